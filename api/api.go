@@ -0,0 +1,292 @@
+// Package api provides the HTTP listeners that expose Pico's internals: the
+// admin listener serves the status of the running instance and the debug
+// listener serves profiling endpoints. Both are opt-in and are never shared
+// with the webhook listener, which is exposed to git hosts.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/state"
+)
+
+// Backend is the running instance that the admin listener reports on
+type Backend interface {
+	Status() Status
+	// Trigger deploys a target's current checkout, immediately or after its
+	// debounce, on behalf of the requester. It returns ErrUnknownTarget if
+	// there's no such target.
+	Trigger(target string, immediate bool, requester string) error
+	// DeployGroup triggers every enabled target of a group, PauseGroup and
+	// ResumeGroup hold and release the deploys of its changes. They return
+	// ErrUnknownGroup if no target belongs to the group.
+	DeployGroup(group string, immediate bool, requester string) error
+	PauseGroup(group string) error
+	ResumeGroup(group string) error
+	// History returns the recent executions of a target, newest first. It
+	// returns ErrUnknownTarget if the target doesn't exist.
+	History(target string) ([]state.Execution, error)
+	// Reinit forgets that a target's init command succeeded and deploys it
+	// immediately, running the init command again before its up command.
+	Reinit(target string, requester string) error
+	// Config returns the effective configuration with secrets redacted
+	Config() []ConfigField
+	// Degraded returns why the instance is running but not fully healthy, such
+	// as stale configuration, or nothing if it is.
+	Degraded() []string
+	// Activate ends the standby of the instance on behalf of the requester and
+	// deploys every target. It returns ErrNotStandby if it isn't in standby.
+	Activate(requester string) error
+	// Approve deploys the commit of a target held pending approval
+	// immediately, on behalf of the approver. It returns ErrUnknownTarget if
+	// there's no such target and ErrNoPendingApproval if nothing is held.
+	Approve(target string, approver string) error
+}
+
+var (
+	// ErrUnknownTarget is returned for operations on targets that don't exist
+	// or are disabled.
+	ErrUnknownTarget = errors.New("unknown or disabled target")
+	// ErrUnknownGroup is returned for operations on groups without targets
+	ErrUnknownGroup = errors.New("unknown group")
+	// ErrAlreadyRunning is returned for operations that can't be done while a
+	// task of the target is waiting to be executed
+	ErrAlreadyRunning = errors.New("a task of the target is already waiting to be executed")
+	// ErrNotStandby is returned when activating an instance that isn't in
+	// standby
+	ErrNotStandby = errors.New("the instance is not in standby")
+	// ErrNoPendingApproval is returned when approving a target without a
+	// commit held pending approval
+	ErrNoPendingApproval = errors.New("no commit of the target is pending approval")
+)
+
+// Server is an HTTP listener
+type Server struct {
+	name     string
+	address  string
+	handler  http.Handler
+	listener net.Listener
+	public   []string // paths served without authentication
+}
+
+// NewAdmin creates the admin listener, serving the dashboard at / and the API
+// below it. Metrics are served if a handler is given.
+func NewAdmin(address string, b Backend, metrics http.Handler) *Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", dashboard(b))
+	// a degraded instance is still serving and deploying, so it's not an error.
+	// Health isn't wrapped in a Response since it's read by probes.
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status, reasons := "ok", b.Degraded()
+		if len(reasons) > 0 {
+			status = "degraded"
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Status  string   `json:"status"`
+			Reasons []string `json:"reasons,omitempty"`
+		}{status, reasons})
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeData(w, http.StatusOK, b.Status())
+	})
+	mux.HandleFunc("/trigger", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost, "trigger requires POST")
+			return
+		}
+		target := r.URL.Query().Get("target")
+		immediate := r.URL.Query().Get("immediate") == "true"
+		if err := b.Trigger(target, immediate, requester(r)); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusAccepted, struct {
+			Target    string `json:"target"`
+			Immediate bool   `json:"immediate"`
+		}{target, immediate})
+	})
+	mux.HandleFunc("/activate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost, "activate requires POST")
+			return
+		}
+		if err := b.Activate(requester(r)); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusAccepted, struct {
+			Standby bool `json:"standby"`
+		}{false})
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		writeData(w, http.StatusOK, b.Config())
+	})
+	// /targets/{name}/history, /targets/{name}/reinit and /targets/{name}/approve
+	mux.HandleFunc("/targets/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/targets/"), "/")
+		if len(parts) != 2 || parts[0] == "" || (parts[1] != "history" && parts[1] != "reinit" && parts[1] != "approve") {
+			writeFailure(w, http.StatusNotFound, CodeNotFound, "no such endpoint")
+			return
+		}
+		if parts[1] == "approve" {
+			if r.Method != http.MethodPost {
+				methodNotAllowed(w, http.MethodPost, "approve requires POST")
+				return
+			}
+			approver := r.URL.Query().Get("approver")
+			if approver == "" {
+				approver = requester(r)
+			}
+			if err := b.Approve(parts[0], approver); err != nil {
+				writeError(w, err)
+				return
+			}
+			writeData(w, http.StatusAccepted, struct {
+				Target   string `json:"target"`
+				Approver string `json:"approver"`
+			}{parts[0], approver})
+			return
+		}
+		if parts[1] == "reinit" {
+			if r.Method != http.MethodPost {
+				methodNotAllowed(w, http.MethodPost, "reinit requires POST")
+				return
+			}
+			if err := b.Reinit(parts[0], requester(r)); err != nil {
+				writeError(w, err)
+				return
+			}
+			writeData(w, http.StatusAccepted, struct {
+				Target string `json:"target"`
+			}{parts[0]})
+			return
+		}
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet, "history requires GET")
+			return
+		}
+		history, err := b.History(parts[0])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		executions := make([]Execution, len(history))
+		for i, e := range history {
+			executions[i] = NewExecution(e)
+		}
+		writeData(w, http.StatusOK, History{Target: parts[0], Executions: executions})
+	})
+	// /groups/{name}/deploy, /groups/{name}/pause and /groups/{name}/resume
+	mux.HandleFunc("/groups/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/groups/"), "/")
+		if len(parts) != 2 || parts[0] == "" {
+			writeFailure(w, http.StatusNotFound, CodeNotFound, "no such endpoint")
+			return
+		}
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost, "group operations require POST")
+			return
+		}
+		group, action := parts[0], parts[1]
+		immediate := r.URL.Query().Get("immediate") == "true"
+
+		var err error
+		switch action {
+		case "deploy":
+			err = b.DeployGroup(group, immediate, requester(r))
+		case "pause":
+			err = b.PauseGroup(group)
+		case "resume":
+			err = b.ResumeGroup(group)
+		default:
+			writeFailure(w, http.StatusNotFound, CodeNotFound, "unknown group operation "+action)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeData(w, http.StatusAccepted, struct {
+			Group  string `json:"group"`
+			Action string `json:"action"`
+		}{group, action})
+	})
+	if metrics != nil {
+		mux.Handle("/metrics", metrics)
+	}
+	return &Server{name: "admin", address: address, handler: mux, public: []string{"/healthz"}}
+}
+
+// Listen binds the listener's address without serving it yet, so the address
+// can be bound before the process gives up the privileges needed to bind it.
+// Run calls it if it hasn't been called already.
+func (s *Server) Listen() error {
+	if s.listener != nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", LocalAddress(s.address))
+	if err != nil {
+		return errors.Wrapf(err, "failed to start %s listener", s.name)
+	}
+	s.listener = l
+	return nil
+}
+
+// Run listens until the context is cancelled
+func (s *Server) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              LocalAddress(s.address),
+		Handler:           s.handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	l := s.listener
+	zap.L().Info("listening", zap.String("listener", s.name), zap.String("address", l.Addr().String()))
+
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown) //nolint:errcheck
+	}()
+
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// LocalAddress binds an address without a host, such as ":8080", to the
+// loopback interface so listeners are never exposed by accident.
+func LocalAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host != "" {
+		return address
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// errorResponse is the error of webhook requests, which aren't versioned like
+// the admin API since only git hosts read them
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v) //nolint:errcheck
+}
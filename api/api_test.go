@@ -0,0 +1,379 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/state"
+)
+
+func TestLocalAddress(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:6060", LocalAddress(":6060"))
+	assert.Equal(t, "0.0.0.0:6060", LocalAddress("0.0.0.0:6060"))
+	assert.Equal(t, "localhost:6060", LocalAddress("localhost:6060"))
+}
+
+type fakeBackend struct {
+	status    Status
+	triggered map[string]bool
+	requested map[string]string // the requester of each trigger
+	groups    map[string]string // the last operation on each group
+}
+
+func (f fakeBackend) Status() Status { return f.status }
+
+func (f fakeBackend) Trigger(target string, immediate bool, requester string) error {
+	if target != "app" {
+		return ErrUnknownTarget
+	}
+	f.triggered[target] = immediate
+	if f.requested != nil {
+		f.requested[target] = requester
+	}
+	return nil
+}
+
+func (f fakeBackend) group(group, action string) error {
+	if group != "apps" {
+		return ErrUnknownGroup
+	}
+	f.groups[group] = action
+	return nil
+}
+
+func (f fakeBackend) DeployGroup(group string, immediate bool, requester string) error {
+	return f.group(group, "deploy")
+}
+
+func (f fakeBackend) History(target string) ([]state.Execution, error) {
+	if target != "app" {
+		return nil, ErrUnknownTarget
+	}
+	return []state.Execution{{Commit: "def456", Previous: "abc123"}}, nil
+}
+
+func (f fakeBackend) Reinit(target string, requester string) error {
+	if target != "app" {
+		return ErrUnknownTarget
+	}
+	if f.triggered != nil {
+		f.triggered["reinit "+target] = true
+	}
+	return nil
+}
+
+func (f fakeBackend) Config() []ConfigField {
+	return []ConfigField{{Name: "VaultToken", Value: "[REDACTED]", Origin: "env"}}
+}
+
+func (f fakeBackend) Degraded() []string {
+	var reasons []string
+	for _, c := range f.status.Config {
+		if c.Stale {
+			reasons = append(reasons, "configuration from "+c.Source+" is stale")
+		}
+	}
+	return reasons
+}
+
+func (f fakeBackend) PauseGroup(group string) error { return f.group(group, "pause") }
+
+func (f fakeBackend) ResumeGroup(group string) error { return f.group(group, "resume") }
+
+func (f fakeBackend) Approve(target string, approver string) error {
+	if target != "app" {
+		return ErrUnknownTarget
+	}
+	if f.requested == nil {
+		return ErrNoPendingApproval
+	}
+	f.requested["approve "+target] = approver
+	return nil
+}
+
+func (f fakeBackend) Activate(requester string) error {
+	if !f.status.Standby {
+		return ErrNotStandby
+	}
+	return nil
+}
+
+func TestAdminStatus(t *testing.T) {
+	s := NewAdmin(":0", fakeBackend{status: Status{
+		Hostname: "host",
+		Targets:  []TargetStatus{{Name: "app", Status: "disabled"}},
+	}}, nil)
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var got Status
+	assert.NoError(t, decodeResponse(rec.Result(), &got))
+	assert.Equal(t, "host", got.Hostname)
+	assert.Equal(t, "disabled", got.Targets[0].Status)
+}
+
+func TestAdminHealthz(t *testing.T) {
+	b := fakeBackend{}
+	s := NewAdmin(":0", b, nil)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+
+	b.status.Config = []ConfigStatus{{Source: "repo", Stale: true}}
+	s = NewAdmin(":0", b, nil)
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"degraded","reasons":["configuration from repo is stale"]}`, rec.Body.String())
+}
+
+func TestAdminTrigger(t *testing.T) {
+	b := fakeBackend{triggered: map[string]bool{}}
+	s := NewAdmin(":0", b, nil)
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trigger?target=app&immediate=true", nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, map[string]bool{"app": true}, b.triggered)
+
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trigger?target=other", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/trigger?target=app", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestAdminTriggerRequester(t *testing.T) {
+	b := fakeBackend{triggered: map[string]bool{}, requested: map[string]string{}}
+	s := NewAdmin(":0", b, nil)
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trigger?target=app", nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "192.0.2.1:1234", b.requested["app"])
+
+	req := httptest.NewRequest(http.MethodPost, "/trigger?target=app", nil)
+	req.SetBasicAuth("alice", "token")
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "alice", b.requested["app"])
+}
+
+func TestAdminGroups(t *testing.T) {
+	b := fakeBackend{groups: map[string]string{}}
+	s := NewAdmin(":0", b, nil)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		want   string
+	}{
+		{http.MethodPost, "/groups/apps/pause", http.StatusAccepted, "pause"},
+		{http.MethodPost, "/groups/apps/resume", http.StatusAccepted, "resume"},
+		{http.MethodPost, "/groups/apps/deploy?immediate=true", http.StatusAccepted, "deploy"},
+		{http.MethodPost, "/groups/other/deploy", http.StatusNotFound, "deploy"},
+		{http.MethodPost, "/groups/apps/restart", http.StatusNotFound, "deploy"},
+		{http.MethodPost, "/groups/apps", http.StatusNotFound, "deploy"},
+		{http.MethodGet, "/groups/apps/pause", http.StatusMethodNotAllowed, "deploy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.code, rec.Code)
+			assert.Equal(t, tt.want, b.groups["apps"])
+		})
+	}
+}
+
+func TestAdminHistory(t *testing.T) {
+	s := NewAdmin(":0", fakeBackend{}, nil)
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/targets/app/history", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var got History
+	assert.NoError(t, decodeResponse(rec.Result(), &got))
+	assert.Equal(t, "app", got.Target)
+	assert.Equal(t, "abc123", got.Executions[0].Previous)
+	assert.Equal(t, "success", got.Executions[0].Status)
+
+	for path, code := range map[string]int{
+		"/targets/other/history": http.StatusNotFound,
+		"/targets/app":           http.StatusNotFound,
+		"/targets/app/logs":      http.StatusNotFound,
+	} {
+		rec = httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, code, rec.Code, path)
+	}
+}
+
+func TestAdminReinit(t *testing.T) {
+	b := fakeBackend{triggered: make(map[string]bool)}
+	s := NewAdmin(":0", b, nil)
+
+	for _, tt := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/targets/app/reinit", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/targets/other/reinit", http.StatusNotFound},
+		{http.MethodPost, "/targets/app/reinit", http.StatusAccepted},
+	} {
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		assert.Equal(t, tt.code, rec.Code, tt.method+" "+tt.path)
+	}
+	assert.True(t, b.triggered["reinit app"])
+}
+
+func TestAdminApprove(t *testing.T) {
+	b := fakeBackend{requested: make(map[string]string)}
+	s := NewAdmin(":0", b, nil)
+
+	for _, tt := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/targets/app/approve", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/targets/other/approve", http.StatusNotFound},
+		{http.MethodPost, "/targets/app/approve?approver=alice", http.StatusAccepted},
+	} {
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		assert.Equal(t, tt.code, rec.Code, tt.method+" "+tt.path)
+	}
+	assert.Equal(t, "alice", b.requested["approve app"])
+
+	req := httptest.NewRequest(http.MethodPost, "/targets/app/approve", nil)
+	req.SetBasicAuth("bob", "")
+	s.handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "bob", b.requested["approve app"], "the requester approves without an approver")
+
+	rec := httptest.NewRecorder()
+	NewAdmin(":0", fakeBackend{}, nil).handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/targets/app/approve", nil))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code": "no_pending_approval"`)
+}
+
+func TestAdminActivate(t *testing.T) {
+	for _, tt := range []struct {
+		method  string
+		standby bool
+		code    int
+	}{
+		{http.MethodGet, true, http.StatusMethodNotAllowed},
+		{http.MethodPost, false, http.StatusConflict},
+		{http.MethodPost, true, http.StatusAccepted},
+	} {
+		s := NewAdmin(":0", fakeBackend{status: Status{Standby: tt.standby}}, nil)
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/activate", nil))
+		assert.Equal(t, tt.code, rec.Code, tt.method)
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewAdmin(":0", fakeBackend{}, nil).handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var got []ConfigField
+	assert.NoError(t, decodeResponse(rec.Result(), &got))
+	assert.Equal(t, []ConfigField{{Name: "VaultToken", Value: "[REDACTED]", Origin: "env"}}, got)
+}
+
+func TestDebugGoroutines(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewDebug(":0").handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/goroutines", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "goroutine")
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(NewAdmin(":0", fakeBackend{status: Status{Hostname: "host"}}, nil).handler)
+	defer srv.Close()
+	c := NewClient(strings.TrimPrefix(srv.URL, "http://"))
+
+	s, err := c.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, "host", s.Hostname)
+
+	h, err := c.History("app")
+	assert.NoError(t, err)
+	assert.Equal(t, "def456", h.Executions[0].Commit)
+
+	_, err = c.History("other")
+	assert.EqualError(t, err, ErrUnknownTarget.Error())
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, CodeTargetNotFound, e.Code)
+	}
+
+	assert.NoError(t, c.Reinit("app"))
+	assert.EqualError(t, c.Reinit("other"), ErrUnknownTarget.Error())
+}
+
+func TestAdminDashboard(t *testing.T) {
+	until := time.Now().Add(time.Minute)
+	s := NewAdmin(":0", fakeBackend{status: Status{
+		Hostname: "host",
+		Leader:   true,
+		Targets: []TargetStatus{
+			{Name: "app", Status: "enabled", Group: "apps", DebounceUntil: &until},
+			{Name: "<db>", Status: "disabled"},
+		},
+		Groups: []GroupStatus{{Name: "apps", Paused: true, Targets: []string{"app"}}},
+	}}, nil)
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `<meta http-equiv="refresh" content="10">`)
+	assert.Contains(t, body, `<td class="success">succeeded</td>`)
+	assert.Contains(t, body, `<code>def456</code>`)
+	assert.Contains(t, body, `<span class="tag">paused</span>`)
+	assert.Contains(t, body, `<span class="tag">debouncing</span>`)
+	assert.Contains(t, body, `<a href="/targets/app/history">history</a>`)
+	assert.Contains(t, body, `<td>&lt;db&gt;</td>`)
+	assert.Contains(t, body, `<td class="none">never run</td>`)
+
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{30 * time.Second, "30s"},
+		{5 * time.Minute, "5m"},
+		{3*time.Hour + 20*time.Minute, "3h"},
+		{50 * time.Hour, "2d"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, formatAge(tt.d))
+	}
+}
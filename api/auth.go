@@ -0,0 +1,71 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RequireToken makes every route of the listener, other than its public ones
+// such as /healthz, require the token. It's accepted as a bearer token or as
+// the basic auth password, with any user name, so browsers can prompt for it.
+// An empty token leaves the listener open. It must be called before Run.
+func (s *Server) RequireToken(token string) {
+	if token == "" {
+		return
+	}
+	next := s.handler
+	public := s.public
+	s.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range public {
+			if r.URL.Path == p {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if !authorised(r, token) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="pico"`)
+			writeFailure(w, http.StatusUnauthorized, CodeUnauthorized, "a valid token is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorised compares the request's credentials in constant time
+func authorised(r *http.Request, token string) bool {
+	var given string
+	if _, pass, ok := r.BasicAuth(); ok {
+		given = pass
+	} else if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		given = strings.TrimPrefix(h, "Bearer ")
+	} else {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// requester identifies who made a request for the record of what triggered a
+// deploy: the basic auth user name if one was given, otherwise the address the
+// request came from.
+func requester(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	return r.RemoteAddr
+}
+
+// IsLoopback reports whether a listener address is only reachable from the
+// local machine, addresses without a host bind to the loopback interface.
+func IsLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(LocalAddress(address))
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireToken(t *testing.T) {
+	s := NewAdmin(":0", fakeBackend{}, nil)
+	s.RequireToken("s3cret")
+
+	tests := []struct {
+		name string
+		path string
+		auth func(r *http.Request)
+		code int
+	}{
+		{"no credentials", "/config", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"bearer", "/config", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusOK},
+		{"wrong bearer", "/config", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"basic", "/config", func(r *http.Request) { r.SetBasicAuth("admin", "s3cret") }, http.StatusOK},
+		{"wrong basic", "/config", func(r *http.Request) { r.SetBasicAuth("admin", "nope") }, http.StatusUnauthorized},
+		{"other scheme", "/config", func(r *http.Request) { r.Header.Set("Authorization", "token s3cret") }, http.StatusUnauthorized},
+		{"dashboard", "/", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"health", "/healthz", func(r *http.Request) {}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			tt.auth(req)
+			rec := httptest.NewRecorder()
+			s.handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.code == http.StatusUnauthorized {
+				assert.JSONEq(t, `{"version":1,"error":{"code":"unauthorized","message":"a valid token is required"}}`, rec.Body.String())
+				assert.Equal(t, `Basic realm="pico"`, rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestRequireTokenEmpty(t *testing.T) {
+	s := NewAdmin(":0", fakeBackend{}, nil)
+	s.RequireToken("")
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestClientToken(t *testing.T) {
+	s := NewAdmin(":0", fakeBackend{status: Status{Hostname: "host"}}, nil)
+	s.RequireToken("s3cret")
+	srv := httptest.NewServer(s.handler)
+	defer srv.Close()
+
+	c := NewClient(srv.Listener.Addr().String())
+	_, err := c.Status()
+	assert.Error(t, err)
+
+	c.SetToken("s3cret")
+	status, err := c.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, "host", status.Hostname)
+}
+
+func TestIsLoopback(t *testing.T) {
+	assert.True(t, IsLoopback(":8080"))
+	assert.True(t, IsLoopback("127.0.0.1:8080"))
+	assert.True(t, IsLoopback("localhost:8080"))
+	assert.True(t, IsLoopback("[::1]:8080"))
+	assert.False(t, IsLoopback("0.0.0.0:8080"))
+	assert.False(t, IsLoopback("10.0.0.5:8080"))
+	assert.False(t, IsLoopback("pico.example.com:8080"))
+}
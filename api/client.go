@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Client queries the admin listener of a running instance
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// NewClient creates a client for the admin listener at address, an address
+// without a host refers to the loopback interface as it does for the listener.
+func NewClient(address string) *Client {
+	return &Client{
+		base: "http://" + LocalAddress(address),
+		http: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetToken sets the bearer token sent to an admin listener that requires one
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Status returns the status of the instance
+func (c *Client) Status() (s Status, err error) {
+	err = c.get("/status", &s)
+	return
+}
+
+// Config returns the effective configuration of the instance, with secrets
+// redacted
+func (c *Client) Config() (fields []ConfigField, err error) {
+	err = c.get("/config", &fields)
+	return
+}
+
+// History returns the recent executions of the named target, newest first
+func (c *Client) History(target string) (h History, err error) {
+	err = c.get("/targets/"+url.PathEscape(target)+"/history", &h)
+	return
+}
+
+// Reinit makes the named target run its init command again, before its next
+// up command, and deploys it immediately.
+func (c *Client) Reinit(target string) error {
+	return c.do(http.MethodPost, "/targets/"+url.PathEscape(target)+"/reinit", &struct{}{})
+}
+
+// Approve deploys the commit of the named target held pending approval, on
+// behalf of the approver or the client's user if it's empty.
+func (c *Client) Approve(target, approver string) error {
+	path := "/targets/" + url.PathEscape(target) + "/approve"
+	if approver != "" {
+		path += "?approver=" + url.QueryEscape(approver)
+	}
+	return c.do(http.MethodPost, path, &struct{}{})
+}
+
+// Activate ends the standby of the instance, which then deploys every target
+func (c *Client) Activate() error {
+	return c.do(http.MethodPost, "/activate", &struct{}{})
+}
+
+func (c *Client) get(path string, v interface{}) error {
+	return c.do(http.MethodGet, path, v)
+}
+
+func (c *Client) do(method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to reach admin listener")
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, v)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+// update rewrites the golden files with the current responses, for changes to
+// responses that are meant to be made: go test ./api -run Contract -update
+var update = flag.Bool("update", false, "rewrite the golden files of the admin API")
+
+// TestContract compares responses of the admin API for representative states
+// with the golden files in testdata, so renamed or removed fields are caught.
+func TestContract(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	later := at.Add(time.Minute)
+	enabled := true
+	status := Status{
+		Build:         buildinfo.Info{Version: "1.2.3", Commit: "abc1234", Date: "2020-01-01"},
+		Hostname:      "host",
+		Leader:        true,
+		LastError:     "worker: exit status 1",
+		DataSize:      4096,
+		CheckoutSizes: map[string]int64{task.CheckoutFull: 4096},
+		Targets: []TargetStatus{
+			{
+				Name:             "app",
+				Status:           "rate limited until 2020-01-02T03:05:05Z",
+				State:            StatePendingWindow,
+				Group:            "apps",
+				Source:           "https://github.com/org/config",
+				Commit:           "def4567890",
+				Path:             "/data/app",
+				RateLimitedUntil: &later,
+				CloneSize:        4096,
+				Images:           []state.Image{{Container: "app_web_1", Image: "nginx:1.19", Digest: "sha256:abc"}},
+				Fetch:            &FetchStatus{LastCheck: at, LastSuccess: &at, ConsecutiveFailures: 0},
+				Definition:       task.Target{Name: "app", RepoURL: "https://github.com/org/app", Up: []string{"docker-compose", "up", "-d"}, Enabled: &enabled},
+			},
+			{
+				Name:           "worker",
+				Status:         "blocked: missing secrets API_KEY",
+				State:          StateBlocked,
+				MissingSecrets: []string{"API_KEY"},
+				Waiting:        &WaitingStatus{Since: at, Mutex: "db"},
+				Definition:     task.Target{Name: "worker", RepoURL: "https://github.com/org/worker", Up: []string{"./deploy.sh"}},
+			},
+		},
+		Groups: []GroupStatus{{Name: "apps", Paused: false, Targets: []string{"app"}}},
+		Config: []ConfigStatus{{
+			Source:    "https://github.com/org/config",
+			Branch:    "main",
+			Path:      "/data/config",
+			Applied:   "0123456789",
+			Checked:   &at,
+			Updated:   &at,
+			UpdatedBy: "webhook",
+		}},
+		Runtime: RuntimeStats{Goroutines: 10, HeapInUse: 1024, HeapObjects: 16},
+	}
+	b := fakeBackend{status: status, triggered: map[string]bool{}}
+
+	tests := []struct {
+		golden string
+		method string
+		path   string
+		token  bool
+		code   int
+	}{
+		{"status", http.MethodGet, "/status", false, http.StatusOK},
+		{"history", http.MethodGet, "/targets/app/history", false, http.StatusOK},
+		{"trigger", http.MethodPost, "/trigger?target=app", false, http.StatusAccepted},
+		{"target_not_found", http.MethodPost, "/trigger?target=other", false, http.StatusNotFound},
+		{"method_not_allowed", http.MethodGet, "/targets/app/reinit", false, http.StatusMethodNotAllowed},
+		{"unauthorized", http.MethodGet, "/status", true, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			s := NewAdmin(":0", b, nil)
+			if tt.token {
+				s.RequireToken("s3cret")
+			}
+			rec := httptest.NewRecorder()
+			s.handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.code, rec.Code)
+
+			path := filepath.Join("testdata", tt.golden+".json")
+			if *update {
+				require.NoError(t, ioutil.WriteFile(path, rec.Body.Bytes(), 0o644))
+			}
+			want, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(want), rec.Body.String())
+		})
+	}
+}
+
+func TestNewExecution(t *testing.T) {
+	assert.Equal(t, task.ResultSuccess, NewExecution(state.Execution{Shutdown: true}).Status)
+	assert.Equal(t, task.ResultNoop, NewExecution(state.Execution{Noop: true}).Status)
+	assert.Equal(t, task.ResultFailure, NewExecution(state.Execution{Error: "exit status 1"}).Status)
+}
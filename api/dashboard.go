@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/state"
+)
+
+// dashboardRefresh is how often the dashboard page reloads itself
+const dashboardRefresh = 10 * time.Second
+
+// dashboardRow is a target as shown on the dashboard
+type dashboardRow struct {
+	TargetStatus
+	Last    *state.Execution // the target's latest execution, if any
+	Paused  bool             // the target's group is paused
+	Pending string           // why a detected change hasn't been deployed yet
+}
+
+type dashboardView struct {
+	Status  Status
+	Rows    []dashboardRow
+	Now     time.Time
+	Refresh int // seconds
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"short": func(commit string) string {
+		if len(commit) > 7 {
+			return commit[:7]
+		}
+		return commit
+	},
+	"age": func(now, t time.Time) string {
+		return formatAge(now.Sub(t))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Pico {{.Status.Hostname}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.4em 0.8em; border-bottom: 1px solid #ddd; }
+.success { color: #1a7f37; }
+.failure { color: #cf222e; }
+.none, .muted { color: #777; }
+.tag { font-size: 0.8em; padding: 0.1em 0.4em; border-radius: 0.3em; background: #eee; }
+.error { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>Pico on {{.Status.Hostname}}</h1>
+<p class="muted">{{.Status.Build.Version}}{{if .Status.Standby}} &middot; standby, tasks are not executed until activated{{else if not .Status.Leader}} &middot; not the leader, tasks are not executed{{end}}</p>
+{{if .Status.LastError}}<p class="error">Last error: {{.Status.LastError}}</p>{{end}}
+{{range .Status.Config}}{{if .Error}}<p class="error">Configuration {{.Source}} is invalid: {{.Error}}</p>{{end}}{{if .Stale}}<p class="error">Configuration {{.Source}} is stale, its repository is unreachable</p>{{end}}{{end}}
+<table>
+<tr><th>Target</th><th>Group</th><th>Status</th><th>Last result</th><th>Commit</th><th>History</th></tr>
+{{range .Rows}}<tr>
+<td>{{.Name}}</td>
+<td>{{.Group}}{{if .Paused}} <span class="tag">paused</span>{{end}}</td>
+<td>{{.Status}}{{if .Pending}} <span class="tag">{{.Pending}}</span>{{end}}</td>
+{{with .Last}}{{if .Error}}<td class="failure" title="{{.Error}}">failed</td>{{else}}<td class="success">{{if .Shutdown}}shut down{{else}}succeeded{{end}}</td>{{end}}
+<td><code>{{short .Commit}}</code> <span class="muted">{{age $.Now .Finished}} ago</span></td>{{else}}<td class="none">never run</td>
+<td><code>{{short .Commit}}</code></td>{{end}}
+<td><a href="/targets/{{.Name}}/history">history</a></td>
+</tr>
+{{else}}<tr><td colspan="6" class="none">No targets are configured.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// dashboard renders an HTML page of the targets' status, it has no scripts and
+// reloads itself periodically.
+func dashboard(b Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			writeFailure(w, http.StatusNotFound, CodeNotFound, "no such endpoint")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, "dashboard requires GET")
+			return
+		}
+
+		status := b.Status()
+		view := dashboardView{
+			Status:  status,
+			Now:     time.Now(),
+			Refresh: int(dashboardRefresh / time.Second),
+		}
+		paused := make(map[string]bool)
+		for _, g := range status.Groups {
+			paused[g.Name] = g.Paused
+		}
+		for _, t := range status.Targets {
+			row := dashboardRow{TargetStatus: t, Paused: paused[t.Group]}
+			if history, err := b.History(t.Name); err == nil && len(history) > 0 {
+				row.Last = &history[0]
+			}
+			switch {
+			case t.Waiting != nil:
+				row.Pending = "queued"
+			case t.DebounceUntil != nil:
+				row.Pending = "debouncing"
+			case t.RateLimitedUntil != nil:
+				row.Pending = "rate limited"
+			}
+			view.Rows = append(view.Rows, row)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(w, view); err != nil {
+			zap.L().Warn("failed to render dashboard", zap.Error(err))
+		}
+	}
+}
+
+// formatAge writes a duration as its largest unit, such as 5m or 3d
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d/time.Second))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// NewDebug creates the debug listener serving the standard pprof handlers and
+// a plain text dump of all goroutine stacks.
+func NewDebug(address string) *Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 1<<20)
+		for {
+			n := runtime.Stack(buf, true)
+			if n < len(buf) {
+				buf = buf[:n]
+				break
+			}
+			buf = make([]byte, len(buf)*2)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf) //nolint:errcheck
+	})
+	return &Server{name: "debug", address: address, handler: mux}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Version is the version of the admin API's responses. It's increased whenever
+// a field is removed or renamed or its meaning changes, fields may be added to
+// a version.
+const Version = 1
+
+// Response wraps every response of the admin API other than /healthz, Data is
+// set if the request succeeded and Error if it failed.
+type Response struct {
+	Version int         `json:"version"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   *Error      `json:"error,omitempty"`
+}
+
+// ErrorCode identifies why an admin API request failed, unlike the message it
+// never changes within a version.
+type ErrorCode string
+
+// The codes of failed admin API requests
+const (
+	CodeTargetNotFound   ErrorCode = "target_not_found"
+	CodeGroupNotFound    ErrorCode = "group_not_found"
+	CodeAlreadyRunning   ErrorCode = "already_running"
+	CodeNotStandby       ErrorCode = "not_standby"
+	CodeNoPending        ErrorCode = "no_pending_approval"
+	CodeUnauthorized     ErrorCode = "unauthorized"
+	CodeNotFound         ErrorCode = "not_found" // no such endpoint
+	CodeMethodNotAllowed ErrorCode = "method_not_allowed"
+	CodeInternal         ErrorCode = "internal"
+)
+
+// Error is a failed admin API request, it's returned by the Client
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// writeData responds with the version and the data of a successful request
+func writeData(w http.ResponseWriter, status int, v interface{}) {
+	writeJSON(w, status, Response{Version: Version, Data: v})
+}
+
+// writeFailure responds with the version and the error of a failed request
+func writeFailure(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	writeJSON(w, status, Response{Version: Version, Error: &Error{code, message}})
+}
+
+// writeError responds with the code of the error returned by the backend,
+// internal for errors the backend doesn't describe.
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownTarget):
+		writeFailure(w, http.StatusNotFound, CodeTargetNotFound, err.Error())
+	case errors.Is(err, ErrUnknownGroup):
+		writeFailure(w, http.StatusNotFound, CodeGroupNotFound, err.Error())
+	case errors.Is(err, ErrAlreadyRunning):
+		writeFailure(w, http.StatusConflict, CodeAlreadyRunning, err.Error())
+	case errors.Is(err, ErrNotStandby):
+		writeFailure(w, http.StatusConflict, CodeNotStandby, err.Error())
+	case errors.Is(err, ErrNoPendingApproval):
+		writeFailure(w, http.StatusConflict, CodeNoPending, err.Error())
+	default:
+		writeFailure(w, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+}
+
+// methodNotAllowed responds to a request with the wrong method
+func methodNotAllowed(w http.ResponseWriter, allow, message string) {
+	w.Header().Set("Allow", allow)
+	writeFailure(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, message)
+}
+
+// decodeResponse decodes the data of a response into v, or returns the error
+// of a failed request. Responses without an error, such as from a proxy in
+// front of the listener, are described by their HTTP status.
+func decodeResponse(resp *http.Response, v interface{}) error {
+	var r struct {
+		Version int             `json:"version"`
+		Data    json.RawMessage `json:"data"`
+		Error   *Error          `json:"error"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&r)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		if err != nil || r.Error == nil {
+			return &Error{Code: CodeInternal, Message: resp.Status}
+		}
+		return r.Error
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to decode response")
+	}
+	if r.Version != Version {
+		return errors.Errorf("response version %d is not supported, this client supports version %d", r.Version, Version)
+	}
+	return errors.Wrap(json.Unmarshal(r.Data, v), "failed to decode response")
+}
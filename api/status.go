@@ -0,0 +1,203 @@
+package api
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+// Status is the payload served by the admin listener's /status endpoint
+type Status struct {
+	Build     buildinfo.Info `json:"build"`
+	Hostname  string         `json:"hostname"`
+	Leader    bool           `json:"leader"`
+	Standby   bool           `json:"standby"` // tasks are recorded as suppressed rather than executed
+	LastError string         `json:"last_error,omitempty"`
+	DataSize  int64          `json:"data_size_bytes,omitempty"`
+	DataFree  int64          `json:"data_free_bytes,omitempty"` // free space of the data directory's filesystem
+	Targets   []TargetStatus `json:"targets"`
+	Groups    []GroupStatus  `json:"groups,omitempty"`
+	Config    []ConfigStatus `json:"config"`
+	Runtime   RuntimeStats   `json:"runtime"`
+
+	// CheckoutSizes is the disk usage of the clones of each checkout mode
+	CheckoutSizes map[string]int64 `json:"checkout_size_bytes,omitempty"`
+}
+
+// TargetStatus describes a single target and where it came from
+type TargetStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // "enabled", "disabled", "waiting", "waiting on mutex ...", "blocked: missing secrets ...", "blocked: low disk", "rate limited until ..." or "pending approval of ..."
+	State  string `json:"state"`  // one of the State constants, for scripts rather than people
+	Group  string `json:"group,omitempty"`
+	Source string `json:"source,omitempty"`
+	Commit string `json:"commit,omitempty"` // the last applied commit
+	Path   string `json:"path,omitempty"`   // where the target is cloned
+	// StaleSecrets is when the secrets the target last ran with were fetched,
+	// if the secret store was unavailable and cached secrets were used.
+	StaleSecrets *time.Time `json:"stale_secrets,omitempty"`
+	// MissingSecrets are the required secrets that were missing when the
+	// target's last task was run, it wasn't run because of them.
+	MissingSecrets []string `json:"missing_secrets,omitempty"`
+	// DebounceUntil is when a detected change will be deployed, if the target
+	// is waiting for its debounce window to end.
+	DebounceUntil *time.Time `json:"debounce_until,omitempty"`
+	// RateLimitedUntil is when a detected change will be deployed, if it was
+	// detected too soon after the target's last deploy.
+	RateLimitedUntil *time.Time `json:"rate_limited_until,omitempty"`
+	// PendingApproval is the commit held until the target is triggered, since
+	// its author or committer isn't allowed to deploy it automatically.
+	PendingApproval string `json:"pending_approval,omitempty"`
+	// Approval describes the commit pending approval and what it changes
+	// since the last applied commit.
+	Approval *ApprovalStatus `json:"approval,omitempty"`
+	// Quarantined is set while the target's tasks at a commit are skipped
+	// since they panicked repeatedly.
+	Quarantined *QuarantineStatus `json:"quarantined,omitempty"`
+	// LowDisk is set while the target's clone, fetches or tasks are skipped
+	// since the data directory's disk is below the minimum free space.
+	LowDisk bool `json:"low_disk,omitempty"`
+	// Images are the images and digests running in the target's compose
+	// project after its last successful deploy.
+	Images []state.Image `json:"images,omitempty"`
+	// Waiting is set while a task for the target is waiting to be executed
+	Waiting    *WaitingStatus `json:"waiting,omitempty"`
+	CloneSize  int64          `json:"clone_size_bytes,omitempty"`
+	Fetch      *FetchStatus   `json:"fetch,omitempty"` // unset until the target is first checked
+	Definition task.Target    `json:"definition"`      // the effective definition, with defaults applied
+}
+
+// ApprovalStatus describes a commit held pending approval
+type ApprovalStatus struct {
+	Commit     string `json:"commit"`
+	Author     string `json:"author"`
+	Files      int    `json:"files_changed"`
+	Insertions int    `json:"insertions"`
+	Deletions  int    `json:"deletions"`
+}
+
+// QuarantineStatus describes the commit of a quarantined target
+type QuarantineStatus struct {
+	Commit string    `json:"commit"`
+	Panics int       `json:"panics"`
+	Panic  string    `json:"panic"` // the value of the last panic
+	Since  time.Time `json:"since"`
+}
+
+// The states of targets, a target is in the first of them that applies
+const (
+	StateDisabled        = "disabled"
+	StateQuarantined     = "quarantined"
+	StateBlocked         = "blocked" // missing required secrets or low disk space
+	StatePendingApproval = "pending_approval"
+	StatePaused          = "paused"         // its group is paused
+	StateWaiting         = "waiting"        // a task is waiting to be executed
+	StatePendingWindow   = "pending_window" // a change waits for its debounce or rate limit
+	StateFailing         = "failing"        // the last fetch or task failed
+	StateStaleConfig     = "stale_config"   // the configuration it's from is stale
+	StateOK              = "ok"
+)
+
+// FetchStatus describes the recent fetches of a target's repository. The error
+// of the last failed fetch is cleared by the next successful one.
+type FetchStatus struct {
+	LastCheck           time.Time  `json:"last_check"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// WaitingStatus describes a task that's waiting to be executed, Mutex is the
+// target's mutex, if it has one, which other tasks may be holding.
+type WaitingStatus struct {
+	Since time.Time `json:"since"`
+	Mutex string    `json:"mutex,omitempty"`
+}
+
+// ConfigField is a field of the effective configuration served by /config,
+// with secrets redacted. Origin is "flag", "env", "default", "secret store" or
+// "derived" for values computed by Pico.
+type ConfigField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Origin string `json:"origin"`
+}
+
+// GroupStatus describes a group of targets
+type GroupStatus struct {
+	Name    string   `json:"name"`
+	Paused  bool     `json:"paused"`
+	Targets []string `json:"targets"`
+}
+
+// History is the payload served by /targets/{name}/history
+type History struct {
+	Target     string      `json:"target"`
+	Executions []Execution `json:"executions"` // newest first
+}
+
+// Execution is an executed task of a target with its result, one of
+// task.ResultSuccess, ResultFailure or ResultNoop.
+type Execution struct {
+	state.Execution
+	Status string `json:"status"`
+}
+
+// NewExecution labels an execution with its result
+func NewExecution(e state.Execution) Execution {
+	status := task.ResultSuccess
+	switch {
+	case e.Error != "":
+		status = task.ResultFailure
+	case e.Noop:
+		status = task.ResultNoop
+	case e.Suppressed != "":
+		status = task.ResultSuppressed
+	}
+	return Execution{e, status}
+}
+
+// ConfigStatus describes a configuration source
+type ConfigStatus struct {
+	Source  string `json:"source"`
+	Branch  string `json:"branch,omitempty"`
+	Path    string `json:"path,omitempty"`   // where the repository is checked out
+	Applied string `json:"commit,omitempty"` // the commit the current state is from
+	Error   string `json:"error,omitempty"`  // set while the latest revision is invalid
+	Commit  string `json:"invalid_commit,omitempty"`
+	File    string `json:"invalid_file,omitempty"`
+	// Checked is when the repository was last checked successfully, Stale is
+	// set once it's been unreachable for longer than the stale threshold.
+	Checked *time.Time `json:"last_contact,omitempty"`
+	Stale   bool       `json:"stale,omitempty"`
+	// Updated is when the configuration last changed the targets, UpdatedBy
+	// the check that found the change: startup, poll or webhook.
+	Updated   *time.Time `json:"updated,omitempty"`
+	UpdatedBy string     `json:"updated_by,omitempty"`
+
+	// AppliedAuthor and AppliedTime are the author of the applied commit and
+	// when it was committed.
+	AppliedAuthor string     `json:"author,omitempty"`
+	AppliedTime   *time.Time `json:"committed,omitempty"`
+}
+
+// RuntimeStats are basic statistics of the Go runtime
+type RuntimeStats struct {
+	Goroutines  int    `json:"goroutines"`
+	HeapInUse   uint64 `json:"heap_in_use"`
+	HeapObjects uint64 `json:"heap_objects"`
+}
+
+// ReadRuntimeStats samples the current runtime statistics
+func ReadRuntimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return RuntimeStats{
+		Goroutines:  runtime.NumGoroutine(),
+		HeapInUse:   m.HeapInuse,
+		HeapObjects: m.HeapObjects,
+	}
+}
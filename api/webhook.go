@@ -0,0 +1,140 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// maxWebhookBody bounds the size of webhook payloads read into memory
+const maxWebhookBody = 1 << 20
+
+// Push describes the refs of a repository that changed, as reported by a git
+// host. A repository is identified by its clone URLs, or by its Name, such as
+// `project/repo`, matched against the end of repository paths if the host
+// doesn't send them.
+type Push struct {
+	URLs     []string
+	Name     string
+	Refs     []string // full ref names, such as refs/heads/main
+	Delivery string   // the host's ID of the webhook request, if it sends one
+}
+
+// Checker is told about pushes received by the webhook listener
+type Checker interface {
+	// Check makes the targets and configuration repositories that track one
+	// of the pushed refs check for changes now, it returns how many did.
+	Check(push Push) int
+}
+
+// NewWebhook creates the webhook listener that git hosts send pushes to. Push
+// payloads must be signed with the secret.
+func NewWebhook(address string, c Checker, secret string) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/webhook/bitbucket", bitbucketHandler(c, secret))
+	return &Server{name: "webhook", address: address, handler: mux}
+}
+
+// bitbucketEvent is the part of a Bitbucket Server or Data Center webhook
+// payload used to find the repository and refs that changed.
+type bitbucketEvent struct {
+	EventKey   string `json:"eventKey"`
+	Repository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+		Links struct {
+			Clone []struct {
+				Href string `json:"href"`
+			} `json:"clone"`
+		} `json:"links"`
+	} `json:"repository"`
+	Changes []struct {
+		RefID string `json:"refId"`
+		Ref   struct {
+			ID string `json:"id"`
+		} `json:"ref"`
+	} `json:"changes"`
+}
+
+// bitbucketHandler handles `repo:refs_changed` events from Bitbucket Server
+// and Data Center, signed with an HMAC-SHA256 `X-Hub-Signature` header. Other
+// events, such as the test connection ping, are acknowledged and ignored.
+func bitbucketHandler(c Checker, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"webhooks require POST"})
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"failed to read payload"})
+			return
+		}
+		if !validSignature(secret, body, r.Header.Get("X-Hub-Signature")) {
+			zap.L().Warn("rejected webhook with invalid signature",
+				zap.String("remote", r.RemoteAddr))
+			writeJSON(w, http.StatusUnauthorized, errorResponse{"invalid signature"})
+			return
+		}
+
+		var e bitbucketEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"invalid payload"})
+			return
+		}
+		if e.EventKey != "repo:refs_changed" {
+			zap.L().Debug("ignoring bitbucket event", zap.String("event", e.EventKey))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		push := Push{Delivery: r.Header.Get("X-Request-Id")}
+		for _, l := range e.Repository.Links.Clone {
+			push.URLs = append(push.URLs, l.Href)
+		}
+		if len(push.URLs) == 0 && e.Repository.Project.Key != "" && e.Repository.Slug != "" {
+			push.Name = e.Repository.Project.Key + "/" + e.Repository.Slug
+		}
+		for _, ch := range e.Changes {
+			ref := ch.RefID
+			if ref == "" {
+				ref = ch.Ref.ID
+			}
+			if ref != "" {
+				push.Refs = append(push.Refs, ref)
+			}
+		}
+
+		checked := c.Check(push)
+		zap.L().Debug("received bitbucket push",
+			zap.Strings("urls", push.URLs),
+			zap.String("name", push.Name),
+			zap.Strings("refs", push.Refs),
+			zap.String("delivery", push.Delivery),
+			zap.Int("checked", checked))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// validSignature checks a `sha256=<hex>` HMAC of the body
+func validSignature(secret string, body []byte, signature string) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body) //nolint:errcheck
+	return hmac.Equal(got, mac.Sum(nil))
+}
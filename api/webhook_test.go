@@ -0,0 +1,94 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeChecker struct {
+	pushes []Push
+}
+
+func (f *fakeChecker) Check(push Push) int {
+	f.pushes = append(f.pushes, push)
+	return 1
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body)) //nolint:errcheck
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestBitbucketWebhook(t *testing.T) {
+	const refsChanged = `{
+		"eventKey": "repo:refs_changed",
+		"repository": {
+			"slug": "app",
+			"project": {"key": "OPS"},
+			"links": {"clone": [
+				{"href": "ssh://git@bitbucket.example.com:7999/ops/app.git", "name": "ssh"},
+				{"href": "https://bitbucket.example.com/scm/ops/app.git", "name": "http"}
+			]}
+		},
+		"changes": [{"ref": {"id": "refs/heads/main"}, "refId": "refs/heads/main", "type": "UPDATE"}]
+	}`
+	const withoutLinks = `{
+		"eventKey": "repo:refs_changed",
+		"repository": {"slug": "app", "project": {"key": "OPS"}},
+		"changes": [{"ref": {"id": "refs/heads/dev"}}]
+	}`
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		signature  string
+		wantStatus int
+		wantPush   *Push
+	}{
+		{"refs changed", http.MethodPost, refsChanged, sign("secret", refsChanged), http.StatusNoContent, &Push{
+			URLs:     []string{"ssh://git@bitbucket.example.com:7999/ops/app.git", "https://bitbucket.example.com/scm/ops/app.git"},
+			Refs:     []string{"refs/heads/main"},
+			Delivery: "delivery-1",
+		}},
+		{"without clone links", http.MethodPost, withoutLinks, sign("secret", withoutLinks), http.StatusNoContent, &Push{
+			Name:     "OPS/app",
+			Refs:     []string{"refs/heads/dev"},
+			Delivery: "delivery-1",
+		}},
+		{"ping", http.MethodPost, `{"eventKey":"diagnostics:ping"}`, sign("secret", `{"eventKey":"diagnostics:ping"}`), http.StatusNoContent, nil},
+		{"wrong secret", http.MethodPost, refsChanged, sign("other", refsChanged), http.StatusUnauthorized, nil},
+		{"unsigned", http.MethodPost, refsChanged, "", http.StatusUnauthorized, nil},
+		{"invalid json", http.MethodPost, "{", sign("secret", "{"), http.StatusBadRequest, nil},
+		{"get", http.MethodGet, "", "", http.StatusMethodNotAllowed, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeChecker{}
+			srv := NewWebhook(":0", c, "secret")
+
+			req := httptest.NewRequest(tt.method, "/webhook/bitbucket", strings.NewReader(tt.body))
+			req.Header.Set("X-Request-Id", "delivery-1")
+			if tt.signature != "" {
+				req.Header.Set("X-Hub-Signature", tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			srv.handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantPush == nil {
+				assert.Empty(t, c.pushes)
+				return
+			}
+			assert.Equal(t, []Push{*tt.wantPush}, c.pushes)
+		})
+	}
+}
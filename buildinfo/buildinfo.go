@@ -0,0 +1,80 @@
+// Package buildinfo describes the build of the running binary. The values are
+// set at link time via ldflags, for example:
+//
+//	-X github.com/picostack/pico/buildinfo.Version=v1.2.3
+//	-X github.com/picostack/pico/buildinfo.Commit=abc123
+//	-X github.com/picostack/pico/buildinfo.Date=2020-01-01T00:00:00Z
+//
+// When built without ldflags, such as with `go get`, the module version from
+// the binary's embedded build information is used instead.
+package buildinfo
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// Set via ldflags
+var (
+	Version = ""
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the build information of the running binary
+type Info struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	i := Info{Version: Version, Commit: Commit, Date: Date}
+	if i.Version == "" {
+		i.Version = "master"
+		if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			i.Version = bi.Main.Version
+		}
+	}
+	return i
+}
+
+func (i Info) String() string {
+	s := i.Version
+	if i.Commit != "" {
+		s += " (" + i.Commit + ")"
+	}
+	if i.Date != "" {
+		s += " built " + i.Date
+	}
+	return s
+}
+
+// UserAgent returns the User-Agent header value used for outbound requests
+func UserAgent() string {
+	i := Get()
+	if i.Commit != "" {
+		return fmt.Sprintf("pico/%s (%s)", i.Version, i.Commit)
+	}
+	return fmt.Sprintf("pico/%s", i.Version)
+}
+
+// Transport wraps a RoundTripper so every request carries Pico's User-Agent
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return userAgentTransport{rt}
+}
+
+type userAgentTransport struct {
+	next http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("User-Agent", UserAgent())
+	return t.next.RoundTrip(r)
+}
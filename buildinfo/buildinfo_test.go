@@ -0,0 +1,31 @@
+package buildinfo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	Version, Commit, Date = "v1.2.3", "abc123", "2020-01-01"
+	defer func() { Version, Commit, Date = "", "", "" }()
+
+	assert.Equal(t, Info{"v1.2.3", "abc123", "2020-01-01"}, Get())
+	assert.Equal(t, "v1.2.3 (abc123) built 2020-01-01", Get().String())
+	assert.Equal(t, "pico/v1.2.3 (abc123)", UserAgent())
+}
+
+func TestTransport(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.UserAgent()
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: Transport(nil)}
+	_, err := c.Get(srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, UserAgent(), got)
+}
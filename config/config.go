@@ -4,10 +4,17 @@
 // reconfigurer for resolving state changes. JavaScript is used so certain
 // common expressions can be re-used, or targets can be conditionally resolved
 // based on input variables such as the machine's hostname.
+//
+// Scripts can read a small set of read-only builtins: HOSTNAME, VERSION and
+// ENV, which only contains allowlisted environment variables. Configuration is
+// re-evaluated on every check, not only when the repository changes, so scripts
+// should be deterministic for a given set of builtins and must not rely on
+// being evaluated once.
 package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -24,26 +31,69 @@ type State struct {
 	Targets     task.Targets      `json:"targets"`
 	AuthMethods []AuthMethod      `json:"auths"`
 	Env         map[string]string `json:"env"`
+	Notifiers   []Notifier        `json:"notifiers,omitempty"`
+}
+
+// Types of notifiers that can be declared
+const (
+	NotifierDiscord = "discord"
+	NotifierWebhook = "webhook"
+	NotifierCommand = "command"
+)
+
+// Notifier is a notifier declared once with N() that targets and groups route
+// their notifications to by name, so its URL isn't repeated for each of them.
+type Notifier struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`              // one of the Notifier constants
+	URL     string `json:"url,omitempty"`     // the URL of discord and webhook notifiers
+	Command string `json:"command,omitempty"` // the shell command of command notifiers
+}
+
+// Group sets what applies to every target of a group, declared with G()
+type Group struct {
+	Name          string              `json:"name"`
+	Notifications *task.Notifications `json:"notifications,omitempty"`
 }
 
 // AuthMethod represents a method of authentication for a target
 type AuthMethod struct {
-	Name    string `json:"name"`     // name of the auth method
-	Path    string `json:"path"`     // path within the secret store
-	UserKey string `json:"user_key"` // key for username
-	PassKey string `json:"pass_key"` // key for password
+	Name     string `json:"name"`                // name of the auth method
+	Type     string `json:"type,omitempty"`      // "basic" or "token", detected when empty
+	Path     string `json:"path"`                // path within the secret store
+	UserKey  string `json:"user_key"`            // key for username
+	PassKey  string `json:"pass_key"`            // key for password
+	TokenKey string `json:"token_key,omitempty"` // key for a personal access token
+}
+
+// Builtins are the read-only values exposed to configuration scripts
+type Builtins struct {
+	Hostname string   // exposed as HOSTNAME
+	Version  string   // exposed as VERSION
+	Env      []string // names of environment variables exposed in ENV
+}
+
+func (b Builtins) environment() map[string]string {
+	env := make(map[string]string)
+	for _, k := range b.Env {
+		if v, ok := os.LookupEnv(k); ok {
+			env[k] = v
+		}
+	}
+	return env
 }
 
 // ConfigFromDirectory searches a directory for configuration files and
-// constructs a desired state from the declarations.
-func ConfigFromDirectory(dir, hostname string) (state State, err error) {
+// constructs a desired state from the declarations. Keys of target definitions
+// that aren't target fields are returned as unknown, they're ignored.
+func ConfigFromDirectory(dir string, builtins Builtins) (state State, unknown []UnknownKey, err error) {
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		err = errors.Wrap(err, "failed to read config directory")
 		return
 	}
 
-	sources := []string{}
+	sources := []script{}
 
 	for _, file := range files {
 		if file.IsDir() {
@@ -51,7 +101,10 @@ func ConfigFromDirectory(dir, hostname string) (state State, err error) {
 		}
 
 		if filepath.Ext(file.Name()) == ".js" {
-			sources = append(sources, fileToString(filepath.Join(dir, file.Name())))
+			sources = append(sources, script{
+				name:   file.Name(),
+				source: fileToString(filepath.Join(dir, file.Name())),
+			})
 		}
 	}
 
@@ -61,34 +114,43 @@ func ConfigFromDirectory(dir, hostname string) (state State, err error) {
 		scripts: sources,
 	}
 
-	err = cb.construct(hostname)
+	err = cb.construct(builtins)
 	if err != nil {
 		return
 	}
 
 	state = *cb.state
+	unknown = cb.unknown
 	return
 }
 
 type configBuilder struct {
 	vm      *otto.Otto
 	state   *State
-	scripts []string
+	scripts []script
+	unknown []UnknownKey
+}
+
+type script struct {
+	name   string
+	source string
 }
 
-func (cb *configBuilder) construct(hostname string) (err error) {
+func (cb *configBuilder) construct(builtins Builtins) (err error) {
 	//nolint:errcheck
 	cb.vm.Run(`'use strict';
 var STATE = {
 	targets: [],
 	auths: [],
-	env: {}
+	env: {},
+	defaults: {},
+	notifiers: [],
+	groups: []
 };
 
 function T(t) {
-	if(t.name === undefined) { throw "target name undefined"; }
-	if(t.url === undefined) { throw "target url undefined"; }
-	if(t.up === undefined) { throw "target up undefined"; }
+	if(t.name === undefined) { throw new Error("target name undefined"); }
+	// url and up may be provided by defaults, so they're validated after merge
 	// if(t.down === undefined) { }
 	// if(t.env) { }
 	// if(t.initial_run) { }
@@ -101,24 +163,47 @@ function E(k, v) {
 	STATE.env[k] = v
 }
 
+function D(d) {
+	for(var k in d) { STATE.defaults[k] = d[k]; }
+}
+
 function A(a) {
-	if(a.name === undefined) { throw "auth name undefined"; }
-	if(a.path === undefined) { throw "auth path undefined"; }
-	if(a.user_key === undefined) { throw "auth user_key undefined"; }
-	if(a.pass_key === undefined) { throw "auth pass_key undefined"; }
+	if(a.name === undefined) { throw new Error("auth name undefined"); }
+	if(a.path === undefined) { throw new Error("auth path undefined"); }
+	if(a.token_key === undefined) {
+		if(a.user_key === undefined && a.type !== "token") { throw new Error("auth user_key undefined"); }
+		if(a.pass_key === undefined) { throw new Error("auth pass_key undefined"); }
+	}
 
 	STATE.auths.push(a);
 }
-`)
 
-	cb.vm.Set("HOSTNAME", hostname) //nolint:errcheck
+function N(n) {
+	if(n.name === undefined) { throw new Error("notifier name undefined"); }
+	if(n.type === undefined) { throw new Error("notifier type undefined"); }
 
-	env := make(map[string]string)
-	for _, kv := range os.Environ() {
-		d := strings.IndexRune(kv, '=')
-		env[kv[:d]] = kv[d+1:]
+	STATE.notifiers.push(n);
+}
+
+function G(g) {
+	if(g.name === undefined) { throw new Error("group name undefined"); }
+
+	STATE.groups.push(g);
+}
+`)
+
+	env, _ := cb.vm.Object(`({})`)
+	for k, v := range builtins.environment() {
+		env.Set(k, v) //nolint:errcheck
 	}
-	cb.vm.Set("ENV", env) //nolint:errcheck
+	cb.vm.Set("__hostname", builtins.Hostname) //nolint:errcheck
+	cb.vm.Set("__version", builtins.Version)   //nolint:errcheck
+	cb.vm.Set("__env", env)                    //nolint:errcheck
+	cb.vm.Run(`
+Object.defineProperty(this, "HOSTNAME", {value: __hostname, writable: false});
+Object.defineProperty(this, "VERSION", {value: __version, writable: false});
+Object.defineProperty(this, "ENV", {value: Object.freeze(__env), writable: false});
+`) //nolint:errcheck
 
 	for _, s := range cb.scripts {
 		err = cb.applyFileTargets(s)
@@ -135,23 +220,174 @@ function A(a) {
 	if err != nil {
 		return errors.Wrap(err, "failed to get string representation of STATE")
 	}
-	err = json.Unmarshal([]byte(stateRaw), cb.state)
 
+	var raw struct {
+		Targets  []map[string]interface{} `json:"targets"`
+		Defaults map[string]interface{}   `json:"defaults"`
+		Groups   []Group                  `json:"groups"`
+	}
+	if err = json.Unmarshal([]byte(stateRaw), &raw); err != nil {
+		return errors.Wrap(err, "failed to decode STATE object")
+	}
+	cb.unknown = unknownKeys("", raw.Defaults)
+	for i := range raw.Targets {
+		name, _ := raw.Targets[i]["name"].(string)
+		cb.unknown = append(cb.unknown, unknownKeys(name, raw.Targets[i])...)
+		raw.Targets[i] = applyDefaults(raw.Targets[i], raw.Defaults)
+	}
+	targetsRaw, err := json.Marshal(raw.Targets)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode targets with defaults")
+	}
+
+	if err = json.Unmarshal([]byte(stateRaw), cb.state); err != nil {
+		return errors.Wrap(err, "failed to decode STATE object")
+	}
+	cb.state.Targets = nil
+	if err = json.Unmarshal(targetsRaw, &cb.state.Targets); err != nil {
+		return errors.Wrap(err, "failed to decode targets")
+	}
+
+	// global environment variables are overridden by target variables
 	for i := range cb.state.Targets {
-		tmpEnv := cb.state.Targets[i].Env
-		cb.state.Targets[i].Env = cb.state.Env
-		for k, v := range tmpEnv {
-			cb.state.Targets[i].Env[k] = v
+		env := make(map[string]string)
+		for k, v := range cb.state.Env {
+			env[k] = v
 		}
+		for k, v := range cb.state.Targets[i].Env {
+			env[k] = v
+		}
+		cb.state.Targets[i].Env = env
 	}
 
-	return
+	// targets without notifications of their own have those of their group
+	for _, g := range raw.Groups {
+		if err := validateNotifications("group '"+g.Name+"'", g.Notifications, cb.state.Notifiers); err != nil {
+			return err
+		}
+		for i, t := range cb.state.Targets {
+			if t.Group == g.Name && t.Notifications == nil && g.Notifications != nil {
+				n := *g.Notifications
+				cb.state.Targets[i].Notifications = &n
+			}
+		}
+	}
+
+	if err := validateNotifiers(cb.state.Notifiers); err != nil {
+		return err
+	}
+	for _, t := range cb.state.Targets {
+		if err := validateNotifications("target '"+t.Name+"'", t.Notifications, cb.state.Notifiers); err != nil {
+			return err
+		}
+	}
+	return validate(cb.state.Targets)
+}
+
+// validateNotifiers checks that notifiers are uniquely named and have what
+// their type requires.
+func validateNotifiers(notifiers []Notifier) error {
+	names := make(map[string]bool, len(notifiers))
+	for _, n := range notifiers {
+		if names[n.Name] {
+			return errors.Errorf("notifier '%s' is declared twice", n.Name)
+		}
+		names[n.Name] = true
+		switch n.Type {
+		case NotifierDiscord, NotifierWebhook:
+			if n.URL == "" {
+				return errors.Errorf("notifier '%s': url undefined", n.Name)
+			}
+		case NotifierCommand:
+			if n.Command == "" {
+				return errors.Errorf("notifier '%s': command undefined", n.Name)
+			}
+		default:
+			return errors.Errorf("notifier '%s' type '%s' is not one of %s, %s or %s", n.Name, n.Type, NotifierDiscord, NotifierWebhook, NotifierCommand)
+		}
+	}
+	return nil
 }
 
-func (cb *configBuilder) applyFileTargets(script string) (err error) {
-	_, err = cb.vm.Run(script)
+// validateNotifications checks that notifications are routed to a declared
+// notifier, if they're routed at all.
+func validateNotifications(owner string, n *task.Notifications, notifiers []Notifier) error {
+	if n == nil || n.Channel == "" {
+		return nil
+	}
+	for _, known := range notifiers {
+		if known.Name == n.Channel {
+			return nil
+		}
+	}
+	return errors.Errorf("%s notifications channel '%s' is not a notifier declared with N()", owner, n.Channel)
+}
+
+// applyDefaults fills in every key of the target that's absent with the value
+// from the defaults. Map-valued keys, such as env, are merged key-wise so the
+// target may add to or override individual keys from the defaults.
+func applyDefaults(target, defaults map[string]interface{}) map[string]interface{} {
+	for k, dv := range defaults {
+		tv, ok := target[k]
+		if !ok || tv == nil {
+			target[k] = dv
+			continue
+		}
+
+		tm, tok := tv.(map[string]interface{})
+		dm, dok := dv.(map[string]interface{})
+		if !tok || !dok {
+			continue
+		}
+		merged := make(map[string]interface{})
+		for mk, mv := range dm {
+			merged[mk] = mv
+		}
+		for mk, mv := range tm {
+			merged[mk] = mv
+		}
+		target[k] = merged
+	}
+	return target
+}
+
+// validate checks targets for required fields once defaults have been applied
+func validate(targets task.Targets) error {
+	for _, t := range targets {
+		if t.RepoURL == "" {
+			return errors.Errorf("target %s: url undefined", t.Name)
+		}
+		if len(t.Up) == 0 {
+			return errors.Errorf("target %s: up undefined", t.Name)
+		}
+	}
+	return task.ValidateTargets(targets)
+}
+
+// ScriptError is returned when a configuration script fails to parse or
+// evaluate, it identifies the offending file.
+type ScriptError struct {
+	File   string
+	Reason string
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("failed to evaluate %s: %s", e.File, e.Reason)
+}
+
+func (cb *configBuilder) applyFileTargets(s script) (err error) {
+	compiled, err := cb.vm.Compile(s.name, s.source)
 	if err != nil {
-		return
+		return &ScriptError{File: s.name, Reason: err.Error()}
+	}
+
+	_, err = cb.vm.Run(compiled)
+	if err != nil {
+		// otto errors carry the stack with file and line of the failure
+		if oe, ok := err.(*otto.Error); ok {
+			return &ScriptError{File: s.name, Reason: strings.TrimSpace(oe.String())}
+		}
+		return &ScriptError{File: s.name, Reason: err.Error()}
 	}
 
 	return
@@ -66,10 +66,64 @@ func Test_applyFileTargets(t *testing.T) {
 		`, task.Targets{
 			{Name: "name", RepoURL: "../test.local", Up: []string{"sleep"}, Env: map[string]string{"GLOBAL": "readme", "LOCAL": "hi"}},
 		}, false},
+		{"defaults", `
+		D({up: ["docker-compose", "up", "-d"], branch: "main", env: {TIER: "prod", REGION: "eu"}});
+		T({name: "1", url: "../one.local"});
+		T({name: "2", url: "../two.local", up: ["make"], branch: "dev", env: {REGION: "us", EXTRA: "x"}});
+		`, task.Targets{
+			{Name: "1", RepoURL: "../one.local", Branch: "main", Up: []string{"docker-compose", "up", "-d"}, Env: map[string]string{"TIER": "prod", "REGION": "eu"}},
+			{Name: "2", RepoURL: "../two.local", Branch: "dev", Up: []string{"make"}, Env: map[string]string{"TIER": "prod", "REGION": "us", "EXTRA": "x"}},
+		}, false},
+		{"defaultsafter", `
+		T({name: "1", url: "../one.local", initial_run: false});
+		D({up: ["sleep"], initial_run: true});
+		`, task.Targets{
+			{Name: "1", RepoURL: "../one.local", Up: []string{"sleep"}, InitialRun: false, Env: map[string]string{}},
+		}, false},
+		{"defaultsglobalenv", `
+		E("TIER", "global");
+		E("ONLY", "global");
+		D({env: {TIER: "default"}});
+		T({name: "1", url: "../one.local", up: ["sleep"]});
+		T({name: "2", url: "../two.local", up: ["sleep"], env: {TIER: "target"}});
+		`, task.Targets{
+			{Name: "1", RepoURL: "../one.local", Up: []string{"sleep"}, Env: map[string]string{"TIER": "default", "ONLY": "global"}},
+			{Name: "2", RepoURL: "../two.local", Up: []string{"sleep"}, Env: map[string]string{"TIER": "target", "ONLY": "global"}},
+		}, false},
+		{"notifications", `
+		N({name: "dev", type: "discord", url: "https://discord.com/api/webhooks/1/t"});
+		G({name: "dev", notifications: {channel: "dev", notify_on: ["failure", "recovery"]}});
+		T({name: "1", url: "../one.local", up: ["sleep"], group: "dev"});
+		T({name: "2", url: "../two.local", up: ["sleep"], group: "dev", notifications: {mute: true}});
+		`, task.Targets{
+			{Name: "1", RepoURL: "../one.local", Up: []string{"sleep"}, Group: "dev", Env: map[string]string{}, Notifications: &task.Notifications{Channel: "dev", NotifyOn: []string{"failure", "recovery"}}},
+			{Name: "2", RepoURL: "../two.local", Up: []string{"sleep"}, Group: "dev", Env: map[string]string{}, Notifications: &task.Notifications{Mute: true}},
+		}, false},
+		{"unknownnotifier", `T({name: "1", url: "../one.local", up: ["sleep"], notifications: {channel: "ops"}})`, task.Targets{}, true},
+		{"unknowngroupnotifier", `G({name: "dev", notifications: {channel: "ops"}})`, task.Targets{}, true},
+		{"notifiertype", `N({name: "ops", type: "slack", url: "https://hooks.slack.com/x"})`, task.Targets{}, true},
+		{"defaultsmissingup", `D({branch: "main"}); T({name: "name", url: "../test.local"})`, task.Targets{}, true},
 		{"badtype", `T({name: "name", url: "../test.local", up: 1.23})`, task.Targets{}, true},
 		{"missingkey", `T({name: "name", url: "../test.local"})`, task.Targets{}, true},
+		{"emptyscript", `T({name: "name", url: "../test.local", up: ["sh", "-c", ""]})`, task.Targets{}, true},
+		{"undefinedprogram", `T({name: "name", url: "../test.local", up: [ENV["UNSET_KEY"], "up"]})`, task.Targets{}, true},
 		{"env", `console.log(ENV["TEST_ENV_KEY"])`, task.Targets{}, false},
 		{"hostname", `console.log(HOSTNAME)`, task.Targets{}, false},
+		{"builtins", `
+		if (HOSTNAME !== "host") { throw new Error("unexpected hostname " + HOSTNAME); }
+		if (VERSION !== "v1.2.3") { throw new Error("unexpected version " + VERSION); }
+		T({name: HOSTNAME, url: "../test.local", up: ["echo", ENV["TEST_ENV_KEY"]]});
+		`, task.Targets{
+			{Name: "host", RepoURL: "../test.local", Up: []string{"echo", "an environment variable inside the JS vm"}, Env: map[string]string{}},
+		}, false},
+		{"envnotallowed", `if (ENV["TEST_ENV_SECRET"] !== undefined) { throw new Error("leaked"); }`, task.Targets{}, false},
+		{"readonly", `
+		HOSTNAME = "other";
+		ENV["TEST_ENV_KEY"] = "other";
+		T({name: HOSTNAME, url: "../test.local", up: [ENV["TEST_ENV_KEY"]]});
+		`, task.Targets{
+			{Name: "host", RepoURL: "../test.local", Up: []string{"an environment variable inside the JS vm"}, Env: map[string]string{}},
+		}, false},
 	}
 
 	for _, tt := range tests {
@@ -77,12 +131,17 @@ func Test_applyFileTargets(t *testing.T) {
 			cb := configBuilder{
 				vm:      otto.New(),
 				state:   new(State),
-				scripts: []string{tt.script},
+				scripts: []script{{name: tt.name + ".js", source: tt.script}},
 			}
 
 			os.Setenv("TEST_ENV_KEY", "an environment variable inside the JS vm")
+			os.Setenv("TEST_ENV_SECRET", "not allowlisted")
 
-			err := cb.construct("host")
+			err := cb.construct(Builtins{
+				Hostname: "host",
+				Version:  "v1.2.3",
+				Env:      []string{"TEST_ENV_KEY"},
+			})
 			if tt.wantErr {
 				assert.Error(t, err)
 				return
@@ -93,3 +152,17 @@ func Test_applyFileTargets(t *testing.T) {
 		})
 	}
 }
+
+func Test_errorLocation(t *testing.T) {
+	cb := configBuilder{
+		vm:    otto.New(),
+		state: new(State),
+		scripts: []script{{name: "targets.js", source: `
+var region = ENV["REGION"];
+if (region === undefined) { throw new Error("REGION is required"); }
+`}},
+	}
+
+	err := cb.construct(Builtins{Hostname: "host"})
+	assert.EqualError(t, err, "failed to evaluate targets.js: Error: REGION is required\n    at targets.js:3:39")
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/picostack/pico/task"
+)
+
+// UnknownKey is a key of a target definition that isn't a field of a target,
+// such as a misspelled key, so it has no effect.
+type UnknownKey struct {
+	Target     string // the target's name, empty for a key of the defaults set with D()
+	Key        string
+	Suggestion string // a known key that's spelled similarly, if any
+}
+
+func (u UnknownKey) String() string {
+	where := "defaults"
+	if u.Target != "" {
+		where = "target " + u.Target
+	}
+	s := fmt.Sprintf("%s: unknown key '%s'", where, u.Key)
+	if u.Suggestion != "" {
+		s += fmt.Sprintf(", did you mean '%s'?", u.Suggestion)
+	}
+	return s
+}
+
+// UnknownKeysError is returned for unknown keys when they aren't allowed
+type UnknownKeysError struct {
+	Keys []UnknownKey
+}
+
+func (e *UnknownKeysError) Error() string {
+	s := make([]string, len(e.Keys))
+	for i, k := range e.Keys {
+		s[i] = k.String()
+	}
+	return strings.Join(s, "; ")
+}
+
+// targetKeys are the keys of a target definition, from the JSON field names
+// of task.Target.
+var targetKeys = jsonKeys(reflect.TypeOf(task.Target{}))
+
+func jsonKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// unknownKeys returns the keys of a target definition that aren't target keys,
+// in alphabetical order.
+func unknownKeys(target string, definition map[string]interface{}) (unknown []UnknownKey) {
+	var keys []string
+	for k := range definition {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if i := sort.SearchStrings(targetKeys, k); i < len(targetKeys) && targetKeys[i] == k {
+			continue
+		}
+		unknown = append(unknown, UnknownKey{Target: target, Key: k, Suggestion: suggest(k)})
+	}
+	return
+}
+
+// suggest returns the target key closest to key, if it's only a couple of
+// edits away.
+func suggest(key string) (best string) {
+	most := 2
+	if len(key) <= 3 {
+		most = 1
+	}
+	for _, k := range targetKeys {
+		if d := distance(strings.ToLower(key), k); d <= most {
+			best, most = k, d-1
+		}
+	}
+	return
+}
+
+// distance is the Levenshtein distance between a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minimum(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minimum(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
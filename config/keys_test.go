@@ -0,0 +1,51 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/robertkrimen/otto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"none", `T({name: "a", url: "../a", up: ["true"], debounce: "1s"});`, nil},
+		{"typo", `T({name: "a", url: "../a", up: ["true"], debunce: "1s"});`, []string{
+			"target a: unknown key 'debunce', did you mean 'debounce'?",
+		}},
+		{"case", `T({name: "a", url: "../a", up: ["true"], Priority: 1});`, []string{
+			"target a: unknown key 'Priority', did you mean 'priority'?",
+		}},
+		{"unrelated", `T({name: "a", url: "../a", up: ["true"], command: ["true"]});`, []string{
+			"target a: unknown key 'command'",
+		}},
+		{"defaults", `D({in_plcae: true}); T({name: "a", url: "../a", up: ["true"]});`, []string{
+			"defaults: unknown key 'in_plcae', did you mean 'in_place'?",
+		}},
+		{"sorted", `T({name: "a", url: "../a", up: ["true"], zz: 1, aa: 2}); T({name: "b", url: "../b", up: ["true"], mutx: "db"});`, []string{
+			"target a: unknown key 'aa'",
+			"target a: unknown key 'zz'",
+			"target b: unknown key 'mutx', did you mean 'mutex'?",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := configBuilder{
+				vm:      otto.New(),
+				state:   new(State),
+				scripts: []script{{name: tt.name + ".js", source: tt.script}},
+			}
+			assert.NoError(t, cb.construct(Builtins{}))
+
+			var got []string
+			for _, u := range cb.unknown {
+				got = append(got, u.String())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Package disk measures the disk usage of repository clones and compacts them,
+// so long-lived clones on small devices don't grow without bound.
+package disk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+)
+
+// Size returns the total size of the regular files under path. Files that
+// disappear while it's walked are skipped.
+func Size(path string) (int64, error) {
+	var total int64
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+var units = []struct {
+	suffix string
+	size   int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// ParseSize parses a size such as "512M" or "2G", with binary units, or a plain
+// number of bytes.
+func ParseSize(s string) (int64, error) {
+	v := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSuffix(v, u.suffix)
+			multiplier = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid size '%s'", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// FormatSize formats a number of bytes with a binary unit, such as "1.5G"
+func FormatSize(n int64) string {
+	for _, u := range units {
+		if n >= u.size {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// GC removes unreachable loose objects from the repository at path and packs
+// the remaining objects into a single pack. It must not run concurrently with
+// other git operations on the same repository.
+func GC(path string) error {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open repository")
+	}
+
+	// recent objects may belong to an operation that's still in progress,
+	// git itself keeps them for two weeks.
+	err = repo.Prune(git.PruneOptions{
+		OnlyObjectsOlderThan: time.Now().Add(-time.Hour),
+		Handler:              repo.DeleteObject,
+	})
+	if err != nil && err != git.ErrLooseObjectsNotSupported {
+		return errors.Wrap(err, "failed to prune loose objects")
+	}
+
+	err = repo.RepackObjects(&git.RepackConfig{OnlyDeletePacksOlderThan: time.Now()})
+	if err != nil && err != git.ErrPackedObjectsNotSupported {
+		return errors.Wrap(err, "failed to repack objects")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"100", 100, false},
+		{"512M", 512 << 20, false},
+		{"2g", 2 << 30, false},
+		{"1.5GB", 3 << 29, false},
+		{"10K", 10 << 10, false},
+		{"big", 0, true},
+		{"-1M", 0, true},
+	} {
+		got, err := ParseSize(tt.in)
+		if tt.wantErr {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512B", FormatSize(512))
+	assert.Equal(t, "1.5K", FormatSize(1536))
+	assert.Equal(t, "2.0G", FormatSize(2<<30))
+}
+
+func TestSizeAndGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-disk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+	wt, err := repo.Worktree()
+	assert.NoError(t, err)
+	for i, content := range []string{"one", "two", "three"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), []byte(content), 0o644))
+		_, err = wt.Add("file")
+		assert.NoError(t, err)
+		_, err = wt.Commit(content, &git.CommitOptions{Author: &object.Signature{
+			Name: "pico", Email: "pico@example.com", When: time.Now().Add(time.Duration(i) * time.Second),
+		}})
+		assert.NoError(t, err)
+	}
+
+	size, err := Size(dir)
+	assert.NoError(t, err)
+	assert.True(t, size > 0)
+
+	assert.NoError(t, GC(dir))
+
+	head, err := repo.Head()
+	assert.NoError(t, err)
+	repo, err = git.PlainOpen(dir)
+	assert.NoError(t, err)
+	_, err = repo.CommitObject(head.Hash())
+	assert.NoError(t, err)
+
+	missing, err := Size(filepath.Join(dir, "missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), missing)
+}
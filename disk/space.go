@@ -0,0 +1,53 @@
+package disk
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrLowSpace is matched by errors of operations skipped since the disk has
+// less free space than the configured minimum
+var ErrLowSpace = errors.New("low disk space")
+
+// Space is the size of a filesystem and how much of it is free, Free only
+// counts the space available to unprivileged users.
+type Space struct {
+	Free  int64
+	Total int64
+}
+
+// Percent returns the free space as a percentage of the filesystem's size
+func (s Space) Percent() float64 {
+	if s.Total <= 0 {
+		return 0
+	}
+	return float64(s.Free) / float64(s.Total) * 100
+}
+
+// FreeSpace returns the space of the filesystem that path is on
+func FreeSpace(path string) (Space, error) {
+	return freeSpace(path)
+}
+
+// Minimum is the free space below which operations that write to a disk are
+// skipped, as an absolute size, a percentage of the filesystem's size or both.
+type Minimum struct {
+	Bytes   int64
+	Percent float64
+}
+
+// Enabled reports whether a minimum is set
+func (m Minimum) Enabled() bool {
+	return m.Bytes > 0 || m.Percent > 0
+}
+
+// Check returns an error matching ErrLowSpace if the space is below either
+// minimum.
+func (m Minimum) Check(s Space) error {
+	if m.Bytes > 0 && s.Free < m.Bytes {
+		return errors.Wrapf(ErrLowSpace, "%s free, below the minimum of %s", FormatSize(s.Free), FormatSize(m.Bytes))
+	}
+	if m.Percent > 0 && s.Total > 0 && s.Percent() < m.Percent {
+		return errors.Wrapf(ErrLowSpace, "%.1f%% free, below the minimum of %.1f%%", s.Percent(), m.Percent)
+	}
+	return nil
+}
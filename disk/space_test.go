@@ -0,0 +1,38 @@
+package disk
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinimum(t *testing.T) {
+	s := Space{Free: 1 << 30, Total: 20 << 30}
+	for _, tt := range []struct {
+		min  Minimum
+		want string
+	}{
+		{Minimum{}, ""},
+		{Minimum{Bytes: 512 << 20}, ""},
+		{Minimum{Bytes: 2 << 30}, "1.0G free, below the minimum of 2.0G: low disk space"},
+		{Minimum{Percent: 5}, ""},
+		{Minimum{Percent: 10}, "5.0% free, below the minimum of 10.0%: low disk space"},
+		{Minimum{Bytes: 512 << 20, Percent: 10}, "5.0% free, below the minimum of 10.0%: low disk space"},
+	} {
+		err := tt.min.Check(s)
+		if tt.want == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		assert.EqualError(t, err, tt.want)
+		assert.True(t, errors.Is(err, ErrLowSpace))
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	s, err := FreeSpace(".")
+	assert.NoError(t, err)
+	assert.True(t, s.Total > 0)
+	assert.True(t, s.Free <= s.Total)
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package disk
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+func freeSpace(path string) (Space, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return Space{}, errors.Wrap(err, "failed to measure free disk space")
+	}
+	return Space{
+		Free:  int64(st.Bavail) * int64(st.Bsize),
+		Total: int64(st.Blocks) * int64(st.Bsize),
+	}, nil
+}
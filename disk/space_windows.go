@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package disk
+
+import (
+	"github.com/pkg/errors"
+)
+
+// free space isn't measured on Windows, a minimum is never enforced there
+func freeSpace(path string) (Space, error) {
+	return Space{}, errors.New("free disk space can't be measured on windows")
+}
@@ -1,110 +1,585 @@
 package executor
 
 import (
+	"context"
+	"io"
+	"os"
+	osexec "os/exec"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
+	"github.com/picostack/pico/redact"
 	"github.com/picostack/pico/secret"
 	"github.com/picostack/pico/task"
 )
 
 var _ Executor = &CommandExecutor{}
+var _ EnabledSetter = &CommandExecutor{}
 
 // CommandExecutor handles command invocation targets
 type CommandExecutor struct {
-	secrets            secret.Store
-	passEnvironment    bool   // pass the Pico process environment to children
-	configSecretPath   string // path to global secrets to pass to children
-	configSecretPrefix string // only pass secrets with this prefix, usually GLOBAL_
+	secrets             *SecretResolver
+	passEnvironment     bool // pass the Pico process environment to children, targets may override
+	interpolateCommands bool // resolve secret placeholders in commands as well as env
+	strictEnv           bool // fail tasks whose environment sources define a variable differently
+	enabled             func(target string) bool
+	results             func(Result)
+	started             func(Result)
+	handlersMu          sync.Mutex // the start and result handlers are called one at a time
+	leases              *leases
+	queue               *queue
+	plan                *task.Plan // the cold start plan executed last
+	mutexes             *mutexes
+	gate                *gate
+	quarantine          *quarantine
+	worktrees           string // directory for per-task checkouts, none when empty
+	archives            string // directory for checkouts of bare clones, temporary when empty
+	ctx                 context.Context
+	outputLimit         int // bytes of output kept per task
+	startupParallel     int // tasks of the cold start plan executed at a time
+	inits               InitRecorder
+	applied             func(target string) string // the commit last deployed
+	hostname            string                     // os.Hostname when empty
+}
+
+// InitRecorder records which targets have run their init command on this host,
+// state.Store implements it to persist them.
+type InitRecorder interface {
+	Initialised(target string) bool
+	SetInitialised(target string, initialised bool) error
 }
 
-// NewCommandExecutor creates a new CommandExecutor
+// NewCommandExecutor creates a new CommandExecutor, global secrets are read
+// from configSecretPath in the secret store.
 func NewCommandExecutor(
 	secrets secret.Store,
 	passEnvironment bool,
 	configSecretPath string,
-	configSecretPrefix string,
-) CommandExecutor {
-	return CommandExecutor{
-		secrets:            secrets,
-		passEnvironment:    passEnvironment,
-		configSecretPath:   configSecretPath,
-		configSecretPrefix: configSecretPrefix,
-	}
-}
-
-// Subscribe implements executor.Executor
-func (e *CommandExecutor) Subscribe(bus chan task.ExecutionTask) {
-	for t := range bus {
-		if err := e.execute(t.Target, t.Path, t.Shutdown, t.Env); err != nil {
-			zap.L().Error("executor task unsuccessful",
-				zap.String("target", t.Target.Name),
-				zap.Bool("shutdown", t.Shutdown),
-				zap.Error(err))
+) *CommandExecutor {
+	return &CommandExecutor{
+		secrets:         NewSecretResolver(secrets, configSecretPath),
+		passEnvironment: passEnvironment,
+		leases:          &leases{},
+		queue:           newQueue(queueLimit),
+		mutexes:         newMutexes(),
+		gate:            newGate(),
+		quarantine:      newQuarantine(),
+		ctx:             context.Background(),
+		outputLimit:     DefaultOutputLimit,
+		startupParallel: 1,
+		inits:           &memoryInits{},
+	}
+}
+
+// SetRequireSecrets makes tasks fail, rather than warn, when a target's
+// secret_map refers to a secret that doesn't exist.
+func (e *CommandExecutor) SetRequireSecrets(require bool) {
+	e.secrets.SetRequireSecrets(require)
+}
+
+// SetInterpolateCommands enables resolving ${secret:...} placeholders in
+// target commands, placeholders in environment values are always resolved.
+func (e *CommandExecutor) SetInterpolateCommands(enabled bool) {
+	e.interpolateCommands = enabled
+}
+
+// SetWorktreeDirectory makes each task run in a checkout of exactly the commit
+// it was queued for, created in dir and removed afterwards, so fetches never
+// change files under a running command. Only tracked files are checked out and
+// the checkout is removed once the task's commands exit, so anything they
+// start mustn't refer to it by a relative path, such as a bind mount.
+func (e *CommandExecutor) SetWorktreeDirectory(dir string) {
+	e.worktrees = dir
+}
+
+// SetArchiveDirectory sets where tasks of targets checked out as archives get
+// the checkout of their commit they run in, rather than the system's temporary
+// directory.
+func (e *CommandExecutor) SetArchiveDirectory(dir string) {
+	e.archives = dir
+}
+
+// SetStrictEnv makes tasks fail when more than one source of their environment
+// defines a variable with different values, such as a global secret and the
+// target's env, rather than the one with higher precedence winning.
+func (e *CommandExecutor) SetStrictEnv(strict bool) {
+	e.strictEnv = strict
+}
+
+// SetOutputLimit sets how many bytes of each task's output are kept for its
+// result, the middle of longer output is dropped. Output is always written to
+// stdout in full.
+func (e *CommandExecutor) SetOutputLimit(limit int) {
+	if limit > 0 {
+		e.outputLimit = limit
+	}
+}
+
+// SetStartupParallelism sets how many tasks of the cold start plan may execute
+// at the same time, every other task is executed one at a time.
+func (e *CommandExecutor) SetStartupParallelism(n int) {
+	if n > 0 {
+		e.startupParallel = n
+	}
+}
+
+// SetAppliedFunc sets how the commit a target was last deployed at is looked up,
+// for PICO_PREVIOUS_COMMIT, it's empty when unset.
+func (e *CommandExecutor) SetAppliedFunc(f func(target string) string) {
+	e.applied = f
+}
+
+// SetHostname sets PICO_HOSTNAME of commands, the system's hostname is used
+// when unset.
+func (e *CommandExecutor) SetHostname(hostname string) {
+	e.hostname = hostname
+}
+
+// SetInitRecorder sets where the targets that have run their init command are
+// recorded, by default they're only kept in memory.
+func (e *CommandExecutor) SetInitRecorder(r InitRecorder) {
+	e.inits = r
+}
+
+// SetContext implements executor.Executor, the command of the running task and
+// every process it started are stopped when ctx is done.
+func (e *CommandExecutor) SetContext(ctx context.Context) {
+	e.ctx = ctx
+}
+
+// SetCancelOnReconfigure makes Hold cancel the executing tasks of the targets
+// being reconfigured rather than waiting for them to finish.
+func (e *CommandExecutor) SetCancelOnReconfigure(cancel bool) {
+	e.gate.cancel = cancel
+}
+
+// Hold implements executor.Holder
+func (e *CommandExecutor) Hold(targets []string) (release func()) {
+	return e.gate.hold(targets)
+}
+
+// SetEnabledFunc sets a function that's consulted for each task before it's
+// executed, tasks for targets it reports as disabled are dropped.
+func (e *CommandExecutor) SetEnabledFunc(f func(target string) bool) {
+	e.enabled = f
+}
+
+// SetStartHandler sets a function that's called when each task starts
+// executing, with a result that has yet to be finished.
+func (e *CommandExecutor) SetStartHandler(f func(Result)) {
+	e.started = f
+}
+
+// SetResultHandler implements executor.Executor
+func (e *CommandExecutor) SetResultHandler(f func(Result)) {
+	e.results = f
+}
+
+// Subscribe implements executor.Executor. Tasks that arrive while another is
+// executing are queued, up to queueLimit, and executed highest priority first, tasks of equal
+// priority in the order they arrived. A task whose target has a mutex waits for
+// any other task holding it to finish. The tasks of a cold start plan are
+// executed before any others, see runPlan.
+func (e *CommandExecutor) Subscribe(bus <-chan task.ExecutionTask) {
+	go e.queue.feed(bus)
+
+	for {
+		item, waiting, ok := e.queue.pop()
+		if !ok {
+			return
+		}
+		// a plan task that arrives after its plan gave up waiting for it is
+		// executed on its own
+		if item.task.Plan != nil && item.task.Plan != e.plan {
+			e.runPlan(item, waiting)
+			continue
+		}
+		e.process(item, waiting)
+	}
+}
+
+// runPlan executes the tasks of a cold start plan, starting with first, in the
+// plan's order. Up to startupParallel of them execute at a time and each one
+// waits for the tasks of the targets it depends on to finish first. Tasks of
+// the plan may be dropped or held before they reach the executor, so the plan
+// ends once no more of its tasks arrive within planTaskTimeout.
+func (e *CommandExecutor) runPlan(first queued, waiting int) {
+	plan := first.task.Plan
+	e.plan = plan
+	zap.L().Info("executing cold start plan",
+		zap.Strings("order", plan.Targets),
+		zap.Int("parallelism", e.startupParallel))
+
+	finished := make(map[string]chan struct{}, len(plan.Targets))
+	slots := make(chan struct{}, e.startupParallel)
+	var wg sync.WaitGroup
+
+	item, ok := first, true
+	for n := 0; ok; n++ {
+		for _, d := range item.task.Target.DependsOn {
+			if done, started := finished[d]; started {
+				<-done
+			}
+		}
+		done := make(chan struct{})
+		finished[item.task.Target.Name] = done
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(item queued, waiting int) {
+			defer wg.Done()
+			e.process(item, waiting)
+			<-slots
+			close(done)
+		}(item, waiting)
+
+		if n+1 == len(plan.Targets) {
+			break
+		}
+		item, waiting, ok = e.queue.popPlan(plan, planTaskTimeout)
+		if !ok && n+1 < len(plan.Targets) {
+			zap.L().Warn("cold start plan ended without some of its tasks, they were dropped or held",
+				zap.Int("executed", n+1),
+				zap.Int("planned", len(plan.Targets)))
 		}
 	}
+	wg.Wait()
+}
+
+// process executes a single task unless its target is disabled or it was queued
+// before its target was reconfigured.
+func (e *CommandExecutor) process(item queued, waiting int) {
+	t := item.task
+	if e.enabled != nil && !e.enabled(t.Target.Name) {
+		taskLogger(t).Info("dropping task for disabled target",
+			zap.Bool("shutdown", t.Shutdown))
+		return
+	}
+	created := t.Created
+	if created.IsZero() {
+		created = item.queued
+	}
+	log := taskLogger(t)
+	ctx, done, ok := e.gate.enter(withLogger(e.ctx, log), t.Target.Name, created)
+	if !ok {
+		log.Info("dropping task queued before its target was reconfigured",
+			zap.Bool("shutdown", t.Shutdown))
+		return
+	}
+	commit := t.Commit
+	if commit == "" {
+		commit = task.HeadCommit(t.Path)
+	}
+	// teardowns are always attempted, and a manual trigger is a retry
+	if q, held := e.quarantine.held(t.Target.Name, commit); held && !t.Shutdown && t.Trigger != task.TriggerManual {
+		log.Error("skipping quarantined task, trigger the target to retry it",
+			zap.String("commit", commit),
+			zap.Int("panics", q.Panics),
+			zap.String("panic", q.Panic))
+		done()
+		return
+	}
+	release := e.mutexes.acquire(t.Target.Mutex, t.Target.Name, item.queued)
+	r := Result{
+		Task:    t,
+		Commit:  commit,
+		Queued:  item.queued,
+		Started: time.Now(),
+	}
+	log.Info("executing task",
+		zap.Int("priority", t.Priority),
+		zap.String("trigger", string(t.Trigger)),
+		zap.String("trigger_detail", t.Detail),
+		zap.Time("queued", r.Queued),
+		zap.Duration("waited", r.Started.Sub(r.Queued)),
+		zap.Int("waiting", waiting))
+	if e.started != nil {
+		e.handlersMu.Lock()
+		e.started(r)
+		e.handlersMu.Unlock()
+	}
+	// output is redacted before it's kept, so dropping the middle of it can't
+	// leave part of a secret that would no longer be recognised
+	output := task.NewOutput(e.outputLimit)
+	redacted := redact.Stream(output)
+	ctx = withDeployVars(ctx, e.deployVars(t, commit))
+	r.Directory, r.Err = e.runRecovered(ctx, t, commit, redacted)
+	redacted.Close() //nolint:errcheck - output never fails
+	r.Finished = time.Now()
+	var pe *PanicError
+	if errors.As(r.Err, &pe) {
+		log.Error("recovered from panic executing task",
+			zap.Any("panic", pe.Value),
+			zap.String("stack", pe.Stack))
+		if e.quarantine.record(t.Target.Name, commit, pe) {
+			log.Error("quarantined target, its tasks at this commit keep panicking and are skipped until it's triggered or another commit deploys",
+				zap.String("commit", commit),
+				zap.Int("panics", quarantineAfter))
+		}
+	} else if r.Err == nil {
+		e.quarantine.clear(t.Target.Name)
+	}
+	var ee *ExecError
+	if errors.As(r.Err, &ee) && ee.Noop {
+		log.Info("task had nothing to do",
+			zap.Int("exit_code", ee.ExitCode))
+		r.Err, r.Noop = nil, true
+	}
+	release()
+	done()
+	if r.Err == nil && !t.Shutdown {
+		r.Images = e.deployedImages(log, t)
+		if len(r.Images) > 0 {
+			r.Project = composeProject(t)
+		}
+	}
+	r.Output = output.String()
+	r.OutputBytes = output.Total()
+	r.StaleSecrets = e.secrets.StaleSince(t.Target.Name)
+	if r.Err != nil {
+		log.Error("executor task unsuccessful",
+			zap.Bool("shutdown", t.Shutdown),
+			zap.Error(r.Err))
+	}
+	if e.results != nil {
+		e.handlersMu.Lock()
+		e.results(r)
+		e.handlersMu.Unlock()
+	}
+}
+
+// Waiting implements executor.WaitReporter, tasks are returned in the order
+// they were received.
+func (e *CommandExecutor) Waiting() []Waiting {
+	out := e.mutexes.waiting()
+	for _, q := range e.queue.snapshot() {
+		out = append(out, Waiting{Target: q.task.Target.Name, Mutex: q.task.Target.Mutex, Since: q.queued})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since) })
+	return out
+}
+
+// run executes a task, in a dedicated checkout of its commit if enabled, and
+// returns the directory its commands run in.
+func (e *CommandExecutor) run(ctx context.Context, t task.ExecutionTask, commit string, out io.Writer) (string, error) {
+	if !e.useWorktree(t) {
+		return t.Target.WorkDir(t.Path), e.execute(ctx, t.Target, t.Path, commit, t.Shutdown, t.Env, out)
+	}
+	root := e.worktrees
+	if t.Target.GetCheckout() == task.CheckoutArchive {
+		root = e.archives
+		if root == "" {
+			root = os.TempDir()
+		}
+	}
+	if commit == "" {
+		return "", errors.Errorf("no commit of target %s to check out", t.Target.Name)
+	}
+	dir, cleanup, err := checkoutWorktree(root, t.Path, commit, t.Target.CheckoutPaths())
+	if err != nil {
+		return "", errors.Wrap(err, "failed to prepare checkout for task")
+	}
+	defer cleanup()
+	logger(ctx).Debug("running task in dedicated checkout",
+		zap.String("commit", commit),
+		zap.String("dir", dir))
+	return t.Target.WorkDir(dir), e.execute(ctx, t.Target, dir, commit, t.Shutdown, t.Env, out)
 }
 
 type exec struct {
 	path            string
+	dir             string // where commands run, the target's subpath of path
 	env             map[string]string
+	secrets         map[string]string // the global and target secrets in env
 	shutdown        bool
 	passEnvironment bool
+	target          task.Target // with secret placeholders resolved
 }
 
 func (e *CommandExecutor) prepare(
-	name string,
+	ctx context.Context,
+	target task.Target,
 	path string,
 	shutdown bool,
 	execEnv map[string]string,
 ) (exec, error) {
-	// get global secrets from the Pico config path in the secret store.
-	// only secrets with the prefix are retrieved.
-	global, err := secret.GetPrefixedSecrets(e.secrets, e.configSecretPath, e.configSecretPrefix)
+	resolved, err := e.secrets.Resolve(ctx, target)
 	if err != nil {
-		return exec{}, errors.Wrap(err, "failed to get global secrets for target")
+		return exec{}, err
 	}
 
-	secrets, err := e.secrets.GetSecretsForTarget(name)
+	target, execEnv, err = e.interpolate(ctx, target, execEnv, resolved.Raw)
 	if err != nil {
-		return exec{}, errors.Wrap(err, "failed to get secrets for target")
+		return exec{}, errors.Wrap(err, "failed to resolve secret reference")
 	}
 
-	env := make(map[string]string)
+	dir, err := workDir(target, path)
+	if err != nil {
+		return exec{}, err
+	}
 
-	// merge execution environment with secrets in the following order:
-	// globals first, then execution environment, then per-target secrets
-	for k, v := range global {
-		env[k] = v
+	fileEnv, err := readEnvFiles(path, target.EnvFile)
+	if err != nil {
+		return exec{}, err
 	}
-	for k, v := range execEnv {
-		env[k] = v
+
+	passEnvironment := target.ShouldPassEnvironment(e.passEnvironment)
+	passed := make(map[string]string)
+	for _, secrets := range []map[string]string{resolved.Global, resolved.Target} {
+		for k, v := range secrets {
+			passed[k] = v
+		}
+	}
+
+	// the environment is merged from its sources in order of precedence, see
+	// envSource, with the host environment inherited beneath all of them.
+	env := newEnvironment()
+	if target.Clone != "" {
+		env.add(sourceDefault, map[string]string{"COMPOSE_PROJECT_NAME": sharedProject(target)})
+	}
+	env.add(sourceEnvFile, fileEnv)
+	env.add(sourceGlobalSecret, resolved.Global)
+	env.add(sourceConfig, execEnv)
+	env.add(sourceTargetSecret, resolved.Target)
+
+	// credentials are issued per task, a shutdown revokes them instead.
+	if !shutdown {
+		dynamic, err := e.issueCredentials(ctx, target)
+		if err != nil {
+			return exec{}, err
+		}
+		env.add(sourceCredential, dynamic)
 	}
-	for k, v := range secrets {
-		env[k] = v
+	env.add(sourceTarget, target.Env)
+	deploy := deployVarsOf(ctx)
+	env.add(sourceDeploy, deploy)
+	target.Env = withoutVars(target.Env, deploy)
+	if passEnvironment {
+		env.inherit(hostEnviron())
 	}
 
-	return exec{path, env, shutdown, e.passEnvironment}, nil
+	if err := env.report(logger(ctx), e.strictEnv); err != nil {
+		if !shutdown {
+			e.revokeCredentials(ctx, target)
+		}
+		return exec{}, err
+	}
+	if !shutdown {
+		if err := checkRequired(target, env.vars, passEnvironment); err != nil {
+			e.revokeCredentials(ctx, target)
+			return exec{}, err
+		}
+	}
+
+	return exec{path, dir, env.vars, passed, shutdown, passEnvironment, target}, nil
 }
 
 func (e *CommandExecutor) execute(
+	ctx context.Context,
 	target task.Target,
 	path string,
+	commit string,
 	shutdown bool,
 	execEnv map[string]string,
+	out io.Writer,
 ) (err error) {
-	ex, err := e.prepare(target.Name, path, shutdown, execEnv)
+	ex, err := e.prepare(ctx, target, path, shutdown, execEnv)
 	if err != nil {
 		return err
 	}
+	if err := renderTemplates(ctx, ex, commit); err != nil {
+		if !shutdown {
+			e.revokeCredentials(ctx, target)
+		}
+		return err
+	}
 
-	zap.L().Debug("executing with secrets",
-		zap.String("target", target.Name),
+	log := logger(ctx)
+	log.Debug("executing with secrets",
 		zap.Strings("cmd", target.Up),
 		zap.String("url", target.RepoURL),
-		zap.String("dir", path),
+		zap.String("dir", ex.dir),
 		zap.Any("env", ex.env),
-		zap.Bool("passthrough", e.passEnvironment))
+		zap.Bool("passthrough", ex.passEnvironment))
+	log.Info("running target command",
+		zap.String("dir", ex.dir),
+		zap.Bool("shutdown", shutdown))
+
+	if !shutdown {
+		if err := e.initialise(ctx, ex, out); err != nil {
+			e.revokeCredentials(ctx, target)
+			return err
+		}
+		return noopExit(target, execError(runCommand(ctx, ex.target, ex.dir, ex.env, ex.shutdown, ex.passEnvironment, out)))
+	}
+
+	timeout := target.GetShutdownTimeout()
+	shutdownCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err = noopExit(target, execError(runCommand(shutdownCtx, ex.target, ex.dir, ex.env, ex.shutdown, ex.passEnvironment, out)))
+	if shutdownCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		log.Error("abandoned teardown of target after shutdown timeout",
+			zap.Duration("timeout", timeout))
+		err = errors.Wrapf(err, "teardown abandoned after %s", timeout)
+	}
+	e.revokeCredentials(ctx, target)
+	if isNoop(err) {
+		removeRendered(ctx, ex, nil)
+	} else {
+		removeRendered(ctx, ex, err)
+	}
+	return err
+}
 
-	return target.Execute(ex.path, ex.env, ex.shutdown, ex.passEnvironment)
+// runCommand runs the up or down command of a target, replaced in tests
+var runCommand = func(ctx context.Context, t task.Target, dir string, env map[string]string, shutdown, passEnvironment bool, out io.Writer) error {
+	return t.ExecuteContext(ctx, dir, env, shutdown, passEnvironment, out)
+}
+
+// noopExit marks the exit of a target's command with one of its noop exit codes,
+// only the up and down commands are marked, a failed init command never is.
+func noopExit(target task.Target, err error) error {
+	var ee *ExecError
+	if errors.As(err, &ee) && target.IsNoopExit(ee.ExitCode) {
+		ee.Noop = true
+	}
+	return err
+}
+
+// isNoop reports whether err is the exit of a command that had nothing to do
+func isNoop(err error) bool {
+	var ee *ExecError
+	return errors.As(err, &ee) && ee.Noop
+}
+
+// ExecError is returned for a task whose command ran but exited unsuccessfully
+type ExecError struct {
+	ExitCode int
+	Err      error
+	// Noop is set if the exit code is one of the target's noop exit codes, the
+	// task succeeded without anything to do.
+	Noop bool
+}
+
+func (e *ExecError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying *exec.ExitError
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// execError converts the exit of an unsuccessful command into an ExecError,
+// other errors, such as the command not starting, are returned as they are.
+func execError(err error) error {
+	var ee *osexec.ExitError
+	if errors.As(err, &ee) {
+		return &ExecError{ExitCode: ee.ExitCode(), Err: err}
+	}
+	return err
 }
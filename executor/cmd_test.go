@@ -1,15 +1,20 @@
 package executor
 
 import (
+	"context"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/picostack/pico/secret/memory"
 	"github.com/picostack/pico/task"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	_ "github.com/picostack/pico/logger"
 )
@@ -26,7 +31,7 @@ func TestCommandExecutor(t *testing.T) {
 				"SOME_SECRET": "123",
 			},
 		},
-	}, false, "pico", "GLOBAL_")
+	}, false, "pico")
 	bus := make(chan task.ExecutionTask)
 
 	g := errgroup.Group{}
@@ -58,6 +63,31 @@ func TestCommandExecutor(t *testing.T) {
 	os.RemoveAll(".test/.git")
 }
 
+func TestCommandExecutorDisabled(t *testing.T) {
+	ce := NewCommandExecutor(&memory.MemorySecrets{}, false, "pico")
+	ce.SetEnabledFunc(func(target string) bool { return target != "test_disabled" })
+	bus := make(chan task.ExecutionTask, 2)
+
+	bus <- task.ExecutionTask{
+		Target: task.Target{Name: "test_disabled", Up: []string{"touch", "disabled"}},
+		Path:   "./.test",
+	}
+	bus <- task.ExecutionTask{
+		Target: task.Target{Name: "test_enabled", Up: []string{"touch", "enabled"}},
+		Path:   "./.test",
+	}
+	close(bus)
+
+	ce.Subscribe(bus)
+
+	_, err := os.Stat(".test/disabled")
+	assert.True(t, os.IsNotExist(err), "expected task for disabled target to be dropped")
+	_, err = os.Stat(".test/enabled")
+	assert.NoError(t, err)
+
+	os.Remove(".test/enabled")
+}
+
 func TestCommandPrepareWithoutPassthrough(t *testing.T) {
 	ce := NewCommandExecutor(&memory.MemorySecrets{
 		Secrets: map[string]map[string]string{
@@ -65,20 +95,25 @@ func TestCommandPrepareWithoutPassthrough(t *testing.T) {
 				"SOME_SECRET": "123",
 			},
 		},
-	}, false, "pico", "GLOBAL_")
+	}, false, "pico")
 
-	ex, err := ce.prepare("test", "./", false, map[string]string{
+	ex, err := ce.prepare(context.Background(), task.Target{Name: "test"}, "./", false, map[string]string{
 		"DATA_DIR": "/data/shared",
 	})
 	assert.NoError(t, err)
 	assert.Equal(t, exec{
 		path: "./",
+		dir:  "./",
 		env: map[string]string{
 			"SOME_SECRET": "123",
 			"DATA_DIR":    "/data/shared",
 		},
+		secrets: map[string]string{
+			"SOME_SECRET": "123",
+		},
 		shutdown:        false,
 		passEnvironment: false,
+		target:          task.Target{Name: "test"},
 	}, ex)
 }
 
@@ -93,20 +128,167 @@ func TestCommandPrepareWithGlobal(t *testing.T) {
 				"IGNORE":        "this",
 			},
 		},
-	}, false, "pico", "GLOBAL_")
+	}, false, "pico")
 
-	ex, err := ce.prepare("test", "./", false, map[string]string{
+	ex, err := ce.prepare(context.Background(), task.Target{Name: "test"}, "./", false, map[string]string{
 		"DATA_DIR": "/data/shared",
 	})
 	assert.NoError(t, err)
 	assert.Equal(t, exec{
 		path: "./",
+		dir:  "./",
 		env: map[string]string{
 			"SOME_SECRET": "123",
 			"SECRET":      "456",
 			"DATA_DIR":    "/data/shared",
 		},
+		secrets: map[string]string{
+			"SOME_SECRET": "123",
+			"SECRET":      "456",
+		},
 		shutdown:        false,
 		passEnvironment: false,
+		target:          task.Target{Name: "test"},
 	}, ex)
 }
+
+func TestCommandExecutorPlan(t *testing.T) {
+	ce := NewCommandExecutor(&memory.MemorySecrets{}, false, "pico")
+	ce.SetStartupParallelism(2)
+
+	var mu sync.Mutex
+	var events []string
+	running, most := 0, 0
+	ce.SetStartHandler(func(r Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, "start "+r.Task.Target.Name)
+		running++
+		if running > most {
+			most = running
+		}
+	})
+	ce.SetResultHandler(func(r Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.NoError(t, r.Err)
+		events = append(events, "finish "+r.Task.Target.Name)
+		running--
+	})
+
+	targets := []task.Target{
+		{Name: "network", Up: []string{"sleep", "0.3"}},
+		{Name: "cache", Up: []string{"sleep", "0.1"}},
+		{Name: "app", Up: []string{"true"}, DependsOn: []string{"network"}},
+		{Name: "worker", Up: []string{"true"}},
+	}
+	plan := &task.Plan{}
+	for _, target := range targets {
+		plan.Targets = append(plan.Targets, target.Name)
+	}
+	bus := make(chan task.ExecutionTask, len(targets))
+	for _, target := range targets {
+		bus <- task.ExecutionTask{Target: target, Path: "./.test", Plan: plan}
+	}
+	close(bus)
+
+	ce.Subscribe(bus)
+
+	index := func(event string) int {
+		for i, e := range events {
+			if e == event {
+				return i
+			}
+		}
+		t.Fatalf("no %s in %v", event, events)
+		return -1
+	}
+	assert.Len(t, events, 8)
+	assert.Equal(t, 2, most)
+	assert.ElementsMatch(t, []string{"start network", "start cache"}, events[:2])
+	assert.Less(t, index("finish network"), index("start app"))
+}
+
+func TestCommandExecutorExitCode(t *testing.T) {
+	ce := NewCommandExecutor(&memory.MemorySecrets{}, false, "pico")
+	target := task.Target{Name: "test_exit", Up: []string{"sh", "-c", "exit 3"}}
+
+	err := ce.execute(context.Background(), target, "./.test", "", false, nil, nil)
+	var ee *ExecError
+	if assert.True(t, errors.As(err, &ee)) {
+		assert.Equal(t, 3, ee.ExitCode)
+	}
+	assert.EqualError(t, err, "exit status 3")
+
+	// commands that can't be started didn't exit
+	target.Up = []string{"pico-command-does-not-exist"}
+	err = ce.execute(context.Background(), target, "./.test", "", false, nil, nil)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &ee))
+}
+
+func TestCommandExecutorNoopExitCode(t *testing.T) {
+	ce := NewCommandExecutor(&memory.MemorySecrets{}, false, "pico")
+	var results []Result
+	ce.SetResultHandler(func(r Result) { results = append(results, r) })
+
+	bus := make(chan task.ExecutionTask, 3)
+	bus <- task.ExecutionTask{Target: task.Target{Name: "noop", Up: []string{"sh", "-c", "exit 3"}, NoopExitCodes: []int{3}}, Path: "./.test"}
+	bus <- task.ExecutionTask{Target: task.Target{Name: "unlisted", Up: []string{"sh", "-c", "exit 4"}, NoopExitCodes: []int{3}}, Path: "./.test"}
+	bus <- task.ExecutionTask{Target: task.Target{Name: "init", Init: []string{"sh", "-c", "exit 3"}, Up: []string{"true"}, NoopExitCodes: []int{3}}, Path: "./.test"}
+	close(bus)
+	ce.Subscribe(bus)
+
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, task.ResultNoop, results[0].Status())
+	assert.EqualError(t, results[1].Err, "exit status 4")
+	assert.Equal(t, task.ResultFailure, results[1].Status())
+	// only the up and down commands can have nothing to do
+	assert.Equal(t, task.ResultFailure, results[2].Status())
+}
+
+func TestCommandExecutorOutputRedacted(t *testing.T) {
+	ce := NewCommandExecutor(&memory.MemorySecrets{
+		Secrets: map[string]map[string]string{
+			"leaky": {"TOKEN": "s3cr3t-passw0rd-value"},
+		},
+	}, false, "pico")
+	ce.SetOutputLimit(20)
+	var results []Result
+	ce.SetResultHandler(func(r Result) { results = append(results, r) })
+
+	// the secret straddles the end of the kept head of the output
+	bus := make(chan task.ExecutionTask, 1)
+	bus <- task.ExecutionTask{Target: task.Target{Name: "leaky", Up: []string{"sh", "-c", `printf '0123456%s%040d' "$TOKEN" 0`}}, Path: "./.test"}
+	close(bus)
+	ce.Subscribe(bus)
+
+	require.Len(t, results, 1)
+	assert.NoError(t, results[0].Err)
+	assert.True(t, strings.HasPrefix(results[0].Output, "0123456[RE"), results[0].Output)
+	assert.NotContains(t, results[0].Output, "s3c")
+}
+
+func TestCommandPreparePassEnvironment(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		global bool
+		target *bool
+		want   bool
+	}{
+		{"default", false, nil, false},
+		{"global", true, nil, true},
+		{"target enables", false, &yes, true},
+		{"target disables", true, &no, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ce := NewCommandExecutor(&memory.MemorySecrets{}, tt.global, "pico")
+			ex, err := ce.prepare(context.Background(), task.Target{Name: "test", PassEnvironment: tt.target}, "./", false, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, ex.passEnvironment)
+		})
+	}
+}
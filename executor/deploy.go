@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"context"
+	"os"
+
+	"github.com/picostack/pico/task"
+)
+
+// deployVarsKey is the context key of the variables describing the task being
+// executed
+type deployVarsKey struct{}
+
+// deployVars returns the PICO_* variables describing a task to its commands,
+// every one is set, empty if it's unknown, such as the previous commit of a
+// target that was never deployed.
+func (e *CommandExecutor) deployVars(t task.ExecutionTask, commit string) map[string]string {
+	branch := t.Target.Branch
+	if branch == "" {
+		branch = task.HeadBranch(t.Path)
+	}
+	var previous string
+	if e.applied != nil {
+		previous = e.applied(t.Target.Name)
+	}
+	hostname := e.hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname() //nolint:errcheck
+	}
+	short := commit
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	return map[string]string{
+		"PICO_TARGET":          t.Target.Name,
+		"PICO_COMMIT":          commit,
+		"PICO_COMMIT_SHORT":    short,
+		"PICO_BRANCH":          branch,
+		"PICO_TRIGGER":         string(t.Trigger),
+		"PICO_PREVIOUS_COMMIT": previous,
+		"PICO_HOSTNAME":        hostname,
+		"PICO_RUN_ID":          t.ID,
+	}
+}
+
+func withDeployVars(ctx context.Context, vars map[string]string) context.Context {
+	return context.WithValue(ctx, deployVarsKey{}, vars)
+}
+
+// deployVarsOf returns the variables describing the task being executed with
+// ctx, none outside of a task.
+func deployVarsOf(ctx context.Context) map[string]string {
+	vars, _ := ctx.Value(deployVarsKey{}).(map[string]string)
+	return vars
+}
+
+// withoutVars returns a copy of env without the variables in vars. The target's
+// env is set again when its commands run, so it mustn't replace the variables
+// describing the task.
+func withoutVars(env, vars map[string]string) map[string]string {
+	if len(vars) == 0 {
+		return env
+	}
+	out := make(map[string]string, len(env))
+	for k, v := range env {
+		if _, ok := vars[k]; !ok {
+			out[k] = v
+		}
+	}
+	return out
+}
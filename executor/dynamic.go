@@ -0,0 +1,129 @@
+package executor
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/redact"
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/task"
+)
+
+// leases tracks the dynamic credential leases issued for the most recent task
+// of each target so they can be revoked when the target is shut down. Leases
+// from earlier tasks are left to expire.
+type leases struct {
+	mu      sync.Mutex
+	targets map[string][]lease
+}
+
+// lease is a dynamic credential lease and the values it issued, which are
+// redacted until it's revoked or expires
+type lease struct {
+	id     string
+	values []string
+}
+
+func (l *leases) set(target string, issued []lease) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.targets == nil {
+		l.targets = make(map[string][]lease)
+	}
+	l.targets[target] = issued
+}
+
+func (l *leases) take(target string) []lease {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	issued := l.targets[target]
+	delete(l.targets, target)
+	return issued
+}
+
+// issueCredentials obtains the target's dynamic secrets, the keys of each set
+// of credentials are upper-cased and prefixed with the name it's declared as.
+// If any can't be obtained, those already issued are revoked.
+func (e *CommandExecutor) issueCredentials(ctx context.Context, t task.Target) (env map[string]string, err error) {
+	if len(t.DynamicSecrets) == 0 {
+		return nil, nil
+	}
+	store, ok := secret.Base(e.secrets.store).(secret.DynamicStore)
+	if !ok {
+		return nil, errors.New("target has dynamic_secrets but the secret store can't issue credentials")
+	}
+
+	names := make([]string, 0, len(t.DynamicSecrets))
+	for name := range t.DynamicSecrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env = make(map[string]string)
+	var issued []lease
+	defer func() {
+		if err != nil {
+			revoke(ctx, store, issued)
+		}
+	}()
+	for _, name := range names {
+		ref := t.DynamicSecrets[name]
+		if !strings.HasPrefix(ref, secret.DynamicPrefix) {
+			return nil, errors.Errorf("dynamic secret '%s' must start with %s", name, secret.DynamicPrefix)
+		}
+		creds, err := store.IssueCredentials(ctx, strings.TrimPrefix(ref, secret.DynamicPrefix))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to obtain dynamic secret '%s'", name)
+		}
+		logger(ctx).Info("obtained dynamic credentials for target",
+			zap.String("name", name),
+			zap.String("lease_id", creds.LeaseID),
+			zap.Duration("ttl", creds.TTL))
+		values := make([]string, 0, len(creds.Data))
+		for k, v := range creds.Data {
+			env[name+"_"+strings.ToUpper(k)] = v
+			values = append(values, v)
+		}
+		if creds.TTL > 0 {
+			redact.AddExpiring(creds.TTL, values...)
+		} else {
+			redact.Add(values...)
+		}
+		if creds.LeaseID != "" {
+			issued = append(issued, lease{creds.LeaseID, values})
+		}
+	}
+	e.leases.set(t.Name, issued)
+	return env, nil
+}
+
+// revokeCredentials revokes the leases issued for the target's last task
+func (e *CommandExecutor) revokeCredentials(ctx context.Context, t task.Target) {
+	issued := e.leases.take(t.Name)
+	if len(issued) == 0 {
+		return
+	}
+	store, ok := secret.Base(e.secrets.store).(secret.DynamicStore)
+	if !ok {
+		return
+	}
+	revoke(ctx, store, issued)
+}
+
+// revoke revokes leases, their values are no longer redacted once revoked
+func revoke(ctx context.Context, store secret.DynamicStore, issued []lease) {
+	for _, l := range issued {
+		if err := store.RevokeLease(ctx, l.id); err != nil {
+			logger(ctx).Warn("failed to revoke dynamic credentials, they will expire",
+				zap.String("lease_id", l.id),
+				zap.Error(err))
+			continue
+		}
+		redact.Remove(l.values...)
+	}
+}
@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/redact"
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+type fakeDynamic struct {
+	memory.MemorySecrets
+	issued  []string
+	revoked []string
+}
+
+func (f *fakeDynamic) IssueCredentials(ctx context.Context, path string) (secret.Credentials, error) {
+	if path == "database/creds/missing" {
+		return secret.Credentials{}, errors.New("unknown role")
+	}
+	f.issued = append(f.issued, path)
+	return secret.Credentials{
+		LeaseID: "lease-" + path,
+		TTL:     time.Hour,
+		Data:    map[string]string{"username": "v-app", "password": "generated"},
+	}, nil
+}
+
+func (f *fakeDynamic) RevokeLease(ctx context.Context, id string) error {
+	f.revoked = append(f.revoked, id)
+	return nil
+}
+
+func TestDynamicSecrets(t *testing.T) {
+	store := &fakeDynamic{}
+	ce := NewCommandExecutor(store, false, "pico")
+	target := task.Target{
+		Name:           "app",
+		DynamicSecrets: map[string]string{"DB": "vault-dynamic:database/creds/app"},
+	}
+
+	ex, err := ce.prepare(context.Background(), target, "./", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v-app", ex.env["DB_USERNAME"])
+	assert.Equal(t, "generated", ex.env["DB_PASSWORD"])
+	assert.Equal(t, []string{"database/creds/app"}, store.issued)
+	assert.Equal(t, redact.Replacement, redact.String("generated"))
+
+	// shutdown doesn't issue new credentials and revokes the last ones
+	_, err = ce.prepare(context.Background(), target, "./", true, nil)
+	assert.NoError(t, err)
+	ce.revokeCredentials(context.Background(), target)
+	assert.Equal(t, []string{"database/creds/app"}, store.issued)
+	assert.Equal(t, []string{"lease-database/creds/app"}, store.revoked)
+	assert.Equal(t, "generated", redact.String("generated"), "revoked credentials aren't redacted")
+}
+
+func TestDynamicSecretsFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   secret.Store
+		ref     string
+		wantErr string
+	}{
+		{"unsupported", &memory.MemorySecrets{}, "vault-dynamic:database/creds/app", "target has dynamic_secrets but the secret store can't issue credentials"},
+		{"prefix", &fakeDynamic{}, "database/creds/app", "dynamic secret 'DB' must start with vault-dynamic:"},
+		{"issue", &fakeDynamic{}, "vault-dynamic:database/creds/missing", "failed to obtain dynamic secret 'DB': unknown role"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ce := NewCommandExecutor(tt.store, false, "pico")
+			_, err := ce.prepare(context.Background(), task.Target{Name: "app", DynamicSecrets: map[string]string{"DB": tt.ref}}, "./", false, nil)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestDynamicSecretsRevokedOnFailure(t *testing.T) {
+	store := &fakeDynamic{}
+	ce := NewCommandExecutor(store, false, "pico")
+	target := task.Target{
+		Name: "app",
+		DynamicSecrets: map[string]string{
+			"A": "vault-dynamic:database/creds/app",
+			"B": "vault-dynamic:database/creds/missing",
+		},
+	}
+
+	_, err := ce.prepare(context.Background(), target, "./", false, nil)
+	assert.EqualError(t, err, "failed to obtain dynamic secret 'B': unknown role")
+	assert.Equal(t, []string{"database/creds/app"}, store.issued)
+	assert.Equal(t, []string{"lease-database/creds/app"}, store.revoked)
+}
@@ -0,0 +1,142 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// envSource is where a variable of a command's environment is defined. Sources
+// are listed in increasing order of precedence, a variable defined by more than
+// one source takes the value of the last.
+type envSource int
+
+const (
+	// sourceHost is Pico's own environment, inherited by targets that pass it
+	sourceHost envSource = iota
+	// sourceDefault are defaults set by Pico, such as the compose project name
+	// of a target that shares its clone
+	sourceDefault
+	sourceEnvFile
+	sourceGlobalSecret
+	sourceConfig // the environment of the configuration
+	sourceTargetSecret
+	sourceCredential // dynamic secrets issued for the task
+	sourceTarget     // the target's env
+	// sourceDeploy are the PICO_* variables describing the task, which always
+	// take precedence so commands can rely on them
+	sourceDeploy
+)
+
+var envSourceNames = []string{
+	"host",
+	"default",
+	"env_file",
+	"global_secret",
+	"config",
+	"target_secret",
+	"dynamic_credential",
+	"target_env",
+	"pico",
+}
+
+func (s envSource) String() string {
+	return envSourceNames[s]
+}
+
+// envOverride is a variable defined with different values by two sources
+type envOverride struct {
+	key    string
+	winner envSource
+	loser  envSource
+}
+
+func (o envOverride) String() string {
+	return fmt.Sprintf("%s is defined by both %s and %s", o.key, o.loser, o.winner)
+}
+
+// environment builds the environment of a target's commands from its sources,
+// recording which variables each source overrides.
+type environment struct {
+	vars      map[string]string
+	sources   map[string]envSource
+	overrides []envOverride
+}
+
+func newEnvironment() *environment {
+	return &environment{
+		vars:    make(map[string]string),
+		sources: make(map[string]envSource),
+	}
+}
+
+// add sets the variables of a source, sources must be added in increasing
+// order of precedence. A variable already set to the same value isn't an
+// override as nothing is shadowed.
+func (e *environment) add(source envSource, vars map[string]string) {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := vars[k]
+		if previous, ok := e.vars[k]; ok && previous != v && e.sources[k] != source {
+			e.overrides = append(e.overrides, envOverride{k, source, e.sources[k]})
+		}
+		e.vars[k] = v
+		e.sources[k] = source
+	}
+}
+
+// inherit records the variables of Pico's own environment that are overridden,
+// the host environment has the lowest precedence and is inherited by the
+// command itself rather than added to the variables.
+func (e *environment) inherit(environ []string) {
+	var overrides []envOverride
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if v, ok := e.vars[parts[0]]; ok && v != parts[1] {
+			overrides = append(overrides, envOverride{parts[0], e.sources[parts[0]], sourceHost})
+		}
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].key < overrides[j].key })
+	e.overrides = append(overrides, e.overrides...)
+}
+
+// report logs every overridden variable, without values since they may be
+// secrets. Under strict mode, overrides are returned as an error instead, apart
+// from those of Pico's defaults, which are meant to be overridden, and of the
+// variables describing the task, which can't be.
+func (e *environment) report(log *zap.Logger, strict bool) error {
+	var conflicts []string
+	for _, o := range e.overrides {
+		if o.winner == sourceDeploy {
+			log.Warn("environment variable is reserved by Pico and was replaced",
+				zap.String("key", o.key),
+				zap.String("overridden", o.loser.String()))
+			continue
+		}
+		log.Info("environment variable overridden",
+			zap.String("key", o.key),
+			zap.String("source", o.winner.String()),
+			zap.String("overridden", o.loser.String()))
+		if o.winner != sourceDefault && o.loser != sourceDefault {
+			conflicts = append(conflicts, o.String())
+		}
+	}
+	if strict && len(conflicts) > 0 {
+		return errors.Errorf("conflicting environment variables: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// hostEnviron is Pico's own environment, replaced in tests
+var hostEnviron = os.Environ
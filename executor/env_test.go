@@ -0,0 +1,118 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+func TestEnvironmentPrecedence(t *testing.T) {
+	var sources []envSource
+	for s := sourceHost; s <= sourceDeploy; s++ {
+		sources = append(sources, s)
+	}
+
+	for i, loser := range sources {
+		for _, winner := range sources[i+1:] {
+			t.Run(fmt.Sprintf("%s/%s", loser, winner), func(t *testing.T) {
+				env := newEnvironment()
+				if loser != sourceHost {
+					env.add(loser, map[string]string{"DATABASE_URL": "loser", "SAME": "value"})
+				}
+				env.add(winner, map[string]string{"DATABASE_URL": "winner", "SAME": "value"})
+				if loser == sourceHost {
+					env.inherit([]string{"DATABASE_URL=loser", "SAME=value", "PATH=/bin"})
+				}
+
+				assert.Equal(t, "winner", env.vars["DATABASE_URL"])
+				assert.Equal(t, []envOverride{{"DATABASE_URL", winner, loser}}, env.overrides)
+				assert.NoError(t, env.report(zap.L(), false))
+
+				// defaults are meant to be overridden and the variables describing
+				// the task can't be, even in strict mode
+				err := env.report(zap.L(), true)
+				if loser == sourceDefault || winner == sourceDefault || winner == sourceDeploy {
+					assert.NoError(t, err)
+				} else {
+					assert.EqualError(t, err, fmt.Sprintf("conflicting environment variables: DATABASE_URL is defined by both %s and %s", loser, winner))
+				}
+			})
+		}
+	}
+}
+
+func TestCommandPrepareStrictEnv(t *testing.T) {
+	defer func(e func() []string) { hostEnviron = e }(hostEnviron)
+	hostEnviron = func() []string { return []string{"LOG_LEVEL=debug", "HOME=/root"} }
+
+	ce := NewCommandExecutor(&memory.MemorySecrets{
+		Secrets: map[string]map[string]string{
+			"app":  {"DATABASE_URL": "postgres://target"},
+			"pico": {"GLOBAL_DATABASE_URL": "postgres://global"},
+		},
+	}, true, "pico")
+	target := task.Target{Name: "app", Env: map[string]string{"LOG_LEVEL": "info"}}
+
+	// the source with the highest precedence wins
+	ex, err := ce.prepare(context.Background(), target, "./", false, map[string]string{"DATABASE_URL": "postgres://config"})
+	assert.NoError(t, err)
+	assert.Equal(t, "postgres://target", ex.env["DATABASE_URL"])
+	assert.Equal(t, "info", ex.env["LOG_LEVEL"])
+
+	ce.SetStrictEnv(true)
+	_, err = ce.prepare(context.Background(), target, "./", false, map[string]string{"DATABASE_URL": "postgres://config"})
+	assert.EqualError(t, err, "conflicting environment variables: "+
+		"LOG_LEVEL is defined by both host and target_env; "+
+		"DATABASE_URL is defined by both global_secret and config; "+
+		"DATABASE_URL is defined by both config and target_secret")
+}
+
+func TestDeployVars(t *testing.T) {
+	ce := NewCommandExecutor(&memory.MemorySecrets{}, false, "pico")
+	ce.SetHostname("host-1")
+	ce.SetAppliedFunc(func(target string) string { return "0123456789abcdef" })
+	var outputs []string
+	ce.SetResultHandler(func(r Result) {
+		assert.NoError(t, r.Err)
+		outputs = append(outputs, r.Output)
+	})
+
+	printVars := []string{"sh", "-c", "env | grep ^PICO_ | sort"}
+	target := task.Target{
+		Name:   "app",
+		Branch: "main",
+		Up:     printVars,
+		Down:   printVars,
+		Env:    map[string]string{"PICO_COMMIT": "mine", "APP_ENV": "prod"},
+	}
+	bus := make(chan task.ExecutionTask, 2)
+	bus <- task.ExecutionTask{ID: "run-1", Target: target, Path: "./.test", Commit: "fedcba9876543210", Trigger: task.TriggerWebhook}
+	bus <- task.ExecutionTask{ID: "run-2", Target: target, Path: "./.test", Commit: "fedcba9876543210", Trigger: task.TriggerConfig, Shutdown: true}
+	close(bus)
+	ce.Subscribe(bus)
+
+	// Pico's values replace the target's own
+	assert.Equal(t, []string{`PICO_BRANCH=main
+PICO_COMMIT=fedcba9876543210
+PICO_COMMIT_SHORT=fedcba9
+PICO_HOSTNAME=host-1
+PICO_PREVIOUS_COMMIT=0123456789abcdef
+PICO_RUN_ID=run-1
+PICO_TARGET=app
+PICO_TRIGGER=webhook
+`, `PICO_BRANCH=main
+PICO_COMMIT=fedcba9876543210
+PICO_COMMIT_SHORT=fedcba9
+PICO_HOSTNAME=host-1
+PICO_PREVIOUS_COMMIT=0123456789abcdef
+PICO_RUN_ID=run-2
+PICO_TARGET=app
+PICO_TRIGGER=config
+`}, outputs)
+}
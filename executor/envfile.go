@@ -0,0 +1,122 @@
+package executor
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/task"
+)
+
+// envKey is the format of variable names in env files
+var envKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// readEnvFiles reads the target's env files from its checkout, later files
+// take precedence over earlier ones. Every listed file must exist.
+func readEnvFiles(path string, files []string) (map[string]string, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	env := make(map[string]string)
+	for _, name := range files {
+		if !task.Contained(name) {
+			return nil, errors.Errorf("env file path must be inside the repository: %s", name)
+		}
+		vars, err := readEnvFile(filepath.Join(path, name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read env file %s", name)
+		}
+		for k, v := range vars {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+// readEnvFile parses a file in dotenv syntax: KEY=value lines, optionally
+// prefixed with export, where values may be single quoted to be taken as they
+// are or double quoted to allow escapes such as \n. Blank lines and lines
+// starting with # are ignored, as are comments after unquoted values.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, err := parseEnvLine(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", n)
+		}
+		env[key] = value
+	}
+	return env, scanner.Err()
+}
+
+func parseEnvLine(line string) (key, value string, err error) {
+	line = strings.TrimPrefix(line, "export ")
+	i := strings.IndexRune(line, '=')
+	if i < 0 {
+		return "", "", errors.New("expected KEY=value")
+	}
+	key = strings.TrimSpace(line[:i])
+	if !envKey.MatchString(key) {
+		return "", "", errors.Errorf("invalid variable name '%s'", key)
+	}
+	value = strings.TrimSpace(line[i+1:])
+
+	if value == "" {
+		return key, "", nil
+	}
+	switch quote := value[0]; quote {
+	case '\'', '"':
+		end := closingQuote(value, quote)
+		if end < 0 {
+			return "", "", errors.Errorf("unterminated quoted value of %s", key)
+		}
+		rest := strings.TrimSpace(value[end+1:])
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", "", errors.Errorf("unexpected characters after quoted value of %s", key)
+		}
+		value = value[1:end]
+		if quote == '"' {
+			value = unescape(value)
+		}
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+	}
+	return key, value, nil
+}
+
+// closingQuote returns the index of the quote closing the value, double quotes
+// may be escaped with a backslash.
+func closingQuote(value string, quote byte) int {
+	for i := 1; i < len(value); i++ {
+		switch {
+		case quote == '"' && value[i] == '\\':
+			i++
+		case value[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+var escapes = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`, `\$`, `$`)
+
+func unescape(value string) string {
+	return escapes.Replace(value)
+}
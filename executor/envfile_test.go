@@ -0,0 +1,80 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+func TestParseEnvLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		key   string
+		value string
+		err   string
+	}{
+		{`TAG=1.2.3`, "TAG", "1.2.3", ""},
+		{`export TAG=1.2.3`, "TAG", "1.2.3", ""},
+		{`TAG = 1.2.3 # pinned`, "TAG", "1.2.3", ""},
+		{`URL=http://host/#anchor`, "URL", "http://host/#anchor", ""},
+		{`EMPTY=`, "EMPTY", "", ""},
+		{`NAME='it''s'`, "", "", "unexpected characters after quoted value of NAME"},
+		{`NAME='$HOME \n'`, "NAME", `$HOME \n`, ""},
+		{`NAME="a \"b\"\nc" # comment`, "NAME", "a \"b\"\nc", ""},
+		{`NAME="open`, "", "", "unterminated quoted value of NAME"},
+		{`just words`, "", "", "expected KEY=value"},
+		{`1BAD=x`, "", "", "invalid variable name '1BAD'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			key, value, err := parseEnvLine(tt.line)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.key, key)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+}
+
+func TestCommandPrepareEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-env-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "deploy"), os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".env"), []byte("# defaults\nTAG=latest\nDOMAIN=example.com\nSECRET=from-file\n\nREPLICAS=1\n"), 0o600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "deploy", ".env"), []byte("export REPLICAS=3\n"), 0o600))
+
+	ce := NewCommandExecutor(&memory.MemorySecrets{
+		Secrets: map[string]map[string]string{"test": {"SECRET": "from-store"}},
+	}, false, "pico")
+	target := task.Target{Name: "test", EnvFile: []string{".env", "deploy/.env"}}
+	ex, err := ce.prepare(context.Background(), target, dir, false, map[string]string{"DOMAIN": "example.org"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"TAG":      "latest",
+		"DOMAIN":   "example.org",
+		"SECRET":   "from-store",
+		"REPLICAS": "3",
+	}, ex.env)
+
+	target.EnvFile = []string{".env", "missing.env"}
+	_, err = ce.prepare(context.Background(), target, dir, false, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read env file missing.env")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".env"), []byte("TAG=latest\n\nnot a variable\n"), 0o600))
+	target.EnvFile = []string{".env"}
+	_, err = ce.prepare(context.Background(), target, dir, false, nil)
+	assert.EqualError(t, err, "failed to read env file .env: line 3: expected KEY=value")
+}
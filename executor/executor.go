@@ -3,10 +3,107 @@
 // execute them as they arrive.
 package executor
 
-import "github.com/picostack/pico/task"
+import (
+	"context"
+	"time"
+
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
 
 // Executor describes a type that can handle events and react to them. An
-// executor is also responsible for hydrating a target with secrets.
+// executor is also responsible for hydrating a target with secrets, usually
+// with a SecretResolver.
 type Executor interface {
-	Subscribe(chan task.ExecutionTask)
+	// Subscribe executes tasks received on the bus until it's closed.
+	Subscribe(bus <-chan task.ExecutionTask)
+
+	// SetContext is called before Subscribe with a context that's done when
+	// Pico is shutting down, running tasks should be stopped.
+	SetContext(ctx context.Context)
+
+	// SetResultHandler is called before Subscribe with a function that must be
+	// called with the result of every executed task. It's used for history,
+	// metrics and notifications and should not be called concurrently.
+	SetResultHandler(func(Result))
+}
+
+// Waiting is a task that was received but hasn't started executing yet
+type Waiting struct {
+	Target string
+	Mutex  string    // the target's mutex, if it has one
+	Since  time.Time // when the task was received
+}
+
+// WaitReporter is implemented by executors that can report the tasks waiting
+// to be executed.
+type WaitReporter interface {
+	Waiting() []Waiting
+}
+
+// EnabledSetter is implemented by executors that drop the tasks of disabled
+// targets. SetEnabledFunc is called before Subscribe with a function that
+// reports whether a target is enabled.
+type EnabledSetter interface {
+	SetEnabledFunc(enabled func(target string) bool)
+}
+
+// Holder is implemented by executors that can hold back the tasks of targets
+// while they're reconfigured. Hold returns once no task of the targets is
+// executing, and until release is called none start. Tasks created before
+// the hold are dropped since they carry the targets' previous definitions.
+type Holder interface {
+	Hold(targets []string) (release func())
+}
+
+// DefaultOutputLimit is how many bytes of a task's output are kept by default
+const DefaultOutputLimit = 4 << 20
+
+// Result describes the outcome of a single executed task
+type Result struct {
+	Task     task.ExecutionTask
+	Commit   string    // the commit checked out when the task was executed
+	Queued   time.Time // when the task was received from the bus
+	Started  time.Time
+	Finished time.Time
+	Err      error
+	// Noop is set if the task's command exited with one of its target's noop
+	// exit codes, Err is nil since the task counts as a success.
+	Noop bool
+	// Suppressed is why the task was recorded without being executed, such as
+	// the instance being in standby.
+	Suppressed string
+
+	// Directory is where the task's commands ran, the target's subpath of its
+	// clone or of the task's dedicated checkout.
+	Directory string
+
+	// Output is the redacted output of the task's command, with the middle
+	// dropped beyond the output limit. OutputBytes is the full size.
+	Output      string
+	OutputBytes int64
+
+	// StaleSecrets is set if the secret store was unavailable and secrets
+	// fetched earlier were used, it's the time they were fetched.
+	StaleSecrets *time.Time
+
+	// Images are the images running in the target's compose project after a
+	// successful deploy, when there's a Docker daemon to list them from.
+	// Project is the name of that compose project, set if it has containers.
+	Images  []state.Image
+	Project string
+}
+
+// Status labels the result as one of task.ResultSuccess, ResultFailure,
+// ResultNoop or ResultSuppressed.
+func (r Result) Status() string {
+	switch {
+	case r.Suppressed != "":
+		return task.ResultSuppressed
+	case r.Err != nil:
+		return task.ResultFailure
+	case r.Noop:
+		return task.ResultNoop
+	}
+	return task.ResultSuccess
 }
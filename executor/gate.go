@@ -0,0 +1,118 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// gate coordinates tasks with reconfigurations of their targets. While a target
+// is held no task for it starts, and a hold only takes effect once the tasks
+// already executing for the target have finished or, if cancel is set, have
+// been stopped. Tasks created before the hold are dropped, as they carry the
+// target's previous definition.
+type gate struct {
+	cancel bool
+
+	mu      sync.Mutex
+	changed *sync.Cond
+	running map[string]map[*inflight]struct{}
+	held    map[string]int
+	since   map[string]time.Time // when each target was last held
+}
+
+type inflight struct {
+	cancel context.CancelFunc
+}
+
+func newGate() *gate {
+	g := &gate{
+		running: make(map[string]map[*inflight]struct{}),
+		held:    make(map[string]int),
+		since:   make(map[string]time.Time),
+	}
+	g.changed = sync.NewCond(&g.mu)
+	return g
+}
+
+// enter waits until the target isn't held and registers a task for it as
+// executing. The returned context is cancelled if a hold cancels the task and
+// done must be called once it has finished. It returns false, without waiting,
+// for a task created before the target was last held.
+func (g *gate) enter(ctx context.Context, target string, created time.Time) (context.Context, func(), bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.held[target] > 0 {
+		g.changed.Wait()
+	}
+	if created.Before(g.since[target]) {
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	f := &inflight{cancel: cancel}
+	if g.running[target] == nil {
+		g.running[target] = make(map[*inflight]struct{})
+	}
+	g.running[target][f] = struct{}{}
+
+	return ctx, func() {
+		cancel()
+		g.mu.Lock()
+		delete(g.running[target], f)
+		if len(g.running[target]) == 0 {
+			delete(g.running, target)
+		}
+		g.mu.Unlock()
+		g.changed.Broadcast()
+	}, true
+}
+
+// hold keeps tasks of the targets from starting until release is called, it
+// returns once none of them are executing.
+func (g *gate) hold(targets []string) (release func()) {
+	g.mu.Lock()
+	now := time.Now()
+	for _, t := range targets {
+		g.held[t]++
+		g.since[t] = now
+		if len(g.running[t]) == 0 {
+			continue
+		}
+		if g.cancel {
+			zap.L().Info("cancelling task of reconfigured target", zap.String("target", t))
+			for f := range g.running[t] {
+				f.cancel()
+			}
+		} else {
+			zap.L().Info("waiting for task of reconfigured target to finish", zap.String("target", t))
+		}
+	}
+	for g.anyRunning(targets) {
+		g.changed.Wait()
+	}
+	g.mu.Unlock()
+
+	return func() {
+		g.mu.Lock()
+		for _, t := range targets {
+			if g.held[t]--; g.held[t] <= 0 {
+				delete(g.held, t)
+			}
+		}
+		g.mu.Unlock()
+		g.changed.Broadcast()
+	}
+}
+
+func (g *gate) anyRunning(targets []string) bool {
+	for _, t := range targets {
+		if len(g.running[t]) > 0 {
+			return true
+		}
+	}
+	return false
+}
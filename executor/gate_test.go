@@ -0,0 +1,113 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+func TestGateDropsStaleTasks(t *testing.T) {
+	g := newGate()
+	created := time.Now()
+	release := g.hold([]string{"app"})
+	release()
+
+	_, _, ok := g.enter(context.Background(), "app", created)
+	assert.False(t, ok, "task created before the hold")
+	_, done, ok := g.enter(context.Background(), "app", time.Now())
+	assert.True(t, ok, "task created after the hold")
+	done()
+	_, done, ok = g.enter(context.Background(), "other", created)
+	assert.True(t, ok, "task of another target")
+	done()
+}
+
+func TestGateCancels(t *testing.T) {
+	g := newGate()
+	g.cancel = true
+	ctx, done, ok := g.enter(context.Background(), "app", time.Now())
+	require.True(t, ok)
+	go func() {
+		<-ctx.Done()
+		done()
+	}()
+
+	release := g.hold([]string{"app"})
+	assert.Error(t, ctx.Err())
+	release()
+}
+
+// TestReconfigureStress reconfigures a target repeatedly while its slow tasks
+// execute. The directory's `current` file stands in for the definition applied
+// by the watcher, every task must see the definition it was queued with from
+// start to finish.
+func TestReconfigureStress(t *testing.T) {
+	for _, cancel := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cancel=%v", cancel), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "pico-reconfigure")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			current := filepath.Join(dir, "current")
+			apply := func(gen int) {
+				require.NoError(t, ioutil.WriteFile(current, []byte(strconv.Itoa(gen)), 0o644))
+			}
+			definition := func(gen int) task.Target {
+				return task.Target{
+					Name: "app",
+					Env:  map[string]string{"GEN": strconv.Itoa(gen)},
+					// only builtins, so a cancelled task leaves no processes behind
+					Up: []string{"sh", "-c", `read a < current; i=0; while [ $i -lt 5000 ]; do i=$((i+1)); done; read b < current
+if [ "$a" != "$GEN" ] || [ "$b" != "$GEN" ]; then echo "$GEN $a $b" >> violations; fi`},
+				}
+			}
+
+			ce := NewCommandExecutor(&memory.MemorySecrets{}, true, "pico")
+			ce.SetCancelOnReconfigure(cancel)
+			var mu sync.Mutex
+			executed := 0
+			ce.SetResultHandler(func(r Result) {
+				mu.Lock()
+				executed++
+				mu.Unlock()
+			})
+
+			bus := make(chan task.ExecutionTask, 100)
+			finished := make(chan struct{})
+			go func() {
+				ce.Subscribe(bus)
+				close(finished)
+			}()
+
+			apply(0)
+			for gen := 0; gen < 20; gen++ {
+				for i := 0; i < 3; i++ {
+					bus <- task.ExecutionTask{Target: definition(gen), Path: dir, Created: time.Now()}
+				}
+				time.Sleep(time.Duration(rand.Intn(40)) * time.Millisecond)
+				release := ce.Hold([]string{"app"})
+				apply(gen + 1)
+				release()
+			}
+			bus <- task.ExecutionTask{Target: definition(20), Path: dir, Created: time.Now()}
+			close(bus)
+			<-finished
+
+			violations, err := ioutil.ReadFile(filepath.Join(dir, "violations"))
+			assert.True(t, os.IsNotExist(err), "tasks ran with mixed definitions: %s", violations)
+			assert.NotZero(t, executed)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package executor
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/state"
+)
+
+// DefaultHistorySize is the number of executions kept per target by default
+const DefaultHistorySize = 20
+
+// History keeps the most recent executions of each target, up to a fixed
+// number per target. If it's given a store, executions are persisted to it and
+// loaded from it so they survive restarts.
+type History struct {
+	size  int
+	store *state.Store
+
+	mu      sync.RWMutex
+	records map[string][]state.Execution
+}
+
+// NewHistory creates a history of size executions per target, the store may be
+// nil to only keep executions in memory.
+func NewHistory(size int, store *state.Store) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	h := &History{
+		size:    size,
+		store:   store,
+		records: make(map[string][]state.Execution),
+	}
+	if store != nil {
+		for name, records := range store.History() {
+			h.records[name] = h.trim(records)
+		}
+	}
+	return h
+}
+
+// Add records the result of an executed task. The previous commit is taken from
+// the target's last successful deploy, or from the store if there is none yet.
+func (h *History) Add(r Result) {
+	name := r.Task.Target.Name
+	record := state.Execution{
+		TaskID:        r.Task.ID,
+		Commit:        r.Commit,
+		Trigger:       string(r.Task.Trigger),
+		TriggerDetail: r.Task.Detail,
+		Shutdown:      r.Task.Shutdown,
+		Queued:        r.Queued,
+		Started:       r.Started,
+		Finished:      r.Finished,
+		OutputBytes:   r.OutputBytes,
+		Images:        r.Images,
+		Directory:     r.Directory,
+		Noop:          r.Noop,
+		Suppressed:    r.Suppressed,
+	}
+	if r.Err != nil {
+		record.Error = r.Err.Error()
+	}
+
+	h.mu.Lock()
+	records := h.records[name]
+	record.Previous = previous(records)
+	if record.Previous == "" && h.store != nil {
+		record.Previous = h.store.Applied(name)
+	}
+	records = h.trim(append(records, record))
+	h.records[name] = records
+	h.mu.Unlock()
+
+	if h.store == nil {
+		return
+	}
+	if err := h.store.SetHistory(name, records); err != nil {
+		zap.L().Warn("failed to persist execution history",
+			zap.String("target", name),
+			zap.Error(err))
+	}
+}
+
+// Get returns the recorded executions of the named target, newest first
+func (h *History) Get(name string) []state.Execution {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	records := h.records[name]
+	out := make([]state.Execution, len(records))
+	for i, r := range records {
+		out[len(records)-1-i] = r
+	}
+	return out
+}
+
+// Images returns the images recorded for the most recent successful deploy of
+// the named target, if any were.
+func (h *History) Images(name string) []state.Image {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	records := h.records[name]
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Error == "" && !records[i].Shutdown && records[i].Suppressed == "" {
+			return records[i].Images
+		}
+	}
+	return nil
+}
+
+// trim drops the oldest records beyond the size, the result never shares its
+// backing array with the input.
+func (h *History) trim(records []state.Execution) []state.Execution {
+	if len(records) > h.size {
+		records = records[len(records)-h.size:]
+	}
+	return append([]state.Execution(nil), records...)
+}
+
+// previous returns the commit of the most recent successful deploy
+func previous(records []state.Execution) string {
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Error == "" && !records[i].Shutdown {
+			return records[i].Commit
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package executor
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+func result(name, commit string, err error) Result {
+	return Result{
+		Task:   task.ExecutionTask{Target: task.Target{Name: name}, Trigger: task.TriggerChange},
+		Commit: commit,
+		Err:    err,
+	}
+}
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3, nil)
+	h.Add(result("app", "a", nil))
+	h.Add(result("app", "b", errors.New("exit status 1")))
+	h.Add(result("app", "c", nil))
+	h.Add(result("app", "d", nil))
+	h.Add(result("other", "x", nil))
+
+	got := h.Get("app")
+	require.Len(t, got, 3)
+	assert.Equal(t, state.Execution{Commit: "d", Previous: "c", Trigger: "change"}, got[0])
+	assert.Equal(t, state.Execution{Commit: "c", Previous: "a", Trigger: "change"}, got[1])
+	assert.Equal(t, state.Execution{Commit: "b", Previous: "a", Trigger: "change", Error: "exit status 1"}, got[2])
+	assert.Len(t, h.Get("other"), 1)
+	assert.Empty(t, h.Get("unknown"))
+}
+
+func TestHistoryImages(t *testing.T) {
+	h := NewHistory(3, nil)
+	deployed := result("app", "a", nil)
+	deployed.Images = []state.Image{{Container: "app_web_1", Image: "nginx:1.25", Digest: "nginx@sha256:222"}}
+	h.Add(deployed)
+	h.Add(result("app", "b", errors.New("exit status 1")))
+
+	assert.Equal(t, deployed.Images, h.Images("app"))
+	assert.Equal(t, deployed.Images, h.Get("app")[1].Images)
+	assert.Nil(t, h.Images("unknown"))
+}
+
+func TestHistoryPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-history")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := state.Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.SetApplied("app", "a"))
+
+	h := NewHistory(2, store)
+	h.Add(result("app", "b", nil))
+	h.Add(result("app", "c", nil))
+	h.Add(result("app", "d", nil))
+
+	reopened, err := state.Open(dir)
+	require.NoError(t, err)
+	got := NewHistory(2, reopened).Get("app")
+	require.Len(t, got, 2)
+	assert.Equal(t, "d", got[0].Commit)
+	assert.Equal(t, "c", got[0].Previous)
+	assert.Equal(t, "b", got[1].Previous)
+
+	// a smaller size applies to persisted history too
+	assert.Len(t, NewHistory(1, reopened).Get("app"), 1)
+}
@@ -0,0 +1,186 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+// imagesTimeout bounds how long listing the images of a deployed target may take
+const imagesTimeout = time.Second * 30
+
+// dockerCommand builds a docker CLI command used to inspect containers
+var dockerCommand = func(ctx context.Context, args ...string) *osexec.Cmd {
+	return osexec.CommandContext(ctx, "docker", args...)
+}
+
+// dockerReachable reports whether there's a Docker daemon to list containers
+// from, either at DOCKER_HOST or the default socket, and a CLI to ask it with.
+var dockerReachable = func() bool {
+	if _, err := osexec.LookPath("docker"); err != nil {
+		return false
+	}
+	if os.Getenv("DOCKER_HOST") != "" {
+		return true
+	}
+	_, err := os.Stat("/var/run/docker.sock")
+	return err == nil
+}
+
+// deployedImages lists the images of the containers in the compose project of
+// a deployed target. The deploy already succeeded, so a failure is only logged.
+func (e *CommandExecutor) deployedImages(log *zap.Logger, t task.ExecutionTask) []state.Image {
+	if !dockerReachable() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(e.ctx, imagesTimeout)
+	defer cancel()
+
+	project := composeProject(t)
+	images, err := listImages(ctx, project)
+	if err != nil {
+		log.Warn("failed to list images of deployed target",
+			zap.String("project", project),
+			zap.Error(err))
+		return nil
+	}
+	if len(images) > 0 {
+		log.Info("deployed images",
+			zap.Any("images", images))
+	}
+	return images
+}
+
+// projectChars are the characters docker-compose removes from project names
+var projectChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// composeProject returns the name docker-compose gives the target's project,
+// COMPOSE_PROJECT_NAME if it's set or the name of the directory its commands
+// run in otherwise.
+func composeProject(t task.ExecutionTask) string {
+	for _, env := range []map[string]string{t.Target.Env, t.Env} {
+		if name := env["COMPOSE_PROJECT_NAME"]; name != "" {
+			return name
+		}
+	}
+	if t.Target.Clone != "" {
+		return sharedProject(t.Target)
+	}
+	return projectChars.ReplaceAllString(strings.ToLower(filepath.Base(t.Target.WorkDir(t.Path))), "")
+}
+
+// sharedProject is the compose project name of a target that shares its clone,
+// the name it would have in a clone of its own, so targets running in the same
+// directory don't share a project.
+func sharedProject(t task.Target) string {
+	return projectChars.ReplaceAllString(strings.ToLower(t.DirName()), "")
+}
+
+// listImages lists the image and digest of every running container of the
+// compose project, ordered by container name.
+func listImages(ctx context.Context, project string) ([]state.Image, error) {
+	out, err := docker(ctx, "ps", "--quiet", "--filter", "label=com.docker.compose.project="+project)
+	if err != nil {
+		return nil, err
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	out, err = docker(ctx, append([]string{"container", "inspect", "--format", "{{.Name}}\t{{.Config.Image}}\t{{.Image}}"}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	var images []state.Image
+	imageIDs := make(map[string][]int)
+	for _, fields := range lines(out, 3) {
+		imageIDs[fields[2]] = append(imageIDs[fields[2]], len(images))
+		images = append(images, state.Image{
+			Container: strings.TrimPrefix(fields[0], "/"),
+			Image:     fields[1],
+			Digest:    fields[2],
+		})
+	}
+
+	unique := make([]string, 0, len(imageIDs))
+	for id := range imageIDs {
+		unique = append(unique, id)
+	}
+	sort.Strings(unique)
+	out, err = docker(ctx, append([]string{"image", "inspect", "--format", "{{.Id}}\t{{join .RepoDigests \" \"}}"}, unique...)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, fields := range lines(out, 1) {
+		for _, i := range imageIDs[fields[0]] {
+			if d := repoDigest(images[i].Image, fields[1:]); d != "" {
+				images[i].Digest = d
+			}
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].Container < images[j].Container })
+	return images, nil
+}
+
+func docker(ctx context.Context, args ...string) ([]byte, error) {
+	return commandOutput(dockerCommand(ctx, args...), "docker "+args[0])
+}
+
+// commandOutput returns the output of a command, or an error with its stderr
+func commandOutput(cmd *osexec.Cmd, name string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s failed: %s", name, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// lines splits output into whitespace separated fields, skipping lines with
+// fewer than n fields.
+func lines(out []byte, n int) (result [][]string) {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if fields := strings.Fields(s.Text()); len(fields) >= n {
+			result = append(result, fields)
+		}
+	}
+	return
+}
+
+// repoDigest picks the digest of the repository the image reference names from
+// an image's repository digests, such as nginx@sha256:... for nginx:1.25.
+func repoDigest(ref string, digests []string) string {
+	repo := ref
+	if i := strings.IndexRune(repo, '@'); i >= 0 {
+		repo = repo[:i]
+	}
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	for _, d := range digests {
+		if strings.HasPrefix(d, repo+"@") {
+			return d
+		}
+	}
+	if len(digests) > 0 {
+		return digests[0]
+	}
+	return ""
+}
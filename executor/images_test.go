@@ -0,0 +1,93 @@
+package executor
+
+import (
+	"context"
+	osexec "os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+func TestDeployedImages(t *testing.T) {
+	if _, err := osexec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	defer func(r func() bool) { dockerReachable = r }(dockerReachable)
+	dockerReachable = func() bool { return true }
+	defer func(c func(context.Context, ...string) *osexec.Cmd) { dockerCommand = c }(dockerCommand)
+
+	var project string
+	outputs := map[string]string{
+		"container": `printf '/app_web_1\tnginx:1.25\tsha256:aaa\n/app_db_1\tlocal/db\tsha256:bbb\n/app_worker_1\tnginx:1.25\tsha256:aaa\n'`,
+		"image":     `printf 'sha256:aaa\tmirror/nginx@sha256:111 nginx@sha256:222\nsha256:bbb\t\n'`,
+	}
+	dockerCommand = func(ctx context.Context, args ...string) *osexec.Cmd {
+		if args[0] == "ps" {
+			project = args[len(args)-1]
+			return osexec.CommandContext(ctx, "sh", "-c", "echo c1 c2 c3")
+		}
+		return osexec.CommandContext(ctx, "sh", "-c", outputs[args[0]])
+	}
+
+	ce := NewCommandExecutor(nil, false, "pico")
+	images := ce.deployedImages(zap.L(), task.ExecutionTask{Path: "/data/targets/My.App"})
+	assert.Equal(t, "label=com.docker.compose.project=myapp", project)
+	assert.Equal(t, []state.Image{
+		{Container: "app_db_1", Image: "local/db", Digest: "sha256:bbb"},
+		{Container: "app_web_1", Image: "nginx:1.25", Digest: "nginx@sha256:222"},
+		{Container: "app_worker_1", Image: "nginx:1.25", Digest: "nginx@sha256:222"},
+	}, images)
+
+	ce.deployedImages(zap.L(), task.ExecutionTask{
+		Path:   "/data/targets/app",
+		Target: task.Target{Env: map[string]string{"COMPOSE_PROJECT_NAME": "stack"}},
+	})
+	assert.Equal(t, "label=com.docker.compose.project=stack", project)
+
+	// targets sharing a clone keep the project named after their own directory
+	ce.deployedImages(zap.L(), task.ExecutionTask{
+		Path:   "/data/targets/.shared-github.com_org_mono",
+		Target: task.Target{Name: "Web", Clone: ".shared-github.com_org_mono"},
+	})
+	assert.Equal(t, "label=com.docker.compose.project=web", project)
+
+	// a failure is only logged
+	outputs["image"] = "echo 'Cannot connect to the Docker daemon' >&2; exit 1"
+	assert.Nil(t, ce.deployedImages(zap.L(), task.ExecutionTask{Path: "/data/targets/app"}))
+}
+
+func TestRepoDigest(t *testing.T) {
+	digests := []string{"registry:5000/team/app@sha256:111", "team/app@sha256:222"}
+	assert.Equal(t, "team/app@sha256:222", repoDigest("team/app:v1", digests))
+	assert.Equal(t, "registry:5000/team/app@sha256:111", repoDigest("registry:5000/team/app", digests))
+	assert.Equal(t, "team/app@sha256:222", repoDigest("team/app@sha256:222", digests))
+	assert.Equal(t, "registry:5000/team/app@sha256:111", repoDigest("other", digests))
+	assert.Equal(t, "", repoDigest("other", nil))
+}
+
+func TestComposeProjects(t *testing.T) {
+	if _, err := osexec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	defer func(c func(context.Context, ...string) *osexec.Cmd) { dockerCommand = c }(dockerCommand)
+
+	var args []string
+	dockerCommand = func(ctx context.Context, a ...string) *osexec.Cmd {
+		args = a
+		return osexec.CommandContext(ctx, "sh", "-c", `printf 'web\tweb\nweb\tweb\nmonitoring\t\nold\tapi\nold\tweb\n'`)
+	}
+	projects, err := ComposeProjects(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Project{
+		{Name: "monitoring"},
+		{Name: "old", Targets: []string{"api", "web"}},
+		{Name: "web", Targets: []string{"web"}},
+	}, projects)
+
+	assert.NoError(t, RemoveProject(context.Background(), "old"))
+	assert.Equal(t, []string{"compose", "--project-name", "old", "down", "--remove-orphans"}, args)
+}
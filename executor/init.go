@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// initialise runs the target's init command unless it has already succeeded on
+// this host. A failed init isn't recorded, so it's retried before the next up.
+func (e *CommandExecutor) initialise(ctx context.Context, ex exec, out io.Writer) error {
+	name := ex.target.Name
+	if len(ex.target.Init) == 0 || e.inits.Initialised(name) {
+		return nil
+	}
+
+	logger(ctx).Info("running init command of target",
+		zap.Strings("cmd", ex.target.Init))
+
+	if err := execError(ex.target.InitContext(ctx, ex.dir, ex.env, ex.passEnvironment, out)); err != nil {
+		return errors.Wrap(err, "init command failed")
+	}
+	if err := e.inits.SetInitialised(name, true); err != nil {
+		// the init command will run again after a restart, it can't be undone
+		logger(ctx).Warn("failed to record init of target",
+			zap.Error(err))
+	}
+	return nil
+}
+
+// memoryInits records initialised targets for the life of the process
+type memoryInits struct {
+	mu      sync.Mutex
+	targets map[string]bool
+}
+
+func (m *memoryInits) Initialised(target string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.targets[target]
+}
+
+func (m *memoryInits) SetInitialised(target string, initialised bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.targets == nil {
+		m.targets = make(map[string]bool)
+	}
+	m.targets[target] = initialised
+	return nil
+}
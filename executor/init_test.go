@@ -0,0 +1,55 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+func TestCommandExecutorInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-init")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := state.Open(dir)
+	require.NoError(t, err)
+
+	ce := NewCommandExecutor(&memory.MemorySecrets{}, false, "pico")
+	ce.SetInitRecorder(store)
+	target := task.Target{
+		Name: "app",
+		Init: []string{"sh", "-c", "test -e fail && exit 1; echo init >> log"},
+		Up:   []string{"sh", "-c", "echo up >> log"},
+	}
+	log := func() string {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, "log"))
+		return string(b)
+	}
+
+	// a failed init stops up from running and is retried next time
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "fail"), nil, 0o600))
+	err = ce.execute(context.Background(), target, dir, "", false, nil, nil)
+	assert.EqualError(t, err, "init command failed: exit status 1")
+	assert.Equal(t, "", log())
+	assert.False(t, store.Initialised("app"))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "fail")))
+	assert.NoError(t, ce.execute(context.Background(), target, dir, "", false, nil, nil))
+	assert.NoError(t, ce.execute(context.Background(), target, dir, "", false, nil, nil))
+	assert.Equal(t, "init\nup\nup\n", log())
+	assert.True(t, store.Initialised("app"))
+
+	// shutdowns never run it
+	require.NoError(t, store.SetInitialised("app", false))
+	target.Down = []string{"true"}
+	assert.NoError(t, ce.execute(context.Background(), target, dir, "", true, nil, nil))
+	assert.False(t, store.Initialised("app"))
+}
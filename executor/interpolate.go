@@ -0,0 +1,140 @@
+package executor
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/redact"
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/task"
+)
+
+// placeholder matches ${secret:<ref>} and its escaped form $${secret:<ref>}
+var placeholder = regexp.MustCompile(`\$?\$\{secret:([^}]*)\}`)
+
+// resolver substitutes secret placeholders in configuration values. A reference
+// is either a key of the target's own secrets or a path and key separated by
+// the last slash, such as myapp/DB_PASSWORD. Secrets are read once per path.
+type resolver struct {
+	ctx    context.Context
+	e      *CommandExecutor
+	target string
+	paths  map[string]map[string]string
+}
+
+func (e *CommandExecutor) newResolver(ctx context.Context, target string, own map[string]string) *resolver {
+	return &resolver{
+		ctx:    ctx,
+		e:      e,
+		target: target,
+		paths:  map[string]map[string]string{target: own},
+	}
+}
+
+// resolve replaces every placeholder in s, failing if any can't be resolved
+func (r *resolver) resolve(s string) (string, error) {
+	if !strings.Contains(s, "${secret:") {
+		return s, nil
+	}
+	var failed error
+	out := placeholder.ReplaceAllStringFunc(s, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		ref := placeholder.FindStringSubmatch(match)[1]
+		v, err := r.lookup(ref)
+		if err != nil && failed == nil {
+			failed = err
+		}
+		return v
+	})
+	if failed != nil {
+		return "", failed
+	}
+	return out, nil
+}
+
+func (r *resolver) lookup(ref string) (string, error) {
+	path, key := r.target, ref
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		path, key = ref[:i], ref[i+1:]
+	}
+	if path == "" || key == "" {
+		return "", errors.Errorf("invalid secret reference '%s'", ref)
+	}
+
+	secrets, ok := r.paths[path]
+	if !ok {
+		var err error
+		secrets, err = secret.Get(r.ctx, r.e.secrets.store, path)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read secrets for reference '%s'", ref)
+		}
+		r.paths[path] = secrets
+	}
+	v, ok := secrets[key]
+	if !ok {
+		return "", errors.Errorf("secret reference '%s' could not be resolved", ref)
+	}
+	redact.Add(v)
+	return v, nil
+}
+
+// interpolate resolves placeholders in the target's environment, the execution
+// environment and, if enabled, the target's commands. The target and maps are
+// copied so the watcher's state is never modified.
+func (e *CommandExecutor) interpolate(ctx context.Context, target task.Target, execEnv map[string]string, own map[string]string) (task.Target, map[string]string, error) {
+	r := e.newResolver(ctx, target.Name, own)
+
+	resolveMap := func(m map[string]string) (map[string]string, error) {
+		if m == nil {
+			return nil, nil
+		}
+		out := make(map[string]string, len(m))
+		for k, v := range m {
+			resolved, err := r.resolve(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "in the value of %s", k)
+			}
+			out[k] = resolved
+		}
+		return out, nil
+	}
+	resolveSlice := func(s []string) ([]string, error) {
+		if s == nil {
+			return nil, nil
+		}
+		out := make([]string, len(s))
+		for i, v := range s {
+			resolved, err := r.resolve(v)
+			if err != nil {
+				return nil, errors.Wrap(err, "in the command")
+			}
+			out[i] = resolved
+		}
+		return out, nil
+	}
+
+	var err error
+	if target.Env, err = resolveMap(target.Env); err != nil {
+		return target, nil, err
+	}
+	if execEnv, err = resolveMap(execEnv); err != nil {
+		return target, nil, err
+	}
+	if e.interpolateCommands {
+		if target.Up, err = resolveSlice(target.Up); err != nil {
+			return target, nil, err
+		}
+		if target.Down, err = resolveSlice(target.Down); err != nil {
+			return target, nil, err
+		}
+		if target.Init, err = resolveSlice(target.Init); err != nil {
+			return target, nil, err
+		}
+	}
+	return target, execEnv, nil
+}
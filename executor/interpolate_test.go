@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/redact"
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+func TestInterpolate(t *testing.T) {
+	ce := NewCommandExecutor(&memory.MemorySecrets{
+		Secrets: map[string]map[string]string{
+			"app":        {"DB_PASSWORD": "hunter2"},
+			"shared/db":  {"HOST": "db.internal"},
+			"pico":       {},
+			"other_team": {"TOKEN": "t0ken"},
+		},
+	}, false, "pico")
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{"plain", "no placeholders", "no placeholders", ""},
+		{"own", "postgres://app:${secret:DB_PASSWORD}@db/app", "postgres://app:hunter2@db/app", ""},
+		{"path", "${secret:shared/db/HOST}:5432", "db.internal:5432", ""},
+		{"multiple", "${secret:other_team/TOKEN}-${secret:DB_PASSWORD}", "t0ken-hunter2", ""},
+		{"escaped", "$${secret:DB_PASSWORD}", "${secret:DB_PASSWORD}", ""},
+		{"missing", "${secret:MISSING}", "", "failed to resolve secret reference: in the value of VALUE: secret reference 'MISSING' could not be resolved"},
+		{"invalid", "${secret:shared/}", "", "failed to resolve secret reference: in the value of VALUE: invalid secret reference 'shared/'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex, err := ce.prepare(context.Background(), task.Target{
+				Name: "app",
+				Env:  map[string]string{"VALUE": tt.value},
+			}, "./", false, nil)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, ex.target.Env["VALUE"])
+		})
+	}
+
+	assert.Equal(t, "db is [REDACTED]", redact.String("db is db.internal"))
+}
+
+func TestInterpolateCommands(t *testing.T) {
+	ce := NewCommandExecutor(&memory.MemorySecrets{
+		Secrets: map[string]map[string]string{"app": {"TOKEN": "t0ken"}},
+	}, false, "pico")
+	target := task.Target{Name: "app", Up: []string{"login", "${secret:TOKEN}"}}
+
+	ex, err := ce.prepare(context.Background(), target, "./", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"login", "${secret:TOKEN}"}, ex.target.Up)
+
+	ce.SetInterpolateCommands(true)
+	ex, err = ce.prepare(context.Background(), target, "./", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"login", "t0ken"}, ex.target.Up)
+	assert.Equal(t, []string{"login", "${secret:TOKEN}"}, target.Up)
+}
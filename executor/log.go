@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/task"
+)
+
+type loggerKey struct{}
+
+// taskLogger builds the logger for the lines logged while preparing and
+// executing a task, they carry its ID to tell them apart from other tasks.
+func taskLogger(t task.ExecutionTask) *zap.Logger {
+	return zap.L().With(
+		zap.String("task_id", t.ID),
+		zap.String("target", t.Target.Name),
+		t.Target.LabelsField())
+}
+
+// withLogger sets the logger of a task, the secret store uses it too so its
+// reads for the task can be told apart.
+func withLogger(ctx context.Context, log *zap.Logger) context.Context {
+	return context.WithValue(secret.WithLogger(ctx, log), loggerKey{}, log)
+}
+
+// logger returns the logger of the task being executed with ctx, or the global
+// logger outside of a task.
+func logger(ctx context.Context) *zap.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return log
+	}
+	return zap.L()
+}
@@ -0,0 +1,40 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/picostack/pico/secret/instrumented"
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+func TestTaskLogger(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	m, err := instrumented.NewMetrics(prometheus.NewRegistry())
+	assert.NoError(t, err)
+	store := instrumented.New(&memory.MemorySecrets{}, "memory", m)
+
+	ce := NewCommandExecutor(store, false, "pico")
+	var result Result
+	ce.SetResultHandler(func(r Result) { result = r })
+	ce.process(queued{task: task.ExecutionTask{
+		ID:     "0123456789abcdef",
+		Path:   "./.test",
+		Target: task.Target{Name: "app", Init: []string{"true"}, Up: []string{"true"}},
+	}, queued: time.Now()}, 0)
+
+	assert.Equal(t, "0123456789abcdef", result.Task.ID)
+	assert.NotZero(t, logs.FilterMessage("read secrets from secret store").Len())
+	for _, e := range logs.All() {
+		assert.Equal(t, "0123456789abcdef", e.ContextMap()["task_id"], e.Message)
+		assert.Equal(t, "app", e.ContextMap()["target"], e.Message)
+	}
+}
@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// mutexes serialises the tasks of targets that share a mutex name. A task
+// acquires its target's mutex before it executes, tasks waiting on a mutex are
+// granted it in the order they were received.
+type mutexes struct {
+	mu    sync.Mutex
+	locks map[string]*mutex
+}
+
+type mutex struct {
+	holder  string // the target executing while holding the mutex
+	waiters []waiter
+}
+
+type waiter struct {
+	target string
+	since  time.Time
+	ready  chan struct{}
+}
+
+func newMutexes() *mutexes {
+	return &mutexes{locks: make(map[string]*mutex)}
+}
+
+// acquire blocks until the named mutex is free and returns a function that
+// releases it. Targets without a mutex are never blocked.
+func (m *mutexes) acquire(name, target string, since time.Time) (release func()) {
+	if name == "" {
+		return func() {}
+	}
+
+	m.mu.Lock()
+	l, ok := m.locks[name]
+	if !ok {
+		m.locks[name] = &mutex{holder: target}
+		m.mu.Unlock()
+		return func() { m.release(name) }
+	}
+	w := waiter{target: target, since: since, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	holder := l.holder
+	m.mu.Unlock()
+
+	zap.L().Info("task waiting on mutex",
+		zap.String("target", target),
+		zap.String("mutex", name),
+		zap.String("held_by", holder))
+	<-w.ready
+	return func() { m.release(name) }
+}
+
+// release hands the mutex to the task that has waited on it the longest
+func (m *mutexes) release(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.locks[name]
+	if len(l.waiters) == 0 {
+		delete(m.locks, name)
+		return
+	}
+	next := l.waiters[0]
+	l.waiters = l.waiters[1:]
+	l.holder = next.target
+	close(next.ready)
+}
+
+// waiting returns the tasks blocked on a mutex
+func (m *mutexes) waiting() (out []Waiting) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, l := range m.locks {
+		for _, w := range l.waiters {
+			out = append(out, Waiting{Target: w.target, Mutex: name, Since: w.since})
+		}
+	}
+	return
+}
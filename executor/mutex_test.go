@@ -0,0 +1,45 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutexesFIFO(t *testing.T) {
+	m := newMutexes()
+	start := time.Now()
+
+	release := m.acquire("db", "first", start)
+	// targets without a mutex, or with another one, never wait
+	m.acquire("", "free", start)()
+	m.acquire("cache", "other", start)()
+
+	order := make(chan string, 3)
+	for i, target := range []string{"second", "third", "fourth"} {
+		go func(target string, since time.Time) {
+			defer m.acquire("db", target, since)()
+			order <- target
+		}(target, start.Add(time.Duration(i+1)*time.Second))
+		// each waiter is queued before the next one asks
+		require.Eventually(t, func() bool { return len(m.waiting()) == i+1 }, time.Second, time.Millisecond)
+	}
+
+	waiting := m.waiting()
+	require.Len(t, waiting, 3)
+	assert.Equal(t, Waiting{Target: "second", Mutex: "db", Since: start.Add(time.Second)}, waiting[0])
+	assert.Empty(t, order)
+
+	release()
+	assert.Equal(t, "second", <-order)
+	assert.Equal(t, "third", <-order)
+	assert.Equal(t, "fourth", <-order)
+
+	require.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.locks) == 0
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,123 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/picostack/pico/task"
+)
+
+// quarantineAfter is how many times a target's task may panic at the same
+// commit before further tasks of that commit are skipped
+const quarantineAfter = 3
+
+// PanicError is returned for a task that panicked while it was executed. The
+// panic is recovered so the executor carries on with the next task.
+type PanicError struct {
+	Value interface{}
+	Stack string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v", e.Value)
+}
+
+// Quarantined is a target whose tasks at a commit are skipped since they
+// panicked repeatedly. A manual trigger still executes them, and a task of
+// another commit that succeeds lifts the quarantine.
+type Quarantined struct {
+	Target string
+	Commit string
+	Panics int
+	Panic  string    // the value of the last panic
+	Since  time.Time // when it was quarantined
+}
+
+// QuarantineReporter is implemented by executors that quarantine tasks which
+// panic repeatedly.
+type QuarantineReporter interface {
+	Quarantined() []Quarantined
+}
+
+// quarantine counts the panics of each target's tasks at their latest commit
+type quarantine struct {
+	mu      sync.Mutex
+	targets map[string]*Quarantined
+}
+
+func newQuarantine() *quarantine {
+	return &quarantine{targets: make(map[string]*Quarantined)}
+}
+
+// record counts a panic of a target's task at a commit, it returns true if
+// the target has just been quarantined.
+func (q *quarantine) record(target, commit string, err *PanicError) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	p, ok := q.targets[target]
+	if !ok || p.Commit != commit {
+		p = &Quarantined{Target: target, Commit: commit}
+		q.targets[target] = p
+	}
+	p.Panics++
+	p.Panic = fmt.Sprint(err.Value)
+	if p.Panics >= quarantineAfter && p.Since.IsZero() {
+		p.Since = time.Now()
+		return true
+	}
+	return false
+}
+
+// held reports whether tasks of the target at the commit are quarantined
+func (q *quarantine) held(target, commit string) (Quarantined, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	p, ok := q.targets[target]
+	if !ok || p.Commit != commit || p.Since.IsZero() {
+		return Quarantined{}, false
+	}
+	return *p, true
+}
+
+// clear forgets the panics of a target once one of its tasks succeeds
+func (q *quarantine) clear(target string) {
+	q.mu.Lock()
+	delete(q.targets, target)
+	q.mu.Unlock()
+}
+
+func (q *quarantine) list() (out []Quarantined) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, p := range q.targets {
+		if !p.Since.IsZero() {
+			out = append(out, *p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Target < out[j].Target })
+	return
+}
+
+// Quarantined implements executor.QuarantineReporter
+func (e *CommandExecutor) Quarantined() []Quarantined {
+	return e.quarantine.list()
+}
+
+// runRecovered is run, except that a panic while executing the task fails it
+// with a PanicError rather than taking the whole process down.
+func (e *CommandExecutor) runRecovered(ctx context.Context, t task.ExecutionTask, commit string, out io.Writer) (dir string, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: string(debug.Stack())}
+		}
+	}()
+	return e.run(ctx, t, commit, out)
+}
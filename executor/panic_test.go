@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+func TestCommandExecutorPanic(t *testing.T) {
+	defer func(run func(context.Context, task.Target, string, map[string]string, bool, bool, io.Writer) error) {
+		runCommand = run
+	}(runCommand)
+	var ran []string
+	runCommand = func(ctx context.Context, t task.Target, dir string, env map[string]string, shutdown, passEnvironment bool, out io.Writer) error {
+		ran = append(ran, t.Name)
+		if t.Name == "broken" {
+			var m map[string]string
+			m["key"] = "value"
+		}
+		return nil
+	}
+
+	ce := NewCommandExecutor(&memory.MemorySecrets{}, false, "pico")
+	var results []Result
+	ce.SetResultHandler(func(r Result) { results = append(results, r) })
+
+	broken := task.ExecutionTask{Target: task.Target{Name: "broken", Up: []string{"true"}}, Path: "./.test", Commit: "abc"}
+	bus := make(chan task.ExecutionTask, 8)
+	for i := 0; i < quarantineAfter+1; i++ {
+		bus <- broken
+	}
+	bus <- task.ExecutionTask{Target: task.Target{Name: "app", Up: []string{"true"}}, Path: "./.test", Commit: "abc"}
+	manual := broken
+	manual.Trigger = task.TriggerManual
+	bus <- manual
+	close(bus)
+	ce.Subscribe(bus)
+
+	// the panics fail their tasks and the executor carries on, the task that
+	// panicked too often is skipped until it's triggered
+	require.Len(t, results, quarantineAfter+2)
+	var pe *PanicError
+	if assert.True(t, errors.As(results[0].Err, &pe)) {
+		assert.Contains(t, pe.Stack, "TestCommandExecutorPanic")
+	}
+	assert.EqualError(t, results[0].Err, "task panicked: assignment to entry in nil map")
+	assert.Equal(t, "app", results[quarantineAfter].Task.Target.Name)
+	assert.NoError(t, results[quarantineAfter].Err)
+	assert.Equal(t, task.TriggerManual, results[quarantineAfter+1].Task.Trigger)
+	assert.Error(t, results[quarantineAfter+1].Err)
+	assert.Len(t, ran, quarantineAfter+2)
+
+	quarantined := ce.Quarantined()
+	if assert.Len(t, quarantined, 1) {
+		assert.Equal(t, "broken", quarantined[0].Target)
+		assert.Equal(t, "abc", quarantined[0].Commit)
+		assert.Equal(t, quarantineAfter+1, quarantined[0].Panics)
+	}
+
+	// another commit of the target isn't quarantined
+	_, held := ce.quarantine.held("broken", "def")
+	assert.False(t, held)
+}
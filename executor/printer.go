@@ -1,6 +1,7 @@
 package executor
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/picostack/pico/task"
@@ -9,9 +10,18 @@ import (
 // Printer implements an executor that doesn't actually execute, just prints.
 type Printer struct{}
 
+var _ Executor = &Printer{}
+
 // Subscribe implements executor.Executor
-func (p *Printer) Subscribe(bus chan task.ExecutionTask) {
+func (p *Printer) Subscribe(bus <-chan task.ExecutionTask) {
 	for t := range bus {
 		fmt.Printf("received task: %s\n", t.Target.Name)
 	}
 }
+
+// SetContext implements executor.Executor
+func (p *Printer) SetContext(context.Context) {}
+
+// SetResultHandler implements executor.Executor, nothing is executed so there
+// are no results.
+func (p *Printer) SetResultHandler(func(Result)) {}
@@ -0,0 +1,88 @@
+package executor
+
+import (
+	"context"
+	osexec "os/exec"
+	"sort"
+	"time"
+)
+
+// TargetLabel is the label of containers naming the target whose compose
+// project they belong to. Labels of running containers can't be changed, so
+// compose files set it from PICO_TARGET to have their projects recognised
+// even without the project recorded in the state file.
+const TargetLabel = "com.pico.target"
+
+// projectTimeout bounds how long listing or tearing down compose projects
+// may take
+const projectTimeout = time.Minute * 5
+
+// DockerReachable reports whether there's a Docker daemon and a CLI to list
+// and tear down compose projects with.
+func DockerReachable() bool {
+	return dockerReachable()
+}
+
+// Project is a compose project with running containers
+type Project struct {
+	Name    string
+	Targets []string // the targets its containers are labelled with, if any
+}
+
+// ComposeProjects lists the compose projects with running containers, ordered
+// by name.
+func ComposeProjects(ctx context.Context) ([]Project, error) {
+	ctx, cancel := context.WithTimeout(ctx, projectTimeout)
+	defer cancel()
+
+	out, err := docker(ctx, "ps", "--filter", "label=com.docker.compose.project",
+		"--format", `{{.Label "com.docker.compose.project"}}`+"\t"+`{{.Label "`+TargetLabel+`"}}`)
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]map[string]bool)
+	for _, fields := range lines(out, 1) {
+		if targets[fields[0]] == nil {
+			targets[fields[0]] = make(map[string]bool)
+		}
+		if len(fields) > 1 {
+			targets[fields[0]][fields[1]] = true
+		}
+	}
+
+	projects := make([]Project, 0, len(targets))
+	for name, labelled := range targets {
+		p := Project{Name: name}
+		for t := range labelled {
+			p.Targets = append(p.Targets, t)
+		}
+		sort.Strings(p.Targets)
+		projects = append(projects, p)
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Name < projects[j].Name })
+	return projects, nil
+}
+
+// RemoveProject stops and removes the containers and networks of a compose
+// project, without needing the compose file it was started from.
+func RemoveProject(ctx context.Context, project string) error {
+	ctx, cancel := context.WithTimeout(ctx, projectTimeout)
+	defer cancel()
+
+	_, err := compose(ctx, "--project-name", project, "down", "--remove-orphans")
+	return err
+}
+
+// composeV1Command builds a command of the standalone docker-compose CLI
+var composeV1Command = func(ctx context.Context, args ...string) *osexec.Cmd {
+	return osexec.CommandContext(ctx, "docker-compose", args...)
+}
+
+// compose runs a compose command with the docker compose plugin, or with the
+// standalone docker-compose on hosts that only have compose v1.
+func compose(ctx context.Context, args ...string) ([]byte, error) {
+	if _, err := docker(ctx, "compose", "version"); err == nil {
+		return docker(ctx, append([]string{"compose"}, args...)...)
+	}
+	return commandOutput(composeV1Command(ctx, args...), "docker-compose")
+}
@@ -0,0 +1,45 @@
+package executor
+
+import (
+	"context"
+	osexec "os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveProject(t *testing.T) {
+	if _, err := osexec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	defer func(c func(context.Context, ...string) *osexec.Cmd) { dockerCommand = c }(dockerCommand)
+	defer func(c func(context.Context, ...string) *osexec.Cmd) { composeV1Command = c }(composeV1Command)
+
+	for _, tt := range []struct {
+		name   string
+		plugin bool
+		want   []string
+	}{
+		{"plugin", true, []string{"docker compose version", "docker compose --project-name app down --remove-orphans"}},
+		{"compose v1", false, []string{"docker compose version", "docker-compose --project-name app down --remove-orphans"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran []string
+			dockerCommand = func(ctx context.Context, args ...string) *osexec.Cmd {
+				ran = append(ran, "docker "+strings.Join(args, " "))
+				if tt.plugin {
+					return osexec.CommandContext(ctx, "sh", "-c", "true")
+				}
+				return osexec.CommandContext(ctx, "sh", "-c", "echo \"'compose' is not a docker command\" >&2; exit 1")
+			}
+			composeV1Command = func(ctx context.Context, args ...string) *osexec.Cmd {
+				ran = append(ran, "docker-compose "+strings.Join(args, " "))
+				return osexec.CommandContext(ctx, "sh", "-c", "true")
+			}
+			require.NoError(t, RemoveProject(context.Background(), "app"))
+			assert.Equal(t, tt.want, ran)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package executor
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/picostack/pico/task"
+)
+
+// queued is a task waiting to be executed
+type queued struct {
+	task   task.ExecutionTask
+	seq    uint64 // arrival order, to keep equal priorities first in first out
+	queued time.Time
+}
+
+// tasks implements heap.Interface, the tasks of a cold start plan first in the
+// plan's order, then highest priority first
+type tasks []queued
+
+func (q tasks) Len() int { return len(q) }
+
+func (q tasks) Less(i, j int) bool {
+	pi, pj := q[i].task.Plan, q[j].task.Plan
+	if (pi != nil) != (pj != nil) {
+		return pi != nil
+	}
+	if pi != nil && pi == pj {
+		return pi.Index(q[i].task.Target.Name) < pj.Index(q[j].task.Target.Name)
+	}
+	if q[i].task.Priority != q[j].task.Priority {
+		return q[i].task.Priority > q[j].task.Priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q tasks) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *tasks) Push(x interface{}) { *q = append(*q, x.(queued)) }
+
+func (q *tasks) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[:n-1]
+	return item
+}
+
+// queueLimit is how many tasks may wait in the queue, once it's full no more
+// are taken from the bus so its senders wait, as they would without the queue.
+const queueLimit = 512
+
+// queue holds the tasks received from the bus while earlier tasks execute, so
+// they can be executed in order of priority rather than arrival.
+type queue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	tasks  tasks
+	seq    uint64
+	closed bool
+	slots  chan struct{} // one for each task in the queue, to bound it
+}
+
+func newQueue(limit int) *queue {
+	q := &queue{slots: make(chan struct{}, limit)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// feed moves every task from the bus into the queue until the bus is closed
+func (q *queue) feed(bus <-chan task.ExecutionTask) {
+	for t := range bus {
+		q.push(t, time.Now())
+	}
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// push queues a task, it blocks while the queue is full
+func (q *queue) push(t task.ExecutionTask, at time.Time) {
+	q.slots <- struct{}{}
+	q.mu.Lock()
+	heap.Push(&q.tasks, queued{task: t, seq: q.seq, queued: at})
+	q.seq++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// snapshot returns the tasks in the queue, in no particular order
+func (q *queue) snapshot() []queued {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]queued(nil), q.tasks...)
+}
+
+// pop blocks until a task is queued and returns the one with the highest
+// priority and the number still waiting. It returns false once the bus is
+// closed and every task has been taken.
+func (q *queue) pop() (item queued, waiting int, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.tasks) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.tasks) == 0 {
+		return queued{}, 0, false
+	}
+	return q.take(), len(q.tasks), true
+}
+
+// take removes the task at the front of the queue, the lock must be held
+func (q *queue) take() queued {
+	item := heap.Pop(&q.tasks).(queued)
+	<-q.slots
+	return item
+}
+
+// planTaskTimeout is how long a cold start plan waits for each of its tasks
+const planTaskTimeout = time.Minute
+
+// popPlan is pop limited to the tasks of the given plan, which are always at
+// the front of the queue. It returns false once the bus is closed and none of
+// the plan's tasks are left, or if none arrives within timeout.
+func (q *queue) popPlan(plan *task.Plan, timeout time.Duration) (item queued, waiting int, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		q.mu.Lock()
+		expired = true
+		q.mu.Unlock()
+		q.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	for (len(q.tasks) == 0 || q.tasks[0].task.Plan != plan) && !q.closed && !expired {
+		q.cond.Wait()
+	}
+	if len(q.tasks) == 0 || q.tasks[0].task.Plan != plan {
+		return queued{}, 0, false
+	}
+	return q.take(), len(q.tasks), true
+}
@@ -0,0 +1,146 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestQueuePriority(t *testing.T) {
+	bus := make(chan task.ExecutionTask, 8)
+	for _, tt := range []struct {
+		name     string
+		priority int
+	}{
+		{"app1", 0},
+		{"app2", 0},
+		{"proxy", 10},
+		{"db", 5},
+		{"app3", 0},
+		{"cleanup", -1},
+		{"ingress", 10},
+	} {
+		bus <- task.ExecutionTask{Target: task.Target{Name: tt.name}, Priority: tt.priority}
+	}
+	close(bus)
+
+	q := newQueue(queueLimit)
+	q.feed(bus)
+
+	var order []string
+	for {
+		item, _, ok := q.pop()
+		if !ok {
+			break
+		}
+		assert.False(t, item.queued.IsZero())
+		order = append(order, item.task.Target.Name)
+	}
+	assert.Equal(t, []string{"proxy", "ingress", "db", "app1", "app2", "app3", "cleanup"}, order)
+}
+
+func TestQueueWaits(t *testing.T) {
+	q := newQueue(queueLimit)
+	bus := make(chan task.ExecutionTask)
+	go q.feed(bus)
+
+	popped := make(chan string)
+	go func() {
+		item, _, _ := q.pop()
+		popped <- item.task.Target.Name
+	}()
+
+	bus <- task.ExecutionTask{Target: task.Target{Name: "late"}}
+	assert.Equal(t, "late", <-popped)
+
+	close(bus)
+	_, _, ok := q.pop()
+	assert.False(t, ok)
+}
+
+func TestQueuePlanFirst(t *testing.T) {
+	plan := &task.Plan{Targets: []string{"network", "app", "proxy"}}
+	bus := make(chan task.ExecutionTask, 8)
+	bus <- task.ExecutionTask{Target: task.Target{Name: "manual"}, Priority: 100}
+	bus <- task.ExecutionTask{Target: task.Target{Name: "proxy"}, Priority: 10, Plan: plan}
+	bus <- task.ExecutionTask{Target: task.Target{Name: "app"}, Plan: plan}
+	bus <- task.ExecutionTask{Target: task.Target{Name: "network"}, Plan: plan}
+	close(bus)
+
+	q := newQueue(queueLimit)
+	q.feed(bus)
+
+	var order []string
+	for {
+		item, _, ok := q.popPlan(plan, time.Second)
+		if !ok {
+			break
+		}
+		order = append(order, item.task.Target.Name)
+	}
+	assert.Equal(t, []string{"network", "app", "proxy"}, order)
+
+	item, _, ok := q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, "manual", item.task.Target.Name)
+}
+
+func TestQueuePlanTimeout(t *testing.T) {
+	plan := &task.Plan{Targets: []string{"network", "app"}}
+	q := newQueue(queueLimit)
+	q.push(task.ExecutionTask{Target: task.Target{Name: "manual"}}, time.Now())
+
+	// the plan's tasks were dropped before they reached the queue
+	start := time.Now()
+	_, _, ok := q.popPlan(plan, 50*time.Millisecond)
+	assert.False(t, ok)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	item, _, ok := q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, "manual", item.task.Target.Name)
+}
+
+func TestQueueLimit(t *testing.T) {
+	q := newQueue(2)
+	bus := make(chan task.ExecutionTask)
+	go q.feed(bus)
+
+	bus <- task.ExecutionTask{Target: task.Target{Name: "a"}}
+	bus <- task.ExecutionTask{Target: task.Target{Name: "b"}}
+	select {
+	case bus <- task.ExecutionTask{Target: task.Target{Name: "c"}}:
+		// taken from the bus, feed is blocked on pushing it
+	case <-time.After(time.Second):
+		t.Fatal("the bus wasn't read")
+	}
+	blocked := make(chan struct{})
+	go func() {
+		bus <- task.ExecutionTask{Target: task.Target{Name: "d"}}
+		close(blocked)
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("a full queue took another task from the bus")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Len(t, q.snapshot(), 2)
+
+	item, _, _ := q.pop()
+	assert.Equal(t, "a", item.task.Target.Name)
+	<-blocked
+	close(bus)
+
+	var rest []string
+	for {
+		item, _, ok := q.pop()
+		if !ok {
+			break
+		}
+		rest = append(rest, item.task.Target.Name)
+	}
+	assert.Equal(t, []string{"b", "c", "d"}, rest)
+}
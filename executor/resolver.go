@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/redact"
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/task"
+)
+
+// GlobalSecretPrefix is the prefix of the secrets in Pico's configuration path
+// that are passed to every target.
+const GlobalSecretPrefix = "GLOBAL_"
+
+// SecretResolver resolves the secrets a target's commands are given: the
+// global secrets from Pico's configuration path, then the target's own secrets
+// after its secret_map and allowlist are applied. Executors other than the
+// command executor use it to resolve secrets the same way.
+type SecretResolver struct {
+	store      secret.Store
+	configPath string // path to global secrets to pass to every target
+	prefix     string // only global secrets with this prefix are passed
+	require    bool   // fail when a target's secret_map refers to missing secrets
+}
+
+// Secrets are the secrets resolved for a target
+type Secrets struct {
+	Global map[string]string // secrets from the configuration path, prefix removed
+	Target map[string]string // the target's secrets with its secret_map applied
+	Raw    map[string]string // the target's secrets as stored, for placeholders
+}
+
+// NewSecretResolver creates a resolver that reads secrets from store, globals
+// from configPath.
+func NewSecretResolver(store secret.Store, configPath string) *SecretResolver {
+	return &SecretResolver{
+		store:      store,
+		configPath: configPath,
+		prefix:     GlobalSecretPrefix,
+	}
+}
+
+// SetRequireSecrets makes resolving fail, rather than warn, when a target's
+// secret_map refers to a secret that doesn't exist.
+func (r *SecretResolver) SetRequireSecrets(require bool) {
+	r.require = require
+}
+
+// Store returns the secret store secrets are read from
+func (r *SecretResolver) Store() secret.Store {
+	return r.store
+}
+
+// Resolve reads the global and target secrets for a target, the store logs
+// the reads with the logger of ctx, see secret.WithLogger. Every value read is
+// redacted from task output and logs.
+func (r *SecretResolver) Resolve(ctx context.Context, t task.Target) (Secrets, error) {
+	// only secrets with the prefix are retrieved.
+	global, err := secret.GetPrefixedSecrets(ctx, r.store, r.configPath, r.prefix)
+	if err != nil {
+		return Secrets{}, errors.Wrap(err, "failed to get global secrets for target")
+	}
+
+	own, err := secret.Get(ctx, r.store, t.Name)
+	if err != nil {
+		return Secrets{}, errors.Wrap(err, "failed to get secrets for target")
+	}
+	mapped, err := mapSecrets(t, own, r.require)
+	if err != nil {
+		return Secrets{}, err
+	}
+	for _, secrets := range []map[string]string{global, own} {
+		for _, v := range secrets {
+			redact.Add(v)
+		}
+	}
+
+	return Secrets{Global: global, Target: mapped, Raw: own}, nil
+}
+
+// StaleSince returns the oldest fetch time of stale secrets last read for the
+// target, or nil if none of its secrets were stale.
+func (r *SecretResolver) StaleSince(target string) *time.Time {
+	sr, ok := secret.FindStaleReporter(r.store)
+	if !ok {
+		return nil
+	}
+	var oldest *time.Time
+	for _, name := range []string{r.configPath, target} {
+		if t, stale := sr.StaleSince(name); stale && (oldest == nil || t.Before(*oldest)) {
+			t := t
+			oldest = &t
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,92 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// mapSecrets applies a target's secret map and allowlist to the secrets read
+// for it. A mapping from a secret that doesn't exist is logged, or returned as
+// an error if require is set.
+func mapSecrets(t task.Target, secrets map[string]string, require bool) (map[string]string, error) {
+	if len(t.SecretMap) == 0 && len(t.SecretAllowlist) == 0 {
+		return secrets, nil
+	}
+
+	out := make(map[string]string, len(secrets))
+	for k, v := range secrets {
+		out[k] = v
+	}
+
+	if len(t.SecretMap) > 0 {
+		// sources are removed first so a mapping never depends on the order in
+		// which other mappings are applied, such as when two keys are swapped.
+		for _, from := range t.SecretMap {
+			delete(out, from)
+		}
+		for to, from := range t.SecretMap {
+			v, ok := secrets[from]
+			if !ok {
+				if require {
+					return nil, errors.Errorf("secret_map refers to missing secret '%s' for '%s'", from, to)
+				}
+				zap.L().Warn("secret_map refers to a missing secret",
+					zap.String("target", t.Name),
+					t.LabelsField(),
+					zap.String("secret", from),
+					zap.String("env", to))
+				continue
+			}
+			out[to] = v
+		}
+	}
+
+	if len(t.SecretAllowlist) > 0 {
+		allowed := make(map[string]bool, len(t.SecretAllowlist))
+		for _, k := range t.SecretAllowlist {
+			allowed[k] = true
+		}
+		for k := range out {
+			if !allowed[k] {
+				delete(out, k)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// MissingSecretsError is returned for a task that isn't run because some of
+// its target's required secrets are missing or empty.
+type MissingSecretsError struct {
+	Keys []string
+}
+
+func (e *MissingSecretsError) Error() string {
+	return fmt.Sprintf("missing required secrets %s", strings.Join(e.Keys, ", "))
+}
+
+// checkRequired verifies that every required secret of a target is set in its
+// environment, which includes Pico's own environment when it's passed through.
+func checkRequired(t task.Target, env map[string]string, passEnvironment bool) error {
+	var missing []string
+	for _, k := range t.RequiredSecrets {
+		v, ok := env[k]
+		if !ok && passEnvironment {
+			v = os.Getenv(k)
+		}
+		if v == "" {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingSecretsError{Keys: missing}
+	}
+	return nil
+}
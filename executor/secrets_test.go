@@ -0,0 +1,89 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestMapSecrets(t *testing.T) {
+	secrets := map[string]string{
+		"database_password": "hunter2",
+		"database_user":     "app",
+		"API_KEY":           "key",
+	}
+	tests := []struct {
+		name    string
+		target  task.Target
+		require bool
+		want    map[string]string
+		wantErr string
+	}{
+		{"none", task.Target{}, false, secrets, ""},
+		{
+			"map",
+			task.Target{SecretMap: map[string]string{"DB_PASS": "database_password"}},
+			false,
+			map[string]string{"DB_PASS": "hunter2", "database_user": "app", "API_KEY": "key"},
+			"",
+		},
+		{
+			"swap",
+			task.Target{SecretMap: map[string]string{"database_user": "database_password", "database_password": "database_user"}},
+			false,
+			map[string]string{"database_user": "hunter2", "database_password": "app", "API_KEY": "key"},
+			"",
+		},
+		{
+			"missing",
+			task.Target{SecretMap: map[string]string{"DB_PASS": "db_password"}},
+			false,
+			secrets,
+			"",
+		},
+		{
+			"required",
+			task.Target{SecretMap: map[string]string{"DB_PASS": "db_password"}},
+			true,
+			nil,
+			"secret_map refers to missing secret 'db_password' for 'DB_PASS'",
+		},
+		{
+			"allowlist",
+			task.Target{
+				SecretMap:       map[string]string{"DB_PASS": "database_password"},
+				SecretAllowlist: []string{"DB_PASS", "OTHER"},
+			},
+			false,
+			map[string]string{"DB_PASS": "hunter2"},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapSecrets(tt.target, secrets, tt.require)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCheckRequired(t *testing.T) {
+	target := task.Target{RequiredSecrets: []string{"DB_PASSWORD", "API_KEY", "EMPTY"}}
+
+	err := checkRequired(target, map[string]string{"DB_PASSWORD": "hunter2", "EMPTY": ""}, false)
+	assert.EqualError(t, err, "missing required secrets API_KEY, EMPTY")
+	var mse *MissingSecretsError
+	assert.True(t, errors.As(err, &mse))
+	assert.Equal(t, []string{"API_KEY", "EMPTY"}, mse.Keys)
+
+	assert.NoError(t, checkRequired(target, map[string]string{"DB_PASSWORD": "a", "API_KEY": "b", "EMPTY": "c"}, false))
+	assert.NoError(t, checkRequired(task.Target{}, nil, false))
+}
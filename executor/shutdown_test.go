@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+func TestShutdown(t *testing.T) {
+	grace := task.GracePeriod
+	task.GracePeriod = 100 * time.Millisecond
+	defer func() { task.GracePeriod = grace }()
+
+	tests := []struct {
+		name        string
+		down        []string
+		cleanup     bool
+		wantErr     bool
+		wantRemoved bool
+	}{
+		{"success", []string{"true"}, false, false, true},
+		{"failure", []string{"false"}, false, true, false},
+		{"failure with cleanup", []string{"false"}, true, true, true},
+		{"timeout", []string{"sleep", "10"}, false, true, false},
+		{"timeout with cleanup", []string{"sleep", "10"}, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "pico-shutdown")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "env.tmpl"), []byte("A=1\n"), 0o644))
+
+			ce := NewCommandExecutor(&memory.MemorySecrets{}, false, "pico")
+			target := task.Target{
+				Name:                    "app",
+				Down:                    tt.down,
+				ShutdownTimeout:         task.Duration(200 * time.Millisecond),
+				CleanupOnFailedShutdown: tt.cleanup,
+				Templates:               []task.Template{{Source: "env.tmpl", Output: ".env"}},
+			}
+
+			start := time.Now()
+			err = ce.execute(context.Background(), target, dir, "", true, nil, nil)
+			assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			_, err = os.Stat(filepath.Join(dir, ".env"))
+			assert.Equal(t, tt.wantRemoved, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestShutdownTimeoutDefault(t *testing.T) {
+	assert.Equal(t, task.DefaultShutdownTimeout, (&task.Target{}).GetShutdownTimeout())
+	assert.Equal(t, time.Minute*5, (&task.Target{ShutdownTimeout: task.Duration(time.Minute * 5)}).GetShutdownTimeout())
+}
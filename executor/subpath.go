@@ -0,0 +1,40 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/task"
+)
+
+// workDir returns the directory the target's commands run in for its checkout
+// at path, its subpath if it has one. The subpath must be a directory that
+// stays inside the checkout once symbolic links are resolved.
+func workDir(target task.Target, path string) (string, error) {
+	dir := target.WorkDir(path)
+	if dir == path {
+		return dir, nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", errors.Wrapf(err, "subpath %s of target %s does not exist", target.Subpath, target.Name)
+	}
+	if !info.IsDir() {
+		return "", errors.Errorf("subpath %s of target %s is not a directory", target.Subpath, target.Name)
+	}
+
+	root, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve checkout")
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve subpath %s", target.Subpath)
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !task.Contained(rel) && rel != "." {
+		return "", errors.Errorf("subpath %s of target %s leaves the repository", target.Subpath, target.Name)
+	}
+	return dir, nil
+}
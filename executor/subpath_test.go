@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+func TestWorkDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-subpath")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clone := filepath.Join(dir, "clone")
+	require.NoError(t, os.MkdirAll(filepath.Join(clone, "deployments", "edge"), 0o700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(clone, "README"), nil, 0o600))
+	require.NoError(t, os.Symlink(dir, filepath.Join(clone, "outside")))
+
+	tests := []struct {
+		subpath string
+		want    string
+		wantErr string
+	}{
+		{"", clone, ""},
+		{"deployments/edge", filepath.Join(clone, "deployments", "edge"), ""},
+		{"missing", "", "subpath missing of target app does not exist"},
+		{"README", "", "subpath README of target app is not a directory"},
+		{"outside", "", "subpath outside of target app leaves the repository"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subpath, func(t *testing.T) {
+			got, err := workDir(task.Target{Name: "app", Subpath: tt.subpath}, clone)
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCommandExecutorSubpath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-subpath")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "deployments", "edge"), 0o700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".env"), []byte("TAG=1\n"), 0o600))
+
+	// commands run in the subpath, env files are still read from the root
+	ce := NewCommandExecutor(&memory.MemorySecrets{}, false, "pico")
+	target := task.Target{
+		Name:    "edge",
+		Subpath: "deployments/edge",
+		EnvFile: []string{".env"},
+		Up:      []string{"sh", "-c", "echo $TAG > ran"},
+	}
+	require.NoError(t, ce.execute(context.Background(), target, dir, "", false, nil, nil))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "deployments", "edge", "ran"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n", string(b))
+}
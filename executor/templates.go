@@ -0,0 +1,218 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// templateData is available to target templates
+type templateData struct {
+	Env     map[string]string // the command's full environment
+	Secrets map[string]string // the global and target secrets
+	Target  task.Target
+	Commit  string
+}
+
+// renderTemplates renders the target's templates into the working directory.
+// Outputs may hold secrets, so they're only readable by Pico and, in a clone,
+// excluded from git so they never show up as changes.
+func renderTemplates(ctx context.Context, ex exec, commit string) error {
+	if len(ex.target.Templates) == 0 {
+		return nil
+	}
+	data := templateData{
+		Env:     ex.env,
+		Secrets: ex.secrets,
+		Target:  ex.target,
+		Commit:  commit,
+	}
+
+	var outputs []string
+	for _, tpl := range ex.target.Templates {
+		if !containedOnDisk(ex.path, tpl.Source) || !containedOnDisk(ex.path, tpl.Output) {
+			return errors.Errorf("template paths must be inside the repository: %s -> %s", tpl.Source, tpl.Output)
+		}
+		if err := renderTemplate(ex.path, tpl, data); err != nil {
+			return errors.Wrapf(err, "failed to render template %s", tpl.Source)
+		}
+		outputs = append(outputs, filepath.ToSlash(filepath.Clean(tpl.Output)))
+	}
+
+	if err := excludeFromGit(ex.path, outputs); err != nil {
+		logger(ctx).Warn("failed to exclude rendered templates from git",
+			zap.Error(err))
+	}
+	return nil
+}
+
+// removeRendered removes the outputs of the target's templates after it was
+// torn down, as they may hold secrets. If the teardown failed they're kept for
+// tearing the target down by hand, unless the target opts to clean up anyway.
+func removeRendered(ctx context.Context, ex exec, shutdownErr error) {
+	if len(ex.target.Templates) == 0 {
+		return
+	}
+	if shutdownErr != nil && !ex.target.CleanupOnFailedShutdown {
+		logger(ctx).Warn("keeping rendered templates of target after failed shutdown")
+		return
+	}
+	for _, tpl := range ex.target.Templates {
+		if !containedOnDisk(ex.path, tpl.Output) {
+			continue
+		}
+		out := filepath.Join(ex.path, tpl.Output)
+		if err := os.Remove(out); err != nil && !os.IsNotExist(err) {
+			logger(ctx).Warn("failed to remove rendered template",
+				zap.String("path", out),
+				zap.Error(err))
+		}
+	}
+}
+
+// containedOnDisk reports whether a path relative to dir stays inside it once
+// symlinks are followed, as a checkout may link anywhere. The parts of the path
+// that don't exist yet are taken as they are.
+func containedOnDisk(dir, path string) bool {
+	if !task.Contained(path) {
+		return false
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	full, rest := filepath.Join(dir, path), ""
+	for {
+		resolved, err := filepath.EvalSymlinks(full)
+		if err == nil {
+			full = filepath.Join(resolved, rest)
+			break
+		}
+		if !os.IsNotExist(err) {
+			return false
+		}
+		parent := filepath.Dir(full)
+		if parent == full {
+			return false
+		}
+		rest = filepath.Join(filepath.Base(full), rest)
+		full = parent
+	}
+	rel, err := filepath.Rel(root, full)
+	return err == nil && task.Contained(rel)
+}
+
+func renderTemplate(dir string, tpl task.Template, data templateData) error {
+	src, err := ioutil.ReadFile(filepath.Join(dir, tpl.Source))
+	if err != nil {
+		return err
+	}
+	t, err := template.New(tpl.Source).Option("missingkey=error").Parse(string(src))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	// written to a temporary file first so an existing output's permissions
+	// don't carry over.
+	out := filepath.Join(dir, tpl.Output)
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	tmp := out + ".pico-tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		os.Remove(tmp) //nolint:errcheck
+		return err
+	}
+	return os.Rename(tmp, out)
+}
+
+// gitDir finds the git directory of a checkout. In worktrees and submodules
+// .git is a file pointing at it, and worktrees share the info directory of the
+// repository they belong to. It's empty if dir isn't a checkout.
+func gitDir(dir string) (string, error) {
+	dotGit := filepath.Join(dir, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return "", nil
+	}
+	if info.IsDir() {
+		return dotGit, nil
+	}
+	content, err := ioutil.ReadFile(dotGit)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "", errors.Errorf("malformed .git file: %s", dotGit)
+	}
+	git := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+	if !filepath.IsAbs(git) {
+		git = filepath.Join(dir, git)
+	}
+	content, err = ioutil.ReadFile(filepath.Join(git, "commondir"))
+	if os.IsNotExist(err) {
+		return git, nil
+	} else if err != nil {
+		return "", err
+	}
+	common := strings.TrimSpace(string(content))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(git, common)
+	}
+	return common, nil
+}
+
+// excludeFromGit adds paths to the repository's local exclude file, if the
+// directory is a clone, so rendered files aren't reported as changes.
+func excludeFromGit(dir string, paths []string) error {
+	git, err := gitDir(dir)
+	if err != nil || git == "" {
+		return err
+	}
+	info := filepath.Join(git, "info")
+	exclude := filepath.Join(info, "exclude")
+	existing, err := ioutil.ReadFile(exclude)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := make(map[string]bool)
+	for _, l := range strings.Split(string(existing), "\n") {
+		lines[strings.TrimSpace(l)] = true
+	}
+
+	var add []string
+	for _, p := range paths {
+		if !lines["/"+p] {
+			add = append(add, "/"+p)
+		}
+	}
+	if len(add) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(info, 0o755); err != nil {
+		return err
+	}
+	content := string(existing)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += strings.Join(add, "\n") + "\n"
+	return ioutil.WriteFile(exclude, []byte(content), 0o644)
+}
@@ -0,0 +1,133 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git", "info"), 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "env.tmpl"),
+		[]byte("NAME={{.Target.Name}}\nMODE={{.Env.MODE}}\nPASSWORD={{.Secrets.PASSWORD}}\nCOMMIT={{.Commit}}\n"), 0o644))
+
+	ex := exec{
+		path:    dir,
+		env:     map[string]string{"MODE": "prod"},
+		secrets: map[string]string{"PASSWORD": "hunter2"},
+		target: task.Target{Name: "app", Templates: []task.Template{
+			{Source: "env.tmpl", Output: "config/.env"},
+		}},
+	}
+	require.NoError(t, renderTemplates(context.Background(), ex, "abc123"))
+	// rendering again doesn't duplicate the exclusion
+	require.NoError(t, renderTemplates(context.Background(), ex, "abc123"))
+
+	out := filepath.Join(dir, "config", ".env")
+	content, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "NAME=app\nMODE=prod\nPASSWORD=hunter2\nCOMMIT=abc123\n", string(content))
+
+	info, err := os.Stat(out)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	exclude, err := ioutil.ReadFile(filepath.Join(dir, ".git", "info", "exclude"))
+	require.NoError(t, err)
+	assert.Equal(t, "/config/.env\n", string(exclude))
+}
+
+func TestRenderTemplatesErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "missing.tmpl"), []byte("{{.Secrets.NOPE}}"), 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.tmpl"), []byte("{{.Env"), 0o644))
+
+	for _, tt := range []struct {
+		name     string
+		template task.Template
+		wantErr  string
+	}{
+		{"missing key", task.Template{Source: "missing.tmpl", Output: "out"}, "failed to render template missing.tmpl"},
+		{"parse", task.Template{Source: "broken.tmpl", Output: "out"}, "failed to render template broken.tmpl"},
+		{"no source", task.Template{Source: "nope.tmpl", Output: "out"}, "failed to render template nope.tmpl"},
+		{"escape", task.Template{Source: "missing.tmpl", Output: "../out"}, "template paths must be inside the repository: missing.tmpl -> ../out"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := exec{path: dir, target: task.Target{Name: "app", Templates: []task.Template{tt.template}}}
+			err := renderTemplates(context.Background(), ex, "")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			_, statErr := os.Stat(filepath.Join(dir, "out"))
+			assert.True(t, os.IsNotExist(statErr))
+		})
+	}
+}
+
+func TestRenderTemplatesSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempDir("", "pico-outside")
+	require.NoError(t, err)
+	defer os.RemoveAll(outside)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(outside, "passwd"), []byte("root"), 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "env.tmpl"), []byte("x"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "passwd"), filepath.Join(dir, "linked.tmpl")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "etc")))
+
+	for _, tt := range []struct {
+		name     string
+		template task.Template
+	}{
+		{"source", task.Template{Source: "linked.tmpl", Output: "out"}},
+		{"output", task.Template{Source: "env.tmpl", Output: "etc/out"}},
+		{"output directory", task.Template{Source: "env.tmpl", Output: "etc/new/out"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := exec{path: dir, target: task.Target{Name: "app", Templates: []task.Template{tt.template}}}
+			err := renderTemplates(context.Background(), ex, "")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "template paths must be inside the repository")
+		})
+	}
+	_, err = os.Stat(filepath.Join(outside, "out"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(outside, "new"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRenderTemplatesWorktree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// a worktree's .git file points into the main repository, which holds the
+	// shared info directory
+	common := filepath.Join(dir, "main", ".git")
+	require.NoError(t, os.MkdirAll(filepath.Join(common, "worktrees", "app"), 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(common, "worktrees", "app", "commondir"), []byte("../..\n"), 0o644))
+	checkout := filepath.Join(dir, "app")
+	require.NoError(t, os.MkdirAll(checkout, 0o755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(checkout, ".git"), []byte("gitdir: ../main/.git/worktrees/app\n"), 0o644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(checkout, "env.tmpl"), []byte("x"), 0o644))
+
+	ex := exec{path: checkout, target: task.Target{Name: "app", Templates: []task.Template{
+		{Source: "env.tmpl", Output: ".env"},
+	}}}
+	require.NoError(t, renderTemplates(context.Background(), ex, ""))
+
+	exclude, err := ioutil.ReadFile(filepath.Join(common, "info", "exclude"))
+	require.NoError(t, err)
+	assert.Equal(t, "/.env\n", string(exclude))
+}
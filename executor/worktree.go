@@ -0,0 +1,50 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// useWorktree reports whether a task is run in a dedicated checkout, only if
+// worktrees are enabled. Shutdown tasks run in place since their target is no
+// longer fetched, as do targets that opt out because they need a stable path,
+// such as for bind mounts, and targets cloned to a directory of their own.
+// Targets checked out as archives have no files in place, so their tasks always
+// run in a checkout of their own.
+func (e *CommandExecutor) useWorktree(t task.ExecutionTask) bool {
+	if t.Target.GetCheckout() == task.CheckoutArchive {
+		return true
+	}
+	return e.worktrees != "" && !t.Shutdown && !t.Target.InPlace && t.Target.Directory == "" && t.Commit != ""
+}
+
+// checkoutWorktree writes the files of a commit of the repository at path that
+// are within paths, or every file if paths is nil, to a new directory under
+// root. The directory has the same name as path, so tools that derive names
+// from it, such as Docker Compose project names, behave the same as in the
+// clone. The returned function removes the directory.
+func checkoutWorktree(root, path, commit string, paths []string) (string, func(), error) {
+	parent := filepath.Join(root, fmt.Sprintf("%s-%s-%d", filepath.Base(path), commit[:7], time.Now().UnixNano()))
+	dir := filepath.Join(parent, filepath.Base(path))
+	cleanup := func() {
+		if err := os.RemoveAll(parent); err != nil {
+			zap.L().Warn("failed to remove worktree", zap.String("path", parent), zap.Error(err))
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", nil, errors.Wrap(err, "failed to create worktree")
+	}
+
+	if _, err := task.WriteTree(path, commit, dir, paths); err != nil {
+		cleanup()
+		return "", nil, errors.Wrap(err, "failed to check out worktree")
+	}
+	return dir, cleanup, nil
+}
@@ -0,0 +1,83 @@
+package executor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestCheckoutWorktree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-worktree")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app")
+	repo, err := git.PlainInit(path, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	commit := func(files map[string]string) string {
+		for name, content := range files {
+			require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0o755))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(path, name), []byte(content), 0o755))
+			_, err := wt.Add(name)
+			require.NoError(t, err)
+		}
+		h, err := wt.Commit("commit", &git.CommitOptions{Author: &object.Signature{Name: "pico", Email: "pico@example.com", When: time.Now()}})
+		require.NoError(t, err)
+		return h.String()
+	}
+	first := commit(map[string]string{"run.sh": "v1", "conf/app.yml": "a: 1"})
+	commit(map[string]string{"run.sh": "v2"})
+
+	out, cleanup, err := checkoutWorktree(filepath.Join(dir, ".pico-worktrees"), path, first, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "app", filepath.Base(out))
+
+	b, err := ioutil.ReadFile(filepath.Join(out, "run.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(b))
+	info, err := os.Stat(filepath.Join(out, "run.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+	b, err = ioutil.ReadFile(filepath.Join(out, "conf", "app.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a: 1", string(b))
+
+	cleanup()
+	_, err = os.Stat(filepath.Dir(out))
+	assert.True(t, os.IsNotExist(err))
+
+	// only the paths of a sparse or archive checkout are written
+	out, cleanup, err = checkoutWorktree(dir, path, first, []string{"conf"})
+	require.NoError(t, err)
+	defer cleanup()
+	assert.FileExists(t, filepath.Join(out, "conf", "app.yml"))
+	_, err = os.Stat(filepath.Join(out, "run.sh"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, _, err = checkoutWorktree(dir, path, "0000000000000000000000000000000000000000", nil)
+	assert.Error(t, err)
+}
+
+func TestUseWorktree(t *testing.T) {
+	e := &CommandExecutor{worktrees: "/data/.pico-worktrees"}
+	assert.True(t, e.useWorktree(task.ExecutionTask{Commit: "abc"}))
+	assert.False(t, e.useWorktree(task.ExecutionTask{Commit: "abc", Shutdown: true}))
+	assert.False(t, e.useWorktree(task.ExecutionTask{Commit: "abc", Target: task.Target{InPlace: true}}))
+	assert.False(t, e.useWorktree(task.ExecutionTask{Commit: "abc", Target: task.Target{Directory: "/srv/app"}}))
+	assert.False(t, e.useWorktree(task.ExecutionTask{}))
+	assert.False(t, (&CommandExecutor{}).useWorktree(task.ExecutionTask{Commit: "abc"}))
+	// archive checkouts have nothing to run in place
+	assert.True(t, (&CommandExecutor{}).useWorktree(task.ExecutionTask{Shutdown: true, Target: task.Target{Checkout: task.CheckoutArchive}}))
+}
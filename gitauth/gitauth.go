@@ -0,0 +1,93 @@
+// Package gitauth builds the authentication methods used for git operations
+// over HTTP, both for configuration repositories and target repositories.
+package gitauth
+
+import (
+	"fmt"
+	nethttp "net/http"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// Mode selects how credentials are sent to a git server
+type Mode string
+
+const (
+	// ModeAuto uses basic auth when there's a username and token auth when
+	// there's only a password or token.
+	ModeAuto Mode = ""
+	// ModeBasic sends the username and password as HTTP basic auth
+	ModeBasic Mode = "basic"
+	// ModeToken sends the password as an `Authorization: token` header
+	ModeToken Mode = "token"
+)
+
+// ParseMode validates a mode from the command line or configuration
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeAuto, ModeBasic, ModeToken:
+		return m, nil
+	}
+	return "", errors.Errorf("unknown git auth mode '%s', must be basic or token", s)
+}
+
+// New creates the authentication method for the mode from a username and a
+// password or token. It returns nil if there are no credentials.
+func New(mode Mode, username, password string) (transport.AuthMethod, error) {
+	switch mode {
+	case ModeAuto:
+		if password == "" {
+			return nil, nil
+		}
+		if username == "" {
+			return &GitTokenAuth{Token: password}, nil
+		}
+		return &http.BasicAuth{Username: username, Password: password}, nil
+
+	case ModeBasic:
+		if username == "" || password == "" {
+			return nil, errors.New("basic git auth requires a username and password")
+		}
+		return &http.BasicAuth{Username: username, Password: password}, nil
+
+	case ModeToken:
+		if password == "" {
+			return nil, errors.New("token git auth requires a token")
+		}
+		return &GitTokenAuth{Token: password}, nil
+	}
+	return nil, errors.Errorf("unknown git auth mode '%s'", mode)
+}
+
+var _ http.AuthMethod = &GitTokenAuth{}
+
+// GitTokenAuth implements an HTTP auth method for git servers that expect a
+// personal access token in an `Authorization: token <token>` header, such as
+// GitHub Enterprise behind some SSO proxies, rather than as a basic auth
+// password. go-git's own TokenAuth sends a bearer token instead.
+type GitTokenAuth struct {
+	Token string
+}
+
+// SetAuth implements http.AuthMethod
+func (a *GitTokenAuth) SetAuth(r *nethttp.Request) {
+	if a == nil {
+		return
+	}
+	r.Header.Set("Authorization", "token "+a.Token)
+}
+
+// Name implements transport.AuthMethod
+func (a *GitTokenAuth) Name() string {
+	return "http-git-token-auth"
+}
+
+func (a *GitTokenAuth) String() string {
+	masked := "*******"
+	if a.Token == "" {
+		masked = "<empty>"
+	}
+	return fmt.Sprintf("%s - %s", a.Name(), masked)
+}
@@ -0,0 +1,58 @@
+package gitauth
+
+import (
+	nethttp "net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		mode     Mode
+		username string
+		password string
+		want     transport.AuthMethod
+		wantErr  bool
+	}{
+		{"none", ModeAuto, "", "", nil, false},
+		{"auto basic", ModeAuto, "user", "pass", &http.BasicAuth{Username: "user", Password: "pass"}, false},
+		{"auto token", ModeAuto, "", "pat", &GitTokenAuth{Token: "pat"}, false},
+		{"explicit token", ModeToken, "user", "pat", &GitTokenAuth{Token: "pat"}, false},
+		{"explicit basic", ModeBasic, "user", "pass", &http.BasicAuth{Username: "user", Password: "pass"}, false},
+		{"basic without user", ModeBasic, "", "pass", nil, true},
+		{"token without token", ModeToken, "user", "", nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.mode, tt.username, tt.password)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGitTokenAuth(t *testing.T) {
+	r, err := nethttp.NewRequest("GET", "https://github.example.com/org/repo.git/info/refs", nil)
+	assert.NoError(t, err)
+
+	a := &GitTokenAuth{Token: "pat"}
+	a.SetAuth(r)
+	assert.Equal(t, "token pat", r.Header.Get("Authorization"))
+	assert.Equal(t, "http-git-token-auth - *******", a.String())
+}
+
+func TestParseMode(t *testing.T) {
+	m, err := ParseMode("token")
+	assert.NoError(t, err)
+	assert.Equal(t, ModeToken, m)
+
+	_, err = ParseMode("bearer")
+	assert.Error(t, err)
+}
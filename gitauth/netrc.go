@@ -0,0 +1,158 @@
+package gitauth
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// Resolver returns the authentication method for a repository URL, or nil if
+// it has no credentials for it.
+type Resolver func(repoURL string) transport.AuthMethod
+
+// NetrcEntry is a machine entry of a netrc file, the default entry has an
+// empty Machine.
+type NetrcEntry struct {
+	Machine  string
+	Login    string
+	Password string
+}
+
+// DefaultNetrcPath returns $HOME/.netrc, or an empty string if there's no home
+// directory.
+func DefaultNetrcPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// ParseNetrc reads the machine and default entries of a netrc file. Macro
+// definitions and account tokens are ignored.
+func ParseNetrc(r io.Reader) ([]NetrcEntry, error) {
+	var (
+		entries []NetrcEntry
+		current *NetrcEntry
+		inMacro bool
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// a macro definition ends at the first empty line
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			token := fields[i]
+			switch token {
+			case "default":
+				entries = append(entries, NetrcEntry{})
+				current = &entries[len(entries)-1]
+				continue
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+				continue
+			}
+
+			if i+1 >= len(fields) {
+				return nil, errors.Errorf("netrc token '%s' has no value", token)
+			}
+			i++
+			value := fields[i]
+
+			switch token {
+			case "machine":
+				entries = append(entries, NetrcEntry{Machine: value})
+				current = &entries[len(entries)-1]
+			case "login", "password", "account":
+				if current == nil {
+					return nil, errors.Errorf("netrc token '%s' outside of a machine entry", token)
+				}
+				if token == "login" {
+					current.Login = value
+				} else if token == "password" {
+					current.Password = value
+				}
+			default:
+				return nil, errors.Errorf("unknown netrc token '%s'", token)
+			}
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// FindNetrc returns the entry for a host, falling back to the default entry
+func FindNetrc(entries []NetrcEntry, host string) (NetrcEntry, bool) {
+	var fallback *NetrcEntry
+	for i, e := range entries {
+		if e.Machine == "" {
+			if fallback == nil {
+				fallback = &entries[i]
+			}
+			continue
+		}
+		if strings.EqualFold(e.Machine, host) {
+			return e, true
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return NetrcEntry{}, false
+}
+
+// NetrcResolver creates a resolver that reads basic auth credentials for the
+// host of an HTTP repository URL from the netrc file at path. The file is read
+// on every call, so changes made by other tools are picked up. A missing or
+// malformed file, or one without an entry for the host, is not an error.
+func NetrcResolver(path string) Resolver {
+	return func(repoURL string) transport.AuthMethod {
+		if path == "" {
+			return nil
+		}
+		u, err := url.Parse(repoURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			zap.L().Debug("not using netrc", zap.String("path", path), zap.Error(err))
+			return nil
+		}
+		defer f.Close()
+
+		entries, err := ParseNetrc(f)
+		if err != nil {
+			zap.L().Debug("failed to parse netrc", zap.String("path", path), zap.Error(err))
+			return nil
+		}
+		e, ok := FindNetrc(entries, u.Hostname())
+		if !ok || e.Login == "" {
+			zap.L().Debug("no netrc entry for host", zap.String("host", u.Hostname()))
+			return nil
+		}
+
+		zap.L().Debug("using netrc credentials", zap.String("host", u.Hostname()), zap.String("login", e.Login))
+		return &http.BasicAuth{Username: e.Login, Password: e.Password}
+	}
+}
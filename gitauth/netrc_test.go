@@ -0,0 +1,69 @@
+package gitauth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+const netrc = `# maintained elsewhere
+machine github.example.com
+	login deploy
+	password s3cret
+
+machine gitlab.example.com login ci password token123 account ignored
+
+macdef init
+	cd /pub
+	machine notreal.example.com login x password y
+
+default login anonymous password guest
+`
+
+func TestParseNetrc(t *testing.T) {
+	entries, err := ParseNetrc(strings.NewReader(netrc))
+	assert.NoError(t, err)
+	assert.Equal(t, []NetrcEntry{
+		{Machine: "github.example.com", Login: "deploy", Password: "s3cret"},
+		{Machine: "gitlab.example.com", Login: "ci", Password: "token123"},
+		{Login: "anonymous", Password: "guest"},
+	}, entries)
+
+	e, ok := FindNetrc(entries, "GitHub.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "deploy", e.Login)
+
+	e, ok = FindNetrc(entries, "other.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "anonymous", e.Login)
+
+	_, ok = FindNetrc(entries[:2], "other.example.com")
+	assert.False(t, ok)
+
+	_, err = ParseNetrc(strings.NewReader("machine"))
+	assert.Error(t, err)
+	_, err = ParseNetrc(strings.NewReader("login x"))
+	assert.Error(t, err)
+}
+
+func TestNetrcResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netrc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".netrc")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(netrc), 0o600))
+
+	r := NetrcResolver(path)
+	assert.Equal(t, &http.BasicAuth{Username: "deploy", Password: "s3cret"}, r("https://github.example.com/org/repo.git"))
+	assert.Nil(t, r("git@github.example.com:org/repo.git"))
+
+	assert.Nil(t, NetrcResolver(filepath.Join(dir, "missing"))("https://github.example.com/org/repo"))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("machine"), 0o600))
+	assert.Nil(t, r("https://github.example.com/org/repo.git"))
+}
@@ -0,0 +1,101 @@
+// Package httpclient builds the HTTP client shared by Pico's outbound
+// integrations, such as notification webhooks, Grafana annotations and the
+// Pushgateway, so they all get the same timeout, proxy, TLS and User-Agent
+// configuration and the same redaction of failed requests.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/redact"
+)
+
+// DefaultTimeout is how long each outbound request may take unless configured
+// otherwise. Callers may set shorter deadlines on the request's context.
+const DefaultTimeout = 30 * time.Second
+
+// Config configures outbound requests
+type Config struct {
+	Timeout  time.Duration // per request, DefaultTimeout when zero
+	Proxy    string        // proxy for every request, HTTPS_PROXY and friends when empty
+	CABundle string        // PEM file of CAs trusted in addition to the system's
+}
+
+// New creates a client for outbound integrations. Requests carry Pico's
+// User-Agent and time out after the configured timeout.
+func New(c Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, errors.Errorf("invalid proxy URL '%s'", RedactURL(c.Proxy))
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if c.CABundle != "" {
+		pem, err := ioutil.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CA bundle")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("CA bundle %s holds no PEM certificates", c.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{Timeout: timeout, Transport: buildinfo.Transport(transport)}, nil
+}
+
+// Default returns a client with the default configuration
+func Default() *http.Client {
+	return &http.Client{Timeout: DefaultTimeout, Transport: buildinfo.Transport(nil)}
+}
+
+// Error redacts the error of a failed outbound request for logging. The URL of
+// the request is reduced to its scheme and host, since the paths and queries of
+// webhook URLs often hold tokens, and registered secrets are redacted.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		redacted := *urlErr
+		redacted.URL = RedactURL(urlErr.URL)
+		msg := strings.Replace(err.Error(), urlErr.Error(), redacted.Error(), 1)
+		return errors.New(redact.String(msg))
+	}
+	return errors.New(redact.String(err.Error()))
+}
+
+// RedactURL reduces a URL to its scheme and host, so a URL with credentials in
+// its path or query, such as a webhook URL, can be logged
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return redact.Replacement
+	}
+	if u.Path == "" && u.RawQuery == "" && u.User == nil {
+		return u.Scheme + "://" + u.Host
+	}
+	return u.Scheme + "://" + u.Host + "/" + redact.Replacement
+}
@@ -0,0 +1,105 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/redact"
+)
+
+func TestNew(t *testing.T) {
+	c, err := New(Config{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTimeout, c.Timeout)
+
+	c, err = New(Config{Timeout: time.Second, Proxy: "http://proxy.internal:3128"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, c.Timeout)
+
+	_, err = New(Config{Proxy: "not a url"})
+	assert.EqualError(t, err, "invalid proxy URL '[REDACTED]'")
+
+	_, err = New(Config{CABundle: "/nonexistent/ca.pem"})
+	assert.Error(t, err)
+}
+
+func TestNewCABundle(t *testing.T) {
+	var agent string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = r.UserAgent()
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "pico-httpclient")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0o600))
+
+	// the test server's certificate is only trusted with the bundle
+	_, err = Default().Get(srv.URL)
+	assert.Error(t, err)
+
+	c, err := New(Config{CABundle: bundle})
+	require.NoError(t, err)
+	resp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, buildinfo.UserAgent(), agent)
+
+	require.NoError(t, ioutil.WriteFile(bundle, []byte("not a certificate"), 0o600))
+	_, err = New(Config{CABundle: bundle})
+	assert.EqualError(t, err, "CA bundle "+bundle+" holds no PEM certificates")
+}
+
+func TestError(t *testing.T) {
+	redact.Add("s3cret-value")
+
+	err := Error(errors.Wrap(&url.Error{
+		Op:  "Post",
+		URL: "https://discord.com/api/webhooks/123/t0ken?wait=true",
+		Err: errors.New("connection refused"),
+	}, "failed"))
+	assert.NotContains(t, err.Error(), "t0ken")
+	assert.Contains(t, err.Error(), "https://discord.com/[REDACTED]")
+	assert.Contains(t, err.Error(), "connection refused")
+
+	err = Error(&url.Error{Op: "Get", URL: "http://grafana:3000", Err: errors.New("timeout")})
+	assert.Contains(t, err.Error(), "http://grafana:3000")
+
+	assert.EqualError(t, Error(errors.New("token s3cret-value rejected")), "token [REDACTED] rejected")
+	assert.NoError(t, Error(nil))
+}
+
+func TestRecorder(t *testing.T) {
+	rec := &Recorder{}
+	resp, err := rec.Client().Post("https://example.com/hook", "application/json", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	rec.Status = http.StatusBadGateway
+	resp, err = rec.Client().Get("https://example.com/ping")
+	require.NoError(t, err)
+	assert.Equal(t, "502 Bad Gateway", resp.Status)
+
+	requests := rec.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "POST", requests[0].Method)
+	assert.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+	assert.Equal(t, "https://example.com/ping", requests[1].URL)
+}
@@ -0,0 +1,73 @@
+package httpclient
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Request is an outbound request captured by a Recorder
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Recorder is a test double for outbound integrations, it records requests
+// instead of sending them so tests can assert payloads without a network.
+// Every request is answered with Status, http.StatusOK when zero.
+type Recorder struct {
+	Status int
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// Client returns a client whose requests are recorded
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	r.mu.Lock()
+	r.requests = append(r.requests, Request{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header.Clone(),
+		Body:   body,
+	})
+	status := r.Status
+	r.mu.Unlock()
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}, nil
+}
+
+// Requests returns the recorded requests in the order they were made
+func (r *Recorder) Requests() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Request(nil), r.requests...)
+}
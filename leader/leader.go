@@ -0,0 +1,74 @@
+// Package leader provides leader election so that multiple Pico instances can
+// run against the same configuration in an active/standby arrangement. Only
+// the elected leader executes tasks, standby instances keep their repositories
+// up to date so they're ready to take over.
+package leader
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Lease describes a type that can hold an expiring, exclusive lease. Acquire
+// either takes a free or expired lease, or renews a lease the holder already
+// owns, and returns false if the lease is held by someone else.
+type Lease interface {
+	Acquire(holder string, ttl time.Duration) (bool, error)
+}
+
+// AppliedStore is implemented by leases whose store also records the commit
+// each target was last applied at, shared by every instance electing its
+// leader with the lease. An instance that takes over leadership deploys what
+// the previous leader hadn't applied rather than what it hadn't itself.
+type AppliedStore interface {
+	Applied() (map[string]string, error)
+	SetApplied(target, commit string) error
+}
+
+// Elector campaigns for leadership using a Lease
+type Elector struct {
+	lease  Lease
+	holder string
+	ttl    time.Duration
+}
+
+// New creates an elector that identifies itself as holder and holds the lease
+// for ttl, renewing it well before it expires.
+func New(lease Lease, holder string, ttl time.Duration) *Elector {
+	return &Elector{
+		lease:  lease,
+		holder: holder,
+		ttl:    ttl,
+	}
+}
+
+// Run campaigns for leadership until the context is cancelled. The callback is
+// called whenever leadership is gained or lost, starting as a standby. If the
+// lease can't be reached, leadership is given up to avoid two leaders.
+func (e *Elector) Run(ctx context.Context, changed func(leader bool)) error {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	leader := false
+	for {
+		ok, err := e.lease.Acquire(e.holder, e.ttl)
+		if err != nil {
+			zap.L().Warn("failed to acquire leadership lease",
+				zap.String("holder", e.holder),
+				zap.Error(err))
+			ok = false
+		}
+		if ok != leader {
+			leader = ok
+			changed(leader)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/picostack/pico/logger"
+)
+
+type fakeLease struct {
+	mu      sync.Mutex
+	results []error // nil for acquired, errTaken for held elsewhere, the last repeats
+}
+
+var errTaken = errors.New("taken")
+
+func (f *fakeLease) Acquire(holder string, ttl time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.results[0]
+	if len(f.results) > 1 {
+		f.results = f.results[1:]
+	}
+	if r == errTaken {
+		return false, nil
+	}
+	return r == nil, r
+}
+
+func TestElector(t *testing.T) {
+	lease := &fakeLease{results: []error{errTaken, nil, nil, errors.New("unreachable"), nil}}
+	e := New(lease, "me", 30*time.Millisecond)
+
+	var changes []bool
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := e.Run(ctx, func(leader bool) { changes = append(changes, leader) })
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, []bool{true, false, true}, changes)
+}
@@ -10,6 +10,9 @@ import (
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/redact"
 )
 
 type Env string
@@ -52,7 +55,15 @@ func init() {
 	config.Level.SetLevel(c.LogLevel)
 	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
-	logger, err := config.Build()
+	// secret values registered with the redact package are removed from all
+	// output, so the core writes through a redacting writer.
+	encoder := zapcore.NewJSONEncoder(config.EncoderConfig)
+	if config.Encoding == "console" {
+		encoder = zapcore.NewConsoleEncoder(config.EncoderConfig)
+	}
+	logger, err := config.Build(zap.WrapCore(func(zapcore.Core) zapcore.Core {
+		return zapcore.NewCore(encoder, zapcore.Lock(zapcore.AddSync(redact.Writer(os.Stderr))), config.Level)
+	}))
 	if err != nil {
 		fmt.Println("Error during logging config:", err)
 		os.Exit(1)
@@ -60,6 +71,7 @@ func init() {
 	zap.ReplaceGlobals(logger)
 
 	zap.L().Info("logger configured",
+		zap.String("version", buildinfo.Get().String()),
 		zap.String("level", c.LogLevel.String()),
 		zap.String("env", string(c.Environment)))
 
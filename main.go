@@ -15,47 +15,297 @@ import (
 	"github.com/urfave/cli"
 	"go.uber.org/zap"
 
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/disk"
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/gitauth"
 	_ "github.com/picostack/pico/logger"
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/secret/cache"
 	"github.com/picostack/pico/service"
 	"github.com/picostack/pico/task"
 )
 
-var version = "master"
-
 func main() {
 	app := cli.NewApp()
 
 	app.Name = "pico"
 	app.Usage = "A git-driven task automation butler."
 	app.UsageText = `pico [flags] [command]`
-	app.Version = version
+	app.Version = buildinfo.Get().String()
 	app.Description = `Pico is a git-driven task runner to automate the application of configs.`
 	app.Author = "Southclaws"
 	app.Email = "hello@southcla.ws"
 
 	app.Commands = []cli.Command{
+		{
+			Name:  "version",
+			Usage: "print the version, commit and build date",
+			Action: func(c *cli.Context) error {
+				i := buildinfo.Get()
+				fmt.Printf("version: %s\ncommit:  %s\ndate:    %s\n", i.Version, i.Commit, i.Date)
+				return nil
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "show the status of a running instance from its admin listener",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "admin-address", EnvVar: "ADMIN_ADDRESS", Usage: "address of the instance's admin listener"},
+				cli.StringFlag{Name: "admin-token", EnvVar: "ADMIN_TOKEN", Usage: "token the instance's admin listener requires, if any"},
+				cli.StringFlag{Name: "history", Usage: "show the recent executions of this target instead"},
+			},
+			Action: func(c *cli.Context) error {
+				if c.String("admin-address") == "" {
+					return errors.New("missing --admin-address, the instance must be run with an admin listener")
+				}
+				client := api.NewClient(c.String("admin-address"))
+				client.SetToken(c.String("admin-token"))
+				if target := c.String("history"); target != "" {
+					return printHistory(client, target)
+				}
+				return printStatus(client)
+			},
+		},
+		{
+			Name:      "reinit",
+			Usage:     "run the init command of a target on a running instance again, then deploy it",
+			ArgsUsage: "<target>",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "admin-address", EnvVar: "ADMIN_ADDRESS", Usage: "address of the instance's admin listener"},
+				cli.StringFlag{Name: "admin-token", EnvVar: "ADMIN_TOKEN", Usage: "token the instance's admin listener requires, if any"},
+			},
+			Action: func(c *cli.Context) error {
+				target := c.Args().First()
+				if target == "" {
+					return errors.New("missing target name")
+				}
+				if c.String("admin-address") == "" {
+					return errors.New("missing --admin-address, the instance must be run with an admin listener")
+				}
+				client := api.NewClient(c.String("admin-address"))
+				client.SetToken(c.String("admin-token"))
+				if err := client.Reinit(target); err != nil {
+					return err
+				}
+				fmt.Printf("%s will run its init command before deploying\n", target)
+				return nil
+			},
+		},
+		{
+			Name:      "approve",
+			Usage:     "deploy the commit of a target held pending approval on a running instance",
+			ArgsUsage: "<target>",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "admin-address", EnvVar: "ADMIN_ADDRESS", Usage: "address of the instance's admin listener"},
+				cli.StringFlag{Name: "admin-token", EnvVar: "ADMIN_TOKEN", Usage: "token the instance's admin listener requires, if any"},
+				cli.StringFlag{Name: "approver", Usage: "who approved the commit, recorded in the target's history, the admin user when empty"},
+			},
+			Action: func(c *cli.Context) error {
+				target := c.Args().First()
+				if target == "" {
+					return errors.New("missing target name")
+				}
+				if c.String("admin-address") == "" {
+					return errors.New("missing --admin-address, the instance must be run with an admin listener")
+				}
+				client := api.NewClient(c.String("admin-address"))
+				client.SetToken(c.String("admin-token"))
+				if err := client.Approve(target, c.String("approver")); err != nil {
+					return err
+				}
+				fmt.Printf("%s will deploy its commit pending approval\n", target)
+				return nil
+			},
+		},
+		{
+			Name:      "validate",
+			Usage:     "check the configuration files in a directory, unknown keys in target definitions are errors",
+			ArgsUsage: "[directory]",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "hostname", EnvVar: "HOSTNAME", Usage: "hostname exposed to configuration scripts, this host's when empty"},
+				cli.StringSliceFlag{Name: "config-env", EnvVar: "CONFIG_ENV", Usage: "environment variables exposed to configuration scripts as ENV"},
+			},
+			Action: func(c *cli.Context) error {
+				dir := c.Args().First()
+				if dir == "" {
+					dir = "."
+				}
+				hostname := c.String("hostname")
+				if hostname == "" {
+					var err error
+					if hostname, err = os.Hostname(); err != nil {
+						return errors.Wrap(err, "failed to get hostname")
+					}
+				}
+				return validateConfig(dir, hostname, c.StringSlice("config-env"))
+			},
+		},
+		{
+			Name:  "plan",
+			Usage: "show what applying a configuration would do to the targets, exiting with status 2 if it would change any",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "config", Usage: "directory of the configuration files, or a revision of the repository at --repo, to plan"},
+				cli.StringFlag{Name: "baseline", Usage: "directory or revision of the configuration to compare with, the targets of the instance at --admin-address when empty"},
+				cli.StringFlag{Name: "repo", Value: ".", Usage: "the configuration repository revisions are read from"},
+				cli.StringFlag{Name: "source", Usage: "configuration source of the instance's targets to compare with, for instances with several, all targets when empty"},
+				cli.StringFlag{Name: "admin-address", EnvVar: "ADMIN_ADDRESS", Usage: "address of the instance's admin listener"},
+				cli.StringFlag{Name: "admin-token", EnvVar: "ADMIN_TOKEN", Usage: "token the instance's admin listener requires, if any"},
+				cli.StringFlag{Name: "directory", EnvVar: "DIRECTORY", Value: "./cache/", Usage: "data directory clones are shown in when comparing with a baseline"},
+				cli.StringFlag{Name: "hostname", EnvVar: "HOSTNAME", Usage: "hostname exposed to configuration scripts, this host's when empty"},
+				cli.StringSliceFlag{Name: "config-env", EnvVar: "CONFIG_ENV", Usage: "environment variables exposed to configuration scripts as ENV"},
+			},
+			Action: func(c *cli.Context) error {
+				if c.String("config") == "" {
+					return errors.New("missing --config, the configuration to plan")
+				}
+				hostname := c.String("hostname")
+				if hostname == "" {
+					var err error
+					if hostname, err = os.Hostname(); err != nil {
+						return errors.Wrap(err, "failed to get hostname")
+					}
+				}
+				o := planOptions{
+					config:   c.String("config"),
+					baseline: c.String("baseline"),
+					repo:     c.String("repo"),
+					source:   c.String("source"),
+					root:     c.String("directory"),
+					builtins: config.Builtins{
+						Hostname: hostname,
+						Version:  buildinfo.Get().Version,
+						Env:      c.StringSlice("config-env"),
+					},
+				}
+				if c.String("baseline") == "" && c.String("admin-address") != "" {
+					o.client = api.NewClient(c.String("admin-address"))
+					o.client.SetToken(c.String("admin-token"))
+				}
+				return planConfig(o)
+			},
+		},
+		{
+			Name:  "wipe-secret-cache",
+			Usage: "remove the encrypted secret cache from the data directory",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "directory", EnvVar: "DIRECTORY", Value: "./cache/"},
+			},
+			Action: func(c *cli.Context) error {
+				if err := cache.Wipe(c.String("directory")); err != nil {
+					return errors.Wrap(err, "failed to wipe secret cache")
+				}
+				fmt.Println("secret cache wiped")
+				return nil
+			},
+		},
+		stateCommand,
 		{
 			Name:    "run",
 			Aliases: []string{"r"},
 			Description: `Starts the Pico daemon with the specified target repository. This
 repository should contain one or more configuration files for Pico. When
-this repository has new commits, Pico will automatically reconfigure.`,
-			Usage:     "argument `target` specifies Git repository for configuration.",
-			ArgsUsage: "target",
-			Flags: []cli.Flag{
+this repository has new commits, Pico will automatically reconfigure.
+
+Additional repositories are merged with the first one's targets and use the
+--git-username, --git-password and --git-token credentials, unless they're
+given their own as https://host/repo.git#user:PASSWORD_ENV or
+https://host/repo.git#TOKEN_ENV, read from the named environment variable.
+
+Options that are paths, addresses, URLs or credentials may refer to
+environment variables as ${VAR} or ${VAR:-default}, which Pico expands when
+it starts, such as --directory '/srv/${DATA_ROOT:-pico}' or --vault-token
+'${DEPLOY_TOKEN}' to keep the token off the command line. Commands and
+templates, such as --notify-command, are left as they are. A literal ${ is
+written $${.`,
+			Usage:     "argument `target` specifies Git repository for configuration, additional repositories are merged with it and may have their own credentials as URL#user:PASSWORD_ENV or URL#TOKEN_ENV.",
+			ArgsUsage: "target [targets...]",
+			Flags: append([]cli.Flag{
 				cli.StringFlag{Name: "git-username", EnvVar: "GIT_USERNAME"},
 				cli.StringFlag{Name: "git-password", EnvVar: "GIT_PASSWORD"},
+				cli.StringFlag{Name: "git-token", EnvVar: "GIT_TOKEN", Usage: "personal access token sent as an 'Authorization: token' header"},
+				cli.StringFlag{Name: "git-auth", EnvVar: "GIT_AUTH", Usage: "git HTTP auth mode, basic or token, detected from the credentials when empty"},
+				cli.StringFlag{Name: "netrc", EnvVar: "NETRC", Value: gitauth.DefaultNetrcPath(), Usage: "netrc file to read git HTTP credentials from when none are configured"},
+				cli.StringFlag{Name: "config-branch", EnvVar: "CONFIG_BRANCH", Usage: "branch of the target configuration repository to check out, its default branch when empty"},
 				cli.StringFlag{Name: "hostname", EnvVar: "HOSTNAME"},
+				cli.StringSliceFlag{Name: "config-env", EnvVar: "CONFIG_ENV", Usage: "environment variables exposed to configuration scripts as ENV"},
 				cli.StringFlag{Name: "directory", EnvVar: "DIRECTORY", Value: "./cache/"},
-				cli.DurationFlag{Name: "pass-env", EnvVar: "PASS_ENV"},
+				cli.BoolFlag{Name: "check", Usage: "only run the preflight checks, exiting with a non-zero status if any fail"},
+				cli.BoolFlag{Name: "pass-env", EnvVar: "PASS_ENV", Usage: "pass Pico's environment to target commands, targets may override this with pass_environment"},
 				cli.BoolFlag{Name: "ssh", EnvVar: "SSH"},
 				cli.DurationFlag{Name: "check-interval", EnvVar: "CHECK_INTERVAL", Value: time.Second * 10},
+				cli.DurationFlag{Name: "git-timeout", EnvVar: "GIT_TIMEOUT", Value: time.Minute * 10, Usage: "how long a clone, fetch or listing of a repository may take before it's abandoned, targets may override this with git_timeout"},
+				cli.DurationFlag{Name: "config-stale-after", EnvVar: "CONFIG_STALE_AFTER", Value: time.Hour, Usage: "how long a configuration repository may be unreachable before the configuration is reported as stale, zero disables this"},
+				cli.StringFlag{Name: "secret-backend", EnvVar: "SECRET_BACKEND", Usage: "where secrets are read from, one of " + strings.Join(service.SecretBackends(), ", ") + ", inferred from the other secret options when unset (deprecated)"},
 				cli.StringFlag{Name: "vault-addr", EnvVar: "VAULT_ADDR"},
 				cli.StringFlag{Name: "vault-token", EnvVar: "VAULT_TOKEN"},
-				cli.StringFlag{Name: "vault-path", EnvVar: "VAULT_PATH", Value: "/secret"},
+				cli.StringFlag{Name: "vault-auth-method", EnvVar: "VAULT_AUTH_METHOD", Usage: "how to log in to vault, token or aws, the token when empty"},
+				cli.StringFlag{Name: "vault-aws-role", EnvVar: "VAULT_AWS_ROLE", Usage: "vault role to log in as with the aws auth method, the name of the host's IAM role when empty"},
+				cli.StringFlag{Name: "vault-aws-header-value", EnvVar: "VAULT_AWS_HEADER_VALUE", Usage: "X-Vault-AWS-IAM-Server-ID header of logins with the aws auth method, if vault requires one"},
+				cli.BoolFlag{Name: "vault-token-wrapped", EnvVar: "VAULT_TOKEN_WRAPPED", Usage: "the vault token is a response-wrapping token, detected automatically when unset"},
+				cli.StringFlag{Name: "vault-path", EnvVar: "VAULT_PATH", Value: "/secret", Usage: "comma separated paths to search for secrets, earlier paths take precedence"},
 				cli.DurationFlag{Name: "vault-renew-interval", EnvVar: "VAULT_RENEW_INTERVAL", Value: time.Hour * 24},
 				cli.StringFlag{Name: "vault-config-path", EnvVar: "VAULT_CONFIG_PATH", Value: "pico"},
-			},
+				cli.StringFlag{Name: "azure-keyvault-uri", EnvVar: "AZURE_KEYVAULT_URI", Usage: "read secrets from an Azure Key Vault, such as https://name.vault.azure.net"},
+				cli.StringFlag{Name: "gcp-project", EnvVar: "GCP_PROJECT", Usage: "read secrets from Google Secret Manager in this project"},
+				cli.StringFlag{Name: "gcp-secret-prefix", EnvVar: "GCP_SECRET_PREFIX", Value: "pico-", Usage: "prefix of Secret Manager secret names, followed by <target>__<KEY>"},
+				cli.BoolFlag{Name: "kube-secrets", EnvVar: "KUBE_SECRETS", Usage: "read secrets from Kubernetes Secrets named pico-<target> or labelled pico.target=<target>"},
+				cli.StringFlag{Name: "kube-namespace", EnvVar: "KUBE_NAMESPACE", Usage: "namespace of Kubernetes Secrets, defaults to the pod's namespace"},
+				cli.BoolFlag{Name: "kube-watch", EnvVar: "KUBE_WATCH", Usage: "redeploy targets when their Kubernetes Secrets change"},
+				cli.StringFlag{Name: "secrets-directory", EnvVar: "SECRETS_DIRECTORY", Usage: "read secrets from files in <directory>/<target>/<KEY>, global secrets from <directory>/global"},
+				cli.StringFlag{Name: "ssm-region", EnvVar: "SSM_REGION", Usage: "read secrets from AWS SSM Parameter Store in this region"},
+				cli.StringFlag{Name: "ssm-prefix", EnvVar: "SSM_PREFIX", Value: "/pico", Usage: "Parameter Store path prefix, followed by /<target>/<KEY>"},
+				cli.StringFlag{Name: "secret-cache-key", EnvVar: "SECRET_CACHE_KEY", Usage: "key file for an encrypted on-disk cache of fetched secrets, disabled when empty"},
+				cli.StringSliceFlag{Name: "secret", Usage: "set a secret in memory as key=value or target:key=value, overrides the secret store, for testing"},
+				cli.BoolFlag{Name: "allow-stale-secrets", EnvVar: "ALLOW_STALE_SECRETS", Usage: "use cached secrets when the secret store is unavailable, requires --secret-cache-key"},
+				cli.BoolFlag{Name: "standby", EnvVar: "STANDBY", Usage: "fetch targets and cache secrets but record tasks as suppressed rather than executing them, until activated by the admin API, --activate-file or winning leader election"},
+				cli.StringFlag{Name: "activate-file", EnvVar: "ACTIVATE_FILE", Usage: "activate a standby instance once this file exists"},
+				cli.BoolFlag{Name: "leader-election", EnvVar: "LEADER_ELECTION", Usage: "only execute tasks while elected leader, requires vault"},
+				cli.StringFlag{Name: "leader-key", EnvVar: "LEADER_KEY", Value: "pico-leader"},
+				cli.DurationFlag{Name: "leader-ttl", EnvVar: "LEADER_TTL", Value: time.Second * 30},
+				cli.StringFlag{Name: "admin-address", EnvVar: "ADMIN_ADDRESS", Usage: "address for the admin listener serving status and a dashboard, disabled when empty, protected by ADMIN_TOKEN from the secret store if set"},
+				cli.StringFlag{Name: "debug-address", EnvVar: "DEBUG_ADDRESS", Usage: "address for the debug listener serving pprof, disabled when empty, binds to localhost without a host"},
+				cli.StringFlag{Name: "webhook-address", EnvVar: "WEBHOOK_ADDRESS", Usage: "address to receive push webhooks from git hosts on, disabled when empty, binds to localhost without a host, requires BITBUCKET_WEBHOOK_SECRET in the secret store"},
+				cli.BoolFlag{Name: "worktrees", EnvVar: "WORKTREES", Usage: "run tasks in a checkout of the task's commit rather than their target's clone, removed once the task's commands exit, so bind mounts need absolute paths and untracked files such as .env are left out, targets may opt out with in_place"},
+				cli.BoolFlag{Name: "cancel-on-reconfigure", EnvVar: "CANCEL_ON_RECONFIGURE", Usage: "cancel the executing task of a target whose definition changed rather than waiting for it to finish"},
+				cli.IntFlag{Name: "startup-parallelism", EnvVar: "STARTUP_PARALLELISM", Value: 1, Usage: "number of tasks of the cold start plan executed at the same time, other tasks are always executed one at a time"},
+				cli.IntFlag{Name: "history-size", EnvVar: "HISTORY_SIZE", Value: executor.DefaultHistorySize, Usage: "number of executions kept per target"},
+				cli.StringFlag{Name: "max-output", EnvVar: "MAX_OUTPUT", Value: "4M", Usage: "output kept per task for history and notifications, the middle of longer output is dropped"},
+				cli.BoolFlag{Name: "persist-history", EnvVar: "PERSIST_HISTORY", Usage: "keep execution history in the state file so it survives restarts"},
+				cli.StringFlag{Name: "smtp-host", EnvVar: "SMTP_HOST", Usage: "SMTP server to email failures and recoveries through, credentials are read from SMTP_USERNAME and SMTP_PASSWORD in the secret store"},
+				cli.IntFlag{Name: "smtp-port", EnvVar: "SMTP_PORT", Usage: "SMTP server port, 587 or 465 for implicit TLS when unset"},
+				cli.StringFlag{Name: "smtp-tls", EnvVar: "SMTP_TLS", Value: "starttls", Usage: "starttls, tls for implicit TLS or none"},
+				cli.StringFlag{Name: "smtp-from", EnvVar: "SMTP_FROM", Usage: "address notification emails are sent from"},
+				cli.StringSliceFlag{Name: "smtp-to", EnvVar: "SMTP_TO", Usage: "addresses notification emails are sent to"},
+				cli.StringFlag{Name: "smtp-subject", EnvVar: "SMTP_SUBJECT", Value: notifier.DefaultSubject, Usage: "template of the subject of notification emails, with .Target and .Status"},
+				cli.StringFlag{Name: "grafana-url", EnvVar: "GRAFANA_URL", Usage: "Grafana instance to annotate finished tasks in, authenticated with GRAFANA_TOKEN from the secret store"},
+				cli.StringFlag{Name: "discord-webhook", EnvVar: "DISCORD_WEBHOOK_URL", Usage: "Discord webhook URL to post task notifications to, read from DISCORD_WEBHOOK_URL in the secret store when unset"},
+				cli.StringSliceFlag{Name: "webhook-url", EnvVar: "WEBHOOK_URLS", Usage: "URLs to post every event to as JSON, signed with WEBHOOK_SECRET from the secret store"},
+				cli.StringFlag{Name: "notify-command", EnvVar: "NOTIFY_COMMAND", Usage: "shell command run for every notification, with the event in PICO_* environment variables"},
+				cli.BoolFlag{Name: "prune-images", EnvVar: "PRUNE_IMAGES", Usage: "prune Docker images no container uses after successful deploys, targets may override this with prune_images"},
+				cli.DurationFlag{Name: "prune-interval", EnvVar: "PRUNE_INTERVAL", Value: time.Hour * 24, Usage: "prune images at most once per interval however often targets deploy"},
+				cli.DurationFlag{Name: "prune-until", EnvVar: "PRUNE_UNTIL", Value: time.Hour * 24, Usage: "only prune images created more than this long ago"},
+				cli.BoolFlag{Name: "remove-orphans", EnvVar: "REMOVE_ORPHANS", Usage: "tear down compose projects of targets that are no longer configured, they're only listed otherwise"},
+				cli.StringFlag{Name: "run-as", EnvVar: "RUN_AS", Usage: "user[:group] to switch to after binding listeners and reading credentials"},
+				cli.DurationFlag{Name: "gc-interval", EnvVar: "GC_INTERVAL", Usage: "how often to compact target clones over --gc-threshold, disabled when zero"},
+				cli.StringFlag{Name: "gc-threshold", EnvVar: "GC_THRESHOLD", Value: "256M", Usage: "size of a target clone above which it's compacted"},
+				cli.StringFlag{Name: "max-data-size", EnvVar: "MAX_DATA_SIZE", Usage: "warn and notify when the data directory exceeds this size, such as 10G"},
+				cli.StringFlag{Name: "min-free-space", EnvVar: "MIN_FREE_SPACE", Usage: "skip clones, fetches and tasks while the data directory's disk has less free space than this, such as 1G"},
+				cli.Float64Flag{Name: "min-free-percent", EnvVar: "MIN_FREE_PERCENT", Usage: "as for --min-free-space, as a percentage of the disk's size"},
+				cli.StringSliceFlag{Name: "metric-labels", EnvVar: "METRIC_LABELS", Usage: "target label keys to export on per-target metrics, other labels are omitted"},
+				cli.StringFlag{Name: "pushgateway-url", EnvVar: "PUSHGATEWAY_URL", Usage: "Prometheus Pushgateway to push the final metrics to on shutdown"},
+				cli.StringFlag{Name: "pushgateway-job", EnvVar: "PUSHGATEWAY_JOB", Value: "pico", Usage: "job name pushed metrics are grouped under"},
+				cli.StringSliceFlag{Name: "pushgateway-grouping", EnvVar: "PUSHGATEWAY_GROUPING", Usage: "key=value grouping labels of pushed metrics, such as instance=web-1"},
+				cli.DurationFlag{Name: "http-timeout", EnvVar: "HTTP_TIMEOUT", Value: time.Second * 30, Usage: "how long each outbound request of notifications and the Pushgateway may take"},
+				cli.StringFlag{Name: "http-proxy", EnvVar: "HTTP_PROXY_URL", Usage: "proxy for outbound requests of notifications and the Pushgateway, HTTPS_PROXY and HTTP_PROXY when empty"},
+				cli.StringFlag{Name: "http-ca-bundle", EnvVar: "HTTP_CA_BUNDLE", Usage: "PEM file of CAs trusted for outbound requests in addition to the system's"},
+				cli.BoolFlag{Name: "require-secrets", EnvVar: "REQUIRE_SECRETS", Usage: "fail tasks whose secret_map refers to a missing secret instead of warning"},
+				cli.BoolFlag{Name: "interpolate-commands", EnvVar: "INTERPOLATE_COMMANDS", Usage: "resolve ${secret:...} placeholders in target commands as well as environment values"},
+				cli.BoolFlag{Name: "strict-env", EnvVar: "STRICT_ENV", Usage: "fail tasks when env files, secrets, credentials, the configuration, the target or the passed host environment define a variable with different values"},
+				cli.BoolFlag{Name: "strict-config", EnvVar: "STRICT_CONFIG", Usage: "exit instead of keeping the last good configuration when a revision is invalid or a target definition has unknown keys"},
+			}, retentionFlags...),
 			Action: func(c *cli.Context) (err error) {
 				if !c.Args().Present() {
 					cli.ShowCommandHelp(c, "run")
@@ -74,30 +324,157 @@ this repository has new commits, Pico will automatically reconfigure.`,
 					}
 				}
 
+				var sources []task.Repo
+				for _, arg := range c.Args().Tail() {
+					source, err := sourceRepo(arg, task.Repo{
+						User:  c.String("git-username"),
+						Pass:  c.String("git-password"),
+						Token: c.String("git-token"),
+					})
+					if err != nil {
+						return err
+					}
+					sources = append(sources, source)
+				}
+
+				gcThreshold, err := disk.ParseSize(c.String("gc-threshold"))
+				if err != nil {
+					return errors.Wrap(err, "invalid --gc-threshold")
+				}
+				maxOutput, err := disk.ParseSize(c.String("max-output"))
+				if err != nil {
+					return errors.Wrap(err, "invalid --max-output")
+				}
+				var maxDataSize int64
+				if c.String("max-data-size") != "" {
+					maxDataSize, err = disk.ParseSize(c.String("max-data-size"))
+					if err != nil {
+						return errors.Wrap(err, "invalid --max-data-size")
+					}
+				}
+				retention, err := retentionConfig(c)
+				if err != nil {
+					return err
+				}
+				var minFreeSpace int64
+				if c.String("min-free-space") != "" {
+					minFreeSpace, err = disk.ParseSize(c.String("min-free-space"))
+					if err != nil {
+						return errors.Wrap(err, "invalid --min-free-space")
+					}
+				}
+
 				cfg := service.Config{
 					Target: task.Repo{
-						URL:  c.Args().First(),
-						User: c.String("git-username"),
-						Pass: c.String("git-password"),
+						URL:    c.Args().First(),
+						Branch: c.String("config-branch"),
+						User:   c.String("git-username"),
+						Pass:   c.String("git-password"),
+						Token:  c.String("git-token"),
 					},
+					Sources:         sources,
 					Hostname:        hostname,
+					Version:         buildinfo.Get().Version,
+					ConfigEnv:       c.StringSlice("config-env"),
 					Directory:       c.String("directory"),
 					PassEnvironment: c.Bool("pass-env"),
+					GitAuth:         c.String("git-auth"),
+					Netrc:           c.String("netrc"),
 					SSH:             c.Bool("ssh"),
 					CheckInterval:   c.Duration("check-interval"),
+					GitTimeout:      c.Duration("git-timeout"),
+					ConfigStale:     c.Duration("config-stale-after"),
+					SecretBackend:   c.String("secret-backend"),
 					VaultAddress:    c.String("vault-addr"),
 					VaultToken:      c.String("vault-token"),
+					VaultWrapped:    c.Bool("vault-token-wrapped"),
+					VaultAuth:       c.String("vault-auth-method"),
+					VaultAWSRole:    c.String("vault-aws-role"),
+					VaultAWSHeader:  c.String("vault-aws-header-value"),
 					VaultPath:       c.String("vault-path"),
 					VaultRenewal:    c.Duration("vault-renew-interval"),
 					VaultConfig:     c.String("vault-config-path"),
+					AzureVaultURI:   c.String("azure-keyvault-uri"),
+					GCPProject:      c.String("gcp-project"),
+					GCPSecretPrefix: c.String("gcp-secret-prefix"),
+					KubeSecrets:     c.Bool("kube-secrets"),
+					KubeNamespace:   c.String("kube-namespace"),
+					KubeWatch:       c.Bool("kube-watch"),
+					SecretsDir:      c.String("secrets-directory"),
+					SSMRegion:       c.String("ssm-region"),
+					SSMPrefix:       c.String("ssm-prefix"),
+					SecretCacheKey:  c.String("secret-cache-key"),
+					AllowStale:      c.Bool("allow-stale-secrets"),
+					Secrets:         c.StringSlice("secret"),
+					StrictConfig:    c.Bool("strict-config"),
+					RequireSecrets:  c.Bool("require-secrets"),
+					InterpolateCmds: c.Bool("interpolate-commands"),
+					StrictEnv:       c.Bool("strict-env"),
+					Standby:         c.Bool("standby"),
+					ActivateFile:    c.String("activate-file"),
+					LeaderElection:  c.Bool("leader-election"),
+					LeaderKey:       c.String("leader-key"),
+					LeaderTTL:       c.Duration("leader-ttl"),
+					AdminAddress:    c.String("admin-address"),
+					DebugAddress:    c.String("debug-address"),
+					WebhookAddress:  c.String("webhook-address"),
+					MetricLabels:    c.StringSlice("metric-labels"),
+					PushGateway:     c.String("pushgateway-url"),
+					PushJob:         c.String("pushgateway-job"),
+					PushGrouping:    c.StringSlice("pushgateway-grouping"),
+					HTTPTimeout:     c.Duration("http-timeout"),
+					HTTPProxy:       c.String("http-proxy"),
+					HTTPCABundle:    c.String("http-ca-bundle"),
+					Worktrees:       c.Bool("worktrees"),
+					CancelReconfig:  c.Bool("cancel-on-reconfigure"),
+					StartupParallel: c.Int("startup-parallelism"),
+					HistorySize:     c.Int("history-size"),
+					PersistHistory:  c.Bool("persist-history"),
+					StateMaxRecords: retention.MaxRecords,
+					StateMaxAge:     retention.MaxAge,
+					StateMaxSize:    retention.MaxSize,
+					MaxOutput:       maxOutput,
+					MinFreeSpace:    minFreeSpace,
+					MinFreePercent:  c.Float64("min-free-percent"),
+					NotifyCommand:   c.String("notify-command"),
+					DiscordWebhook:  c.String("discord-webhook"),
+					GrafanaURL:      c.String("grafana-url"),
+					WebhookURLs:     c.StringSlice("webhook-url"),
+					SMTP: notifier.SMTPConfig{
+						Host:    c.String("smtp-host"),
+						Port:    c.Int("smtp-port"),
+						TLS:     c.String("smtp-tls"),
+						From:    c.String("smtp-from"),
+						To:      c.StringSlice("smtp-to"),
+						Subject: c.String("smtp-subject"),
+					},
+					PruneImages:   c.Bool("prune-images"),
+					PruneInterval: c.Duration("prune-interval"),
+					PruneUntil:    c.Duration("prune-until"),
+					RemoveOrphans: c.Bool("remove-orphans"),
+					RunAs:         c.String("run-as"),
+					GCInterval:    c.Duration("gc-interval"),
+					GCThreshold:   gcThreshold,
+					MaxDataSize:   maxDataSize,
+					Origins:       configOrigins(c),
 				}
 
-				zap.L().Debug("initialising service", zap.Any("config", cfg))
+				if c.Bool("check") {
+					results := service.Check(cfg)
+					for _, r := range results {
+						fmt.Println(r)
+					}
+					if service.Failed(results) {
+						return errors.New("preflight checks failed")
+					}
+					return nil
+				}
 
 				svc, err := service.Initialise(cfg)
 				if err != nil {
 					return errors.Wrap(err, "failed to initialise")
 				}
+				defer svc.Close() //nolint:errcheck
 
 				zap.L().Info("service initialised")
 
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FetchState is the outcome of the recent fetches of a target's repository
+type FetchState struct {
+	ConsecutiveFailures int
+	LastSuccess         time.Time
+}
+
+var (
+	fetchFailuresDesc = prometheus.NewDesc(
+		"pico_target_fetch_consecutive_failures",
+		"Number of consecutive failed fetches of each target's repository.",
+		[]string{"target"}, nil)
+	fetchSuccessDesc = prometheus.NewDesc(
+		"pico_target_fetch_last_success_timestamp_seconds",
+		"Time of the last successful fetch of each target's repository.",
+		[]string{"target"}, nil)
+)
+
+// fetchCollector reads the fetch state of every target at scrape time
+type fetchCollector struct {
+	state func() map[string]FetchState
+}
+
+func (c fetchCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- fetchFailuresDesc
+	ch <- fetchSuccessDesc
+}
+
+func (c fetchCollector) Collect(ch chan<- prometheus.Metric) {
+	for target, s := range c.state() {
+		ch <- prometheus.MustNewConstMetric(fetchFailuresDesc, prometheus.GaugeValue,
+			float64(s.ConsecutiveFailures), target)
+		if !s.LastSuccess.IsZero() {
+			ch <- prometheus.MustNewConstMetric(fetchSuccessDesc, prometheus.GaugeValue,
+				float64(s.LastSuccess.UnixNano())/1e9, target)
+		}
+	}
+}
+
+// CollectFetchState exports the fetch state returned by the given function
+// whenever the metrics are scraped. It must only be called once.
+func (m *Metrics) CollectFetchState(state func() map[string]FetchState) {
+	m.registry.MustRegister(fetchCollector{state: state})
+}
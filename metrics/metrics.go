@@ -0,0 +1,253 @@
+// Package metrics provides the Prometheus metrics that Pico exposes about the
+// tasks it executes. Per-target metrics carry the target's name, its group and,
+// to keep cardinality under control, only those target labels that are
+// explicitly allowed.
+package metrics
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/picostack/pico/task"
+)
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reserved label names are set by Pico itself and can't be target labels
+var reserved = map[string]bool{"target": true, "group": true, "trigger": true, "result": true, "shutdown": true, "kind": true}
+
+// Metrics holds the registry and collectors for the running instance
+type Metrics struct {
+	labels   []string // allowed target label keys
+	registry *prometheus.Registry
+
+	executions  *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	lastSuccess *prometheus.GaugeVec
+	cloneSize   *prometheus.GaugeVec
+	dataSize    prometheus.Gauge
+	dataFree    prometheus.Gauge
+	prunes      *prometheus.CounterVec
+	reclaimed   prometheus.Counter
+	renewals    *prometheus.CounterVec
+	failures    *prometheus.CounterVec
+	standby     prometheus.Gauge
+	suppressed  *prometheus.CounterVec
+	revision    *prometheus.GaugeVec
+
+	mu        sync.Mutex
+	revisions map[string]string // the commit each configuration source is at
+}
+
+// New creates the metrics with the given target label keys as extra labels on
+// every per-target metric. Label keys not in this list are never exported.
+func New(labels []string) (*Metrics, error) {
+	seen := make(map[string]bool)
+	for _, l := range labels {
+		if !labelName.MatchString(l) || reserved[l] {
+			return nil, errors.Errorf("'%s' can't be used as a metric label", l)
+		}
+		if seen[l] {
+			return nil, errors.Errorf("metric label '%s' is listed twice", l)
+		}
+		seen[l] = true
+	}
+
+	m := &Metrics{
+		labels:   labels,
+		registry: prometheus.NewRegistry(),
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pico",
+			Name:      "task_executions_total",
+			Help:      "Number of executed tasks by target, trigger and result.",
+		}, append([]string{"target", "group", "trigger", "shutdown", "result"}, labels...)),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pico",
+			Name:      "task_duration_seconds",
+			Help:      "Duration of executed tasks by target.",
+			Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600},
+		}, append([]string{"target", "group"}, labels...)),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pico",
+			Name:      "task_last_success_timestamp_seconds",
+			Help:      "Time of the last successful task by target.",
+		}, append([]string{"target", "group"}, labels...)),
+		cloneSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pico",
+			Name:      "target_clone_size_bytes",
+			Help:      "Disk usage of each target's repository clone.",
+		}, []string{"target"}),
+		dataSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "pico",
+			Name:      "data_directory_size_bytes",
+			Help:      "Disk usage of the data directory.",
+		}),
+		dataFree: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "pico",
+			Name:      "data_directory_free_bytes",
+			Help:      "Free space of the filesystem the data directory is on.",
+		}),
+		prunes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pico",
+			Name:      "image_prunes_total",
+			Help:      "Number of Docker image prunes after deploys by result.",
+		}, []string{"result"}),
+		reclaimed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "pico",
+			Name:      "image_prune_reclaimed_bytes_total",
+			Help:      "Disk space reclaimed by pruning Docker images.",
+		}),
+		renewals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pico",
+			Name:      "vault_token_renewals_total",
+			Help:      "Number of attempts to renew or log in again for the Vault token by result.",
+		}, []string{"result"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pico",
+			Name:      "task_failures_total",
+			Help:      "Number of failed tasks by target and kind of failure.",
+		}, append([]string{"target", "group", "kind"}, labels...)),
+		standby: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "pico",
+			Name:      "standby",
+			Help:      "Whether the instance is in standby, recording tasks without executing them.",
+		}),
+		suppressed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pico",
+			Name:      "tasks_suppressed_total",
+			Help:      "Number of tasks recorded without being executed by target.",
+		}, append([]string{"target", "group"}, labels...)),
+		revision: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pico",
+			Name:      "config_revision_info",
+			Help:      "The commit of each configuration repository that's in force, always 1.",
+		}, []string{"source", "commit"}),
+		revisions: make(map[string]string),
+	}
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.executions,
+		m.duration,
+		m.lastSuccess,
+		m.cloneSize,
+		m.dataSize,
+		m.dataFree,
+		m.prunes,
+		m.reclaimed,
+		m.renewals,
+		m.failures,
+		m.standby,
+		m.suppressed,
+		m.revision,
+	)
+	return m, nil
+}
+
+// ObserveTask records the result of a task executed for a target, one of
+// task.ResultSuccess, ResultFailure or ResultNoop, and what triggered it
+func (m *Metrics) ObserveTask(t task.Target, trigger task.Trigger, shutdown bool, duration time.Duration, result string) {
+	shut := "false"
+	if shutdown {
+		shut = "true"
+	}
+
+	labels := m.targetLabels(t)
+	m.executions.WithLabelValues(append([]string{t.Name, t.Group, string(trigger), shut, result}, labels...)...).Inc()
+	m.duration.WithLabelValues(append([]string{t.Name, t.Group}, labels...)...).Observe(duration.Seconds())
+	if result != task.ResultFailure {
+		m.lastSuccess.WithLabelValues(append([]string{t.Name, t.Group}, labels...)...).SetToCurrentTime()
+	}
+}
+
+// ObserveFailure records a failed task of a target by the kind of failure, such
+// as auth_failed or command_failed.
+func (m *Metrics) ObserveFailure(t task.Target, kind string) {
+	m.failures.WithLabelValues(append([]string{t.Name, t.Group, kind}, m.targetLabels(t)...)...).Inc()
+}
+
+// SetStandby records whether the instance is in standby
+func (m *Metrics) SetStandby(standby bool) {
+	if standby {
+		m.standby.Set(1)
+	} else {
+		m.standby.Set(0)
+	}
+}
+
+// ObserveSuppressed records a task of a target that wasn't executed
+func (m *Metrics) ObserveSuppressed(t task.Target) {
+	m.suppressed.WithLabelValues(append([]string{t.Name, t.Group}, m.targetLabels(t)...)...).Inc()
+}
+
+// SetConfigRevision records the commit of a configuration source that's in
+// force, replacing the commit it was at before.
+func (m *Metrics) SetConfigRevision(source, commit string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if previous, ok := m.revisions[source]; ok {
+		m.revision.DeleteLabelValues(source, previous)
+	}
+	m.revisions[source] = commit
+	m.revision.WithLabelValues(source, commit).Set(1)
+}
+
+// ObserveDiskUsage records the size of the data directory and of each target
+// clone, targets that are no longer measured are removed.
+func (m *Metrics) ObserveDiskUsage(data int64, clones map[string]int64) {
+	m.dataSize.Set(float64(data))
+	m.cloneSize.Reset()
+	for target, size := range clones {
+		m.cloneSize.WithLabelValues(target).Set(float64(size))
+	}
+}
+
+// SetDataFree records the free space of the data directory's filesystem
+func (m *Metrics) SetDataFree(free int64) {
+	m.dataFree.Set(float64(free))
+}
+
+// ObservePrune records the outcome of an image prune and the space it reclaimed
+func (m *Metrics) ObservePrune(reclaimed int64, err error) {
+	if err != nil {
+		m.prunes.WithLabelValues("failure").Inc()
+		return
+	}
+	m.prunes.WithLabelValues("success").Inc()
+	m.reclaimed.Add(float64(reclaimed))
+}
+
+// ObserveVaultRenewal records the outcome of an attempt to renew the Vault token
+func (m *Metrics) ObserveVaultRenewal(err error) {
+	if err != nil {
+		m.renewals.WithLabelValues("failure").Inc()
+		return
+	}
+	m.renewals.WithLabelValues("success").Inc()
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Registry returns the registry, for registering additional collectors
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// targetLabels returns the values of the allowed labels, in order, a label the
+// target doesn't set has an empty value which Prometheus treats as absent.
+func (m *Metrics) targetLabels(t task.Target) []string {
+	values := make([]string, len(m.labels))
+	for i, l := range m.labels {
+		values[i] = t.Labels[l]
+	}
+	return values
+}
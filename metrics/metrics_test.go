@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestNewLabels(t *testing.T) {
+	for _, labels := range [][]string{{"team", "tier"}, {"_x"}, nil} {
+		_, err := New(labels)
+		assert.NoError(t, err, labels)
+	}
+	for _, labels := range [][]string{{"target"}, {"group"}, {"1team"}, {"team-name"}, {"team", "team"}} {
+		_, err := New(labels)
+		assert.Error(t, err, labels)
+	}
+}
+
+func TestObserveTask(t *testing.T) {
+	m, err := New([]string{"team"})
+	require.NoError(t, err)
+
+	target := task.Target{Name: "app", Labels: map[string]string{"team": "payments", "tier": "prod"}}
+	m.ObserveTask(target, task.TriggerChange, false, time.Second, task.ResultSuccess)
+	m.ObserveTask(target, task.TriggerWebhook, false, time.Second, task.ResultFailure)
+	m.ObserveTask(target, task.TriggerChange, false, time.Second, task.ResultNoop)
+	m.ObserveTask(task.Target{Name: "other", Group: "apps"}, task.TriggerConfig, true, time.Second, task.ResultSuccess)
+
+	err = testutil.CollectAndCompare(m.executions, strings.NewReader(`
+# HELP pico_task_executions_total Number of executed tasks by target, trigger and result.
+# TYPE pico_task_executions_total counter
+pico_task_executions_total{group="",result="failure",shutdown="false",target="app",team="payments",trigger="webhook"} 1
+pico_task_executions_total{group="",result="noop",shutdown="false",target="app",team="payments",trigger="change"} 1
+pico_task_executions_total{group="",result="success",shutdown="false",target="app",team="payments",trigger="change"} 1
+pico_task_executions_total{group="apps",result="success",shutdown="true",target="other",team="",trigger="config"} 1
+`))
+	assert.NoError(t, err)
+}
+
+func TestObservePrune(t *testing.T) {
+	m, err := New(nil)
+	require.NoError(t, err)
+
+	m.ObservePrune(1000, nil)
+	m.ObservePrune(500, nil)
+	m.ObservePrune(0, errors.New("docker is not running"))
+
+	assert.Equal(t, float64(1500), testutil.ToFloat64(m.reclaimed))
+	err = testutil.CollectAndCompare(m.prunes, strings.NewReader(`
+# HELP pico_image_prunes_total Number of Docker image prunes after deploys by result.
+# TYPE pico_image_prunes_total counter
+pico_image_prunes_total{result="failure"} 1
+pico_image_prunes_total{result="success"} 2
+`))
+	assert.NoError(t, err)
+}
+
+func TestObserveVaultRenewal(t *testing.T) {
+	m, err := New(nil)
+	require.NoError(t, err)
+
+	m.ObserveVaultRenewal(errors.New("connection refused"))
+	m.ObserveVaultRenewal(nil)
+	m.ObserveVaultRenewal(nil)
+
+	err = testutil.CollectAndCompare(m.renewals, strings.NewReader(`
+# HELP pico_vault_token_renewals_total Number of attempts to renew or log in again for the Vault token by result.
+# TYPE pico_vault_token_renewals_total counter
+pico_vault_token_renewals_total{result="failure"} 1
+pico_vault_token_renewals_total{result="success"} 2
+`))
+	assert.NoError(t, err)
+}
+
+func TestObserveFailure(t *testing.T) {
+	m, err := New(nil)
+	require.NoError(t, err)
+
+	app := task.Target{Name: "app", Group: "web"}
+	m.ObserveFailure(app, "auth_failed")
+	m.ObserveFailure(app, "command_failed")
+	m.ObserveFailure(app, "command_failed")
+
+	err = testutil.CollectAndCompare(m.failures, strings.NewReader(`
+# HELP pico_task_failures_total Number of failed tasks by target and kind of failure.
+# TYPE pico_task_failures_total counter
+pico_task_failures_total{group="web",kind="auth_failed",target="app"} 1
+pico_task_failures_total{group="web",kind="command_failed",target="app"} 2
+`))
+	assert.NoError(t, err)
+}
+
+func TestObserveDiskUsage(t *testing.T) {
+	m, err := New(nil)
+	require.NoError(t, err)
+
+	m.ObserveDiskUsage(300, map[string]int64{"app": 100, "old": 50})
+	m.ObserveDiskUsage(300, map[string]int64{"app": 200})
+
+	assert.Equal(t, float64(300), testutil.ToFloat64(m.dataSize))
+	m.SetDataFree(1024)
+	assert.Equal(t, float64(1024), testutil.ToFloat64(m.dataFree))
+	err = testutil.CollectAndCompare(m.cloneSize, strings.NewReader(`
+# HELP pico_target_clone_size_bytes Disk usage of each target's repository clone.
+# TYPE pico_target_clone_size_bytes gauge
+pico_target_clone_size_bytes{target="app"} 200
+`))
+	assert.NoError(t, err)
+}
+
+func TestCollectFetchState(t *testing.T) {
+	m, err := New(nil)
+	require.NoError(t, err)
+
+	m.CollectFetchState(func() map[string]FetchState {
+		return map[string]FetchState{
+			"app":    {LastSuccess: time.Unix(1000, 0)},
+			"broken": {ConsecutiveFailures: 3},
+		}
+	})
+
+	err = testutil.GatherAndCompare(m.registry, strings.NewReader(`
+# HELP pico_target_fetch_consecutive_failures Number of consecutive failed fetches of each target's repository.
+# TYPE pico_target_fetch_consecutive_failures gauge
+pico_target_fetch_consecutive_failures{target="app"} 0
+pico_target_fetch_consecutive_failures{target="broken"} 3
+# HELP pico_target_fetch_last_success_timestamp_seconds Time of the last successful fetch of each target's repository.
+# TYPE pico_target_fetch_last_success_timestamp_seconds gauge
+pico_target_fetch_last_success_timestamp_seconds{target="app"} 1000
+`), "pico_target_fetch_consecutive_failures", "pico_target_fetch_last_success_timestamp_seconds")
+	assert.NoError(t, err)
+}
+
+func TestSetConfigRevision(t *testing.T) {
+	m, err := New(nil)
+	require.NoError(t, err)
+
+	m.SetConfigRevision("https://git/config", "aaaa")
+	m.SetConfigRevision("https://git/other", "cccc")
+	m.SetConfigRevision("https://git/config", "bbbb")
+
+	err = testutil.CollectAndCompare(m.revision, strings.NewReader(`
+# HELP pico_config_revision_info The commit of each configuration repository that's in force, always 1.
+# TYPE pico_config_revision_info gauge
+pico_config_revision_info{commit="bbbb",source="https://git/config"} 1
+pico_config_revision_info{commit="cccc",source="https://git/other"} 1
+`))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/httpclient"
+)
+
+// DefaultPushJob is the job name metrics are pushed under when none is set
+const DefaultPushJob = "pico"
+
+// pushRetryDelay is the wait between attempts to push, doubled after each
+var pushRetryDelay = time.Millisecond * 500
+
+// PushConfig is where metrics are pushed to for instances that may not live
+// long enough to be scraped.
+type PushConfig struct {
+	URL      string            // Pushgateway to push to, disabled when empty
+	Job      string            // DefaultPushJob when empty
+	Grouping map[string]string // grouping labels besides the job
+	Client   *http.Client      // http.DefaultClient when nil
+}
+
+// ParseGrouping parses key=value grouping labels for a Pushgateway
+func ParseGrouping(pairs []string) (map[string]string, error) {
+	grouping := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.Errorf("grouping label '%s' is not key=value", p)
+		}
+		if !labelName.MatchString(parts[0]) || parts[0] == "job" {
+			return nil, errors.Errorf("'%s' can't be used as a grouping label", parts[0])
+		}
+		if _, ok := grouping[parts[0]]; ok {
+			return nil, errors.Errorf("grouping label '%s' is listed twice", parts[0])
+		}
+		grouping[parts[0]] = parts[1]
+	}
+	return grouping, nil
+}
+
+// Push replaces the metrics of the job and grouping on the Pushgateway with the
+// current ones. Failed pushes are retried until the context is done, so the
+// context bounds how long pushing may delay an exit.
+func (m *Metrics) Push(ctx context.Context, c PushConfig) error {
+	job := c.Job
+	if job == "" {
+		job = DefaultPushJob
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	pusher := push.New(c.URL, job).
+		Gatherer(m.registry).
+		Client(contextDoer{ctx, client})
+	for k, v := range c.Grouping {
+		pusher = pusher.Grouping(k, v)
+	}
+
+	delay := pushRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := pusher.Push()
+		if err == nil {
+			return nil
+		}
+		err = httpclient.Error(err)
+		if ctx.Err() != nil {
+			return errors.Wrapf(err, "failed to push metrics after %d attempts", attempt)
+		}
+		zap.L().Debug("failed to push metrics, retrying",
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return errors.Wrapf(err, "failed to push metrics after %d attempts", attempt)
+		}
+	}
+}
+
+// contextDoer sends pushes with a context, which the Pushgateway client in use
+// doesn't take itself.
+type contextDoer struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+func (d contextDoer) Do(req *http.Request) (*http.Response, error) {
+	return d.client.Do(req.WithContext(d.ctx))
+}
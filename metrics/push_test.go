@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestParseGrouping(t *testing.T) {
+	grouping, err := ParseGrouping([]string{"instance=web-1", "env=a=b"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"instance": "web-1", "env": "a=b"}, grouping)
+
+	for _, pairs := range [][]string{{"instance"}, {"instance="}, {"job=x"}, {"1x=y"}, {"a=1", "a=2"}} {
+		_, err := ParseGrouping(pairs)
+		assert.Error(t, err, pairs)
+	}
+}
+
+func TestPush(t *testing.T) {
+	defer func(d time.Duration) { pushRetryDelay = d }(pushRetryDelay)
+	pushRetryDelay = time.Millisecond
+
+	var calls int32
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first attempt fails and is retried
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		path, body = r.Method+" "+r.URL.Path, string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	m, err := New(nil)
+	require.NoError(t, err)
+	m.ObserveTask(task.Target{Name: "app"}, task.TriggerChange, false, time.Second, task.ResultSuccess)
+
+	err = m.Push(context.Background(), PushConfig{URL: server.URL, Grouping: map[string]string{"instance": "web-1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, "PUT /metrics/job/pico/instance/web-1", path)
+	assert.Contains(t, body, "pico_task_executions_total")
+
+	// a gateway that keeps failing is given up on once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	atomic.StoreInt32(&calls, 0)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	err = m.Push(ctx, PushConfig{URL: broken.URL, Job: "deploys"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to push metrics after")
+	assert.True(t, atomic.LoadInt32(&calls) > 1)
+}
@@ -0,0 +1,101 @@
+package notifier
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/task"
+)
+
+// DefaultCommandTimeout is how long a notification command may run
+const DefaultCommandTimeout = 10 * time.Second
+
+// commandInput is how much of a task's output, from its end, is written to the
+// command's stdin.
+const commandInput = 64 << 10
+
+var _ Notifier = &Command{}
+
+// Command implements a Notifier that runs a shell command for every event. The
+// event is described by PICO_EVENT, PICO_TARGET, PICO_COMMIT, PICO_STATUS,
+// PICO_TRIGGER, PICO_DURATION, PICO_ERROR and PICO_MESSAGE and the end of the task's output
+// is written to its stdin. Only PATH and HOME are passed from Pico's own
+// environment, the command never sees Pico's or a target's secrets.
+type Command struct {
+	Command string
+	Timeout time.Duration // DefaultCommandTimeout when zero
+}
+
+// Notify implements Notifier
+func (c *Command) Notify(e Event) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.Command("sh", "-c", c.Command)
+	cmd.Env = commandEnv(e)
+	input := e.Output
+	if len(input) > commandInput {
+		input = input[len(input)-commandInput:]
+	}
+	cmd.Stdin = strings.NewReader(input)
+
+	out := task.NewOutput(4096)
+	if err := task.Run(ctx, cmd, out); err != nil {
+		return errors.Wrapf(err, "notify command failed: %s", strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+func commandEnv(e Event) []string {
+	env := []string{
+		"PICO_EVENT=" + string(e.Type),
+		"PICO_TARGET=" + e.Target,
+		"PICO_TASK_ID=" + e.TaskID,
+		"PICO_COMMIT=" + e.Commit,
+		"PICO_STATUS=" + status(e.Type),
+		"PICO_TRIGGER=" + string(e.Trigger),
+		"PICO_DURATION=" + durationSeconds(e.Duration),
+		"PICO_ERROR=" + e.Error,
+		"PICO_ERROR_KIND=" + e.ErrorKind,
+		"PICO_MESSAGE=" + e.Message,
+	}
+	for _, k := range []string{"PATH", "HOME"} {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	return env
+}
+
+// status is the outcome of a task event, empty for other events
+func status(t EventType) string {
+	switch t {
+	case EventTaskStarted:
+		return "started"
+	case EventTaskSucceeded:
+		return "success"
+	case EventTaskFailed:
+		return "failure"
+	case EventTaskNoop:
+		return "noop"
+	}
+	return ""
+}
+
+// durationSeconds formats a duration as seconds, such as "12.345"
+func durationSeconds(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,64 @@
+package notifier
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-notify")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	os.Setenv("PICO_TEST_SECRET", "hunter2") //nolint:errcheck
+	defer os.Unsetenv("PICO_TEST_SECRET")    //nolint:errcheck
+	out := filepath.Join(dir, "out")
+
+	c := &Command{Command: "env | grep -E '^(PICO_|PATH=)' | sort > " + out + "; cat >> " + out}
+	err = c.Notify(Event{
+		Type:      EventTaskFailed,
+		Target:    "app",
+		TaskID:    "0123456789abcdef",
+		Commit:    "abc123",
+		Trigger:   task.TriggerWebhook,
+		Duration:  1500 * time.Millisecond,
+		Error:     "exit status 1",
+		ErrorKind: "command_failed",
+		Message:   "app failed: exit status 1",
+		Output:    "started\nfailed\n",
+	})
+	require.NoError(t, err)
+
+	got, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	lines := strings.Split(string(got), "\n")
+	assert.Contains(t, lines, "PICO_EVENT=task_failed")
+	assert.Contains(t, lines, "PICO_TARGET=app")
+	assert.Contains(t, lines, "PICO_TASK_ID=0123456789abcdef")
+	assert.Contains(t, lines, "PICO_COMMIT=abc123")
+	assert.Contains(t, lines, "PICO_STATUS=failure")
+	assert.Contains(t, lines, "PICO_TRIGGER=webhook")
+	assert.Contains(t, lines, "PICO_DURATION=1.500")
+	assert.Contains(t, lines, "PICO_ERROR=exit status 1")
+	assert.Contains(t, lines, "PICO_ERROR_KIND=command_failed")
+	assert.NotContains(t, string(got), "hunter2")
+	assert.True(t, strings.HasSuffix(string(got), "started\nfailed\n"), string(got))
+}
+
+func TestCommandFailure(t *testing.T) {
+	err := (&Command{Command: "echo nope >&2; exit 3"}).Notify(Event{Type: EventTaskSucceeded})
+	assert.EqualError(t, err, "notify command failed: nope: exit status 3")
+
+	start := time.Now()
+	err = (&Command{Command: "sleep 10", Timeout: 100 * time.Millisecond}).Notify(Event{})
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
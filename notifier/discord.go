@@ -0,0 +1,244 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/httpclient"
+	"github.com/picostack/pico/redact"
+	"github.com/picostack/pico/task"
+)
+
+// discordQueueSize is how many messages wait to be posted to Discord, such as
+// while rate limited, before new ones are dropped.
+const discordQueueSize = 100
+
+// discordAttempts bounds how many times a message is posted, it's only retried
+// when Discord rate limits it or can't be reached.
+const discordAttempts = 5
+
+// embed colours by event
+const (
+	colourStarted = 0x3498db
+	colourSuccess = 0x2ecc71
+	colourFailure = 0xe74c3c
+	colourOther   = 0x95a5a6
+)
+
+var _ Notifier = &Discord{}
+
+// Discord implements a Notifier that posts task events to a Discord webhook as
+// embeds. Messages are posted in order by a single worker which waits out rate
+// limits rather than dropping messages, unless too many are waiting.
+type Discord struct {
+	webhook string
+	client  *http.Client
+	queue   chan discordMessage
+	sleep   func(time.Duration)
+}
+
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+	Footer      *discordFooter `json:"footer,omitempty"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+// NewDiscord creates a notifier that posts to the given Discord webhook URL
+// with the client, httpclient.Default when it's nil.
+func NewDiscord(webhook string, client *http.Client) *Discord {
+	if client == nil {
+		client = httpclient.Default()
+	}
+	d := &Discord{
+		webhook: webhook,
+		client:  client,
+		queue:   make(chan discordMessage, discordQueueSize),
+		sleep:   time.Sleep,
+	}
+	go d.run()
+	return d
+}
+
+// Notify implements Notifier, only task events are posted
+func (d *Discord) Notify(e Event) error {
+	m, ok := discordEvent(e)
+	if !ok {
+		return nil
+	}
+	select {
+	case d.queue <- m:
+		return nil
+	default:
+		return errors.New("discord notification queue is full, dropping message")
+	}
+}
+
+func (d *Discord) run() {
+	for m := range d.queue {
+		if err := d.post(m); err != nil {
+			zap.L().Warn("failed to post discord notification", zap.Error(err))
+		}
+	}
+}
+
+// post sends a message, waiting and retrying while Discord rate limits it
+func (d *Discord) post(m discordMessage) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		wait, err := d.send(body)
+		if err == nil {
+			return nil
+		}
+		if wait == 0 || attempt == discordAttempts {
+			return err
+		}
+		zap.L().Debug("retrying discord notification", zap.Duration("wait", wait), zap.Error(err))
+		d.sleep(wait)
+	}
+}
+
+// send posts the body once, a retryable failure returns how long to wait
+func (d *Discord) send(body []byte) (time.Duration, error) {
+	resp, err := d.client.Post(d.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 5 * time.Second, errors.Wrap(httpclient.Error(err), "failed to reach discord")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return retryAfter(resp), errors.New("rate limited by discord")
+	case resp.StatusCode >= 500:
+		return 5 * time.Second, errors.Errorf("discord responded %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return 0, errors.Errorf("discord responded %s", resp.Status)
+	}
+	return 0, nil
+}
+
+// retryAfter reads how long Discord asked to wait from a rate limited response
+func retryAfter(resp *http.Response) time.Duration {
+	var limit struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&limit); err == nil && limit.RetryAfter > 0 {
+		return time.Duration(limit.RetryAfter * float64(time.Second))
+	}
+	if s, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && s > 0 {
+		return time.Duration(s * float64(time.Second))
+	}
+	return time.Second
+}
+
+// discordEvent builds the message for an event, if it's a task event
+func discordEvent(e Event) (discordMessage, bool) {
+	embed := discordEmbed{
+		Description: redact.String(e.Message),
+		Timestamp:   e.Time.Format(time.RFC3339),
+	}
+	switch e.Type {
+	case EventTaskStarted:
+		embed.Title = fmt.Sprintf("Deploying %s", e.Target)
+		embed.Color = colourStarted
+	case EventTaskSucceeded:
+		embed.Title = fmt.Sprintf("Deployed %s", e.Target)
+		embed.Color = colourSuccess
+	case EventTaskFailed:
+		embed.Title = fmt.Sprintf("Failed to deploy %s", e.Target)
+		embed.Color = colourFailure
+	case EventTaskNoop:
+		embed.Title = fmt.Sprintf("Nothing to deploy for %s", e.Target)
+		embed.Color = colourOther
+	default:
+		return discordMessage{}, false
+	}
+	if e.Time.IsZero() {
+		embed.Timestamp = ""
+	}
+
+	embed.Fields = append(embed.Fields, discordField{Name: "Target", Value: e.Target, Inline: true})
+	if e.Commit != "" {
+		commit := "`" + short(e.Commit) + "`"
+		if link := CommitURL(e.Repo, e.Commit); link != "" {
+			commit = fmt.Sprintf("[%s](%s)", commit, link)
+		}
+		embed.Fields = append(embed.Fields, discordField{Name: "Commit", Value: commit, Inline: true})
+	}
+	if e.Author != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "Author", Value: redact.String(e.Author), Inline: true})
+	}
+	if e.Duration > 0 {
+		embed.Fields = append(embed.Fields, discordField{Name: "Duration", Value: e.Duration.Round(time.Second).String(), Inline: true})
+	}
+	if e.Type == EventTaskFailed && e.Output != "" {
+		// embed field values are limited to 1024 characters
+		out := redact.String(e.Output)
+		if len(out) > 1000 {
+			out = out[len(out)-1000:]
+		}
+		embed.Fields = append(embed.Fields, discordField{Name: "Output", Value: "```\n" + strings.Replace(out, "```", "'''", -1) + "\n```"})
+	}
+	if e.Version != "" {
+		embed.Footer = &discordFooter{Text: "Pico " + e.Version}
+	}
+	return discordMessage{Embeds: []discordEmbed{embed}}, true
+}
+
+func short(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// CommitURL returns the web URL of a commit for repositories on GitHub, GitLab
+// and Bitbucket, or an empty string if the host isn't known.
+func CommitURL(repo, commit string) string {
+	if repo == "" || commit == "" {
+		return ""
+	}
+	parts := strings.SplitN(task.NormaliseRepo(repo), "/", 2)
+	host, path := parts[0], ""
+	if len(parts) == 2 {
+		path = parts[1]
+	}
+	if host == "" || path == "" {
+		return ""
+	}
+	switch {
+	case host == "github.com", strings.HasPrefix(host, "gitlab."):
+		return fmt.Sprintf("https://%s/%s/commit/%s", host, path, commit)
+	case host == "bitbucket.org":
+		return fmt.Sprintf("https://%s/%s/commits/%s", host, path, commit)
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/redact"
+)
+
+func TestDiscord(t *testing.T) {
+	redact.Add("hunter2")
+
+	var mu sync.Mutex
+	var requests int
+	posted := make(chan discordMessage, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		limited := requests == 1
+		mu.Unlock()
+		if limited {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.01}`)) //nolint:errcheck
+			return
+		}
+		var m discordMessage
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		posted <- m
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	d := NewDiscord(srv.URL, nil)
+	var waited time.Duration
+	d.sleep = func(wait time.Duration) { waited = wait }
+
+	require.NoError(t, d.Notify(Event{
+		Type:     EventTaskFailed,
+		Message:  "app failed: password hunter2 rejected",
+		Target:   "app",
+		Repo:     "git@github.com:picostack/app.git",
+		Commit:   "0123456789abcdef",
+		Duration: 90 * time.Second,
+		Output:   "login with hunter2\nexit status 1",
+	}))
+	require.NoError(t, d.Notify(Event{Type: EventConfigChanged, Message: "not posted"}))
+
+	select {
+	case m := <-posted:
+		require.Len(t, m.Embeds, 1)
+		e := m.Embeds[0]
+		assert.Equal(t, "Failed to deploy app", e.Title)
+		assert.Equal(t, "app failed: password [REDACTED] rejected", e.Description)
+		assert.Equal(t, colourFailure, e.Color)
+		assert.Equal(t, []discordField{
+			{Name: "Target", Value: "app", Inline: true},
+			{Name: "Commit", Value: "[`0123456`](https://github.com/picostack/app/commit/0123456789abcdef)", Inline: true},
+			{Name: "Duration", Value: "1m30s", Inline: true},
+			{Name: "Output", Value: "```\nlogin with [REDACTED]\nexit status 1\n```"},
+		}, e.Fields)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not posted")
+	}
+	assert.Equal(t, 10*time.Millisecond, waited)
+}
+
+func TestDiscordQueueFull(t *testing.T) {
+	d := &Discord{queue: make(chan discordMessage, 1)}
+	assert.NoError(t, d.Notify(Event{Type: EventTaskStarted, Target: "app"}))
+	assert.Error(t, d.Notify(Event{Type: EventTaskStarted, Target: "app"}))
+}
+
+func TestCommitURL(t *testing.T) {
+	for _, tt := range []struct {
+		repo string
+		want string
+	}{
+		{"https://github.com/picostack/pico", "https://github.com/picostack/pico/commit/abc"},
+		{"https://github.com/picostack/pico.git", "https://github.com/picostack/pico/commit/abc"},
+		{"git@github.com:picostack/pico.git", "https://github.com/picostack/pico/commit/abc"},
+		{"ssh://git@gitlab.example.com/group/sub/app.git", "https://gitlab.example.com/group/sub/app/commit/abc"},
+		{"https://bitbucket.org/team/app", "https://bitbucket.org/team/app/commits/abc"},
+		{"https://git.example.com/app", ""},
+		{"/srv/repos/app", ""},
+		{"", ""},
+	} {
+		assert.Equal(t, tt.want, CommitURL(tt.repo, "abc"), tt.repo)
+	}
+}
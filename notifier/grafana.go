@@ -0,0 +1,96 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// grafanaQueueSize is how many annotations wait to be posted before new ones
+// are dropped.
+const grafanaQueueSize = 100
+
+var _ Notifier = &Grafana{}
+
+// Grafana implements a Notifier that adds an annotation to Grafana for every
+// finished task, spanning the time the task executed and tagged with its
+// target, commit and status so deploys can be overlaid on dashboards.
+// Annotations are only informational, one that can't be posted after a couple
+// of retries is dropped.
+type Grafana struct {
+	url    string
+	token  string
+	queue  chan GrafanaAnnotation
+	poster *poster
+}
+
+// GrafanaAnnotation is the body posted to Grafana's annotations API, times are
+// in milliseconds since the epoch.
+type GrafanaAnnotation struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd"`
+	Tags    []string `json:"tags"`
+	Text    string   `json:"text"`
+}
+
+// NewGrafana creates a notifier that posts annotations to the Grafana instance
+// at url, authenticated with the API token unless it's empty. Annotations are
+// posted with the client, httpclient.Default when it's nil.
+func NewGrafana(url, token string, client *http.Client) *Grafana {
+	g := &Grafana{
+		url:    strings.TrimSuffix(url, "/") + "/api/annotations",
+		token:  token,
+		queue:  make(chan GrafanaAnnotation, grafanaQueueSize),
+		poster: newPoster(client, 3),
+	}
+	go g.run()
+	return g
+}
+
+// Notify implements Notifier, only finished tasks are annotated
+func (g *Grafana) Notify(e Event) error {
+	if e.Type != EventTaskSucceeded && e.Type != EventTaskFailed && e.Type != EventTaskNoop {
+		return nil
+	}
+	select {
+	case g.queue <- annotation(e):
+		return nil
+	default:
+		return errors.New("grafana queue is full, dropping annotation")
+	}
+}
+
+func annotation(e Event) GrafanaAnnotation {
+	tags := []string{"pico", "target:" + e.Target, "status:" + status(e.Type)}
+	if e.Commit != "" {
+		tags = append(tags, "commit:"+short(e.Commit))
+	}
+	return GrafanaAnnotation{
+		Time:    e.Time.Add(-e.Duration).UnixNano() / 1e6,
+		TimeEnd: e.Time.UnixNano() / 1e6,
+		Tags:    tags,
+		Text:    e.Message,
+	}
+}
+
+func (g *Grafana) run() {
+	header := make(http.Header)
+	if g.token != "" {
+		header.Set("Authorization", "Bearer "+g.token)
+	}
+	for a := range g.queue {
+		body, err := json.Marshal(a)
+		if err != nil {
+			zap.L().Error("failed to encode grafana annotation", zap.Error(err))
+			continue
+		}
+		if err := g.poster.post(g.url, body, header); err != nil {
+			zap.L().Debug("failed to post grafana annotation, dropping it",
+				zap.Strings("tags", a.Tags),
+				zap.Error(err))
+		}
+	}
+}
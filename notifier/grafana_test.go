@@ -0,0 +1,50 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrafana(t *testing.T) {
+	received := make(chan GrafanaAnnotation, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/grafana/api/annotations", r.URL.Path)
+		assert.Equal(t, "Bearer t0ken", r.Header.Get("Authorization"))
+		var a GrafanaAnnotation
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&a))
+		received <- a
+	}))
+	defer srv.Close()
+
+	g := NewGrafana(srv.URL+"/grafana/", "t0ken", nil)
+
+	finished := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, g.Notify(Event{Type: EventTaskStarted, Time: finished, Target: "app"}))
+	require.NoError(t, g.Notify(Event{
+		Type:     EventTaskFailed,
+		Time:     finished,
+		Message:  "app failed",
+		Target:   "app",
+		Commit:   "abc123def456",
+		Duration: time.Minute,
+	}))
+
+	select {
+	case a := <-received:
+		assert.Equal(t, GrafanaAnnotation{
+			Time:    finished.Add(-time.Minute).UnixNano() / 1e6,
+			TimeEnd: finished.UnixNano() / 1e6,
+			Tags:    []string{"pico", "target:app", "status:failure", "commit:abc123d"},
+			Text:    "app failed",
+		}, a)
+	case <-time.After(5 * time.Second):
+		t.Fatal("annotation was not posted")
+	}
+	assert.Empty(t, received, "started tasks aren't annotated")
+}
@@ -0,0 +1,84 @@
+package notifier
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/httpclient"
+)
+
+// poster posts JSON bodies for the notifiers that deliver events over HTTP.
+// Every request is retried with exponential backoff on network errors and 5xx
+// responses, other responses fail at once. Errors are redacted since URLs, such
+// as webhook URLs, often hold tokens.
+type poster struct {
+	client  *http.Client
+	retrier *retrier.Retrier
+}
+
+// newPoster creates a poster sending with the client, httpclient.Default when
+// it's nil.
+func newPoster(client *http.Client, retries int) *poster {
+	if client == nil {
+		client = httpclient.Default()
+	}
+	return &poster{
+		client:  client,
+		retrier: retrier.New(retrier.ExponentialBackoff(retries, time.Second), retryable{}),
+	}
+}
+
+// post sends the body to url with the given headers until it's accepted or the
+// retries are exhausted.
+func (p *poster) post(url string, body []byte, header http.Header) error {
+	err := p.retrier.Run(func() error { return p.send(url, body, header) })
+	if err != nil {
+		return httpclient.Error(err)
+	}
+	return nil
+}
+
+func (p *poster) send(url string, body []byte, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanent{err}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return errors.Errorf("responded %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return permanent{errors.Errorf("responded %s", resp.Status)}
+	}
+	return nil
+}
+
+// permanent is a delivery failure that won't succeed if retried
+type permanent struct{ error }
+
+// retryable classifies permanent failures as failed, others are retried
+type retryable struct{}
+
+func (retryable) Classify(err error) retrier.Action {
+	if err == nil {
+		return retrier.Succeed
+	}
+	if _, ok := err.(permanent); ok {
+		return retrier.Fail
+	}
+	return retrier.Retry
+}
@@ -0,0 +1,58 @@
+package notifier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/httpclient"
+	"github.com/picostack/pico/redact"
+)
+
+func TestPosterPermanentFailure(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		assert.Equal(t, "value", r.Header.Get("X-Test"))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	p := newPoster(nil, 3)
+	p.retrier = retrier.New(retrier.ExponentialBackoff(3, time.Millisecond), retryable{})
+	err := p.post(srv.URL, []byte(`{}`), http.Header{"X-Test": {"value"}})
+	assert.EqualError(t, err, "responded 404 Not Found")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "client errors aren't retried")
+}
+
+func TestPosterRedactsErrors(t *testing.T) {
+	redact.Add("tok3n")
+	p := newPoster(nil, 1)
+	p.retrier = retrier.New(retrier.ExponentialBackoff(1, time.Millisecond), retryable{})
+	err := p.post("http://127.0.0.1:0/hooks/tok3n", []byte(`{}`), nil)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "tok3n")
+}
+
+func TestPosterClient(t *testing.T) {
+	rec := &httpclient.Recorder{}
+	p := newPoster(rec.Client(), 1)
+	assert.NoError(t, p.post("https://hooks.example.com/t0ken", []byte(`{"type":"task_succeeded"}`), http.Header{"X-Test": {"value"}}))
+
+	requests := rec.Requests()
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, http.MethodPost, requests[0].Method)
+		assert.Equal(t, "https://hooks.example.com/t0ken", requests[0].URL)
+		assert.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+		assert.Equal(t, "value", requests[0].Header.Get("X-Test"))
+		assert.JSONEq(t, `{"type":"task_succeeded"}`, string(requests[0].Body))
+	}
+
+	rec.Status = http.StatusForbidden
+	assert.EqualError(t, p.post("https://hooks.example.com/t0ken", []byte(`{}`), nil), "responded 403 Forbidden")
+}
@@ -0,0 +1,106 @@
+// Package notifier provides an interface and implementations for delivering
+// notifications about events that occur inside Pico, such as configuration
+// changes, to external systems. Notifiers are informational only, a failure to
+// deliver a notification never affects the operation that triggered it.
+package notifier
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+// EventType identifies the kind of event being notified
+type EventType string
+
+const (
+	// EventConfigChanged is emitted when a new configuration is applied
+	EventConfigChanged EventType = "config_changed"
+	// EventConfigInvalid is emitted when a configuration revision fails to apply
+	EventConfigInvalid EventType = "config_invalid"
+	// EventConfigRecovered is emitted when a valid revision follows an invalid one
+	EventConfigRecovered EventType = "config_recovered"
+	// EventConfigStale is emitted when the configuration repository has been
+	// unreachable for longer than the stale threshold
+	EventConfigStale EventType = "config_stale"
+	// EventConfigReachable is emitted when a stale configuration repository is
+	// reachable again
+	EventConfigReachable EventType = "config_reachable"
+	// EventLeadershipChanged is emitted when an instance gains or loses leadership
+	EventLeadershipChanged EventType = "leadership_changed"
+	// EventTaskStarted is emitted when a target's task starts executing
+	EventTaskStarted EventType = "task_started"
+	// EventTaskSucceeded is emitted when a target's task completes successfully
+	EventTaskSucceeded EventType = "task_succeeded"
+	// EventTaskFailed is emitted when a target's task fails
+	EventTaskFailed EventType = "task_failed"
+	// EventTaskNoop is emitted when a target's task exits with one of its noop
+	// exit codes, it succeeded without anything to do
+	EventTaskNoop EventType = "task_noop"
+	// EventActivated is emitted when a standby instance is activated
+	EventActivated EventType = "activated"
+	// EventDiskUsage is emitted when the data directory exceeds its size limit
+	EventDiskUsage EventType = "disk_usage"
+)
+
+// Event represents something that happened which may be of interest
+type Event struct {
+	Type     EventType         `json:"type"`
+	Time     time.Time         `json:"time"`
+	Message  string            `json:"message"`
+	Diff     *task.TargetsDiff `json:"diff,omitempty"`
+	Target   string            `json:"target,omitempty"`   // the target of task events
+	TaskID   string            `json:"task_id,omitempty"`  // the ID of the task of task events
+	Group    string            `json:"group,omitempty"`    // the target's group, for routing
+	Labels   map[string]string `json:"labels,omitempty"`   // the target's labels, for routing
+	Repo     string            `json:"repo,omitempty"`     // the target's or configuration's repository URL
+	Commit   string            `json:"commit,omitempty"`   // the commit of task and configuration change events
+	Author   string            `json:"author,omitempty"`   // the author of the commit
+	Duration time.Duration     `json:"duration,omitempty"` // how long the task took
+	Error    string            `json:"error,omitempty"`    // why the task failed
+	// Output is the output of the task, already redacted and truncated to the
+	// executor's output limit.
+	Output string `json:"output,omitempty"`
+	// ErrorKind classifies Error, such as auth_failed or command_failed
+	ErrorKind string `json:"error_kind,omitempty"`
+	// StaleSecrets is set on task events that used cached secrets
+	StaleSecrets bool `json:"stale_secrets,omitempty"`
+	// Images are the images running after a successful deploy
+	Images []state.Image `json:"images,omitempty"`
+	// Trigger is what caused the task of task events, TriggerDetail is about
+	// it, such as the webhook delivery or the admin user.
+	Trigger       task.Trigger `json:"trigger,omitempty"`
+	TriggerDetail string       `json:"trigger_detail,omitempty"`
+	Version       string       `json:"version"` // the Pico version, for notification footers
+}
+
+// Notifier describes a type that can deliver events somewhere
+type Notifier interface {
+	Notify(Event) error
+}
+
+var _ Notifier = Multi{}
+
+// Multi implements a Notifier that delivers each event to a list of notifiers.
+// An empty Multi discards all events.
+type Multi []Notifier
+
+// Notify implements Notifier, failures are logged and don't prevent delivery
+// to the remaining notifiers.
+func (m Multi) Notify(e Event) error {
+	if e.Version == "" {
+		e.Version = buildinfo.Get().String()
+	}
+	for _, n := range m {
+		if err := n.Notify(e); err != nil {
+			zap.L().Warn("failed to deliver notification",
+				zap.String("type", string(e.Type)),
+				zap.Error(err))
+		}
+	}
+	return nil
+}
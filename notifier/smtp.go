@@ -0,0 +1,258 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/pkg/errors"
+)
+
+// DefaultSubject is the subject template of notification emails
+const DefaultSubject = "[pico] {{if .Target}}{{.Target}} {{end}}{{.Status}}"
+
+// smtpTimeout bounds each attempt to deliver an email
+const smtpTimeout = 30 * time.Second
+
+// emailOutputLines is how many lines from the end of a failed task's output are
+// included in its email.
+const emailOutputLines = 50
+
+// SMTPConfig describes how notification emails are sent
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	TLS      string // "starttls", "tls" for implicit TLS or "none"
+	Username string
+	Password string `json:"-"`
+	From     string
+	To       []string
+	Subject  string // a text/template of the subject, DefaultSubject when empty
+}
+
+var _ Notifier = &SMTP{}
+
+// SMTP implements a Notifier that emails failures and recoveries: failed tasks,
+// tasks that succeed after failing, configuration revisions that are invalid
+// or valid again and disk usage warnings. Other events aren't emailed.
+// Delivery is attempted a few times before the email is dropped.
+type SMTP struct {
+	config  SMTPConfig
+	subject *template.Template
+	retrier *retrier.Retrier
+
+	mu       sync.Mutex
+	failures map[string]Event // the last failure of each target, until it recovers
+}
+
+// email is the data available to the subject template
+type email struct {
+	Event
+	Status string // "failed", "recovered", "invalid" or the event type
+}
+
+// NewSMTP creates a notifier that sends emails as configured
+func NewSMTP(c SMTPConfig) (*SMTP, error) {
+	switch c.TLS {
+	case "":
+		c.TLS = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, errors.Errorf("unknown SMTP TLS mode '%s', must be starttls, tls or none", c.TLS)
+	}
+	if c.Host == "" || c.From == "" || len(c.To) == 0 {
+		return nil, errors.New("SMTP notifications need a host, a from address and at least one to address")
+	}
+	if c.Port == 0 {
+		c.Port = 587
+		if c.TLS == "tls" {
+			c.Port = 465
+		}
+	}
+	if c.Subject == "" {
+		c.Subject = DefaultSubject
+	}
+	subject, err := template.New("subject").Parse(c.Subject)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid SMTP subject template")
+	}
+
+	return &SMTP{
+		config:   c,
+		subject:  subject,
+		retrier:  retrier.New(retrier.ExponentialBackoff(3, 2*time.Second), nil),
+		failures: make(map[string]Event),
+	}, nil
+}
+
+// Notify implements Notifier
+func (s *SMTP) Notify(e Event) error {
+	m, ok := s.message(e)
+	if !ok {
+		return nil
+	}
+	return errors.Wrap(s.retrier.Run(func() error { return s.send(m) }), "failed to send email")
+}
+
+// message builds the email for an event, if it's one that's emailed
+func (s *SMTP) message(e Event) ([]byte, bool) {
+	data := email{Event: e, Status: string(e.Type)}
+	var body strings.Builder
+
+	switch e.Type {
+	case EventTaskFailed:
+		s.mu.Lock()
+		s.failures[e.Target] = e
+		s.mu.Unlock()
+
+		data.Status = "failed"
+		fmt.Fprintf(&body, "%s\n\n", e.Message)
+		writeField(&body, "Target", e.Target)
+		writeField(&body, "Commit", e.Commit)
+		writeField(&body, "Author", e.Author)
+		writeField(&body, "Time", e.Time.Format(time.RFC1123Z))
+		if out := tail(e.Output, emailOutputLines); out != "" {
+			fmt.Fprintf(&body, "\nLast %d lines of output:\n\n%s\n", emailOutputLines, out)
+		}
+
+	case EventTaskSucceeded, EventTaskNoop:
+		s.mu.Lock()
+		failure, failed := s.failures[e.Target]
+		delete(s.failures, e.Target)
+		s.mu.Unlock()
+		if !failed {
+			return nil, false
+		}
+
+		data.Status = "recovered"
+		fmt.Fprintf(&body, "%s\n\n", e.Message)
+		writeField(&body, "Target", e.Target)
+		writeField(&body, "Commit", e.Commit)
+		writeField(&body, "Author", e.Author)
+		writeField(&body, "Time", e.Time.Format(time.RFC1123Z))
+		fmt.Fprintf(&body, "\nThis recovers from the failure at %s:\n\n%s\n",
+			failure.Time.Format(time.RFC1123Z), failure.Message)
+		if failure.Commit != "" {
+			fmt.Fprintf(&body, "(commit %s)\n", failure.Commit)
+		}
+
+	case EventConfigInvalid, EventConfigRecovered, EventConfigStale, EventConfigReachable, EventDiskUsage:
+		switch e.Type {
+		case EventConfigInvalid:
+			data.Status = "invalid configuration"
+		case EventConfigRecovered:
+			data.Status = "configuration recovered"
+		case EventConfigStale:
+			data.Status = "stale configuration"
+		case EventConfigReachable:
+			data.Status = "configuration reachable"
+		default:
+			data.Status = "disk usage"
+		}
+		fmt.Fprintf(&body, "%s\n\n", e.Message)
+		writeField(&body, "Time", e.Time.Format(time.RFC1123Z))
+
+	default:
+		return nil, false
+	}
+
+	if e.Version != "" {
+		fmt.Fprintf(&body, "\n-- \nPico %s\n", e.Version)
+	}
+
+	var subject bytes.Buffer
+	if err := s.subject.Execute(&subject, data); err != nil {
+		subject.Reset()
+		subject.WriteString("[pico] " + data.Status)
+	}
+
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&m, "To: %s\r\n", strings.Join(s.config.To, ", "))
+	fmt.Fprintf(&m, "Subject: %s\r\n", strings.NewReplacer("\r", "", "\n", " ").Replace(subject.String()))
+	fmt.Fprintf(&m, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	m.WriteString("MIME-Version: 1.0\r\n")
+	m.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	m.WriteString(strings.Replace(body.String(), "\n", "\r\n", -1))
+	return m.Bytes(), true
+}
+
+func writeField(b *strings.Builder, name, value string) {
+	if value != "" {
+		fmt.Fprintf(b, "%s: %s\n", name, value)
+	}
+}
+
+// tail returns the last n lines of s
+func tail(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// send delivers a message in a single connection to the server
+func (s *SMTP) send(m []byte) error {
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+	tlsConfig := &tls.Config{ServerName: s.config.Host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	if s.config.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(smtpTimeout)) //nolint:errcheck
+
+	c, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if s.config.TLS == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return errors.Wrap(err, "STARTTLS failed")
+		}
+	}
+	if s.config.Username != "" {
+		auth := smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+		if err := c.Auth(auth); err != nil {
+			return errors.Wrap(err, "authentication failed")
+		}
+	}
+	if err := c.Mail(s.config.From); err != nil {
+		return err
+	}
+	for _, to := range s.config.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
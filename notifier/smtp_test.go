@@ -0,0 +1,129 @@
+package notifier
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpServer accepts connections and sends the data of every message received
+func smtpServer(t *testing.T, messages chan<- string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) } //nolint:errcheck
+				reply("220 localhost ESMTP")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+					case "EHLO", "HELO":
+						reply("250-localhost\r\n250 OK")
+					case "DATA":
+						reply("354 go ahead")
+						var data strings.Builder
+						for {
+							line, err := r.ReadString('\n')
+							if err != nil || line == ".\r\n" {
+								break
+							}
+							data.WriteString(line)
+						}
+						messages <- data.String()
+						reply("250 OK")
+					case "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 OK")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l
+}
+
+func TestSMTP(t *testing.T) {
+	messages := make(chan string, 4)
+	l := smtpServer(t, messages)
+	defer l.Close()
+	addr := l.Addr().(*net.TCPAddr)
+	s, err := NewSMTP(SMTPConfig{
+		Host: addr.IP.String(),
+		Port: addr.Port,
+		TLS:  "none",
+		From: "pico@example.com",
+		To:   []string{"oncall@example.com"},
+	})
+	require.NoError(t, err)
+
+	failed := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, s.Notify(Event{
+		Type:    EventTaskFailed,
+		Time:    failed,
+		Message: "app failed: exit status 1",
+		Target:  "app",
+		Commit:  "abc123",
+		Author:  "Someone <someone@example.com>",
+		Output:  strings.Repeat("noise\n", 100) + "the real error\n",
+	}))
+	m := <-messages
+	assert.Contains(t, m, "Subject: [pico] app failed\r\n")
+	assert.Contains(t, m, "To: oncall@example.com\r\n")
+	assert.Contains(t, m, "Commit: abc123\r\n")
+	assert.Contains(t, m, "Author: Someone <someone@example.com>\r\n")
+	assert.Contains(t, m, "the real error")
+	assert.Equal(t, emailOutputLines-1, strings.Count(m, "noise"))
+
+	require.NoError(t, s.Notify(Event{Type: EventTaskSucceeded, Message: "other deployed def456", Target: "other"}))
+	require.NoError(t, s.Notify(Event{Type: EventConfigChanged, Message: "configuration changed"}))
+	require.NoError(t, s.Notify(Event{Type: EventTaskSucceeded, Message: "app deployed def456", Target: "app", Commit: "def456"}))
+	m = <-messages
+	assert.Contains(t, m, "Subject: [pico] app recovered\r\n")
+	assert.Contains(t, m, "This recovers from the failure at Thu, 02 Jan 2020 03:04:05 +0000:\r\n\r\napp failed: exit status 1\r\n(commit abc123)")
+	assert.Empty(t, messages, "only failures and recoveries are emailed")
+}
+
+func TestSMTPUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	s, err := NewSMTP(SMTPConfig{Host: addr.IP.String(), Port: addr.Port, TLS: "none", From: "a@b", To: []string{"c@d"}})
+	require.NoError(t, err)
+	s.retrier = retrier.New(retrier.ConstantBackoff(2, time.Millisecond), nil)
+	err = s.Notify(Event{Type: EventTaskFailed, Target: "app"})
+	assert.Error(t, err)
+}
+
+func TestNewSMTP(t *testing.T) {
+	_, err := NewSMTP(SMTPConfig{Host: "mail", From: "a@b", To: []string{"c@d"}, TLS: "ssl"})
+	assert.EqualError(t, err, "unknown SMTP TLS mode 'ssl', must be starttls, tls or none")
+	_, err = NewSMTP(SMTPConfig{Host: "mail", From: "a@b"})
+	assert.Error(t, err)
+	_, err = NewSMTP(SMTPConfig{Host: "mail", From: "a@b", To: []string{"c@d"}, Subject: "{{.Nope"})
+	assert.Error(t, err)
+
+	s, err := NewSMTP(SMTPConfig{Host: "mail", From: "a@b", To: []string{"c@d"}, TLS: "tls"})
+	require.NoError(t, err)
+	assert.Equal(t, 465, s.config.Port)
+}
@@ -0,0 +1,151 @@
+package notifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/httpclient"
+	"github.com/picostack/pico/redact"
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+// WebhookSchemaVersion is the version of the JSON payload posted to webhooks,
+// it's incremented whenever a field changes meaning or is removed.
+const WebhookSchemaVersion = 1
+
+// SignatureHeader carries the HMAC-SHA256 signature of the body, hex encoded
+// and prefixed with "sha256=".
+const SignatureHeader = "X-Pico-Signature"
+
+// webhookQueueSize is how many events wait to be delivered before new ones are
+// dropped.
+const webhookQueueSize = 100
+
+var _ Notifier = &Webhook{}
+
+// Webhook implements a Notifier that posts every event as JSON to a list of
+// URLs. Events are delivered in the background, in order, and retried with
+// exponential backoff on network errors and 5xx responses. An event that still
+// can't be delivered is logged in full and dropped.
+type Webhook struct {
+	urls   []string
+	secret []byte
+	queue  chan WebhookPayload
+	poster *poster
+}
+
+// WebhookPayload is the JSON body posted to webhooks
+type WebhookPayload struct {
+	SchemaVersion int               `json:"schema_version"`
+	Type          EventType         `json:"type"`
+	Time          time.Time         `json:"time"`
+	Message       string            `json:"message"`
+	Target        string            `json:"target,omitempty"`
+	TaskID        string            `json:"task_id,omitempty"`
+	Group         string            `json:"group,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Commit        string            `json:"commit,omitempty"`
+	Status        string            `json:"status,omitempty"` // "started", "success" or "failure" for task events
+	Trigger       task.Trigger      `json:"trigger,omitempty"`
+	TriggerDetail string            `json:"trigger_detail,omitempty"`
+	Started       *time.Time        `json:"started,omitempty"`
+	Finished      *time.Time        `json:"finished,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	ErrorKind     string            `json:"error_kind,omitempty"`
+	Diff          *task.TargetsDiff `json:"diff,omitempty"`
+	Images        []state.Image     `json:"images,omitempty"`
+	Version       string            `json:"version"`
+}
+
+// NewWebhook creates a notifier that posts to every URL with the client,
+// httpclient.Default when it's nil. Bodies are signed with the secret unless
+// it's empty.
+func NewWebhook(urls []string, secret string, client *http.Client) *Webhook {
+	w := &Webhook{
+		urls:   urls,
+		secret: []byte(secret),
+		queue:  make(chan WebhookPayload, webhookQueueSize),
+		poster: newPoster(client, 5),
+	}
+	go w.run()
+	return w
+}
+
+// Notify implements Notifier
+func (w *Webhook) Notify(e Event) error {
+	select {
+	case w.queue <- payload(e):
+		return nil
+	default:
+		return errors.New("webhook queue is full, dropping event")
+	}
+}
+
+func payload(e Event) WebhookPayload {
+	p := WebhookPayload{
+		SchemaVersion: WebhookSchemaVersion,
+		Type:          e.Type,
+		Time:          e.Time,
+		Message:       e.Message,
+		Target:        e.Target,
+		TaskID:        e.TaskID,
+		Group:         e.Group,
+		Labels:        e.Labels,
+		Commit:        e.Commit,
+		Status:        status(e.Type),
+		Trigger:       e.Trigger,
+		TriggerDetail: e.TriggerDetail,
+		Error:         e.Error,
+		ErrorKind:     e.ErrorKind,
+		Diff:          e.Diff,
+		Images:        e.Images,
+		Version:       e.Version,
+	}
+	switch e.Type {
+	case EventTaskStarted:
+		p.Started = &e.Time
+	case EventTaskSucceeded, EventTaskFailed, EventTaskNoop:
+		started := e.Time.Add(-e.Duration)
+		p.Started, p.Finished = &started, &e.Time
+	}
+	return p
+}
+
+func (w *Webhook) run() {
+	for p := range w.queue {
+		body, err := json.Marshal(p)
+		if err != nil {
+			zap.L().Error("failed to encode webhook event", zap.Error(err))
+			continue
+		}
+		header := make(http.Header)
+		if len(w.secret) > 0 {
+			header.Set(SignatureHeader, Sign(w.secret, body))
+		}
+		for _, url := range w.urls {
+			if err := w.poster.post(url, body, header); err != nil {
+				// the dead letter log, the event is dropped for this URL
+				zap.L().Error("failed to deliver webhook event, dropping it",
+					zap.String("url", httpclient.RedactURL(url)),
+					zap.String("type", string(p.Type)),
+					zap.String("event", redact.String(string(body))),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+// Sign returns the signature of a webhook body, as sent in SignatureHeader
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body) //nolint:errcheck
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,100 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWebhook(t *testing.T) {
+	var calls int32
+	received := make(chan WebhookPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, Sign([]byte("s3cret"), body), r.Header.Get(SignatureHeader))
+		var p WebhookPayload
+		assert.NoError(t, json.Unmarshal(body, &p))
+		received <- p
+	}))
+	defer srv.Close()
+
+	w := NewWebhook([]string{srv.URL}, "s3cret", nil)
+	w.poster.retrier = retrier.New(retrier.ExponentialBackoff(3, time.Millisecond), retryable{})
+
+	finished := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, w.Notify(Event{
+		Type:     EventTaskSucceeded,
+		Time:     finished,
+		Message:  "app deployed abc123",
+		Target:   "app",
+		Labels:   map[string]string{"team": "payments"},
+		Commit:   "abc123",
+		Duration: time.Minute,
+		Output:   "never sent",
+		Version:  "1.0.0",
+	}))
+
+	select {
+	case p := <-received:
+		started := finished.Add(-time.Minute)
+		assert.Equal(t, WebhookPayload{
+			SchemaVersion: WebhookSchemaVersion,
+			Type:          EventTaskSucceeded,
+			Time:          finished,
+			Message:       "app deployed abc123",
+			Target:        "app",
+			Labels:        map[string]string{"team": "payments"},
+			Commit:        "abc123",
+			Status:        "success",
+			Started:       &started,
+			Finished:      &finished,
+			Version:       "1.0.0",
+		}, p)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t,
+		"sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		Sign([]byte("key"), []byte("The quick brown fox jumps over the lazy dog")))
+}
+
+func TestWebhookFailureLog(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	w := NewWebhook([]string{srv.URL + "/services/T000/B000/XXXX?token=s3cret"}, "", nil)
+	w.poster.retrier = retrier.New(retrier.ExponentialBackoff(1, time.Millisecond), retryable{})
+	require.NoError(t, w.Notify(Event{Type: EventTaskFailed, Target: "app"}))
+
+	require.Eventually(t, func() bool { return logs.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+	entry := logs.All()[0]
+	assert.Equal(t, srv.URL+"/[REDACTED]", entry.ContextMap()["url"])
+	for _, v := range entry.ContextMap() {
+		assert.NotContains(t, v, "XXXX")
+		assert.NotContains(t, v, "s3cret")
+	}
+}
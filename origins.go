@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/picostack/pico/service"
+)
+
+// configFlags maps the fields of service.Config to the flags of the run command
+// they're read from, fields not listed are derived by Pico.
+var configFlags = map[string]string{
+	"Target.Branch":   "config-branch",
+	"Target.User":     "git-username",
+	"Target.Pass":     "git-password",
+	"Target.Token":    "git-token",
+	"Hostname":        "hostname",
+	"ConfigEnv":       "config-env",
+	"SSH":             "ssh",
+	"GitAuth":         "git-auth",
+	"Netrc":           "netrc",
+	"Directory":       "directory",
+	"PassEnvironment": "pass-env",
+	"CheckInterval":   "check-interval",
+	"GitTimeout":      "git-timeout",
+	"ConfigStale":     "config-stale-after",
+	"SecretBackend":   "secret-backend",
+	"VaultAddress":    "vault-addr",
+	"VaultToken":      "vault-token",
+	"VaultWrapped":    "vault-token-wrapped",
+	"VaultAuth":       "vault-auth-method",
+	"VaultAWSRole":    "vault-aws-role",
+	"VaultAWSHeader":  "vault-aws-header-value",
+	"VaultPath":       "vault-path",
+	"VaultRenewal":    "vault-renew-interval",
+	"VaultConfig":     "vault-config-path",
+	"AzureVaultURI":   "azure-keyvault-uri",
+	"GCPProject":      "gcp-project",
+	"GCPSecretPrefix": "gcp-secret-prefix",
+	"KubeSecrets":     "kube-secrets",
+	"KubeNamespace":   "kube-namespace",
+	"KubeWatch":       "kube-watch",
+	"SecretsDir":      "secrets-directory",
+	"SSMRegion":       "ssm-region",
+	"SSMPrefix":       "ssm-prefix",
+	"SecretCacheKey":  "secret-cache-key",
+	"AllowStale":      "allow-stale-secrets",
+	"Secrets":         "secret",
+	"StrictConfig":    "strict-config",
+	"RequireSecrets":  "require-secrets",
+	"InterpolateCmds": "interpolate-commands",
+	"StrictEnv":       "strict-env",
+	"Standby":         "standby",
+	"ActivateFile":    "activate-file",
+	"LeaderElection":  "leader-election",
+	"LeaderKey":       "leader-key",
+	"LeaderTTL":       "leader-ttl",
+	"AdminAddress":    "admin-address",
+	"DebugAddress":    "debug-address",
+	"MetricLabels":    "metric-labels",
+	"PushGateway":     "pushgateway-url",
+	"PushJob":         "pushgateway-job",
+	"PushGrouping":    "pushgateway-grouping",
+	"HTTPTimeout":     "http-timeout",
+	"HTTPProxy":       "http-proxy",
+	"HTTPCABundle":    "http-ca-bundle",
+	"GCInterval":      "gc-interval",
+	"GCThreshold":     "gc-threshold",
+	"MaxDataSize":     "max-data-size",
+	"MinFreeSpace":    "min-free-space",
+	"MinFreePercent":  "min-free-percent",
+	"Worktrees":       "worktrees",
+	"HistorySize":     "history-size",
+	"PersistHistory":  "persist-history",
+	"StateMaxRecords": "state-max-records",
+	"StateMaxAge":     "state-max-age",
+	"StateMaxSize":    "state-max-size",
+	"MaxOutput":       "max-output",
+	"PruneImages":     "prune-images",
+	"PruneInterval":   "prune-interval",
+	"PruneUntil":      "prune-until",
+	"RemoveOrphans":   "remove-orphans",
+	"RunAs":           "run-as",
+	"CancelReconfig":  "cancel-on-reconfigure",
+	"StartupParallel": "startup-parallelism",
+	"WebhookAddress":  "webhook-address",
+	"NotifyCommand":   "notify-command",
+	"SMTP.Host":       "smtp-host",
+	"SMTP.Port":       "smtp-port",
+	"SMTP.TLS":        "smtp-tls",
+	"SMTP.From":       "smtp-from",
+	"SMTP.To":         "smtp-to",
+	"SMTP.Subject":    "smtp-subject",
+	"DiscordWebhook":  "discord-webhook",
+	"GrafanaURL":      "grafana-url",
+	"WebhookURLs":     "webhook-url",
+}
+
+// configOrigins returns where the value of each configuration field came from
+func configOrigins(c *cli.Context) map[string]string {
+	envVars := make(map[string]string)
+	for _, f := range c.Command.Flags {
+		if env := flagEnvVar(f); env != "" {
+			envVars[f.GetName()] = env
+		}
+	}
+
+	origins := map[string]string{
+		"Target.URL": service.OriginFlag,
+		"Sources":    service.OriginFlag,
+	}
+	for field, name := range configFlags {
+		origins[field] = flagOrigin(name, envVars[name])
+	}
+	if origins["Hostname"] == service.OriginDefault {
+		origins["Hostname"] = service.OriginDerived
+	}
+	return origins
+}
+
+// flagOrigin reports whether a flag was set on the command line, which takes
+// precedence, by its environment variable or neither.
+func flagOrigin(name, env string) string {
+	for _, arg := range os.Args[1:] {
+		if arg == "--" {
+			break
+		}
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name || strings.HasPrefix(arg, name+"=") {
+			return service.OriginFlag
+		}
+	}
+	if env != "" {
+		if _, ok := os.LookupEnv(env); ok {
+			return service.OriginEnv
+		}
+	}
+	return service.OriginDefault
+}
+
+func flagEnvVar(f cli.Flag) string {
+	switch f := f.(type) {
+	case cli.StringFlag:
+		return f.EnvVar
+	case cli.BoolFlag:
+		return f.EnvVar
+	case cli.IntFlag:
+		return f.EnvVar
+	case cli.DurationFlag:
+		return f.EnvVar
+	case cli.StringSliceFlag:
+		return f.EnvVar
+	}
+	return ""
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+// planChangesPending is the exit status of pico plan when applying the
+// configuration would change targets, so CI can tell it from an error.
+const planChangesPending = 2
+
+// planOptions are what pico plan compares
+type planOptions struct {
+	config   string // the candidate, a directory or a revision of repo
+	baseline string // as for config, the instance's targets when empty
+	repo     string // the configuration repository revisions are read from
+	source   string // only compare with the instance's targets of this source
+	root     string // the data directory of a baseline that isn't an instance
+	builtins config.Builtins
+	client   *api.Client
+}
+
+// planConfig prints what applying a candidate configuration in place of the
+// baseline would do to each target, without cloning or executing anything. It
+// returns an error with the planChangesPending exit status if any target
+// would change.
+func planConfig(o planOptions) error {
+	candidate, err := loadConfig(o.config, o.repo, o.builtins)
+	if err != nil {
+		return errors.Wrap(err, "failed to load the configuration")
+	}
+
+	var baseline, others task.Targets
+	layout := task.Layout{Root: o.root}
+	if o.baseline != "" {
+		if baseline, err = loadConfig(o.baseline, o.repo, o.builtins); err != nil {
+			return errors.Wrap(err, "failed to load the baseline configuration")
+		}
+		task.ShareClones(baseline)
+		fmt.Printf("comparing %s with %s\n\n", o.config, o.baseline)
+	} else {
+		var passEnv bool
+		baseline, others, layout, passEnv, err = instanceTargets(o.client, o.source)
+		if err != nil {
+			return err
+		}
+		if candidate, err = asReported(candidate); err != nil {
+			return err
+		}
+		for i := range candidate {
+			if candidate[i].PassEnvironment == nil {
+				// the instance reports the effective value, not that it's unset
+				candidate[i].PassEnvironment = &passEnv
+			}
+		}
+		fmt.Printf("comparing %s with the targets applied by the instance\n\n", o.config)
+	}
+
+	// the candidate shares clones with the instance's targets of other sources
+	merged := append(append(task.Targets{}, others...), candidate...)
+	task.ShareClones(merged)
+	candidate = merged[len(others):]
+
+	changes := task.PlanChanges(baseline, candidate, layout)
+	if len(changes) == 0 {
+		fmt.Println("No changes, the targets match the configuration.")
+		return nil
+	}
+	if err := printPlan(changes); err != nil {
+		return err
+	}
+	return cli.NewExitError("", planChangesPending)
+}
+
+// loadConfig constructs the targets from a directory of configuration files
+// or, if spec isn't one, from the files of the revision of repo it names.
+func loadConfig(spec, repo string, builtins config.Builtins) (task.Targets, error) {
+	dir := spec
+	if info, err := os.Stat(spec); err != nil || !info.IsDir() {
+		commit, err := task.ResolveRevision(repo, spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s is neither a directory nor a revision of %s", spec, repo)
+		}
+		if dir, err = ioutil.TempDir("", "pico-plan"); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		if _, err := task.WriteTree(repo, commit, dir, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	state, _, err := config.ConfigFromDirectory(dir, builtins)
+	if err != nil {
+		return nil, err
+	}
+	if err := task.ValidateTargets(state.Targets); err != nil {
+		return nil, err
+	}
+	return state.Targets, nil
+}
+
+// instanceTargets returns the targets applied by a running instance, split
+// into those of the source and those of all others if a source is given, the
+// layout of its data directory and whether it passes its environment to
+// targets by default.
+func instanceTargets(c *api.Client, source string) (targets, others task.Targets, layout task.Layout, passEnv bool, err error) {
+	if c == nil {
+		err = errors.New("missing --baseline or --admin-address to compare the configuration with")
+		return
+	}
+	s, err := c.Status()
+	if err != nil {
+		return
+	}
+	fields, err := c.Config()
+	if err != nil {
+		return
+	}
+	for _, f := range fields {
+		switch f.Name {
+		case "Directory":
+			layout.Root = f.Value
+		case "PassEnvironment":
+			passEnv = f.Value == "true"
+		}
+	}
+	for _, ts := range s.Targets {
+		if source != "" && ts.Definition.Source != source {
+			others = append(others, ts.Definition)
+			continue
+		}
+		targets = append(targets, ts.Definition)
+	}
+	return
+}
+
+// asReported returns the targets as the status of an instance reports them,
+// after a round trip through JSON, so empty and absent fields compare equal.
+func asReported(targets task.Targets) (task.Targets, error) {
+	b, err := json.Marshal(targets)
+	if err != nil {
+		return nil, err
+	}
+	var reported task.Targets
+	return reported, json.Unmarshal(b, &reported)
+}
+
+// printPlan prints the changes like a terraform plan, one line per target
+func printPlan(changes []task.PlannedChange) error {
+	symbols := map[string]string{task.ChangeAdd: "+", task.ChangeModify: "~", task.ChangeRemove: "-"}
+	counts := make(map[string]int)
+	var executed int
+
+	fmt.Println("Pico will perform the following actions:")
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, c := range changes {
+		counts[c.Action]++
+		if c.Executes {
+			executed++
+		}
+		fmt.Fprintf(w, "  %s %s\t%s\n", symbols[c.Action], c.Target, describeChange(c))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nPlan: %d to add, %d to change, %d to remove, %d tasks to execute.\n",
+		counts[task.ChangeAdd], counts[task.ChangeModify], counts[task.ChangeRemove], executed)
+	return nil
+}
+
+func describeChange(c task.PlannedChange) string {
+	var what string
+	switch {
+	case c.Action == task.ChangeAdd && c.Executes:
+		what = "deploy"
+	case c.Action == task.ChangeAdd:
+		what = "add without deploying"
+	case c.Action == task.ChangeModify && c.Executes:
+		what = "redeploy"
+	case c.Action == task.ChangeModify:
+		what = "change without redeploying"
+	case c.Executes:
+		what = "run down command"
+	default:
+		what = "remove without running down command"
+	}
+	parts := []string{what}
+	if len(c.Fields) > 0 {
+		parts[0] += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+	if c.Note != "" {
+		parts = append(parts, c.Note)
+	}
+	if c.Cloned {
+		parts = append(parts, "clone into "+c.Clone)
+	}
+	if c.Unused != "" {
+		parts = append(parts, "leave "+c.Unused+" unused")
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,65 @@
+package reconfigurer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	"github.com/picostack/pico/watcher"
+)
+
+// switchBranch checks out the configured branch in an existing checkout of the
+// configuration repository that has another branch checked out, such as after
+// --config-branch was changed. A missing checkout is cloned on the configured
+// branch by watchConfig instead.
+func (p *GitProvider) switchBranch() error {
+	if p.branch == "" {
+		return nil
+	}
+	repo, err := git.PlainOpen(p.Directory())
+	if err == git.ErrRepositoryNotExists {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to open configuration repository")
+	}
+
+	local := plumbing.NewBranchReferenceName(p.branch)
+	var current string
+	if head, err := repo.Head(); err == nil {
+		if head.Name() == local {
+			return nil
+		}
+		current = head.Name().Short()
+	}
+	zap.L().Info("switching branch of configuration repository",
+		zap.String("repo", p.configRepo),
+		zap.String("from", current),
+		zap.String("to", p.branch))
+
+	remote := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, p.branch)
+	err = watcher.Fetch(context.Background(), p.gitTimeout, repo, p.configRepo, &git.FetchOptions{
+		RefSpecs: []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("+%s:%s", local, remote))},
+		Auth:     p.authMethod,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrapf(err, "failed to fetch branch %s of configuration repository", p.branch)
+	}
+	ref, err := repo.Reference(remote, true)
+	if err != nil {
+		return errors.Wrapf(err, "configuration repository has no branch %s", p.branch)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(local, ref.Hash())); err != nil {
+		return errors.Wrapf(err, "failed to create branch %s", p.branch)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return errors.Wrap(err, "failed to get worktree")
+	}
+	return errors.Wrapf(wt.Checkout(&git.CheckoutOptions{Branch: local, Force: true}),
+		"failed to check out branch %s", p.branch)
+}
@@ -0,0 +1,79 @@
+package reconfigurer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func TestSwitchBranch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-branch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the origin has a staging branch with a commit master doesn't have
+	origin := filepath.Join(dir, "remote", "config")
+	repo, err := git.PlainInit(origin, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(content string) plumbing.Hash {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(origin, "targets.js"), []byte(content), 0600))
+		_, err := wt.Add("targets.js")
+		require.NoError(t, err)
+		hash, err := wt.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		return hash
+	}
+	commit(`T({name: "main", url: "../a", up: ["true"]})`)
+	require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("staging"), Create: true}))
+	staging := commit(`T({name: "staging", url: "../a", up: ["true"]})`)
+	require.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("master")}))
+
+	data := filepath.Join(dir, "data")
+	_, err = git.PlainClone(filepath.Join(data, "config"), false, &git.CloneOptions{URL: origin})
+	require.NoError(t, err)
+
+	p := New(data, config.Builtins{}, origin, time.Second, nil, false, nil)
+	p.SetBranch("staging")
+	var observed []Revision
+	p.SetRevisionObserver(func(r Revision) { observed = append(observed, r) })
+	assert.Equal(t, "master", p.Branch())
+
+	require.NoError(t, p.switchBranch())
+	assert.Equal(t, "staging", p.Branch())
+	assert.Equal(t, staging.String(), task.HeadCommit(p.Directory()))
+
+	state, ok, err := p.getState()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "staging", state.Targets[0].Name)
+	assert.Equal(t, staging.String(), p.Applied())
+	assert.Equal(t, "test <test@example.com>", p.Revision().Author)
+	assert.False(t, p.Revision().Time.IsZero())
+	assert.Equal(t, []Revision{p.Revision()}, observed)
+
+	// the same revision isn't observed again
+	_, _, err = p.getState()
+	require.NoError(t, err)
+	assert.Len(t, observed, 1)
+
+	// switching again is a no-op
+	require.NoError(t, p.switchBranch())
+
+	p.SetBranch("missing")
+	assert.Error(t, p.switchBranch())
+}
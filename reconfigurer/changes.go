@@ -0,0 +1,78 @@
+package reconfigurer
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// TargetSetChange describes a change to the targets a provider has applied,
+// Targets is the complete set of targets after the change.
+type TargetSetChange struct {
+	task.TargetsDiff
+	Targets []task.Target
+	Time    time.Time
+}
+
+// targetSet holds the targets a provider last applied and delivers changes to
+// them to subscribers. Changes are delivered without blocking, so a subscriber
+// whose channel is full misses the change rather than holding up the provider.
+type targetSet struct {
+	mu          sync.Mutex
+	targets     []task.Target
+	subscribers []chan<- TargetSetChange
+}
+
+// get returns a copy of the applied targets
+func (s *targetSet) get() []task.Target {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyTargets(s.targets)
+}
+
+func (s *targetSet) subscribe(ch chan<- TargetSetChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, ch)
+}
+
+// apply records the targets as applied and returns how they differ from the
+// previously applied targets, subscribers are sent the change if there is one.
+func (s *targetSet) apply(targets []task.Target) task.TargetsDiff {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	diff := task.CompareTargets(s.targets, targets)
+	s.targets = copyTargets(targets)
+	if diff.Empty() {
+		return diff
+	}
+	change := TargetSetChange{
+		TargetsDiff: diff,
+		Time:        time.Now(),
+	}
+	for _, ch := range s.subscribers {
+		change.Targets = copyTargets(s.targets)
+		select {
+		case ch <- change:
+		default:
+			zap.L().Warn("dropped target change for a subscriber that isn't keeping up",
+				zap.Strings("added", diff.Added),
+				zap.Strings("removed", diff.Removed),
+				zap.Any("modified", diff.Modified))
+		}
+	}
+	return diff
+}
+
+// copyTargets copies the list of targets, the targets themselves still share
+// their slices and maps with the state they came from and must not be modified.
+func copyTargets(targets []task.Target) []task.Target {
+	if targets == nil {
+		return nil
+	}
+	return append(make([]task.Target, 0, len(targets)), targets...)
+}
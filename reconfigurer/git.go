@@ -2,15 +2,23 @@ package reconfigurer
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Southclaws/gitwatch"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 
 	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/task"
 	"github.com/picostack/pico/watcher"
 )
 
@@ -20,50 +28,239 @@ var _ Provider = &GitProvider{}
 // watcher process upon commits to its defined configuration repository.
 type GitProvider struct {
 	directory     string
-	hostname      string
+	builtins      config.Builtins
 	configRepo    string
+	branch        string
 	checkInterval time.Duration
 	authMethod    transport.AuthMethod
+	strict        bool
+	notifier      notifier.Notifier
+	gitTimeout    time.Duration
+	staleAfter    time.Duration
+	observe       func(Revision) // called with every newly applied revision
 
-	configWatcher *gitwatch.Session
+	check   chan struct{}
+	changes targetSet
+
+	mu            sync.Mutex
+	lastGood      *config.State
+	applied       Revision // revision of the last good state
+	revisionError *RevisionError
+	unknown       string    // unknown keys last warned about
+	suspicious    string    // command warnings last logged
+	contact       time.Time // the last successful check of the repository
+	stale         bool
+	logged        time.Time // when a failed check was last logged while stale
+	updated       time.Time // when the watcher's state was last changed
+	updatedBy     string    // the kind of check that changed it, an Update constant
+}
+
+// Revision is a commit of the configuration repository
+type Revision struct {
+	Commit string
+	Author string
+	Time   time.Time // when it was committed
+}
+
+// Kinds of check that update the configuration
+const (
+	UpdateStartup = "startup" // the initial clone or pull
+	UpdatePoll    = "poll"    // the check at every interval
+	UpdateWebhook = "webhook" // a check requested with Check
+)
+
+// DefaultStaleAfter is how long the configuration repository may be unreachable
+// before the configuration is considered stale.
+const DefaultStaleAfter = time.Hour
+
+// staleLogInterval is how often failed checks are logged while the
+// configuration is stale, rather than on every check.
+const staleLogInterval = time.Minute * 10
+
+// RevisionError describes a revision of the configuration repository that
+// could not be applied.
+type RevisionError struct {
+	Commit string    `json:"commit"`
+	File   string    `json:"file,omitempty"`
+	Err    error     `json:"-"`
+	Time   time.Time `json:"time"`
+}
+
+// ErrInvalidConfig is matched by errors caused by a configuration revision that
+// could not be evaluated or validated.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+func (e *RevisionError) Error() string {
+	return fmt.Sprintf("configuration revision %s is invalid: %v", e.Commit, e.Err)
+}
+
+// Unwrap returns the reason the revision is invalid
+func (e *RevisionError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrInvalidConfig
+func (e *RevisionError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// invalidError marks an error as ErrInvalidConfig without changing its message
+type invalidError struct{ error }
+
+func (e invalidError) Unwrap() error {
+	return e.error
+}
+
+func (e invalidError) Is(target error) bool {
+	return target == ErrInvalidConfig
 }
 
 // New creates a new provider with all necessary parameters
 func New(
 	directory string,
-	hostname string,
+	builtins config.Builtins,
 	configRepo string,
 	checkInterval time.Duration,
 	authMethod transport.AuthMethod,
+	strict bool,
+	n notifier.Notifier,
 ) *GitProvider {
 	return &GitProvider{
 		directory:     directory,
-		hostname:      hostname,
+		builtins:      builtins,
 		configRepo:    configRepo,
 		checkInterval: checkInterval,
 		authMethod:    authMethod,
+		strict:        strict,
+		notifier:      n,
+		gitTimeout:    watcher.DefaultGitTimeout,
+		staleAfter:    DefaultStaleAfter,
+		check:         make(chan struct{}, 1),
 	}
 }
 
+// SetBranch sets the branch of the configuration repository to check out, the
+// remote's default branch is used when empty. It must be called before
+// Configure.
+func (p *GitProvider) SetBranch(branch string) {
+	p.branch = branch
+}
+
+// SetGitTimeout sets how long every clone and fetch of the configuration
+// repository may take before it's abandoned, zero disables the timeout. It
+// must be called before Configure.
+func (p *GitProvider) SetGitTimeout(timeout time.Duration) {
+	p.gitTimeout = timeout
+}
+
+// SetStaleAfter sets how long the configuration repository may go without a
+// successful check before the configuration is reported as stale, zero never
+// reports it. It must be called before Configure.
+func (p *GitProvider) SetStaleAfter(d time.Duration) {
+	p.staleAfter = d
+}
+
+// SetRevisionObserver sets a function called with every revision of the
+// configuration repository that's applied, such as for metrics. It must be
+// called before Configure.
+func (p *GitProvider) SetRevisionObserver(f func(Revision)) {
+	p.observe = f
+}
+
+// Branch returns the branch of the configuration repository that's checked
+// out, or the configured branch if there's no checkout yet.
+func (p *GitProvider) Branch() string {
+	if b := task.HeadBranch(p.Directory()); b != "" {
+		return b
+	}
+	return p.branch
+}
+
 // Configure implements Provider
 func (p *GitProvider) Configure(w watcher.Watcher) error {
 	if err := p.reconfigure(w); err != nil {
 		return err
 	}
 
-	for range p.configWatcher.Events {
-		if err := p.reconfigure(w); err != nil {
-			return err
+	// configuration may depend on builtins, such as the environment, so it's
+	// re-evaluated on every check even when the repository has not changed.
+	evaluate := time.NewTicker(p.checkInterval)
+	defer evaluate.Stop()
+
+	for {
+		select {
+		case <-evaluate.C:
+			p.pull()
+			if err := p.reevaluate(w, UpdatePoll); err != nil {
+				return err
+			}
+
+		case <-p.check:
+			if err := p.fetch(w, UpdateWebhook); err != nil {
+				return err
+			}
 		}
 	}
+}
 
-	return nil
+// Check makes the provider fetch the configuration repository now rather than
+// at its next interval, such as when a webhook reports a push.
+func (p *GitProvider) Check() {
+	select {
+	case p.check <- struct{}{}:
+	default:
+	}
 }
 
-// reconfigure will close the configuration watcher (unless it's the first run)
-// then create a watcher for the application's config target repo then wait for
-// the first event (either from a fresh clone, a pull, or just a noop event)
-// then update the state of the watcher it's in charge of.
+// fetch pulls the configuration checkout and re-evaluates it if it changed
+func (p *GitProvider) fetch(w watcher.Watcher, by string) error {
+	if !p.pull() {
+		return nil
+	}
+	return p.reevaluate(w, by)
+}
+
+// pull pulls the configuration checkout and reports whether it changed, a
+// failed pull is logged as the next interval will try again.
+func (p *GitProvider) pull() bool {
+	repo, err := git.PlainOpen(p.Directory())
+	if err != nil {
+		p.checkFailed("failed to open configuration repository", err)
+		return false
+	}
+	event, err := watcher.Pull(context.Background(), p.gitTimeout, repo, p.configRepo, p.branch, p.authMethod)
+	if err != nil && !errors.Is(err, io.EOF) {
+		p.checkFailed("failed to fetch configuration repository", err)
+		return false
+	}
+	p.contacted()
+	if event == nil {
+		return false
+	}
+	zap.L().Info("configuration repository changed", zap.String("repo", p.configRepo))
+	return true
+}
+
+// reevaluate constructs the desired state from the existing config checkout and
+// only updates the watcher if the state differs from its current state.
+func (p *GitProvider) reevaluate(w watcher.Watcher, by string) error {
+	current := w.GetState()
+	state, ok, err := p.getState()
+	if err != nil || !ok {
+		return err
+	}
+	if reflect.DeepEqual(state, current) {
+		return nil
+	}
+
+	zap.L().Info("configuration evaluated to a new state",
+		zap.String("updated_by", by))
+
+	return p.setState(w, state, by)
+}
+
+// reconfigure clones or pulls the application's config target repo then
+// updates the state of the watcher it's in charge of.
 func (p *GitProvider) reconfigure(w watcher.Watcher) (err error) {
 	zap.L().Debug("reconfiguring")
 
@@ -71,90 +268,374 @@ func (p *GitProvider) reconfigure(w watcher.Watcher) (err error) {
 	if err != nil {
 		return
 	}
+	p.contacted()
 
-	// generate a new desired state from the config repo
-	path, err := gitwatch.GetRepoDirectory(p.configRepo)
-	if err != nil {
+	state, ok, err := p.getState()
+	if err != nil || !ok {
 		return
 	}
-	state := getNewState(
-		filepath.Join(p.directory, path),
-		p.hostname,
-		w.GetState(),
-	)
-
-	// Set the HOSTNAME config environment variable if necessary.
-	if p.hostname != "" {
-		state.Env["HOSTNAME"] = p.hostname
-	}
 
 	zap.L().Debug("setting state for watcher",
 		zap.Any("new_state", state))
 
-	return w.SetState(state)
+	return p.setState(w, state, UpdateStartup)
 }
 
-// watchConfig creates or restarts the watcher that reacts to changes to the
-// repo that contains pico configuration scripts
-func (p *GitProvider) watchConfig() (err error) {
-	if p.configWatcher != nil {
-		zap.L().Debug("closing existing watcher")
-		p.configWatcher.Close()
-	}
-
-	p.configWatcher, err = gitwatch.New(
-		context.TODO(),
-		[]gitwatch.Repository{{URL: p.configRepo}},
-		p.checkInterval,
-		p.directory,
-		p.authMethod,
-		false)
+// setState sets the state of the watcher and records its targets as applied
+// and the kind of check that updated them.
+func (p *GitProvider) setState(w watcher.Watcher, state config.State, by string) error {
+	if err := w.SetState(state); err != nil {
+		return err
+	}
+	p.changes.apply(state.Targets)
+
+	p.mu.Lock()
+	p.updated = time.Now()
+	p.updatedBy = by
+	p.mu.Unlock()
+	return nil
+}
+
+// LastUpdate returns when the configuration last changed the watcher's state
+// and the kind of check that changed it, zero and empty until the first.
+func (p *GitProvider) LastUpdate() (time.Time, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.updated, p.updatedBy
+}
+
+// Targets implements Provider
+func (p *GitProvider) Targets() []task.Target {
+	return p.changes.get()
+}
+
+// SubscribeChanges implements Provider
+func (p *GitProvider) SubscribeChanges(ch chan<- TargetSetChange) {
+	p.changes.subscribe(ch)
+}
+
+// getState generates a new desired state from the config repo checkout. If the
+// configuration is invalid, the last known good state is returned instead and
+// the bad revision is reported. If there is no good state yet, ok is false.
+// Under strict mode, an invalid configuration is returned as an error instead.
+func (p *GitProvider) getState() (state config.State, ok bool, err error) {
+	dir, err := gitwatch.GetRepoDirectory(p.configRepo)
 	if err != nil {
-		return errors.Wrap(err, "failed to watch config target")
+		return
 	}
+	path := filepath.Join(p.directory, dir)
 
-	errs := make(chan error)
-	go func() {
-		e := p.configWatcher.Run()
-		if e != nil && !errors.Is(e, context.Canceled) {
-			errs <- e
+	state, unknown, err := config.ConfigFromDirectory(path, p.builtins)
+	if err == nil {
+		if p.strict && len(unknown) > 0 {
+			err = &config.UnknownKeysError{Keys: unknown}
+		} else {
+			p.warnUnknown(unknown)
 		}
-		// TODO: forward these errors elsewhere.
-		for e = range p.configWatcher.Errors {
-			zap.L().Error("config watcher error occurred", zap.Error(e))
+		p.warnCommands(task.CommandWarnings(state.Targets))
+	}
+	if err != nil {
+		p.setRevisionError(path, err)
+		if p.strict {
+			return state, false, errors.Wrap(invalidError{err}, "invalid configuration in strict mode")
 		}
-	}()
-	zap.L().Debug("created new config watcher, awaiting setup")
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.lastGood == nil {
+			return config.State{}, false, nil
+		}
+		return *p.lastGood, true, nil
+	}
+	p.clearRevisionError()
 
-	err = p.__waitpoint__watch_config(errs)
+	zap.L().Debug("constructed desired state",
+		zap.Int("targets", len(state.Targets)))
 
-	zap.L().Debug("config watcher initialised")
+	// Set the HOSTNAME config environment variable if necessary.
+	if p.builtins.Hostname != "" {
+		if state.Env == nil {
+			state.Env = make(map[string]string)
+		}
+		state.Env["HOSTNAME"] = p.builtins.Hostname
+	}
 
-	return
+	commit := task.HeadCommit(path)
+	p.mu.Lock()
+	p.lastGood = &state
+	previous := p.applied
+	if commit != previous.Commit {
+		p.applied = Revision{
+			Commit: commit,
+			Author: task.CommitAuthor(path, commit),
+			Time:   task.CommitTime(path, commit),
+		}
+	}
+	applied := p.applied
+	p.mu.Unlock()
+
+	if applied.Commit != previous.Commit {
+		zap.L().Info("applied configuration revision",
+			zap.String("repo", p.configRepo),
+			zap.String("commit", applied.Commit),
+			zap.String("author", applied.Author),
+			zap.Time("committed", applied.Time))
+		if p.observe != nil {
+			p.observe(applied)
+		}
+	}
+	return state, true, nil
 }
 
-func (p *GitProvider) __waitpoint__watch_config(errs chan error) (err error) {
-	select {
-	case <-p.configWatcher.InitialDone:
-	case err = <-errs:
+// Directory returns the path of the configuration repository checkout, or an
+// empty string if it can't be derived from the repository URL.
+func (p *GitProvider) Directory() string {
+	dir, err := gitwatch.GetRepoDirectory(p.configRepo)
+	if err != nil {
+		return ""
 	}
-	return
+	return filepath.Join(p.directory, dir)
+}
+
+// Applied returns the commit of the configuration repository that the current
+// state was constructed from, empty until a valid state has been constructed.
+func (p *GitProvider) Applied() string {
+	return p.Revision().Commit
+}
+
+// Revision returns the revision of the configuration repository that the
+// current state was constructed from, with an empty commit until a valid
+// state has been constructed.
+func (p *GitProvider) Revision() Revision {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.applied
+}
+
+// RevisionError returns the failure of the most recent configuration revision
+// or nil if the currently applied configuration is from the latest revision.
+func (p *GitProvider) RevisionError() *RevisionError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.revisionError
+}
+
+func (p *GitProvider) setRevisionError(path string, err error) {
+	re := &RevisionError{
+		Commit: task.HeadCommit(path),
+		Err:    err,
+		Time:   time.Now(),
+	}
+	var se *config.ScriptError
+	if errors.As(err, &se) {
+		re.File = se.File
+	}
+
+	p.mu.Lock()
+	previous := p.revisionError
+	p.revisionError = re
+	p.mu.Unlock()
+
+	// a revision is only reported once, it's re-evaluated on every check.
+	if previous != nil && previous.Commit == re.Commit && previous.Err.Error() == re.Err.Error() {
+		zap.L().Debug("configuration revision still invalid", zap.String("commit", re.Commit))
+		return
+	}
+
+	zap.L().Error("failed to construct config from repo, keeping last known good configuration",
+		zap.String("repo", p.configRepo),
+		zap.String("commit", re.Commit),
+		zap.String("file", re.File),
+		zap.Error(err))
+
+	p.notify(notifier.Event{
+		Type:    notifier.EventConfigInvalid,
+		Time:    re.Time,
+		Message: re.Error(),
+	})
+}
+
+// warnUnknown logs the unknown keys of target definitions, they're only logged
+// again once they change since the configuration is re-evaluated on every check.
+func (p *GitProvider) warnUnknown(unknown []config.UnknownKey) {
+	summary := (&config.UnknownKeysError{Keys: unknown}).Error()
+	p.mu.Lock()
+	previous := p.unknown
+	p.unknown = summary
+	p.mu.Unlock()
+	if previous == summary {
+		return
+	}
+	for _, u := range unknown {
+		zap.L().Warn("unknown key in target definition",
+			zap.String("repo", p.configRepo),
+			zap.String("target", u.Target),
+			zap.String("key", u.Key),
+			zap.String("suggestion", u.Suggestion))
+	}
+}
+
+// warnCommands logs the target commands that look like mistakes, only once
+// they change like unknown keys.
+func (p *GitProvider) warnCommands(warnings []string) {
+	summary := strings.Join(warnings, "\n")
+	p.mu.Lock()
+	previous := p.suspicious
+	p.suspicious = summary
+	p.mu.Unlock()
+	if previous == summary {
+		return
+	}
+	for _, w := range warnings {
+		zap.L().Warn("suspicious target command",
+			zap.String("repo", p.configRepo),
+			zap.String("warning", w))
+	}
+}
+
+func (p *GitProvider) clearRevisionError() {
+	p.mu.Lock()
+	previous := p.revisionError
+	p.revisionError = nil
+	p.mu.Unlock()
+
+	if previous == nil {
+		return
+	}
+
+	zap.L().Info("configuration is valid again",
+		zap.String("repo", p.configRepo),
+		zap.String("previous_commit", previous.Commit))
+
+	p.notify(notifier.Event{
+		Type:    notifier.EventConfigRecovered,
+		Time:    time.Now(),
+		Message: fmt.Sprintf("configuration from %s is valid again", p.configRepo),
+	})
 }
 
-// getNewState attempts to obtain a new desired state from the given path, if
-// any failures occur, it simply returns a fallback state and logs an error
-func getNewState(path, hostname string, fallback config.State) (state config.State) {
-	state, err := config.ConfigFromDirectory(path, hostname)
+// checkFailed logs a failed check of the configuration repository and reports
+// the configuration as stale once there hasn't been a successful check for
+// longer than the stale threshold. Failures are only logged every
+// staleLogInterval while the configuration is stale.
+func (p *GitProvider) checkFailed(msg string, err error) {
+	now := time.Now()
+	p.mu.Lock()
+	contact := p.contact
+	becameStale := !p.stale && p.staleAfter > 0 && !contact.IsZero() && now.Sub(contact) > p.staleAfter
+	if becameStale {
+		p.stale = true
+	}
+	quiet := p.stale && !becameStale && now.Sub(p.logged) < staleLogInterval
+	if !quiet {
+		p.logged = now
+	}
+	p.mu.Unlock()
+
+	if !quiet {
+		zap.L().Warn(msg, zap.String("repo", p.configRepo), zap.Error(err))
+	}
+	if !becameStale {
+		return
+	}
+
+	zap.L().Error("configuration repository unreachable, configuration is stale",
+		zap.String("repo", p.configRepo),
+		zap.Time("last_contact", contact),
+		zap.Error(err))
+
+	p.notify(notifier.Event{
+		Type:    notifier.EventConfigStale,
+		Time:    now,
+		Message: fmt.Sprintf("configuration from %s is stale, the repository hasn't been reachable since %s: %v", p.configRepo, contact.Format(time.RFC3339), err),
+	})
+}
+
+// contacted records a successful check of the configuration repository and
+// reports the recovery if the configuration was stale.
+func (p *GitProvider) contacted() {
+	now := time.Now()
+	p.mu.Lock()
+	previous := p.contact
+	wasStale := p.stale
+	p.contact = now
+	p.stale = false
+	p.mu.Unlock()
+
+	if !wasStale {
+		return
+	}
+
+	zap.L().Info("configuration repository reachable again",
+		zap.String("repo", p.configRepo),
+		zap.Time("last_contact", previous))
+
+	p.notify(notifier.Event{
+		Type:    notifier.EventConfigReachable,
+		Time:    now,
+		Message: fmt.Sprintf("configuration repository %s is reachable again after %s", p.configRepo, now.Sub(previous).Round(time.Second)),
+	})
+}
+
+// LastContact returns when the configuration repository was last checked
+// successfully, zero until the first check.
+func (p *GitProvider) LastContact() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.contact
+}
+
+// Stale reports whether the configuration repository hasn't been reachable for
+// longer than the stale threshold, the applied configuration may be outdated.
+func (p *GitProvider) Stale() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stale
+}
+
+func (p *GitProvider) notify(e notifier.Event) {
+	if p.notifier == nil {
+		return
+	}
+	go func() {
+		if err := p.notifier.Notify(e); err != nil {
+			zap.L().Warn("failed to send notification", zap.Error(err))
+		}
+	}()
+}
+
+// watchConfig clones the repo that contains pico configuration scripts, or
+// pulls it if it's already checked out, before a state is constructed from it.
+func (p *GitProvider) watchConfig() (err error) {
+	if err = p.switchBranch(); err != nil {
+		return
+	}
+
+	dir, err := gitwatch.GetRepoDirectory(p.configRepo)
 	if err != nil {
-		zap.L().Error("failed to construct config from repo, falling back to original state",
-			zap.String("path", path),
-			zap.String("hostname", hostname),
-			zap.Error(err))
+		return errors.Wrap(err, "failed to watch config target")
+	}
 
-		state = fallback
-	} else {
-		zap.L().Debug("constructed desired state",
-			zap.Int("targets", len(state.Targets)))
+	zap.L().Debug("updating config checkout")
+
+	if err = p.__waitpoint__watch_config(filepath.Join(p.directory, dir)); err != nil {
+		return &watcher.GitError{URL: p.configRepo, Err: err}
 	}
+
+	zap.L().Debug("config checkout updated")
+
 	return
 }
+
+func (p *GitProvider) __waitpoint__watch_config(path string) error {
+	repo, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		return watcher.Clone(context.Background(), p.gitTimeout, path, p.configRepo, p.branch, p.authMethod)
+	} else if err != nil {
+		return errors.Wrap(err, "failed to open local repo")
+	}
+	if _, err = watcher.Pull(context.Background(), p.gitTimeout, repo, p.configRepo, p.branch, p.authMethod); errors.Is(err, io.EOF) {
+		// an empty response from the remote, nothing changed
+		return nil
+	}
+	return err
+}
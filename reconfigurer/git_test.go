@@ -0,0 +1,123 @@
+package reconfigurer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/task"
+	"github.com/picostack/pico/watcher"
+)
+
+func TestGitProviderLastKnownGood(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-reconfigurer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "config"), os.ModePerm))
+	file := filepath.Join(dir, "config", "targets.js")
+
+	p := New(dir, config.Builtins{}, "config", time.Second, nil, false, nil)
+
+	// no good configuration yet, nothing should be applied
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`T({`), 0600))
+	_, ok, err := p.getState()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "targets.js", p.RevisionError().File)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`T({name: "a", url: "../a", up: ["true"]})`), 0600))
+	good, ok, err := p.getState()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, good.Targets, 1)
+	assert.Nil(t, p.RevisionError())
+
+	// once applied, the targets are those of the good state
+	assert.Empty(t, p.Targets())
+	_, by := p.LastUpdate()
+	assert.Equal(t, "", by)
+	assert.NoError(t, p.reevaluate(&watcher.MockWatcher{}, UpdateWebhook))
+	assert.Equal(t, good.Targets, task.Targets(p.Targets()))
+	updated, by := p.LastUpdate()
+	assert.Equal(t, UpdateWebhook, by)
+	assert.False(t, updated.IsZero())
+
+	// a broken revision keeps the last good state
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`T({name: "a"}); undefinedFunction();`), 0600))
+	state, ok, err := p.getState()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, good, state)
+	assert.Equal(t, "targets.js", p.RevisionError().File)
+
+	// unless strict mode is enabled
+	p.strict = true
+	_, _, err = p.getState()
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	assert.True(t, errors.Is(p.RevisionError(), ErrInvalidConfig))
+}
+
+func TestGitProviderUnknownKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-reconfigurer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "config"), os.ModePerm))
+	file := filepath.Join(dir, "config", "targets.js")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`T({name: "a", url: "../a", up: ["true"], debunce: "1s"})`), 0600))
+
+	// unknown keys are only warned about
+	p := New(dir, config.Builtins{}, "config", time.Second, nil, false, nil)
+	state, ok, err := p.getState()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, state.Targets, 1)
+
+	// unless strict mode is enabled
+	p = New(dir, config.Builtins{}, "config", time.Second, nil, true, nil)
+	_, _, err = p.getState()
+	assert.EqualError(t, err, "invalid configuration in strict mode: target a: unknown key 'debunce', did you mean 'debounce'?")
+	assert.NotNil(t, p.RevisionError())
+}
+
+type events chan notifier.Event
+
+func (e events) Notify(ev notifier.Event) error {
+	e <- ev
+	return nil
+}
+
+func TestGitProviderStale(t *testing.T) {
+	n := make(events, 4)
+	p := New("", config.Builtins{}, "config", time.Second, nil, false, n)
+	p.SetStaleAfter(time.Hour)
+
+	// failures within the threshold aren't reported
+	p.contacted()
+	p.checkFailed("failed to fetch configuration repository", errors.New("timeout"))
+	assert.False(t, p.Stale())
+
+	p.mu.Lock()
+	p.contact = time.Now().Add(-time.Hour * 2)
+	p.mu.Unlock()
+	p.checkFailed("failed to fetch configuration repository", errors.New("timeout"))
+	assert.True(t, p.Stale())
+	assert.Equal(t, notifier.EventConfigStale, (<-n).Type)
+
+	// only once
+	p.checkFailed("failed to fetch configuration repository", errors.New("timeout"))
+	assert.True(t, p.Stale())
+	assert.Empty(t, n)
+
+	p.contacted()
+	assert.False(t, p.Stale())
+	assert.Equal(t, notifier.EventConfigReachable, (<-n).Type)
+	assert.WithinDuration(t, time.Now(), p.LastContact(), time.Second)
+}
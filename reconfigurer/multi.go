@@ -0,0 +1,279 @@
+package reconfigurer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/task"
+	"github.com/picostack/pico/watcher"
+)
+
+var _ Provider = &Multi{}
+
+// Source is a named configuration provider. The name is usually the URL of the
+// configuration repository and is recorded on each target the source declares.
+// Directory is where the source's repository is checked out, if any, targets
+// may not use a directory overlapping it.
+type Source struct {
+	Name      string
+	Provider  Provider
+	Directory string
+}
+
+// Multi implements a Provider that runs one provider per configuration source
+// and merges the states they produce into a single state for the watcher. The
+// watcher is only configured once every source has produced its first state.
+type Multi struct {
+	directory string
+	sources   []Source
+	notifier  notifier.Notifier
+
+	mu      sync.Mutex
+	target  watcher.Watcher
+	states  map[string]config.State
+	changes targetSet
+	applied bool // whether a merged state has been set on the watcher
+}
+
+// NewMulti creates a provider that merges the given sources, in order. Target
+// directories are resolved against the data directory. Changes to the merged
+// targets are sent to the notifier.
+func NewMulti(directory string, n notifier.Notifier, sources ...Source) *Multi {
+	return &Multi{
+		directory: directory,
+		sources:   sources,
+		notifier:  n,
+		states:    make(map[string]config.State),
+	}
+}
+
+// Configure implements Provider
+func (m *Multi) Configure(w watcher.Watcher) error {
+	m.mu.Lock()
+	m.target = w
+	m.mu.Unlock()
+
+	errs := make(chan error, len(m.sources))
+	for _, s := range m.sources {
+		go func(s Source) {
+			errs <- errors.Wrapf(
+				s.Provider.Configure(&sourceWatcher{m: m, name: s.Name}),
+				"configuration source %s failed", s.Name,
+			)
+		}(s)
+	}
+
+	for range m.sources {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Multi) set(name string, state config.State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.states[name] = state
+	if len(m.states) < len(m.sources) {
+		zap.L().Debug("waiting for remaining configuration sources",
+			zap.String("source", name),
+			zap.Int("received", len(m.states)),
+			zap.Int("sources", len(m.sources)))
+		return nil
+	}
+
+	merged, err := m.merge()
+	if err != nil && !m.applied {
+		// there's no previous state to keep on the first merge, so the
+		// watcher would otherwise never be configured.
+		return errors.Wrapf(err, "failed to merge the first configuration of %s with the other sources", name)
+	}
+	if err != nil {
+		// a conflict is a configuration mistake, not a fatal error, so the
+		// previously applied state stays in effect until it is resolved.
+		zap.L().Error("failed to merge configuration sources, keeping previous state",
+			zap.String("source", name),
+			zap.Error(err))
+		return nil
+	}
+
+	if err := m.target.SetState(merged); err != nil {
+		return err
+	}
+	m.applied = true
+	m.logChanges(name, m.changes.apply(merged.Targets))
+	return nil
+}
+
+// revisioner is implemented by providers that know the revision of their
+// configuration that's in force, such as GitProvider.
+type revisioner interface {
+	Revision() Revision
+}
+
+// revision returns the revision of the named source that's in force, if its
+// provider knows it.
+func (m *Multi) revision(name string) (r Revision) {
+	for _, s := range m.sources {
+		if rv, ok := s.Provider.(revisioner); ok && s.Name == name {
+			return rv.Revision()
+		}
+	}
+	return
+}
+
+// Targets implements Provider, the targets are those of the merged state.
+func (m *Multi) Targets() []task.Target {
+	return m.changes.get()
+}
+
+// SubscribeChanges implements Provider
+func (m *Multi) SubscribeChanges(ch chan<- TargetSetChange) {
+	m.changes.subscribe(ch)
+}
+
+// logChanges logs and notifies the differences between the previously applied
+// targets and the targets just applied, if there are any, with the revision of
+// the source that changed them.
+func (m *Multi) logChanges(source string, diff task.TargetsDiff) {
+	if diff.Empty() {
+		return
+	}
+
+	revision := m.revision(source)
+	zap.L().Info("configuration changed",
+		zap.String("source", source),
+		zap.String("commit", revision.Commit),
+		zap.Strings("added", diff.Added),
+		zap.Strings("removed", diff.Removed),
+		zap.Any("modified", diff.Modified))
+
+	message := fmt.Sprintf("configuration changed: %d added, %d removed, %d modified", len(diff.Added), len(diff.Removed), len(diff.Modified))
+	if revision.Commit != "" {
+		message = fmt.Sprintf("configuration changed at %.7s: %d added, %d removed, %d modified", revision.Commit, len(diff.Added), len(diff.Removed), len(diff.Modified))
+	}
+
+	if m.notifier == nil {
+		return
+	}
+	go func() {
+		if err := m.notifier.Notify(notifier.Event{
+			Type:    notifier.EventConfigChanged,
+			Time:    time.Now(),
+			Message: message,
+			Diff:    &diff,
+			Repo:    source,
+			Commit:  revision.Commit,
+			Author:  revision.Author,
+		}); err != nil {
+			zap.L().Warn("failed to notify configuration change", zap.Error(err))
+		}
+	}()
+}
+
+func (m *Multi) get(name string) config.State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.states[name]; ok {
+		return s
+	}
+	return config.State{}
+}
+
+// merge combines the states of all sources in source order. Targets, auth
+// methods and notifiers must be uniquely named across all sources, environment
+// variables declared by earlier sources take precedence over later ones.
+// Targets of the same repository share a clone, even if they're declared by different sources.
+func (m *Multi) merge() (merged config.State, err error) {
+	merged.Env = make(map[string]string)
+
+	targetSources := make(map[string]string)
+	authSources := make(map[string]string)
+	notifierSources := make(map[string]string)
+	var conflicts []string
+
+	for _, s := range m.sources {
+		state := m.states[s.Name]
+
+		for _, t := range state.Targets {
+			if other, ok := targetSources[t.Name]; ok {
+				conflicts = append(conflicts, fmt.Sprintf(
+					"target '%s' declared by both %s and %s", t.Name, other, s.Name))
+				continue
+			}
+			targetSources[t.Name] = s.Name
+			t.Source = s.Name
+			merged.Targets = append(merged.Targets, t)
+		}
+
+		for _, a := range state.AuthMethods {
+			if other, ok := authSources[a.Name]; ok {
+				conflicts = append(conflicts, fmt.Sprintf(
+					"auth '%s' declared by both %s and %s", a.Name, other, s.Name))
+				continue
+			}
+			authSources[a.Name] = s.Name
+			merged.AuthMethods = append(merged.AuthMethods, a)
+		}
+
+		for _, n := range state.Notifiers {
+			if other, ok := notifierSources[n.Name]; ok {
+				conflicts = append(conflicts, fmt.Sprintf(
+					"notifier '%s' declared by both %s and %s", n.Name, other, s.Name))
+				continue
+			}
+			notifierSources[n.Name] = s.Name
+			merged.Notifiers = append(merged.Notifiers, n)
+		}
+
+		for k, v := range state.Env {
+			if _, ok := merged.Env[k]; !ok {
+				merged.Env[k] = v
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return config.State{}, errors.New(strings.Join(conflicts, "; "))
+	}
+	if err := task.ValidateTargets(merged.Targets); err != nil {
+		return config.State{}, err
+	}
+	task.ShareClones(merged.Targets)
+	var checkouts []string
+	for _, s := range m.sources {
+		if s.Directory != "" {
+			checkouts = append(checkouts, s.Directory)
+		}
+	}
+	if err := task.ValidateDirectories(merged.Targets, m.directory, checkouts...); err != nil {
+		return config.State{}, err
+	}
+
+	return merged, nil
+}
+
+// sourceWatcher is handed to each source's provider in place of the real
+// watcher so the state it sets can be merged with the other sources.
+type sourceWatcher struct {
+	m    *Multi
+	name string
+}
+
+func (s *sourceWatcher) SetState(state config.State) error {
+	return s.m.set(s.name, state)
+}
+
+func (s *sourceWatcher) GetState() config.State {
+	return s.m.get(s.name)
+}
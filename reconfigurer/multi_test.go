@@ -0,0 +1,142 @@
+package reconfigurer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+	"github.com/picostack/pico/watcher"
+
+	_ "github.com/picostack/pico/logger"
+)
+
+func TestMultiMerge(t *testing.T) {
+	w := &watcher.MockWatcher{}
+	m := NewMulti("/data", nil,
+		Source{Name: "base", Provider: &Static{state: config.State{
+			Targets: task.Targets{{Name: "proxy", RepoURL: "https://git/proxy"}},
+			Env:     map[string]string{"DOMAIN": "base.local", "TIER": "prod"},
+		}}},
+		Source{Name: "team", Provider: &Static{state: config.State{
+			Targets: task.Targets{{Name: "app", RepoURL: "https://git/app"}},
+			Env:     map[string]string{"DOMAIN": "team.local"},
+		}}},
+	)
+
+	assert.NoError(t, m.Configure(w))
+	assert.Equal(t, config.State{
+		Targets: task.Targets{
+			{Name: "proxy", RepoURL: "https://git/proxy", Source: "base"},
+			{Name: "app", RepoURL: "https://git/app", Source: "team"},
+		},
+		Env: map[string]string{"DOMAIN": "base.local", "TIER": "prod"},
+	}, w.GetState())
+}
+
+func TestMultiMergeConflict(t *testing.T) {
+	m := NewMulti("/data", nil, Source{Name: "base"}, Source{Name: "team"})
+	m.states["base"] = config.State{Targets: task.Targets{{Name: "app"}}}
+	m.states["team"] = config.State{Targets: task.Targets{{Name: "app"}}}
+
+	_, err := m.merge()
+	assert.EqualError(t, err, "target 'app' declared by both base and team")
+
+	m.states["base"] = config.State{Notifiers: []config.Notifier{{Name: "ops"}}}
+	m.states["team"] = config.State{Notifiers: []config.Notifier{{Name: "ops"}}}
+	_, err = m.merge()
+	assert.EqualError(t, err, "notifier 'ops' declared by both base and team")
+}
+
+func TestMultiConfigureConflict(t *testing.T) {
+	w := &watcher.MockWatcher{}
+	m := NewMulti("/data", nil,
+		Source{Name: "base", Provider: &Static{state: config.State{
+			Targets: task.Targets{{Name: "app", RepoURL: "https://git/app"}},
+		}}},
+		Source{Name: "team", Provider: &Static{state: config.State{
+			Targets: task.Targets{{Name: "app", RepoURL: "https://git/team-app"}},
+		}}},
+	)
+
+	err := m.Configure(w)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "target 'app' declared by both base and team")
+	assert.Empty(t, w.GetState().Targets)
+}
+
+func TestMultiConflictKeepsState(t *testing.T) {
+	w := &watcher.MockWatcher{}
+	m := NewMulti("/data", nil,
+		Source{Name: "base", Provider: &Static{state: config.State{
+			Targets: task.Targets{{Name: "proxy", RepoURL: "https://git/proxy"}},
+		}}},
+		Source{Name: "team", Provider: &Static{state: config.State{
+			Targets: task.Targets{{Name: "app", RepoURL: "https://git/app"}},
+		}}},
+	)
+	assert.NoError(t, m.Configure(w))
+	before := w.GetState()
+
+	// a later conflict is logged and the merged state stays in effect
+	assert.NoError(t, m.set("team", config.State{Targets: task.Targets{
+		{Name: "proxy", RepoURL: "https://git/team-proxy"},
+	}}))
+	assert.Equal(t, before, w.GetState())
+
+	assert.NoError(t, m.set("team", config.State{Targets: task.Targets{
+		{Name: "api", RepoURL: "https://git/api"},
+	}}))
+	assert.Equal(t, task.Targets{
+		{Name: "proxy", RepoURL: "https://git/proxy", Source: "base"},
+		{Name: "api", RepoURL: "https://git/api", Source: "team"},
+	}, w.GetState().Targets)
+}
+
+func TestMultiMergeDirectory(t *testing.T) {
+	m := NewMulti("/data", nil, Source{Name: "base", Directory: "/data/config"})
+	m.states["base"] = config.State{Targets: task.Targets{{Name: "app", Directory: "/data/config/app"}}}
+
+	_, err := m.merge()
+	assert.EqualError(t, err, "target 'app' directory '/data/config/app' overlaps the configuration checkout '/data/config'")
+}
+
+func TestMultiSubscribeChanges(t *testing.T) {
+	w := &watcher.MockWatcher{}
+	m := NewMulti("/data", nil,
+		Source{Name: "base", Provider: &Static{state: config.State{
+			Targets: task.Targets{{Name: "proxy", RepoURL: "https://git/proxy"}},
+		}}},
+	)
+	changes := make(chan TargetSetChange, 1)
+	m.SubscribeChanges(changes)
+	full := make(chan TargetSetChange)
+	m.SubscribeChanges(full)
+
+	assert.NoError(t, m.Configure(w))
+	change := <-changes
+	assert.Equal(t, []string{"proxy"}, change.Added)
+	assert.Equal(t, m.Targets(), change.Targets)
+
+	assert.NoError(t, m.set("base", config.State{Targets: task.Targets{
+		{Name: "proxy", RepoURL: "https://git/proxy", Branch: "next"},
+		{Name: "app", RepoURL: "https://git/app"},
+	}}))
+	change = <-changes
+	assert.Equal(t, task.TargetsDiff{
+		Added:    []string{"app"},
+		Modified: []task.TargetChange{{Name: "proxy", Fields: []string{"branch"}}},
+	}, change.TargetsDiff)
+
+	// re-applying the same targets isn't a change
+	assert.NoError(t, m.set("base", config.State{Targets: task.Targets{
+		{Name: "proxy", RepoURL: "https://git/proxy", Branch: "next"},
+		{Name: "app", RepoURL: "https://git/app"},
+	}}))
+	assert.Len(t, changes, 0)
+
+	targets := m.Targets()
+	targets[0].Name = "changed"
+	assert.Equal(t, "proxy", m.Targets()[0].Name)
+}
@@ -8,11 +8,19 @@
 package reconfigurer
 
 import (
+	"github.com/picostack/pico/task"
 	"github.com/picostack/pico/watcher"
 )
 
 // Provider describes a type that can provide config state events to a target
 // watcher. It will reconfigure and restart the watcher whenever necessary.
+//
+// Targets returns a copy of the targets of the state last applied to the
+// watcher and SubscribeChanges registers a channel that's sent every change to
+// them. Changes are sent without blocking, so the channel should be buffered,
+// a change that doesn't fit in its buffer is dropped.
 type Provider interface {
 	Configure(watcher.Watcher) error
+	Targets() []task.Target
+	SubscribeChanges(chan<- TargetSetChange)
 }
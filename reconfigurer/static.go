@@ -2,6 +2,7 @@ package reconfigurer
 
 import (
 	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
 	"github.com/picostack/pico/watcher"
 )
 
@@ -24,3 +25,12 @@ func NewStatic(state config.State, w watcher.Watcher) Static {
 func (s *Static) Configure(w watcher.Watcher) error {
 	return w.SetState(s.state)
 }
+
+// Targets implements Provider
+func (s *Static) Targets() []task.Target {
+	return copyTargets(s.state.Targets)
+}
+
+// SubscribeChanges implements Provider, the targets of a static state never
+// change so nothing is ever sent.
+func (s *Static) SubscribeChanges(chan<- TargetSetChange) {}
@@ -0,0 +1,219 @@
+// Package redact keeps a process-wide list of secret values which are replaced
+// in log output, so secrets that end up in a log line, such as in the
+// environment of an executed task, are never written out.
+package redact
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replacement is written in place of a secret value
+const Replacement = "[REDACTED]"
+
+// minLength avoids redacting short values, which would mangle unrelated output
+// while revealing little about the secret.
+const minLength = 4
+
+var (
+	mu sync.RWMutex
+	// values are redacted until their expiry, forever if it's zero
+	values   = make(map[string]time.Time)
+	replacer = strings.NewReplacer()
+)
+
+// Add registers secret values to be redacted. Values are also redacted in the
+// escaped form they take inside JSON strings.
+func Add(secrets ...string) {
+	add(time.Time{}, secrets)
+}
+
+// AddExpiring registers secret values that are only valid for ttl, such as
+// leased credentials, to be redacted until then or until they're removed. A
+// value that was added by Add stays redacted.
+func AddExpiring(ttl time.Duration, secrets ...string) {
+	add(time.Now().Add(ttl), secrets)
+}
+
+// Remove stops redacting secret values registered by AddExpiring, such as when
+// their lease is revoked.
+func Remove(secrets ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	changed := false
+	for _, s := range secrets {
+		for _, v := range []string{s, jsonEscaped(s)} {
+			if expiry, ok := values[v]; ok && !expiry.IsZero() {
+				delete(values, v)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		rebuild()
+	}
+}
+
+func add(expiry time.Time, secrets []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	changed := expire(time.Now())
+	for _, s := range secrets {
+		if len(s) < minLength {
+			continue
+		}
+		for _, v := range []string{s, jsonEscaped(s)} {
+			current, ok := values[v]
+			if ok && (current.IsZero() || (!expiry.IsZero() && !current.Before(expiry))) {
+				continue
+			}
+			values[v] = expiry
+			changed = true
+		}
+	}
+	if changed {
+		rebuild()
+	}
+}
+
+// expire drops the values whose expiry has passed and reports whether there
+// were any. Must hold mu.
+func expire(now time.Time) bool {
+	changed := false
+	for v, expiry := range values {
+		if !expiry.IsZero() && !now.Before(expiry) {
+			delete(values, v)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// String returns s with all registered secret values replaced
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return replacer.Replace(s)
+}
+
+// Writer returns a writer that redacts everything written to w. Each write is
+// redacted on its own, which suits loggers that write one entry at a time.
+func Writer(w io.Writer) io.Writer {
+	return writer{w}
+}
+
+type writer struct{ w io.Writer }
+
+func (r writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Stream returns a writer that redacts everything written to w, including
+// secrets split across writes. The end of a write that may be the start of a
+// secret is held back until the next write, Close writes what's left.
+func Stream(w io.Writer) io.WriteCloser {
+	return &stream{w: w}
+}
+
+type stream struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending string
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf := s.pending + string(p)
+	mu.RLock()
+	cut := safeCut(buf)
+	out := replacer.Replace(buf[:cut])
+	mu.RUnlock()
+	s.pending = buf[cut:]
+
+	if _, err := io.WriteString(s.w, out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the output held back, it doesn't close the underlying writer
+func (s *stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := String(s.pending)
+	s.pending = ""
+	_, err := io.WriteString(s.w, out)
+	return err
+}
+
+// safeCut returns how much of s can be redacted before the rest of the stream
+// is known: up to the first secret that starts in s and may continue beyond
+// the cut, either because s ends inside it or it overlaps a secret that does.
+// Must hold mu.
+func safeCut(s string) int {
+	cut := len(s)
+	for {
+		next := cut
+		for v := range values {
+			from := cut - len(v) + 1
+			if from < 0 {
+				from = 0
+			}
+			for i := from; i < next; i++ {
+				if strings.HasPrefix(s[i:], v) || strings.HasPrefix(v, s[i:]) {
+					next = i
+					break
+				}
+			}
+		}
+		if next == cut {
+			return cut
+		}
+		cut = next
+	}
+}
+
+// rebuild replaces longer values first, so a secret containing another secret
+// is redacted as a whole. Must hold mu.
+func rebuild() {
+	sorted := make([]string, 0, len(values))
+	for v := range values {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, v := range sorted {
+		pairs = append(pairs, v, Replacement)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+func jsonEscaped(s string) string {
+	b, _ := json.Marshal(s) //nolint:errcheck - strings always encode
+	return string(b[1 : len(b)-1])
+}
+
+// reset clears all values, for tests
+func reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	values = make(map[string]time.Time)
+	replacer = strings.NewReplacer()
+}
@@ -0,0 +1,67 @@
+package redact
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	defer reset()
+
+	Add("hunter2", "abc", `pa"ss`, "hunter2-longer")
+
+	assert.Equal(t, "password is [REDACTED]", String("password is hunter2"))
+	assert.Equal(t, "[REDACTED] and [REDACTED]", String("hunter2-longer and hunter2"))
+	assert.Equal(t, "abc is too short", String("abc is too short"))
+	assert.Equal(t, `{"p":"[REDACTED]"}`, String(`{"p":"pa\"ss"}`))
+}
+
+func TestWriter(t *testing.T) {
+	defer reset()
+
+	Add("hunter2")
+
+	var b bytes.Buffer
+	n, err := Writer(&b).Write([]byte("the password is hunter2\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 24, n)
+	assert.Equal(t, "the password is [REDACTED]\n", b.String())
+}
+
+func TestExpiring(t *testing.T) {
+	defer reset()
+
+	Add("static-secret")
+	AddExpiring(time.Hour, "leased-secret", "static-secret")
+	AddExpiring(-time.Second, "expired-secret")
+	assert.Equal(t, "[REDACTED] [REDACTED]", String("static-secret leased-secret"))
+
+	// expired values are dropped when more are added
+	Add("another-secret")
+	assert.Equal(t, "expired-secret", String("expired-secret"))
+
+	Remove("leased-secret", "static-secret")
+	assert.Equal(t, "leased-secret [REDACTED]", String("leased-secret static-secret"))
+	assert.Len(t, values, 2, "only the static values are left")
+}
+
+func TestStream(t *testing.T) {
+	defer reset()
+
+	Add("hunter2", "hunter2-longer")
+
+	var b bytes.Buffer
+	s := Stream(&b)
+	for _, p := range []string{"the password is hun", "ter2 and ", "hunter2", "-lon", "ger, ", "hunter"} {
+		n, err := s.Write([]byte(p))
+		assert.NoError(t, err)
+		assert.Equal(t, len(p), n)
+	}
+	assert.Equal(t, "the password is [REDACTED] and [REDACTED], ", b.String())
+
+	assert.NoError(t, s.Close())
+	assert.Equal(t, "the password is [REDACTED] and [REDACTED], hunter", b.String())
+}
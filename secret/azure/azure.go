@@ -0,0 +1,219 @@
+// Package azure implements a secret.Store backed by Azure Key Vault. Key Vault
+// has no notion of paths, so every secret is named after the target it belongs
+// to and its key, see SecretName for the mapping.
+//
+// The store talks to the Key Vault REST API directly, see the secret package.
+// Key Vault can't list the secrets of one target, so the names of all secrets
+// are listed and kept for listTTL, reads of several targets share one list.
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/secret"
+)
+
+const apiVersion = "7.4"
+
+// maxAttempts is how many times a throttled or unavailable request is tried
+const maxAttempts = 5
+
+// listTTL is how long the list of secret names is reused before it's listed
+// again, so a secret that was added takes up to this long to be picked up
+const listTTL = time.Minute
+
+// KeyVaultSecrets implements a secret.Store backed by Azure Key Vault
+type KeyVaultSecrets struct {
+	vaultURI   string
+	client     *http.Client
+	credential Credential
+	backoff    time.Duration // the initial delay between retries
+
+	listMu sync.Mutex
+	names  []string
+	listed time.Time
+}
+
+var _ secret.Store = &KeyVaultSecrets{}
+
+// New creates a store for the vault at the given URI, such as
+// https://myvault.vault.azure.net, authenticating with DefaultCredential.
+func New(vaultURI string) (*KeyVaultSecrets, error) {
+	u, err := url.Parse(vaultURI)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, errors.Errorf("invalid key vault URI '%s'", vaultURI)
+	}
+
+	client := cleanhttp.DefaultClient()
+	client.Transport = buildinfo.Transport(client.Transport)
+
+	return &KeyVaultSecrets{
+		vaultURI:   strings.TrimSuffix(vaultURI, "/"),
+		client:     client,
+		credential: DefaultCredential(client),
+		backoff:    time.Second,
+	}, nil
+}
+
+// GetSecretsForTarget implements secret.Store
+func (k *KeyVaultSecrets) GetSecretsForTarget(name string) (map[string]string, error) {
+	ctx := context.Background()
+
+	names, err := k.cachedList(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list key vault secrets")
+	}
+
+	var env map[string]string
+	for _, n := range names {
+		target, key, ok := ParseSecretName(n)
+		// names are case-insensitive in key vault, so targets are too
+		if !ok || !strings.EqualFold(target, name) {
+			continue
+		}
+		value, err := k.get(ctx, n)
+		if err != nil {
+			// the secret may have been deleted since it was listed
+			k.forgetList()
+			return nil, errors.Wrapf(err, "failed to read secret %s", n)
+		}
+		if env == nil {
+			env = make(map[string]string)
+		}
+		env[key] = value
+	}
+
+	zap.L().Debug("found secrets in key vault",
+		zap.String("name", name),
+		zap.Int("secrets", len(env)))
+
+	return env, nil
+}
+
+// cachedList returns the names of all enabled secrets, listing them again if
+// the last list is older than listTTL.
+func (k *KeyVaultSecrets) cachedList(ctx context.Context) ([]string, error) {
+	k.listMu.Lock()
+	defer k.listMu.Unlock()
+
+	if k.names != nil && time.Since(k.listed) < listTTL {
+		return k.names, nil
+	}
+	names, err := k.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if names == nil {
+		names = []string{}
+	}
+	k.names, k.listed = names, time.Now()
+	return names, nil
+}
+
+func (k *KeyVaultSecrets) forgetList() {
+	k.listMu.Lock()
+	k.names = nil
+	k.listMu.Unlock()
+}
+
+// list returns the names of all enabled secrets, following pagination
+func (k *KeyVaultSecrets) list(ctx context.Context) ([]string, error) {
+	var names []string
+	next := k.vaultURI + "/secrets?api-version=" + apiVersion
+	for next != "" {
+		var page struct {
+			Value []struct {
+				ID         string `json:"id"`
+				Attributes struct {
+					Enabled bool `json:"enabled"`
+				} `json:"attributes"`
+			} `json:"value"`
+			NextLink string `json:"nextLink"`
+		}
+		if err := k.request(ctx, next, &page); err != nil {
+			return nil, err
+		}
+		for _, s := range page.Value {
+			if !s.Attributes.Enabled {
+				continue
+			}
+			names = append(names, s.ID[strings.LastIndex(s.ID, "/")+1:])
+		}
+		next = page.NextLink
+	}
+	return names, nil
+}
+
+// get reads the current version of a secret
+func (k *KeyVaultSecrets) get(ctx context.Context, name string) (string, error) {
+	var s struct {
+		Value string `json:"value"`
+	}
+	if err := k.request(ctx, k.vaultURI+"/secrets/"+url.PathEscape(name)+"?api-version="+apiVersion, &s); err != nil {
+		return "", err
+	}
+	return s.Value, nil
+}
+
+// request performs an authenticated GET and decodes the response into v.
+// Throttled (429) and unavailable (5xx) responses are retried with exponential
+// backoff, honouring Retry-After if the response has one.
+func (k *KeyVaultSecrets) request(ctx context.Context, endpoint string, v interface{}) error {
+	delay := k.backoff
+	for attempt := 1; ; attempt++ {
+		tok, err := k.credential.Token(ctx)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+
+		resp, err := k.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(v)
+			resp.Body.Close()
+			return errors.Wrap(err, "failed to decode key vault response")
+		}
+		resp.Body.Close()
+
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !retry || attempt == maxAttempts {
+			return errors.Errorf("key vault responded with %s", resp.Status)
+		}
+
+		wait := delay
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			wait = time.Duration(s) * time.Second
+		}
+		zap.L().Debug("key vault request throttled, retrying",
+			zap.Int("status", resp.StatusCode),
+			zap.Int("attempt", attempt),
+			zap.Duration("wait", wait))
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,101 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticCredential string
+
+func (s staticCredential) Token(ctx context.Context) (string, error) { return string(s), nil }
+
+func TestGetSecretsForTarget(t *testing.T) {
+	throttled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		if !throttled {
+			throttled = true
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		switch r.URL.Path {
+		case "/secrets":
+			json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+				"value": []map[string]interface{}{
+					{"id": "https://vault/secrets/app--DB-5FURL", "attributes": map[string]bool{"enabled": true}},
+					{"id": "https://vault/secrets/app--OLD", "attributes": map[string]bool{"enabled": false}},
+					{"id": "https://vault/secrets/other--KEY", "attributes": map[string]bool{"enabled": true}},
+					{"id": "https://vault/secrets/unrelated", "attributes": map[string]bool{"enabled": true}},
+				},
+			})
+		case "/secrets/app--DB-5FURL":
+			json.NewEncoder(w).Encode(map[string]string{"value": "postgres://db"}) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	k := &KeyVaultSecrets{
+		vaultURI:   srv.URL,
+		client:     srv.Client(),
+		credential: staticCredential("token"),
+	}
+
+	got, err := k.GetSecretsForTarget("APP")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"DB_URL": "postgres://db"}, got)
+	assert.True(t, throttled)
+}
+
+func TestRequestGivesUp(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	k := &KeyVaultSecrets{vaultURI: srv.URL, client: srv.Client(), credential: staticCredential("token")}
+	_, err := k.GetSecretsForTarget("app")
+	assert.Error(t, err)
+	assert.Equal(t, maxAttempts, calls)
+}
+
+func TestListCached(t *testing.T) {
+	lists := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/secrets":
+			lists++
+			json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+				"value": []map[string]interface{}{
+					{"id": "https://vault/secrets/app--KEY", "attributes": map[string]bool{"enabled": true}},
+					{"id": "https://vault/secrets/db--KEY", "attributes": map[string]bool{"enabled": true}},
+				},
+			})
+		case "/secrets/app--KEY", "/secrets/db--KEY":
+			json.NewEncoder(w).Encode(map[string]string{"value": "value"}) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	k := &KeyVaultSecrets{vaultURI: srv.URL, client: srv.Client(), credential: staticCredential("token")}
+	for _, target := range []string{"app", "db", "none"} {
+		_, err := k.GetSecretsForTarget(target)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, lists)
+
+	k.listed = k.listed.Add(-listTTL)
+	_, err := k.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, lists)
+}
@@ -0,0 +1,246 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// resource is the Azure AD resource Key Vault tokens are issued for
+const resource = "https://vault.azure.net"
+
+// imdsEndpoint is the instance metadata service that issues managed identity
+// tokens on Azure VMs
+const imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// authorityHost is where service principal tokens are issued, unless
+// AZURE_AUTHORITY_HOST names another cloud's
+const authorityHost = "https://login.microsoftonline.com/"
+
+// Credential obtains Azure AD access tokens for Key Vault
+type Credential interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// DefaultCredential returns a credential that tries the same sources, in the
+// same order, as the Azure SDK's DefaultAzureCredential, which needs a newer
+// Go release than Pico is built with:
+//
+//   - a service principal from AZURE_TENANT_ID, AZURE_CLIENT_ID and
+//     AZURE_CLIENT_SECRET
+//   - workload identity, federating the token in AZURE_FEDERATED_TOKEN_FILE
+//   - the managed identity of the App Service or VM, AZURE_CLIENT_ID selects
+//     a user-assigned identity
+//   - the account the Azure CLI is logged in with
+//
+// Sources that aren't configured are skipped, the first that issues a token
+// is used from then on.
+func DefaultCredential(client *http.Client) Credential {
+	tenant := os.Getenv("AZURE_TENANT_ID")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	secret := os.Getenv("AZURE_CLIENT_SECRET")
+	tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = authorityHost
+	}
+	tokenEndpoint := strings.TrimSuffix(authority, "/") + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+
+	c := &chain{}
+	if tenant != "" && clientID != "" && secret != "" {
+		c.add("environment", func(ctx context.Context) (token, error) {
+			return fetchToken(ctx, client, http.MethodPost, tokenEndpoint, nil, url.Values{
+				"grant_type":    {"client_credentials"},
+				"client_id":     {clientID},
+				"client_secret": {secret},
+				"scope":         {resource + "/.default"},
+			})
+		})
+	}
+	if tenant != "" && clientID != "" && tokenFile != "" {
+		c.add("workload identity", func(ctx context.Context) (token, error) {
+			assertion, err := ioutil.ReadFile(tokenFile)
+			if err != nil {
+				return token{}, errors.Wrap(err, "failed to read federated token")
+			}
+			return fetchToken(ctx, client, http.MethodPost, tokenEndpoint, nil, url.Values{
+				"grant_type":            {"client_credentials"},
+				"client_id":             {clientID},
+				"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+				"client_assertion":      {strings.TrimSpace(string(assertion))},
+				"scope":                 {resource + "/.default"},
+			})
+		})
+	}
+	c.add("managed identity", func(ctx context.Context) (token, error) {
+		q := url.Values{"resource": {resource}}
+		if clientID != "" {
+			q.Set("client_id", clientID)
+		}
+		// App Service and Functions run their own identity endpoint
+		if endpoint, header := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER"); endpoint != "" && header != "" {
+			q.Set("api-version", "2019-08-01")
+			return fetchToken(ctx, client, http.MethodGet, endpoint+"?"+q.Encode(),
+				http.Header{"X-Identity-Header": {header}}, nil)
+		}
+		q.Set("api-version", "2018-02-01")
+		return fetchToken(ctx, client, http.MethodGet, imdsEndpoint+"?"+q.Encode(),
+			http.Header{"Metadata": {"true"}}, nil)
+	})
+	c.add("azure cli", cliToken)
+	return c
+}
+
+type token struct {
+	value   string
+	expires time.Time
+}
+
+// chain implements a Credential that tries each source in order until one
+// issues a token, that source is then the only one used.
+type chain struct {
+	names   []string
+	sources []*cachedCredential
+
+	mu       sync.Mutex
+	selected *cachedCredential
+}
+
+func (c *chain) add(name string, fetch func(ctx context.Context) (token, error)) {
+	c.names = append(c.names, name)
+	c.sources = append(c.sources, &cachedCredential{fetch: fetch})
+}
+
+func (c *chain) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	selected := c.selected
+	c.mu.Unlock()
+	if selected != nil {
+		return selected.Token(ctx)
+	}
+
+	var failed []string
+	for i, source := range c.sources {
+		t, err := source.Token(ctx)
+		if err != nil {
+			failed = append(failed, c.names[i]+": "+err.Error())
+			continue
+		}
+		zap.L().Debug("authenticating to key vault", zap.String("credential", c.names[i]))
+		c.mu.Lock()
+		c.selected = source
+		c.mu.Unlock()
+		return t, nil
+	}
+	return "", errors.Errorf("no azure credential could obtain a token: %s", strings.Join(failed, "; "))
+}
+
+// cachedCredential reuses a token until shortly before it expires
+type cachedCredential struct {
+	fetch func(ctx context.Context) (token, error)
+
+	mu      sync.Mutex
+	current token
+}
+
+func (c *cachedCredential) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current.value != "" && time.Until(c.current.expires) > time.Minute {
+		return c.current.value, nil
+	}
+	t, err := c.fetch(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to obtain azure access token")
+	}
+	c.current = t
+	return t.value, nil
+}
+
+func fetchToken(ctx context.Context, client *http.Client, method, endpoint string, header http.Header, form url.Values) (token, error) {
+	var req *http.Request
+	var err error
+	if form != nil {
+		req, err = http.NewRequest(method, endpoint, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequest(method, endpoint, nil)
+	}
+	if err != nil {
+		return token{}, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return token{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return token{}, errors.Errorf("token endpoint responded with %s", resp.Status)
+	}
+
+	// the identity endpoints encode expires_in as either a number or a string
+	var body struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return token{}, errors.Wrap(err, "failed to decode token response")
+	}
+	seconds, err := strconv.Atoi(body.ExpiresIn.String())
+	if err != nil {
+		seconds = 300
+	}
+	return token{
+		value:   body.AccessToken,
+		expires: time.Now().Add(time.Duration(seconds) * time.Second),
+	}, nil
+}
+
+// azCommand runs the Azure CLI, replaced in tests
+var azCommand = func(ctx context.Context, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, "az", args...).Output()
+}
+
+// cliToken obtains a token for the account the Azure CLI is logged in with
+func cliToken(ctx context.Context) (token, error) {
+	out, err := azCommand(ctx, "account", "get-access-token", "--resource", resource, "--output", "json")
+	if err != nil {
+		return token{}, errors.Wrap(err, "failed to run az account get-access-token")
+	}
+
+	// older releases of the CLI only give expiresOn, in local time
+	var body struct {
+		AccessToken string `json:"accessToken"`
+		ExpiresOn   string `json:"expiresOn"`
+		ExpiresAt   int64  `json:"expires_on"`
+	}
+	if err := json.Unmarshal(out, &body); err != nil {
+		return token{}, errors.Wrap(err, "failed to decode az account get-access-token output")
+	}
+	expires := time.Now().Add(5 * time.Minute)
+	if body.ExpiresAt > 0 {
+		expires = time.Unix(body.ExpiresAt, 0)
+	} else if t, err := time.ParseInLocation("2006-01-02 15:04:05.999999", body.ExpiresOn, time.Local); err == nil {
+		expires = t
+	}
+	return token{value: body.AccessToken, expires: expires}, nil
+}
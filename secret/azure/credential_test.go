@@ -0,0 +1,81 @@
+package azure
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultCredentialWorkloadIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-azure")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("federated\n"), 0o600))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/tenant/oauth2/v2.0/token", r.URL.Path)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client", r.PostForm.Get("client_id"))
+		assert.Equal(t, "federated", r.PostForm.Get("client_assertion"))
+		w.Write([]byte(`{"access_token":"issued","expires_in":3600}`)) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	for k, v := range map[string]string{
+		"AZURE_TENANT_ID":            "tenant",
+		"AZURE_CLIENT_ID":            "client",
+		"AZURE_FEDERATED_TOKEN_FILE": tokenFile,
+		"AZURE_AUTHORITY_HOST":       srv.URL,
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	os.Unsetenv("AZURE_CLIENT_SECRET")
+
+	got, err := DefaultCredential(srv.Client()).Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "issued", got)
+}
+
+func TestChainSelectsFirstSource(t *testing.T) {
+	var tried []string
+	c := &chain{}
+	c.add("first", func(context.Context) (token, error) {
+		tried = append(tried, "first")
+		return token{}, errors.New("not configured")
+	})
+	c.add("second", func(context.Context) (token, error) {
+		tried = append(tried, "second")
+		return token{value: "second", expires: time.Now()}, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		got, err := c.Token(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "second", got)
+	}
+	assert.Equal(t, []string{"first", "second", "second"}, tried)
+
+	_, err := (&chain{}).Token(context.Background())
+	assert.Error(t, err)
+}
+
+func TestCLIToken(t *testing.T) {
+	defer func(f func(context.Context, ...string) ([]byte, error)) { azCommand = f }(azCommand)
+	azCommand = func(ctx context.Context, args ...string) ([]byte, error) {
+		return []byte(`{"accessToken":"cli","expiresOn":"2030-01-02 03:04:05.000000","expires_on":1893553445}`), nil
+	}
+
+	got, err := cliToken(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, token{value: "cli", expires: time.Unix(1893553445, 0)}, got)
+}
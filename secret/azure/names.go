@@ -0,0 +1,84 @@
+package azure
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxNameLength is the longest secret name Key Vault accepts
+const maxNameLength = 127
+
+// separator joins the target and key parts of a secret name. Escapes are always
+// a dash followed by a hex digit, so a double dash can't appear inside a part.
+const separator = "--"
+
+// SecretName maps a target and secret key onto a valid Key Vault secret name.
+// Key Vault only allows alphanumerics and dashes in names, so every other byte,
+// including dashes, is escaped as a dash followed by two hex digits and the two
+// parts are joined with a double dash. For example APP and DB_URL becomes
+// APP--DB-5FURL. Key Vault names are case-insensitive, so keys that only differ
+// by case can't be stored for the same target.
+func SecretName(target, key string) (string, error) {
+	if target == "" || key == "" {
+		return "", errors.New("target and key must not be empty")
+	}
+	name := escape(target) + separator + escape(key)
+	if len(name) > maxNameLength {
+		return "", errors.Errorf("secret name for %s_%s is %d characters long, Key Vault allows %d", target, key, len(name), maxNameLength)
+	}
+	return name, nil
+}
+
+// ParseSecretName is the inverse of SecretName, names that weren't produced by
+// SecretName are reported as not ok.
+func ParseSecretName(name string) (target, key string, ok bool) {
+	parts := strings.SplitN(name, separator, 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	target, err := unescape(parts[0])
+	if err != nil || target == "" {
+		return "", "", false
+	}
+	key, err = unescape(parts[1])
+	if err != nil || key == "" {
+		return "", "", false
+	}
+	return target, key, true
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "-%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func unescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '-' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", errors.Errorf("truncated escape in '%s'", s)
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", errors.Errorf("invalid escape in '%s'", s)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,48 @@
+package azure
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretName(t *testing.T) {
+	tests := []struct {
+		target string
+		key    string
+		want   string
+	}{
+		{"app", "PASSWORD", "app--PASSWORD"},
+		{"APP", "DB_URL", "APP--DB-5FURL"},
+		{"my-app", "KEY", "my-2Dapp--KEY"},
+		{"my.app", "A_B_C", "my-2Eapp--A-5FB-5FC"},
+		{"pico", "GLOBAL_TOKEN", "pico--GLOBAL-5FTOKEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got, err := SecretName(tt.target, tt.key)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			target, key, ok := ParseSecretName(got)
+			assert.True(t, ok)
+			assert.Equal(t, tt.target, target)
+			assert.Equal(t, tt.key, key)
+		})
+	}
+}
+
+func TestSecretNameInvalid(t *testing.T) {
+	_, err := SecretName("", "KEY")
+	assert.Error(t, err)
+	_, err = SecretName("app", strings.Repeat("A", 128))
+	assert.Error(t, err)
+}
+
+func TestParseSecretNameInvalid(t *testing.T) {
+	for _, name := range []string{"plain", "app--", "--KEY", "app--KEY-5", "app--KEY-ZZ"} {
+		_, _, ok := ParseSecretName(name)
+		assert.False(t, ok, name)
+	}
+}
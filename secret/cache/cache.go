@@ -0,0 +1,207 @@
+// Package cache implements a secret.Store that keeps an encrypted copy of the
+// secrets most recently read from another store on disk. If the other store
+// can't be reached, such as when a host starts without network access, the
+// cached secrets can be used instead so targets can still be deployed.
+//
+// Entries are encrypted with AES-256-GCM using a key derived from a local key
+// file, which should be readable only by Pico.
+package cache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/secret"
+)
+
+// Directory is where entries are stored, relative to the data directory
+const Directory = ".pico-secrets"
+
+// CachedSecrets implements a secret.Store in front of another store
+type CachedSecrets struct {
+	store      secret.Store
+	dir        string
+	aead       cipher.AEAD
+	allowStale bool
+
+	mu    sync.Mutex
+	stale map[string]time.Time // names last served from the cache, by fetch time
+}
+
+var (
+	_ secret.Store         = &CachedSecrets{}
+	_ secret.Wrapper       = &CachedSecrets{}
+	_ secret.StaleReporter = &CachedSecrets{}
+	_ secret.ContextStore  = &CachedSecrets{}
+)
+
+type entry struct {
+	Fetched time.Time         `json:"fetched"`
+	Secrets map[string]string `json:"secrets"`
+}
+
+// New creates a cache of store in the data directory, encrypted with a key
+// derived from the contents of keyfile. Cached secrets are only used when
+// allowStale is set, otherwise the cache is kept up to date but never read.
+func New(store secret.Store, dataDir, keyfile string, allowStale bool) (*CachedSecrets, error) {
+	material, err := ioutil.ReadFile(keyfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read secret cache key file")
+	}
+	if len(material) < 32 {
+		return nil, errors.New("secret cache key file must contain at least 32 bytes")
+	}
+	key := sha256.Sum256(append([]byte("pico secret cache\x00"), material...))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(dataDir, Directory)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "failed to create secret cache directory")
+	}
+
+	return &CachedSecrets{
+		store:      store,
+		dir:        dir,
+		aead:       aead,
+		allowStale: allowStale,
+		stale:      make(map[string]time.Time),
+	}, nil
+}
+
+// Wipe removes every cached entry from the data directory
+func Wipe(dataDir string) error {
+	return os.RemoveAll(filepath.Join(dataDir, Directory))
+}
+
+// Unwrap implements secret.Wrapper
+func (c *CachedSecrets) Unwrap() secret.Store {
+	return c.store
+}
+
+// GetSecretsForTarget implements secret.Store
+func (c *CachedSecrets) GetSecretsForTarget(name string) (map[string]string, error) {
+	return c.GetSecretsForTargetContext(context.Background(), name)
+}
+
+// GetSecretsForTargetContext implements secret.ContextStore
+func (c *CachedSecrets) GetSecretsForTargetContext(ctx context.Context, name string) (map[string]string, error) {
+	secrets, err := secret.Get(ctx, c.store, name)
+	if err == nil {
+		c.mu.Lock()
+		delete(c.stale, name)
+		c.mu.Unlock()
+
+		if werr := c.write(name, entry{Fetched: time.Now(), Secrets: secrets}); werr != nil {
+			secret.Logger(ctx).Warn("failed to update secret cache", zap.String("name", name), zap.Error(werr))
+		}
+		return secrets, nil
+	}
+	if !c.allowStale || !unavailable(err) {
+		return nil, err
+	}
+
+	e, rerr := c.read(name)
+	if rerr != nil {
+		secret.Logger(ctx).Debug("no usable cached secrets", zap.String("name", name), zap.Error(rerr))
+		return nil, err
+	}
+
+	secret.Logger(ctx).Error("SECRET STORE UNAVAILABLE, USING STALE CACHED SECRETS",
+		zap.String("name", name),
+		zap.Time("fetched", e.Fetched),
+		zap.Error(err))
+
+	c.mu.Lock()
+	c.stale[name] = e.Fetched
+	c.mu.Unlock()
+
+	return e.Secrets, nil
+}
+
+// unavailable reports whether err means the store couldn't be reached, only
+// then are cached secrets used. A store that denied access must not be worked
+// around with secrets it was allowed to read before.
+func unavailable(err error) bool {
+	if errors.Is(err, secret.ErrSecretDenied) {
+		return false
+	}
+	if errors.Is(err, secret.ErrSecretUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// StaleSince implements secret.StaleReporter
+func (c *CachedSecrets) StaleSince(name string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.stale[name]
+	return t, ok
+}
+
+// path names entries by a hash so names never need escaping
+func (c *CachedSecrets) path(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *CachedSecrets) write(name string, e entry) error {
+	plain, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	// the name is authenticated so entries can't be swapped between targets
+	sealed := c.aead.Seal(nonce, nonce, plain, []byte(name))
+
+	path := c.path(name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, sealed, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *CachedSecrets) read(name string) (e entry, err error) {
+	sealed, err := ioutil.ReadFile(c.path(name))
+	if err != nil {
+		return e, err
+	}
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return e, errors.New("cache entry is truncated")
+	}
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], []byte(name))
+	if err != nil {
+		return e, errors.Wrap(err, "failed to decrypt cache entry, was the key file changed?")
+	}
+	err = json.Unmarshal(plain, &e)
+	return e, err
+}
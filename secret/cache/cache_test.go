@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/secret/memory"
+)
+
+type flakyStore struct {
+	memory.MemorySecrets
+	down   bool
+	denied bool
+}
+
+func (f *flakyStore) GetSecretsForTarget(name string) (map[string]string, error) {
+	if f.denied {
+		return nil, &secret.StoreError{Kind: secret.ErrSecretDenied, Err: errors.New("permission denied")}
+	}
+	if f.down {
+		return nil, &secret.StoreError{Kind: secret.ErrSecretUnavailable, Err: errors.New("connection refused")}
+	}
+	return f.MemorySecrets.GetSecretsForTarget(name)
+}
+
+func setup(t *testing.T) (dir, keyfile string) {
+	dir, err := ioutil.TempDir("", "pico-cache")
+	require.NoError(t, err)
+	keyfile = filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(keyfile, []byte(strings.Repeat("k", 32)), 0o600))
+	return dir, keyfile
+}
+
+func TestCache(t *testing.T) {
+	dir, keyfile := setup(t)
+	defer os.RemoveAll(dir)
+
+	store := &flakyStore{MemorySecrets: memory.MemorySecrets{Secrets: map[string]map[string]string{
+		"app": {"DB_PASS": "hunter2"},
+	}}}
+	c, err := New(store, dir, keyfile, true)
+	require.NoError(t, err)
+
+	got, err := c.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"DB_PASS": "hunter2"}, got)
+	_, stale := c.StaleSince("app")
+	assert.False(t, stale)
+
+	// entries are not stored in plain text
+	entries, err := ioutil.ReadDir(filepath.Join(dir, Directory))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	b, err := ioutil.ReadFile(filepath.Join(dir, Directory, entries[0].Name()))
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "hunter2")
+
+	store.down = true
+	got, err = c.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"DB_PASS": "hunter2"}, got)
+	_, stale = c.StaleSince("app")
+	assert.True(t, stale)
+
+	_, err = c.GetSecretsForTarget("other")
+	assert.EqualError(t, err, "connection refused")
+
+	// a store that denies access isn't worked around
+	store.denied = true
+	_, err = c.GetSecretsForTarget("app")
+	assert.EqualError(t, err, "permission denied")
+	store.denied = false
+
+	// a cache with a different key can't read the entries
+	require.NoError(t, ioutil.WriteFile(keyfile, []byte(strings.Repeat("x", 32)), 0o600))
+	c, err = New(store, dir, keyfile, true)
+	require.NoError(t, err)
+	_, err = c.GetSecretsForTarget("app")
+	assert.EqualError(t, err, "connection refused")
+
+	assert.NoError(t, Wipe(dir))
+	_, err = os.Stat(filepath.Join(dir, Directory))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCacheNotAllowed(t *testing.T) {
+	dir, keyfile := setup(t)
+	defer os.RemoveAll(dir)
+
+	store := &flakyStore{MemorySecrets: memory.MemorySecrets{Secrets: map[string]map[string]string{
+		"app": {"DB_PASS": "hunter2"},
+	}}}
+	c, err := New(store, dir, keyfile, false)
+	require.NoError(t, err)
+
+	_, err = c.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+
+	store.down = true
+	_, err = c.GetSecretsForTarget("app")
+	assert.EqualError(t, err, "connection refused")
+}
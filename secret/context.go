@@ -0,0 +1,36 @@
+package secret
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+// ContextStore is implemented by stores that log their reads with the logger
+// of a context, so the lines of a read made for a task carry its ID.
+type ContextStore interface {
+	GetSecretsForTargetContext(ctx context.Context, name string) (map[string]string, error)
+}
+
+// WithLogger returns a context that makes stores log with log
+func WithLogger(ctx context.Context, log *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// Logger returns the logger of ctx, or the global logger if it has none
+func Logger(ctx context.Context) *zap.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return log
+	}
+	return zap.L()
+}
+
+// Get reads the secrets for name from s, with ctx if s is a ContextStore
+func Get(ctx context.Context, s Store, name string) (map[string]string, error) {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.GetSecretsForTargetContext(ctx, name)
+	}
+	return s.GetSecretsForTarget(name)
+}
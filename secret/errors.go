@@ -0,0 +1,35 @@
+package secret
+
+import "github.com/pkg/errors"
+
+var (
+	// ErrSecretUnavailable is matched by errors of stores that could not be
+	// reached, such as network failures or server errors, which may succeed
+	// when retried.
+	ErrSecretUnavailable = errors.New("secret store unavailable")
+
+	// ErrSecretDenied is matched by errors of stores that refused the request
+	// because Pico's credentials are invalid or lack permission.
+	ErrSecretDenied = errors.New("secret store denied access")
+)
+
+// StoreError is a failure of a secret store classified as one of the errors
+// above, errors.Is matches both the kind and the underlying cause.
+type StoreError struct {
+	Kind error
+	Err  error
+}
+
+func (e *StoreError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying cause
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of the error
+func (e *StoreError) Is(target error) bool {
+	return target == e.Kind
+}
@@ -0,0 +1,111 @@
+// Package file implements a secret.Store backed by a directory tree, with one
+// directory per target and one file per secret, the same layout as Docker and
+// Kubernetes secret mounts:
+//
+//	/etc/pico/secrets/<target>/<KEY>
+//	/etc/pico/secrets/global/<KEY>
+//
+// The global directory holds the secrets for Pico's configuration path. Files
+// are read on every call, so changes take effect on the next deployment.
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/task"
+)
+
+// GlobalDirectory holds the secrets for the configuration path
+const GlobalDirectory = "global"
+
+// FileSecrets implements a secret.Store backed by files
+type FileSecrets struct {
+	root       string
+	configPath string
+}
+
+var _ secret.Store = &FileSecrets{}
+
+// New creates a store reading from root, secrets for configPath are read from
+// the global directory. A root directory that anyone can read is allowed but
+// warned about.
+func New(root, configPath string) (*FileSecrets, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open secrets directory")
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("secrets path %s is not a directory", root)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o004 != 0 {
+		zap.L().Warn("secrets directory is world-readable, restrict it with chmod o-rwx",
+			zap.String("directory", root),
+			zap.String("mode", info.Mode().Perm().String()))
+	}
+
+	return &FileSecrets{root: root, configPath: configPath}, nil
+}
+
+// GetSecretsForTarget implements secret.Store
+func (f *FileSecrets) GetSecretsForTarget(name string) (map[string]string, error) {
+	dir := name
+	if name == f.configPath {
+		dir = GlobalDirectory
+	} else if err := task.ValidateName(name); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(f.root, dir)
+
+	entries, err := ioutil.ReadDir(path)
+	if os.IsNotExist(err) {
+		zap.L().Debug("no secrets directory for target", zap.String("name", name), zap.String("path", path))
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read secrets directory")
+	}
+
+	env := make(map[string]string)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		// symlinks are followed, secret mounts are often links to the data
+		file := filepath.Join(path, e.Name())
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read secret %s", e.Name())
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read secret %s", e.Name())
+		}
+		env[e.Name()] = trimNewline(string(b))
+	}
+
+	zap.L().Debug("found secrets in directory",
+		zap.String("name", name),
+		zap.String("path", path),
+		zap.Int("secrets", len(env)))
+
+	return env, nil
+}
+
+// trimNewline removes a single trailing newline, which editors usually add
+func trimNewline(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		s = strings.TrimSuffix(s, "\n")
+		s = strings.TrimSuffix(s, "\r")
+	}
+	return s
+}
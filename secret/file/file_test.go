@@ -0,0 +1,57 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func write(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestGetSecretsForTarget(t *testing.T) {
+	root, err := ioutil.TempDir("", "pico-secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	write(t, filepath.Join(root, "app", "DB_URL"), "postgres://db\n")
+	write(t, filepath.Join(root, "app", "MULTILINE"), "one\ntwo\n\n")
+	write(t, filepath.Join(root, "app", "CRLF"), "value\r\n")
+	write(t, filepath.Join(root, "app", ".hidden"), "x")
+	write(t, filepath.Join(root, "app", "nested", "KEY"), "x")
+	write(t, filepath.Join(root, GlobalDirectory, "GLOBAL_TOKEN"), "token")
+
+	s, err := New(root, "pico")
+	require.NoError(t, err)
+
+	got, err := s.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_URL":    "postgres://db",
+		"MULTILINE": "one\ntwo\n",
+		"CRLF":      "value",
+	}, got)
+
+	got, err = s.GetSecretsForTarget("pico")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"GLOBAL_TOKEN": "token"}, got)
+
+	got, err = s.GetSecretsForTarget("missing")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	_, err = s.GetSecretsForTarget("../app")
+	assert.Error(t, err)
+
+	// changes are picked up without creating a new store
+	write(t, filepath.Join(root, "app", "DB_URL"), "postgres://other")
+	got, err = s.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, "postgres://other", got["DB_URL"])
+}
@@ -0,0 +1,210 @@
+// Package gcp implements a secret.Store backed by Google Cloud Secret Manager.
+// Secret Manager has a flat namespace per project so secrets for a target are
+// named with a common prefix, the target name and the key, separated by a
+// double underscore: with the prefix "pico-", the key DB_URL of the target app
+// is stored in the secret "pico-app__DB_URL". The latest version is always used.
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/secret"
+)
+
+const endpoint = "https://secretmanager.googleapis.com/v1"
+
+// workers is the number of secrets fetched concurrently, Secret Manager needs
+// one call per secret
+const workers = 8
+
+// separator joins the target name and the key in a secret's name
+const separator = "__"
+
+// SecretManagerSecrets implements a secret.Store backed by Secret Manager
+type SecretManagerSecrets struct {
+	endpoint string
+	project  string
+	prefix   string
+	client   *http.Client
+}
+
+var _ secret.Store = &SecretManagerSecrets{}
+
+// New creates a store for secrets in the given project whose names start with
+// prefix, authenticating with Application Default Credentials.
+func New(project, prefix string) (*SecretManagerSecrets, error) {
+	if project == "" {
+		return nil, errors.New("no project ID specified for secret manager")
+	}
+
+	ts, err := google.DefaultTokenSource(context.Background(), "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find application default credentials")
+	}
+
+	return &SecretManagerSecrets{
+		endpoint: endpoint,
+		project:  project,
+		prefix:   prefix,
+		client: &http.Client{Transport: &oauth2.Transport{
+			Source: ts,
+			Base:   buildinfo.Transport(nil),
+		}},
+	}, nil
+}
+
+// GetSecretsForTarget implements secret.Store. Secrets that can't be accessed
+// due to missing permissions are logged and left out, rather than failing the
+// whole target.
+func (s *SecretManagerSecrets) GetSecretsForTarget(name string) (map[string]string, error) {
+	ctx := context.Background()
+	prefix := s.prefix + name + separator
+
+	ids, err := s.list(ctx, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list secrets")
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	type result struct {
+		key   string
+		value string
+		err   error
+	}
+	jobs := make(chan string)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(ids); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				value, err := s.access(ctx, id)
+				results <- result{strings.TrimPrefix(id, prefix), value, err}
+			}
+		}()
+	}
+	go func() {
+		for _, id := range ids {
+			jobs <- id
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	env := make(map[string]string)
+	var failed error
+	for r := range results {
+		switch {
+		case r.err == nil:
+			env[r.key] = r.value
+		case errors.Is(r.err, errPermissionDenied):
+			zap.L().Warn("permission denied accessing secret, it will not be set",
+				zap.String("target", name),
+				zap.String("key", r.key))
+		case failed == nil:
+			failed = errors.Wrapf(r.err, "failed to access secret %s", r.key)
+		}
+	}
+	if failed != nil {
+		return nil, failed
+	}
+
+	zap.L().Debug("found secrets in secret manager",
+		zap.String("name", name),
+		zap.Int("secrets", len(env)))
+
+	return env, nil
+}
+
+var errPermissionDenied = errors.New("permission denied")
+
+// list returns the IDs of all secrets whose name starts with prefix. The list
+// filter matches substrings, so names are checked for the prefix as well.
+func (s *SecretManagerSecrets) list(ctx context.Context, prefix string) ([]string, error) {
+	var ids []string
+	token := ""
+	for {
+		q := url.Values{
+			"filter":   {"name:" + prefix},
+			"pageSize": {"250"},
+		}
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+		var page struct {
+			Secrets []struct {
+				Name string `json:"name"`
+			} `json:"secrets"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := s.get(ctx, s.endpoint+"/projects/"+url.PathEscape(s.project)+"/secrets?"+q.Encode(), &page); err != nil {
+			return nil, err
+		}
+		for _, secret := range page.Secrets {
+			id := secret.Name[strings.LastIndex(secret.Name, "/")+1:]
+			if strings.HasPrefix(id, prefix) && len(id) > len(prefix) {
+				ids = append(ids, id)
+			}
+		}
+		if page.NextPageToken == "" {
+			return ids, nil
+		}
+		token = page.NextPageToken
+	}
+}
+
+// access reads the latest version of a secret
+func (s *SecretManagerSecrets) access(ctx context.Context, id string) (string, error) {
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	path := "/projects/" + url.PathEscape(s.project) + "/secrets/" + url.PathEscape(id) + "/versions/latest:access"
+	if err := s.get(ctx, s.endpoint+path, &version); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode secret payload")
+	}
+	return string(data), nil
+}
+
+func (s *SecretManagerSecrets) get(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "failed to decode secret manager response")
+	case http.StatusForbidden:
+		return errPermissionDenied
+	default:
+		return errors.Errorf("secret manager responded with %s", resp.Status)
+	}
+}
@@ -0,0 +1,74 @@
+package gcp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSecretsForTarget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/projects/proj/secrets":
+			assert.Equal(t, "name:pico-app__", r.URL.Query().Get("filter"))
+			if r.URL.Query().Get("pageToken") == "" {
+				json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+					"secrets": []map[string]string{
+						{"name": "projects/1/secrets/pico-app__DB_URL"},
+						{"name": "projects/1/secrets/old-pico-app__DB_URL"},
+					},
+					"nextPageToken": "next",
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+				"secrets": []map[string]string{
+					{"name": "projects/1/secrets/pico-app__DENIED"},
+					{"name": "projects/1/secrets/pico-app__API_KEY"},
+				},
+			})
+		case strings.HasSuffix(r.URL.Path, "pico-app__DENIED/versions/latest:access"):
+			w.WriteHeader(http.StatusForbidden)
+		case strings.HasSuffix(r.URL.Path, "/versions/latest:access"):
+			id := strings.Split(r.URL.Path, "/")[4]
+			json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+				"payload": map[string]string{"data": base64.StdEncoding.EncodeToString([]byte("value of " + id))},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	s := &SecretManagerSecrets{endpoint: srv.URL, project: "proj", prefix: "pico-", client: srv.Client()}
+
+	got, err := s.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_URL":  "value of pico-app__DB_URL",
+		"API_KEY": "value of pico-app__API_KEY",
+	}, got)
+}
+
+func TestGetSecretsForTargetFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/projects/proj/secrets" {
+			json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+				"secrets": []map[string]string{{"name": "projects/1/secrets/app__KEY"}},
+			})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	s := &SecretManagerSecrets{endpoint: srv.URL, project: "proj", client: srv.Client()}
+
+	_, err := s.GetSecretsForTarget("app")
+	assert.EqualError(t, err, "failed to access secret KEY: secret manager responded with 500 Internal Server Error")
+}
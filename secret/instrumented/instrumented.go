@@ -0,0 +1,141 @@
+// Package instrumented provides a secret.Store decorator that records how long
+// reads from any backend take and how often they fail, as Prometheus metrics
+// and debug logs. Only key names are ever logged, never values.
+package instrumented
+
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/secret"
+)
+
+// Metrics are the collectors shared by every instrumented store, create them
+// once per registry with NewMetrics.
+type Metrics struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	keys     *prometheus.GaugeVec
+}
+
+// NewMetrics creates and registers the secret store metrics
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pico",
+			Name:      "secret_read_duration_seconds",
+			Help:      "Duration of secret store reads by backend and result.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}, []string{"backend", "result"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pico",
+			Name:      "secret_read_errors_total",
+			Help:      "Number of failed secret store reads by backend and error class.",
+		}, []string{"backend", "class"}),
+		keys: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "pico",
+			Name:      "secret_keys",
+			Help:      "Number of keys returned by the last secret store read by backend and path.",
+		}, []string{"backend", "path"}),
+	}
+	for _, c := range []prometheus.Collector{m.duration, m.errors, m.keys} {
+		if err := reg.Register(c); err != nil {
+			return nil, errors.Wrap(err, "failed to register secret store metrics")
+		}
+	}
+	return m, nil
+}
+
+// InstrumentedSecrets implements a secret.Store that records metrics about the
+// reads of the store it wraps.
+type InstrumentedSecrets struct {
+	store   secret.Store
+	backend string
+	metrics *Metrics
+}
+
+var (
+	_ secret.Store        = &InstrumentedSecrets{}
+	_ secret.Wrapper      = &InstrumentedSecrets{}
+	_ secret.ContextStore = &InstrumentedSecrets{}
+)
+
+// New wraps a store, the backend name is used as a metric label
+func New(store secret.Store, backend string, m *Metrics) *InstrumentedSecrets {
+	return &InstrumentedSecrets{
+		store:   store,
+		backend: backend,
+		metrics: m,
+	}
+}
+
+// Unwrap implements secret.Wrapper
+func (s *InstrumentedSecrets) Unwrap() secret.Store {
+	return s.store
+}
+
+// GetSecretsForTarget implements secret.Store
+func (s *InstrumentedSecrets) GetSecretsForTarget(name string) (map[string]string, error) {
+	return s.GetSecretsForTargetContext(context.Background(), name)
+}
+
+// GetSecretsForTargetContext implements secret.ContextStore
+func (s *InstrumentedSecrets) GetSecretsForTargetContext(ctx context.Context, name string) (map[string]string, error) {
+	start := time.Now()
+	secrets, err := secret.Get(ctx, s.store, name)
+	duration := time.Since(start)
+
+	if err != nil {
+		class := Classify(err)
+		s.metrics.duration.WithLabelValues(s.backend, "failure").Observe(duration.Seconds())
+		s.metrics.errors.WithLabelValues(s.backend, class).Inc()
+		secret.Logger(ctx).Debug("secret store read failed",
+			zap.String("backend", s.backend),
+			zap.String("path", name),
+			zap.Duration("duration", duration),
+			zap.String("class", class),
+			zap.Error(err))
+		return nil, err
+	}
+
+	s.metrics.duration.WithLabelValues(s.backend, "success").Observe(duration.Seconds())
+	s.metrics.keys.WithLabelValues(s.backend, name).Set(float64(len(secrets)))
+	secret.Logger(ctx).Debug("read secrets from secret store",
+		zap.String("backend", s.backend),
+		zap.String("path", name),
+		zap.Duration("duration", duration),
+		zap.Strings("keys", keys(secrets)))
+
+	return secrets, nil
+}
+
+// Classify returns a coarse class of a secret store error for use as a metric
+// label: "timeout", "network" or "other".
+func Classify(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		if ne.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return "other"
+}
+
+func keys(secrets map[string]string) []string {
+	out := make([]string, 0, len(secrets))
+	for k := range secrets {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,52 @@
+package instrumented
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/secret/memory"
+)
+
+type failing struct{ err error }
+
+func (f failing) GetSecretsForTarget(string) (map[string]string, error) { return nil, f.err }
+
+func TestInstrumentedSecrets(t *testing.T) {
+	m, err := NewMetrics(prometheus.NewRegistry())
+	assert.NoError(t, err)
+
+	store := memory.New(map[string]map[string]string{"app": {"A": "1", "B": "2"}})
+	s := New(store, "memory", m)
+	assert.Equal(t, secret.Store(store), secret.Base(s))
+
+	got, err := s.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"A": "1", "B": "2"}, got)
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.keys.WithLabelValues("memory", "app")))
+
+	f := New(failing{errors.New("denied")}, "vault", m)
+	_, err = f.GetSecretsForTarget("app")
+	assert.EqualError(t, err, "denied")
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.errors.WithLabelValues("vault", "other")))
+}
+
+func TestClassify(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{errors.New("denied"), "other"},
+		{errors.Wrap(context.DeadlineExceeded, "read"), "timeout"},
+		{&url.Error{Op: "Get", URL: "http://vault", Err: &net.OpError{Op: "dial", Err: errors.New("refused")}}, "network"},
+	} {
+		assert.Equal(t, tt.want, Classify(tt.err), tt.err.Error())
+	}
+}
@@ -0,0 +1,272 @@
+// Package kubernetes implements a secret.Store backed by Kubernetes Secrets in
+// a single namespace, for when Pico runs inside a cluster. A target's secrets
+// are read from the Secret named pico-<target> and from every Secret labelled
+// pico.target=<target>, in that order, later Secrets overriding earlier keys.
+//
+// The store uses the API server's REST interface with the pod's service
+// account, see the secret package.
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/secret"
+)
+
+// serviceAccount is where the pod's service account credentials are mounted
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// LabelKey is the label that assigns a Secret to a target
+const LabelKey = "pico.target"
+
+// NamePrefix is prepended to a target's name to find its Secret
+const NamePrefix = "pico-"
+
+// KubernetesSecrets implements a secret.Store backed by Kubernetes Secrets
+type KubernetesSecrets struct {
+	server    string
+	namespace string
+	token     *tokenFile
+	client    *http.Client
+	backoff   time.Duration // the first delay before retrying a failed watch
+}
+
+// tokenFile is the service account token, read again whenever the file
+// changes since projected tokens are rotated while the pod runs.
+type tokenFile struct {
+	path string
+
+	mu       sync.Mutex
+	token    string
+	modified time.Time
+}
+
+func newTokenFile(path string) (*tokenFile, error) {
+	f := &tokenFile{path: path}
+	if err := f.read(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// get returns the current token. If the file can't be read, the token read
+// last is returned, the request fails instead if it's no longer valid.
+func (f *tokenFile) get() string {
+	if f == nil {
+		return ""
+	}
+	if f.path != "" {
+		if err := f.read(); err != nil {
+			zap.L().Warn("failed to read service account token again", zap.Error(err))
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.token
+}
+
+func (f *tokenFile) read() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read service account token")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if info.ModTime().Equal(f.modified) && f.token != "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read service account token")
+	}
+	f.token = strings.TrimSpace(string(b))
+	f.modified = info.ModTime()
+	return nil
+}
+
+var (
+	_ secret.Store          = &KubernetesSecrets{}
+	_ secret.ChangeNotifier = &KubernetesSecrets{}
+)
+
+// New creates a store using the in-cluster configuration. If namespace is
+// empty, the pod's own namespace is used. Missing permissions are reported as a
+// warning rather than an error, since they may be granted later.
+func New(namespace string) (*KubernetesSecrets, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	token, err := newTokenFile(serviceAccount + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccount + "/ca.crt")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read service account CA certificate")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("service account CA certificate is invalid")
+	}
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(serviceAccount + "/namespace")
+		if err != nil {
+			return nil, errors.Wrap(err, "no namespace specified and failed to read the pod's namespace")
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	k := &KubernetesSecrets{
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		token:     token,
+		client:    &http.Client{Transport: buildinfo.Transport(transport)},
+		backoff:   watchBackoff,
+	}
+	k.checkAccess()
+
+	return k, nil
+}
+
+// GetSecretsForTarget implements secret.Store
+func (k *KubernetesSecrets) GetSecretsForTarget(name string) (map[string]string, error) {
+	ctx := context.Background()
+
+	var named secretObject
+	found, err := k.get(ctx, k.path("/"+url.PathEscape(NamePrefix+name)), &named)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read secret %s%s", NamePrefix, name)
+	}
+
+	var labelled secretList
+	if _, err := k.get(ctx, k.path("?labelSelector="+url.QueryEscape(LabelKey+"="+name)), &labelled); err != nil {
+		return nil, errors.Wrap(err, "failed to list labelled secrets")
+	}
+	sort.Slice(labelled.Items, func(i, j int) bool {
+		return labelled.Items[i].Metadata.Name < labelled.Items[j].Metadata.Name
+	})
+
+	var env map[string]string
+	objects := labelled.Items
+	if found {
+		objects = append([]secretObject{named}, objects...)
+	}
+	for _, o := range objects {
+		for key, value := range o.Data {
+			if env == nil {
+				env = make(map[string]string)
+			}
+			env[key] = string(value)
+		}
+	}
+
+	zap.L().Debug("found secrets in kubernetes",
+		zap.String("name", name),
+		zap.Int("objects", len(objects)),
+		zap.Int("secrets", len(env)))
+
+	return env, nil
+}
+
+type secretObject struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Labels          map[string]string `json:"labels"`
+		ResourceVersion string            `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string][]byte `json:"data"` // values are base64 which []byte decodes
+}
+
+type secretList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []secretObject `json:"items"`
+}
+
+// target returns the name of the target a Secret belongs to, if any
+func (o secretObject) target() string {
+	if t := o.Metadata.Labels[LabelKey]; t != "" {
+		return t
+	}
+	return strings.TrimPrefix(o.Metadata.Name, NamePrefix)
+}
+
+func (o secretObject) relevant() bool {
+	return o.Metadata.Labels[LabelKey] != "" || strings.HasPrefix(o.Metadata.Name, NamePrefix)
+}
+
+// checkAccess warns about the permissions the service account is missing
+func (k *KubernetesSecrets) checkAccess() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var list secretList
+	_, err := k.get(ctx, k.path("?limit=1"), &list)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		zap.L().Warn("the service account can't read secrets, grant it a Role with verbs [get, list, watch] on resource \"secrets\" in the namespace",
+			zap.String("namespace", k.namespace))
+		return
+	}
+	zap.L().Warn("failed to check access to kubernetes secrets", zap.Error(err))
+}
+
+var errForbidden = errors.New("forbidden")
+
+func (k *KubernetesSecrets) path(suffix string) string {
+	return k.server + "/api/v1/namespaces/" + url.PathEscape(k.namespace) + "/secrets" + suffix
+}
+
+func (k *KubernetesSecrets) request(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token.get())
+	req.Header.Set("Accept", "application/json")
+	return k.client.Do(req.WithContext(ctx))
+}
+
+// get decodes the object at u into v, found is false if it doesn't exist
+func (k *KubernetesSecrets) get(ctx context.Context, u string, v interface{}) (found bool, err error) {
+	resp, err := k.request(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "failed to decode kubernetes response")
+	case http.StatusNotFound:
+		return false, nil
+	case http.StatusForbidden:
+		return false, errors.Wrapf(errForbidden, "service account is not allowed to read secrets in namespace %s", k.namespace)
+	default:
+		return false, errors.Errorf("kubernetes API responded with %s", resp.Status)
+	}
+}
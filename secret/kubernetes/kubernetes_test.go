@@ -0,0 +1,143 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func secretJSON(name string, labels map[string]string, data map[string]string) map[string]interface{} {
+	d := make(map[string][]byte)
+	for k, v := range data {
+		d[k] = []byte(v)
+	}
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "labels": labels, "resourceVersion": "2"},
+		"data":     d,
+	}
+}
+
+func TestGetSecretsForTarget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/v1/namespaces/edge/secrets/pico-app":
+			json.NewEncoder(w).Encode(secretJSON("pico-app", nil, map[string]string{"DB_URL": "named", "USER": "admin"})) //nolint:errcheck
+		case "/api/v1/namespaces/edge/secrets":
+			assert.Equal(t, "pico.target=app", r.URL.Query().Get("labelSelector"))
+			json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+				"items": []interface{}{
+					secretJSON("b", map[string]string{LabelKey: "app"}, map[string]string{"DB_URL": "labelled"}),
+					secretJSON("a", map[string]string{LabelKey: "app"}, map[string]string{"DB_URL": "first", "TOKEN": "t"}),
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	k := &KubernetesSecrets{server: srv.URL, namespace: "edge", token: &tokenFile{token: "token"}, client: srv.Client()}
+
+	got, err := k.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"DB_URL": "labelled", "USER": "admin", "TOKEN": "t"}, got)
+}
+
+func TestGetSecretsForTargetForbidden(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	k := &KubernetesSecrets{server: srv.URL, namespace: "edge", client: srv.Client()}
+
+	_, err := k.GetSecretsForTarget("app")
+	assert.EqualError(t, err, "failed to read secret pico-app: service account is not allowed to read secrets in namespace edge: forbidden")
+}
+
+func TestWatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("watch"))
+		assert.Equal(t, "1", r.URL.Query().Get("resourceVersion"))
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]interface{}{"type": "MODIFIED", "object": secretJSON("pico-app", nil, nil)})                           //nolint:errcheck
+		enc.Encode(map[string]interface{}{"type": "ADDED", "object": secretJSON("unrelated", nil, nil)})                             //nolint:errcheck
+		enc.Encode(map[string]interface{}{"type": "DELETED", "object": secretJSON("db", map[string]string{LabelKey: "other"}, nil)}) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	k := &KubernetesSecrets{server: srv.URL, namespace: "edge", client: srv.Client()}
+
+	var changed []string
+	version, err := k.watch(context.Background(), "1", func(target string) { changed = append(changed, target) })
+	assert.NoError(t, err)
+	assert.Equal(t, "2", version)
+	assert.Equal(t, []string{"app", "other"}, changed)
+}
+
+func TestWatchChangesRetriesList(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	var lists int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "true" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"type": "MODIFIED", "object": secretJSON("pico-app", nil, nil)}) //nolint:errcheck
+			return
+		}
+		if atomic.AddInt32(&lists, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"metadata": map[string]string{"resourceVersion": "1"}}) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	k := &KubernetesSecrets{server: srv.URL, namespace: "edge", client: srv.Client(), backoff: time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var changed string
+	err := k.WatchChanges(ctx, func(target string) {
+		changed = target
+		cancel()
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, "app", changed)
+	assert.Equal(t, 2, logs.FilterMessage("failed to list kubernetes secrets to watch, retrying").Len())
+}
+
+func TestTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-kube")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(path, []byte("first\n"), 0600))
+
+	f, err := newTokenFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "first", f.get())
+
+	// the kubelet rotates projected tokens by replacing the file
+	require.NoError(t, ioutil.WriteFile(path, []byte("second\n"), 0600))
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(path, later, later))
+	assert.Equal(t, "second", f.get())
+
+	// the last token is kept if the file disappears
+	require.NoError(t, os.Remove(path))
+	assert.Equal(t, "second", f.get())
+}
@@ -0,0 +1,124 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// watchBackoff is the delay before retrying a failed list or watch, it
+	// doubles with every failure in a row up to watchMaxBackoff.
+	watchBackoff    = 5 * time.Second
+	watchMaxBackoff = 5 * time.Minute
+)
+
+// WatchChanges implements secret.ChangeNotifier. It watches the namespace for
+// changes to Secrets that belong to targets and calls changed with the name of
+// the affected target. Failed lists and watches are retried with a backoff, it
+// blocks until the context is cancelled.
+func (k *KubernetesSecrets) WatchChanges(ctx context.Context, changed func(target string)) error {
+	backoff := k.backoff
+	retry := func(msg string, err error) error {
+		zap.L().Warn(msg, zap.Duration("retry_in", backoff), zap.Error(err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > watchMaxBackoff {
+			backoff = watchMaxBackoff
+		}
+		return nil
+	}
+
+	for {
+		version, err := k.resourceVersion(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if err := retry("failed to list kubernetes secrets to watch, retrying", err); err != nil {
+				return err
+			}
+			continue
+		}
+		backoff = k.backoff
+
+		// watch until the server ends the stream or the version expires, then
+		// list again to resume from the current version.
+		for version != "" {
+			version, err = k.watch(ctx, version, changed)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				if err := retry("kubernetes secret watch failed, retrying", err); err != nil {
+					return err
+				}
+				continue
+			}
+			backoff = k.backoff
+		}
+	}
+}
+
+func (k *KubernetesSecrets) resourceVersion(ctx context.Context) (string, error) {
+	var list secretList
+	if _, err := k.get(ctx, k.path("?limit=1"), &list); err != nil {
+		return "", err
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch streams events from the given version and returns the version to resume
+// from, or an empty version if the watch must be restarted from a fresh list.
+func (k *KubernetesSecrets) watch(ctx context.Context, version string, changed func(string)) (string, error) {
+	resp, err := k.request(ctx, k.path("?watch=true&allowWatchBookmarks=true&resourceVersion="+url.QueryEscape(version)))
+	if err != nil {
+		return version, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return "", nil
+	default:
+		return version, errors.Errorf("kubernetes API responded with %s", resp.Status)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string       `json:"type"`
+			Object secretObject `json:"object"`
+		}
+		if err := dec.Decode(&event); err != nil {
+			// the server closes watches periodically, resume from the last event
+			return version, nil
+		}
+		switch event.Type {
+		case "ERROR":
+			// usually an expired resource version
+			return "", nil
+		case "BOOKMARK":
+		case "ADDED", "MODIFIED", "DELETED":
+			if event.Object.relevant() {
+				zap.L().Info("target secrets changed",
+					zap.String("secret", event.Object.Metadata.Name),
+					zap.String("target", event.Object.target()),
+					zap.String("event", event.Type))
+				changed(event.Object.target())
+			}
+		}
+		if v := event.Object.Metadata.ResourceVersion; v != "" {
+			version = v
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/picostack/pico/secret"
+)
+
+// Layered implements a secret.Store that overrides the secrets of another
+// store with those of a memory store.
+type Layered struct {
+	base secret.Store
+	top  *MemorySecrets
+}
+
+var (
+	_ secret.Store        = &Layered{}
+	_ secret.Wrapper      = &Layered{}
+	_ secret.ContextStore = &Layered{}
+)
+
+// Layer creates a store that reads from base and then top, secrets in top take
+// precedence.
+func Layer(base secret.Store, top *MemorySecrets) *Layered {
+	return &Layered{base: base, top: top}
+}
+
+// Unwrap implements secret.Wrapper
+func (l *Layered) Unwrap() secret.Store {
+	return l.base
+}
+
+// GetSecretsForTarget implements secret.Store
+func (l *Layered) GetSecretsForTarget(name string) (map[string]string, error) {
+	return l.GetSecretsForTargetContext(context.Background(), name)
+}
+
+// GetSecretsForTargetContext implements secret.ContextStore
+func (l *Layered) GetSecretsForTargetContext(ctx context.Context, name string) (map[string]string, error) {
+	secrets, err := secret.Get(ctx, l.base, name)
+	if err != nil {
+		return nil, err
+	}
+	top, _ := l.top.GetSecretsForTarget(name) //nolint:errcheck - never fails
+	if len(top) == 0 {
+		return secrets, nil
+	}
+	out := make(map[string]string, len(secrets)+len(top))
+	for k, v := range secrets {
+		out[k] = v
+	}
+	for k, v := range top {
+		out[k] = v
+	}
+	return out, nil
+}
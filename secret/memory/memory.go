@@ -1,21 +1,86 @@
 package memory
 
 import (
+	"sync"
+
 	"github.com/picostack/pico/secret"
 )
 
-// MemorySecrets implements a simple in-memory secret.Store for testing
+// MemorySecrets implements a simple in-memory secret.Store for testing and for
+// secrets passed on the command line. It's safe for concurrent use.
 type MemorySecrets struct {
 	Secrets map[string]map[string]string
+
+	mu sync.RWMutex
 }
 
 var _ secret.Store = &MemorySecrets{}
 
+// New creates a store seeded with a copy of the given secrets, keyed by target
+func New(secrets map[string]map[string]string) *MemorySecrets {
+	m := &MemorySecrets{}
+	for target, table := range secrets {
+		for k, v := range table {
+			m.Set(target, k, v)
+		}
+	}
+	return m
+}
+
 // GetSecretsForTarget implements secret.Store
 func (v *MemorySecrets) GetSecretsForTarget(name string) (map[string]string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
 	table, ok := v.Secrets[name]
 	if !ok {
 		return nil, nil
 	}
-	return table, nil
+	return copyTable(table), nil
+}
+
+// Set stores a secret for a target
+func (v *MemorySecrets) Set(target, key, value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.Secrets == nil {
+		v.Secrets = make(map[string]map[string]string)
+	}
+	if v.Secrets[target] == nil {
+		v.Secrets[target] = make(map[string]string)
+	}
+	v.Secrets[target][key] = value
+}
+
+// Delete removes a secret from a target, the target is removed with its last
+// secret.
+func (v *MemorySecrets) Delete(target, key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.Secrets[target], key)
+	if len(v.Secrets[target]) == 0 {
+		delete(v.Secrets, target)
+	}
+}
+
+// Snapshot returns a copy of every secret by target, for assertions in tests
+func (v *MemorySecrets) Snapshot() map[string]map[string]string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	out := make(map[string]map[string]string, len(v.Secrets))
+	for target, table := range v.Secrets {
+		out[target] = copyTable(table)
+	}
+	return out
+}
+
+func copyTable(table map[string]string) map[string]string {
+	out := make(map[string]string, len(table))
+	for k, v := range table {
+		out[k] = v
+	}
+	return out
 }
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySecrets(t *testing.T) {
+	seed := map[string]map[string]string{"app": {"A": "1"}}
+	m := New(seed)
+	seed["app"]["A"] = "changed"
+
+	m.Set("app", "B", "2")
+	m.Set("other", "C", "3")
+	assert.Equal(t, map[string]map[string]string{
+		"app":   {"A": "1", "B": "2"},
+		"other": {"C": "3"},
+	}, m.Snapshot())
+
+	got, err := m.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	got["A"] = "modified"
+	got, _ = m.GetSecretsForTarget("app")
+	assert.Equal(t, "1", got["A"])
+
+	m.Delete("other", "C")
+	m.Delete("missing", "C")
+	got, err = m.GetSecretsForTarget("other")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestMemorySecretsConcurrent(t *testing.T) {
+	m := &MemorySecrets{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Set("app", fmt.Sprint(i), fmt.Sprint(j))
+				m.GetSecretsForTarget("app") //nolint:errcheck
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, m.Snapshot()["app"], 10)
+}
+
+func TestLayer(t *testing.T) {
+	base := New(map[string]map[string]string{"app": {"A": "base", "B": "base"}})
+	top := New(map[string]map[string]string{"app": {"B": "top"}, "other": {"C": "top"}})
+	l := Layer(base, top)
+
+	got, err := l.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"A": "base", "B": "top"}, got)
+
+	got, err = l.GetSecretsForTarget("other")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"C": "top"}, got)
+}
@@ -1,18 +1,87 @@
 // Package secret provides an interface and implementations for secret storage.
 // A secret store is passed to the executor, which hydrates execution tasks with
 // any secrets that match it.
+//
+// Stores whose official client libraries, such as the Azure SDK and client-go,
+// require a newer Go release than Pico is built with talk to their service's
+// REST API directly instead.
 package secret
 
-import "strings"
+import (
+	"context"
+	"strings"
+	"time"
+)
 
 // Store describes a type that can securely obtain secrets for services.
 type Store interface {
 	GetSecretsForTarget(name string) (map[string]string, error)
 }
 
+// ChangeNotifier is implemented by stores that can report when the secrets of a
+// target change, so the target can be redeployed with its new secrets.
+type ChangeNotifier interface {
+	WatchChanges(ctx context.Context, changed func(target string)) error
+}
+
+// DynamicPrefix marks a reference to credentials that are issued on demand,
+// such as vault-dynamic:database/creds/app
+const DynamicPrefix = "vault-dynamic:"
+
+// DynamicStore is implemented by stores that can issue short-lived credentials
+// for each task, the credentials are leased and can be revoked early.
+type DynamicStore interface {
+	IssueCredentials(ctx context.Context, path string) (Credentials, error)
+	RevokeLease(ctx context.Context, leaseID string) error
+}
+
+// Credentials are issued by a DynamicStore
+type Credentials struct {
+	LeaseID string
+	TTL     time.Duration
+	Data    map[string]string
+}
+
+// Wrapper is implemented by stores that decorate another store, such as a
+// cache. Capabilities like DynamicStore are implemented by the wrapped store.
+type Wrapper interface {
+	Unwrap() Store
+}
+
+// Base returns the innermost store of a chain of wrappers
+func Base(s Store) Store {
+	for {
+		w, ok := s.(Wrapper)
+		if !ok {
+			return s
+		}
+		s = w.Unwrap()
+	}
+}
+
+// StaleReporter is implemented by stores that may serve secrets that are out
+// of date, it reports when the secrets last returned for name were fetched.
+type StaleReporter interface {
+	StaleSince(name string) (time.Time, bool)
+}
+
+// FindStaleReporter returns the first StaleReporter in a chain of wrappers
+func FindStaleReporter(s Store) (StaleReporter, bool) {
+	for {
+		if sr, ok := s.(StaleReporter); ok {
+			return sr, true
+		}
+		w, ok := s.(Wrapper)
+		if !ok {
+			return nil, false
+		}
+		s = w.Unwrap()
+	}
+}
+
 // GetPrefixedSecrets uses a Store to get a set of secrets that use a prefix.
-func GetPrefixedSecrets(s Store, path, prefix string) (map[string]string, error) {
-	all, err := s.GetSecretsForTarget(path)
+func GetPrefixedSecrets(ctx context.Context, s Store, path, prefix string) (map[string]string, error) {
+	all, err := Get(ctx, s, path)
 	if err != nil {
 		return nil, err
 	}
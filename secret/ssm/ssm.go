@@ -0,0 +1,88 @@
+// Package ssm implements a secret.Store backed by AWS Systems Manager Parameter
+// Store. A target's secrets are the parameters directly under
+// <prefix>/<target>, such as /pico/app/DB_URL, named by the last element of
+// their path. Nested parameters are not included.
+package ssm
+
+import (
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awsssm "github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/secret"
+)
+
+// ParameterStoreSecrets implements a secret.Store backed by Parameter Store
+type ParameterStoreSecrets struct {
+	client ssmiface.SSMAPI
+	prefix string
+}
+
+var _ secret.Store = &ParameterStoreSecrets{}
+
+// New creates a store for parameters under prefix in the given region. AWS
+// credentials are found through the SDK's default chain and throttled requests
+// are retried by the SDK's default retryer.
+func New(region, prefix string) (*ParameterStoreSecrets, error) {
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create AWS session")
+	}
+	sess.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler(buildinfo.UserAgent()))
+
+	return &ParameterStoreSecrets{
+		client: awsssm.New(sess),
+		prefix: path.Join("/", prefix),
+	}, nil
+}
+
+// GetSecretsForTarget implements secret.Store. String, SecureString and
+// StringList parameters are all returned by their raw value, so a StringList is
+// the comma separated string.
+func (p *ParameterStoreSecrets) GetSecretsForTarget(name string) (map[string]string, error) {
+	dir := path.Join(p.prefix, name)
+
+	env := make(map[string]string)
+	err := p.client.GetParametersByPathPages(&awsssm.GetParametersByPathInput{
+		Path:           aws.String(dir),
+		Recursive:      aws.Bool(false),
+		WithDecryption: aws.Bool(true),
+		MaxResults:     aws.Int64(10),
+	}, func(page *awsssm.GetParametersByPathOutput, last bool) bool {
+		for _, param := range page.Parameters {
+			env[path.Base(aws.StringValue(param.Name))] = aws.StringValue(param.Value)
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessDeniedException" {
+			zap.L().Error("access denied reading parameters, check the IAM policy allows ssm:GetParametersByPath and kms:Decrypt",
+				zap.String("name", name),
+				zap.String("path", dir))
+			return nil, errors.Wrapf(err, "access denied reading parameters at %s", dir)
+		}
+		return nil, errors.Wrapf(err, "failed to read parameters at %s", dir)
+	}
+
+	if len(env) == 0 {
+		zap.L().Debug("no parameters found at path",
+			zap.String("name", name),
+			zap.String("path", dir))
+		return nil, nil
+	}
+
+	zap.L().Debug("found parameters in parameter store",
+		zap.String("name", name),
+		zap.String("path", dir),
+		zap.Int("secrets", len(env)))
+
+	return env, nil
+}
@@ -0,0 +1,68 @@
+package ssm
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awsssm "github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	pages [][]*awsssm.Parameter
+	err   error
+	input *awsssm.GetParametersByPathInput
+}
+
+func (f *fakeSSM) GetParametersByPathPages(in *awsssm.GetParametersByPathInput, fn func(*awsssm.GetParametersByPathOutput, bool) bool) error {
+	f.input = in
+	if f.err != nil {
+		return f.err
+	}
+	for i, p := range f.pages {
+		if !fn(&awsssm.GetParametersByPathOutput{Parameters: p}, i == len(f.pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func param(name, typ, value string) *awsssm.Parameter {
+	return &awsssm.Parameter{Name: aws.String(name), Type: aws.String(typ), Value: aws.String(value)}
+}
+
+func TestGetSecretsForTarget(t *testing.T) {
+	f := &fakeSSM{pages: [][]*awsssm.Parameter{
+		{param("/pico/app/DB_URL", "SecureString", "postgres://db")},
+		{param("/pico/app/HOSTS", "StringList", "a,b,c"), param("/pico/app/MODE", "String", "prod")},
+	}}
+	p := &ParameterStoreSecrets{client: f, prefix: "/pico"}
+
+	got, err := p.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"DB_URL": "postgres://db", "HOSTS": "a,b,c", "MODE": "prod"}, got)
+	assert.Equal(t, "/pico/app", aws.StringValue(f.input.Path))
+	assert.True(t, aws.BoolValue(f.input.WithDecryption))
+	assert.False(t, aws.BoolValue(f.input.Recursive))
+}
+
+func TestGetSecretsForTargetEmpty(t *testing.T) {
+	p := &ParameterStoreSecrets{client: &fakeSSM{}, prefix: "/pico"}
+
+	got, err := p.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestGetSecretsForTargetDenied(t *testing.T) {
+	p := &ParameterStoreSecrets{
+		client: &fakeSSM{err: awserr.New("AccessDeniedException", "not authorized", nil)},
+		prefix: "/pico",
+	}
+
+	_, err := p.GetSecretsForTarget("app")
+	assert.EqualError(t, err, "access denied reading parameters at /pico/app: AccessDeniedException: not authorized")
+}
@@ -0,0 +1,119 @@
+package vault
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/secret"
+)
+
+const (
+	// awsMount is where the AWS auth method is mounted
+	awsMount = "auth/aws"
+	// awsRegion is the region the request to STS is signed for, that of the
+	// global endpoint Vault sends it to unless it's configured otherwise
+	awsRegion = "us-east-1"
+	// awsServerIDHeader is the header Vault requires the signed request to
+	// have if the auth method is configured with a server ID, to stop signed
+	// requests meant for another server being replayed against it
+	awsServerIDHeader = "X-Vault-AWS-IAM-Server-ID"
+)
+
+// ErrClockSkew is matched by failed logins with the AWS auth method whose
+// signed request STS rejected as expired or not yet valid, which almost always
+// means the clock of this host is skewed.
+var ErrClockSkew = errors.New("signed login rejected for its time, the clock of this host is likely skewed")
+
+// clockSkewed matches the errors STS returns for requests signed too far from
+// its own time, which Vault relays when a login fails.
+var clockSkewed = regexp.MustCompile(`Signature expired|Signature not yet current|RequestExpired|RequestTimeTooSkewed`)
+
+// awsLogin returns a login with the AWS auth method, it signs a
+// sts:GetCallerIdentity request with the credentials of the SDK's default
+// chain, such as the EC2 instance's role, for Vault to send to STS.
+func (v *VaultSecrets) awsLogin(role, serverID string) (func() (time.Duration, error), error) {
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(awsRegion))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create AWS session")
+	}
+	sess.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler(buildinfo.UserAgent()))
+	return v.iamLogin(sts.New(sess), role, serverID), nil
+}
+
+// iamLogin returns a login with the AWS auth method that signs requests with
+// the STS client.
+func (v *VaultSecrets) iamLogin(client *sts.STS, role, serverID string) func() (time.Duration, error) {
+	return func() (time.Duration, error) {
+		data, err := awsLoginData(client, role, serverID)
+		if err != nil {
+			return 0, err
+		}
+		// the login path is unauthenticated, an expired token mustn't be sent.
+		// It's cleared on a clone as reads may be using the shared client.
+		c, err := v.client.Clone()
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to create vault client for the aws login")
+		}
+		c.ClearToken()
+		s, err := c.Logical().Write(awsMount+"/login", data)
+		if err != nil {
+			return 0, classifyLogin(errors.Wrap(err, "failed to log in to vault with the aws auth method"))
+		}
+		if s == nil || s.Auth == nil || s.Auth.ClientToken == "" {
+			return 0, errors.New("vault aws login returned no token")
+		}
+		v.client.SetToken(s.Auth.ClientToken)
+		return time.Duration(s.Auth.LeaseDuration) * time.Second, nil
+	}
+}
+
+// awsLoginData signs a sts:GetCallerIdentity request and encodes it as the
+// AWS auth method's iam login expects.
+func awsLoginData(client *sts.STS, role, serverID string) (map[string]interface{}, error) {
+	req, _ := client.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	if serverID != "" {
+		req.HTTPRequest.Header.Add(awsServerIDHeader, serverID)
+	}
+	if err := req.Sign(); err != nil {
+		return nil, errors.Wrap(err, "failed to sign sts:GetCallerIdentity request")
+	}
+
+	headers, err := json.Marshal(req.HTTPRequest.Header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode signed request headers")
+	}
+	body, err := ioutil.ReadAll(req.HTTPRequest.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read signed request body")
+	}
+
+	data := map[string]interface{}{
+		"iam_http_request_method": req.HTTPRequest.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(req.HTTPRequest.URL.String())),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(body),
+	}
+	if role != "" {
+		data["role"] = role
+	}
+	return data, nil
+}
+
+// classifyLogin classifies a failed login like any other request, except that
+// a signature rejected for its time matches ErrClockSkew instead.
+func classifyLogin(err error) error {
+	if clockSkewed.MatchString(err.Error()) {
+		return &secret.StoreError{Kind: ErrClockSkew, Err: err}
+	}
+	return classify(err)
+}
@@ -0,0 +1,78 @@
+package vault
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret"
+)
+
+func TestIAMLogin(t *testing.T) {
+	var body map[string]string
+	status, response := http.StatusOK, `{"auth":{"client_token":"s.aws","lease_duration":3600}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/aws/login" || r.Header.Get("X-Vault-Token") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+		w.WriteHeader(status)
+		w.Write([]byte(response)) //nolint:errcheck
+	}))
+	defer srv.Close()
+	client, err := api.NewClient(&api.Config{Address: srv.URL, HttpClient: srv.Client()})
+	require.NoError(t, err)
+	client.SetToken("s.expired")
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion(awsRegion).
+		WithCredentials(credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", "")))
+	require.NoError(t, err)
+	v := &VaultSecrets{client: client}
+	login := v.iamLogin(sts.New(sess), "pico", "vault.example.com")
+
+	ttl, err := login()
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, ttl)
+	assert.Equal(t, "s.aws", client.Token())
+
+	assert.Equal(t, "pico", body["role"])
+	assert.Equal(t, "POST", body["iam_http_request_method"])
+	decode := func(key string) string {
+		b, err := base64.StdEncoding.DecodeString(body[key])
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "https://sts.amazonaws.com/", decode("iam_request_url"))
+	assert.Equal(t, "Action=GetCallerIdentity&Version=2011-06-15", decode("iam_request_body"))
+	var headers http.Header
+	require.NoError(t, json.Unmarshal([]byte(decode("iam_request_headers")), &headers))
+	assert.Equal(t, "vault.example.com", headers.Get(awsServerIDHeader))
+	assert.Contains(t, headers.Get("Authorization"), "Credential=AKIDEXAMPLE/")
+	assert.Contains(t, headers.Get("Authorization"), "x-vault-aws-iam-server-id")
+
+	status, response = http.StatusBadRequest, `{"errors":["error making upstream request: received error code 403 from STS: <ErrorResponse><Error><Code>SignatureDoesNotMatch</Code><Message>Signature expired: 20200101T000000Z is now earlier than 20200101T001000Z</Message></Error></ErrorResponse>"]}`
+	_, err = login()
+	assert.True(t, errors.Is(err, ErrClockSkew))
+	assert.False(t, errors.Is(err, secret.ErrSecretDenied))
+	// reads in flight keep the token while the login fails
+	assert.Equal(t, "s.aws", client.Token())
+
+	status, response = http.StatusBadRequest, `{"errors":["entry for role pico not found"]}`
+	_, err = login()
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrClockSkew))
+}
@@ -0,0 +1,51 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/secret"
+)
+
+var _ secret.DynamicStore = &VaultSecrets{}
+
+// IssueCredentials implements secret.DynamicStore by reading a path of a
+// secrets engine that generates credentials, such as database/creds/<role>.
+func (v *VaultSecrets) IssueCredentials(ctx context.Context, path string) (secret.Credentials, error) {
+	s, err := v.client.Logical().Read(path)
+	if err != nil {
+		return secret.Credentials{}, classify(errors.Wrapf(err, "failed to issue credentials from %s", path))
+	}
+	if s == nil {
+		return secret.Credentials{}, errors.Errorf("no credentials issued from %s", path)
+	}
+
+	data := make(map[string]string, len(s.Data))
+	for k, v := range s.Data {
+		data[k] = fmt.Sprint(v)
+	}
+
+	secret.Logger(ctx).Info("issued dynamic credentials",
+		zap.String("path", path),
+		zap.String("lease_id", s.LeaseID),
+		zap.Int("lease_duration", s.LeaseDuration))
+
+	return secret.Credentials{
+		LeaseID: s.LeaseID,
+		TTL:     time.Duration(s.LeaseDuration) * time.Second,
+		Data:    data,
+	}, nil
+}
+
+// RevokeLease implements secret.DynamicStore
+func (v *VaultSecrets) RevokeLease(ctx context.Context, leaseID string) error {
+	if err := v.client.Sys().Revoke(leaseID); err != nil {
+		return errors.Wrapf(err, "failed to revoke lease %s", leaseID)
+	}
+	secret.Logger(ctx).Info("revoked dynamic credentials", zap.String("lease_id", leaseID))
+	return nil
+}
@@ -0,0 +1,35 @@
+package vault
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/secret"
+)
+
+// classify marks errors of the Vault client as secret.ErrSecretDenied when the
+// server rejected the token and as secret.ErrSecretUnavailable when the server
+// could not be reached or failed, other errors are returned as they are.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var re *api.ResponseError
+	if errors.As(err, &re) {
+		switch {
+		case re.StatusCode == http.StatusUnauthorized || re.StatusCode == http.StatusForbidden:
+			return &secret.StoreError{Kind: secret.ErrSecretDenied, Err: err}
+		case re.StatusCode >= 500:
+			return &secret.StoreError{Kind: secret.ErrSecretUnavailable, Err: err}
+		}
+		return err
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return &secret.StoreError{Kind: secret.ErrSecretUnavailable, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package vault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret"
+)
+
+func TestClassify(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name   string
+		status int  // response of the server, none if zero
+		down   bool // the server is not listening
+		want   error
+	}{
+		{"permission denied", http.StatusForbidden, false, secret.ErrSecretDenied},
+		{"bad token", http.StatusUnauthorized, false, secret.ErrSecretDenied},
+		{"sealed", http.StatusServiceUnavailable, false, secret.ErrSecretUnavailable},
+		{"server error", http.StatusInternalServerError, false, secret.ErrSecretUnavailable},
+		{"bad request", http.StatusBadRequest, false, nil},
+		{"connection refused", 0, true, secret.ErrSecretUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := closed.URL
+			if !tt.down {
+				s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.status)
+					w.Write([]byte(`{"errors":["nope"]}`)) //nolint:errcheck
+				}))
+				defer s.Close()
+				addr = s.URL
+			}
+			client, err := api.NewClient(&api.Config{Address: addr, MaxRetries: -1})
+			require.NoError(t, err)
+
+			_, err = client.Logical().Read("secret/data/app")
+			require.Error(t, err)
+			err = classify(errors.Wrap(err, "failed to read secret"))
+
+			for _, kind := range []error{secret.ErrSecretDenied, secret.ErrSecretUnavailable} {
+				assert.Equal(t, kind == tt.want, errors.Is(err, kind), kind.Error())
+			}
+			assert.Contains(t, err.Error(), "failed to read secret")
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package vault
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/leader"
+)
+
+// AcquireLease implements leader.Lease using a KV v2 secret at key, relative to
+// the store's first path. Writes use check-and-set so two instances racing for
+// an expired lease can't both win.
+func (v *VaultSecrets) AcquireLease(key, holder string, ttl time.Duration) (bool, error) {
+	m := v.mounts[0]
+	if m.version != 2 {
+		return false, errors.New("leader election requires a KV v2 secrets engine")
+	}
+
+	p := path.Join(m.enginepath, "data", m.path, key)
+
+	s, err := v.client.Logical().Read(p)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read lease")
+	}
+
+	cas := 0
+	if s != nil {
+		current, _ := s.Data["data"].(map[string]interface{})
+		metadata, _ := s.Data["metadata"].(map[string]interface{})
+		if version, ok := metadata["version"].(interface{ String() string }); ok {
+			cas, _ = strconv.Atoi(version.String())
+		}
+
+		currentHolder, _ := current["holder"].(string)
+		expires, _ := time.Parse(time.RFC3339, stringOf(current["expires"]))
+		if currentHolder != holder && time.Now().Before(expires) {
+			return false, nil
+		}
+	}
+
+	_, err = v.client.Logical().Write(p, map[string]interface{}{
+		"options": map[string]interface{}{"cas": cas},
+		"data": map[string]interface{}{
+			"holder":  holder,
+			"expires": time.Now().Add(ttl).Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "check-and-set") {
+			// another instance wrote the lease between our read and write
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to write lease")
+	}
+	return true, nil
+}
+
+// appliedSuffix is appended to the lease's key for the secret that records
+// the commit each target was last applied at
+const appliedSuffix = "-applied"
+
+// appliedRetries is how often a write of an applied commit is retried when
+// another instance wrote the secret in between
+const appliedRetries = 3
+
+// Applied returns the commit each target was last applied at by any instance
+// electing its leader with the lease at key, from the KV v2 secret next to it.
+func (v *VaultSecrets) Applied(key string) (map[string]string, error) {
+	current, _, err := v.readApplied(key)
+	return current, err
+}
+
+// SetApplied records the commit a target was applied at for the instances
+// electing their leader with the lease at key, an empty commit removes it.
+func (v *VaultSecrets) SetApplied(key, target, commit string) error {
+	for i := 0; ; i++ {
+		current, cas, err := v.readApplied(key)
+		if err != nil {
+			return err
+		}
+		if current[target] == commit {
+			return nil
+		}
+		data := make(map[string]interface{}, len(current)+1)
+		for name, c := range current {
+			data[name] = c
+		}
+		if commit == "" {
+			delete(data, target)
+		} else {
+			data[target] = commit
+		}
+
+		_, err = v.client.Logical().Write(v.appliedPath(key), map[string]interface{}{
+			"options": map[string]interface{}{"cas": cas},
+			"data":    data,
+		})
+		if err == nil {
+			return nil
+		}
+		if !strings.Contains(err.Error(), "check-and-set") || i == appliedRetries {
+			return errors.Wrap(err, "failed to write applied commits")
+		}
+	}
+}
+
+// readApplied reads the applied commits and the version of their secret, for
+// check-and-set
+func (v *VaultSecrets) readApplied(key string) (map[string]string, int, error) {
+	if v.mounts[0].version != 2 {
+		return nil, 0, errors.New("leader election requires a KV v2 secrets engine")
+	}
+	s, err := v.client.Logical().Read(v.appliedPath(key))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to read applied commits")
+	}
+	applied := make(map[string]string)
+	if s == nil {
+		return applied, 0, nil
+	}
+	data, _ := s.Data["data"].(map[string]interface{})
+	for name, c := range data {
+		applied[name] = stringOf(c)
+	}
+	var cas int
+	metadata, _ := s.Data["metadata"].(map[string]interface{})
+	if version, ok := metadata["version"].(interface{ String() string }); ok {
+		cas, _ = strconv.Atoi(version.String())
+	}
+	return applied, cas, nil
+}
+
+func (v *VaultSecrets) appliedPath(key string) string {
+	m := v.mounts[0]
+	return path.Join(m.enginepath, "data", m.path, key+appliedSuffix)
+}
+
+// Lease binds a key and holder to the store for use as a leader.Lease
+type Lease struct {
+	Store *VaultSecrets
+	Key   string
+}
+
+var _ leader.AppliedStore = Lease{}
+
+// Acquire implements leader.Lease
+func (l Lease) Acquire(holder string, ttl time.Duration) (bool, error) {
+	return l.Store.AcquireLease(l.Key, holder, ttl)
+}
+
+// Applied implements leader.AppliedStore
+func (l Lease) Applied() (map[string]string, error) {
+	return l.Store.Applied(l.Key)
+}
+
+// SetApplied implements leader.AppliedStore
+func (l Lease) SetApplied(target, commit string) error {
+	return l.Store.SetApplied(l.Key, target, commit)
+}
+
+func stringOf(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,86 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKV serves a single KV v2 secret, writes fail check-and-set unless they
+// name the current version
+type fakeKV struct {
+	mu      sync.Mutex
+	data    map[string]interface{}
+	version int
+	writes  int
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path != "/v1/secret/data/pico/leader-applied" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if f.data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+			"data": map[string]interface{}{"data": f.data, "metadata": map[string]interface{}{"version": f.version}},
+		})
+	default:
+		var body struct {
+			Options struct{ CAS int } `json:"options"`
+			Data    map[string]interface{}
+		}
+		json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+		f.writes++
+		if body.Options.CAS != f.version {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`)) //nolint:errcheck
+			return
+		}
+		f.data = body.Data
+		f.version++
+		w.Write([]byte(`{"data":{}}`)) //nolint:errcheck
+	}
+}
+
+func TestLeaseApplied(t *testing.T) {
+	kv := &fakeKV{}
+	srv := httptest.NewServer(kv)
+	defer srv.Close()
+	client, err := api.NewClient(&api.Config{Address: srv.URL, HttpClient: srv.Client()})
+	require.NoError(t, err)
+	lease := Lease{
+		Store: &VaultSecrets{client: client, mounts: []mount{{enginepath: "secret", path: "pico", version: 2}}},
+		Key:   "leader",
+	}
+
+	applied, err := lease.Applied()
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+
+	assert.NoError(t, lease.SetApplied("app", "abc123"))
+	assert.NoError(t, lease.SetApplied("db", "def456"))
+	assert.NoError(t, lease.SetApplied("db", "def456"))
+	assert.Equal(t, 2, kv.writes, "an unchanged commit isn't written")
+
+	// another instance wrote in between, the write is retried
+	kv.version = 5
+	kv.data["web"] = "0a1b2c"
+	assert.NoError(t, lease.SetApplied("app", ""))
+
+	applied, err = lease.Applied()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"db": "def456", "web": "0a1b2c"}, applied)
+}
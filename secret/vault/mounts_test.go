@@ -0,0 +1,41 @@
+package vault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSecretsForTargetMounts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/kv-new/data/app":
+			w.Write([]byte(`{"data":{"data":{"DB_PASS":"new","API_KEY":"key"}}}`)) //nolint:errcheck
+		case "/v1/kv-old/app":
+			w.Write([]byte(`{"data":{"DB_PASS":"old","LEGACY":"yes"}}`)) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := api.NewClient(&api.Config{Address: srv.URL, HttpClient: srv.Client()})
+	require.NoError(t, err)
+
+	v := &VaultSecrets{client: client, mounts: []mount{
+		{enginepath: "kv-new", path: "/", version: 2},
+		{enginepath: "kv-old", path: "/", version: 1},
+	}}
+
+	got, err := v.GetSecretsForTarget("app")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"DB_PASS": "new", "API_KEY": "key", "LEGACY": "yes"}, got)
+
+	got, err = v.GetSecretsForTarget("missing")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
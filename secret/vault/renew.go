@@ -0,0 +1,111 @@
+package vault
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// renewalBackoff is the delay before the first retry of a failed renewal,
+	// it doubles with every failure up to renewalMaxBackoff.
+	renewalBackoff    = time.Second
+	renewalMaxBackoff = time.Minute
+)
+
+// renewal renews a token on an interval. Failed renewals are retried with
+// exponential backoff and jitter for as long as the token is valid, once it has
+// expired the only way to recover is to log in again.
+type renewal struct {
+	interval   time.Duration
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// renew and login return the TTL of the token, zero if it never expires.
+	// login is nil if the auth method can't log in again, such as a token.
+	renew   func() (time.Duration, error)
+	login   func() (time.Duration, error)
+	observe func(err error) // called with the outcome of every attempt
+	now     func() time.Time
+}
+
+// run renews the token until ctx is done or until the token has expired and it
+// can't log in again. The token expires at expires, never if it's zero.
+func (r *renewal) run(ctx context.Context, expires time.Time) error {
+	delay := r.next(expires)
+	backoff := r.backoff
+	for {
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+
+		ttl, err := r.renew()
+		r.observe(err)
+		if err == nil {
+			expires = expiry(r.now(), ttl)
+			delay, backoff = r.next(expires), r.backoff
+			continue
+		}
+
+		if expires.IsZero() || r.now().Before(expires) {
+			delay = jitter(backoff)
+			zap.L().Warn("failed to renew vault token, retrying",
+				zap.Duration("retry_in", delay),
+				zap.Time("expires", expires),
+				zap.Error(err))
+			if backoff *= 2; backoff > r.maxBackoff {
+				backoff = r.maxBackoff
+			}
+			continue
+		}
+
+		if r.login == nil {
+			return errors.Wrap(err, "vault token expired and could not be renewed")
+		}
+		zap.L().Warn("vault token expired, logging in again", zap.Error(err))
+		ttl, lerr := r.login()
+		r.observe(lerr)
+		if lerr != nil {
+			return errors.Wrap(lerr, "vault token expired and logging in again failed")
+		}
+		zap.L().Info("logged in to vault again")
+		expires = expiry(r.now(), ttl)
+		delay, backoff = r.next(expires), r.backoff
+	}
+}
+
+// next returns the delay until the next renewal, the interval unless the token
+// expires sooner, in which case it's renewed halfway through its remaining TTL.
+func (r *renewal) next(expires time.Time) time.Duration {
+	if expires.IsZero() {
+		return r.interval
+	}
+	if half := expires.Sub(r.now()) / 2; half > 0 && half < r.interval {
+		return half
+	}
+	return r.interval
+}
+
+// expiry returns when a token with the TTL expires, zero if it never does
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// jitter returns a random delay between half and all of d, so instances that
+// lost Vault at the same time don't all retry at once.
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
@@ -0,0 +1,97 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenewal(t *testing.T) {
+	outage := errors.New("connection refused")
+
+	tests := []struct {
+		name     string
+		expires  time.Duration // from now, never if zero
+		failures int           // renewals failing before they succeed again, -1 for all
+		login    error
+		canLogin bool
+		wantErr  string
+		wantOK   int // observed successes before stopping
+	}{
+		{"renews", 0, 0, nil, false, "", 3},
+		{"retries while valid", time.Hour, 5, nil, false, "", 3},
+		{"never expires", 0, 10, nil, false, "", 3},
+		{"expired", -time.Second, -1, nil, false, "vault token expired and could not be renewed: connection refused", 0},
+		{"logs in again", -time.Second, 1, nil, true, "", 3},
+		{"login fails", -time.Second, -1, errors.New("denied"), true, "vault token expired and logging in again failed: denied", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var ok, failed, attempts int
+			r := &renewal{
+				interval:   time.Millisecond,
+				backoff:    time.Millisecond,
+				maxBackoff: 4 * time.Millisecond,
+				renew: func() (time.Duration, error) {
+					attempts++
+					if tt.failures < 0 || attempts <= tt.failures {
+						return 0, outage
+					}
+					return time.Hour, nil
+				},
+				observe: func(err error) {
+					if err != nil {
+						failed++
+						return
+					}
+					if ok++; ok == 3 {
+						cancel()
+					}
+				},
+				now: time.Now,
+			}
+			if tt.canLogin {
+				r.login = func() (time.Duration, error) { return time.Hour, tt.login }
+			}
+
+			var expires time.Time
+			if tt.expires != 0 {
+				expires = time.Now().Add(tt.expires)
+			}
+			err := r.run(ctx, expires)
+			if tt.wantErr == "" {
+				assert.Equal(t, context.Canceled, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.failures > 0 && !tt.canLogin {
+				assert.Equal(t, tt.failures, failed)
+			}
+		})
+	}
+}
+
+func TestRenewalNext(t *testing.T) {
+	now := time.Now()
+	r := &renewal{interval: time.Hour, now: func() time.Time { return now }}
+
+	assert.Equal(t, time.Hour, r.next(time.Time{}))
+	assert.Equal(t, time.Hour, r.next(now.Add(24*time.Hour)))
+	assert.Equal(t, 15*time.Minute, r.next(now.Add(30*time.Minute)))
+	assert.Equal(t, time.Hour, r.next(now.Add(-time.Minute)), "an expired token is left to the retries")
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Second)
+		assert.True(t, d >= 500*time.Millisecond && d <= time.Second, d)
+	}
+	assert.Equal(t, time.Duration(1), jitter(1))
+}
@@ -11,103 +11,213 @@ import (
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
+	"github.com/picostack/pico/buildinfo"
 	"github.com/picostack/pico/secret"
 )
 
 // VaultSecrets implements a secret.Store backed by Hashicorp Vault
 type VaultSecrets struct {
-	client     *api.Client
+	client  *api.Client
+	mounts  []mount
+	renewal time.Duration
+	observe func(err error)
+	login   func() (time.Duration, error) // nil if the auth method can't log in again
+}
+
+// Auth methods the store can log in to Vault with
+const (
+	// AuthToken uses a token that's provisioned, the default
+	AuthToken = "token"
+	// AuthAWS logs in with the AWS auth method, signing a request with the
+	// host's AWS credentials so no secret needs provisioning
+	AuthAWS = "aws"
+)
+
+// Auth is how the store logs in to Vault
+type Auth struct {
+	Method   string // AuthToken when empty
+	Token    string // for AuthToken
+	Wrapped  bool   // the token is a response-wrapping token to unwrap
+	Unwrap   string // for AuthToken, the file a token unwrapped from Token is kept in, see TokenFileName
+	Role     string // for AuthAWS, the IAM principal's name when empty
+	ServerID string // for AuthAWS, the X-Vault-AWS-IAM-Server-ID header if Vault requires one
+}
+
+// mount is one of the paths secrets are searched for in
+type mount struct {
 	enginepath string
 	path       string
 	version    int
-	renewal    time.Duration
 }
 
 var _ secret.Store = &VaultSecrets{}
+var _ secret.ContextStore = &VaultSecrets{}
 
-// New creates a new Vault client and pings the server
-func New(addr, basepath, token string, renewal time.Duration) (v *VaultSecrets, err error) {
-	if strings.HasPrefix(basepath, "/") {
-		basepath = basepath[1:]
-	}
-
+// New creates a new Vault client, logs in and pings the server. If the token is
+// a response-wrapping token, or wrapped is set, it's unwrapped and the enclosed
+// token is used instead, it's kept in the auth's Unwrap file to be used again
+// after a restart. The base path may be a comma separated list of paths,
+// which are searched in order.
+func New(addr, basepaths string, auth Auth, renewal time.Duration) (v *VaultSecrets, err error) {
 	v = &VaultSecrets{
 		renewal: renewal,
 	}
 
+	httpClient := cleanhttp.DefaultClient()
+	httpClient.Transport = buildinfo.Transport(httpClient.Transport)
+
 	if v.client, err = api.NewClient(&api.Config{
 		Address:    addr,
-		HttpClient: cleanhttp.DefaultClient(),
+		HttpClient: httpClient,
 	}); err != nil {
 		return nil, errors.Wrap(err, "failed to create vault client")
 	}
-	v.client.SetToken(token)
+
+	switch auth.Method {
+	case AuthToken, "":
+		token, err := resolveToken(v.client, auth.Token, auth.Wrapped, auth.Unwrap)
+		if err != nil {
+			return nil, err
+		}
+		v.client.SetToken(token)
+	case AuthAWS:
+		if v.login, err = v.awsLogin(auth.Role, auth.ServerID); err != nil {
+			return nil, err
+		}
+		if _, err = v.login(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("vault auth method '%s' is not one of %s or %s", auth.Method, AuthToken, AuthAWS)
+	}
 
 	if _, err = v.client.Auth().Token().LookupSelf(); err != nil {
 		return nil, errors.Wrap(err, "failed to connect to vault server")
 	}
 
-	// engine is the first component of base, then the rest is the actual path.
-	v.enginepath, v.path = splitPath(basepath)
+	for _, basepath := range strings.Split(basepaths, ",") {
+		basepath = strings.TrimPrefix(strings.TrimSpace(basepath), "/")
+		if basepath == "" {
+			continue
+		}
 
-	if v.version, err = getKVEngineVersion(v.client, v.enginepath); err != nil {
-		return nil, errors.Wrapf(err, "failed to determine KV engine version at '/%s'", v.enginepath)
-	}
+		// engine is the first component of base, then the rest is the actual path.
+		var m mount
+		m.enginepath, m.path = splitPath(basepath)
 
-	zap.L().Debug("created new vault client for secrets engine",
-		zap.Int("kv_version", v.version),
-		zap.String("basepath", basepath),
-		zap.String("enginepath", v.enginepath))
+		if m.version, err = getKVEngineVersion(v.client, m.enginepath); err != nil {
+			return nil, errors.Wrapf(err, "failed to determine KV engine version at '/%s'", m.enginepath)
+		}
+
+		zap.L().Debug("created new vault client for secrets engine",
+			zap.Int("kv_version", m.version),
+			zap.String("basepath", basepath),
+			zap.String("enginepath", m.enginepath))
+
+		v.mounts = append(v.mounts, m)
+	}
+	if len(v.mounts) == 0 {
+		return nil, errors.New("no vault path specified")
+	}
 
 	return v, nil
 }
 
-// GetSecretsForTarget implements secret.Store
+// GetSecretsForTarget implements secret.Store. Each path is searched in order
+// and the results are merged, secrets from earlier paths take precedence.
 func (v *VaultSecrets) GetSecretsForTarget(name string) (map[string]string, error) {
-	path := v.buildPath(name)
+	return v.GetSecretsForTargetContext(context.Background(), name)
+}
 
-	zap.L().Debug("looking for secrets in vault",
-		zap.String("name", name),
-		zap.String("path", path))
+// GetSecretsForTargetContext implements secret.ContextStore
+func (v *VaultSecrets) GetSecretsForTargetContext(ctx context.Context, name string) (map[string]string, error) {
+	log := secret.Logger(ctx)
+	var env map[string]string
+	for _, m := range v.mounts {
+		path := m.buildPath(name)
 
-	secret, err := v.client.Logical().Read(path)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read secret")
-	}
-	if secret == nil {
-		zap.L().Debug("did not find secrets in vault",
+		log.Debug("looking for secrets in vault",
 			zap.String("name", name),
 			zap.String("path", path))
-		return nil, nil
-	}
 
-	env, err := kvToMap(v.version, secret.Data)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to unwrap secret data")
-	}
+		secret, err := v.client.Logical().Read(path)
+		if err != nil {
+			return nil, classify(errors.Wrap(err, "failed to read secret"))
+		}
+		if secret == nil {
+			log.Debug("did not find secrets in vault",
+				zap.String("name", name),
+				zap.String("path", path))
+			continue
+		}
 
-	zap.L().Debug("found secrets in vault",
-		zap.Strings("secret", keys(env)))
+		found, err := kvToMap(m.version, secret.Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to unwrap secret data")
+		}
+
+		if env == nil {
+			env = make(map[string]string)
+		}
+		var used []string
+		for k, s := range found {
+			if _, ok := env[k]; !ok {
+				env[k] = s
+				used = append(used, k)
+			}
+		}
+
+		log.Debug("found secrets in vault",
+			zap.String("path", path),
+			zap.Strings("secret", used))
+	}
 
 	return env, nil
 }
 
-// RenewEvery starts a renewal ticker and blocks until fatal error
-// works well with github.com/eapache/go-resiliency
+// SetRenewalObserver sets a function called with the outcome of every attempt
+// to renew the token, such as for metrics. It must be called before Renew.
+func (v *VaultSecrets) SetRenewalObserver(f func(err error)) {
+	v.observe = f
+}
+
+// Renew renews the token on every renewal interval and blocks until ctx is done
+// or the token can no longer be renewed. Failed renewals are retried with
+// backoff for as long as the token is valid, an error is only returned once it
+// has expired.
 func (v *VaultSecrets) Renew(ctx context.Context) error {
-	if ctx.Err() == context.Canceled {
+	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
-	renew := time.NewTicker(v.renewal)
-	defer renew.Stop()
-	for range renew.C {
-		_, err := v.client.Auth().Token().RenewSelf(0)
-		if err != nil {
-			return errors.Wrap(err, "failed to renew vault token")
-		}
+	var expires time.Time
+	if self, err := v.client.Auth().Token().LookupSelf(); err == nil {
+		ttl, _ := self.TokenTTL() //nolint:errcheck
+		expires = expiry(time.Now(), ttl)
+	} else {
+		zap.L().Warn("failed to look up vault token expiry", zap.Error(err))
+	}
+
+	observe := v.observe
+	if observe == nil {
+		observe = func(error) {}
 	}
-	return nil
+	r := &renewal{
+		interval:   v.renewal,
+		backoff:    renewalBackoff,
+		maxBackoff: renewalMaxBackoff,
+		renew: func() (time.Duration, error) {
+			s, err := v.client.Auth().Token().RenewSelf(0)
+			if err != nil {
+				return 0, classify(errors.Wrap(err, "failed to renew vault token"))
+			}
+			return s.TokenTTL()
+		},
+		login:   v.login,
+		observe: observe,
+		now:     time.Now,
+	}
+	return r.run(ctx, expires)
 }
 
 func splitPath(basepath string) (string, string) {
@@ -122,12 +232,11 @@ func splitPath(basepath string) (string, string) {
 }
 
 // builds the correct path to a secret based on the kv version
-func (v *VaultSecrets) buildPath(item string) string {
-	if v.version == 1 {
-		path.Split(v.path)
-		return path.Join(v.enginepath, v.path, item)
+func (m mount) buildPath(item string) string {
+	if m.version == 1 {
+		return path.Join(m.enginepath, m.path, item)
 	}
-	return path.Join(v.enginepath, "data", v.path, item)
+	return path.Join(m.enginepath, "data", m.path, item)
 }
 
 // pulls out the kv secret data for v1 and v2 secrets
@@ -152,13 +261,6 @@ func kvToMap(version int, data map[string]interface{}) (env map[string]string, e
 	return
 }
 
-func keys(m map[string]string) (k []string) {
-	for x := range m {
-		k = append(k, x)
-	}
-	return
-}
-
 // because Vault has no way to know if a kv engine is v1 or v2, we have to check
 // for the /config path and if it doesn't exist, attempt to LIST the path, if
 // that succeeds, it's a v1, if it doesn't succeed, it *might still* be a v1 but
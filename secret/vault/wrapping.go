@@ -0,0 +1,134 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ErrWrappingTokenUsed is returned when a wrapping token was already
+// unwrapped. Nothing but Pico should unwrap its token, so this means the token
+// may have been intercepted rather than misconfigured.
+var ErrWrappingTokenUsed = errors.New("wrapping token already unwrapped — possible interception")
+
+// TokenFileName is the file in the data directory a token unwrapped from a
+// wrapping token is kept in, so it's used again after a restart rather than
+// unwrapping the same wrapping token a second time.
+const TokenFileName = ".pico-vault-token"
+
+// unwrapped is the content of the token file
+type unwrapped struct {
+	Wrapping string `json:"wrapping"` // SHA-256 of the wrapping token
+	Token    string `json:"token"`
+}
+
+func wrappingHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// readUnwrapped returns the token kept in the file if it was unwrapped from
+// the given wrapping token.
+func readUnwrapped(file, wrapping string) (string, bool) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			zap.L().Warn("failed to read unwrapped vault token", zap.String("file", file), zap.Error(err))
+		}
+		return "", false
+	}
+	var u unwrapped
+	if err := json.Unmarshal(b, &u); err != nil || u.Token == "" {
+		zap.L().Warn("ignoring invalid unwrapped vault token file", zap.String("file", file))
+		return "", false
+	}
+	return u.Token, u.Wrapping == wrappingHash(wrapping)
+}
+
+func writeUnwrapped(file, wrapping, token string) error {
+	b, err := json.Marshal(unwrapped{Wrapping: wrappingHash(wrapping), Token: token})
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(ioutil.WriteFile(file, b, 0600), "failed to write unwrapped vault token")
+}
+
+// isWrappingToken reports whether the token is a response-wrapping token by
+// looking it up in the cubbyhole wrapping store, which needs no authentication.
+// A token the store doesn't know, such as a regular token or one that expired,
+// isn't taken for a wrapping token.
+func isWrappingToken(client *api.Client, token string) bool {
+	c, err := client.Clone()
+	if err != nil {
+		return false
+	}
+	c.ClearToken()
+	s, err := c.Logical().Write("sys/wrapping/lookup", map[string]interface{}{"token": token})
+	return err == nil && s != nil && s.Data["creation_path"] != nil
+}
+
+func isUsedWrappingToken(err error) bool {
+	return strings.Contains(err.Error(), "wrapping token is not valid or does not exist")
+}
+
+// unwrapToken exchanges a wrapping token for the token it encloses, which may
+// be the auth of a wrapped token creation or a "token" value of wrapped data.
+func unwrapToken(client *api.Client, wrapping string) (string, error) {
+	c, err := client.Clone()
+	if err != nil {
+		return "", err
+	}
+	c.ClearToken()
+
+	s, err := c.Logical().Unwrap(wrapping)
+	if err != nil {
+		if isUsedWrappingToken(err) {
+			return "", ErrWrappingTokenUsed
+		}
+		return "", errors.Wrap(err, "failed to unwrap token")
+	}
+	if s == nil {
+		return "", ErrWrappingTokenUsed
+	}
+	if s.Auth != nil && s.Auth.ClientToken != "" {
+		return s.Auth.ClientToken, nil
+	}
+	if t, ok := s.Data["token"].(string); ok && t != "" {
+		return t, nil
+	}
+	return "", errors.New("wrapped response does not contain a token")
+}
+
+// resolveToken returns the token to use, unwrapping it first if it's a
+// wrapping token or wrapped is set. A token unwrapped before from the same
+// wrapping token is read from file instead, unwrapped tokens are written to it
+// unless it's empty.
+func resolveToken(client *api.Client, token string, wrapped bool, file string) (string, error) {
+	if file != "" {
+		if unwrapped, ok := readUnwrapped(file, token); ok {
+			zap.L().Info("using the vault token unwrapped before", zap.String("file", file))
+			return unwrapped, nil
+		}
+	}
+	if !wrapped && !isWrappingToken(client, token) {
+		return token, nil
+	}
+	zap.L().Info("unwrapping vault token")
+	unwrapped, err := unwrapToken(client, token)
+	if err != nil {
+		return "", err
+	}
+	if file != "" {
+		if err := writeUnwrapped(file, token, unwrapped); err != nil {
+			zap.L().Warn("the unwrapped vault token can't be used after a restart", zap.Error(err))
+		}
+	}
+	return unwrapped, nil
+}
@@ -0,0 +1,93 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeVault(t *testing.T, used bool) *api.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+		token := body["token"]
+		if token == "" {
+			token = r.Header.Get("X-Vault-Token")
+		}
+		if token != "s.wrapping" || used {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["wrapping token is not valid or does not exist"]}`)) //nolint:errcheck
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/lookup":
+			w.Write([]byte(`{"data":{"creation_path":"auth/token/create","creation_ttl":300}}`)) //nolint:errcheck
+		case "/v1/sys/wrapping/unwrap":
+			w.Write([]byte(`{"auth":{"client_token":"s.real"}}`)) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := api.NewClient(&api.Config{Address: srv.URL, HttpClient: srv.Client()})
+	require.NoError(t, err)
+	return client
+}
+
+func TestResolveToken(t *testing.T) {
+	client := fakeVault(t, false)
+
+	token, err := resolveToken(client, "s.plain", false, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "s.plain", token)
+
+	token, err = resolveToken(client, "s.wrapping", false, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "s.real", token)
+}
+
+func TestResolveTokenUsed(t *testing.T) {
+	client := fakeVault(t, true)
+
+	_, err := resolveToken(client, "s.wrapping", true, "")
+	assert.Equal(t, ErrWrappingTokenUsed, err)
+
+	// without --vault-token-wrapped, a token the wrapping store doesn't know
+	// may as well be an expired regular token
+	token, err := resolveToken(client, "s.wrapping", false, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "s.wrapping", token)
+}
+
+func TestResolveTokenRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-vault")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, TokenFileName)
+
+	token, err := resolveToken(fakeVault(t, false), "s.wrapping", true, file)
+	assert.NoError(t, err)
+	assert.Equal(t, "s.real", token)
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// after a restart the wrapping token was used, the kept token is used
+	token, err = resolveToken(fakeVault(t, true), "s.wrapping", true, file)
+	assert.NoError(t, err)
+	assert.Equal(t, "s.real", token)
+
+	// a different token isn't replaced by the kept one
+	token, err = resolveToken(fakeVault(t, true), "s.plain", false, file)
+	assert.NoError(t, err)
+	assert.Equal(t, "s.plain", token)
+}
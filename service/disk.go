@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/disk"
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/watcher"
+)
+
+// diskCheckInterval is how often the data directory is measured
+const diskCheckInterval = time.Minute * 5
+
+// diskOffenders is how many of the biggest clones are listed when the data
+// directory exceeds its size limit.
+const diskOffenders = 5
+
+// watchDiskUsage periodically measures the data directory, records it with the
+// clone sizes measured by the watcher and warns once whenever it exceeds the
+// configured limit.
+func (app *App) watchDiskUsage(ctx context.Context, gw *watcher.GitWatcher) {
+	t := time.NewTicker(diskCheckInterval)
+	defer t.Stop()
+
+	exceeded := false
+	for {
+		exceeded = app.checkDiskUsage(gw, exceeded)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (app *App) checkDiskUsage(gw *watcher.GitWatcher, exceeded bool) bool {
+	size, err := disk.Size(app.config.Directory)
+	if err != nil {
+		zap.L().Warn("failed to measure data directory", zap.Error(err))
+		return exceeded
+	}
+	clones := gw.Sizes()
+	app.metrics.ObserveDiskUsage(size, clones)
+
+	app.mu.Lock()
+	app.dataSize = size
+	app.mu.Unlock()
+	if !app.minFreeSpace().Enabled() {
+		// otherwise it's measured every check interval with the minimum
+		if s, err := disk.FreeSpace(app.config.Directory); err == nil {
+			app.metrics.SetDataFree(s.Free)
+			app.mu.Lock()
+			app.dataFree = s.Free
+			app.mu.Unlock()
+		}
+	}
+
+	if app.config.MaxDataSize <= 0 || size <= app.config.MaxDataSize {
+		if exceeded {
+			zap.L().Info("data directory is within its size limit again",
+				zap.String("size", disk.FormatSize(size)))
+		}
+		return false
+	}
+	if exceeded {
+		return true
+	}
+
+	offenders := biggest(clones, diskOffenders)
+	zap.L().Warn("data directory exceeds its size limit",
+		zap.String("size", disk.FormatSize(size)),
+		zap.String("limit", disk.FormatSize(app.config.MaxDataSize)),
+		zap.Strings("biggest", offenders))
+	go app.notifier.Notify(notifier.Event{ //nolint:errcheck
+		Type: notifier.EventDiskUsage,
+		Time: time.Now(),
+		Message: fmt.Sprintf("data directory is %s, over its limit of %s, biggest clones: %s",
+			disk.FormatSize(size),
+			disk.FormatSize(app.config.MaxDataSize),
+			strings.Join(offenders, ", ")),
+	})
+	return true
+}
+
+// biggest lists the n biggest clones with their sizes, biggest first
+func biggest(sizes map[string]int64, n int) []string {
+	names := make([]string, 0, len(sizes))
+	for name := range sizes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if sizes[names[i]] != sizes[names[j]] {
+			return sizes[names[i]] > sizes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = fmt.Sprintf("%s (%s)", name, disk.FormatSize(sizes[name]))
+	}
+	return out
+}
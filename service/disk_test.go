@@ -0,0 +1,14 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBiggest(t *testing.T) {
+	sizes := map[string]int64{"a": 1 << 20, "b": 3 << 30, "c": 1 << 20, "d": 512}
+	assert.Equal(t, []string{"b (3.0G)", "a (1.0M)", "c (1.0M)"}, biggest(sizes, 3))
+	assert.Len(t, biggest(sizes, 10), 4)
+	assert.Empty(t, biggest(nil, 3))
+}
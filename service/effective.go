@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/redact"
+	"github.com/picostack/pico/task"
+)
+
+// Where the value of a configuration field came from
+const (
+	OriginFlag        = "flag"
+	OriginEnv         = "env"
+	OriginDefault     = "default"
+	OriginSecretStore = "secret store"
+	OriginDerived     = "derived" // computed by Pico, such as the hostname
+)
+
+// sensitive matches the names of fields whose values are never shown, which
+// include the URLs that carry a token in their path or query
+var sensitive = regexp.MustCompile(`(?i)(token|password|pass|secrets?|webhook|webhookurls|pushgateway|credentials?)$`)
+
+// EffectiveConfig lists every field of the configuration with its value and
+// where it came from. Values of fields that hold secrets, by their name or
+// because they're excluded from JSON, are replaced by redact.Replacement as
+// are passwords in URLs and any value known to the redaction filter.
+func EffectiveConfig(c Config) []api.ConfigField {
+	var fields []api.ConfigField
+	walkConfig(reflect.ValueOf(c), "", c.Origins, &fields)
+	return fields
+}
+
+func walkConfig(v reflect.Value, prefix string, origins map[string]string, fields *[]api.ConfigField) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := prefix + f.Name
+		if f.Name == "Origins" || f.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			walkConfig(fv, name+".", origins, fields)
+			continue
+		}
+
+		value := formatValue(fv)
+		if value != "" && (f.Tag.Get("json") == "-" || sensitive.MatchString(f.Name)) {
+			value = redact.Replacement
+		}
+		origin, ok := origins[name]
+		if !ok {
+			origin = OriginDerived
+		}
+		*fields = append(*fields, api.ConfigField{Name: name, Value: value, Origin: origin})
+	}
+}
+
+func formatValue(v reflect.Value) string {
+	switch x := v.Interface().(type) {
+	case string:
+		return redactValue(x)
+	case []string:
+		out := make([]string, len(x))
+		for i, s := range x {
+			out[i] = redactValue(s)
+		}
+		return strings.Join(out, ",")
+	case []task.Repo:
+		out := make([]string, len(x))
+		for i, r := range x {
+			out[i] = redactValue(r.URL)
+		}
+		return strings.Join(out, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// redactValue removes known secrets and the password of URLs from a value
+func redactValue(s string) string {
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+			s = strings.Replace(u.String(), "REDACTED", redact.Replacement, 1)
+		}
+	}
+	return redact.String(s)
+}
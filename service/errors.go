@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/reconfigurer"
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/secret/vault"
+	"github.com/picostack/pico/watcher"
+)
+
+// errorKind classifies an error for logs, metrics and notifications, so alerts
+// can distinguish failures such as rejected credentials from a failed deploy
+// command. It's empty for nil and "other" for errors it doesn't recognise.
+func errorKind(err error) string {
+	var (
+		mse *executor.MissingSecretsError
+		ee  *executor.ExecError
+		pe  *executor.PanicError
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, watcher.ErrAuthFailed):
+		return "auth_failed"
+	case errors.Is(err, watcher.ErrRepoNotFound):
+		return "repo_not_found"
+	case errors.Is(err, watcher.ErrDiskFull):
+		return "disk_full"
+	case errors.As(err, &mse):
+		return "missing_secrets"
+	case errors.Is(err, vault.ErrClockSkew):
+		return "clock_skew"
+	case errors.Is(err, secret.ErrSecretDenied):
+		return "secret_denied"
+	case errors.Is(err, secret.ErrSecretUnavailable):
+		return "secret_unavailable"
+	case errors.Is(err, reconfigurer.ErrInvalidConfig):
+		return "invalid_config"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &ee):
+		return "command_failed"
+	case errors.As(err, &pe):
+		return "panic"
+	}
+	return "other"
+}
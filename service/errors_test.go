@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/reconfigurer"
+	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/secret/vault"
+	"github.com/picostack/pico/watcher"
+)
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"auth", &watcher.GitError{Err: transport.ErrAuthenticationRequired}, "auth_failed"},
+		{"not found", &watcher.GitError{Err: transport.ErrRepositoryNotFound}, "repo_not_found"},
+		{"git other", &watcher.GitError{Err: errors.New("connection reset")}, "other"},
+		{"missing secrets", &executor.MissingSecretsError{Keys: []string{"TOKEN"}}, "missing_secrets"},
+		{"secret denied", &secret.StoreError{Kind: secret.ErrSecretDenied, Err: errors.New("403")}, "secret_denied"},
+		{"clock skew", &secret.StoreError{Kind: vault.ErrClockSkew, Err: errors.New("Signature expired")}, "clock_skew"},
+		{"secret unavailable", &secret.StoreError{Kind: secret.ErrSecretUnavailable, Err: errors.New("503")}, "secret_unavailable"},
+		{"invalid config", &reconfigurer.RevisionError{Err: errors.New("syntax error")}, "invalid_config"},
+		{"timeout", errors.Wrap(context.DeadlineExceeded, "command stopped"), "timeout"},
+		{"command", &executor.ExecError{ExitCode: 1, Err: errors.New("exit status 1")}, "command_failed"},
+		{"panic", &executor.PanicError{Value: "assignment to entry in nil map"}, "panic"},
+		{"other", errors.New("something else"), "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, errorKind(errors.Wrap(tt.err, "git watcher crashed")))
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// reference matches ${VAR} and ${VAR:-default} references to environment
+// variables, and $${ which stands for a literal ${
+var reference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// unterminated matches a reference missing its closing brace, other uses of ${
+// such as ${secret:...} placeholders are left as they are
+var unterminated = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*(:-[^}]*)?$`)
+
+// expandable are the fields of the configuration whose references to
+// environment variables are expanded, all of a struct's fields for a struct.
+// Fields that are shell commands or templates, such as NotifyCommand and
+// SMTP.Subject, are left out since their own ${...} are expanded later.
+var expandable = map[string]bool{
+	"Target":          true,
+	"Sources":         true,
+	"Hostname":        true,
+	"Netrc":           true,
+	"Directory":       true,
+	"VaultAddress":    true,
+	"VaultToken":      true,
+	"VaultAWSRole":    true,
+	"VaultAWSHeader":  true,
+	"VaultPath":       true,
+	"VaultConfig":     true,
+	"AzureVaultURI":   true,
+	"GCPProject":      true,
+	"GCPSecretPrefix": true,
+	"KubeNamespace":   true,
+	"SecretsDir":      true,
+	"SSMRegion":       true,
+	"SSMPrefix":       true,
+	"SecretCacheKey":  true,
+	"Secrets":         true,
+	"ActivateFile":    true,
+	"LeaderKey":       true,
+	"AdminAddress":    true,
+	"AdminToken":      true,
+	"DebugAddress":    true,
+	"WebhookAddress":  true,
+	"BitbucketSecret": true,
+	"PushGateway":     true,
+	"PushGrouping":    true,
+	"SMTP.Host":       true,
+	"SMTP.Username":   true,
+	"SMTP.Password":   true,
+	"SMTP.From":       true,
+	"SMTP.To":         true,
+	"DiscordWebhook":  true,
+	"WebhookURLs":     true,
+	"GrafanaURL":      true,
+	"GrafanaToken":    true,
+	"HTTPProxy":       true,
+	"HTTPCABundle":    true,
+	"RunAs":           true,
+}
+
+// expandConfig replaces references to environment variables in the expandable
+// fields of the configuration, including those that hold secrets, with their
+// values looked up by lookup. A reference with a default takes it when the
+// variable is unset or empty. Every reference that can't be resolved is
+// listed in the error along with its field.
+func expandConfig(c *Config, lookup func(string) (string, bool)) error {
+	var unresolved []string
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if expandable[f.Name] {
+			expandValue(v.Field(i), f.Name, lookup, &unresolved)
+			continue
+		}
+		if v.Field(i).Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < f.Type.NumField(); j++ {
+			if name := f.Name + "." + f.Type.Field(j).Name; expandable[name] {
+				expandValue(v.Field(i).Field(j), name, lookup, &unresolved)
+			}
+		}
+	}
+	if len(unresolved) > 0 {
+		return errors.Errorf("unresolved environment variables in the configuration: %s", strings.Join(unresolved, ", "))
+	}
+	return nil
+}
+
+func expandValue(v reflect.Value, name string, lookup func(string) (string, bool), unresolved *[]string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			expandValue(v.Field(i), name+"."+f.Name, lookup, unresolved)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandValue(v.Index(i), fmt.Sprintf("%s[%d]", name, i), lookup, unresolved)
+		}
+	case reflect.String:
+		if expanded, ok := expandString(v.String(), name, lookup, unresolved); ok {
+			v.SetString(expanded)
+		}
+	}
+}
+
+// expandString returns s with its references expanded, and whether it had
+// any. References that can't be resolved are added to unresolved.
+func expandString(s, field string, lookup func(string) (string, bool), unresolved *[]string) (string, bool) {
+	if !strings.Contains(s, "${") {
+		return s, false
+	}
+	expanded := reference.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		m := reference.FindStringSubmatch(ref)
+		value, ok := lookup(m[1])
+		if m[2] != "" && value == "" {
+			return m[3]
+		}
+		if !ok {
+			*unresolved = append(*unresolved, fmt.Sprintf("%s (%s)", field, m[1]))
+		}
+		return value
+	})
+	if unterminated.MatchString(reference.ReplaceAllString(s, "")) {
+		*unresolved = append(*unresolved, fmt.Sprintf("%s (unterminated reference)", field))
+	}
+	return expanded, true
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/task"
+)
+
+func TestExpandConfig(t *testing.T) {
+	env := map[string]string{"HOST": "web-1", "TOKEN": "s3cret", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	for _, tt := range []struct {
+		name    string
+		config  Config
+		want    Config
+		wantErr string
+	}{
+		{
+			"fields",
+			Config{
+				Target:     task.Repo{URL: "https://git.${HOST}.example/config"},
+				Sources:    []task.Repo{{URL: "https://${HOST}/extra", Token: "${TOKEN}"}},
+				Directory:  "/srv/${HOST}/${ROOT:-cache}",
+				VaultToken: "${TOKEN}",
+				Secrets:    []string{"key=${TOKEN}"},
+				SMTP:       notifier.SMTPConfig{Host: "${SMTP_HOST:-localhost}", Subject: "${HOST} {{.Target}}"},
+				// commands are expanded when they run, with the event's variables
+				NotifyCommand: "curl -d ${PICO_TARGET} https://${HOST}",
+			},
+			Config{
+				Target:        task.Repo{URL: "https://git.web-1.example/config"},
+				Sources:       []task.Repo{{URL: "https://web-1/extra", Token: "s3cret"}},
+				Directory:     "/srv/web-1/cache",
+				VaultToken:    "s3cret",
+				Secrets:       []string{"key=s3cret"},
+				SMTP:          notifier.SMTPConfig{Host: "localhost", Subject: "${HOST} {{.Target}}"},
+				NotifyCommand: "curl -d ${PICO_TARGET} https://${HOST}",
+			},
+			"",
+		},
+		{
+			"defaults and literals",
+			Config{Hostname: "${EMPTY:-default}", VaultPath: "$${HOST}", ActivateFile: "${EMPTY}", VaultConfig: "${secret:key}"},
+			Config{Hostname: "default", VaultPath: "${HOST}", VaultConfig: "${secret:key}"},
+			"",
+		},
+		{
+			"unresolved",
+			Config{Directory: "${ROOT}", Sources: []task.Repo{{URL: "${REPO}"}}, VaultToken: "${TOKEN"},
+			Config{},
+			"unresolved environment variables in the configuration: Sources[0].URL (REPO), Directory (ROOT), VaultToken (unterminated reference)",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := expandConfig(&tt.config, lookup)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, tt.config)
+		})
+	}
+}
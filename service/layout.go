@@ -0,0 +1,107 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Southclaws/gitwatch"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// migrationDirectory holds repositories while they're moved into their new
+// place, so a repository named like one of the new directories, such as a
+// configuration repository called `config`, can be moved too.
+const migrationDirectory = ".pico-migration"
+
+// migrateLayout moves the repositories of a data directory from the old layout,
+// where configuration repositories and targets were all cloned at its top
+// level, into the directories of the layout. Any git repository at the top
+// level is from the old layout: those of the configuration repositories are
+// moved to the config directory and all others to the targets directory. It
+// must be called while the data directory is locked.
+func migrateLayout(l task.Layout, configRepos []string) error {
+	entries, err := ioutil.ReadDir(l.Root)
+	if err != nil {
+		return errors.Wrap(err, "failed to read data directory")
+	}
+
+	configs := make(map[string]bool, len(configRepos))
+	for _, url := range configRepos {
+		if dir, err := gitwatch.GetRepoDirectory(url); err == nil {
+			configs[dir] = true
+		}
+	}
+
+	staging := filepath.Join(l.Root, migrationDirectory)
+	var moved int
+	for _, e := range entries {
+		if !e.IsDir() || !isRepository(filepath.Join(l.Root, e.Name())) {
+			continue
+		}
+		kind := task.TargetsDirectory
+		if configs[e.Name()] {
+			kind = task.ConfigDirectory
+		}
+		if err := os.MkdirAll(filepath.Join(staging, kind), 0700); err != nil {
+			return errors.Wrap(err, "failed to create migration directory")
+		}
+		if err := os.Rename(filepath.Join(l.Root, e.Name()), filepath.Join(staging, kind, e.Name())); err != nil {
+			return errors.Wrapf(err, "failed to move %s", e.Name())
+		}
+		moved++
+	}
+
+	// an interrupted migration is finished by moving what's left in staging
+	if moved == 0 {
+		if _, err := os.Stat(staging); os.IsNotExist(err) {
+			return nil
+		}
+	}
+	zap.L().Info("migrating data directory to separate config and targets directories",
+		zap.String("directory", l.Root))
+
+	for _, kind := range []string{task.ConfigDirectory, task.TargetsDirectory} {
+		repos, err := ioutil.ReadDir(filepath.Join(staging, kind))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read migration directory")
+		}
+		dest := filepath.Join(l.Root, kind)
+		if err := os.MkdirAll(dest, 0700); err != nil {
+			return errors.Wrapf(err, "failed to create %s directory", kind)
+		}
+		for _, r := range repos {
+			from, to := filepath.Join(staging, kind, r.Name()), filepath.Join(dest, r.Name())
+			if _, err := os.Stat(to); err == nil {
+				// the new layout already has a clone, it's kept and the
+				// old one is left in staging to be removed by hand.
+				zap.L().Warn("not migrating repository, it already exists in the new layout",
+					zap.String("from", from),
+					zap.String("to", to))
+				continue
+			}
+			if err := os.Rename(from, to); err != nil {
+				return errors.Wrapf(err, "failed to move %s", r.Name())
+			}
+			zap.L().Info("migrated repository", zap.String("to", to))
+		}
+	}
+
+	// only removed once it's empty, so nothing left behind is lost
+	for _, kind := range []string{task.ConfigDirectory, task.TargetsDirectory} {
+		os.Remove(filepath.Join(staging, kind)) //nolint:errcheck
+	}
+	os.Remove(staging) //nolint:errcheck
+	return nil
+}
+
+// isRepository reports whether the directory is a git repository checkout
+func isRepository(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestMigrateLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		repos   []string // git repositories at the top level of the old layout
+		configs []string // URLs of the configuration repositories
+		want    []string // repositories after the migration
+	}{
+		{"old layout", []string{"pico-config.git", "app", "app_dev"}, []string{"https://git.example.com/ops/pico-config.git"},
+			[]string{"config/pico-config.git", "targets/app", "targets/app_dev"}},
+		{"named like the new directories", []string{"config", "targets"}, []string{"https://git.example.com/ops/config"},
+			[]string{"config/config", "targets/targets"}},
+		{"multiple sources", []string{"base.git", "extra.git", "app"}, []string{"https://git.example.com/base.git", "git@git.example.com:extra.git"},
+			[]string{"config/base.git", "config/extra.git", "targets/app"}},
+		{"new layout", nil, []string{"https://git.example.com/ops/config.git"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "pico-layout")
+			require.NoError(t, err)
+			defer os.RemoveAll(root)
+
+			for _, r := range tt.repos {
+				require.NoError(t, os.MkdirAll(filepath.Join(root, r, ".git"), 0700))
+			}
+			require.NoError(t, os.MkdirAll(filepath.Join(root, task.WorktreesDirectory, "app"), 0700))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(root, "state.json"), []byte("{}"), 0600))
+
+			l := task.Layout{Root: root}
+			require.NoError(t, migrateLayout(l, tt.configs))
+			assert.Equal(t, tt.want, repositories(t, root))
+
+			// files that aren't repositories stay where they are
+			assert.DirExists(t, filepath.Join(root, task.WorktreesDirectory, "app"))
+			assert.FileExists(t, filepath.Join(root, "state.json"))
+			_, err = os.Stat(filepath.Join(root, migrationDirectory))
+			assert.True(t, os.IsNotExist(err), "the migration directory is removed")
+
+			// migrating again changes nothing
+			require.NoError(t, migrateLayout(l, tt.configs))
+			assert.Equal(t, tt.want, repositories(t, root))
+		})
+	}
+}
+
+func TestMigrateLayoutExisting(t *testing.T) {
+	root, err := ioutil.TempDir("", "pico-layout")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	// a clone in the new layout is kept over the old one
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "app", ".git"), 0700))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "targets", "app", ".git"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "targets", "app", "new"), nil, 0600))
+
+	require.NoError(t, migrateLayout(task.Layout{Root: root}, nil))
+	assert.FileExists(t, filepath.Join(root, "targets", "app", "new"))
+	assert.DirExists(t, filepath.Join(root, migrationDirectory, "targets", "app"))
+}
+
+// repositories lists the git repositories of a data directory, relative to it
+func repositories(t *testing.T, root string) (repos []string) {
+	require.NoError(t, filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == ".git" {
+			rel, err := filepath.Rel(root, filepath.Dir(path))
+			require.NoError(t, err)
+			repos = append(repos, filepath.ToSlash(rel))
+			return filepath.SkipDir
+		}
+		return nil
+	}))
+	sort.Strings(repos)
+	return repos
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/leader"
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/secret/vault"
+	"github.com/picostack/pico/task"
+)
+
+// newElector creates a leader elector backed by the secret store, which must be
+// Vault since it's the only store that supports writes.
+func (app *App) newElector() (*leader.Elector, error) {
+	v, ok := app.secrets.(*vault.VaultSecrets)
+	if !ok {
+		return nil, errors.New("leader election requires the vault secret store")
+	}
+	holder := fmt.Sprintf("%s-%d", app.config.Hostname, os.Getpid())
+	lease := vault.Lease{Store: v, Key: app.config.LeaderKey}
+	app.shared = lease
+	app.sharedWrites = newAppliedWriter(lease)
+	return leader.New(lease, holder, app.config.LeaderTTL), nil
+}
+
+// recordShared records a target's applied commit in the lease's store, for
+// the instance that takes over leadership, an empty commit removes it. It's
+// written in the background so a slow store doesn't hold up the executor.
+func (app *App) recordShared(target, commit string) {
+	if app.sharedWrites == nil || !app.isLeader() {
+		return
+	}
+	app.sharedWrites.set(target, commit)
+}
+
+// appliedWriter writes applied commits to a shared store in the background,
+// only the latest commit of each target is written.
+type appliedWriter struct {
+	store leader.AppliedStore
+
+	mu      sync.Mutex
+	pending map[string]string
+	kick    chan struct{}
+}
+
+func newAppliedWriter(store leader.AppliedStore) *appliedWriter {
+	w := &appliedWriter{
+		store:   store,
+		pending: make(map[string]string),
+		kick:    make(chan struct{}, 1),
+	}
+	go w.run()
+	return w
+}
+
+func (w *appliedWriter) set(target, commit string) {
+	w.mu.Lock()
+	w.pending[target] = commit
+	w.mu.Unlock()
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (w *appliedWriter) run() {
+	for range w.kick {
+		w.mu.Lock()
+		pending := w.pending
+		w.pending = make(map[string]string)
+		w.mu.Unlock()
+
+		targets := make([]string, 0, len(pending))
+		for target := range pending {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+		for _, target := range targets {
+			if err := w.store.SetApplied(target, pending[target]); err != nil {
+				zap.L().Warn("failed to record applied commit for other instances",
+					zap.String("target", target),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+// appliedFunc returns how deployPending looks up the commit a target was last
+// applied at. With leader election it's the commit any instance applied, so a
+// new leader doesn't deploy again what the previous one already did, falling
+// back to this instance's state for targets it hasn't recorded.
+func (app *App) appliedFunc() func(target string) string {
+	if app.shared == nil {
+		return app.state.Applied
+	}
+	shared, err := app.shared.Applied()
+	if err != nil {
+		zap.L().Warn("failed to read the commits other instances applied, comparing with this instance's",
+			zap.Error(err))
+	}
+	return func(target string) string {
+		if commit, ok := shared[target]; ok {
+			return commit
+		}
+		return app.state.Applied(target)
+	}
+}
+
+// isLeader reports whether this instance should execute tasks, without leader
+// election every instance is a leader.
+func (app *App) isLeader() bool {
+	return atomic.LoadInt32(&app.leader) == 1
+}
+
+// gate forwards tasks from the watcher to the executor only while this instance
+// is the leader, not in standby and has enough disk space. Tasks received in
+// standby are recorded as suppressed, those received while not the leader are
+// dropped and those received while the disk is low on space are held.
+func (app *App) gate(in, out chan task.ExecutionTask) {
+	for t := range in {
+		if app.isStandby() {
+			app.suppress(t)
+			continue
+		}
+		if !app.isLeader() {
+			zap.L().Debug("standby instance, not executing task",
+				zap.String("target", t.Target.Name),
+				zap.Bool("shutdown", t.Shutdown))
+			continue
+		}
+		if app.holdForSpace(t) {
+			continue
+		}
+		out <- t
+	}
+}
+
+// runElection campaigns for leadership and, once elected, deploys every target
+// whose checked out commit has not already been applied, by any instance as
+// recorded in the lease's store or, for targets it has no record of, by this
+// one.
+func (app *App) runElection(ctx context.Context, e *leader.Elector, out chan task.ExecutionTask) error {
+	return e.Run(ctx, func(isLeader bool) {
+		var v int32
+		if isLeader {
+			v = 1
+		}
+		atomic.StoreInt32(&app.leader, v)
+
+		msg := "this instance is now a standby"
+		if isLeader {
+			msg = "this instance is now the leader"
+		}
+		zap.L().Info(msg, zap.String("hostname", app.config.Hostname))
+		go app.notifier.Notify(notifier.Event{ //nolint:errcheck
+			Type:    notifier.EventLeadershipChanged,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("%s: %s", app.config.Hostname, msg),
+		})
+
+		if isLeader && app.isStandby() {
+			// winning the election activates a standby instance
+			if err := app.Activate("leader election"); err == nil {
+				return
+			}
+		}
+		if isLeader {
+			go app.deployPending(out, task.TriggerLeader, "", false)
+		}
+	})
+}
+
+// deployPending deploys every enabled target whose checked out commit hasn't
+// been applied on this host, or every enabled target if all is set, when an
+// instance starts executing tasks after it didn't.
+func (app *App) deployPending(out chan task.ExecutionTask, trigger task.Trigger, detail string, all bool) {
+	state := app.watcher.GetState()
+	applied := app.appliedFunc()
+	for _, t := range state.Targets {
+		if !t.IsEnabled() {
+			continue
+		}
+		path := app.layout.Target(t)
+		head := task.HeadCommit(path)
+		if !all && head != "" && head == applied(t.Name) {
+			zap.L().Debug("target already at applied commit, not deploying to catch up",
+				zap.String("target", t.Name),
+				zap.String("trigger", string(trigger)),
+				zap.String("commit", head),
+				t.LabelsField())
+			continue
+		}
+		if err := t.CheckApproval(path, head); err != nil {
+			zap.L().Warn("not deploying target to catch up, commit is pending approval",
+				zap.String("target", t.Name),
+				zap.String("trigger", string(trigger)),
+				zap.String("commit", head),
+				t.LabelsField(),
+				zap.Error(err))
+			continue
+		}
+		id := task.NewTaskID()
+		zap.L().Info("deploying target to catch up",
+			zap.String("task_id", id),
+			zap.String("target", t.Name),
+			zap.String("trigger", string(trigger)),
+			zap.String("commit", head),
+			t.LabelsField())
+		out <- task.ExecutionTask{
+			ID:       id,
+			Target:   t,
+			Path:     path,
+			Commit:   head,
+			Priority: t.Priority,
+			Trigger:  trigger,
+			Detail:   detail,
+			Env:      state.Env,
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type slowStore struct {
+	mu      sync.Mutex
+	block   chan struct{}
+	written []string
+}
+
+func (s *slowStore) Applied() (map[string]string, error) { return nil, nil }
+
+func (s *slowStore) SetApplied(target, commit string) error {
+	<-s.block
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, target+"="+commit)
+	return nil
+}
+
+func (s *slowStore) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.written...)
+}
+
+func TestAppliedWriter(t *testing.T) {
+	store := &slowStore{block: make(chan struct{})}
+	w := newAppliedWriter(store)
+
+	// setting doesn't wait for the store
+	done := make(chan struct{})
+	go func() {
+		w.set("app", "a1")
+		assert.Eventually(t, func() bool {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			return len(w.pending) == 0
+		}, 5*time.Second, time.Millisecond)
+		w.set("app", "a2")
+		w.set("app", "a3")
+		w.set("db", "d1")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("set waited for the store")
+	}
+
+	close(store.block)
+	assert.Eventually(t, func() bool { return len(store.get()) == 3 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []string{"app=a1", "app=a3", "db=d1"}, store.get())
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// lockFileName is the name of the lock file inside the data directory
+const lockFileName = ".pico.lock"
+
+// dirLock is an exclusive advisory lock on a data directory that prevents two
+// Pico instances from operating on the same repositories. The lock is owned by
+// the open file, so it's released by the OS if the process crashes.
+type dirLock struct {
+	file *os.File
+}
+
+// lockDirectory takes the lock for the given directory, creating it if needed
+func lockDirectory(dir string) (*dirLock, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create data directory")
+	}
+
+	path := filepath.Join(dir, lockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open lock file")
+	}
+
+	if err := flock(f); err != nil {
+		f.Close()
+		if err == errLocked {
+			return nil, errors.Errorf("another pico instance (pid %s) is using the directory %s", readPID(path), dir)
+		}
+		return nil, errors.Wrap(err, "failed to lock data directory")
+	}
+
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to truncate lock file")
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "failed to write pid to lock file")
+	}
+
+	return &dirLock{file: f}, nil
+}
+
+// release unlocks and closes the lock file, the file itself is left in place
+// since removing it would race with another instance acquiring it.
+func (l *dirLock) release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	if err := funlock(l.file); err != nil {
+		return errors.Wrap(err, "failed to unlock data directory")
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func readPID(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil || len(b) == 0 {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(b))
+}
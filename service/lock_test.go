@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-lock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := lockDirectory(dir)
+	assert.NoError(t, err)
+
+	_, err = lockDirectory(dir)
+	assert.EqualError(t, err, "another pico instance (pid "+strconv.Itoa(os.Getpid())+") is using the directory "+dir)
+
+	assert.NoError(t, l.release())
+
+	l, err = lockDirectory(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, l.release())
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+var errLocked = errors.New("lock is held by another process")
+
+func flock(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+func funlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package service
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var errLocked = errors.New("lock is held by another process")
+
+// Windows has no flock, so locking is a no-op there.
+func flock(f *os.File) error { return nil }
+
+func funlock(f *os.File) error { return nil }
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/task"
+	"github.com/picostack/pico/watcher"
+)
+
+// channels are the notifiers declared in the configuration that targets route
+// their notifications to by name. Each is only created again when its
+// declaration changes, so events queued for delivery aren't lost.
+type channels struct {
+	client *http.Client
+	secret string // signs the events posted by webhook notifiers
+
+	mu       sync.Mutex
+	declared map[string]config.Notifier
+	created  map[string]notifier.Notifier
+}
+
+func newChannels(client *http.Client, secret string) *channels {
+	return &channels{
+		client:   client,
+		secret:   secret,
+		declared: make(map[string]config.Notifier),
+		created:  make(map[string]notifier.Notifier),
+	}
+}
+
+// set replaces the declared notifiers with those of a new configuration
+func (c *channels) set(declared []config.Notifier) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := make(map[string]bool, len(declared))
+	for _, d := range declared {
+		names[d.Name] = true
+		if c.declared[d.Name] == d {
+			continue
+		}
+		c.declared[d.Name] = d
+		switch d.Type {
+		case config.NotifierDiscord:
+			c.created[d.Name] = notifier.NewDiscord(d.URL, c.client)
+		case config.NotifierWebhook:
+			c.created[d.Name] = notifier.NewWebhook([]string{d.URL}, c.secret, c.client)
+		case config.NotifierCommand:
+			c.created[d.Name] = &notifier.Command{Command: d.Command}
+		}
+	}
+	for name := range c.declared {
+		if !names[name] {
+			delete(c.declared, name)
+			delete(c.created, name)
+		}
+	}
+}
+
+// get returns the declared notifier of the name
+func (c *channels) get(name string) (notifier.Notifier, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.created[name]
+	return n, ok
+}
+
+// routingWatcher passes states on to the watcher after recording the notifiers
+// they declare, so they're known before any task of the state is notified. The
+// number of targets is kept for status reporting, which can't wait on the
+// watcher while it's busy.
+type routingWatcher struct {
+	watcher.Watcher
+	app *App
+}
+
+func (w routingWatcher) SetState(state config.State) error {
+	w.app.channels.set(state.Notifiers)
+	if err := w.Watcher.SetState(state); err != nil {
+		return err
+	}
+	w.app.mu.Lock()
+	w.app.targets = len(state.Targets)
+	w.app.mu.Unlock()
+	return nil
+}
+
+// notify delivers an event of a target's task to the notifier its
+// notifications are routed to, or to Pico's notifiers if they aren't.
+func (app *App) notify(t task.Target, e notifier.Event) {
+	if n := t.Notifications; n != nil && n.Channel != "" {
+		if ch, ok := app.channels.get(n.Channel); ok {
+			go notifier.Multi{ch}.Notify(e) //nolint:errcheck
+			return
+		}
+		zap.L().Warn("target notifier is not declared, notifying Pico's notifiers instead",
+			zap.String("target", t.Name),
+			zap.String("channel", n.Channel))
+	}
+	go app.notifier.Notify(e) //nolint:errcheck
+}
+
+// recovered reports whether the newest execution of the target succeeded after
+// the one before it failed.
+func (app *App) recovered(target string) bool {
+	records := app.history.Get(target)
+	return len(records) > 1 && records[0].Error == "" && records[1].Error != ""
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/task"
+)
+
+func TestChannels(t *testing.T) {
+	c := newChannels(nil, "")
+	ops := config.Notifier{Name: "ops", Type: config.NotifierCommand, Command: "true"}
+	c.set([]config.Notifier{ops})
+	first, ok := c.get("ops")
+	require.True(t, ok)
+
+	// an unchanged declaration keeps its notifier, a changed one replaces it
+	c.set([]config.Notifier{ops})
+	same, _ := c.get("ops")
+	assert.True(t, first == same)
+	ops.Command = "false"
+	c.set([]config.Notifier{ops})
+	changed, _ := c.get("ops")
+	assert.False(t, first == changed)
+
+	c.set(nil)
+	_, ok = c.get("ops")
+	assert.False(t, ok)
+}
+
+func TestRecovered(t *testing.T) {
+	app := &App{history: executor.NewHistory(10, nil)}
+	target := task.Target{Name: "app"}
+	add := func(err error) {
+		app.history.Add(executor.Result{Task: task.ExecutionTask{Target: target}, Finished: time.Now(), Err: err})
+	}
+
+	add(nil)
+	assert.False(t, app.recovered("app"))
+	add(errors.New("exit status 1"))
+	assert.False(t, app.recovered("app"))
+	add(nil)
+	assert.True(t, app.recovered("app"))
+	add(nil)
+	assert.False(t, app.recovered("app"))
+}
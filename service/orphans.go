@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+	"github.com/picostack/pico/watcher"
+)
+
+// orphanCheckInterval is how often compose projects are reconciled with the
+// configured targets
+const orphanCheckInterval = time.Minute * 10
+
+// orphan is a running compose project deployed by Pico for a target that's no
+// longer configured, such as one that was renamed.
+type orphan struct {
+	project string
+	targets []string // the targets it was deployed for
+}
+
+// findOrphans picks the running compose projects that belong to no configured
+// target. A project is Pico's if its containers are labelled with a target or
+// if the state records a target deploying it, projects of neither are left
+// alone.
+func findOrphans(projects []executor.Project, targets []task.Target, recorded map[string]state.Target) (orphans []orphan) {
+	configured := make(map[string]bool, len(targets))
+	inUse := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		configured[t.Name] = true
+		if p := recorded[t.Name].Project; p != "" {
+			inUse[p] = true
+		}
+	}
+	deployedBy := make(map[string][]string)
+	for name, t := range recorded {
+		if t.Project != "" && !configured[name] {
+			deployedBy[t.Project] = append(deployedBy[t.Project], name)
+		}
+	}
+
+	for _, p := range projects {
+		if inUse[p.Name] {
+			continue
+		}
+		owners := deployedBy[p.Name]
+		for _, t := range p.Targets {
+			if configured[t] {
+				owners = nil
+				break
+			}
+			owners = append(owners, t)
+		}
+		if len(owners) == 0 {
+			continue
+		}
+		orphans = append(orphans, orphan{p.Name, dedupe(owners)})
+	}
+	return
+}
+
+func dedupe(names []string) []string {
+	sort.Strings(names)
+	out := names[:0]
+	for i, n := range names {
+		if i == 0 || n != names[i-1] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// reconcileOrphans periodically looks for compose projects of targets that
+// are no longer configured once the watcher has the configuration. They're
+// listed unless orphans are removed, which only the active leader does.
+func (app *App) reconcileOrphans(ctx context.Context, gw *watcher.GitWatcher) {
+	if !executor.DockerReachable() {
+		zap.L().Debug("no Docker daemon, not looking for orphaned compose projects")
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-gw.Ready():
+	}
+
+	t := time.NewTicker(orphanCheckInterval)
+	defer t.Stop()
+
+	reported := make(map[string]bool)
+	for {
+		app.checkOrphans(ctx, reported)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkOrphans lists or removes orphaned compose projects, each is listed once
+// until it's gone.
+func (app *App) checkOrphans(ctx context.Context, reported map[string]bool) {
+	targets := app.watcher.GetState().Targets
+	if len(targets) == 0 {
+		// without any target configured every project would look orphaned,
+		// more likely the configuration is missing than every target removed
+		return
+	}
+	projects, err := executor.ComposeProjects(ctx)
+	if err != nil {
+		zap.L().Warn("failed to list compose projects", zap.Error(err))
+		return
+	}
+
+	remove := app.config.RemoveOrphans && app.isLeader() && !app.isStandby()
+	running := make(map[string]bool, len(projects))
+	for _, o := range findOrphans(projects, targets, app.state.Targets()) {
+		running[o.project] = true
+		if !remove {
+			if !reported[o.project] {
+				zap.L().Warn("orphaned compose project of a target that is no longer configured, run with --remove-orphans to tear it down",
+					zap.String("project", o.project),
+					zap.String("targets", strings.Join(o.targets, ", ")))
+				reported[o.project] = true
+			}
+			continue
+		}
+
+		zap.L().Info("removing orphaned compose project",
+			zap.String("project", o.project),
+			zap.String("targets", strings.Join(o.targets, ", ")))
+		if err := executor.RemoveProject(ctx, o.project); err != nil {
+			zap.L().Error("failed to remove orphaned compose project",
+				zap.String("project", o.project),
+				zap.Error(err))
+			continue
+		}
+		for _, name := range o.targets {
+			if err := app.state.Remove(name); err != nil {
+				zap.L().Error("failed to persist target state",
+					zap.String("target", name),
+					zap.Error(err))
+			}
+		}
+	}
+	for p := range reported {
+		if !running[p] {
+			delete(reported, p)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+func TestFindOrphans(t *testing.T) {
+	targets := []task.Target{{Name: "web"}, {Name: "api"}}
+	recorded := map[string]state.Target{
+		"web":     {Project: "web"},
+		"old-web": {Project: "oldweb"},
+		"gone":    {Project: "gone"},
+		"api":     {Project: "shared"},
+		"old-api": {Project: "shared"},
+	}
+	tests := []struct {
+		name     string
+		projects []executor.Project
+		want     []orphan
+	}{
+		{"configured", []executor.Project{{Name: "web"}}, nil},
+		{"renamed", []executor.Project{{Name: "web"}, {Name: "oldweb"}}, []orphan{{"oldweb", []string{"old-web"}}}},
+		{"unmanaged", []executor.Project{{Name: "monitoring"}}, nil},
+		{"labelled", []executor.Project{{Name: "db", Targets: []string{"database"}}}, []orphan{{"db", []string{"database"}}}},
+		{"labelled configured", []executor.Project{{Name: "apiv2", Targets: []string{"api"}}}, nil},
+		{"labelled and recorded", []executor.Project{{Name: "gone", Targets: []string{"gone"}}}, []orphan{{"gone", []string{"gone"}}}},
+		{"still used", []executor.Project{{Name: "shared"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, findOrphans(tt.projects, targets, recorded))
+		})
+	}
+}
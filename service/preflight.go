@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Southclaws/gitwatch"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/secret"
+	memorysecrets "github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+	"github.com/picostack/pico/watcher"
+)
+
+// Outcomes of preflight checks
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// CheckResult is the outcome of a single preflight check
+type CheckResult struct {
+	Name    string
+	Outcome string
+	Message string
+}
+
+func (r CheckResult) String() string {
+	return fmt.Sprintf("%s  %s: %s", r.Outcome, r.Name, r.Message)
+}
+
+// Check runs the preflight checks for the configuration without starting
+// Pico or taking the data directory's lock, for pico run --check.
+func Check(c Config) []CheckResult {
+	if err := expandConfig(&c, os.LookupEnv); err != nil {
+		return []CheckResult{{"configuration", CheckFail, err.Error()}}
+	}
+	store, _, warnings, err := openSecretStore(c)
+	if err != nil {
+		return append(preflight(c, nil), CheckResult{"secret store", CheckFail, err.Error()})
+	}
+	results := make([]CheckResult, 0, len(warnings))
+	for _, w := range warnings {
+		results = append(results, CheckResult{"secret backend", CheckWarn, w})
+	}
+	injected, err := parseSecrets(c.Secrets, c.VaultConfig)
+	if err != nil {
+		return append(preflight(c, store), CheckResult{"secrets", CheckFail, err.Error()})
+	}
+	if store == nil {
+		return append(preflight(c, injected), results...)
+	}
+	if len(c.Secrets) > 0 {
+		store = memorysecrets.Layer(store, injected)
+	}
+	return append(preflight(c, store), results...)
+}
+
+// Failed reports whether any of the checks failed
+func Failed(results []CheckResult) bool {
+	for _, r := range results {
+		if r.Outcome == CheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+// preflight verifies the environment Pico runs in: that the data directory is
+// writable, the secret store is reachable, the configuration repositories can
+// be listed and the commands of the targets they declared when last checked
+// out can be found.
+func preflight(c Config, store secret.Store) []CheckResult {
+	results := []CheckResult{checkDataDirectory(c.Directory)}
+
+	var secretConfig map[string]string
+	if store != nil {
+		r := CheckResult{"secret store", CheckPass, "reachable"}
+		var err error
+		if secretConfig, err = store.GetSecretsForTarget(c.VaultConfig); err != nil {
+			r.Outcome, r.Message = CheckFail, err.Error()
+		}
+		results = append(results, r)
+	}
+
+	layout := task.Layout{Root: c.Directory}
+	var targets []task.Target
+	for _, repo := range append([]task.Repo{c.Target}, c.Sources...) {
+		results = append(results, checkRemote(c, repo, secretConfig))
+
+		found, r := checkoutTargets(c, layout, repo.URL)
+		if r != nil {
+			results = append(results, *r)
+		}
+		targets = append(targets, found...)
+	}
+	return append(results, checkCommands(c, targets)...)
+}
+
+// logChecks logs the outcome of each check at a level matching its outcome
+func logChecks(results []CheckResult) {
+	for _, r := range results {
+		fields := []zap.Field{zap.String("check", r.Name), zap.String("result", r.Message)}
+		switch r.Outcome {
+		case CheckPass:
+			zap.L().Info("preflight check passed", fields...)
+		case CheckWarn:
+			zap.L().Warn("preflight check warning", fields...)
+		default:
+			zap.L().Error("preflight check failed", fields...)
+		}
+	}
+}
+
+func checkDataDirectory(dir string) CheckResult {
+	r := CheckResult{Name: "data directory", Outcome: CheckPass}
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		r.Outcome, r.Message = CheckWarn, fmt.Sprintf("%s does not exist yet, it will be created", dir)
+		return r
+	} else if err != nil {
+		r.Outcome, r.Message = CheckFail, err.Error()
+		return r
+	} else if !fi.IsDir() {
+		r.Outcome, r.Message = CheckFail, fmt.Sprintf("%s is not a directory", dir)
+		return r
+	}
+
+	if err := checkWritable(dir); err != nil {
+		r.Outcome, r.Message = CheckFail, fmt.Sprintf("%s is not writable: %v", dir, err)
+		return r
+	}
+	r.Message = fmt.Sprintf("%s is writable", dir)
+	return r
+}
+
+// checkRemote lists the references of a configuration repository, the same as
+// git ls-remote, with the credentials it will be cloned with.
+func checkRemote(c Config, repo task.Repo, secretConfig map[string]string) CheckResult {
+	r := CheckResult{Name: "repository " + repo.URL, Outcome: CheckPass}
+	auth, err := getAuthMethod(c, repo, secretConfig)
+	if err != nil {
+		r.Outcome, r.Message = CheckFail, err.Error()
+		return r
+	}
+	refs, err := watcher.ListRemote(context.Background(), c.GitTimeout, repo.URL, auth)
+	if err != nil {
+		r.Outcome, r.Message = CheckFail, err.Error()
+		if kind := errorKind(&watcher.GitError{URL: repo.URL, Err: err}); kind != "other" {
+			r.Message += " (" + kind + ")"
+		}
+		return r
+	}
+	r.Message = fmt.Sprintf("reachable, %d references", len(refs))
+	return r
+}
+
+// checkoutTargets evaluates the existing checkout of a configuration repository
+// for its targets, there's no result unless it can't be evaluated.
+func checkoutTargets(c Config, layout task.Layout, url string) ([]task.Target, *CheckResult) {
+	name := "configuration " + url
+	dir, err := gitwatch.GetRepoDirectory(url)
+	if err != nil {
+		return nil, &CheckResult{name, CheckFail, err.Error()}
+	}
+	path := filepath.Join(layout.Config(), dir)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, &CheckResult{name, CheckWarn, "not checked out yet, the commands of its targets can't be checked"}
+	}
+	state, _, err := config.ConfigFromDirectory(path, config.Builtins{
+		Hostname: c.Hostname,
+		Version:  c.Version,
+		Env:      c.ConfigEnv,
+	})
+	if err != nil {
+		return nil, &CheckResult{name, CheckFail, err.Error()}
+	}
+	return state.Targets, nil
+}
+
+// checkCommands looks up the executable of every target command on the PATH,
+// as well as the shell when notify commands are used. Executables given as
+// paths relative to the target's repository aren't checked.
+func checkCommands(c Config, targets []task.Target) []CheckResult {
+	users := make(map[string][]string)
+	use := func(command, target string) {
+		if command == "" || (strings.ContainsRune(command, '/') && !filepath.IsAbs(command)) {
+			return
+		}
+		for _, u := range users[command] {
+			if u == target {
+				return
+			}
+		}
+		users[command] = append(users[command], target)
+	}
+	if c.NotifyCommand != "" {
+		use("sh", "--notify-command")
+	}
+	for _, t := range targets {
+		if !t.IsEnabled() {
+			continue
+		}
+		for _, cmd := range [][]string{t.Init, t.Up, t.Down} {
+			if len(cmd) > 0 {
+				use(cmd[0], t.Name)
+			}
+		}
+		if t.NotifyCommand != "" {
+			use("sh", t.Name)
+		}
+	}
+
+	commands := make([]string, 0, len(users))
+	for command := range users {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	results := make([]CheckResult, 0, len(commands))
+	for _, command := range commands {
+		r := CheckResult{Name: "command " + command, Outcome: CheckPass}
+		used := strings.Join(users[command], ", ")
+		if path, err := exec.LookPath(command); err != nil {
+			r.Outcome, r.Message = CheckFail, fmt.Sprintf("not found on PATH, used by %s", used)
+		} else {
+			r.Message = fmt.Sprintf("%s, used by %s", path, used)
+		}
+		results = append(results, r)
+	}
+	return results
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/task"
+)
+
+func TestPreflight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-preflight")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "config")
+	commitRepo(t, repo, map[string]string{"targets.js": `T({name: "app", url: "../app", up: ["sh", "deploy.sh"], down: ["pico-missing-command"]})`})
+
+	data := filepath.Join(dir, "data")
+	c := Config{
+		Target:    task.Repo{URL: repo},
+		Directory: data,
+	}
+	results := preflight(c, memory.New(nil))
+	assert.Equal(t, []string{CheckWarn, CheckPass, CheckPass, CheckWarn}, outcomes(results))
+	assert.False(t, Failed(results))
+
+	// with a checkout, the commands of its targets are checked too
+	commitRepo(t, filepath.Join(data, task.ConfigDirectory, "config"), map[string]string{"targets.js": `
+T({name: "app", url: "../app", up: ["sh", "deploy.sh"], down: ["pico-missing-command"]});
+T({name: "local", url: "../local", up: ["./deploy.sh"]});
+`})
+	c.Sources = []task.Repo{{URL: filepath.Join(dir, "missing")}}
+	results = preflight(c, memory.New(nil))
+	assert.Equal(t, []CheckResult{
+		{"data directory", CheckPass, data + " is writable"},
+		{"secret store", CheckPass, "reachable"},
+		{"repository " + repo, CheckPass, "reachable, 2 references"},
+		{"repository " + filepath.Join(dir, "missing"), CheckFail, "failed to list " + filepath.Join(dir, "missing") + ": repository not found (repo_not_found)"},
+		{"configuration " + filepath.Join(dir, "missing"), CheckWarn, "not checked out yet, the commands of its targets can't be checked"},
+		{"command pico-missing-command", CheckFail, "not found on PATH, used by app"},
+		results[6],
+	}, results)
+	assert.Equal(t, "command sh", results[6].Name)
+	assert.Equal(t, CheckPass, results[6].Outcome)
+	assert.True(t, Failed(results))
+}
+
+func TestCheckDataDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-preflight")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0o600))
+
+	assert.Equal(t, CheckPass, checkDataDirectory(dir).Outcome)
+	assert.Equal(t, CheckWarn, checkDataDirectory(filepath.Join(dir, "missing")).Outcome)
+	assert.Equal(t, CheckFail, checkDataDirectory(file).Outcome)
+}
+
+func outcomes(results []CheckResult) (out []string) {
+	for _, r := range results {
+		out = append(out, r.Outcome)
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/secret/cache"
+)
+
+// identity is the user and group the process switches to with --run-as.
+type identity struct {
+	spec   string
+	uid    int
+	gid    int
+	groups []int // the supplementary groups, gid and every group of the user
+}
+
+// lookupIdentity resolves a user[:group] specification, by name or numeric ID,
+// to the IDs to switch to. The group defaults to the user's primary group, the
+// user keeps its supplementary groups, such as docker for the Docker socket.
+func lookupIdentity(spec string) (*identity, error) {
+	if !canSetIdentity {
+		return nil, errors.New("run-as is not supported on this platform")
+	}
+	name, group := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, group = spec[:i], spec[i+1:]
+	}
+	if name == "" {
+		return nil, errors.Errorf("invalid run-as user '%s'", spec)
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, errors.Errorf("unknown run-as user '%s'", name)
+		}
+	}
+	id := &identity{spec: spec}
+	if id.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, errors.Errorf("user '%s' has no numeric ID", name)
+	}
+
+	gid := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, errors.Errorf("unknown run-as group '%s'", group)
+			}
+		}
+		gid = g.Gid
+	}
+	if id.gid, err = strconv.Atoi(gid); err != nil {
+		return nil, errors.Errorf("group '%s' has no numeric ID", gid)
+	}
+
+	groups, err := u.GroupIds()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to look up the groups of run-as user '%s'", name)
+	}
+	id.groups = []int{id.gid}
+	for _, g := range groups {
+		if n, err := strconv.Atoi(g); err == nil && n != id.gid {
+			id.groups = append(id.groups, n)
+		}
+	}
+	return id, nil
+}
+
+// dropPrivileges switches the process to the --run-as identity, if one is set,
+// and checks the paths Pico writes to are still writable afterwards. Listeners
+// and credentials must already be set up as they may no longer be accessible.
+func (app *App) dropPrivileges() error {
+	id := app.identity
+	if id == nil {
+		return nil
+	}
+	if err := setIdentity(id.uid, id.gid, id.groups); err != nil {
+		return errors.Wrapf(err, "failed to run as %s", id.spec)
+	}
+	if err := app.checkAccess(); err != nil {
+		return errors.Wrapf(err, "not accessible by %s", id.spec)
+	}
+	zap.L().Info("dropped privileges",
+		zap.String("run_as", id.spec),
+		zap.Int("uid", id.uid),
+		zap.Int("gid", id.gid),
+		zap.Ints("groups", id.groups))
+	return nil
+}
+
+// checkAccess checks the data directory, and the directories in it Pico writes
+// clones and cached secrets to, are writable and that the activate file can be
+// seen. The state file is replaced rather than written to, so only its
+// directory needs to be writable. Directories that don't exist yet are created
+// later, inside ones that are checked.
+func (app *App) checkAccess() error {
+	if err := checkWritable(app.config.Directory); err != nil {
+		return errors.Wrap(err, "data directory")
+	}
+	dirs := []string{app.layout.Config(), app.layout.Targets(), app.layout.Worktrees()}
+	if app.config.SecretCacheKey != "" {
+		dirs = append(dirs, filepath.Join(app.config.Directory, cache.Directory))
+	}
+	for _, dir := range dirs {
+		if err := checkWritable(dir); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "directory %s", dir)
+		}
+	}
+	if app.config.ActivateFile != "" {
+		if _, err := os.Stat(app.config.ActivateFile); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "activate file")
+		}
+	}
+	return nil
+}
+
+// checkWritable creates and removes a file in the directory.
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".pico-write-check")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestLookupIdentity(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("run-as is not supported on windows")
+	}
+	current, err := user.Current()
+	require.NoError(t, err)
+	uid, _ := strconv.Atoi(current.Uid)
+	gid, _ := strconv.Atoi(current.Gid)
+
+	tests := []struct {
+		name    string
+		spec    string
+		wantUID int
+		wantGID int
+		wantErr bool
+	}{
+		{"name", current.Username, uid, gid, false},
+		{"id", current.Uid, uid, gid, false},
+		{"name and group id", current.Username + ":" + current.Gid, uid, gid, false},
+		{"unknown user", "pico-no-such-user", 0, 0, true},
+		{"unknown group", current.Username + ":pico-no-such-group", 0, 0, true},
+		{"empty user", ":" + current.Gid, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := lookupIdentity(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantUID, id.uid)
+			assert.Equal(t, tt.wantGID, id.gid)
+			assert.Equal(t, tt.wantGID, id.groups[0])
+			want, err := current.GroupIds()
+			require.NoError(t, err)
+			for _, g := range want {
+				n, _ := strconv.Atoi(g)
+				assert.Contains(t, id.groups, n)
+			}
+		})
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-writable")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, checkWritable(dir))
+	assert.Error(t, checkWritable(filepath.Join(dir, "missing")))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestCheckAccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-access")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	app := &App{config: Config{Directory: dir, SecretCacheKey: "key"}, layout: task.Layout{Root: dir}}
+
+	// directories that don't exist yet are created later
+	assert.NoError(t, app.checkAccess())
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, task.TargetsDirectory), nil, 0o644))
+	err = app.checkAccess()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(dir, task.TargetsDirectory))
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const canSetIdentity = true
+
+// setIdentity sets the supplementary groups, group and user in that order, as
+// the group can't be changed once the user is no longer privileged. Each call
+// applies to every thread of the process, Go versions that can't guarantee
+// that return an error rather than switching only some threads.
+func setIdentity(uid, gid int, groups []int) error {
+	if os.Getuid() == uid && os.Getgid() == gid {
+		return nil
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return errors.Wrap(err, "failed to set supplementary groups")
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return errors.Wrap(err, "failed to set group")
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return errors.Wrap(err, "failed to set user")
+	}
+	if os.Getuid() != uid || os.Getgid() != gid {
+		return errors.New("identity did not change")
+	}
+	return nil
+}
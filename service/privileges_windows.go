@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package service
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Windows has no setuid, run Pico as the intended service account instead.
+const canSetIdentity = false
+
+func setIdentity(uid, gid int, groups []int) error {
+	return errors.New("run-as is not supported on windows")
+}
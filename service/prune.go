@@ -0,0 +1,122 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/disk"
+)
+
+// pruneTimeout bounds how long a single image prune may take
+const pruneTimeout = time.Minute * 10
+
+// pruneCommand builds the command that prunes unused images created at least
+// until ago. Not only dangling ones: the images a redeploy superseded are still
+// tagged, and any image no container uses is pulled again when it's needed.
+var pruneCommand = func(ctx context.Context, until time.Duration) *exec.Cmd {
+	return exec.CommandContext(ctx, "docker", "image", "prune", "-a", "-f", "--filter", "until="+until.String())
+}
+
+// pruneImages prunes unused images whenever a deploy is reported on the
+// channel, at most once per prune interval however often targets deploy. A
+// deploy reported while it can't prune is dropped, the next one prunes.
+func (app *App) pruneImages(ctx context.Context, deployed <-chan struct{}) {
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deployed:
+		}
+
+		if !last.IsZero() && time.Since(last) < app.config.PruneInterval {
+			zap.L().Debug("skipping image prune, pruned recently",
+				zap.Time("last", last),
+				zap.Duration("interval", app.config.PruneInterval))
+			continue
+		}
+		last = time.Now()
+
+		reclaimed, err := prune(ctx, app.config.PruneUntil)
+		app.metrics.ObservePrune(reclaimed, err)
+		if err != nil {
+			// the deploy already succeeded, a prune failure is only reported
+			zap.L().Warn("failed to prune images after deploy", zap.Error(err))
+			continue
+		}
+		zap.L().Info("pruned unused images",
+			zap.String("reclaimed", disk.FormatSize(reclaimed)),
+			zap.Int64("reclaimed_bytes", reclaimed))
+	}
+}
+
+// notifyDeployed reports a successful deploy of a target to the image pruner,
+// if pruning is enabled for the target.
+func (app *App) notifyDeployed(target string, prune bool) {
+	if app.deployed == nil || !prune {
+		return
+	}
+	select {
+	case app.deployed <- struct{}{}:
+	default:
+		zap.L().Debug("image prune already pending", zap.String("target", target))
+	}
+}
+
+// prune runs the prune command and returns the space it reclaimed
+func prune(ctx context.Context, until time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, pruneTimeout)
+	defer cancel()
+
+	out, err := pruneCommand(ctx, until).CombinedOutput()
+	if err != nil {
+		return 0, errors.Wrapf(err, "image prune failed: %s", strings.TrimSpace(string(out)))
+	}
+	return parseReclaimed(out)
+}
+
+// decimal units, as Docker reports sizes
+var reclaimedUnits = []struct {
+	suffix string
+	size   float64
+}{
+	{"TB", 1e12},
+	{"GB", 1e9},
+	{"MB", 1e6},
+	{"kB", 1e3},
+	{"B", 1},
+}
+
+// parseReclaimed reads the space reclaimed from the output of a prune, such
+// as "Total reclaimed space: 1.2GB".
+func parseReclaimed(out []byte) (int64, error) {
+	const prefix = "Total reclaimed space:"
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		v := strings.TrimSpace(strings.TrimPrefix(line, prefix))
+		for _, u := range reclaimedUnits {
+			if !strings.HasSuffix(v, u.suffix) {
+				continue
+			}
+			n, err := strconv.ParseFloat(strings.TrimSuffix(v, u.suffix), 64)
+			if err != nil {
+				return 0, errors.Errorf("invalid reclaimed space '%s'", v)
+			}
+			return int64(n * u.size), nil
+		}
+		return 0, errors.Errorf("invalid reclaimed space '%s'", v)
+	}
+	return 0, errors.New("image prune did not report the reclaimed space")
+}
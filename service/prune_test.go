@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/metrics"
+)
+
+func TestParseReclaimed(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		out     string
+		want    int64
+		wantErr bool
+	}{
+		{"gigabytes", "Deleted Images:\nuntagged: app:old\n\nTotal reclaimed space: 1.25GB\n", 1250000000, false},
+		{"kilobytes", "Total reclaimed space: 512kB", 512000, false},
+		{"nothing", "Total reclaimed space: 0B\n", 0, false},
+		{"missing", "Error response from daemon\n", 0, true},
+		{"invalid", "Total reclaimed space: lots\n", 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReclaimed([]byte(tt.out))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPruneImagesRateLimited(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	runs := make(chan time.Duration, 10)
+	defer func(c func(context.Context, time.Duration) *exec.Cmd) { pruneCommand = c }(pruneCommand)
+	pruneCommand = func(ctx context.Context, until time.Duration) *exec.Cmd {
+		runs <- until
+		return exec.CommandContext(ctx, "sh", "-c", "echo 'Total reclaimed space: 2MB'")
+	}
+
+	m, err := metrics.New(nil)
+	require.NoError(t, err)
+	app := &App{
+		config:  Config{PruneInterval: time.Hour, PruneUntil: time.Hour * 48},
+		metrics: m,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deployed := make(chan struct{})
+	go app.pruneImages(ctx, deployed)
+
+	deployed <- struct{}{}
+	assert.Equal(t, time.Hour*48, <-runs)
+	// deploys within the interval don't prune again
+	deployed <- struct{}{}
+	deployed <- struct{}{}
+	cancel()
+	assert.Len(t, runs, 0)
+
+	err = testutil.GatherAndCompare(m.Registry(), strings.NewReader(`
+# HELP pico_image_prune_reclaimed_bytes_total Disk space reclaimed by pruning Docker images.
+# TYPE pico_image_prune_reclaimed_bytes_total counter
+pico_image_prune_reclaimed_bytes_total 2e+06
+`), "pico_image_prune_reclaimed_bytes_total")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/secret/memory"
+)
+
+// Secret backends, the stores secrets are read from
+const (
+	BackendVault      = "vault"
+	BackendAzure      = "azure"
+	BackendGCP        = "gcp"
+	BackendKubernetes = "kubernetes"
+	BackendFile       = "file"
+	BackendSSM        = "ssm"
+	// BackendNone reads no secrets but those set with --secret
+	BackendNone = "none"
+)
+
+// backendOption is a setting that only applies to one secret backend
+type backendOption struct {
+	flag     string
+	set      func(c Config) bool
+	required bool
+}
+
+// backendOptions are the settings of each secret backend, in the order the
+// backend was inferred from them before it could be chosen.
+var backendOptions = []struct {
+	backend string
+	options []backendOption
+}{
+	{BackendVault, []backendOption{
+		{"--vault-addr", func(c Config) bool { return c.VaultAddress != "" }, true},
+		{"--vault-token", func(c Config) bool { return c.VaultToken != "" }, false},
+		{"--vault-auth-method", func(c Config) bool { return c.VaultAuth != "" }, false},
+		{"--vault-aws-role", func(c Config) bool { return c.VaultAWSRole != "" }, false},
+		{"--vault-aws-header-value", func(c Config) bool { return c.VaultAWSHeader != "" }, false},
+	}},
+	{BackendAzure, []backendOption{
+		{"--azure-keyvault-uri", func(c Config) bool { return c.AzureVaultURI != "" }, true},
+	}},
+	{BackendGCP, []backendOption{
+		{"--gcp-project", func(c Config) bool { return c.GCPProject != "" }, true},
+	}},
+	{BackendKubernetes, []backendOption{
+		{"--kube-secrets", func(c Config) bool { return c.KubeSecrets }, false},
+		{"--kube-namespace", func(c Config) bool { return c.KubeNamespace != "" }, false},
+		{"--kube-watch", func(c Config) bool { return c.KubeWatch }, false},
+	}},
+	{BackendFile, []backendOption{
+		{"--secrets-directory", func(c Config) bool { return c.SecretsDir != "" }, true},
+	}},
+	{BackendSSM, []backendOption{
+		{"--ssm-region", func(c Config) bool { return c.SSMRegion != "" }, true},
+	}},
+}
+
+// secretBackend returns the secret backend of the configuration, checking that
+// the settings it requires are present, along with warnings about settings of
+// other backends that are ignored. Without a backend set, it's inferred from the
+// first backend whose main setting is present, which is deprecated.
+func secretBackend(c Config) (backend string, warnings []string, err error) {
+	backend = c.SecretBackend
+	if backend == "" {
+		backend = BackendNone
+		for _, b := range backendOptions {
+			if b.options[0].set(c) {
+				backend = b.backend
+				break
+			}
+		}
+		if backend != BackendNone {
+			warnings = append(warnings, fmt.Sprintf("the secret backend was inferred as %s, inferring it is deprecated, set --secret-backend=%s", backend, backend))
+		}
+	}
+
+	known := backend == BackendNone
+	for _, b := range backendOptions {
+		for _, o := range b.options {
+			switch {
+			case b.backend != backend && o.set(c):
+				warnings = append(warnings, fmt.Sprintf("%s is ignored with the %s secret backend", o.flag, backend))
+			case b.backend == backend && o.required && !o.set(c):
+				return "", nil, errors.Errorf("the %s secret backend requires %s", backend, o.flag)
+			}
+		}
+		known = known || b.backend == backend
+	}
+	if !known {
+		return "", nil, errors.Errorf("secret backend '%s' is not one of %s", backend, strings.Join(SecretBackends(), ", "))
+	}
+	return backend, warnings, nil
+}
+
+// SecretBackends returns the names of the secret backends that can be chosen
+func SecretBackends() []string {
+	names := make([]string, 0, len(backendOptions)+1)
+	for _, b := range backendOptions {
+		names = append(names, b.backend)
+	}
+	return append(names, BackendNone)
+}
+
+// parseSecrets builds a memory store from secrets in the form key=value or
+// target:key=value. Secrets without a target are set on the configuration
+// path, the same as secrets read from a secret store's configuration path.
+func parseSecrets(secrets []string, configPath string) (*memory.MemorySecrets, error) {
+	store := memory.New(nil)
+	for _, s := range secrets {
+		target, key, value, err := parseSecret(s)
+		if err != nil {
+			return nil, err
+		}
+		if target == "" {
+			target = configPath
+		}
+		store.Set(target, key, value)
+	}
+	return store, nil
+}
+
+func parseSecret(s string) (target, key, value string, err error) {
+	eq := strings.Index(s, "=")
+	if eq == -1 {
+		return "", "", "", errors.Errorf("secret '%s' is not in the form [target:]key=value", s)
+	}
+	key, value = s[:eq], s[eq+1:]
+	if colon := strings.Index(key, ":"); colon != -1 {
+		target, key = key[:colon], key[colon+1:]
+		if target == "" {
+			return "", "", "", errors.Errorf("secret '%s' has an empty target", s)
+		}
+	}
+	if key == "" {
+		return "", "", "", errors.Errorf("secret '%s' has an empty key", s)
+	}
+	return target, key, value, nil
+}
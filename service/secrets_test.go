@@ -0,0 +1,73 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecrets(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		secrets []string
+		want    map[string]map[string]string
+		wantErr bool
+	}{
+		{"empty", nil, map[string]map[string]string{}, false},
+		{"global", []string{"GLOBAL_A=1"}, map[string]map[string]string{"pico": {"GLOBAL_A": "1"}}, false},
+		{"target", []string{"app:A=1", "app:B=x=y"}, map[string]map[string]string{"app": {"A": "1", "B": "x=y"}}, false},
+		{"empty value", []string{"app:A="}, map[string]map[string]string{"app": {"A": ""}}, false},
+		{"no value", []string{"app:A"}, nil, true},
+		{"no key", []string{"app:=1"}, nil, true},
+		{"no target", []string{":A=1"}, nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSecrets(tt.secrets, "pico")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got.Snapshot())
+		})
+	}
+}
+
+func TestSecretBackend(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		config   Config
+		backend  string
+		warnings []string
+		err      string
+	}{
+		{"none", Config{}, BackendNone, nil, ""},
+		{"explicit", Config{SecretBackend: BackendVault, VaultAddress: "http://vault:8200"}, BackendVault, nil, ""},
+		{"inferred", Config{SecretsDir: "/run/secrets"}, BackendFile, []string{
+			"the secret backend was inferred as file, inferring it is deprecated, set --secret-backend=file",
+		}, ""},
+		{"inferred in order", Config{VaultAddress: "http://vault:8200", SSMRegion: "eu-west-1"}, BackendVault, []string{
+			"the secret backend was inferred as vault, inferring it is deprecated, set --secret-backend=vault",
+			"--ssm-region is ignored with the vault secret backend",
+		}, ""},
+		{"ignored", Config{SecretBackend: BackendKubernetes, VaultToken: "t0ken", KubeWatch: true}, BackendKubernetes, []string{
+			"--vault-token is ignored with the kubernetes secret backend",
+		}, ""},
+		{"none ignores", Config{SecretBackend: BackendNone, GCPProject: "project"}, BackendNone, []string{
+			"--gcp-project is ignored with the none secret backend",
+		}, ""},
+		{"missing", Config{SecretBackend: BackendSSM}, "", nil, "the ssm secret backend requires --ssm-region"},
+		{"unknown", Config{SecretBackend: "aws-sm"}, "", nil, "secret backend 'aws-sm' is not one of vault, azure, gcp, kubernetes, file, ssm, none"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, warnings, err := secretBackend(tt.config)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.backend, backend)
+			assert.Equal(t, tt.warnings, warnings)
+		})
+	}
+}
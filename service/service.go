@@ -5,20 +5,41 @@ package service
 
 import (
 	"context"
+	"fmt"
+	nethttp "net/http"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
-	"github.com/eapache/go-resiliency/retrier"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
 
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/config"
 	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/gitauth"
+	"github.com/picostack/pico/httpclient"
+	"github.com/picostack/pico/leader"
+	"github.com/picostack/pico/metrics"
+	"github.com/picostack/pico/notifier"
 	"github.com/picostack/pico/reconfigurer"
 	"github.com/picostack/pico/secret"
+	"github.com/picostack/pico/secret/azure"
+	"github.com/picostack/pico/secret/cache"
+	"github.com/picostack/pico/secret/file"
+	"github.com/picostack/pico/secret/gcp"
+	"github.com/picostack/pico/secret/instrumented"
+	"github.com/picostack/pico/secret/kubernetes"
 	"github.com/picostack/pico/secret/memory"
+	"github.com/picostack/pico/secret/ssm"
 	"github.com/picostack/pico/secret/vault"
+	"github.com/picostack/pico/state"
 	"github.com/picostack/pico/task"
 	"github.com/picostack/pico/watcher"
 )
@@ -26,49 +47,277 @@ import (
 // Config specifies static configuration parameters (from CLI or environment)
 type Config struct {
 	Target          task.Repo
+	Sources         []task.Repo // additional configuration repositories, merged with Target
 	Hostname        string
+	Version         string
+	ConfigEnv       []string // environment variables exposed to configuration scripts
 	SSH             bool
+	GitAuth         string // "basic" or "token" git HTTP auth, detected when empty
+	Netrc           string // netrc file with git HTTP credentials, used without others
 	Directory       string
 	PassEnvironment bool
 	CheckInterval   time.Duration
+	GitTimeout      time.Duration
+	ConfigStale     time.Duration // report the config as stale after failing checks this long
+	SecretBackend   string        // one of the Backend constants, inferred from the other settings when empty
 	VaultAddress    string
 	VaultToken      string `json:"-"`
+	VaultWrapped    bool   // the token is a response-wrapping token to unwrap
+	VaultAuth       string // vault.AuthToken or vault.AuthAWS, the token when empty
+	VaultAWSRole    string // Vault role of the AWS auth method
+	VaultAWSHeader  string // X-Vault-AWS-IAM-Server-ID of logins with the AWS auth method
 	VaultPath       string
 	VaultRenewal    time.Duration
 	VaultConfig     string
+	AzureVaultURI   string // Azure Key Vault to read secrets from instead of Vault
+	GCPProject      string // Google Cloud project to read Secret Manager secrets from
+	GCPSecretPrefix string
+	KubeSecrets     bool     // read secrets from Kubernetes Secrets in the cluster
+	KubeNamespace   string   // defaults to the pod's namespace
+	KubeWatch       bool     // redeploy targets when their Kubernetes Secrets change
+	SecretsDir      string   // read secrets from files in <dir>/<target>/<KEY>
+	SSMRegion       string   // read secrets from AWS SSM Parameter Store in this region
+	SSMPrefix       string   // parameters are read from <prefix>/<target>/<KEY>
+	SecretCacheKey  string   // key file for the encrypted secret cache, disabled when empty
+	AllowStale      bool     // use cached secrets when the secret store is unavailable
+	Secrets         []string // key=value or target:key=value secrets set in memory
+	StrictConfig    bool     // fail instead of keeping the last good configuration
+	RequireSecrets  bool     // fail tasks whose secret_map refers to missing secrets
+	InterpolateCmds bool     // resolve ${secret:...} placeholders in commands
+	StrictEnv       bool     // fail tasks whose environment defines a variable in conflicting sources
+	LeaderElection  bool     // only execute tasks while holding the leader lease
+	Standby         bool     // fetch but never execute tasks until activated
+	ActivateFile    string   // activates a standby instance once this file exists
+	LeaderKey       string
+	LeaderTTL       time.Duration
+	AdminAddress    string              // serves status, disabled when empty
+	AdminToken      string              `json:"-"` // required by the admin listener, open when empty
+	DebugAddress    string              // serves pprof, disabled when empty
+	WebhookAddress  string              // receives push webhooks from git hosts, disabled when empty
+	BitbucketSecret string              `json:"-"` // verifies Bitbucket Server webhook signatures
+	MetricLabels    []string            // target label keys exported on per-target metrics
+	PushGateway     string              // Pushgateway metrics are pushed to on shutdown, disabled when empty
+	PushJob         string              // job name pushed metrics are grouped under, metrics.DefaultPushJob when empty
+	PushGrouping    []string            // key=value grouping labels of pushed metrics
+	GCInterval      time.Duration       // how often oversized clones are compacted, disabled when zero
+	GCThreshold     int64               // clones bigger than this many bytes are compacted
+	MaxDataSize     int64               // warn when the data directory exceeds this many bytes
+	MinFreeSpace    int64               // skip clones, fetches and tasks with fewer bytes free on the data directory's disk
+	MinFreePercent  float64             // as for MinFreeSpace, as a percentage of the disk's size
+	Worktrees       bool                // run tasks in a checkout of their commit rather than their clone
+	CancelReconfig  bool                // cancel, rather than wait for, tasks of targets being reconfigured
+	StartupParallel int                 // tasks of the cold start plan executed at a time, one when zero
+	HistorySize     int                 // executions kept per target, DefaultHistorySize when zero
+	PersistHistory  bool                // keep execution history in the state file across restarts
+	StateMaxRecords int                 // executions kept per target in the state file, unbounded when zero
+	StateMaxAge     time.Duration       // executions older than this are dropped from the state file
+	StateMaxSize    int64               // bytes of the state file beyond which the oldest executions are dropped
+	MaxOutput       int64               // bytes of output kept per task, DefaultOutputLimit when zero
+	NotifyCommand   string              // shell command run for every notification, disabled when empty
+	SMTP            notifier.SMTPConfig // email notifications, disabled without a host
+	DiscordWebhook  string              `json:"-"` // Discord webhook URL for task notifications
+	WebhookURLs     []string            // URLs every event is posted to as signed JSON
+	GrafanaURL      string              // Grafana instance finished tasks are annotated in, disabled when empty
+	GrafanaToken    string              `json:"-"` // API token for Grafana annotations
+	HTTPTimeout     time.Duration       // per outbound request, httpclient.DefaultTimeout when zero
+	HTTPProxy       string              `json:"-"` // proxy for outbound requests, HTTPS_PROXY when empty
+	HTTPCABundle    string              // PEM file of CAs trusted for outbound requests
+
+	// Origins records where the value of each field came from, by field name
+	// such as "VaultToken" or "SMTP.Host", one of the Origin constants.
+	Origins       map[string]string `json:"-"`
+	PruneImages   bool              // prune unused Docker images after deploys, targets may override
+	PruneInterval time.Duration     // prune at most once per interval
+	PruneUntil    time.Duration     // only prune images created at least this long ago
+	RemoveOrphans bool              // tear down compose projects of targets no longer configured
+	RunAs         string            // user[:group] to switch to once initialised, unchanged when empty
 }
 
 // App stores application state
 type App struct {
 	config       Config
+	layout       task.Layout
 	reconfigurer reconfigurer.Provider
+	providers    []configProvider
 	watcher      watcher.Watcher
 	secrets      secret.Store
+	notifier     notifier.Multi
+	channels     *channels // notifiers declared in the configuration
+	metrics      *metrics.Metrics
+	push         metrics.PushConfig
 	bus          chan task.ExecutionTask
+	deployed     chan struct{} // successful deploys for the image pruner
+	lock         *dirLock
+	state        *state.Store
+	history      *executor.History
+	newExecutor  func(*executor.SecretResolver) executor.Executor // nil for the command executor
+	executor     executor.Executor
+	shared       leader.AppliedStore
+	sharedWrites *appliedWriter
+	leader       int32     // 1 while this instance is the leader, accessed atomically
+	standby      int32     // 1 until a standby instance is activated, accessed atomically
+	identity     *identity // the --run-as identity, nil to keep the current one
+
+	mu        sync.Mutex
+	lastError string               // the most recent task failure, for status reporting
+	targets   int                  // targets of the configuration in force, for status reporting
+	stale     map[string]time.Time // targets last deployed with stale secrets
+	blocked   map[string][]string  // targets not run for missing required secrets
+	dataSize  int64                // bytes used by the data directory, as last measured
+	dataFree  int64                // bytes free on the data directory's disk, as last measured
+
+	// while the data directory's disk is below the minimum free space, why,
+	// the targets whose clones, fetches or tasks were skipped and the latest
+	// task of each target held until there's enough space again
+	lowSpace     error
+	spaceBlocked map[string]bool
+	spaceHeld    map[string]task.ExecutionTask
+
+	// the tasks that reach the executor, past the leader and standby gates
+	executing chan task.ExecutionTask
+}
+
+type configProvider struct {
+	name     string
+	provider *reconfigurer.GitProvider
+}
+
+// Option configures optional parts of the app
+type Option func(*App)
+
+// WithExecutor executes tasks with the executor returned by f instead of the
+// command executor. f is called when the app starts, with a resolver for the
+// secrets of the app's secret store. If the executor implements
+// executor.EnabledSetter, it's told which targets are disabled.
+func WithExecutor(f func(secrets *executor.SecretResolver) executor.Executor) Option {
+	return func(app *App) {
+		app.newExecutor = f
+	}
 }
 
 // Initialise prepares an instance of the app to run
-func Initialise(c Config) (app *App, err error) {
+func Initialise(c Config, opts ...Option) (app *App, err error) {
+	if err = expandConfig(&c, os.LookupEnv); err != nil {
+		return nil, err
+	}
 	app = new(App)
+	for _, opt := range opts {
+		opt(app)
+	}
+
+	// identify git HTTP operations with Pico's User-Agent
+	gitHTTP := http.NewClient(&nethttp.Client{Transport: buildinfo.Transport(nil)})
+	client.InstallProtocol("http", gitHTTP)
+	client.InstallProtocol("https", gitHTTP)
 
 	app.config = c
 
-	var secretStore secret.Store
-	if c.VaultAddress != "" {
-		zap.L().Debug("connecting to vault",
-			zap.String("address", c.VaultAddress),
-			zap.String("path", c.VaultPath),
-			zap.String("token", c.VaultToken),
-			zap.Duration("renewal", c.VaultRenewal))
+	if c.RunAs != "" {
+		app.identity, err = lookupIdentity(c.RunAs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	app.metrics, err = metrics.New(c.MetricLabels)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid metric labels")
+	}
+	if c.Standby {
+		app.standby = 1
+	}
+	app.metrics.SetStandby(c.Standby)
+	if c.ActivateFile != "" && !c.Standby {
+		zap.L().Warn("activate file ignored, the instance is not in standby")
+	}
+	if c.PushGateway != "" {
+		grouping, err := metrics.ParseGrouping(c.PushGrouping)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid Pushgateway grouping")
+		}
+		app.push = metrics.PushConfig{URL: c.PushGateway, Job: c.PushJob, Grouping: grouping}
+	}
 
-		secretStore, err = vault.New(c.VaultAddress, c.VaultPath, c.VaultToken, c.VaultRenewal)
+	lock, err := lockDirectory(c.Directory)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// don't hold the lock if initialisation fails, app is nil by then
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to create vault secret store")
+			lock.release() //nolint:errcheck
 		}
+	}()
+
+	app.layout = task.Layout{Root: c.Directory}
+	var configRepos []string
+	for _, repo := range append([]task.Repo{c.Target}, c.Sources...) {
+		configRepos = append(configRepos, repo.URL)
+	}
+	if err = migrateLayout(app.layout, configRepos); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate data directory layout")
+	}
+
+	app.state, err = state.Open(c.Directory)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open persisted state")
+	}
+	if r := c.retention(); r.Enabled() {
+		app.state.SetRetention(r)
+		app.compactState()
+	}
+
+	if c.PersistHistory {
+		app.history = executor.NewHistory(c.HistorySize, app.state)
 	} else {
-		secretStore = &memory.MemorySecrets{
-			// TODO: pull env vars with PICO_SECRET_* or something and shove em here
+		app.history = executor.NewHistory(c.HistorySize, nil)
+	}
+
+	// checkouts left behind by tasks interrupted by a crash are never reused
+	if err = os.RemoveAll(app.layout.Worktrees()); err != nil {
+		return nil, errors.Wrap(err, "failed to remove stale task checkouts")
+	}
+
+	secretStore, backend, warnings, err := openSecretStore(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range warnings {
+		zap.L().Warn(w)
+	}
+
+	injected, err := parseSecrets(c.Secrets, c.VaultConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	secretMetrics, err := instrumented.NewMetrics(app.metrics.Registry())
+	if err != nil {
+		return nil, err
+	}
+	if secretStore != nil {
+		secretStore = instrumented.New(secretStore, backend, secretMetrics)
+	}
+
+	if c.SecretCacheKey != "" && secretStore == nil {
+		zap.L().Warn("secret cache key ignored, there is no secret store to cache")
+	} else if c.SecretCacheKey != "" {
+		secretStore, err = cache.New(secretStore, c.Directory, c.SecretCacheKey, c.AllowStale)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create secret cache")
 		}
+	} else if c.AllowStale {
+		return nil, errors.New("stale secrets can only be used with a secret cache key file")
+	}
+
+	// secrets from the command line are never cached and take precedence over
+	// those from a secret store, without one they are the only secrets.
+	if secretStore == nil {
+		secretStore = instrumented.New(injected, "memory", secretMetrics)
+	} else if len(c.Secrets) > 0 {
+		zap.L().Info("overriding secret store with secrets from the command line",
+			zap.Int("secrets", len(c.Secrets)))
+		secretStore = memory.Layer(secretStore, injected)
 	}
 
 	secretConfig, err := secretStore.GetSecretsForTarget(c.VaultConfig)
@@ -78,45 +327,336 @@ func Initialise(c Config) (app *App, err error) {
 	}
 	zap.L().Debug("read configuration secrets from secret store", zap.Strings("keys", getKeys(secretConfig)))
 
-	authMethod, err := getAuthMethod(c, secretConfig)
+	app.secrets = secretStore
+
+	logChecks(preflight(c, secretStore))
+
+	// settings read from the secret store unless they were set explicitly
+	origins := make(map[string]string, len(c.Origins))
+	for k, v := range c.Origins {
+		origins[k] = v
+	}
+	fromSecrets := func(field string, value *string, key string) {
+		if v, ok := secretConfig[key]; ok && *value == "" {
+			*value = v
+			origins[field] = OriginSecretStore
+		}
+	}
+	fromSecrets("SMTP.Username", &c.SMTP.Username, "SMTP_USERNAME")
+	fromSecrets("SMTP.Password", &c.SMTP.Password, "SMTP_PASSWORD")
+	fromSecrets("DiscordWebhook", &c.DiscordWebhook, "DISCORD_WEBHOOK_URL")
+	fromSecrets("BitbucketSecret", &c.BitbucketSecret, "BITBUCKET_WEBHOOK_SECRET")
+	fromSecrets("AdminToken", &c.AdminToken, "ADMIN_TOKEN")
+	fromSecrets("GrafanaToken", &c.GrafanaToken, "GRAFANA_TOKEN")
+	if c.AdminAddress != "" && c.AdminToken == "" && !api.IsLoopback(c.AdminAddress) {
+		zap.L().Warn("the admin listener is reachable from the network without authentication, set ADMIN_TOKEN in the secret store",
+			zap.String("address", c.AdminAddress))
+	}
+	if c.WebhookAddress != "" && c.BitbucketSecret == "" {
+		return nil, errors.New("the webhook listener requires BITBUCKET_WEBHOOK_SECRET in the secret store")
+	}
+	c.Origins = origins
+	app.config = c
+
+	// notifications and the Pushgateway share one client for outbound requests
+	client, err := httpclient.New(httpclient.Config{
+		Timeout:  c.HTTPTimeout,
+		Proxy:    c.HTTPProxy,
+		CABundle: c.HTTPCABundle,
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create an authentication method from the given config")
+		return nil, errors.Wrap(err, "failed to set up outbound HTTP client")
 	}
+	app.push.Client = client
 
-	app.secrets = secretStore
+	if c.SMTP.Host != "" {
+		mail, err := notifier.NewSMTP(c.SMTP)
+		if err != nil {
+			return nil, err
+		}
+		app.notifier = append(app.notifier, mail)
+	}
+	if c.DiscordWebhook != "" {
+		app.notifier = append(app.notifier, notifier.NewDiscord(c.DiscordWebhook, client))
+	}
+	if len(c.WebhookURLs) > 0 {
+		secret := secretConfig["WEBHOOK_SECRET"]
+		if secret == "" {
+			zap.L().Warn("webhook events are not signed, there is no WEBHOOK_SECRET in the secret store")
+		}
+		app.notifier = append(app.notifier, notifier.NewWebhook(c.WebhookURLs, secret, client))
+	}
+	if c.GrafanaURL != "" {
+		if c.GrafanaToken == "" {
+			zap.L().Warn("grafana annotations are posted without authentication, there is no GRAFANA_TOKEN in the secret store")
+		}
+		app.notifier = append(app.notifier, notifier.NewGrafana(c.GrafanaURL, c.GrafanaToken, client))
+	}
+	if c.NotifyCommand != "" {
+		app.notifier = append(app.notifier, &notifier.Command{Command: c.NotifyCommand})
+	}
+	app.channels = newChannels(client, secretConfig["WEBHOOK_SECRET"])
 
 	app.bus = make(chan task.ExecutionTask, 100)
+	app.deployed = make(chan struct{}, 1)
 
-	// reconfigurer
-	app.reconfigurer = reconfigurer.New(
-		c.Directory,
-		c.Hostname,
-		c.Target.URL,
-		c.CheckInterval,
-		authMethod,
-	)
+	// reconfigurer, one provider per configuration repository
+	var sources []reconfigurer.Source
+	for _, repo := range append([]task.Repo{c.Target}, c.Sources...) {
+		authMethod, err := getAuthMethod(c, repo, secretConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create an authentication method for %s", repo.URL)
+		}
+
+		provider := reconfigurer.New(
+			app.layout.Config(),
+			config.Builtins{
+				Hostname: c.Hostname,
+				Version:  c.Version,
+				Env:      c.ConfigEnv,
+			},
+			repo.URL,
+			c.CheckInterval,
+			authMethod,
+			c.StrictConfig,
+			&app.notifier,
+		)
+		provider.SetBranch(repo.Branch)
+		provider.SetGitTimeout(c.GitTimeout)
+		provider.SetStaleAfter(c.ConfigStale)
+		source := repo.URL
+		provider.SetRevisionObserver(func(r reconfigurer.Revision) {
+			app.metrics.SetConfigRevision(source, r.Commit)
+		})
+		app.providers = append(app.providers, configProvider{repo.URL, provider})
+		sources = append(sources, reconfigurer.Source{
+			Name:      repo.URL,
+			Provider:  provider,
+			Directory: provider.Directory(),
+		})
+	}
+	app.reconfigurer = reconfigurer.NewMulti(app.layout.Targets(), &app.notifier, sources...)
 
 	// target watcher
-	app.watcher = watcher.NewGitWatcher(
-		app.config.Directory,
+	gw := watcher.NewGitWatcher(
+		app.layout.Targets(),
 		app.bus,
 		app.config.CheckInterval,
 		secretStore,
 	)
+	gw.SetAuthResolver(gitauth.NetrcResolver(c.Netrc))
+	gw.SetMaintenance(c.GCInterval, c.GCThreshold)
+	gw.SetGitTimeout(c.GitTimeout)
+	if app.minFreeSpace().Enabled() {
+		gw.SetSpaceCheck(app.checkSpace)
+	}
+	gw.SetLastDeploy(func(target string) time.Time {
+		t, _ := app.state.Get(target)
+		return t.AppliedAt
+	})
+	gw.SetApplied(app.state.Applied)
+	app.metrics.CollectFetchState(func() map[string]metrics.FetchState {
+		states := gw.State()
+		out := make(map[string]metrics.FetchState, len(states))
+		for name, s := range states {
+			out[name] = metrics.FetchState{
+				ConsecutiveFailures: s.ConsecutiveFailures,
+				LastSuccess:         s.LastSuccess,
+			}
+		}
+		return out
+	})
+	app.watcher = gw
 
+	zap.L().Info("effective configuration", zap.Any("config", EffectiveConfig(app.config)))
+
+	app.lock = lock
 	return
 }
 
+// openSecretStore connects to the secret store of the configured backend, if
+// any, and returns it with the name of its backend for metrics and warnings
+// about the configuration of the backend.
+func openSecretStore(c Config) (store secret.Store, backend string, warnings []string, err error) {
+	backend, warnings, err = secretBackend(c)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	switch backend {
+	case BackendVault:
+		zap.L().Debug("connecting to vault",
+			zap.String("address", c.VaultAddress),
+			zap.String("path", c.VaultPath),
+			zap.String("token", c.VaultToken),
+			zap.String("auth", c.VaultAuth),
+			zap.Duration("renewal", c.VaultRenewal))
+
+		store, err = vault.New(c.VaultAddress, c.VaultPath, vault.Auth{
+			Method:   c.VaultAuth,
+			Token:    c.VaultToken,
+			Wrapped:  c.VaultWrapped,
+			Unwrap:   filepath.Join(c.Directory, vault.TokenFileName),
+			Role:     c.VaultAWSRole,
+			ServerID: c.VaultAWSHeader,
+		}, c.VaultRenewal)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, "failed to create vault secret store")
+		}
+	case BackendAzure:
+		zap.L().Debug("using azure key vault", zap.String("uri", c.AzureVaultURI))
+
+		store, err = azure.New(c.AzureVaultURI)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, "failed to create azure key vault secret store")
+		}
+	case BackendGCP:
+		zap.L().Debug("using google secret manager",
+			zap.String("project", c.GCPProject),
+			zap.String("prefix", c.GCPSecretPrefix))
+
+		store, err = gcp.New(c.GCPProject, c.GCPSecretPrefix)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, "failed to create secret manager secret store")
+		}
+	case BackendKubernetes:
+		zap.L().Debug("using kubernetes secrets", zap.String("namespace", c.KubeNamespace))
+
+		store, err = kubernetes.New(c.KubeNamespace)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, "failed to create kubernetes secret store")
+		}
+	case BackendFile:
+		zap.L().Debug("using secrets directory", zap.String("directory", c.SecretsDir))
+
+		store, err = file.New(c.SecretsDir, c.VaultConfig)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, "failed to create file secret store")
+		}
+	case BackendSSM:
+		zap.L().Debug("using aws parameter store",
+			zap.String("region", c.SSMRegion),
+			zap.String("prefix", c.SSMPrefix))
+
+		store, err = ssm.New(c.SSMRegion, c.SSMPrefix)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, "failed to create parameter store secret store")
+		}
+	}
+	return store, backend, warnings, nil
+}
+
 // Start launches the app and blocks until fatal error
 func (app *App) Start(ctx context.Context) error {
 	errs := make(chan error)
 
-	ce := executor.NewCommandExecutor(app.secrets, app.config.PassEnvironment, app.config.VaultConfig, "GLOBAL_")
+	// listeners are bound before dropping privileges so they may use
+	// privileged ports, nothing else may run until the switch is done.
+	var admin, debug, hooks *api.Server
+	if app.config.AdminAddress != "" {
+		admin = api.NewAdmin(app.config.AdminAddress, app, app.metrics.Handler())
+		admin.RequireToken(app.config.AdminToken)
+		if err := admin.Listen(); err != nil {
+			return err
+		}
+	}
+	if app.config.DebugAddress != "" {
+		debug = api.NewDebug(app.config.DebugAddress)
+		if err := debug.Listen(); err != nil {
+			return err
+		}
+	}
+	if app.config.WebhookAddress != "" {
+		hooks = api.NewWebhook(app.config.WebhookAddress, app, app.config.BitbucketSecret)
+		if err := hooks.Listen(); err != nil {
+			return err
+		}
+	}
+	if err := app.dropPrivileges(); err != nil {
+		return err
+	}
+
+	gw := app.watcher.(*watcher.GitWatcher)
+
+	ex := app.newTaskExecutor(gw)
+	if h, ok := ex.(executor.Holder); ok {
+		gw.SetHold(h.Hold)
+	}
+	app.mu.Lock()
+	app.executor = ex
+	app.mu.Unlock()
+	ex.SetContext(ctx)
+	ex.SetResultHandler(app.recordResult)
+
+	if !app.config.LeaderElection {
+		app.leader = 1
+	}
+	bus := app.bus
+	if app.config.LeaderElection || app.isStandby() || app.minFreeSpace().Enabled() {
+		bus = make(chan task.ExecutionTask, cap(app.bus))
+		go app.gate(app.bus, bus)
+	}
+	if app.minFreeSpace().Enabled() {
+		go app.watchSpace(ctx, bus)
+	}
+	app.mu.Lock()
+	app.executing = bus
+	app.mu.Unlock()
+	if app.config.LeaderElection {
+		elector, err := app.newElector()
+		if err != nil {
+			return err
+		}
+		go func() {
+			errs <- errors.Wrap(
+				app.runElection(ctx, elector, bus),
+				"leader election failed",
+			)
+		}()
+	}
+	if app.isStandby() {
+		zap.L().Warn("STANDBY: targets are fetched but no task is executed until the instance is activated",
+			zap.String("activate_file", app.config.ActivateFile))
+		if app.config.ActivateFile != "" {
+			go app.watchActivateFile(ctx)
+		}
+	}
 	go func() {
-		ce.Subscribe(app.bus)
+		ex.Subscribe(bus)
 	}()
 
-	gw := app.watcher.(*watcher.GitWatcher)
+	go app.runSystemd(ctx, gw.Ready(), gw.LastActive)
+	go app.watchDiskUsage(ctx, gw)
+	if app.config.retention().Enabled() {
+		go app.watchStateSize(ctx)
+	}
+	go app.pruneImages(ctx, app.deployed)
+	go app.reconcileOrphans(ctx, gw)
+
+	if admin != nil {
+		go func() {
+			errs <- errors.Wrap(
+				admin.Run(ctx),
+				"admin listener failed",
+			)
+		}()
+	}
+	if debug != nil {
+		go func() {
+			errs <- errors.Wrap(
+				debug.Run(ctx),
+				"debug listener failed",
+			)
+		}()
+	}
+	if hooks != nil {
+		go func() {
+			errs <- errors.Wrap(
+				hooks.Run(ctx),
+				"webhook listener failed",
+			)
+		}()
+	}
+
 	go func() {
 		errs <- errors.Wrap(
 			gw.Start(),
@@ -126,29 +666,242 @@ func (app *App) Start(ctx context.Context) error {
 
 	go func() {
 		errs <- errors.Wrap(
-			app.reconfigurer.Configure(app.watcher),
+			app.reconfigurer.Configure(routingWatcher{app.watcher, app}),
 			"reconfigure provider crashed",
 		)
 	}()
 
-	if s, ok := app.secrets.(*vault.VaultSecrets); ok {
+	if cn, ok := secret.Base(app.secrets).(secret.ChangeNotifier); ok && app.config.KubeWatch {
 		go func() {
 			errs <- errors.Wrap(
-				retrier.New(retrier.ConstantBackoff(3, 100*time.Millisecond), nil).RunCtx(ctx, s.Renew),
-				"vault token renewal job failed",
+				cn.WatchChanges(ctx, func(target string) {
+					gw.Redeploy(target, "secrets changed")
+				}),
+				"secret change watcher failed",
 			)
 		}()
 	}
 
+	if s, ok := secret.Base(app.secrets).(*vault.VaultSecrets); ok {
+		s.SetRenewalObserver(app.metrics.ObserveVaultRenewal)
+		go func() {
+			errs <- errors.Wrap(s.Renew(ctx), "vault token renewal job failed")
+		}()
+	}
+
 	select {
 	case err := <-errs:
+		zap.L().Error("stopping after fatal error", zap.String("kind", errorKind(err)), zap.Error(err))
 		return err
 	case <-ctx.Done():
 		return context.Canceled
 	}
 }
 
-func getAuthMethod(c Config, secretConfig map[string]string) (transport.AuthMethod, error) {
+// newTaskExecutor creates the executor for tasks, the command executor unless
+// another was set with WithExecutor.
+func (app *App) newTaskExecutor(gw *watcher.GitWatcher) executor.Executor {
+	if app.newExecutor != nil {
+		secrets := executor.NewSecretResolver(app.secrets, app.config.VaultConfig)
+		secrets.SetRequireSecrets(app.config.RequireSecrets)
+		ex := app.newExecutor(secrets)
+		if es, ok := ex.(executor.EnabledSetter); ok {
+			es.SetEnabledFunc(gw.IsEnabled)
+		}
+		return ex
+	}
+
+	ce := executor.NewCommandExecutor(app.secrets, app.config.PassEnvironment, app.config.VaultConfig)
+	ce.SetRequireSecrets(app.config.RequireSecrets)
+	ce.SetInterpolateCommands(app.config.InterpolateCmds)
+	ce.SetStrictEnv(app.config.StrictEnv)
+	ce.SetEnabledFunc(gw.IsEnabled)
+	if app.config.Worktrees {
+		ce.SetWorktreeDirectory(app.layout.Worktrees())
+	}
+	ce.SetArchiveDirectory(app.layout.Worktrees())
+	ce.SetOutputLimit(int(app.config.MaxOutput))
+	ce.SetStartHandler(app.notifyStarted)
+	ce.SetCancelOnReconfigure(app.config.CancelReconfig)
+	ce.SetStartupParallelism(app.config.StartupParallel)
+	ce.SetHostname(app.config.Hostname)
+	if app.state != nil {
+		ce.SetInitRecorder(app.state)
+		ce.SetAppliedFunc(app.state.Applied)
+	}
+	return ce
+}
+
+// recordResult persists the applied commit of successful executions, keeps the
+// most recent failure for status reporting and records history, metrics and
+// notifications for the result.
+func (app *App) recordResult(r executor.Result) {
+	// recorded before the applied commit below changes, so the previous commit
+	// of the execution is the one it replaced.
+	app.history.Add(r)
+
+	t := r.Task.Target
+	app.metrics.ObserveTask(t, r.Task.Trigger, r.Task.Shutdown, r.Finished.Sub(r.Started), r.Status())
+	if r.Err != nil {
+		app.metrics.ObserveFailure(t, errorKind(r.Err))
+	}
+	app.notifyResult(r)
+
+	app.mu.Lock()
+	if app.stale == nil {
+		app.stale = make(map[string]time.Time)
+	}
+	if r.StaleSecrets != nil {
+		app.stale[t.Name] = *r.StaleSecrets
+	} else {
+		delete(app.stale, t.Name)
+	}
+	if app.blocked == nil {
+		app.blocked = make(map[string][]string)
+	}
+	var mse *executor.MissingSecretsError
+	if errors.As(r.Err, &mse) {
+		app.blocked[t.Name] = mse.Keys
+	} else {
+		delete(app.blocked, t.Name)
+	}
+	if r.Err != nil {
+		app.lastError = fmt.Sprintf("%s: %v", t.Name, r.Err)
+	}
+	app.mu.Unlock()
+
+	if r.Err != nil {
+		return
+	}
+	var err error
+	if r.Task.Shutdown {
+		err = app.state.Remove(r.Task.Target.Name)
+		app.recordShared(r.Task.Target.Name, "")
+	} else {
+		err = app.state.SetApplied(r.Task.Target.Name, r.Commit)
+		app.recordShared(r.Task.Target.Name, r.Commit)
+		if err == nil && r.Project != "" {
+			err = app.state.SetProject(r.Task.Target.Name, r.Project)
+		}
+		app.notifyDeployed(t.Name, t.ShouldPruneImages(app.config.PruneImages))
+	}
+	if err != nil {
+		zap.L().Error("failed to persist target state",
+			zap.String("target", t.Name),
+			t.LabelsField(),
+			zap.Error(err))
+	}
+}
+
+func (app *App) notifyStarted(r executor.Result) {
+	t := r.Task.Target
+	if r.Task.Shutdown || !t.ShouldNotify(task.ResultStarted) {
+		return
+	}
+	app.notify(t, notifier.Event{
+		Type:          notifier.EventTaskStarted,
+		Time:          r.Started,
+		Message:       fmt.Sprintf("%s deploying %s", t.Name, shortCommit(r.Commit)),
+		Target:        t.Name,
+		TaskID:        r.Task.ID,
+		Group:         t.Group,
+		Labels:        t.NonEmptyLabels(),
+		Repo:          t.RepoURL,
+		Commit:        r.Commit,
+		Trigger:       r.Task.Trigger,
+		TriggerDetail: r.Task.Detail,
+	})
+}
+
+// notifyResult notifies the result of a task unless its target isn't notified of
+// results of its kind, such as tasks that had nothing to do.
+func (app *App) notifyResult(r executor.Result) {
+	t := r.Task.Target
+	recovered := r.Err == nil && app.recovered(t.Name)
+	if !t.ShouldNotify(r.Status()) && !(recovered && t.ShouldNotify(task.NotifyRecovery)) {
+		return
+	}
+	e := notifier.Event{
+		Type:          notifier.EventTaskSucceeded,
+		Time:          r.Finished,
+		Message:       fmt.Sprintf("%s deployed %s", t.Name, shortCommit(r.Commit)),
+		Target:        t.Name,
+		TaskID:        r.Task.ID,
+		Group:         t.Group,
+		Labels:        t.NonEmptyLabels(),
+		Repo:          t.RepoURL,
+		Commit:        r.Commit,
+		Trigger:       r.Task.Trigger,
+		TriggerDetail: r.Task.Detail,
+		Duration:      r.Finished.Sub(r.Started),
+		Output:        r.Output,
+		Images:        r.Images,
+	}
+	if r.Task.Shutdown {
+		e.Message = fmt.Sprintf("%s shut down", t.Name)
+	} else {
+		e.Author = task.CommitAuthor(r.Task.Path, r.Commit)
+	}
+	if r.Noop {
+		e.Type = notifier.EventTaskNoop
+		e.Message = fmt.Sprintf("%s had nothing to deploy at %s", t.Name, shortCommit(r.Commit))
+		if r.Task.Shutdown {
+			e.Message = fmt.Sprintf("%s had nothing to shut down", t.Name)
+		}
+	}
+	if r.Err != nil {
+		e.Type = notifier.EventTaskFailed
+		e.Message = fmt.Sprintf("%s failed: %v", t.Name, r.Err)
+		e.Error = r.Err.Error()
+		e.ErrorKind = errorKind(r.Err)
+	}
+	if r.StaleSecrets != nil {
+		e.Message += fmt.Sprintf(" (ran with stale secrets from %s)", r.StaleSecrets.Format(time.RFC3339))
+		e.StaleSecrets = true
+	}
+	app.notify(t, e)
+
+	if t.NotifyCommand != "" {
+		go notifier.Multi{&notifier.Command{Command: t.NotifyCommand}}.Notify(e) //nolint:errcheck
+	}
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// Close releases resources held by the app, such as the data directory lock
+func (app *App) Close() error {
+	app.pushMetrics()
+	return app.lock.release()
+}
+
+// pushTimeout bounds how long pushing metrics may delay shutting down
+const pushTimeout = time.Second * 5
+
+// pushMetrics pushes the final metrics of the instance to the Pushgateway, if
+// one is set, as a record of it that outlives the process. A failure is only
+// logged so the push never stops Pico from exiting.
+func (app *App) pushMetrics() {
+	if app.push.URL == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+	if err := app.metrics.Push(ctx, app.push); err != nil {
+		zap.L().Warn("failed to push metrics to the Pushgateway",
+			zap.String("url", app.push.URL),
+			zap.Error(err))
+		return
+	}
+	zap.L().Info("pushed metrics to the Pushgateway",
+		zap.String("url", app.push.URL))
+}
+
+func getAuthMethod(c Config, repo task.Repo, secretConfig map[string]string) (transport.AuthMethod, error) {
 	if c.SSH {
 		authMethod, err := ssh.NewSSHAgentAuth("git")
 		if err != nil {
@@ -157,23 +910,34 @@ func getAuthMethod(c Config, secretConfig map[string]string) (transport.AuthMeth
 		return authMethod, nil
 	}
 
-	if c.Target.User != "" && c.Target.Pass != "" {
-		return &http.BasicAuth{
-			Username: c.Target.User,
-			Password: c.Target.Pass,
-		}, nil
+	mode, err := gitauth.ParseMode(c.GitAuth)
+	if err != nil {
+		return nil, err
 	}
 
-	user, userok := secretConfig["GIT_USERNAME"]
-	pass, passok := secretConfig["GIT_PASSWORD"]
-	if userok && passok {
-		return &http.BasicAuth{
-			Username: user,
-			Password: pass,
-		}, nil
+	// a token is always sent as a token header unless basic auth is forced,
+	// explicit credentials take precedence over those in the secret store.
+	if repo.Token != "" {
+		if mode == gitauth.ModeAuto {
+			mode = gitauth.ModeToken
+		}
+		return gitauth.New(mode, repo.User, repo.Token)
+	}
+	if repo.Pass != "" {
+		return gitauth.New(mode, repo.User, repo.Pass)
+	}
+
+	if token, ok := secretConfig["GIT_TOKEN"]; ok {
+		if mode == gitauth.ModeAuto {
+			mode = gitauth.ModeToken
+		}
+		return gitauth.New(mode, secretConfig["GIT_USERNAME"], token)
+	}
+	if pass, ok := secretConfig["GIT_PASSWORD"]; ok {
+		return gitauth.New(mode, secretConfig["GIT_USERNAME"], pass)
 	}
 
-	return nil, nil
+	return gitauth.NetrcResolver(c.Netrc)(repo.URL), nil
 }
 
 func getKeys(m map[string]string) []string {
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/redact"
+	"github.com/picostack/pico/task"
+)
+
+type fakeExecutor struct {
+	secrets *executor.SecretResolver
+	tasks   chan task.ExecutionTask
+	results func(executor.Result)
+	enabled func(string) bool
+}
+
+func (f *fakeExecutor) Subscribe(bus <-chan task.ExecutionTask) {
+	for t := range bus {
+		f.results(executor.Result{Task: t, Started: time.Now(), Finished: time.Now()})
+		f.tasks <- t
+	}
+}
+
+func (f *fakeExecutor) SetContext(context.Context) {}
+
+func (f *fakeExecutor) SetResultHandler(h func(executor.Result)) { f.results = h }
+
+func (f *fakeExecutor) SetEnabledFunc(enabled func(string) bool) { f.enabled = enabled }
+
+// commitRepo creates a git repository in dir with the given files committed
+func commitRepo(t *testing.T, dir string, files map[string]string) {
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=pico", "-c", "user.email=pico@localhost", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+}
+
+func TestStartWithExecutor(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root, err := ioutil.TempDir("", "pico-service")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	repo := filepath.Join(root, "repos", "app")
+	commitRepo(t, repo, map[string]string{"README": "app"})
+	configRepo := filepath.Join(root, "repos", "config")
+	commitRepo(t, configRepo, map[string]string{
+		"pico.js": fmt.Sprintf(`T({name: "app", url: %q, up: ["true"]});`, repo),
+	})
+
+	fake := &fakeExecutor{tasks: make(chan task.ExecutionTask, 1)}
+	app, err := Initialise(Config{
+		Target:        task.Repo{URL: configRepo},
+		Directory:     filepath.Join(root, "data"),
+		CheckInterval: time.Hour,
+		Secrets:       []string{"app:PASSWORD=hunter2"},
+	}, WithExecutor(func(secrets *executor.SecretResolver) executor.Executor {
+		fake.secrets = secrets
+		return fake
+	}))
+	require.NoError(t, err)
+	defer app.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.Start(ctx) //nolint:errcheck
+
+	select {
+	case got := <-fake.tasks:
+		assert.Equal(t, "app", got.Target.Name)
+		assert.Equal(t, []string{"true"}, got.Target.Up)
+		assert.False(t, got.Shutdown)
+		assert.Equal(t, filepath.Join(root, "data", "targets", "app"), got.Path)
+	case <-time.After(30 * time.Second):
+		t.Fatal("executor did not receive a task")
+	}
+
+	require.NotNil(t, fake.secrets)
+	secrets, err := fake.secrets.Resolve(context.Background(), task.Target{Name: "app"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"PASSWORD": "hunter2"}, secrets.Target)
+	assert.Equal(t, redact.Replacement, redact.String("hunter2"))
+	require.NotNil(t, fake.enabled)
+	assert.True(t, fake.enabled("app"))
+
+	require.Eventually(t, func() bool {
+		return len(app.history.Get("app")) == 1
+	}, 5*time.Second, 10*time.Millisecond, "result was not recorded")
+}
+
+func TestInitialiseFailureReleasesLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-service")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// fails after the data directory is locked
+	_, err = Initialise(Config{
+		Target:     task.Repo{URL: "https://example.com/config.git"},
+		Directory:  dir,
+		AllowStale: true,
+	})
+	assert.EqualError(t, err, "stale secrets can only be used with a secret cache key file")
+
+	l, err := lockDirectory(dir)
+	require.NoError(t, err)
+	assert.NoError(t, l.release())
+}
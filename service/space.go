@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/disk"
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/task"
+)
+
+// minFreeSpace is the free space below which clones, fetches and tasks are
+// skipped
+func (app *App) minFreeSpace() disk.Minimum {
+	return disk.Minimum{Bytes: app.config.MinFreeSpace, Percent: app.config.MinFreePercent}
+}
+
+// checkSpace measures the free space of the data directory's filesystem before
+// a clone or fetch of the targets. It returns an error matching
+// disk.ErrLowSpace if there's too little, and the targets are reported as
+// blocked until there's enough again. If the space can't be measured the
+// operation goes ahead, a failed measurement shouldn't stop deploys.
+func (app *App) checkSpace(targets []string) error {
+	err := app.measureSpace()
+	if err == nil {
+		return nil
+	}
+	app.mu.Lock()
+	if app.spaceBlocked == nil {
+		app.spaceBlocked = make(map[string]bool)
+	}
+	for _, name := range targets {
+		app.spaceBlocked[name] = true
+	}
+	app.mu.Unlock()
+	return err
+}
+
+// measureSpace records the free space of the data directory's filesystem and
+// returns why it's below the minimum, if it is. It warns and notifies once
+// when the space becomes low and logs when it's enough again.
+func (app *App) measureSpace() error {
+	s, err := disk.FreeSpace(app.config.Directory)
+	if err != nil {
+		zap.L().Warn("failed to measure free space of the data directory", zap.Error(err))
+		return nil
+	}
+	app.metrics.SetDataFree(s.Free)
+	low := app.minFreeSpace().Check(s)
+
+	app.mu.Lock()
+	app.dataFree = s.Free
+	wasLow := app.lowSpace != nil
+	app.lowSpace = low
+	app.mu.Unlock()
+
+	switch {
+	case low != nil && !wasLow:
+		zap.L().Warn("data directory is low on disk space, not cloning, fetching or executing tasks until there's enough",
+			zap.String("free", disk.FormatSize(s.Free)),
+			zap.Error(low))
+		go app.notifier.Notify(notifier.Event{ //nolint:errcheck
+			Type:    notifier.EventDiskUsage,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("not cloning, fetching or executing tasks: %s", low),
+		})
+	case low == nil && wasLow:
+		zap.L().Info("data directory has enough disk space again",
+			zap.String("free", disk.FormatSize(s.Free)))
+	}
+	return low
+}
+
+// holdForSpace measures the free space before a task is executed and reports
+// whether the task is held since there's too little. Only the latest task of
+// each target is kept to be executed once there's enough. Down commands aren't
+// held since they tend to free space.
+func (app *App) holdForSpace(t task.ExecutionTask) bool {
+	if t.Shutdown {
+		return false
+	}
+	low := app.measureSpace()
+	if low == nil {
+		return false
+	}
+	zap.L().Warn("disk space is low, not executing task",
+		zap.String("task_id", t.ID),
+		zap.String("target", t.Target.Name),
+		t.Target.LabelsField(),
+		zap.String("commit", t.Commit),
+		zap.Error(low))
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.spaceHeld == nil {
+		app.spaceHeld = make(map[string]task.ExecutionTask)
+	}
+	if app.spaceBlocked == nil {
+		app.spaceBlocked = make(map[string]bool)
+	}
+	app.spaceHeld[t.Target.Name] = t
+	app.spaceBlocked[t.Target.Name] = true
+	return true
+}
+
+// watchSpace measures the free space every check interval and, once there's
+// enough again, releases the tasks held meanwhile to out.
+func (app *App) watchSpace(ctx context.Context, out chan task.ExecutionTask) {
+	t := time.NewTicker(app.config.CheckInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if app.measureSpace() != nil {
+			continue
+		}
+		for _, held := range app.releaseSpace() {
+			out <- held
+		}
+	}
+}
+
+// releaseSpace unblocks every target blocked by low disk space and returns
+// the tasks held meanwhile, by target name.
+func (app *App) releaseSpace() []task.ExecutionTask {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	held := make([]task.ExecutionTask, 0, len(app.spaceHeld))
+	for _, t := range app.spaceHeld {
+		held = append(held, t)
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].Target.Name < held[j].Target.Name })
+	app.spaceHeld = nil
+	app.spaceBlocked = nil
+	return held
+}
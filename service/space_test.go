@@ -0,0 +1,43 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/disk"
+	"github.com/picostack/pico/metrics"
+	"github.com/picostack/pico/task"
+)
+
+func TestLowSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-space")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	m, err := metrics.New(nil)
+	require.NoError(t, err)
+
+	// no disk has this much free space
+	app := &App{config: Config{Directory: dir, MinFreeSpace: 1 << 62}, metrics: m}
+	err = app.checkSpace([]string{"app"})
+	assert.True(t, errors.Is(err, disk.ErrLowSpace), err)
+	assert.True(t, app.spaceBlocked["app"])
+	assert.Len(t, app.Degraded(), 1)
+
+	first := task.ExecutionTask{ID: "1", Target: task.Target{Name: "web"}}
+	latest := task.ExecutionTask{ID: "2", Target: task.Target{Name: "web"}}
+	assert.True(t, app.holdForSpace(first))
+	assert.True(t, app.holdForSpace(latest))
+	assert.False(t, app.holdForSpace(task.ExecutionTask{Target: task.Target{Name: "old"}, Shutdown: true}))
+
+	app.config.MinFreeSpace = 1
+	assert.NoError(t, app.checkSpace([]string{"app"}))
+	assert.Empty(t, app.Degraded())
+	assert.Equal(t, []task.ExecutionTask{latest}, app.releaseSpace())
+	assert.Empty(t, app.spaceBlocked)
+	assert.Empty(t, app.releaseSpace())
+}
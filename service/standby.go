@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/notifier"
+	"github.com/picostack/pico/task"
+)
+
+// activateFileInterval is how often a standby instance checks for the file
+// that activates it
+var activateFileInterval = time.Second * 5
+
+// isStandby reports whether the instance records tasks rather than executing
+// them, until it's activated.
+func (app *App) isStandby() bool {
+	return atomic.LoadInt32(&app.standby) == 1
+}
+
+// suppress records a task received in standby in the target's history without
+// executing it, so it's visible what would have been deployed.
+func (app *App) suppress(t task.ExecutionTask) {
+	zap.L().Info("standby instance, not executing task",
+		zap.String("task_id", t.ID),
+		zap.String("target", t.Target.Name),
+		t.Target.LabelsField(),
+		zap.String("commit", t.Commit),
+		zap.Bool("shutdown", t.Shutdown))
+	now := time.Now()
+	app.history.Add(executor.Result{
+		Task:       t,
+		Commit:     t.Commit,
+		Queued:     now,
+		Started:    now,
+		Finished:   now,
+		Suppressed: "standby",
+	})
+	app.metrics.ObserveSuppressed(t.Target)
+}
+
+// Activate implements api.Backend, it ends the standby of the instance and
+// deploys every target at its current head. With leader election, only the
+// leader deploys once it's elected. It returns api.ErrNotStandby if the
+// instance isn't in standby.
+func (app *App) Activate(requester string) error {
+	if !atomic.CompareAndSwapInt32(&app.standby, 1, 0) {
+		return api.ErrNotStandby
+	}
+	app.metrics.SetStandby(false)
+
+	zap.L().Info("standby instance activated",
+		zap.String("hostname", app.config.Hostname),
+		zap.String("requester", requester))
+	go app.notifier.Notify(notifier.Event{ //nolint:errcheck
+		Type:          notifier.EventActivated,
+		Time:          time.Now(),
+		Message:       fmt.Sprintf("%s: standby instance activated by %s", app.config.Hostname, requester),
+		TriggerDetail: requester,
+	})
+
+	app.mu.Lock()
+	out := app.executing
+	app.mu.Unlock()
+	if out != nil && app.isLeader() {
+		go app.deployPending(out, task.TriggerActivate, requester, true)
+	}
+	return nil
+}
+
+// watchActivateFile activates the instance once the activate file exists
+func (app *App) watchActivateFile(ctx context.Context) {
+	t := time.NewTicker(activateFileInterval)
+	defer t.Stop()
+	for app.isStandby() {
+		if _, err := os.Stat(app.config.ActivateFile); err == nil {
+			app.Activate("file " + app.config.ActivateFile) //nolint:errcheck
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
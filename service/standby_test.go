@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/metrics"
+	"github.com/picostack/pico/task"
+)
+
+func TestStandby(t *testing.T) {
+	m, err := metrics.New(nil)
+	require.NoError(t, err)
+	app := &App{history: executor.NewHistory(10, nil), metrics: m, standby: 1}
+	assert.True(t, app.isStandby())
+
+	app.suppress(task.ExecutionTask{Target: task.Target{Name: "app"}, Commit: "abc123"})
+	records := app.history.Get("app")
+	require.Len(t, records, 1)
+	assert.Equal(t, "standby", records[0].Suppressed)
+
+	assert.NoError(t, app.Activate("test"))
+	assert.False(t, app.isStandby())
+	assert.Equal(t, api.ErrNotStandby, app.Activate("test"))
+}
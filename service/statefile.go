@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/disk"
+	"github.com/picostack/pico/state"
+)
+
+// stateCompactInterval is how often the state file is compacted with the
+// configured retention
+const stateCompactInterval = time.Hour
+
+func (c Config) retention() state.Retention {
+	return state.Retention{MaxRecords: c.StateMaxRecords, MaxAge: c.StateMaxAge, MaxSize: c.StateMaxSize}
+}
+
+// compactState compacts the state file with the configured retention, a
+// failure is logged since the state is still usable without it.
+func (app *App) compactState() {
+	c, err := app.state.Compact()
+	if err != nil {
+		zap.L().Error("failed to compact state file", zap.Error(err))
+		return
+	}
+	if c.Removed > 0 {
+		zap.L().Info("compacted state file",
+			zap.Int("removed", c.Removed),
+			zap.String("before", disk.FormatSize(c.Before)),
+			zap.String("after", disk.FormatSize(c.After)))
+	}
+}
+
+// watchStateSize periodically compacts the state file, as it's compacted at
+// startup, so a busy host's history can't keep growing it.
+func (app *App) watchStateSize(ctx context.Context) {
+	t := time.NewTicker(stateCompactInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		app.compactState()
+	}
+}
+
+// ExportState writes the whole state persisted in the data directory as JSON,
+// it reads the state file as is so an instance may be using the directory.
+func ExportState(dir string, w io.Writer) error {
+	s, err := openStateFile(dir)
+	if err != nil {
+		return err
+	}
+	return s.Export(w)
+}
+
+// PruneState compacts the state file of the data directory with the given
+// retention. The instance using the directory must be stopped first, it would
+// otherwise write back what was pruned.
+func PruneState(dir string, r state.Retention) (state.Compaction, error) {
+	lock, err := lockDirectory(dir)
+	if err != nil {
+		return state.Compaction{}, err
+	}
+	defer lock.release() //nolint:errcheck
+
+	s, err := openStateFile(dir)
+	if err != nil {
+		return state.Compaction{}, err
+	}
+	s.SetRetention(r)
+	return s.Compact()
+}
+
+func openStateFile(dir string) (*state.Store, error) {
+	if _, err := os.Stat(filepath.Join(dir, state.FileName)); err != nil {
+		return nil, errors.Wrapf(err, "no state file in %s", dir)
+	}
+	return state.Open(dir)
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/state"
+)
+
+func TestPruneState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Error(t, ExportState(dir, ioutil.Discard))
+
+	s, err := state.Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.SetHistory("app", []state.Execution{{Commit: "abc123"}, {Commit: "def456"}}))
+
+	// an instance using the directory would write back what was pruned
+	l, err := lockDirectory(dir)
+	require.NoError(t, err)
+	_, err = PruneState(dir, state.Retention{MaxRecords: 1})
+	assert.Error(t, err)
+	require.NoError(t, l.release())
+
+	c, err := PruneState(dir, state.Retention{MaxRecords: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.Removed)
+
+	var out bytes.Buffer
+	assert.NoError(t, ExportState(dir, &out))
+	assert.Contains(t, out.String(), "def456")
+	assert.NotContains(t, out.String(), "abc123")
+}
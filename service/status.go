@@ -0,0 +1,443 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/executor"
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+	"github.com/picostack/pico/watcher"
+)
+
+var _ api.Backend = &App{}
+
+// Status implements api.Backend
+func (app *App) Status() api.Status {
+	state := app.watcher.GetState()
+
+	app.mu.Lock()
+	lastError := app.lastError
+	dataSize := app.dataSize
+	dataFree := app.dataFree
+	lowDisk := make(map[string]bool, len(app.spaceBlocked))
+	for k := range app.spaceBlocked {
+		lowDisk[k] = true
+	}
+	ex := app.executor
+	stale := make(map[string]time.Time, len(app.stale))
+	for k, v := range app.stale {
+		stale[k] = v
+	}
+	blocked := make(map[string][]string, len(app.blocked))
+	for k, v := range app.blocked {
+		blocked[k] = v
+	}
+	app.mu.Unlock()
+
+	var debouncing, limited map[string]time.Time
+	var approval map[string]string
+	var sizes, checkouts map[string]int64
+	var fetches map[string]watcher.TargetState
+	paused := make(map[string]bool)
+	if gw, ok := app.watcher.(*watcher.GitWatcher); ok {
+		debouncing = gw.Debouncing()
+		limited = gw.RateLimited()
+		approval = gw.PendingApproval()
+		sizes = gw.Sizes()
+		checkouts = gw.CheckoutSizes()
+		fetches = gw.State()
+		for _, g := range gw.PausedGroups() {
+			paused[g] = true
+		}
+	}
+
+	quarantined := make(map[string]executor.Quarantined)
+	if qr, ok := ex.(executor.QuarantineReporter); ok {
+		for _, q := range qr.Quarantined() {
+			quarantined[q.Target] = q
+		}
+	}
+
+	// a target has at most one waiting task of interest, the one received first
+	waiting := make(map[string]executor.Waiting)
+	if wr, ok := ex.(executor.WaitReporter); ok {
+		for _, w := range wr.Waiting() {
+			if _, ok := waiting[w.Target]; !ok {
+				waiting[w.Target] = w
+			}
+		}
+	}
+
+	s := api.Status{
+		Build:     buildinfo.Get(),
+		Hostname:  app.config.Hostname,
+		Leader:    app.isLeader(),
+		Standby:   app.isStandby(),
+		LastError: lastError,
+		DataSize:  dataSize,
+		DataFree:  dataFree,
+		Targets:   []api.TargetStatus{},
+		Runtime:   api.ReadRuntimeStats(),
+	}
+	if len(checkouts) > 0 {
+		s.CheckoutSizes = checkouts
+	}
+
+	for _, t := range state.Targets {
+		status := "enabled"
+		if !t.IsEnabled() {
+			status = "disabled"
+		}
+		ts := api.TargetStatus{
+			Name:       t.Name,
+			Status:     status,
+			Group:      t.Group,
+			Source:     t.Source,
+			Commit:     app.state.Applied(t.Name),
+			Path:       app.layout.Target(t),
+			Definition: t,
+			CloneSize:  sizes[t.Name],
+			Images:     app.history.Images(t.Name),
+		}
+		pass := t.ShouldPassEnvironment(app.config.PassEnvironment)
+		ts.Definition.PassEnvironment = &pass
+		if since, ok := stale[t.Name]; ok {
+			ts.StaleSecrets = &since
+		}
+		if until, ok := debouncing[t.Name]; ok {
+			ts.DebounceUntil = &until
+		}
+		if f, ok := fetches[t.Name]; ok {
+			ts.Fetch = fetchStatus(f)
+		}
+		if until, ok := limited[t.Name]; ok && t.IsEnabled() {
+			ts.Status = "rate limited until " + until.Format(time.RFC3339)
+			ts.RateLimitedUntil = &until
+		}
+		if commit, ok := approval[t.Name]; ok && t.IsEnabled() {
+			ts.Status = "pending approval of " + shortCommit(commit)
+			ts.PendingApproval = commit
+			ts.Approval = app.approvalStatus(ts.Path, ts.Commit, commit)
+		}
+		if w, ok := waiting[t.Name]; ok {
+			ts.Status = "waiting"
+			if w.Mutex != "" {
+				ts.Status = "waiting on mutex " + w.Mutex
+			}
+			ts.Waiting = &api.WaitingStatus{Since: w.Since, Mutex: w.Mutex}
+		}
+		if keys, ok := blocked[t.Name]; ok && t.IsEnabled() {
+			ts.Status = "blocked: missing secrets " + strings.Join(keys, ", ")
+			ts.MissingSecrets = keys
+		}
+		if lowDisk[t.Name] && t.IsEnabled() {
+			ts.Status = "blocked: low disk"
+			ts.LowDisk = true
+		}
+		if q, ok := quarantined[t.Name]; ok && t.IsEnabled() {
+			ts.Status = fmt.Sprintf("quarantined: %s panicked %d times", shortCommit(q.Commit), q.Panics)
+			ts.Quarantined = &api.QuarantineStatus{Commit: q.Commit, Panics: q.Panics, Panic: q.Panic, Since: q.Since}
+		}
+		s.Targets = append(s.Targets, ts)
+	}
+	s.Groups = groupStatus(state.Targets, paused)
+
+	for _, p := range app.providers {
+		revision := p.provider.Revision()
+		cs := api.ConfigStatus{
+			Source:        p.name,
+			Branch:        p.provider.Branch(),
+			Path:          p.provider.Directory(),
+			Applied:       revision.Commit,
+			AppliedAuthor: revision.Author,
+		}
+		if !revision.Time.IsZero() {
+			cs.AppliedTime = &revision.Time
+		}
+		if checked := p.provider.LastContact(); !checked.IsZero() {
+			cs.Checked = &checked
+		}
+		cs.Stale = p.provider.Stale()
+		if updated, by := p.provider.LastUpdate(); !updated.IsZero() {
+			cs.Updated = &updated
+			cs.UpdatedBy = by
+		}
+		if re := p.provider.RevisionError(); re != nil {
+			cs.Error = re.Err.Error()
+			cs.Commit = re.Commit
+			cs.File = re.File
+		}
+		s.Config = append(s.Config, cs)
+	}
+
+	staleSources := make(map[string]bool)
+	for _, cs := range s.Config {
+		if cs.Stale {
+			staleSources[cs.Source] = true
+		}
+	}
+	for i := range s.Targets {
+		ts := &s.Targets[i]
+		var failed bool
+		if h := app.history.Get(ts.Name); len(h) > 0 {
+			failed = h[0].Error != ""
+		}
+		stale := staleSources[ts.Source] || (ts.Source == "" && len(staleSources) > 0)
+		ts.State = targetState(*ts, paused[ts.Group], failed, stale)
+	}
+
+	return s
+}
+
+// targetState is the first state of api.TargetStatus that applies to a target,
+// given whether its group is paused, its last task failed and the configuration
+// it's from is stale.
+func targetState(ts api.TargetStatus, paused, failed, stale bool) string {
+	switch {
+	case !ts.Definition.IsEnabled():
+		return api.StateDisabled
+	case ts.Quarantined != nil:
+		return api.StateQuarantined
+	case len(ts.MissingSecrets) > 0 || ts.LowDisk:
+		return api.StateBlocked
+	case ts.PendingApproval != "":
+		return api.StatePendingApproval
+	case paused:
+		return api.StatePaused
+	case ts.Waiting != nil:
+		return api.StateWaiting
+	case ts.DebounceUntil != nil || ts.RateLimitedUntil != nil:
+		return api.StatePendingWindow
+	case failed || (ts.Fetch != nil && ts.Fetch.ConsecutiveFailures > 0):
+		return api.StateFailing
+	case stale:
+		return api.StateStaleConfig
+	}
+	return api.StateOK
+}
+
+func fetchStatus(s watcher.TargetState) *api.FetchStatus {
+	f := &api.FetchStatus{
+		LastCheck:           s.LastCheck,
+		LastError:           s.LastError,
+		ConsecutiveFailures: s.ConsecutiveFailures,
+	}
+	if !s.LastSuccess.IsZero() {
+		f.LastSuccess = &s.LastSuccess
+	}
+	return f
+}
+
+func groupStatus(targets []task.Target, paused map[string]bool) (out []api.GroupStatus) {
+	index := make(map[string]int)
+	for _, t := range targets {
+		if t.Group == "" {
+			continue
+		}
+		i, ok := index[t.Group]
+		if !ok {
+			i = len(out)
+			index[t.Group] = i
+			out = append(out, api.GroupStatus{Name: t.Group, Paused: paused[t.Group]})
+		}
+		out[i].Targets = append(out[i].Targets, t.Name)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return
+}
+
+// Degraded implements api.Backend, the configuration is stale while one of its
+// repositories is unreachable, targets are quarantined while their tasks keep
+// panicking and nothing is deployed while the disk is low on space.
+func (app *App) Degraded() (reasons []string) {
+	for _, p := range app.providers {
+		if p.provider.Stale() {
+			reasons = append(reasons, fmt.Sprintf("configuration from %s is stale, last checked %s",
+				p.name, p.provider.LastContact().Format(time.RFC3339)))
+		}
+	}
+	app.mu.Lock()
+	ex := app.executor
+	lowSpace := app.lowSpace
+	app.mu.Unlock()
+	if lowSpace != nil {
+		reasons = append(reasons, "data directory is low on disk space, "+lowSpace.Error())
+	}
+	if qr, ok := ex.(executor.QuarantineReporter); ok {
+		for _, q := range qr.Quarantined() {
+			reasons = append(reasons, fmt.Sprintf("target %s is quarantined, its tasks at %s panicked %d times",
+				q.Target, shortCommit(q.Commit), q.Panics))
+		}
+	}
+	return
+}
+
+// Config implements api.Backend
+func (app *App) Config() []api.ConfigField {
+	return EffectiveConfig(app.config)
+}
+
+// Trigger implements api.Backend
+func (app *App) Trigger(target string, immediate bool, requester string) error {
+	gw, ok := app.watcher.(*watcher.GitWatcher)
+	if !ok {
+		return errors.New("the watcher can't trigger deploys")
+	}
+	for _, t := range app.watcher.GetState().Targets {
+		if t.Name == target && t.IsEnabled() {
+			gw.Trigger(target, immediate, requester)
+			return nil
+		}
+	}
+	return api.ErrUnknownTarget
+}
+
+// Reinit implements api.Backend
+func (app *App) Reinit(target string, requester string) error {
+	gw, ok := app.watcher.(*watcher.GitWatcher)
+	if !ok {
+		return errors.New("the watcher can't trigger deploys")
+	}
+	for _, t := range app.watcher.GetState().Targets {
+		if t.Name != target || !t.IsEnabled() {
+			continue
+		}
+		if app.waiting(target) {
+			return api.ErrAlreadyRunning
+		}
+		if err := app.state.SetInitialised(target, false); err != nil {
+			return errors.Wrap(err, "failed to reset init of target")
+		}
+		gw.Trigger(target, true, requester)
+		return nil
+	}
+	return api.ErrUnknownTarget
+}
+
+// Approve implements api.Backend
+func (app *App) Approve(target string, approver string) error {
+	gw, ok := app.watcher.(*watcher.GitWatcher)
+	if !ok {
+		return errors.New("the watcher can't trigger deploys")
+	}
+	for _, t := range app.watcher.GetState().Targets {
+		if t.Name != target || !t.IsEnabled() {
+			continue
+		}
+		if _, ok := gw.PendingApproval()[target]; !ok {
+			return api.ErrNoPendingApproval
+		}
+		gw.Approve(target, approver)
+		return nil
+	}
+	return api.ErrUnknownTarget
+}
+
+// approvalStatus describes the commit of a target pending approval, what it
+// changes is only left out if the clone can't be read.
+func (app *App) approvalStatus(path, applied, commit string) *api.ApprovalStatus {
+	change, err := task.DescribeChange(path, applied, commit)
+	if err != nil {
+		return &api.ApprovalStatus{Commit: commit}
+	}
+	return &api.ApprovalStatus{
+		Commit:     change.Commit,
+		Author:     change.Author,
+		Files:      change.Files,
+		Insertions: change.Insertions,
+		Deletions:  change.Deletions,
+	}
+}
+
+// waiting reports whether a task of the target is waiting to be executed
+func (app *App) waiting(target string) bool {
+	app.mu.Lock()
+	ex := app.executor
+	app.mu.Unlock()
+	if wr, ok := ex.(executor.WaitReporter); ok {
+		for _, w := range wr.Waiting() {
+			if w.Target == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DeployGroup implements api.Backend
+func (app *App) DeployGroup(group string, immediate bool, requester string) error {
+	gw, names, err := app.group(group)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		gw.Trigger(name, immediate, requester)
+	}
+	return nil
+}
+
+// PauseGroup implements api.Backend
+func (app *App) PauseGroup(group string) error {
+	gw, _, err := app.group(group)
+	if err != nil {
+		return err
+	}
+	gw.PauseGroup(group)
+	return nil
+}
+
+// ResumeGroup implements api.Backend
+func (app *App) ResumeGroup(group string) error {
+	gw, _, err := app.group(group)
+	if err != nil {
+		return err
+	}
+	gw.ResumeGroup(group)
+	return nil
+}
+
+// group returns the enabled targets of a group, membership is read from the
+// current state so it follows reconfiguration.
+func (app *App) group(group string) (*watcher.GitWatcher, []string, error) {
+	gw, ok := app.watcher.(*watcher.GitWatcher)
+	if !ok {
+		return nil, nil, errors.New("the watcher can't operate on groups")
+	}
+	var names []string
+	found := false
+	for _, t := range app.watcher.GetState().Targets {
+		if t.Group != group {
+			continue
+		}
+		found = true
+		if t.IsEnabled() {
+			names = append(names, t.Name)
+		}
+	}
+	if group == "" || !found {
+		return nil, nil, api.ErrUnknownGroup
+	}
+	return gw, names, nil
+}
+
+// History implements api.Backend, removed targets are reported while they have
+// recorded executions.
+func (app *App) History(target string) ([]state.Execution, error) {
+	history := app.history.Get(target)
+	if len(history) > 0 {
+		return history, nil
+	}
+	for _, t := range app.watcher.GetState().Targets {
+		if t.Name == target {
+			return history, nil
+		}
+	}
+	return nil, api.ErrUnknownTarget
+}
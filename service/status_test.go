@@ -0,0 +1,24 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/task"
+)
+
+func TestGroupStatus(t *testing.T) {
+	targets := []task.Target{
+		{Name: "traefik", Group: "ingress"},
+		{Name: "grafana", Group: "monitoring"},
+		{Name: "standalone"},
+		{Name: "prometheus", Group: "monitoring"},
+	}
+	assert.Equal(t, []api.GroupStatus{
+		{Name: "ingress", Targets: []string{"traefik"}},
+		{Name: "monitoring", Paused: true, Targets: []string{"grafana", "prometheus"}},
+	}, groupStatus(targets, map[string]bool{"monitoring": true}))
+	assert.Empty(t, groupStatus(nil, nil))
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// sdNotify sends a state string to systemd's notification socket. It does
+// nothing when the process is not running under systemd with Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// an @ prefix denotes a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the interval systemd expects keepalives within, or
+// zero if the watchdog is not enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runSystemd reports readiness once the first configuration is applied, then
+// keeps the status line up to date and pets the watchdog for as long as the
+// watch loop keeps making progress.
+func (app *App) runSystemd(ctx context.Context, ready <-chan struct{}, lastActive func() time.Time) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+
+	watchdog := watchdogInterval()
+	interval := watchdog / 2
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	notify := func(state string) {
+		if err := sdNotify(state); err != nil {
+			zap.L().Warn("failed to notify systemd", zap.Error(err))
+		}
+	}
+
+	isReady := false
+	for {
+		select {
+		case <-ctx.Done():
+			notify("STOPPING=1")
+			return
+
+		case <-ready:
+			ready = nil
+			isReady = true
+			notify("READY=1\nSTATUS=" + app.statusLine())
+
+		case <-ticker.C:
+			if !isReady {
+				continue
+			}
+			stuck := time.Since(lastActive()) > interval
+			if stuck {
+				zap.L().Warn("watch loop has not made progress, not petting the watchdog",
+					zap.Time("last_active", lastActive()))
+				notify("STATUS=watcher stalled")
+				continue
+			}
+			if watchdog > 0 {
+				notify("WATCHDOG=1\nSTATUS=" + app.statusLine())
+			} else {
+				notify("STATUS=" + app.statusLine())
+			}
+		}
+	}
+}
+
+// statusLine is a short, single line summary of the app's state
+func (app *App) statusLine() string {
+	app.mu.Lock()
+	targets := app.targets
+	lastError := app.lastError
+	app.mu.Unlock()
+
+	if lastError == "" {
+		return fmt.Sprintf("%d targets", targets)
+	}
+	return fmt.Sprintf("%d targets, last error: %s", targets, lastError)
+}
@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+	"github.com/picostack/pico/watcher"
+)
+
+func TestSDNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-systemd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	assert.NoError(t, sdNotify("READY=1"))
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestSDNotifyInert(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	assert.NoError(t, sdNotify("READY=1"))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	assert.Equal(t, 30*time.Second, watchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", "1")
+	defer os.Unsetenv("WATCHDOG_PID")
+	assert.Equal(t, time.Duration(0), watchdogInterval())
+}
+
+// busyWatcher is a watcher whose state can't be read, as while it's executing
+// a long reconfiguration.
+type busyWatcher struct{ watcher.MockWatcher }
+
+func (busyWatcher) GetState() config.State { select {} }
+
+func TestStatusLine(t *testing.T) {
+	app := &App{channels: newChannels(nil, "")}
+	w := routingWatcher{&busyWatcher{}, app}
+	assert.NoError(t, w.SetState(config.State{Targets: task.Targets{{Name: "a"}, {Name: "b"}}}))
+	assert.Equal(t, "2 targets", app.statusLine())
+
+	app.lastError = "a: exit status 1"
+	assert.Equal(t, "2 targets, last error: a: exit status 1", app.statusLine())
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/task"
+	"github.com/picostack/pico/watcher"
+)
+
+// Check implements api.Checker, targets and configuration repositories are
+// matched by their normalised URL and must track one of the pushed refs.
+func (app *App) Check(push api.Push) (checked int) {
+	gw, ok := app.watcher.(*watcher.GitWatcher)
+	if !ok {
+		return 0
+	}
+	for _, t := range app.watcher.GetState().Targets {
+		if !t.IsEnabled() || !pushMatches(push, t.URLs()...) {
+			continue
+		}
+		if !tracksRef(push.Refs, t.Branch, app.layout.Target(t)) {
+			continue
+		}
+		gw.Check(t.Name, push.Delivery)
+		checked++
+	}
+	for _, p := range app.providers {
+		if !pushMatches(push, p.name) || !tracksRef(push.Refs, p.provider.Branch(), p.provider.Directory()) {
+			continue
+		}
+		zap.L().Debug("checking configuration repository now", zap.String("repo", p.name))
+		p.provider.Check()
+		checked++
+	}
+	return checked
+}
+
+// pushMatches returns true if the push is for the repository at any of the URLs
+func pushMatches(push api.Push, urls ...string) bool {
+	for _, u := range urls {
+		for _, pushed := range push.URLs {
+			if task.SameRepo(pushed, u) {
+				return true
+			}
+		}
+		if push.Name != "" && strings.HasSuffix(
+			strings.ToLower(task.NormaliseRepo(u)),
+			"/"+strings.ToLower(push.Name),
+		) {
+			return true
+		}
+	}
+	return false
+}
+
+// tracksRef returns true if one of the refs is the branch, or if no branch is
+// set, the branch currently checked out at path.
+func tracksRef(refs []string, branch, path string) bool {
+	if branch == "" {
+		branch = task.HeadBranch(path)
+		if branch == "" {
+			return false
+		}
+	}
+	for _, ref := range refs {
+		if ref == "refs/heads/"+branch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/picostack/pico/api"
+)
+
+func TestPushMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		push api.Push
+		url  string
+		want bool
+	}{
+		{"clone url", api.Push{URLs: []string{"ssh://git@bitbucket.example.com:7999/ops/app.git"}}, "ssh://git@Bitbucket.example.com:7999/ops/app", true},
+		{"other clone url", api.Push{URLs: []string{"ssh://git@host:7999/ops/app.git", "https://host/scm/ops/app.git"}}, "https://host/scm/ops/app", true},
+		{"different repo", api.Push{URLs: []string{"https://host/scm/ops/app.git"}}, "https://host/scm/ops/api", false},
+		{"name", api.Push{Name: "OPS/app"}, "https://host/scm/ops/app.git", true},
+		{"name suffix only", api.Push{Name: "OPS/app"}, "https://host/scm/ops/webapp.git", false},
+		{"nothing", api.Push{}, "https://host/scm/ops/app.git", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, pushMatches(tt.push, tt.url))
+		})
+	}
+}
+
+func TestTracksRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root, err := ioutil.TempDir("", "pico-webhook")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	repo := filepath.Join(root, "app")
+	commitRepo(t, repo, map[string]string{"README": "app"})
+	cmd := exec.Command("git", "branch", "-M", "main")
+	cmd.Dir = repo
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	main := []string{"refs/heads/main"}
+	assert.True(t, tracksRef(main, "", repo), "checked out branch")
+	assert.True(t, tracksRef(main, "main", filepath.Join(root, "missing")), "configured branch")
+	assert.False(t, tracksRef([]string{"refs/heads/feature"}, "", repo), "untracked branch")
+	assert.False(t, tracksRef([]string{"refs/pull-requests/1/from"}, "main", repo), "pull request ref")
+	assert.False(t, tracksRef(main, "", filepath.Join(root, "missing")), "no clone yet")
+}
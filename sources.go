@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/task"
+)
+
+// sourceRepo parses an additional configuration repository argument. The URL
+// may be followed by its own credentials as #user:PASSWORD_ENV, the password
+// being read from the named environment variable so it stays off the command
+// line, or as #TOKEN_ENV for a token. A source without credentials uses
+// fallback's, those of --git-username, --git-password and --git-token.
+func sourceRepo(arg string, fallback task.Repo) (task.Repo, error) {
+	i := strings.LastIndex(arg, "#")
+	if i < 0 {
+		fallback.URL = arg
+		return fallback, nil
+	}
+	url, creds := arg[:i], arg[i+1:]
+	if url == "" || creds == "" {
+		return task.Repo{}, errors.Errorf("invalid source '%s', expected URL#user:PASSWORD_ENV or URL#TOKEN_ENV", arg)
+	}
+
+	user, env := "", creds
+	if j := strings.Index(creds, ":"); j >= 0 {
+		user, env = creds[:j], creds[j+1:]
+	}
+	secret, ok := os.LookupEnv(env)
+	if !ok || secret == "" {
+		return task.Repo{}, errors.Errorf("environment variable %s for the credentials of source %s is empty", env, url)
+	}
+	if user == "" {
+		return task.Repo{URL: url, Token: secret}, nil
+	}
+	return task.Repo{URL: url, User: user, Pass: secret}, nil
+}
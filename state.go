@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/picostack/pico/disk"
+	"github.com/picostack/pico/service"
+	"github.com/picostack/pico/state"
+)
+
+// retentionFlags bound the executions kept in the state file, for both pico
+// run and pico state prune
+var retentionFlags = []cli.Flag{
+	cli.IntFlag{Name: "state-max-records", EnvVar: "STATE_MAX_RECORDS", Usage: "executions kept per target in the state file, the newest, unbounded when zero"},
+	cli.DurationFlag{Name: "state-max-age", EnvVar: "STATE_MAX_AGE", Usage: "drop executions that finished longer ago than this from the state file, unbounded when zero"},
+	cli.StringFlag{Name: "state-max-size", EnvVar: "STATE_MAX_SIZE", Usage: "size of the state file beyond which the oldest executions are dropped, such as 10M, unbounded when empty"},
+}
+
+func retentionConfig(c *cli.Context) (r state.Retention, err error) {
+	r.MaxRecords = c.Int("state-max-records")
+	r.MaxAge = c.Duration("state-max-age")
+	if c.String("state-max-size") != "" {
+		if r.MaxSize, err = disk.ParseSize(c.String("state-max-size")); err != nil {
+			return r, errors.Wrap(err, "invalid --state-max-size")
+		}
+	}
+	return r, nil
+}
+
+var stateCommand = cli.Command{
+	Name:  "state",
+	Usage: "export or prune the state file of the data directory",
+	Subcommands: []cli.Command{
+		{
+			Name:  "export",
+			Usage: "print the whole persisted state as JSON, such as to keep a copy before pruning",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "directory", EnvVar: "DIRECTORY", Value: "./cache/"},
+			},
+			Action: func(c *cli.Context) error {
+				return service.ExportState(c.String("directory"), os.Stdout)
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "drop the executions beyond the given retention from the state file, pico must not be running on the data directory",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{Name: "directory", EnvVar: "DIRECTORY", Value: "./cache/"},
+			}, retentionFlags...),
+			Action: func(c *cli.Context) error {
+				r, err := retentionConfig(c)
+				if err != nil {
+					return err
+				}
+				if !r.Enabled() {
+					return errors.New("missing --state-max-records, --state-max-age or --state-max-size to prune with")
+				}
+				pruned, err := service.PruneState(c.String("directory"), r)
+				if err != nil {
+					return errors.Wrap(err, "failed to prune state file")
+				}
+				fmt.Printf("removed %d executions, the state file is %s, it was %s\n",
+					pruned.Removed, disk.FormatSize(pruned.After), disk.FormatSize(pruned.Before))
+				return nil
+			},
+		},
+	},
+}
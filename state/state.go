@@ -0,0 +1,387 @@
+// Package state provides a small persisted store of per-target runtime state,
+// such as the commit that was last successfully applied and, optionally, the
+// most recent executions. The state is written to a JSON file inside the data
+// directory so it survives restarts.
+package state
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileName is the name of the state file inside the data directory
+const FileName = ".pico-state.json"
+
+// Target is the persisted state of a single target
+type Target struct {
+	Commit      string    `json:"commit"`                // the last successfully applied commit
+	AppliedAt   time.Time `json:"applied_at"`            // when the commit was applied
+	Initialised bool      `json:"initialised,omitempty"` // the init command succeeded on this host
+	Project     string    `json:"project,omitempty"`     // the compose project the target deployed
+}
+
+// Execution is the record of a single executed task. Previous is the commit
+// that was applied before it, so consecutive records show what each deploy
+// changed.
+type Execution struct {
+	TaskID        string    `json:"task_id,omitempty"`
+	Commit        string    `json:"commit"`
+	Previous      string    `json:"previous,omitempty"`
+	Trigger       string    `json:"trigger,omitempty"`
+	TriggerDetail string    `json:"trigger_detail,omitempty"` // such as the webhook delivery
+	Shutdown      bool      `json:"shutdown,omitempty"`
+	Error         string    `json:"error,omitempty"` // empty if the task succeeded
+	Noop          bool      `json:"noop,omitempty"`  // succeeded with a noop exit code
+	OutputBytes   int64     `json:"output_bytes"`    // the full size of the task's output
+	Queued        time.Time `json:"queued"`
+	Started       time.Time `json:"started"`
+	Finished      time.Time `json:"finished"`
+	Images        []Image   `json:"images,omitempty"`    // the images running after a deploy
+	Directory     string    `json:"directory,omitempty"` // where the task's commands ran
+
+	// Suppressed is why the task was recorded without being executed, such as
+	// the instance being in standby.
+	Suppressed string `json:"suppressed,omitempty"`
+}
+
+// Image is the image a container of a deployed target runs. Digest is the
+// repository digest the image was pulled by, or its ID if it was built locally.
+type Image struct {
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	Digest    string `json:"digest"`
+}
+
+// Retention bounds the executions kept in the state file, a zero field leaves
+// it unbounded.
+type Retention struct {
+	MaxRecords int           // executions kept per target, the newest
+	MaxAge     time.Duration // executions that finished longer ago are dropped
+	MaxSize    int64         // bytes of the state file, the oldest executions are dropped beyond it
+}
+
+// Enabled reports whether any bound is set
+func (r Retention) Enabled() bool {
+	return r.MaxRecords > 0 || r.MaxAge > 0 || r.MaxSize > 0
+}
+
+// trim returns the executions of a target, oldest first, within the bounds on
+// records and age.
+func (r Retention) trim(executions []Execution, now time.Time) []Execution {
+	if r.MaxRecords > 0 && len(executions) > r.MaxRecords {
+		executions = executions[len(executions)-r.MaxRecords:]
+	}
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge)
+		i := 0
+		for i < len(executions) && executions[i].Finished.Before(cutoff) {
+			i++
+		}
+		executions = executions[i:]
+	}
+	return executions
+}
+
+// Compaction describes what a compaction of the state file removed
+type Compaction struct {
+	Removed int   // executions dropped
+	Before  int64 // bytes of the state file before it was compacted
+	After   int64 // and after
+}
+
+// Store is a concurrency-safe, file-backed store of target state
+type Store struct {
+	path string
+
+	mu        sync.RWMutex
+	targets   map[string]Target
+	history   map[string][]Execution
+	retention Retention
+}
+
+type file struct {
+	Targets map[string]Target      `json:"targets"`
+	History map[string][]Execution `json:"history,omitempty"`
+}
+
+// Open loads the state file from the given directory or creates an empty store
+// if it does not exist yet.
+func Open(dir string) (*Store, error) {
+	s := &Store{
+		path:    filepath.Join(dir, FileName),
+		targets: make(map[string]Target),
+		history: make(map[string][]Execution),
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.Wrap(err, "failed to read state file")
+	}
+
+	var f file
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, errors.Wrap(err, "failed to decode state file")
+	}
+	if f.Targets != nil {
+		s.targets = f.Targets
+	}
+	if f.History != nil {
+		s.history = f.History
+	}
+	return s, nil
+}
+
+// Get returns the state of the named target
+func (s *Store) Get(name string) (Target, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.targets[name]
+	return t, ok
+}
+
+// Applied returns the last commit applied for the named target, if any
+func (s *Store) Applied(name string) string {
+	t, _ := s.Get(name)
+	return t.Commit
+}
+
+// SetApplied records a successfully applied commit for the named target
+func (s *Store) SetApplied(name, commit string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.targets[name]
+	t.Commit = commit
+	t.AppliedAt = time.Now()
+	s.targets[name] = t
+	return s.save()
+}
+
+// SetProject records the compose project the named target deployed, so the
+// project is recognised as the target's once it's renamed or removed.
+func (s *Store) SetProject(name, project string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.targets[name]
+	t.Project = project
+	s.targets[name] = t
+	return s.save()
+}
+
+// Targets returns the persisted state of every target, including targets that
+// are no longer configured.
+func (s *Store) Targets() map[string]Target {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]Target, len(s.targets))
+	for name, t := range s.targets {
+		out[name] = t
+	}
+	return out
+}
+
+// Initialised reports whether the init command of the named target succeeded
+func (s *Store) Initialised(name string) bool {
+	t, _ := s.Get(name)
+	return t.Initialised
+}
+
+// SetInitialised records whether the init command of the named target has
+// succeeded, clearing it makes the init command run again before the next up.
+func (s *Store) SetInitialised(name string, initialised bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.targets[name]
+	t.Initialised = initialised
+	s.targets[name] = t
+	return s.save()
+}
+
+// History returns the persisted executions of every target, oldest first
+func (s *Store) History() map[string][]Execution {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string][]Execution, len(s.history))
+	for name, h := range s.history {
+		out[name] = append([]Execution(nil), h...)
+	}
+	return out
+}
+
+// SetHistory replaces the persisted executions of the named target, those
+// beyond the retention's bounds on records and age aren't persisted.
+func (s *Store) SetHistory(name string, executions []Execution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history[name] = append([]Execution(nil), s.retention.trim(executions, time.Now())...)
+	return s.save()
+}
+
+// SetRetention sets the bounds of the executions kept by SetHistory and Compact
+func (s *Store) SetRetention(r Retention) {
+	s.mu.Lock()
+	s.retention = r
+	s.mu.Unlock()
+}
+
+// Compact drops the executions beyond the retention's bounds and rewrites the
+// state file. Once every target is within the bounds on records and age, the
+// oldest executions of any target are dropped until the file is within the
+// bound on size.
+func (s *Store) Compact() (c Compaction, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info, err := os.Stat(s.path); err == nil {
+		c.Before = info.Size()
+	}
+	now := time.Now()
+	for name, h := range s.history {
+		trimmed := s.retention.trim(h, now)
+		c.Removed += len(h) - len(trimmed)
+		s.history[name] = trimmed
+		if len(trimmed) == 0 {
+			delete(s.history, name)
+		}
+	}
+
+	b, err := s.encode()
+	if err != nil {
+		return c, err
+	}
+	for s.retention.MaxSize > 0 && int64(len(b)) > s.retention.MaxSize {
+		removed, err := s.dropOldest(int64(len(b)) - s.retention.MaxSize)
+		if err != nil {
+			return c, err
+		}
+		if removed == 0 {
+			break // only the targets are left, they're kept whatever their size
+		}
+		c.Removed += removed
+		if b, err = s.encode(); err != nil {
+			return c, err
+		}
+	}
+	if err := s.write(b); err != nil {
+		return c, err
+	}
+	c.After = int64(len(b))
+	return c, nil
+}
+
+// dropOldest drops the oldest executions of every target until at least excess
+// bytes of their encoding are dropped, it returns how many it dropped. Must
+// hold mu.
+func (s *Store) dropOldest(excess int64) (int, error) {
+	type record struct {
+		target   string
+		finished time.Time
+	}
+	var records []record
+	for name, h := range s.history {
+		for _, e := range h {
+			records = append(records, record{name, e.Finished})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].finished.Before(records[j].finished) })
+
+	var dropped int64
+	removed := 0
+	for _, r := range records {
+		if dropped >= excess {
+			break
+		}
+		// each target's executions are oldest first
+		b, err := json.MarshalIndent(s.history[r.target][0], "    ", "  ")
+		if err != nil {
+			return removed, errors.Wrap(err, "failed to encode state")
+		}
+		dropped += int64(len(b))
+		s.history[r.target] = s.history[r.target][1:]
+		if len(s.history[r.target]) == 0 {
+			delete(s.history, r.target)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Export writes the whole state as it's persisted, as indented JSON
+func (s *Store) Export(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, err := s.encode()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Remove deletes the state of the named target, its history is kept so the
+// execution that removed it remains visible.
+func (s *Store) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.targets, name)
+	return s.save()
+}
+
+// save writes the state to the state file. Must hold mu.
+func (s *Store) save() error {
+	b, err := s.encode()
+	if err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+func (s *Store) encode() ([]byte, error) {
+	b, err := json.MarshalIndent(file{Targets: s.targets, History: s.history}, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode state")
+	}
+	return b, nil
+}
+
+// write writes the encoded state to a temporary file, syncs it then renames it
+// over the original so neither a crash nor a power loss mid-write ever leaves
+// a truncated state file.
+func (s *Store) write(b []byte) error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write state file")
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write state file")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to sync state file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to write state file")
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return errors.Wrap(err, "failed to replace state file")
+	}
+	// the rename is only durable once the directory is synced, which not
+	// every platform supports
+	if d, err := os.Open(filepath.Dir(s.path)); err == nil {
+		d.Sync() //nolint:errcheck
+		d.Close()
+	}
+	return nil
+}
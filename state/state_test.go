@@ -0,0 +1,104 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "", s.Applied("app"))
+
+	assert.NoError(t, s.SetInitialised("app", true))
+	assert.NoError(t, s.SetApplied("app", "abc123"))
+	assert.NoError(t, s.SetApplied("other", "def456"))
+	assert.NoError(t, s.Remove("other"))
+
+	reopened, err := Open(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", reopened.Applied("app"))
+	assert.True(t, reopened.Initialised("app"))
+	_, ok := reopened.Get("other")
+	assert.False(t, ok)
+}
+
+func TestStoreHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, s.History())
+
+	executions := []Execution{{Commit: "abc123"}, {Commit: "def456", Previous: "abc123"}}
+	assert.NoError(t, s.SetHistory("app", executions))
+	assert.NoError(t, s.Remove("app"))
+
+	reopened, err := Open(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]Execution{"app": executions}, reopened.History())
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	assert.NoError(t, err)
+	now := time.Now()
+	executions := func(n int, age time.Duration) (out []Execution) {
+		for i := n; i > 0; i-- {
+			out = append(out, Execution{Commit: fmt.Sprint(i), Finished: now.Add(-age * time.Duration(i))})
+		}
+		return
+	}
+	assert.NoError(t, s.SetApplied("app", "abc123"))
+	assert.NoError(t, s.SetHistory("app", executions(10, time.Minute)))
+	assert.NoError(t, s.SetHistory("old", executions(5, time.Hour*24)))
+
+	// the bounds on records and age apply to new history too
+	s.SetRetention(Retention{MaxRecords: 8, MaxAge: time.Hour * 80})
+	c, err := s.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, c.Removed)
+	assert.Len(t, s.History()["app"], 8)
+	assert.Equal(t, "1", s.History()["app"][7].Commit)
+	assert.Len(t, s.History()["old"], 3)
+	assert.NoError(t, s.SetHistory("app", executions(10, time.Minute)))
+	assert.Len(t, s.History()["app"], 8)
+
+	// the oldest executions of any target go first once it's too big
+	s.SetRetention(Retention{MaxSize: c.After / 2})
+	c, err = s.Compact()
+	assert.NoError(t, err)
+	assert.True(t, c.After <= c.Before/2+1, c)
+	_, ok := s.History()["old"]
+	assert.False(t, ok)
+	assert.NotEmpty(t, s.History()["app"])
+
+	// nothing but the targets is left at the tiniest size
+	s.SetRetention(Retention{MaxSize: 1})
+	_, err = s.Compact()
+	assert.NoError(t, err)
+	reopened, err := Open(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, reopened.History())
+	assert.Equal(t, "abc123", reopened.Applied("app"))
+
+	var out bytes.Buffer
+	assert.NoError(t, reopened.Export(&out))
+	assert.Contains(t, out.String(), `"abc123"`)
+}
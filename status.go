@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/picostack/pico/api"
+	"github.com/picostack/pico/state"
+	"github.com/picostack/pico/task"
+)
+
+// printStatus prints the targets of a running instance
+func printStatus(c *api.Client) error {
+	s, err := c.Status()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("hostname: %s\nleader:   %t\nstandby:  %t\nversion:  %s\n", s.Hostname, s.Leader, s.Standby, s.Build.Version)
+	for _, cs := range s.Config {
+		fmt.Printf("config:   %s %s@%s", cs.Source, cs.Branch, short(cs.Applied))
+		if cs.AppliedAuthor != "" {
+			fmt.Printf(" (%s)", cs.AppliedAuthor)
+		}
+		if cs.Stale && cs.Checked != nil {
+			fmt.Printf(" (stale, last checked %s)", cs.Checked.Local().Format(time.RFC3339))
+		}
+		if cs.Updated != nil {
+			fmt.Printf(" updated by %s at %s", cs.UpdatedBy, cs.Updated.Local().Format(time.RFC3339))
+		}
+		fmt.Println()
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tGROUP\tSTATUS\tCOMMIT")
+	for _, t := range s.Targets {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.Name, t.Group, t.Status, short(t.Commit))
+	}
+	return w.Flush()
+}
+
+// printHistory prints the recent executions of a target, newest first
+func printHistory(c *api.Client, target string) error {
+	h, err := c.History(target)
+	if err != nil {
+		return err
+	}
+	if len(h.Executions) == 0 {
+		fmt.Printf("no executions recorded for %s\n", target)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tTASK\tCOMMIT\tPREVIOUS\tTRIGGER\tRESULT\tDURATION")
+	for _, e := range h.Executions {
+		result := e.Status
+		if e.Shutdown && e.Status == task.ResultSuccess {
+			result = "shutdown"
+		}
+		if e.Error != "" {
+			result += ": " + e.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Started.Local().Format(time.RFC3339),
+			e.TaskID,
+			short(e.Commit),
+			short(e.Previous),
+			trigger(e.Execution),
+			result,
+			e.Finished.Sub(e.Started).Round(time.Millisecond))
+	}
+	return w.Flush()
+}
+
+// trigger shows what caused an execution with its detail, such as the admin
+// user of a manual trigger.
+func trigger(e state.Execution) string {
+	if e.TriggerDetail == "" {
+		return e.Trigger
+	}
+	return e.Trigger + " (" + e.TriggerDetail + ")"
+}
+
+func short(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// RequiresApproval reports whether changes to the target may be held pending
+// approval rather than deployed automatically.
+func (t *Target) RequiresApproval() bool {
+	return t.ApprovalRequired || t.RestrictsAuthors()
+}
+
+// CheckApproval returns an error describing why the commit of the repository
+// at path may not be deployed automatically, nil if it may.
+func (t *Target) CheckApproval(path, commit string) error {
+	if t.ApprovalRequired {
+		return errors.New("the target requires approval")
+	}
+	return t.CheckAuthors(path, commit)
+}
+
+// Change summarises a commit, such as one pending approval, and what it
+// changes since the commit that was last applied.
+type Change struct {
+	Commit     string
+	Author     string
+	Files      int
+	Insertions int
+	Deletions  int
+}
+
+// DescribeChange summarises the commit of the repository at path, its changes
+// are counted from the commit from or from its parent if from is empty or no
+// longer in the repository.
+func DescribeChange(path, from, commit string) (Change, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return Change{}, errors.Wrap(err, "failed to open repository")
+	}
+	c, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return Change{}, errors.Wrapf(err, "failed to read commit %s", commit)
+	}
+
+	var stats object.FileStats
+	if base, err := repo.CommitObject(plumbing.NewHash(from)); from != "" && err == nil {
+		patch, err := base.Patch(c)
+		if err != nil {
+			return Change{}, errors.Wrapf(err, "failed to diff %s with %s", from, commit)
+		}
+		stats = patch.Stats()
+	} else if stats, err = c.Stats(); err != nil {
+		return Change{}, errors.Wrapf(err, "failed to diff commit %s", commit)
+	}
+
+	change := Change{
+		Commit: commit,
+		Author: c.Author.Name + " <" + c.Author.Email + ">",
+		Files:  len(stats),
+	}
+	for _, s := range stats {
+		change.Insertions += s.Addition
+		change.Deletions += s.Deletion
+	}
+	return change, nil
+}
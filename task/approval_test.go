@@ -0,0 +1,57 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestDescribeChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-approval")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(files map[string]string) string {
+		for name, content := range files {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+			_, err := wt.Add(name)
+			require.NoError(t, err)
+		}
+		hash, err := wt.Commit("change", &git.CommitOptions{
+			Author: &object.Signature{Name: "Alice", Email: "alice@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		return hash.String()
+	}
+
+	applied := commit(map[string]string{"README": "app\n"})
+	commit(map[string]string{"README": "app\nv2\n"})
+	pending := commit(map[string]string{"README": "app\nv3\n", "docker-compose.yml": "services:\n  web:\n"})
+
+	change, err := DescribeChange(dir, applied, pending)
+	require.NoError(t, err)
+	assert.Equal(t, Change{Commit: pending, Author: "Alice <alice@example.com>", Files: 2, Insertions: 3}, change)
+
+	// without a commit applied the change is the commit's own
+	change, err = DescribeChange(dir, "", pending)
+	require.NoError(t, err)
+	assert.Equal(t, Change{Commit: pending, Author: "Alice <alice@example.com>", Files: 2, Insertions: 3, Deletions: 1}, change)
+
+	target := Target{ApprovalRequired: true}
+	assert.True(t, target.RequiresApproval())
+	assert.EqualError(t, target.CheckApproval(dir, pending), "the target requires approval")
+	target = Target{}
+	assert.False(t, target.RequiresApproval())
+	assert.NoError(t, target.CheckApproval(dir, pending))
+}
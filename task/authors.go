@@ -0,0 +1,59 @@
+package task
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// RestrictsAuthors reports whether only some authors or committers' changes
+// to the target are deployed automatically.
+func (t *Target) RestrictsAuthors() bool {
+	return len(t.AllowedAuthors) > 0 || len(t.AllowedCommitters) > 0
+}
+
+// CheckAuthors returns an error describing why the commit of the repository at
+// path may not be deployed automatically, nil if its author and committer are
+// both allowed by the target.
+func (t *Target) CheckAuthors(path, commit string) error {
+	if !t.RestrictsAuthors() {
+		return nil
+	}
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open repository")
+	}
+	c, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return errors.Wrapf(err, "failed to read commit %s", commit)
+	}
+	if !allowedIdentity(t.AllowedAuthors, c.Author) {
+		return errors.Errorf("author %s is not allowed", c.Author.Email)
+	}
+	if !allowedIdentity(t.AllowedCommitters, c.Committer) {
+		return errors.Errorf("committer %s is not allowed", c.Committer.Email)
+	}
+	return nil
+}
+
+// allowedIdentity reports whether the email or name of a commit's author or
+// committer matches any of the patterns, ignoring case. Anyone is allowed if
+// there are no patterns.
+func allowedIdentity(patterns []string, s object.Signature) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		for _, id := range []string{s.Email, s.Name} {
+			if ok, _ := path.Match(p, strings.ToLower(id)); ok && id != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestCheckAuthors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-authors")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("app"), 0600))
+	_, err = wt.Add("README")
+	require.NoError(t, err)
+	hash, err := wt.Commit("merge", &git.CommitOptions{
+		Author:    &object.Signature{Name: "Alice", Email: "alice@example.com", When: time.Now()},
+		Committer: &object.Signature{Name: "deploy-bot", Email: "bot@ci.example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	commit := hash.String()
+
+	tests := []struct {
+		name       string
+		authors    []string
+		committers []string
+		wantErr    string
+	}{
+		{"unrestricted", nil, nil, ""},
+		{"author email", []string{"alice@example.com"}, nil, ""},
+		{"author glob", []string{"*@EXAMPLE.com"}, nil, ""},
+		{"author name", []string{"alice"}, nil, ""},
+		{"committer name", nil, []string{"deploy-bot"}, ""},
+		{"both", []string{"*@example.com"}, []string{"bot@*"}, ""},
+		{"author denied", []string{"bob@example.com"}, nil, "author alice@example.com is not allowed"},
+		{"committer denied", []string{"*@example.com"}, []string{"*-bot@example.com"}, "committer bot@ci.example.com is not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := Target{AllowedAuthors: tt.authors, AllowedCommitters: tt.committers}
+			err := target.CheckAuthors(dir, commit)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	target := Target{AllowedAuthors: []string{"*"}}
+	assert.Error(t, target.CheckAuthors(dir, "0000000000000000000000000000000000000000"))
+}
@@ -0,0 +1,115 @@
+package task
+
+import (
+	"sort"
+)
+
+// What applying a configuration does to a target
+const (
+	ChangeAdd    = "add"    // a new target is deployed
+	ChangeModify = "modify" // the target is deployed again with its new definition
+	ChangeRemove = "remove" // the target's down command is run
+)
+
+// PlannedChange is what applying a configuration would do to a target, as
+// shown by pico plan.
+type PlannedChange struct {
+	Target string
+	Action string   // one of the Change constants
+	Fields []string // the fields a modification changes, as for TargetChange
+	// Executes is whether a task is executed as soon as the configuration is
+	// applied, the up command or, for a removal, the down command. Note says
+	// why it isn't or when it might not be, such as for approval.
+	Executes bool
+	Note     string
+	// Clone is the directory the target is deployed from and Cloned is set if
+	// it's cloned anew, even if its deploy is held, either since no target used it or since its checkout
+	// mode changed. Unused is the target's previous clone if no target uses
+	// it any longer, it's left in the data directory to be cleaned up.
+	Clone  string
+	Cloned bool
+	Unused string
+}
+
+// PlanChanges returns what applying the new targets in place of the old would
+// do, ordered by target name. Unchanged targets are left out. The fields set
+// by the reconfigurer, the source and the shared clone, are never changes on
+// their own since clones are described separately.
+func PlanChanges(oldTargets, newTargets []Target, layout Layout) (changes []PlannedChange) {
+	diff := CompareTargets(withoutDerived(oldTargets), withoutDerived(newTargets))
+
+	old := make(map[string]Target, len(oldTargets))
+	oldClones := make(map[string]bool, len(oldTargets))
+	for _, t := range oldTargets {
+		old[t.Name] = t
+		if t.IsEnabled() {
+			oldClones[layout.Target(t)] = true
+		}
+	}
+	updated := make(map[string]Target, len(newTargets))
+	newClones := make(map[string]bool, len(newTargets))
+	for _, t := range newTargets {
+		updated[t.Name] = t
+		if t.IsEnabled() {
+			newClones[layout.Target(t)] = true
+		}
+	}
+	// unused returns the clone of an old target if no new target uses it
+	unused := func(t Target) string {
+		if clone := layout.Target(t); t.IsEnabled() && !newClones[clone] {
+			return clone
+		}
+		return ""
+	}
+
+	for _, name := range diff.Added {
+		t := updated[name]
+		c := PlannedChange{Target: name, Action: ChangeAdd, Clone: layout.Target(t)}
+		c.Executes, c.Note = deploys(t)
+		c.Cloned = t.IsEnabled() && !oldClones[c.Clone]
+		changes = append(changes, c)
+	}
+	for _, m := range diff.Modified {
+		t, previous := updated[m.Name], old[m.Name]
+		c := PlannedChange{Target: m.Name, Action: ChangeModify, Fields: m.Fields, Clone: layout.Target(t)}
+		c.Executes, c.Note = deploys(t)
+		c.Cloned = t.IsEnabled() && (!oldClones[c.Clone] || t.GetCheckout() != previous.GetCheckout())
+		c.Unused = unused(previous)
+		changes = append(changes, c)
+	}
+	for _, name := range diff.Removed {
+		t := old[name]
+		c := PlannedChange{Target: name, Action: ChangeRemove, Executes: t.IsEnabled(), Unused: unused(t)}
+		if !c.Executes {
+			c.Note = "disabled"
+		}
+		changes = append(changes, c)
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Target < changes[j].Target })
+	return
+}
+
+// deploys reports whether a new or modified target is deployed as soon as the
+// configuration is applied, with a note if it isn't or might not be.
+func deploys(t Target) (bool, string) {
+	switch {
+	case !t.IsEnabled():
+		return false, "disabled"
+	case t.ApprovalRequired:
+		return false, "held until approved"
+	case t.RestrictsAuthors():
+		return true, "unless its commit's author or committer isn't allowed"
+	}
+	return true, ""
+}
+
+func withoutDerived(targets []Target) []Target {
+	out := make([]Target, len(targets))
+	for i, t := range targets {
+		t.Source = ""
+		t.Clone = ""
+		out[i] = t
+	}
+	return out
+}
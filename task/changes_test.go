@@ -0,0 +1,68 @@
+package task
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanChanges(t *testing.T) {
+	disabled := false
+	l := Layout{Root: "/data"}
+	clone := func(name string) string { return filepath.Join("/data", TargetsDirectory, name) }
+
+	tests := []struct {
+		name    string
+		old     []Target
+		new     []Target
+		changes []PlannedChange
+	}{
+		{
+			"unchanged",
+			[]Target{{Name: "app", RepoURL: "https://git/app", Source: "base", Clone: ".shared-app"}},
+			[]Target{{Name: "app", RepoURL: "https://git/app"}},
+			nil,
+		},
+		{
+			"added",
+			nil,
+			[]Target{{Name: "app", RepoURL: "https://git/app"}, {Name: "off", RepoURL: "https://git/off", Enabled: &disabled}},
+			[]PlannedChange{
+				{Target: "app", Action: ChangeAdd, Executes: true, Clone: clone("app"), Cloned: true},
+				{Target: "off", Action: ChangeAdd, Note: "disabled", Clone: clone("off")},
+			},
+		},
+		{
+			"modified",
+			[]Target{{Name: "app", RepoURL: "https://git/app"}, {Name: "web", RepoURL: "https://git/web"}},
+			[]Target{{Name: "app", RepoURL: "https://git/app", Branch: "next"}, {Name: "web", RepoURL: "https://git/web", Checkout: CheckoutArchive, ApprovalRequired: true}},
+			[]PlannedChange{
+				{Target: "app", Action: ChangeModify, Fields: []string{"branch"}, Executes: true, Clone: clone("app_next"), Cloned: true, Unused: clone("app")},
+				{Target: "web", Action: ChangeModify, Fields: []string{"checkout", "approval_required"}, Note: "held until approved", Clone: clone("web"), Cloned: true},
+			},
+		},
+		{
+			"removed",
+			[]Target{{Name: "app", RepoURL: "https://git/app"}, {Name: "off", RepoURL: "https://git/off", Enabled: &disabled}},
+			nil,
+			[]PlannedChange{
+				{Target: "app", Action: ChangeRemove, Executes: true, Unused: clone("app")},
+				{Target: "off", Action: ChangeRemove, Note: "disabled"},
+			},
+		},
+		{
+			"shared clone kept",
+			[]Target{{Name: "api", RepoURL: "https://git/app", Clone: "shared"}, {Name: "app", RepoURL: "https://git/app", Clone: "shared"}},
+			[]Target{{Name: "app", RepoURL: "https://git/app", Clone: "shared"}},
+			[]PlannedChange{
+				{Target: "api", Action: ChangeRemove, Executes: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.changes, PlanChanges(tt.old, tt.new, l))
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package task
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// How a target's repository is checked out
+const (
+	// CheckoutFull keeps a working tree of every file, the default
+	CheckoutFull = "full"
+	// CheckoutSparse keeps a working tree of only the files tasks need
+	CheckoutSparse = "sparse"
+	// CheckoutArchive keeps no working tree, the files tasks need are written
+	// to a directory of their own for each task, like `git archive` would.
+	CheckoutArchive = "bare-archive"
+)
+
+// GetCheckout returns how the target's repository is checked out
+func (t *Target) GetCheckout() string {
+	if t.Checkout == "" {
+		return CheckoutFull
+	}
+	return t.Checkout
+}
+
+// validateCheckout checks the checkout mode of a target. Only the files under
+// a subpath are checked out sparsely, so a sparse checkout requires one.
+func validateCheckout(t Target) error {
+	switch t.GetCheckout() {
+	case CheckoutFull:
+		return nil
+	case CheckoutSparse, CheckoutArchive:
+	default:
+		return errors.Errorf("target '%s' checkout '%s' is not one of %s, %s or %s", t.Name, t.Checkout, CheckoutFull, CheckoutSparse, CheckoutArchive)
+	}
+	if t.Directory != "" {
+		return errors.Errorf("target '%s' has a directory of its own and can only be checked out in full", t.Name)
+	}
+	if t.Checkout == CheckoutSparse && t.Subpath == "" {
+		return errors.Errorf("target '%s' checkout '%s' requires a subpath", t.Name, t.Checkout)
+	}
+	return nil
+}
+
+// CheckoutPaths returns the paths of the repository that tasks of the target
+// need, see SubpathPaths, or nil for every path if it's checked out in full or
+// has no subpath.
+func (t *Target) CheckoutPaths() []string {
+	if t.GetCheckout() == CheckoutFull {
+		return nil
+	}
+	return t.SubpathPaths()
+}
+
+// SubpathPaths returns the paths of the repository the tasks of a target with
+// a subpath read, its subpath, template sources and env files, which are
+// relative to the root of the repository. It returns nil without a subpath.
+func (t *Target) SubpathPaths() []string {
+	if t.Subpath == "" {
+		return nil
+	}
+	paths := []string{t.Subpath}
+	for _, tpl := range t.Templates {
+		paths = append(paths, tpl.Source)
+	}
+	paths = append(paths, t.EnvFile...)
+	for i, p := range paths {
+		paths[i] = path.Clean(filepath.ToSlash(p))
+	}
+	return paths
+}
+
+// inPaths reports whether a file of a tree is one of the paths or inside one,
+// every file is within no paths.
+func inPaths(name string, paths []string) bool {
+	if paths == nil {
+		return true
+	}
+	for _, p := range paths {
+		if p == "." || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBare reports whether the repository at path has no working tree that's
+// kept up to date by pulls, as for sparse and archive checkouts.
+func IsBare(path string) bool {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return false
+	}
+	cfg, err := repo.Config()
+	return err == nil && cfg.Core.IsBare
+}
+
+// WriteTree writes the files of a commit of the repository at path that are
+// within paths, or every file if paths is nil, to dir. It returns the names of
+// the files written, relative to dir and with forward slashes.
+func WriteTree(path, commit, dir string, paths []string) (map[string]bool, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open repository")
+	}
+	c, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read commit %s", commit)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read commit tree")
+	}
+
+	written := make(map[string]bool)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if !inPaths(f.Name, paths) {
+			return nil
+		}
+		written[f.Name] = true
+		return writeFile(dir, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+func writeFile(dir string, f *object.File) error {
+	target := filepath.Join(dir, filepath.FromSlash(f.Name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	if f.Mode == filemode.Symlink {
+		link, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(link, target)
+	}
+
+	mode := os.FileMode(0o644)
+	if f.Mode == filemode.Executable {
+		mode = 0o755
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(target, mode)
+}
+
+// SyncTree makes the working tree of a sparse checkout at path match the files
+// of the commit within paths, files that are no longer within them or no
+// longer exist are removed.
+func SyncTree(path, commit string, paths []string) error {
+	written, err := WriteTree(path, commit, path, paths)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case rel == ".":
+		case rel == ".git" && info.IsDir():
+			return filepath.SkipDir
+		case !info.IsDir() && !written[filepath.ToSlash(rel)]:
+			stale = append(stale, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list checked out files")
+	}
+	for _, p := range stale {
+		if err := os.Remove(p); err != nil {
+			return errors.Wrap(err, "failed to remove file no longer checked out")
+		}
+		// directories left empty go too, up to the root of the checkout
+		for dir := filepath.Dir(p); dir != path && os.Remove(dir) == nil; dir = filepath.Dir(dir) {
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestValidateCheckout(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		err    string
+	}{
+		{"default", Target{Name: "app"}, ""},
+		{"full directory", Target{Name: "app", Checkout: CheckoutFull, Directory: "/srv/app"}, ""},
+		{"sparse", Target{Name: "app", Checkout: CheckoutSparse, Subpath: "deploy"}, ""},
+		{"archive", Target{Name: "app", Checkout: CheckoutArchive}, ""},
+		{"unknown", Target{Name: "app", Checkout: "shallow"}, "target 'app' checkout 'shallow' is not one of full, sparse or bare-archive"},
+		{"sparse without subpath", Target{Name: "app", Checkout: CheckoutSparse}, "target 'app' checkout 'sparse' requires a subpath"},
+		{"archive directory", Target{Name: "app", Checkout: CheckoutArchive, Directory: "/srv/app"}, "target 'app' has a directory of its own and can only be checked out in full"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCheckout(tt.target)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckoutPaths(t *testing.T) {
+	target := Target{
+		Subpath:   "deploy/",
+		Templates: []Template{{Source: "templates/app.tmpl", Output: "deploy/app.yml"}},
+		EnvFile:   []string{"./env/prod.env"},
+	}
+	assert.Nil(t, target.CheckoutPaths())
+
+	target.Checkout = CheckoutSparse
+	assert.Equal(t, []string{"deploy", "templates/app.tmpl", "env/prod.env"}, target.CheckoutPaths())
+
+	assert.Nil(t, (&Target{Checkout: CheckoutArchive}).CheckoutPaths())
+}
+
+func TestSyncTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-sync-tree")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(files map[string]string) string {
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+			require.NoError(t, ioutil.WriteFile(path, []byte(content), 0o644))
+			_, err := wt.Add(name)
+			require.NoError(t, err)
+		}
+		h, err := wt.Commit("commit", &git.CommitOptions{Author: &object.Signature{Name: "pico", When: time.Now()}})
+		require.NoError(t, err)
+		return h.String()
+	}
+	first := commit(map[string]string{"deploy/compose.yml": "v1", "deploy/old/x": "x", "src/main.go": "package main"})
+	_, err = wt.Remove("deploy/old/x")
+	require.NoError(t, err)
+	second := commit(map[string]string{"deploy/compose.yml": "v2"})
+
+	require.NoError(t, SyncTree(dir, first, []string{"deploy"}))
+	assert.FileExists(t, filepath.Join(dir, "deploy", "old", "x"))
+	_, err = os.Stat(filepath.Join(dir, "src"))
+	assert.True(t, os.IsNotExist(err), "files outside the paths are removed")
+
+	require.NoError(t, SyncTree(dir, second, []string{"deploy"}))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "deploy", "compose.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(b))
+	_, err = os.Stat(filepath.Join(dir, "deploy", "old"))
+	assert.True(t, os.IsNotExist(err), "files no longer in the commit are removed with their directories")
+	assert.DirExists(t, filepath.Join(dir, ".git"))
+}
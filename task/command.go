@@ -0,0 +1,125 @@
+package task
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// shells are the programs whose -c argument is a script rather than a program
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "ash": true, "zsh": true, "ksh": true}
+
+// dangerous matches commands that remove the root, home or every directory,
+// such as `rm -rf /`, almost always a variable that expanded to nothing.
+var dangerous = regexp.MustCompile(`(^|[\s;&|(])rm\s+(-[a-zA-Z-]+\s+)*-[a-zA-Z]*[rR][a-zA-Z]*\s+(-[a-zA-Z-]+\s+)*("?(/|/\*|~|~/|\$HOME/?|\*)"?)(\s|;|&|\||\)|$)`)
+
+// commands returns the target's commands by the key they're defined under
+func (t Target) commands() []struct {
+	key     string
+	command []string
+} {
+	return []struct {
+		key     string
+		command []string
+	}{{"up", t.Up}, {"down", t.Down}, {"init", t.Init}}
+}
+
+// shellScript returns the script a command runs if it's a shell invoked with
+// -c, or any combined flags with c such as -ec.
+func shellScript(command []string) (string, bool) {
+	if len(command) == 0 || !shells[filepath.Base(command[0])] {
+		return "", false
+	}
+	for i := 1; i < len(command); i++ {
+		arg := command[i]
+		switch {
+		case arg == "-o" || arg == "+o":
+			i++ // the option's name, such as pipefail
+			continue
+		case arg == "-" || arg == "--" || !strings.HasPrefix(arg, "-"):
+			return "", false
+		case strings.HasPrefix(arg, "--"):
+			continue // long options such as --login
+		}
+		if strings.ContainsRune(arg[1:], 'c') {
+			if i+1 < len(command) {
+				return command[i+1], true
+			}
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// validateCommands rejects commands that would do nothing yet succeed, a
+// program that's empty or a shell script that's only whitespace. Commands that
+// are absent aren't run, that up is defined is checked with the configuration.
+func validateCommands(t Target) error {
+	for _, c := range t.commands() {
+		if len(c.command) == 0 {
+			continue
+		}
+		if strings.TrimSpace(c.command[0]) == "" {
+			return errors.Errorf("target '%s' %s command %q is empty", t.Name, c.key, c.command)
+		}
+		if script, ok := shellScript(c.command); ok && strings.TrimSpace(script) == "" {
+			return errors.Errorf("target '%s' %s command %q runs an empty shell script", t.Name, c.key, c.command)
+		}
+	}
+	return nil
+}
+
+// CommandWarnings describes the commands of targets that look like mistakes
+// without being invalid, such as one that removes the root directory or a
+// shell script with unbalanced quotes.
+func CommandWarnings(targets []Target) (warnings []string) {
+	for _, t := range targets {
+		for _, c := range t.commands() {
+			if len(c.command) == 0 {
+				continue
+			}
+			script, shell := shellScript(c.command)
+			if !shell {
+				script = strings.Join(c.command, " ")
+			}
+			if dangerous.MatchString(script) {
+				warnings = append(warnings, fmt.Sprintf("target '%s' %s command %q looks like it removes everything", t.Name, c.key, c.command))
+			}
+			if shell && !balancedQuotes(script) {
+				warnings = append(warnings, fmt.Sprintf("target '%s' %s command %q has unbalanced quotes", t.Name, c.key, c.command))
+			}
+			if !shell {
+				for _, arg := range c.command {
+					if strings.ContainsAny(arg, "\r\n") {
+						warnings = append(warnings, fmt.Sprintf("target '%s' %s command %q has an argument with a line break", t.Name, c.key, c.command))
+						break
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// balancedQuotes reports whether every quote of a shell script is closed,
+// following the shell's rules for backslashes inside and outside of quotes.
+func balancedQuotes(script string) bool {
+	var quote rune
+	escaped := false
+	for _, r := range script {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+		case r == quote:
+			quote = 0
+		}
+	}
+	return quote == 0
+}
@@ -0,0 +1,62 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		err    string
+	}{
+		{"compose", Target{Name: "app", Up: []string{"docker-compose", "up", "-d"}}, ""},
+		{"absent down", Target{Name: "app", Up: []string{"true"}, Down: []string{}}, ""},
+		{"empty program", Target{Name: "app", Up: []string{""}}, `target 'app' up command [""] is empty`},
+		{"whitespace program", Target{Name: "app", Up: []string{"true"}, Down: []string{" \n"}}, `target 'app' down command [" \n"] is empty`},
+		{"empty script", Target{Name: "app", Up: []string{"sh", "-c", ""}}, `target 'app' up command ["sh" "-c" ""] runs an empty shell script`},
+		{"whitespace script", Target{Name: "app", Up: []string{"true"}, Init: []string{"/bin/bash", "-ec", "  \n"}}, `target 'app' init command ["/bin/bash" "-ec" "  \n"] runs an empty shell script`},
+		{"missing script", Target{Name: "app", Up: []string{"sh", "-o", "pipefail", "-c"}}, `target 'app' up command ["sh" "-o" "pipefail" "-c"] runs an empty shell script`},
+		{"shell file", Target{Name: "app", Up: []string{"sh", "deploy.sh"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTargets([]Target{tt.target})
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCommandWarnings(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		warning string
+	}{
+		{"compose", []string{"docker-compose", "up", "-d"}, ""},
+		{"clean build", []string{"sh", "-c", "rm -rf ./build && make"}, ""},
+		{"quoted", []string{"sh", "-c", `echo "it's fine" && printf '%s\n' "a \"b\""`}, ""},
+		{"root", []string{"rm", "-rf", "/"}, "looks like it removes everything"},
+		{"empty variable", []string{"sh", "-c", "rm -rf $DIR/ ; rm -fr /*"}, "looks like it removes everything"},
+		{"home", []string{"bash", "-c", "cd app && rm -r -f ~"}, "looks like it removes everything"},
+		{"unbalanced", []string{"sh", "-c", `docker-compose up -d "web`}, "has unbalanced quotes"},
+		{"line break", []string{"docker-compose", "up\n", "-d"}, "has an argument with a line break"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := CommandWarnings([]Target{{Name: "app", Up: tt.command}})
+			if tt.warning == "" {
+				assert.Empty(t, warnings)
+			} else if assert.Len(t, warnings, 1) {
+				assert.Contains(t, warnings[0], "target 'app' up command")
+				assert.Contains(t, warnings[0], tt.warning)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package task
 
-import "reflect"
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
 
 // DiffTargets returns just the additions (also changes) and removals between
 // the specified old targets and new targets
@@ -28,9 +32,101 @@ func DiffTargets(oldTargets, newTargets []Target) (additions, removals []Target)
 		}
 		if !exists {
 			removals = append(removals, oldTarget)
-		} else if !reflect.DeepEqual(oldTarget, newTarget) {
+		} else if len(changedFields(oldTarget, newTarget)) > 0 {
 			additions = append(additions, newTarget)
 		}
 	}
 	return
 }
+
+// TargetsDiff describes the differences between two sets of targets
+type TargetsDiff struct {
+	Added    []string       `json:"added,omitempty"`
+	Removed  []string       `json:"removed,omitempty"`
+	Modified []TargetChange `json:"modified,omitempty"`
+}
+
+// TargetChange lists the fields of a single target that differ, fields are
+// named by their configuration key and env changes are listed per key as
+// `env.KEY` without their values.
+type TargetChange struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+// Empty returns true if there are no differences
+func (d TargetsDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// CompareTargets builds a structured diff of the targets added, removed and
+// modified between the specified old targets and new targets.
+func CompareTargets(oldTargets, newTargets []Target) (diff TargetsDiff) {
+	old := make(map[string]Target, len(oldTargets))
+	for _, t := range oldTargets {
+		old[t.Name] = t
+	}
+	seen := make(map[string]bool, len(newTargets))
+
+	for _, newTarget := range newTargets {
+		seen[newTarget.Name] = true
+		oldTarget, exists := old[newTarget.Name]
+		if !exists {
+			diff.Added = append(diff.Added, newTarget.Name)
+			continue
+		}
+		if fields := changedFields(oldTarget, newTarget); len(fields) > 0 {
+			diff.Modified = append(diff.Modified, TargetChange{
+				Name:   newTarget.Name,
+				Fields: fields,
+			})
+		}
+	}
+	for _, oldTarget := range oldTargets {
+		if !seen[oldTarget.Name] {
+			diff.Removed = append(diff.Removed, oldTarget.Name)
+		}
+	}
+	return
+}
+
+func changedFields(a, b Target) (fields []string) {
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		name := strings.Split(va.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = strings.ToLower(va.Type().Field(i).Name)
+		}
+		if name == "env" {
+			fields = append(fields, changedKeys(a.Env, b.Env)...)
+			continue
+		}
+		// the same repository written differently is not a change
+		if name == "url" {
+			if !SameRepo(a.RepoURL, b.RepoURL) {
+				fields = append(fields, name)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, name)
+		}
+	}
+	return
+}
+
+func changedKeys(a, b map[string]string) (keys []string) {
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			keys = append(keys, "env."+k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, "env."+k)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
@@ -117,6 +117,18 @@ func Test_DiffTargets(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			args{
+				oldTargets: []Target{
+					{Name: "one", RepoURL: "https://github.com/org/one.git"},
+				},
+				newTargets: []Target{
+					{Name: "one", RepoURL: "https://GitHub.com/org/one/"},
+				},
+			},
+			nil,
+			nil,
+		},
 	}
 	for ii, tt := range tests {
 		t.Run(fmt.Sprint(ii), func(t *testing.T) {
@@ -126,3 +138,30 @@ func Test_DiffTargets(t *testing.T) {
 		})
 	}
 }
+
+func TestCompareTargets(t *testing.T) {
+	diff := CompareTargets([]Target{
+		{Name: "one", RepoURL: "https://git/one", Env: map[string]string{"A": "1", "B": "2"}},
+		{Name: "two", RepoURL: "https://git/two"},
+		{Name: "three", RepoURL: "https://git/three"},
+	}, []Target{
+		{Name: "one", RepoURL: "https://git/one", Env: map[string]string{"A": "changed", "C": "3"}},
+		{Name: "two", RepoURL: "https://git/moved", Branch: "dev", Up: []string{"make"}},
+		{Name: "four", RepoURL: "https://git/four"},
+	})
+
+	assert.Equal(t, TargetsDiff{
+		Added:   []string{"four"},
+		Removed: []string{"three"},
+		Modified: []TargetChange{
+			{Name: "one", Fields: []string{"env.A", "env.B", "env.C"}},
+			{Name: "two", Fields: []string{"url", "branch", "up"}},
+		},
+	}, diff)
+	assert.False(t, diff.Empty())
+	assert.True(t, CompareTargets([]Target{{Name: "one"}}, []Target{{Name: "one"}}).Empty())
+	assert.True(t, CompareTargets(
+		[]Target{{Name: "one", RepoURL: "git@GitHub.com:org/one.git"}},
+		[]Target{{Name: "one", RepoURL: "https://github.com/org/one"}},
+	).Empty())
+}
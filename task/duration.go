@@ -0,0 +1,41 @@
+package task
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Duration is a time.Duration that's written in configuration as a string,
+// such as "30s", or as a number of seconds.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid duration '%s'", value)
+		}
+		*d = Duration(parsed)
+	default:
+		return errors.Errorf("invalid duration %s", string(b))
+	}
+	if *d < 0 {
+		return errors.Errorf("duration %s is negative", string(b))
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package task
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuration(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    Duration
+		wantErr bool
+	}{
+		{`"30s"`, Duration(30 * time.Second), false},
+		{`"1m30s"`, Duration(90 * time.Second), false},
+		{`5`, Duration(5 * time.Second), false},
+		{`0.5`, Duration(500 * time.Millisecond), false},
+		{`"soon"`, 0, true},
+		{`"-1s"`, 0, true},
+		{`true`, 0, true},
+	} {
+		var d Duration
+		err := json.Unmarshal([]byte(tt.in), &d)
+		if tt.wantErr {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, d, tt.in)
+	}
+
+	b, err := json.Marshal(Duration(90 * time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, `"1m30s"`, string(b))
+}
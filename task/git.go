@@ -0,0 +1,119 @@
+package task
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// HeadCommit returns the hash of the commit checked out in the repository at
+// the given path or an empty string if it can't be determined.
+func HeadCommit(path string) string {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return ""
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+	return ref.Hash().String()
+}
+
+// HeadBranch returns the name of the branch checked out in the repository at
+// the given path or an empty string if it can't be determined or the checkout
+// is detached.
+func HeadBranch(path string) string {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return ""
+	}
+	ref, err := repo.Head()
+	if err != nil || !ref.Name().IsBranch() {
+		return ""
+	}
+	return ref.Name().Short()
+}
+
+// CommitAuthor returns the author of a commit in the repository at the given
+// path, as "Name <email>", or an empty string if it can't be determined.
+func CommitAuthor(path, commit string) string {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return ""
+	}
+	c, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return ""
+	}
+	return c.Author.String()
+}
+
+// CommitTime returns when a commit in the repository at the given path was
+// committed, or the zero time if it can't be determined.
+func CommitTime(path, commit string) time.Time {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return time.Time{}
+	}
+	c, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return time.Time{}
+	}
+	return c.Committer.When
+}
+
+// ResolveRevision returns the hash of the commit a revision, such as a branch,
+// tag or `origin/branch`, refers to in the repository at the given path.
+func ResolveRevision(path, rev string) (string, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open repository")
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve revision %s", rev)
+	}
+	return hash.String(), nil
+}
+
+// ChangedWithin reports whether any of the paths, or any file inside them,
+// differs between two commits. Paths are slash separated and relative to the
+// root of the repository at path. An error is returned if either commit can't
+// be read.
+func ChangedWithin(path, from, to string, paths ...string) (bool, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open repository")
+	}
+	trees := make([]*object.Tree, 2)
+	for i, commit := range []string{from, to} {
+		c, err := repo.CommitObject(plumbing.NewHash(commit))
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to read commit %s", commit)
+		}
+		if trees[i], err = c.Tree(); err != nil {
+			return false, errors.Wrapf(err, "failed to read tree of commit %s", commit)
+		}
+	}
+	changes, err := trees[0].Diff(trees[1])
+	if err != nil {
+		return false, errors.Wrap(err, "failed to compare commits")
+	}
+
+	cleaned := make([]string, len(paths))
+	for i, p := range paths {
+		cleaned[i] = strings.Trim(filepath.ToSlash(filepath.Clean(p)), "/")
+	}
+	for _, c := range changes {
+		if (c.From.Name != "" && inPaths(c.From.Name, cleaned)) || (c.To.Name != "" && inPaths(c.To.Name, cleaned)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
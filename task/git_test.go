@@ -0,0 +1,52 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestChangedWithin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-changed")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(file string) string {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0o700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(file+time.Now().String()), 0o600))
+		_, err := wt.Add(file)
+		require.NoError(t, err)
+		hash, err := wt.Commit(file, &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+		require.NoError(t, err)
+		return hash.String()
+	}
+	first := commit("deployments/edge/docker-compose.yml")
+	second := commit("deployments/edge-other/docker-compose.yml")
+	third := commit("deployments/edge/.env")
+
+	changed, err := ChangedWithin(dir, first, second, "deployments/edge")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = ChangedWithin(dir, first, third, "deployments/edge/")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = ChangedWithin(dir, second, third, "./deployments")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	_, err = ChangedWithin(dir, first, "0000000000000000000000000000000000000000", "deployments")
+	assert.Error(t, err)
+}
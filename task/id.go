@@ -0,0 +1,20 @@
+package task
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// NewTaskID returns a random ID for a new execution task, it's attached to the
+// log lines, history record and notifications of the task so they can be told
+// apart from those of other tasks.
+func NewTaskID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// unique enough for telling tasks apart in logs
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
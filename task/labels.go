@@ -0,0 +1,48 @@
+package task
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NonEmptyLabels returns the target's labels without those that have an empty
+// key or value, or nil if there are none left.
+func (t *Target) NonEmptyLabels() map[string]string {
+	var out map[string]string
+	for k, v := range t.Labels {
+		if k == "" || v == "" {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// LabelsField returns a log field holding the target's labels, or a field that
+// is skipped if the target has none, so it can be added to any log line.
+func (t *Target) LabelsField() zap.Field {
+	labels := t.NonEmptyLabels()
+	if labels == nil {
+		return zap.Skip()
+	}
+	return zap.Object("labels", labelMarshaler(labels))
+}
+
+type labelMarshaler map[string]string
+
+func (l labelMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		enc.AddString(k, l[k])
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"path/filepath"
+)
+
+const (
+	// ConfigDirectory is where configuration repositories are checked out in
+	// the data directory, each in a directory named after the repository.
+	ConfigDirectory = "config"
+	// TargetsDirectory is where targets are cloned in the data directory,
+	// each in the directory named by DirName, unless they set a Directory.
+	TargetsDirectory = "targets"
+	// WorktreesDirectory is where per-task checkouts are created in the data
+	// directory.
+	WorktreesDirectory = ".pico-worktrees"
+)
+
+// Layout derives where everything Pico keeps in its data directory lives, so
+// configuration checkouts and target clones can never collide.
+type Layout struct {
+	Root string
+}
+
+// Config returns the directory configuration repositories are checked out in
+func (l Layout) Config() string {
+	return filepath.Join(l.Root, ConfigDirectory)
+}
+
+// Targets returns the directory targets are cloned in
+func (l Layout) Targets() string {
+	return filepath.Join(l.Root, TargetsDirectory)
+}
+
+// Target returns the directory of a target's clone
+func (l Layout) Target(t Target) string {
+	return t.Path(l.Targets())
+}
+
+// Worktrees returns the directory per-task checkouts are created in
+func (l Layout) Worktrees() string {
+	return filepath.Join(l.Root, WorktreesDirectory)
+}
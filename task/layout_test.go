@@ -0,0 +1,31 @@
+package task
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayout(t *testing.T) {
+	l := Layout{Root: "/var/lib/pico"}
+
+	assert.Equal(t, filepath.FromSlash("/var/lib/pico/config"), l.Config())
+	assert.Equal(t, filepath.FromSlash("/var/lib/pico/targets"), l.Targets())
+	assert.Equal(t, filepath.FromSlash("/var/lib/pico/.pico-worktrees"), l.Worktrees())
+
+	tests := []struct {
+		target Target
+		want   string
+	}{
+		{Target{Name: "app"}, "/var/lib/pico/targets/app"},
+		{Target{Name: "app", Branch: "dev"}, "/var/lib/pico/targets/app_dev"},
+		{Target{Name: "config"}, "/var/lib/pico/targets/config"},
+		{Target{Name: "app", Directory: "/srv/app/"}, "/srv/app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target.Name, func(t *testing.T) {
+			assert.Equal(t, filepath.FromSlash(tt.want), l.Target(tt.target))
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package task
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Output captures the output of a command up to a limit. Beyond the limit, the
+// first and last halves are kept and the rest is only counted, so a command
+// that writes a lot can't exhaust memory.
+type Output struct {
+	limit int
+
+	mu    sync.Mutex
+	head  []byte
+	tail  []byte // a ring buffer of the latest output once head is full
+	start int    // index of the oldest byte in tail once it's full
+	total int64
+}
+
+// NewOutput captures up to limit bytes of output
+func NewOutput(limit int) *Output {
+	return &Output{limit: limit}
+}
+
+// Write implements io.Writer, it never fails
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.total += int64(len(p))
+	rest := p
+	headSize := o.limit - o.limit/2
+	if n := headSize - len(o.head); n > 0 {
+		if n > len(rest) {
+			n = len(rest)
+		}
+		o.head = append(o.head, rest[:n]...)
+		rest = rest[n:]
+	}
+
+	tailSize := o.limit / 2
+	if tailSize == 0 {
+		return len(p), nil
+	}
+	if len(rest) > tailSize {
+		rest = rest[len(rest)-tailSize:]
+	}
+	for len(rest) > 0 {
+		if len(o.tail) < tailSize {
+			n := tailSize - len(o.tail)
+			if n > len(rest) {
+				n = len(rest)
+			}
+			o.tail = append(o.tail, rest[:n]...)
+			rest = rest[n:]
+			continue
+		}
+		n := copy(o.tail[o.start:], rest)
+		o.start = (o.start + n) % tailSize
+		rest = rest[n:]
+	}
+	return len(p), nil
+}
+
+// Total returns the number of bytes written, including those not kept
+func (o *Output) Total() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.total
+}
+
+// String returns the kept output with a marker in place of the dropped part
+func (o *Output) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	tail := append(append([]byte(nil), o.tail[o.start:]...), o.tail[:o.start]...)
+	dropped := o.total - int64(len(o.head)) - int64(len(tail))
+	if dropped <= 0 {
+		return string(o.head) + string(tail)
+	}
+	return fmt.Sprintf("%s\n[... %d bytes truncated ...]\n%s", o.head, dropped, tail)
+}
@@ -0,0 +1,40 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int
+		writes []string
+		want   string
+	}{
+		{"empty", 10, nil, ""},
+		{"under", 10, []string{"abc", "def"}, "abcdef"},
+		{"exact", 10, []string{"0123456789"}, "0123456789"},
+		{"over", 10, []string{"0123456789", "abcdef"}, "01234\n[... 6 bytes truncated ...]\nbcdef"},
+		{"many writes", 6, []string{"ab", "cd", "ef", "gh", "ij", "kl", "mn"}, "abc\n[... 8 bytes truncated ...]\nlmn"},
+		{"large write", 4, []string{strings.Repeat("x", 100) + "end"}, "xx\n[... 99 bytes truncated ...]\nnd"},
+		{"odd limit", 5, []string{"abcdefgh"}, "abc\n[... 3 bytes truncated ...]\ngh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewOutput(tt.limit)
+			var total int64
+			for _, w := range tt.writes {
+				n, err := fmt.Fprint(o, w)
+				assert.NoError(t, err)
+				assert.Equal(t, len(w), n)
+				total += int64(len(w))
+			}
+			assert.Equal(t, tt.want, o.String())
+			assert.Equal(t, total, o.Total())
+		})
+	}
+}
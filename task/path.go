@@ -0,0 +1,259 @@
+package task
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxDirName is the longest directory name derived for a target, well below
+// the common 255 byte limit so the directory can be nested and suffixed.
+const maxDirName = 128
+
+// DirName returns the name of the directory, relative to the data directory,
+// that the target's repository is cloned to. Characters outside of a safe set
+// are replaced and overly long names are truncated with a hash suffix so the
+// result is always a single, portable path component.
+func (t *Target) DirName() string {
+	name := t.Name
+	if t.Branch != "" {
+		name = fmt.Sprintf("%s_%s", t.Name, t.Branch)
+	}
+	return sanitise(name)
+}
+
+// Path returns the directory the target's repository is cloned to and its
+// commands run in, either the target's directory override, the clone it shares
+// with other targets or a directory named after the target under the data
+// directory.
+func (t *Target) Path(dataDir string) string {
+	if t.Directory != "" {
+		return filepath.Clean(t.Directory)
+	}
+	if t.Clone != "" {
+		return filepath.Join(dataDir, t.Clone)
+	}
+	return filepath.Join(dataDir, t.DirName())
+}
+
+// WorkDir returns the directory the target's commands run in for a clone or
+// checkout of its repository at root, the target's subpath inside it if set.
+func (t *Target) WorkDir(root string) string {
+	if t.Subpath == "" {
+		return root
+	}
+	return filepath.Join(root, filepath.FromSlash(t.Subpath))
+}
+
+// sharedClonePrefix starts the names of clones shared by several targets, target
+// names can't start with a dot so they never collide with a target's directory.
+const sharedClonePrefix = ".shared-"
+
+// ShareClones sets the clone of every set of targets that track the same
+// repository and branch with the same auth method, so the repository is cloned
+// and fetched once for all of them. Targets with a directory override or mirrors
+// always have a clone of their own. Disabled targets are included so enabling or
+// disabling one doesn't move the clone of the others.
+func ShareClones(targets []Target) {
+	counts := make(map[string]int)
+	for _, t := range targets {
+		if key, ok := t.shareKey(); ok {
+			counts[key]++
+		}
+	}
+	for i := range targets {
+		targets[i].Clone = ""
+		if key, ok := targets[i].shareKey(); ok && counts[key] > 1 {
+			targets[i].Clone = sanitise(sharedClonePrefix + key)
+		}
+	}
+}
+
+func (t *Target) shareKey() (string, bool) {
+	if t.Directory != "" || len(t.Mirrors) > 0 || t.Checkout == CheckoutSparse {
+		return "", false
+	}
+	key := NormaliseRepo(t.RepoURL)
+	if t.Branch != "" {
+		key += "@" + t.Branch
+	}
+	if t.Auth != "" {
+		key += "+" + t.Auth
+	}
+	if t.Checkout == CheckoutArchive {
+		key += "#bare" // a bare clone can't be shared with working trees
+	}
+	return key, true
+}
+
+// ValidateName checks that a target name can't be used to escape the data
+// directory, names must not be empty, contain path separators or start with
+// a dot.
+func ValidateName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("target name is empty")
+	case strings.ContainsAny(name, `/\`+"\x00"):
+		return errors.Errorf("target name '%s' contains a path separator", name)
+	case strings.HasPrefix(name, "."):
+		return errors.Errorf("target name '%s' must not start with a dot", name)
+	}
+	return nil
+}
+
+// groupName is the format of group names, which appear in admin API paths, and
+// of mutex names
+var groupName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateTargets checks every target name and ensures no two targets share a
+// directory after their names have been sanitised. Directories are compared
+// case-insensitively since the data directory may be on a case-insensitive
+// filesystem. Directory overrides must be absolute, they're checked for overlap
+// by ValidateDirectories. Dependencies must name other targets and must not
+// form a cycle.
+func ValidateTargets(targets []Target) error {
+	dirs := make(map[string]string)
+	for _, t := range targets {
+		if err := ValidateName(t.Name); err != nil {
+			return err
+		}
+		if err := validateCommands(t); err != nil {
+			return err
+		}
+		if err := validateCheckout(t); err != nil {
+			return err
+		}
+		if err := validateResults(t); err != nil {
+			return err
+		}
+		for _, tpl := range t.Templates {
+			for _, p := range []string{tpl.Source, tpl.Output} {
+				if !Contained(p) {
+					return errors.Errorf("target '%s' template path '%s' is not a relative path inside the repository", t.Name, p)
+				}
+			}
+		}
+		for _, p := range t.EnvFile {
+			if !Contained(p) {
+				return errors.Errorf("target '%s' env file path '%s' is not a relative path inside the repository", t.Name, p)
+			}
+		}
+		if t.Subpath != "" && !Contained(t.Subpath) {
+			return errors.Errorf("target '%s' subpath '%s' is not a relative path inside the repository", t.Name, t.Subpath)
+		}
+		if t.Group != "" && !groupName.MatchString(t.Group) {
+			return errors.Errorf("target '%s' group '%s' may only contain letters, digits, '_', '.' and '-'", t.Name, t.Group)
+		}
+		if t.Mutex != "" && !groupName.MatchString(t.Mutex) {
+			return errors.Errorf("target '%s' mutex '%s' may only contain letters, digits, '_', '.' and '-'", t.Name, t.Mutex)
+		}
+		for _, p := range append(append([]string(nil), t.AllowedAuthors...), t.AllowedCommitters...) {
+			if _, err := path.Match(p, ""); err != nil {
+				return errors.Errorf("target '%s' author pattern '%s' is invalid", t.Name, p)
+			}
+		}
+		if t.Directory != "" {
+			if !filepath.IsAbs(t.Directory) {
+				return errors.Errorf("target '%s' directory '%s' is not an absolute path", t.Name, t.Directory)
+			}
+			continue
+		}
+		dir := strings.ToLower(t.DirName())
+		if other, ok := dirs[dir]; ok {
+			return errors.Errorf("targets '%s' and '%s' both use the directory '%s'", other, t.Name, t.DirName())
+		}
+		dirs[dir] = t.Name
+	}
+	return validateDependencies(targets)
+}
+
+// Contained reports whether a path is relative and stays inside the directory
+// it's relative to.
+func Contained(path string) bool {
+	if path == "" || filepath.IsAbs(path) {
+		return false
+	}
+	clean := filepath.Clean(path)
+	return clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+// ValidateDirectories ensures that no target's directory is nested inside, or
+// contains, another target's directory or any of the reserved directories, such
+// as configuration repository checkouts. Targets sharing a clone may share it.
+func ValidateDirectories(targets []Target, dataDir string, reserved ...string) error {
+	paths := make([]string, len(targets))
+	for i, t := range targets {
+		p, err := filepath.Abs(t.Path(dataDir))
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve directory of target '%s'", t.Name)
+		}
+		paths[i] = p
+	}
+	checkouts := make([]string, len(reserved))
+	for i, r := range reserved {
+		p, err := filepath.Abs(r)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve directory '%s'", r)
+		}
+		checkouts[i] = p
+	}
+
+	for i, t := range targets {
+		for j := i + 1; j < len(targets); j++ {
+			if t.Clone != "" && t.Clone == targets[j].Clone {
+				continue
+			}
+			if overlaps(paths[i], paths[j]) {
+				return errors.Errorf("targets '%s' and '%s' have overlapping directories '%s' and '%s'",
+					t.Name, targets[j].Name, paths[i], paths[j])
+			}
+		}
+		for _, r := range checkouts {
+			if overlaps(paths[i], r) {
+				return errors.Errorf("target '%s' directory '%s' overlaps the configuration checkout '%s'",
+					t.Name, paths[i], r)
+			}
+		}
+	}
+	return nil
+}
+
+// overlaps reports whether a and b are the same directory or one contains the
+// other. Both paths must be absolute and clean.
+func overlaps(a, b string) bool {
+	return within(a, b) || within(b, a)
+}
+
+// within reports whether path is dir or is inside of it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func sanitise(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') ||
+			r == '-' || r == '_' || r == '.' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	out := b.String()
+
+	if len(out) > maxDirName {
+		sum := sha1.Sum([]byte(name))
+		out = out[:maxDirName-9] + "-" + hex.EncodeToString(sum[:])[:8]
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package task
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirName(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		want   string
+	}{
+		{"simple", Target{Name: "app"}, "app"},
+		{"branch", Target{Name: "app", Branch: "dev"}, "app_dev"},
+		{"branchslash", Target{Name: "app", Branch: "feature/x"}, "app_feature_x"},
+		{"spaces", Target{Name: "my app"}, "my_app"},
+		{"unicode", Target{Name: "café"}, "caf_"},
+		{"traversal", Target{Name: "../../etc"}, ".._.._etc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.target.DirName())
+		})
+	}
+}
+
+func TestDirNameLong(t *testing.T) {
+	a := Target{Name: strings.Repeat("a", 300)}
+	b := Target{Name: strings.Repeat("a", 299) + "b"}
+
+	assert.Len(t, a.DirName(), maxDirName)
+	assert.Len(t, b.DirName(), maxDirName)
+	assert.NotEqual(t, a.DirName(), b.DirName())
+}
+
+func TestValidateTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []Target
+		wantErr string
+	}{
+		{"valid", []Target{{Name: "one"}, {Name: "two"}, {Name: "one", Branch: "dev"}}, ""},
+		{"empty", []Target{{Name: ""}}, "target name is empty"},
+		{"traversal", []Target{{Name: "../../etc"}}, "target name '../../etc' contains a path separator"},
+		{"backslash", []Target{{Name: `..\etc`}}, `target name '..\etc' contains a path separator`},
+		{"dot", []Target{{Name: ".."}}, "target name '..' must not start with a dot"},
+		{"hidden", []Target{{Name: ".git"}}, "target name '.git' must not start with a dot"},
+		{"unicode", []Target{{Name: "café"}, {Name: "cafè"}}, "targets 'café' and 'cafè' both use the directory 'caf_'"},
+		{"case", []Target{{Name: "App"}, {Name: "app"}}, "targets 'App' and 'app' both use the directory 'app'"},
+		{"sanitised", []Target{{Name: "my app"}, {Name: "my_app"}}, "targets 'my app' and 'my_app' both use the directory 'my_app'"},
+		{"branch", []Target{{Name: "app_dev"}, {Name: "app", Branch: "dev"}}, "targets 'app_dev' and 'app' both use the directory 'app_dev'"},
+		{"long", []Target{{Name: strings.Repeat("x", 1000)}, {Name: strings.Repeat("x", 999) + "y"}}, ""},
+		{"group", []Target{{Name: "one", Group: "apps"}, {Name: "two", Group: "ingress-v2"}}, ""},
+		{"group path", []Target{{Name: "one", Group: "apps/prod"}}, "target 'one' group 'apps/prod' may only contain letters, digits, '_', '.' and '-'"},
+		{"mutex", []Target{{Name: "one", Mutex: "db"}, {Name: "two", Mutex: "db"}}, ""},
+		{"mutex space", []Target{{Name: "one", Mutex: "shared db"}}, "target 'one' mutex 'shared db' may only contain letters, digits, '_', '.' and '-'"},
+		{"template", []Target{{Name: "one", Templates: []Template{{Source: "env.tmpl", Output: "config/.env"}}}}, ""},
+		{"template absolute", []Target{{Name: "one", Templates: []Template{{Source: "env.tmpl", Output: "/etc/passwd"}}}}, "target 'one' template path '/etc/passwd' is not a relative path inside the repository"},
+		{"template escape", []Target{{Name: "one", Templates: []Template{{Source: "a/../../env.tmpl", Output: ".env"}}}}, "target 'one' template path 'a/../../env.tmpl' is not a relative path inside the repository"},
+		{"authors", []Target{{Name: "one", AllowedAuthors: []string{"*@example.com"}, AllowedCommitters: []string{"deploy-bot"}}}, ""},
+		{"authors pattern", []Target{{Name: "one", AllowedCommitters: []string{"[bot"}}}, "target 'one' author pattern '[bot' is invalid"},
+		{"template empty", []Target{{Name: "one", Templates: []Template{{Source: "env.tmpl"}}}}, "target 'one' template path '' is not a relative path inside the repository"},
+		{"env file escape", []Target{{Name: "one", EnvFile: []string{".env", "../.env"}}}, "target 'one' env file path '../.env' is not a relative path inside the repository"},
+		{"subpath", []Target{{Name: "one", Subpath: "deployments/edge"}}, ""},
+		{"subpath escape", []Target{{Name: "one", Subpath: "deployments/../../edge"}}, "target 'one' subpath 'deployments/../../edge' is not a relative path inside the repository"},
+		{"subpath absolute", []Target{{Name: "one", Subpath: "/srv/edge"}}, "target 'one' subpath '/srv/edge' is not a relative path inside the repository"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTargets(tt.targets)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTargetsDirectory(t *testing.T) {
+	assert.NoError(t, ValidateTargets([]Target{{Name: "app", Directory: "/srv/stacks/app"}, {Name: "other", Directory: "/srv/stacks/other"}}))
+	assert.EqualError(t, ValidateTargets([]Target{{Name: "app", Directory: "stacks/app"}}), "target 'app' directory 'stacks/app' is not an absolute path")
+}
+
+func TestWorkDir(t *testing.T) {
+	target := Target{Name: "edge"}
+	assert.Equal(t, "/data/edge", target.WorkDir("/data/edge"))
+	target.Subpath = "deployments/edge/"
+	assert.Equal(t, filepath.Join("/data/edge", "deployments", "edge"), target.WorkDir("/data/edge"))
+}
+
+func TestShareClones(t *testing.T) {
+	targets := []Target{
+		{Name: "web", RepoURL: "https://github.com/org/mono.git"},
+		{Name: "worker", RepoURL: "git@github.com:org/mono"},
+		{Name: "edge", RepoURL: "https://github.com/org/mono", Branch: "edge"},
+		{Name: "mirrored", RepoURL: "https://github.com/org/mono", Mirrors: []string{"https://mirror/org/mono"}},
+		{Name: "pinned", RepoURL: "https://github.com/org/mono", Directory: "/srv/pinned"},
+		{Name: "alone", RepoURL: "https://github.com/org/other", Clone: ".shared-stale"},
+	}
+	ShareClones(targets)
+
+	assert.Equal(t, ".shared-github.com_org_mono", targets[0].Clone)
+	assert.Equal(t, targets[0].Clone, targets[1].Clone)
+	assert.Equal(t, "/data/.shared-github.com_org_mono", targets[1].Path("/data"))
+	for _, tt := range targets[2:] {
+		assert.Empty(t, tt.Clone, tt.Name)
+	}
+	assert.Equal(t, "/data/edge_edge", targets[2].Path("/data"))
+}
+
+func TestValidateDirectories(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []Target
+		wantErr string
+	}{
+		{"defaults", []Target{{Name: "one"}, {Name: "two"}}, ""},
+		{"override", []Target{{Name: "one"}, {Name: "two", Directory: "/srv/stacks/two"}}, ""},
+		{"siblings", []Target{{Name: "one", Directory: "/srv/stacks/one"}, {Name: "onetwo", Directory: "/srv/stacks/onetwo"}}, ""},
+		{"same", []Target{{Name: "one", Directory: "/srv/app"}, {Name: "two", Directory: "/srv/app/"}}, "targets 'one' and 'two' have overlapping directories '/srv/app' and '/srv/app'"},
+		{"nested", []Target{{Name: "one", Directory: "/srv/app"}, {Name: "two", Directory: "/srv/app/two"}}, "targets 'one' and 'two' have overlapping directories '/srv/app' and '/srv/app/two'"},
+		{"parent", []Target{{Name: "one", Directory: "/srv/app/one"}, {Name: "two", Directory: "/srv"}}, "targets 'one' and 'two' have overlapping directories '/srv/app/one' and '/srv'"},
+		{"default", []Target{{Name: "one"}, {Name: "two", Directory: "/data/one/sub"}}, "targets 'one' and 'two' have overlapping directories '/data/one' and '/data/one/sub'"},
+		{"datadir", []Target{{Name: "one", Directory: "/data"}}, "target 'one' directory '/data' overlaps the configuration checkout '/data/config'"},
+		{"config", []Target{{Name: "one", Directory: "/data/config/one"}}, "target 'one' directory '/data/config/one' overlaps the configuration checkout '/data/config'"},
+		{"shared", []Target{{Name: "one", Clone: ".shared-repo"}, {Name: "two", Clone: ".shared-repo"}}, ""},
+		{"shared nested", []Target{{Name: "one", Clone: ".shared-repo"}, {Name: "two", Directory: "/data/.shared-repo/two"}}, "targets 'one' and 'two' have overlapping directories '/data/.shared-repo' and '/data/.shared-repo/two'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateDirectories(tt.targets, "/data", "/data/config")
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package task
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Plan is the batch of tasks queued for every target after a cold start. The
+// executor runs them in the order of Targets, which is StartupOrder, starting
+// each only once the targets it depends on have been deployed.
+type Plan struct {
+	Targets []string
+}
+
+// Index returns the position of the named target in the plan, or -1 if it's
+// not part of it.
+func (p *Plan) Index(name string) int {
+	for i, n := range p.Targets {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// StartupOrder returns the targets in the order they're deployed after a cold
+// start: every target after the targets it depends on, then targets with a
+// higher priority first, then by name. Dependencies on targets that aren't in
+// the list, such as disabled ones, are ignored.
+func StartupOrder(targets []Target) []Target {
+	included := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		included[t.Name] = true
+	}
+
+	remaining := append([]Target(nil), targets...)
+	sort.SliceStable(remaining, func(i, j int) bool {
+		if remaining[i].Priority != remaining[j].Priority {
+			return remaining[i].Priority > remaining[j].Priority
+		}
+		return remaining[i].Name < remaining[j].Name
+	})
+
+	ordered := make([]Target, 0, len(targets))
+	placed := make(map[string]bool, len(targets))
+	for len(remaining) > 0 {
+		next := -1
+		for i, t := range remaining {
+			if dependenciesPlaced(t, included, placed) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			// only possible with a cycle, which ValidateTargets rejects, the
+			// rest are kept in priority order rather than dropped.
+			next = 0
+		}
+		ordered = append(ordered, remaining[next])
+		placed[remaining[next].Name] = true
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return ordered
+}
+
+func dependenciesPlaced(t Target, included, placed map[string]bool) bool {
+	for _, d := range t.DependsOn {
+		if included[d] && !placed[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// validateDependencies ensures targets only depend on targets that exist and
+// that no target depends on itself, directly or through others.
+func validateDependencies(targets []Target) error {
+	byName := make(map[string]Target, len(targets))
+	for _, t := range targets {
+		byName[t.Name] = t
+	}
+	for _, t := range targets {
+		for _, d := range t.DependsOn {
+			if _, ok := byName[d]; !ok {
+				return errors.Errorf("target '%s' depends on unknown target '%s'", t.Name, d)
+			}
+		}
+	}
+
+	// depth first search, a target seen again while it's being visited is
+	// part of a cycle.
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int, len(targets))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch marks[name] {
+		case visiting:
+			return errors.Errorf("targets depend on each other: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		marks[name] = visiting
+		for _, d := range byName[name].DependsOn {
+			if err := visit(d, append(path, name)); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+		return nil
+	}
+	for _, t := range targets {
+		if err := visit(t.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
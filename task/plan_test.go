@@ -0,0 +1,66 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartupOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []Target
+		want    []string
+	}{
+		{"name", []Target{{Name: "b"}, {Name: "c"}, {Name: "a"}}, []string{"a", "b", "c"}},
+		{"priority", []Target{{Name: "a"}, {Name: "proxy", Priority: 10}, {Name: "db", Priority: 5}}, []string{"proxy", "db", "a"}},
+		{"dependencies", []Target{
+			{Name: "app", Priority: 10, DependsOn: []string{"network"}},
+			{Name: "network"},
+			{Name: "worker", DependsOn: []string{"app"}},
+			{Name: "alpha"},
+		}, []string{"alpha", "network", "app", "worker"}},
+		{"dependency before priority", []Target{
+			{Name: "app", Priority: 10, DependsOn: []string{"db"}},
+			{Name: "db", Priority: -1},
+			{Name: "cache", Priority: 5},
+		}, []string{"cache", "db", "app"}},
+		{"missing dependency", []Target{{Name: "app", DependsOn: []string{"disabled"}}}, []string{"app"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, target := range StartupOrder(tt.targets) {
+				got = append(got, target.Name)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []Target
+		wantErr string
+	}{
+		{"valid", []Target{{Name: "a", DependsOn: []string{"b"}}, {Name: "b"}}, ""},
+		{"unknown", []Target{{Name: "a", DependsOn: []string{"b"}}}, "target 'a' depends on unknown target 'b'"},
+		{"self", []Target{{Name: "a", DependsOn: []string{"a"}}}, "targets depend on each other: a -> a"},
+		{"cycle", []Target{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"c"}},
+			{Name: "c", DependsOn: []string{"a"}},
+		}, "targets depend on each other: a -> b -> c -> a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTargets(tt.targets)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
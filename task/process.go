@@ -0,0 +1,121 @@
+package task
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// GracePeriod is how long the processes of a command are given to exit after
+// SIGTERM before they're killed.
+var GracePeriod = 10 * time.Second
+
+// reapTimeout bounds the wait for killed processes to disappear
+const reapTimeout = 5 * time.Second
+
+// Run starts the command in its own process group and waits for it to exit.
+// If ctx is done first, the whole group is sent SIGTERM and, after the grace
+// period, SIGKILL. Processes the command left behind in its group, such as
+// backgrounded jobs, are stopped the same way before run returns. Output is
+// copied to out, if set, as well as the command's configured stdout.
+func Run(ctx context.Context, cmd *exec.Cmd, out io.Writer) (err error) {
+	setProcessGroup(cmd)
+
+	// the pipe is created here rather than by exec, as Wait would otherwise
+	// block until processes left behind close their copy of it.
+	var pipe *os.File
+	copied := make(chan struct{})
+	if out != nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return errors.Wrap(err, "failed to create output pipe")
+		}
+		defer r.Close()
+		dst := out
+		if cmd.Stdout != nil {
+			dst = io.MultiWriter(cmd.Stdout, out)
+		}
+		cmd.Stdout, cmd.Stderr = w, w
+		pipe = w
+		go func() {
+			io.Copy(dst, r) //nolint:errcheck
+			close(copied)
+		}()
+	} else {
+		close(copied)
+	}
+
+	err = cmd.Start()
+	if pipe != nil {
+		pipe.Close() //nolint:errcheck
+	}
+	if err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		zap.L().Info("stopping command", zap.Int("pid", pid), zap.Strings("args", cmd.Args))
+		signalGroup(cmd, terminate)
+		select {
+		case err = <-done:
+		case <-time.After(GracePeriod):
+			zap.L().Warn("command did not exit after SIGTERM, killing it", zap.Int("pid", pid))
+			signalGroup(cmd, kill)
+			err = <-done
+		}
+		err = errors.Wrapf(ctx.Err(), "command stopped (%v)", err)
+	}
+
+	// only once the command itself has been waited for, so reaping its group
+	// can't take its exit status from Wait.
+	cleanupGroup(cmd)
+
+	select {
+	case <-copied:
+	case <-time.After(reapTimeout):
+		zap.L().Warn("output of command still open after it exited", zap.Int("pid", pid))
+	}
+	return err
+}
+
+// cleanupGroup stops any processes left in the command's group and waits for
+// them to disappear.
+func cleanupGroup(cmd *exec.Cmd) {
+	if !groupAlive(cmd) {
+		return
+	}
+	pid := cmd.Process.Pid
+	zap.L().Info("stopping processes left behind by command", zap.Int("pgid", pid))
+	signalGroup(cmd, terminate)
+	if waitGroup(cmd, GracePeriod) {
+		return
+	}
+	zap.L().Warn("processes left behind by command did not exit after SIGTERM, killing them", zap.Int("pgid", pid))
+	signalGroup(cmd, kill)
+	if !waitGroup(cmd, reapTimeout) {
+		zap.L().Error("processes left behind by command are still running", zap.Int("pgid", pid))
+	}
+}
+
+// waitGroup reports whether the group disappeared within the timeout
+func waitGroup(cmd *exec.Cmd, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for groupAlive(cmd) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+//go:build !windows
+// +build !windows
+
+package task
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// running reports whether the process exists and hasn't exited
+func running(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+func readPID(t *testing.T, path string) int {
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	require.NoError(t, err)
+	return pid
+}
+
+func TestExecuteCleansUpOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-process")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the backgrounded sleep holds on to the output, which must not block
+	target := Target{Up: []string{"sh", "-c", "sleep 30 & echo $! > orphan.pid; echo done"}}
+	out := NewOutput(1024)
+	start := time.Now()
+	require.NoError(t, target.ExecuteContext(context.Background(), dir, nil, false, true, out))
+	assert.Equal(t, "done\n", out.String())
+
+	pid := readPID(t, filepath.Join(dir, "orphan.pid"))
+	assert.False(t, running(pid), "backgrounded sleep survived the command")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestExecuteContextEscalates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-process")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	grace := GracePeriod
+	GracePeriod = 200 * time.Millisecond
+	defer func() { GracePeriod = grace }()
+
+	// the shell and its background job both ignore SIGTERM
+	target := Target{Up: []string{"sh", "-c", "trap '' TERM; sleep 30 & echo $! > orphan.pid; wait"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	err = target.ExecuteContext(ctx, dir, nil, false, true, nil)
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	pid := readPID(t, filepath.Join(dir, "orphan.pid"))
+	assert.False(t, running(pid), "backgrounded sleep survived cancellation")
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package task
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const (
+	terminate = syscall.SIGTERM
+	kill      = syscall.SIGKILL
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalGroup signals every process in the command's group, its group ID is
+// the command's PID.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) {
+	syscall.Kill(-cmd.Process.Pid, sig) //nolint:errcheck
+}
+
+// groupAlive reports whether any process of the command's group exists. Exited
+// members that were reparented to Pico, such as when it runs as PID 1, are
+// reaped first so they don't count.
+func groupAlive(cmd *exec.Cmd) bool {
+	pgid := cmd.Process.Pid
+	for {
+		pid, err := syscall.Wait4(-pgid, nil, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			break
+		}
+	}
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package task
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Windows has no process groups that can be signalled, so only the command's
+// own process is stopped there.
+var (
+	terminate = os.Kill
+	kill      = os.Kill
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func signalGroup(cmd *exec.Cmd, sig os.Signal) {
+	cmd.Process.Signal(sig) //nolint:errcheck
+}
+
+func groupAlive(cmd *exec.Cmd) bool { return false }
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// the default port of each scheme, omitted from normalised URLs
+var defaultPorts = map[string]string{
+	"ssh":     "22",
+	"git+ssh": "22",
+	"git":     "9418",
+	"http":    "80",
+	"https":   "443",
+}
+
+// scp-like SSH syntax, such as git@github.com:org/repo.git, a single letter
+// before the colon is treated as a Windows drive instead.
+var scpLike = regexp.MustCompile(`^(?:[^@/]+@)?([^@/:]{2,}):(.*)$`)
+
+// NormaliseRepo reduces a repository URL to `host[:port]/path` so URLs that
+// refer to the same repository compare equal. The scheme, user info, default
+// ports, trailing slashes and `.git` suffix are dropped and the host is lower
+// cased, the path keeps its case. Local paths are cleaned and returned as-is.
+func NormaliseRepo(raw string) string {
+	raw = strings.TrimSpace(raw)
+
+	var host, port, path string
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return raw
+		}
+		scheme := strings.ToLower(u.Scheme)
+		if scheme == "file" {
+			return filepath.Clean(u.Path)
+		}
+		host, port, path = u.Hostname(), u.Port(), u.Path
+		if port == defaultPorts[scheme] {
+			port = ""
+		}
+	} else if m := scpLike.FindStringSubmatch(raw); m != nil {
+		host, path = m[1], m[2]
+	} else {
+		return filepath.Clean(raw)
+	}
+
+	host = strings.ToLower(host)
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	path = strings.TrimRight(path, "/")
+	return host + "/" + path
+}
+
+// SameRepo returns true if both URLs refer to the same repository, regardless
+// of how they are written.
+func SameRepo(a, b string) bool {
+	return NormaliseRepo(a) == NormaliseRepo(b)
+}
+
+// Matches returns true if the repository is the one at the given URL.
+func (r Repo) Matches(url string) bool {
+	return SameRepo(r.URL, url)
+}
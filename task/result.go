@@ -0,0 +1,99 @@
+package task
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The results of tasks, as labelled in history, metrics and notifications
+const (
+	ResultStarted = "started"
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	// ResultNoop is a task that succeeded with one of its target's noop exit
+	// codes, it counts as a success other than being labelled separately.
+	ResultNoop = "noop"
+	// ResultSuppressed is a task that was recorded without being executed,
+	// such as while the instance is in standby. It's never notified.
+	ResultSuppressed = "suppressed"
+)
+
+// NotifyRecovery notifies a successful task that follows a failed one, even if
+// successes aren't notified otherwise.
+const NotifyRecovery = "recovery"
+
+var results = []string{ResultStarted, ResultSuccess, ResultFailure, ResultNoop}
+
+// Notifications routes the notifications of a target's tasks, set on the
+// target or on its group for all of its targets without their own.
+type Notifications struct {
+	// The named notifier the target's notifications are delivered to instead
+	// of Pico's notifiers, as declared with N() in the configuration.
+	Channel string `json:"channel,omitempty"`
+
+	// Whether the target's tasks are never notified
+	Mute bool `json:"mute,omitempty"`
+
+	// The results of the target's tasks that are notified, as notify_on of
+	// the target, which it replaces.
+	NotifyOn []string `json:"notify_on,omitempty"`
+}
+
+// IsNoopExit reports whether an exit code of the target's commands means there
+// was nothing to do.
+func (t *Target) IsNoopExit(code int) bool {
+	for _, c := range t.NoopExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldNotify reports whether tasks of the target with the result, or
+// NotifyRecovery, are notified.
+func (t *Target) ShouldNotify(result string) bool {
+	notifyOn := t.NotifyOn
+	if n := t.Notifications; n != nil {
+		if n.Mute {
+			return false
+		}
+		if len(n.NotifyOn) > 0 {
+			notifyOn = n.NotifyOn
+		}
+	}
+	if len(notifyOn) == 0 {
+		return true
+	}
+	for _, r := range notifyOn {
+		if r == result {
+			return true
+		}
+	}
+	return false
+}
+
+// validateResults checks the results a target is notified of and its noop exit
+// codes, which must be ones a command can exit with other than success.
+func validateResults(t Target) error {
+	notifyOn := t.NotifyOn
+	if t.Notifications != nil {
+		notifyOn = append(append([]string(nil), notifyOn...), t.Notifications.NotifyOn...)
+	}
+	for _, r := range notifyOn {
+		valid := r == NotifyRecovery
+		for _, known := range results {
+			valid = valid || r == known
+		}
+		if !valid {
+			return errors.Errorf("target '%s' notify_on '%s' is not one of %s or %s", t.Name, r, strings.Join(results, ", "), NotifyRecovery)
+		}
+	}
+	for _, c := range t.NoopExitCodes {
+		if c < 1 || c > 255 {
+			return errors.Errorf("target '%s' noop exit code %d is not between 1 and 255", t.Name, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateResults(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		err    string
+	}{
+		{"defaults", Target{Name: "app"}, ""},
+		{"failures only", Target{Name: "app", NotifyOn: []string{ResultFailure}, NoopExitCodes: []int{3, 255}}, ""},
+		{"unknown result", Target{Name: "app", NotifyOn: []string{"failed"}}, "target 'app' notify_on 'failed' is not one of started, success, failure, noop or recovery"},
+		{"routed", Target{Name: "app", Notifications: &Notifications{NotifyOn: []string{ResultFailure, NotifyRecovery}}}, ""},
+		{"unknown routed result", Target{Name: "app", Notifications: &Notifications{NotifyOn: []string{"recovered"}}}, "target 'app' notify_on 'recovered' is not one of started, success, failure, noop or recovery"},
+		{"success code", Target{Name: "app", NoopExitCodes: []int{0}}, "target 'app' noop exit code 0 is not between 1 and 255"},
+		{"out of range", Target{Name: "app", NoopExitCodes: []int{256}}, "target 'app' noop exit code 256 is not between 1 and 255"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateResults(tt.target)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestShouldNotify(t *testing.T) {
+	target := Target{NoopExitCodes: []int{3}}
+	assert.True(t, target.ShouldNotify(ResultNoop))
+	assert.True(t, target.IsNoopExit(3))
+	assert.False(t, target.IsNoopExit(1))
+
+	target.NotifyOn = []string{ResultSuccess, ResultFailure}
+	assert.True(t, target.ShouldNotify(ResultFailure))
+	assert.False(t, target.ShouldNotify(ResultNoop))
+	assert.False(t, target.ShouldNotify(ResultStarted))
+
+	// routed notify_on replaces the target's, muting drops everything
+	target.Notifications = &Notifications{NotifyOn: []string{ResultFailure, NotifyRecovery}}
+	assert.False(t, target.ShouldNotify(ResultSuccess))
+	assert.True(t, target.ShouldNotify(NotifyRecovery))
+	target.Notifications.Mute = true
+	assert.False(t, target.ShouldNotify(ResultFailure))
+}
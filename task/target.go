@@ -1,31 +1,54 @@
 package task
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 // ExecutionTask encodes a Target with additional execution-time information.
 type ExecutionTask struct {
+	ID       string // unique to the task, see NewTaskID
 	Target   Target
 	Path     string
+	Commit   string  // the commit checked out at Path when the task was queued
+	Priority int     // queued tasks with a higher priority are executed first
+	Trigger  Trigger // what caused the task to be queued
+	Detail   string  // about the trigger, such as the webhook delivery or the admin user
 	Shutdown bool
 	Env      map[string]string
+	Created  time.Time // when the task was created from its target's definition
+	Plan     *Plan     // the cold start plan the task is part of, if any
 }
 
 // Repo represents a Git repo with credentials
 type Repo struct {
-	URL  string
-	User string
-	Pass string `json:"-"`
+	URL    string
+	Branch string // the branch to check out, the remote's default when empty
+	User   string
+	Pass   string `json:"-"`
+	Token  string `json:"-"` // sent as an `Authorization: token` header
+}
+
+// Template is a file in a target's repository rendered with Go's text/template
+// to an output path, both relative to the target's working directory.
+type Template struct {
+	Source string `json:"source"`
+	Output string `json:"output"`
 }
 
 // Targets is just a list of target objects, to implement the Sort interface
 type Targets []Target
 
+// DefaultShutdownTimeout is how long a target's down command may run for if
+// the target doesn't set a shutdown timeout.
+const DefaultShutdownTimeout = 60 * time.Second
+
 // Target represents a repository and the task to perform when that repository
 // is updated.
 type Target struct {
@@ -38,45 +61,253 @@ type Target struct {
 	// The git branch to use
 	Branch string `json:"branch"`
 
+	// Repository URLs to fall back to, in order, when RepoURL is unreachable.
+	// They must serve the same repository, such as read-only mirrors.
+	Mirrors []string `json:"mirrors,omitempty"`
+
+	// How long to wait after a change is detected before deploying, so a burst
+	// of pushes results in a single deploy of the last commit.
+	Debounce Duration `json:"debounce,omitempty"`
+
+	// The least time after a successful deploy before a change is deployed. A
+	// change detected sooner is held and its newest commit is deployed once
+	// the interval has passed. Triggered deploys are not limited.
+	MinDeployInterval Duration `json:"min_deploy_interval,omitempty"`
+
+	// Run tasks in the clone itself rather than a checkout of the task's
+	// commit when Pico runs with --worktrees, for targets that need a stable
+	// path such as for relative bind mounts or untracked files like .env.
+	InPlace bool `json:"in_place,omitempty"`
+
+	// How the repository is checked out, one of the Checkout constants. A full
+	// checkout, the default, has every file of the repository, the others only
+	// have the files tasks need: the subpath, templates and env files.
+	Checkout string `json:"checkout,omitempty"`
+
+	// How long a clone, fetch or listing of the repository's remote may take
+	// before it's abandoned, overriding Pico's --git-timeout setting when set.
+	GitTimeout Duration `json:"git_timeout,omitempty"`
+
 	// The command to run on each new Git commit
 	Up []string `required:"true" json:"up"`
 
 	// Down specifies the command to run during either a graceful shutdown or when the target is removed
 	Down []string `json:"down"`
 
+	// Init specifies a command run once per host before the target's first up,
+	// such as creating external volumes. Up doesn't run until it succeeds.
+	Init []string `json:"init,omitempty"`
+
+	// How long the down command may run before its processes are killed and
+	// the teardown is abandoned. Defaults to DefaultShutdownTimeout.
+	ShutdownTimeout Duration `json:"shutdown_timeout,omitempty"`
+
+	// Whether files rendered from templates are removed after a down command
+	// that failed or timed out. They're kept by default so the target can be
+	// torn down by hand, they're always removed after a successful teardown.
+	CleanupOnFailedShutdown bool `json:"cleanup_on_failed_shutdown,omitempty"`
+
 	// Environment variables associated with the target - do not store credentials here!
 	Env map[string]string `json:"env"`
 
+	// Files in dotenv syntax in the target's repository, such as an .env used
+	// by docker-compose, read into the command's environment before each
+	// command. Env and secrets take precedence over them, and so do later files
+	// over earlier ones. Every listed file must exist.
+	EnvFile []string `json:"env_file,omitempty"`
+
 	// Whether or not to run `Command` on first run, useful if the command is `docker-compose up`
 	InitialRun bool `json:"initial_run"`
 
 	// Auth method to use from the auth store
 	Auth string `json:"auth"`
+
+	// Renames secrets from the secret store, keyed by the environment variable
+	// name with the secret's key as the value, unmapped secrets are unchanged.
+	SecretMap map[string]string `json:"secret_map,omitempty"`
+
+	// Restricts the secrets passed to the target to these names, after they
+	// have been renamed by SecretMap. All secrets are passed if it's empty.
+	SecretAllowlist []string `json:"secret_allowlist,omitempty"`
+
+	// Environment variables that must be set and non-empty, from secrets or
+	// otherwise, for the target's command to run.
+	RequiredSecrets []string `json:"required_secrets,omitempty"`
+
+	// Credentials issued for every task, keyed by the prefix of the variables
+	// they're passed as. For example DB: vault-dynamic:database/creds/app sets
+	// DB_USERNAME and DB_PASSWORD.
+	DynamicSecrets map[string]string `json:"dynamic_secrets,omitempty"`
+
+	// Files rendered from templates in the repository before each command
+	// runs, such as an .env file combining configuration and secrets.
+	Templates []Template `json:"templates,omitempty"`
+
+	// Labels, such as the owning team or tier, attached to log lines, metrics
+	// and notifications related to the target.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// A shell command run after each of the target's tasks, in addition to
+	// Pico's --notify-command, with the outcome in PICO_* variables.
+	NotifyCommand string `json:"notify_command,omitempty"`
+
+	// The results of the target's tasks that are notified, any of ResultStarted,
+	// ResultSuccess, ResultFailure and ResultNoop. Defaults to all of them.
+	NotifyOn []string `json:"notify_on,omitempty"`
+
+	// Where the target's notifications are delivered and which of them are,
+	// defaulting to those of its group.
+	Notifications *Notifications `json:"notifications,omitempty"`
+
+	// Exit codes of the target's commands that mean there was nothing to do,
+	// such as a deploy script finding everything up to date. Tasks that exit
+	// with one succeed but are recorded with ResultNoop rather than success.
+	NoopExitCodes []int `json:"noop_exit_codes,omitempty"`
+
+	// Tasks of targets with a higher priority are executed before others that
+	// are waiting, such as a proxy that other targets depend on. Defaults to 0.
+	Priority int `json:"priority,omitempty"`
+
+	// Targets that are deployed before this one after a cold start, such as
+	// the one that creates a network the target's containers join.
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// The group the target belongs to, such as `monitoring` or `ingress`, so
+	// related targets can be deployed, paused and notified about together.
+	Group string `json:"group,omitempty"`
+
+	// Targets that share a mutex name never execute at the same time, such as
+	// two stacks that restart the same shared database. Tasks waiting on a
+	// mutex acquire it in the order they were received.
+	Mutex string `json:"mutex,omitempty"`
+
+	// An absolute path to clone the repository to and run commands in, instead
+	// of a directory derived from the name under the data directory.
+	Directory string `json:"directory,omitempty"`
+
+	// A directory inside the repository, such as deployments/edge, that the
+	// target's commands run in instead of the root of its clone. Only changes
+	// to files inside it are deployed automatically.
+	Subpath string `json:"subpath,omitempty"`
+
+	// Who may author and commit the changes that are deployed automatically,
+	// as email addresses or names with glob patterns such as *@example.com.
+	// Other commits are held pending approval until the target is triggered.
+	// Anyone may when empty.
+	AllowedAuthors    []string `json:"allowed_authors,omitempty"`
+	AllowedCommitters []string `json:"allowed_committers,omitempty"`
+
+	// Whether every change is held pending approval until it's approved
+	// through the admin API, for targets that mustn't deploy unattended.
+	ApprovalRequired bool `json:"approval_required,omitempty"`
+
+	// Whether the target is enabled, a disabled target remains in the state
+	// but is neither fetched nor executed. Targets are enabled unless set.
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Whether unused Docker images are pruned after the target is deployed,
+	// overriding Pico's --prune-images setting when set.
+	PruneImages *bool `json:"prune_images,omitempty"`
+
+	// Whether the target's commands inherit Pico's own environment, such as
+	// for a helper that reads AWS_* variables from the host, overriding
+	// Pico's --pass-env setting when set.
+	PassEnvironment *bool `json:"pass_environment,omitempty"`
+
+	// The configuration repository that declared this target, set by the
+	// reconfigurer when multiple configuration sources are merged.
+	Source string `json:"source,omitempty"`
+
+	// The directory, under the data directory, of the clone the target shares
+	// with other targets of the same repository and branch, set by the
+	// reconfigurer with ShareClones.
+	Clone string `json:"clone,omitempty"`
+}
+
+// IsEnabled reports whether the target is enabled
+func (t *Target) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// ShouldPruneImages reports whether images are pruned after the target is
+// deployed, def is used unless the target sets it.
+func (t *Target) ShouldPruneImages(def bool) bool {
+	if t.PruneImages == nil {
+		return def
+	}
+	return *t.PruneImages
+}
+
+// ShouldPassEnvironment reports whether the target's commands inherit Pico's
+// environment, def is used unless the target sets it.
+func (t *Target) ShouldPassEnvironment(def bool) bool {
+	if t.PassEnvironment == nil {
+		return def
+	}
+	return *t.PassEnvironment
+}
+
+// GetGitTimeout returns how long git operations on the target's repository may
+// take, def is used unless the target sets it.
+func (t *Target) GetGitTimeout(def time.Duration) time.Duration {
+	if t.GitTimeout == 0 {
+		return def
+	}
+	return time.Duration(t.GitTimeout)
+}
+
+// GetShutdownTimeout returns how long the down command may run for
+func (t *Target) GetShutdownTimeout() time.Duration {
+	if t.ShutdownTimeout == 0 {
+		return DefaultShutdownTimeout
+	}
+	return time.Duration(t.ShutdownTimeout)
+}
+
+// URLs returns the repository URL followed by its mirrors, in order
+func (t *Target) URLs() []string {
+	return append([]string{t.RepoURL}, t.Mirrors...)
 }
 
 // Execute runs the target's command in the specified directory with the
 // specified environment variables
 func (t *Target) Execute(dir string, env map[string]string, shutdown bool, inheritEnv bool) (err error) {
-	if env == nil {
-		env = make(map[string]string)
-	}
-	for k, v := range t.Env {
-		env[k] = v
-	}
+	return t.ExecuteContext(context.Background(), dir, env, shutdown, inheritEnv, nil)
+}
 
+// ExecuteContext is Execute with the command being stopped when ctx is done.
+// The command runs in its own process group, which is stopped as a whole. If
+// out is set, the command's output is written to it as well as to stdout.
+func (t *Target) ExecuteContext(ctx context.Context, dir string, env map[string]string, shutdown bool, inheritEnv bool, out io.Writer) (err error) {
 	var command []string
 	if shutdown {
 		command = t.Down
 	} else {
 		command = t.Up
 	}
+	return t.run(ctx, command, dir, env, inheritEnv, out)
+}
+
+// InitContext runs the target's init command the same way ExecuteContext runs
+// its up command.
+func (t *Target) InitContext(ctx context.Context, dir string, env map[string]string, inheritEnv bool, out io.Writer) (err error) {
+	return t.run(ctx, t.Init, dir, env, inheritEnv, out)
+}
+
+func (t *Target) run(ctx context.Context, command []string, dir string, env map[string]string, inheritEnv bool, out io.Writer) error {
+	if env == nil {
+		env = make(map[string]string)
+	}
+	for k, v := range t.Env {
+		env[k] = v
+	}
 
 	c, err := prepare(dir, env, command, inheritEnv)
 	if err != nil {
 		return errors.Wrap(err, "failed to prepare command for execution")
 	}
 
-	return c.Run()
+	return Run(ctx, c, out)
 }
 
 func prepare(dir string, env map[string]string, command []string, inheritEnv bool) (cmd *exec.Cmd, err error) {
@@ -3,6 +3,7 @@ package task
 import (
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -29,3 +30,43 @@ func TestPrepareTargetExecution(t *testing.T) {
 	assert.Equal(t, want, got)
 	assert.Equal(t, ".", c.Dir)
 }
+
+func TestNonEmptyLabels(t *testing.T) {
+	assert.Nil(t, (&Target{}).NonEmptyLabels())
+	assert.Nil(t, (&Target{Labels: map[string]string{"team": ""}}).NonEmptyLabels())
+	assert.Equal(t,
+		map[string]string{"team": "payments"},
+		(&Target{Labels: map[string]string{"team": "payments", "tier": "", "": "x"}}).NonEmptyLabels())
+}
+
+func TestShouldPassEnvironment(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		target *bool
+		global bool
+		want   bool
+	}{
+		{"global off", nil, false, false},
+		{"global on", nil, true, true},
+		{"target on", &yes, false, true},
+		{"target off", &no, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := Target{PassEnvironment: tt.target}
+			assert.Equal(t, tt.want, target.ShouldPassEnvironment(tt.global))
+		})
+	}
+}
+
+func TestGetGitTimeout(t *testing.T) {
+	assert.Equal(t, time.Minute, (&Target{}).GetGitTimeout(time.Minute))
+	assert.Equal(t, time.Second, (&Target{GitTimeout: Duration(time.Second)}).GetGitTimeout(time.Minute))
+}
+
+func TestNewTaskID(t *testing.T) {
+	id := NewTaskID()
+	assert.Len(t, id, 16)
+	assert.NotEqual(t, id, NewTaskID())
+}
@@ -0,0 +1,31 @@
+package task
+
+// Trigger describes what caused a task to be queued
+type Trigger string
+
+const (
+	// TriggerChange is a new commit fetched from the target's repository when
+	// it was polled
+	TriggerChange Trigger = "change"
+	// TriggerWebhook is a new commit fetched from the target's repository when
+	// a webhook reported a push to it
+	TriggerWebhook Trigger = "webhook"
+	// TriggerConfig is the target being added, changed or removed by a new
+	// configuration
+	TriggerConfig Trigger = "config"
+	// TriggerStartup is the deploy of every target in the cold start plan
+	TriggerStartup Trigger = "startup"
+	// TriggerRedeploy is a redeploy of the current checkout, such as after
+	// the target's secrets changed
+	TriggerRedeploy Trigger = "redeploy"
+	// TriggerManual is a deploy requested through the admin API
+	TriggerManual Trigger = "manual"
+	// TriggerLeader is the catch up of an instance that became leader
+	TriggerLeader Trigger = "leader"
+	// TriggerActivate is the deploy of every target of a standby instance once
+	// it's activated
+	TriggerActivate Trigger = "activate"
+	// TriggerApproval is the deploy of a commit held pending approval once
+	// it's approved through the admin API
+	TriggerApproval Trigger = "approval"
+)
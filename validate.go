@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/picostack/pico/buildinfo"
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+// validateConfig constructs the state from the configuration files in dir, as
+// evaluated on the given host, and prints the targets it declares. Unlike a
+// running instance, which only warns about them, unknown keys in target
+// definitions are an error. Suspicious commands are warned about by both.
+func validateConfig(dir, hostname string, env []string) error {
+	state, unknown, err := config.ConfigFromDirectory(dir, config.Builtins{
+		Hostname: hostname,
+		Version:  buildinfo.Get().Version,
+		Env:      env,
+	})
+	if err != nil {
+		return err
+	}
+	for _, w := range task.CommandWarnings(state.Targets) {
+		fmt.Printf("warning: %s\n", w)
+	}
+	if len(unknown) > 0 {
+		for _, u := range unknown {
+			fmt.Println(u)
+		}
+		return errors.Errorf("%d unknown keys in target definitions", len(unknown))
+	}
+	fmt.Printf("configuration is valid, %d targets:\n", len(state.Targets))
+	for _, t := range state.Targets {
+		fmt.Printf("  %s\n", t.Name)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// SetApplied sets how the commit a target was last deployed at is found. That
+// commit was approved before, so it isn't held again when it's redeployed or
+// after a restart. It must be called before Start.
+func (w *GitWatcher) SetApplied(f func(target string) string) {
+	w.applied = f
+}
+
+// awaitingApproval reports whether the commit checked out for a target may not
+// be deployed automatically since the target requires approval or its author
+// or committer isn't allowed by the target. If so, it's held pending approval
+// until the target is approved or triggered, a manual trigger is the approval
+// and is never held. A newer commit replaces the one held. The commit the
+// target was last approved or deployed at is never held.
+func (w *GitWatcher) awaitingApproval(t task.Target, path string, trigger task.Trigger) bool {
+	if !t.RequiresApproval() {
+		w.clearApproval(t.Name)
+		return false
+	}
+	commit := task.HeadCommit(path)
+	if trigger == task.TriggerManual || trigger == task.TriggerApproval {
+		w.clearApproval(t.Name)
+		w.approvalMu.Lock()
+		w.approved[t.Name] = commit
+		w.approvalMu.Unlock()
+		return false
+	}
+	err := t.CheckApproval(path, commit)
+	if err == nil || w.wasApproved(t.Name, commit) {
+		w.clearApproval(t.Name)
+		return false
+	}
+
+	w.approvalMu.Lock()
+	previous := w.approval[t.Name]
+	w.approval[t.Name] = commit
+	w.approvalMu.Unlock()
+
+	if previous != commit {
+		zap.L().Warn("holding commit pending approval, approve or trigger the target to deploy it",
+			zap.String("target", t.Name),
+			zap.String("commit", commit),
+			zap.String("trigger", string(trigger)),
+			t.LabelsField(),
+			zap.Error(err))
+	}
+	return true
+}
+
+// Approve deploys the commit of a target held pending approval immediately,
+// on behalf of the approver.
+func (w *GitWatcher) Approve(name, approver string) {
+	w.trigger <- trigger{name, true, task.TriggerApproval, approver}
+}
+
+// wasApproved reports whether the commit is the one the target was last
+// approved or deployed at
+func (w *GitWatcher) wasApproved(name, commit string) bool {
+	if commit == "" {
+		return false
+	}
+	w.approvalMu.Lock()
+	approved := w.approved[name]
+	w.approvalMu.Unlock()
+	return commit == approved || (w.applied != nil && commit == w.applied(name))
+}
+
+func (w *GitWatcher) clearApproval(name string) {
+	w.approvalMu.Lock()
+	delete(w.approval, name)
+	w.approvalMu.Unlock()
+}
+
+// PendingApproval returns the commits of targets that are held until they're
+// approved or triggered, since they require approval or their author or
+// committer isn't allowed to auto-deploy.
+func (w *GitWatcher) PendingApproval() map[string]string {
+	w.approvalMu.Lock()
+	defer w.approvalMu.Unlock()
+
+	out := make(map[string]string, len(w.approval))
+	for name, commit := range w.approval {
+		out[name] = commit
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Southclaws/gitwatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func TestPendingApproval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-approval")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(author string) string {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte(author), 0600))
+		_, err := wt.Add("README")
+		require.NoError(t, err)
+		hash, err := wt.Commit(author, &git.CommitOptions{
+			Author: &object.Signature{Name: author, Email: author + "@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		return hash.String()
+	}
+
+	b := make(chan task.ExecutionTask, 16)
+	gw := NewGitWatcher(".test", b, time.Second, nil)
+	gw.initialised = true
+	gw.state = config.State{Targets: []task.Target{
+		{Name: "app", Directory: dir, AllowedAuthors: []string{"deploy-bot"}},
+	}}
+	fetched := func() {
+		gw.handleCheck(check{target: "app", time: time.Now(), event: &gitwatch.Event{Path: dir}, cause: task.TriggerChange})
+	}
+
+	// a direct push is held until the target is triggered
+	pushed := commit("alice")
+	fetched()
+	assert.Empty(t, b)
+	assert.Equal(t, map[string]string{"app": pushed}, gw.PendingApproval())
+
+	gw.executeTargets(gw.state.Targets, false)
+	assert.Empty(t, b, "a new configuration doesn't deploy a held commit")
+
+	gw.doTrigger(trigger{name: "app", immediate: true, cause: task.TriggerManual, detail: "admin"})
+	et := <-b
+	assert.Equal(t, pushed, et.Commit)
+	assert.Empty(t, gw.PendingApproval())
+
+	// commits of allowed authors are deployed automatically
+	merged := commit("deploy-bot")
+	fetched()
+	et = <-b
+	assert.Equal(t, merged, et.Commit)
+	assert.Equal(t, task.TriggerChange, et.Trigger)
+	assert.Empty(t, gw.PendingApproval())
+}
+
+func TestApprovalRequired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-approval")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(content string) string {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte(content), 0600))
+		_, err := wt.Add("README")
+		require.NoError(t, err)
+		hash, err := wt.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "deploy-bot", Email: "bot@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		return hash.String()
+	}
+
+	b := make(chan task.ExecutionTask, 16)
+	gw := NewGitWatcher(".test", b, time.Second, nil)
+	gw.initialised = true
+	gw.state = config.State{Targets: []task.Target{
+		{Name: "app", Directory: dir, ApprovalRequired: true},
+	}}
+	fetched := func() {
+		gw.handleCheck(check{target: "app", time: time.Now(), event: &gitwatch.Event{Path: dir}, cause: task.TriggerChange})
+	}
+
+	// every commit is held, a newer one replaces the one held
+	commit("v1")
+	fetched()
+	newest := commit("v2")
+	fetched()
+	assert.Empty(t, b)
+	assert.Equal(t, map[string]string{"app": newest}, gw.PendingApproval())
+
+	gw.doTrigger(trigger{name: "app", immediate: true, cause: task.TriggerApproval, detail: "alice"})
+	et := <-b
+	assert.Equal(t, newest, et.Commit)
+	assert.Equal(t, task.TriggerApproval, et.Trigger)
+	assert.Equal(t, "alice", et.Detail)
+	assert.Empty(t, b)
+	assert.Empty(t, gw.PendingApproval())
+
+	// the approved commit isn't held again when it's redeployed
+	gw.Redeploy("app", "secrets changed")
+	gw.doRedeploy(<-gw.redeploy)
+	et = <-b
+	assert.Equal(t, newest, et.Commit)
+	assert.Equal(t, task.TriggerRedeploy, et.Trigger)
+	assert.Empty(t, gw.PendingApproval())
+
+	// nor after a restart, where it's known as the applied commit
+	restarted := NewGitWatcher(".test", b, time.Second, nil)
+	restarted.SetApplied(func(target string) string { return newest })
+	restarted.executeStartup([]task.Target{{Name: "app", Directory: dir, ApprovalRequired: true}})
+	et = <-b
+	assert.Equal(t, newest, et.Commit)
+	assert.Equal(t, task.TriggerStartup, et.Trigger)
+	assert.Empty(t, restarted.PendingApproval())
+
+	// a new commit is still held
+	held := commit("v3")
+	restarted.executeStartup([]task.Target{{Name: "app", Directory: dir, ApprovalRequired: true}})
+	assert.Empty(t, b)
+	assert.Equal(t, map[string]string{"app": held}, restarted.PendingApproval())
+}
@@ -0,0 +1,80 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestCheckouts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-checkouts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	repo, err := git.PlainInit(source, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(name, content string) string {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(source, name)), 0o755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(source, name), []byte(content), 0o600))
+		_, err := wt.Add(name)
+		require.NoError(t, err)
+		h, err := wt.Commit(name, &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+		require.NoError(t, err)
+		return h.String()
+	}
+	commit("deploy/compose.yml", "v1")
+	commit("src/main.go", "package main")
+
+	sparse := &poller{targets: []string{"sparse"}, url: source, path: filepath.Join(dir, "sparse"), checkout: task.CheckoutSparse, paths: []string{"deploy"}}
+	archive := &poller{targets: []string{"archive"}, url: source, path: filepath.Join(dir, "archive"), checkout: task.CheckoutArchive}
+	for _, p := range []*poller{sparse, archive} {
+		event, _, err := p.fetch(context.Background())
+		require.NoError(t, err)
+		assert.Nil(t, event)
+		assert.True(t, task.IsBare(p.path))
+	}
+	assert.FileExists(t, filepath.Join(sparse.path, "deploy", "compose.yml"))
+	_, err = os.Stat(filepath.Join(sparse.path, "src"))
+	assert.True(t, os.IsNotExist(err))
+	infos, err := ioutil.ReadDir(archive.path)
+	require.NoError(t, err)
+	require.Len(t, infos, 1, "an archive checkout has no working tree")
+	assert.Equal(t, ".git", infos[0].Name())
+
+	// new commits are fetched and moved to, the same as pulls
+	head := commit("deploy/compose.yml", "v2")
+	for _, p := range []*poller{sparse, archive} {
+		event, _, err := p.fetch(context.Background())
+		require.NoError(t, err)
+		require.NotNil(t, event)
+		assert.Equal(t, p.path, event.Path)
+		assert.Equal(t, head, task.HeadCommit(p.path))
+
+		event, _, err = p.fetch(context.Background())
+		require.NoError(t, err)
+		assert.Nil(t, event)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(sparse.path, "deploy", "compose.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(b))
+
+	// changing the checkout replaces the clone
+	archive.checkout = task.CheckoutFull
+	_, _, err = archive.fetch(context.Background())
+	require.NoError(t, err)
+	assert.False(t, task.IsBare(archive.path))
+	assert.FileExists(t, filepath.Join(archive.path, "src", "main.go"))
+}
@@ -0,0 +1,174 @@
+package watcher
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/src-d/go-git.v4"
+
+	"github.com/picostack/pico/task"
+)
+
+// maxDebounceExtensions limits how often a debounce window is extended because
+// the remote head moved on after the checkout was last updated.
+const maxDebounceExtensions = 3
+
+// debounce is a change of a target that's waiting for its debounce window to
+// end before it's deployed.
+type debounce struct {
+	deadline   time.Time
+	extensions int
+	cause      task.Trigger // of the change that opened the window
+	detail     string
+}
+
+type trigger struct {
+	name      string
+	immediate bool
+	cause     task.Trigger
+	detail    string
+}
+
+// Trigger queues a deploy of the named target's current checkout. Unless it's
+// immediate, the target's debounce applies as if a change had been detected.
+// The requester, such as the admin user, is recorded as the trigger's detail.
+func (w *GitWatcher) Trigger(name string, immediate bool, requester string) {
+	w.trigger <- trigger{name, immediate, task.TriggerManual, requester}
+}
+
+func (w *GitWatcher) doTrigger(tr trigger) {
+	for _, t := range w.state.Targets {
+		if t.Name != tr.name || !t.IsEnabled() {
+			continue
+		}
+		if tr.immediate || t.Debounce == 0 {
+			w.clearDebounce(t.Name)
+			w.clearLimited(t.Name)
+			zap.L().Info("deploying triggered target", zap.String("target", t.Name), t.LabelsField())
+			w.__waitpoint__send_target_task(t, t.Path(w.directory), false, tr.cause, tr.detail)
+			return
+		}
+		w.startDebounce(t, tr.cause, tr.detail)
+		return
+	}
+	zap.L().Debug("not triggering unknown or disabled target", zap.String("target", tr.name))
+}
+
+// startDebounce opens the debounce window for a target, changes detected while
+// it's open are deployed together when it ends, as caused by the first one.
+func (w *GitWatcher) startDebounce(t task.Target, cause task.Trigger, detail string) {
+	w.debounceMu.Lock()
+	defer w.debounceMu.Unlock()
+
+	if _, ok := w.debouncing[t.Name]; ok {
+		zap.L().Debug("change detected during debounce window", zap.String("target", t.Name), t.LabelsField())
+		return
+	}
+	deadline := time.Now().Add(time.Duration(t.Debounce))
+	w.debouncing[t.Name] = &debounce{deadline: deadline, cause: cause, detail: detail}
+	zap.L().Info("debouncing target change",
+		zap.String("target", t.Name),
+		t.LabelsField(),
+		zap.Time("until", deadline))
+}
+
+func (w *GitWatcher) clearDebounce(name string) {
+	w.debounceMu.Lock()
+	delete(w.debouncing, name)
+	w.debounceMu.Unlock()
+}
+
+// Debouncing returns when the pending deploys of debounced targets are due
+func (w *GitWatcher) Debouncing() map[string]time.Time {
+	w.debounceMu.Lock()
+	defer w.debounceMu.Unlock()
+
+	out := make(map[string]time.Time, len(w.debouncing))
+	for name, d := range w.debouncing {
+		out[name] = d.deadline
+	}
+	return out
+}
+
+// flushDebounced deploys the targets whose debounce window has ended. If the
+// remote head differs from the checkout, the window is extended so the next
+// fetch can pick up the latest commit first.
+func (w *GitWatcher) flushDebounced(now time.Time) {
+	due := make(map[string]debounce)
+	w.debounceMu.Lock()
+	for name, d := range w.debouncing {
+		if !now.Before(d.deadline) {
+			due[name] = *d
+		}
+	}
+	w.debounceMu.Unlock()
+
+	for name, window := range due {
+		t, ok := w.getTargetByName(name)
+		if !ok {
+			w.clearDebounce(name)
+			continue
+		}
+		path := t.Path(w.directory)
+
+		if !w.headIsCurrent(t, path) {
+			w.debounceMu.Lock()
+			d := w.debouncing[name]
+			if d.extensions < maxDebounceExtensions {
+				d.extensions++
+				d.deadline = now.Add(time.Duration(t.Debounce))
+				w.debounceMu.Unlock()
+				zap.L().Debug("remote head moved during debounce window, extending it",
+					zap.String("target", name),
+					zap.Time("until", d.deadline))
+				continue
+			}
+			w.debounceMu.Unlock()
+		}
+
+		w.clearDebounce(name)
+		if w.held(t) || w.limited(t, now) {
+			continue
+		}
+		zap.L().Debug("debounce window ended", zap.String("target", name), t.LabelsField())
+		w.__waitpoint__send_target_task(t, path, false, window.cause, window.detail)
+	}
+}
+
+// headIsCurrent reports whether the checkout is at the remote's branch head. It
+// errs on the side of deploying, so any failure to tell counts as current.
+func (w *GitWatcher) headIsCurrent(t task.Target, path string) bool {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return true
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return true
+	}
+
+	url := t.RepoURL
+	if len(t.Mirrors) > 0 {
+		url = w.mirrors.URL(t)
+	}
+	auth, err := w.getAuthForTarget(t, url)
+	if err != nil {
+		return true
+	}
+	refs, err := w.mirrors.probe(url, auth, t.GetGitTimeout(w.gitTimeout))
+	if err != nil {
+		return true
+	}
+
+	hash, ok := remoteHead(refs, t.Branch)
+	return !ok || hash == head.Hash()
+}
+
+func (w *GitWatcher) getTargetByName(name string) (task.Target, bool) {
+	for _, t := range w.state.Targets {
+		if t.Name == name && t.IsEnabled() {
+			return t, true
+		}
+	}
+	return task.Target{}, false
+}
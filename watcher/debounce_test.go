@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Southclaws/gitwatch"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func newDebounceWatcher() (*GitWatcher, chan task.ExecutionTask) {
+	b := make(chan task.ExecutionTask, 16)
+	gw := NewGitWatcher(".test", b, time.Second, nil)
+	gw.initialised = true
+	gw.state = config.State{Targets: []task.Target{
+		{Name: "app", Debounce: task.Duration(time.Minute)},
+	}}
+	return gw, b
+}
+
+func TestDebounce(t *testing.T) {
+	gw, b := newDebounceWatcher()
+	path := gw.state.Targets[0].Path(gw.directory)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, gw.handle("app", gitwatch.Event{Path: path}, task.TriggerChange, ""))
+	}
+	deadline, ok := gw.Debouncing()["app"]
+	assert.True(t, ok)
+	assert.Empty(t, b)
+
+	gw.flushDebounced(deadline.Add(-time.Second))
+	assert.Empty(t, b)
+
+	gw.flushDebounced(deadline)
+	assert.Len(t, b, 1)
+	got := <-b
+	assert.Equal(t, "app", got.Target.Name)
+	assert.Equal(t, task.TriggerChange, got.Trigger)
+	assert.Empty(t, gw.Debouncing())
+
+	gw.flushDebounced(deadline.Add(time.Hour))
+	assert.Empty(t, b)
+}
+
+func TestDebounceImmediate(t *testing.T) {
+	gw, b := newDebounceWatcher()
+	path := gw.state.Targets[0].Path(gw.directory)
+
+	assert.NoError(t, gw.handle("app", gitwatch.Event{Path: path}, task.TriggerChange, ""))
+	assert.Len(t, gw.Debouncing(), 1)
+
+	gw.doTrigger(trigger{name: "app", immediate: true, cause: task.TriggerManual, detail: "alice"})
+	assert.Len(t, b, 1)
+	got := <-b
+	assert.Equal(t, task.TriggerManual, got.Trigger)
+	assert.Equal(t, "alice", got.Detail)
+	assert.Empty(t, gw.Debouncing(), "the pending change was deployed with the trigger")
+
+	gw.flushDebounced(time.Now().Add(time.Hour))
+	assert.Empty(t, b)
+}
@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+var (
+	// ErrAuthFailed is matched by git errors caused by the remote rejecting or
+	// requiring credentials.
+	ErrAuthFailed = errors.New("git authentication failed")
+
+	// ErrRepoNotFound is matched by git errors caused by the remote repository
+	// not existing, or not being visible with the credentials used.
+	ErrRepoNotFound = errors.New("git repository not found")
+
+	// ErrDiskFull is matched by git errors caused by the data directory's disk
+	// running out of space.
+	ErrDiskFull = errors.New("no space left for git repository")
+)
+
+// GitError is a failure to clone or fetch a repository. errors.Is matches the
+// underlying cause as well as ErrAuthFailed, ErrRepoNotFound or ErrDiskFull if
+// the cause is one of those.
+type GitError struct {
+	URL string
+	Err error
+}
+
+func (e *GitError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying cause
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the class of the underlying cause
+func (e *GitError) Is(target error) bool {
+	switch target {
+	case ErrAuthFailed:
+		return errors.Is(e.Err, transport.ErrAuthenticationRequired) ||
+			errors.Is(e.Err, transport.ErrAuthorizationFailed) ||
+			errors.Is(e.Err, transport.ErrInvalidAuthMethod) ||
+			// ssh handshake failures aren't typed by x/crypto/ssh
+			strings.Contains(e.Err.Error(), "unable to authenticate")
+	case ErrRepoNotFound:
+		return errors.Is(e.Err, transport.ErrRepositoryNotFound)
+	case ErrDiskFull:
+		return errors.Is(e.Err, syscall.ENOSPC)
+	}
+	return false
+}
+
+// gitError wraps err as a GitError for the repository at url, nil stays nil
+func gitError(url string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &GitError{URL: url, Err: err}
+}
@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+func TestGitErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"http 401", transport.ErrAuthenticationRequired, ErrAuthFailed},
+		{"http 403", transport.ErrAuthorizationFailed, ErrAuthFailed},
+		{"ssh", errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain"), ErrAuthFailed},
+		{"not found", transport.ErrRepositoryNotFound, ErrRepoNotFound},
+		{"disk full", &os.PathError{Op: "write", Path: "objects/pack", Err: syscall.ENOSPC}, ErrDiskFull},
+		{"other", errors.New("connection reset by peer"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := gitError("https://example.com/a.git", errors.Wrap(tt.err, "failed to clone initial copy of repository"))
+			for _, class := range []error{ErrAuthFailed, ErrRepoNotFound, ErrDiskFull} {
+				assert.Equal(t, class == tt.want, errors.Is(err, class), class.Error())
+			}
+			assert.True(t, errors.Is(err, tt.err))
+			var ge *GitError
+			assert.True(t, errors.As(errors.Wrap(err, "git watcher crashed"), &ge))
+		})
+	}
+}
+
+func TestGitErrorClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-git-error")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	url := filepath.Join(dir, "missing")
+	_, err = git.PlainClone(filepath.Join(dir, "clone"), false, &git.CloneOptions{URL: url})
+	require.Error(t, err)
+
+	err = gitError(url, err)
+	assert.True(t, errors.Is(err, ErrRepoNotFound))
+	assert.False(t, errors.Is(err, ErrAuthFailed))
+}
@@ -2,18 +2,18 @@ package watcher
 
 import (
 	"context"
-	"fmt"
-	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Southclaws/gitwatch"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
-	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 
 	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/disk"
+	"github.com/picostack/pico/gitauth"
 	"github.com/picostack/pico/secret"
 	"github.com/picostack/pico/task"
 )
@@ -26,17 +26,56 @@ type GitWatcher struct {
 	directory     string
 	bus           chan task.ExecutionTask
 	checkInterval time.Duration
+	gitTimeout    time.Duration
 	secrets       secret.Store
-
-	targetsWatcher *gitwatch.Session
-	state          config.State
+	authResolver  gitauth.Resolver
+	hold          func(targets []string) (release func())
+	holdTimeout   time.Duration
+	space         func(targets []string) error
+	mirrors       *mirrors
+	debouncing    map[string]*debounce
+	maintenance   *maintenance
+	debounceMu    sync.Mutex
+	lastDeploy    func(target string) time.Time
+	limitedUntil  map[string]time.Time // when held changes of rate limited targets are due
+	limitMu       sync.Mutex
+	approval      map[string]string // commits of targets held pending approval
+	approved      map[string]string // the last commit of each target approved or triggered
+	applied       func(target string) string
+	approvalMu    sync.Mutex
+
+	pollers    map[string]*poller
+	fetches    *fetchStates
+	state      config.State
+	disabled   map[string]bool
+	disabledMu sync.RWMutex
+	paused     map[string]bool // groups that are paused
+	pending    map[string]bool // targets with changes held by a paused group
+	groupsMu   sync.RWMutex
 
 	initialised bool
 	initialise  chan bool
+	ready       chan struct{}
+	lastActive  int64 // unix nanoseconds of the last loop iteration, accessed atomically
 	newState    chan config.State
+	redeploy    chan redeployRequest
+	trigger     chan trigger
+	check       chan checkRequest
+	resume      chan struct{}
 	stateReq    chan struct{}
 	stateRes    chan config.State
-	errors      chan error
+	checks      chan check
+	compactions chan compaction
+}
+
+type redeployRequest struct {
+	name   string
+	reason string
+}
+
+type checkRequest struct {
+	name     string
+	delivery string
 }
 
 // NewGitWatcher creates a new watcher with all necessary parameters
@@ -50,22 +89,82 @@ func NewGitWatcher(
 		directory:     directory,
 		bus:           bus,
 		checkInterval: checkInterval,
+		gitTimeout:    DefaultGitTimeout,
+		holdTimeout:   holdTimeout,
 		secrets:       secrets,
+		mirrors:       newMirrors(),
+		debouncing:    make(map[string]*debounce),
+		limitedUntil:  make(map[string]time.Time),
+		approval:      make(map[string]string),
+		approved:      make(map[string]string),
+		maintenance:   newMaintenance(),
+		pollers:       make(map[string]*poller),
+		fetches:       newFetchStates(),
+		paused:        make(map[string]bool),
+		pending:       make(map[string]bool),
 
 		initialise: make(chan bool),
+		ready:      make(chan struct{}),
 		newState:   make(chan config.State, 16),
+		redeploy:   make(chan redeployRequest, 16),
+		trigger:    make(chan trigger, 16),
+		check:      make(chan checkRequest, 16),
+		resume:     make(chan struct{}, 16),
 		stateReq:   make(chan struct{}),
 		stateRes:   make(chan config.State),
-		errors:     make(chan error, 16),
+		checks:     make(chan check, 16),
+
+		compactions: make(chan compaction, 16),
 	}
 }
 
+// SetAuthResolver sets how credentials are found for targets without an auth
+// method, such as from a netrc file. It must be called before Start.
+func (w *GitWatcher) SetAuthResolver(r gitauth.Resolver) {
+	w.authResolver = r
+}
+
+// SetGitTimeout sets how long every clone, fetch and listing of a target's
+// remote may take before it's abandoned, unless the target sets git_timeout.
+// Zero disables the timeout. It must be called before Start.
+func (w *GitWatcher) SetGitTimeout(timeout time.Duration) {
+	w.gitTimeout = timeout
+}
+
+// SetSpaceCheck sets a check of the free disk space made before every clone or
+// fetch, with the targets of the clone. The fetch is skipped if it returns an
+// error, errors matching disk.ErrLowSpace aren't counted as failed fetches. It
+// must be called before Start.
+func (w *GitWatcher) SetSpaceCheck(check func(targets []string) error) {
+	w.space = check
+}
+
+// SetHold sets how the tasks of targets whose definitions change are held back
+// while a new state is applied, such as executor.Holder's Hold. It must be
+// called before Start.
+func (w *GitWatcher) SetHold(hold func(targets []string) (release func())) {
+	w.hold = hold
+}
+
 func (w *GitWatcher) __waitpoint__start_wait_init() {
 	<-w.initialise
 }
 
-func (w *GitWatcher) __waitpoint__start_select_states() (err error) {
+func (w *GitWatcher) __waitpoint__start_select_states(heartbeat <-chan time.Time) (err error) {
+	atomic.StoreInt64(&w.lastActive, time.Now().UnixNano())
+
 	select {
+	case now := <-heartbeat:
+		// the iteration itself is the heartbeat, it's also when debounced
+		// changes are deployed, targets with mirrors check their remotes and
+		// clones are maintained.
+		w.flushDebounced(now)
+		w.flushLimited(now)
+		if err := w.checkMirrors(); err != nil {
+			return err
+		}
+		return w.checkMaintenance(now)
+
 	case newState := <-w.newState:
 		zap.L().Debug("git watcher received new state",
 			zap.Any("new_state", newState))
@@ -75,19 +174,23 @@ func (w *GitWatcher) __waitpoint__start_select_states() (err error) {
 	case <-w.stateReq:
 		w.stateRes <- w.state
 
-	case event := <-w.targetsWatcher.Events:
-		zap.L().Debug("git watcher received a target event",
-			zap.Any("new_state", event))
+	case r := <-w.redeploy:
+		w.doRedeploy(r)
 
-		if e := w.handle(event); e != nil {
-			zap.L().Error("failed to handle event",
-				zap.String("url", event.URL),
-				zap.Error(e))
-		}
+	case tr := <-w.trigger:
+		w.doTrigger(tr)
 
-	case e := <-errorMultiplex(w.errors, w.targetsWatcher.Errors):
-		zap.L().Error("git error",
-			zap.Error(e))
+	case r := <-w.check:
+		w.doCheck(r)
+
+	case <-w.resume:
+		w.releasePending()
+
+	case c := <-w.checks:
+		w.handleCheck(c)
+
+	case c := <-w.compactions:
+		w.doCompaction(c)
 	}
 	return
 }
@@ -100,9 +203,15 @@ func (w *GitWatcher) Start() error {
 	w.__waitpoint__start_wait_init()
 
 	zap.L().Debug("git watcher initialised", zap.Any("initial_state", w.state))
+	close(w.ready)
+
+	// the heartbeat ensures the loop iterates regularly so LastActive reflects
+	// whether the loop is stuck rather than just idle.
+	heartbeat := time.NewTicker(time.Second)
+	defer heartbeat.Stop()
 
 	for {
-		err := w.__waitpoint__start_select_states()
+		err := w.__waitpoint__start_select_states(heartbeat.C)
 		if err != nil {
 			return err
 		}
@@ -116,7 +225,29 @@ func (w *GitWatcher) Start() error {
 //   - sets the watcher state field to the new state
 func (w *GitWatcher) doReconfigure(newState config.State) error {
 	additions, removals := task.DiffTargets(w.state.Targets, newState.Targets)
+
+	// a modified target's executing task finishes with its old definition and
+	// clone before the new one is applied, for up to the hold timeout, tasks it
+	// had queued are dropped in favour of the one executed for the new
+	// definition below.
+	if modified := modifiedTargets(w.state.Targets, additions); len(modified) > 0 && w.hold != nil {
+		release := w.awaitHold(modified)
+		defer release()
+	}
+
 	w.state = newState
+	w.setDisabled(newState.Targets)
+
+	w.mirrors.forget(newState.Targets)
+	w.fetches.forget(newState.Targets)
+	for _, t := range newState.Targets {
+		if len(t.Mirrors) == 0 || !t.IsEnabled() {
+			continue
+		}
+		if _, _, err := w.selectRemote(t); err != nil {
+			return err
+		}
+	}
 
 	err := w.watchTargets()
 	if err != nil {
@@ -125,11 +256,32 @@ func (w *GitWatcher) doReconfigure(newState config.State) error {
 
 	// out with the old, in with the new!
 	w.executeTargets(removals, true)
-	w.executeTargets(additions, false)
+	if w.initialised {
+		w.executeTargets(additions, false)
+	} else {
+		w.executeStartup(additions)
+	}
+
+	// targets may have moved out of a paused group
+	w.releasePending()
 
 	return nil
 }
 
+// modifiedTargets returns the names of the additions that replace an existing
+// target rather than adding a new one.
+func modifiedTargets(old []task.Target, additions []task.Target) (names []string) {
+	for _, a := range additions {
+		for _, o := range old {
+			if o.Name == a.Name {
+				names = append(names, a.Name)
+				break
+			}
+		}
+	}
+	return
+}
+
 func (w *GitWatcher) doInit(state config.State) error {
 	if err := w.doReconfigure(state); err != nil {
 		return err
@@ -139,6 +291,78 @@ func (w *GitWatcher) doInit(state config.State) error {
 	return nil
 }
 
+// setDisabled records which targets are disabled so tasks already queued for
+// them can be dropped by the executor.
+func (w *GitWatcher) setDisabled(targets task.Targets) {
+	disabled := make(map[string]bool)
+	for _, t := range targets {
+		if !t.IsEnabled() {
+			disabled[t.Name] = true
+		}
+	}
+
+	w.disabledMu.Lock()
+	w.disabled = disabled
+	w.disabledMu.Unlock()
+}
+
+// IsEnabled reports whether the named target is currently enabled. It is safe
+// to call concurrently with the watch loop.
+func (w *GitWatcher) IsEnabled(name string) bool {
+	w.disabledMu.RLock()
+	defer w.disabledMu.RUnlock()
+	return !w.disabled[name]
+}
+
+// Check makes the named target fetch its repository now rather than at its
+// next interval, such as when a webhook reports a push. Unknown and disabled
+// targets are ignored. The delivery identifies the webhook that reported the
+// push, if any.
+func (w *GitWatcher) Check(name, delivery string) {
+	w.check <- checkRequest{name, delivery}
+}
+
+func (w *GitWatcher) doCheck(r checkRequest) {
+	name := r.name
+	p, ok := w.pollers[name]
+	if !ok {
+		zap.L().Debug("not checking unknown or disabled target", zap.String("target", name))
+		return
+	}
+	zap.L().Debug("checking target now", zap.String("target", name))
+	p.poke(r.delivery)
+}
+
+// Redeploy queues the named target to be executed again with its current
+// checkout, such as when its secrets have changed. The reason is recorded as
+// the detail of the task's trigger. Unknown and disabled targets are ignored.
+func (w *GitWatcher) Redeploy(name, reason string) {
+	w.redeploy <- redeployRequest{name, reason}
+}
+
+func (w *GitWatcher) doRedeploy(r redeployRequest) {
+	name := r.name
+	for _, t := range w.state.Targets {
+		if t.Name != name || !t.IsEnabled() {
+			continue
+		}
+		zap.L().Info("redeploying target", zap.String("target", t.Name), t.LabelsField())
+		w.__waitpoint__send_target_task(t, t.Path(w.directory), false, task.TriggerRedeploy, r.reason)
+		return
+	}
+	zap.L().Debug("not redeploying unknown or disabled target", zap.String("target", name))
+}
+
+// Ready returns a channel that's closed once the first state has been applied
+func (w *GitWatcher) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// LastActive returns the time the watch loop last made progress
+func (w *GitWatcher) LastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&w.lastActive))
+}
+
 // SetState implements Watcher
 // Upon state being updated, the watcher dispatches an event to its own channel
 // to instruct the daemon loop to reconfigure. The reason for this is that loop
@@ -161,154 +385,254 @@ func (w *GitWatcher) GetState() config.State {
 	return <-w.stateRes
 }
 
-// watchTargets creates or restarts the targets watcher.
+// watchTargets creates or restarts a poller for every enabled target, targets
+// sharing a clone share a poller. Each target is checked once before this
+// returns, a failure is recorded in the target's state rather than stopping the
+// others from being watched.
 func (w *GitWatcher) watchTargets() (err error) {
-	targetRepos := make([]gitwatch.Repository, len(w.state.Targets))
-	for i, t := range w.state.Targets {
-		dir := getTargetPath(t)
-		auth, err := w.getAuthForTarget(t)
+	pollers := make(map[string]*poller, len(w.state.Targets))
+	clones := make(map[string]*poller)
+	for _, t := range w.state.Targets {
+		if !t.IsEnabled() {
+			zap.L().Debug("skipping disabled target", zap.String("target", t.Name), t.LabelsField())
+			continue
+		}
+		dir := t.Path(w.directory)
+		if p, ok := clones[dir]; ok {
+			zap.L().Debug("assigned target to shared clone", zap.String("directory", dir), zap.Strings("targets", p.targets), t.LabelsField())
+			p.targets = append(p.targets, t.Name)
+			pollers[t.Name] = p
+			continue
+		}
+		url := t.RepoURL
+		if len(t.Mirrors) > 0 {
+			url = w.mirrors.URL(t)
+		}
+		auth, err := w.getAuthForTarget(t, url)
 		if err != nil {
 			return err
 		}
-		zap.L().Debug("assigned target", zap.String("url", t.RepoURL), zap.String("directory", dir))
-		targetRepos[i] = gitwatch.Repository{
-			URL:       t.RepoURL,
-			Branch:    t.Branch,
-			Directory: dir,
-			Auth:      auth,
+		zap.L().Debug("assigned target", zap.String("url", url), zap.String("directory", dir), t.LabelsField())
+		pollers[t.Name] = &poller{
+			targets: []string{t.Name},
+			url:     url,
+			branch:  t.Branch,
+			path:    dir,
+			auth:    auth,
+			timeout: t.GetGitTimeout(w.gitTimeout),
+			done:    make(chan struct{}),
+			// targets that share a clone have the same checkout
+			checkout: t.GetCheckout(),
+			paths:    t.CheckoutPaths(),
+			now:      make(chan string, 1),
+			space:    w.space,
 		}
+		clones[dir] = pollers[t.Name]
 	}
 
-	if w.targetsWatcher != nil {
-		w.targetsWatcher.Close()
+	w.stopTargets()
+	w.pollers = pollers
+	zap.L().Debug("created target pollers, awaiting setup", zap.Int("targets", len(pollers)), zap.Int("clones", len(clones)))
+
+	w.__waitpoint__watch_targets()
+
+	zap.L().Debug("target pollers initialised")
+
+	return
+}
+
+// stopTargets stops every poller and waits for the fetches in progress, if
+// any, to finish.
+func (w *GitWatcher) stopTargets() {
+	for _, p := range w.clonePollers() {
+		p.cancel()
 	}
-	w.targetsWatcher, err = gitwatch.New(
-		context.TODO(),
-		targetRepos,
-		w.checkInterval,
-		w.directory,
-		nil,
-		false)
-	if err != nil {
-		return errors.Wrap(err, "failed to watch targets")
+	// checks are drained so a poller blocked on reporting one can finish
+	for _, p := range w.clonePollers() {
+		for done := false; !done; {
+			select {
+			case <-p.done:
+				done = true
+			case c := <-w.checks:
+				w.handleCheck(c)
+			}
+		}
 	}
+	w.pollers = nil
+}
 
-	errs := make(chan error)
-	go func() {
-		e := w.targetsWatcher.Run()
-		if e != nil && !errors.Is(e, context.Canceled) {
-			errs <- e
+// clonePollers returns each poller once, even if several targets share it
+func (w *GitWatcher) clonePollers() []*poller {
+	seen := make(map[*poller]bool, len(w.pollers))
+	var out []*poller
+	for _, p := range w.pollers {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
 		}
-		// forward errors from the watcher to the central for-select above
-		w.errors <- <-w.targetsWatcher.Errors
-	}()
-	zap.L().Debug("created targets watcher, awaiting setup")
+	}
+	return out
+}
 
-	err = w.__waitpoint__watch_targets(errs)
+func (w *GitWatcher) __waitpoint__watch_targets() {
+	for _, p := range w.clonePollers() {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.cancel = cancel
+		p.removeStaleLocks()
+		event, previous, err := p.fetch(ctx)
+		now := time.Now()
+		for _, target := range p.targets {
+			w.handleCheck(check{target: target, time: now, event: event, err: err, previous: previous})
+		}
+		go p.run(ctx, w.checkInterval, w.checks)
+	}
+}
 
-	zap.L().Debug("targets watcher initialised")
+// handleCheck records the outcome of a fetch and deploys the target if the
+// fetch brought in new commits.
+func (w *GitWatcher) handleCheck(c check) {
+	if errors.Is(c.err, disk.ErrLowSpace) {
+		zap.L().Debug("skipped fetch while disk space is low",
+			zap.String("target", c.target),
+			zap.Error(c.err))
+		return
+	}
+	if w.fetches.record(c) {
+		zap.L().Info("target fetched successfully again", zap.String("target", c.target))
+	}
+	if c.err != nil {
+		zap.L().Error("git error",
+			zap.String("target", c.target),
+			zap.Error(c.err))
+		w.mirrors.fail()
+		return
+	}
+	if c.event == nil || w.outsideSubpath(c) {
+		return
+	}
 
-	return
-}
+	zap.L().Debug("git watcher received a target event",
+		zap.String("target", c.target),
+		zap.Any("event", c.event))
 
-func (w *GitWatcher) __waitpoint__watch_targets(errs chan error) (err error) {
-	select {
-	case <-w.targetsWatcher.InitialDone:
-	case err = <-errs:
+	if e := w.handle(c.target, *c.event, c.cause, c.detail); e != nil {
+		zap.L().Error("failed to handle event",
+			zap.String("url", c.event.URL),
+			zap.Error(e))
 	}
-	return
 }
 
-func (w *GitWatcher) handle(e gitwatch.Event) (err error) {
-	target, exists := w.getTarget(e.URL)
+func (w *GitWatcher) handle(name string, e gitwatch.Event, cause task.Trigger, detail string) (err error) {
+	target, exists := w.getTargetByName(name)
 	if !exists {
-		return errors.Errorf("attempt to handle event for unknown target %s at %s", e.URL, e.Path)
+		return errors.Errorf("attempt to handle event for unknown target %s at %s", name, e.Path)
 	}
 	zap.L().Debug("handling event",
 		zap.String("target", target.Name),
-		zap.String("url", target.RepoURL),
-		zap.Time("timestamp", e.Timestamp))
-	w.__waitpoint__send_target_task(target, e.Path, false)
+		zap.String("url", e.URL),
+		zap.Time("timestamp", e.Timestamp),
+		target.LabelsField())
+	if !w.initialised {
+		// the first fetch after a cold start is deployed by the startup plan
+		return nil
+	}
+	if w.held(target) || w.limited(target, time.Now()) {
+		return nil
+	}
+	if target.Debounce > 0 {
+		w.startDebounce(target, cause, detail)
+		return nil
+	}
+	w.__waitpoint__send_target_task(target, e.Path, false, cause, detail)
 	return nil
 }
 
-func getTargetPath(t task.Target) string {
-    if t.Branch != "" {
-        return fmt.Sprintf("%s_%s", t.Name, t.Branch)
-    }
-    return t.Name
-}
-
-func (w GitWatcher) getAuthForTarget(t task.Target) (transport.AuthMethod, error) {
+// getAuthForTarget returns the auth method for one of the target's repository
+// URLs, a named auth method applies to all of them.
+func (w *GitWatcher) getAuthForTarget(t task.Target, url string) (transport.AuthMethod, error) {
 	for _, a := range w.state.AuthMethods {
 		if a.Name == t.Auth {
+			mode, err := gitauth.ParseMode(a.Type)
+			if err != nil {
+				return nil, err
+			}
 			s, err := w.secrets.GetSecretsForTarget(a.Path)
 			if err != nil {
 				return nil, err
 			}
-			username, ok := s[a.UserKey]
-			if !ok {
-				return nil, errors.Errorf("auth object 'user_key' did not point to a valid element in the specified secret at '%s'", a.Path)
+			if a.TokenKey != "" {
+				token, ok := s[a.TokenKey]
+				if !ok {
+					return nil, errors.Errorf("auth object 'token_key' did not point to a valid element in the specified secret at '%s'", a.Path)
+				}
+				if mode == gitauth.ModeAuto {
+					mode = gitauth.ModeToken
+				}
+				zap.L().Debug("using auth method for target", zap.String("name", a.Name))
+				return gitauth.New(mode, "", token)
+			}
+			var username string
+			if a.UserKey != "" {
+				var ok bool
+				username, ok = s[a.UserKey]
+				if !ok {
+					return nil, errors.Errorf("auth object 'user_key' did not point to a valid element in the specified secret at '%s'", a.Path)
+				}
 			}
 			password, ok := s[a.PassKey]
 			if !ok {
 				return nil, errors.Errorf("auth object 'pass_key' did not point to a valid element in the specified secret at '%s'", a.Path)
 			}
 			zap.L().Debug("using auth method for target", zap.String("name", a.Name))
-			return &http.BasicAuth{
-				Username: username,
-				Password: password,
-			}, nil
+			return gitauth.New(mode, username, password)
 		}
 	}
+	if w.authResolver != nil {
+		return w.authResolver(url), nil
+	}
 	return nil, nil
 }
 
-func (w GitWatcher) executeTargets(targets []task.Target, shutdown bool) {
+func (w *GitWatcher) executeTargets(targets []task.Target, shutdown bool) {
 	zap.L().Debug("executing all targets",
 		zap.Bool("shutdown", shutdown),
 		zap.Int("targets", len(targets)))
 
 	for _, t := range targets {
-		w.__waitpoint__send_target_task(t, filepath.Join(w.directory, getTargetPath(t)), shutdown)
+		if !t.IsEnabled() || (!shutdown && w.held(t)) {
+			continue
+		}
+		w.__waitpoint__send_target_task(t, t.Path(w.directory), shutdown, task.TriggerConfig, "")
 	}
 }
 
-func (w GitWatcher) getTarget(url string) (target task.Target, exists bool) {
-	for _, t := range w.state.Targets {
-		if t.RepoURL == url {
-			return t, true
-		}
+func (w *GitWatcher) __waitpoint__send_target_task(target task.Target, path string, shutdown bool, trigger task.Trigger, detail string) {
+	if !shutdown && w.awaitingApproval(target, path, trigger) {
+		return
 	}
-	return
+	t := w.newTask(target, path, shutdown, trigger, detail)
+	zap.L().Info("queued task",
+		zap.String("task_id", t.ID),
+		zap.String("target", target.Name),
+		target.LabelsField(),
+		zap.String("commit", t.Commit),
+		zap.String("trigger", string(trigger)),
+		zap.String("trigger_detail", detail),
+		zap.Bool("shutdown", shutdown))
+	w.bus <- t
 }
 
-func (w GitWatcher) __waitpoint__send_target_task(target task.Target, path string, shutdown bool) {
-	w.bus <- task.ExecutionTask{
+func (w *GitWatcher) newTask(target task.Target, path string, shutdown bool, trigger task.Trigger, detail string) task.ExecutionTask {
+	return task.ExecutionTask{
+		ID:       task.NewTaskID(),
 		Target:   target,
 		Path:     path,
+		Commit:   task.HeadCommit(path),
+		Priority: target.Priority,
+		Trigger:  trigger,
+		Detail:   detail,
 		Shutdown: shutdown,
 		Env:      w.state.Env,
+		Created:  time.Now(),
 	}
 }
-
-func errorMultiplex(chans ...<-chan error) <-chan error {
-	out := make(chan error)
-	go func() {
-		var wg sync.WaitGroup
-		wg.Add(len(chans))
-
-		for _, c := range chans {
-			go func(c <-chan error) {
-				for v := range c {
-					out <- v
-				}
-				wg.Done()
-			}(c)
-		}
-
-		wg.Wait()
-		close(out)
-	}()
-	return out
-}
@@ -11,6 +11,11 @@ import (
 )
 
 func TestStateTransitions(t *testing.T) {
+	w, bus := startWatcher(t)
+
+	// the cold start has no targets, so t01 is added by a reconfiguration
+	assert.NoError(t, w.SetState(config.State{}))
+
 	// add target t01
 	assert.NoError(t, w.SetState(config.State{
 		Targets: []task.Target{{
@@ -61,7 +66,7 @@ func TestStateTransitions(t *testing.T) {
 		Env:     map[string]string{},
 	}))
 
-	assert.Equal(t, <-bus, task.ExecutionTask{
+	assert.Equal(t, receive(bus), task.ExecutionTask{
 		Target: task.Target{
 			Name:    "t01",
 			RepoURL: "https://github.com/picostack/pico-example-target",
@@ -69,11 +74,12 @@ func TestStateTransitions(t *testing.T) {
 		},
 		Path:     filepath.Join(".test", "t01"),
 		Shutdown: false,
+		Trigger:  task.TriggerConfig,
 		Env: map[string]string{
 			"KEY": "VALUE",
 		},
 	})
-	assert.Equal(t, <-bus, task.ExecutionTask{
+	assert.Equal(t, receive(bus), task.ExecutionTask{
 		Target: task.Target{
 			Name:    "t02",
 			RepoURL: "https://github.com/picostack/pico-example-target",
@@ -81,11 +87,12 @@ func TestStateTransitions(t *testing.T) {
 		},
 		Path:     filepath.Join(".test", "t02"),
 		Shutdown: false,
+		Trigger:  task.TriggerConfig,
 		Env: map[string]string{
 			"KEY": "VALUE",
 		},
 	})
-	assert.Equal(t, <-bus, task.ExecutionTask{
+	assert.Equal(t, receive(bus), task.ExecutionTask{
 		Target: task.Target{
 			Name:    "t01",
 			RepoURL: "https://github.com/picostack/pico-example-target",
@@ -93,11 +100,12 @@ func TestStateTransitions(t *testing.T) {
 		},
 		Path:     filepath.Join(".test", "t01"),
 		Shutdown: true,
+		Trigger:  task.TriggerConfig,
 		Env: map[string]string{
 			"KEY": "VALUE",
 		},
 	})
-	assert.Equal(t, <-bus, task.ExecutionTask{
+	assert.Equal(t, receive(bus), task.ExecutionTask{
 		Target: task.Target{
 			Name:    "t02",
 			RepoURL: "https://github.com/picostack/pico-example-target",
@@ -105,6 +113,7 @@ func TestStateTransitions(t *testing.T) {
 		},
 		Path:     filepath.Join(".test", "t02"),
 		Shutdown: true,
+		Trigger:  task.TriggerConfig,
 		Env: map[string]string{
 			"KEY": "VALUE",
 		},
@@ -13,6 +13,8 @@ import (
 )
 
 func TestGitEvents(t *testing.T) {
+	w, bus := startWatcher(t)
+
 	// add target t01
 	assert.NoError(t, w.SetState(config.State{
 		Targets: []task.Target{{
@@ -25,7 +27,7 @@ func TestGitEvents(t *testing.T) {
 		},
 	}))
 	// assert receive
-	assert.Equal(t, <-bus, task.ExecutionTask{
+	assert.Equal(t, receive(bus), task.ExecutionTask{
 		Target: task.Target{
 			Name:    "t01",
 			RepoURL: "https://github.com/picostack/pico-example-target",
@@ -33,18 +35,19 @@ func TestGitEvents(t *testing.T) {
 		},
 		Path:     filepath.Join(".test", "t01"),
 		Shutdown: false,
+		Trigger:  task.TriggerStartup,
 		Env: map[string]string{
 			"KEY": "VALUE",
 		},
 	})
 
-	assert.NoError(t, w.handle(gitwatch.Event{
+	assert.NoError(t, w.handle("t01", gitwatch.Event{
 		URL:       "https://github.com/picostack/pico-example-target",
 		Path:      filepath.Join(".test", "t01"),
 		Timestamp: time.Now(),
-	}))
+	}, task.TriggerChange, ""))
 
-	assert.Equal(t, <-bus, task.ExecutionTask{
+	assert.Equal(t, receive(bus), task.ExecutionTask{
 		Target: task.Target{
 			Name:    "t01",
 			RepoURL: "https://github.com/picostack/pico-example-target",
@@ -52,6 +55,7 @@ func TestGitEvents(t *testing.T) {
 		},
 		Path:     filepath.Join(".test", "t01"),
 		Shutdown: false,
+		Trigger:  task.TriggerChange,
 		Env: map[string]string{
 			"KEY": "VALUE",
 		},
@@ -1,7 +1,6 @@
 package watcher
 
 import (
-	"os"
 	"testing"
 	"time"
 
@@ -10,18 +9,28 @@ import (
 	_ "github.com/picostack/pico/logger"
 )
 
-var w *GitWatcher
-var bus chan task.ExecutionTask
-
-func TestMain(m *testing.M) {
-	bus = make(chan task.ExecutionTask, 16)
-	w = NewGitWatcher(".test", bus, time.Second, nil)
+// startWatcher starts a watcher of its own for a test, so the test doesn't
+// depend on the states other tests have set
+func startWatcher(t *testing.T) (*GitWatcher, chan task.ExecutionTask) {
+	bus := make(chan task.ExecutionTask, 16)
+	w := NewGitWatcher(".test", bus, time.Second, nil)
 
 	go func() {
 		if err := w.Start(); err != nil {
-			panic(err)
+			t.Error(err)
 		}
 	}()
+	return w, bus
+}
 
-	os.Exit(m.Run())
+// receive returns the next task from the bus without its random ID, its commit,
+// which depends on the current head of the example repository, its creation
+// time and the cold start plan of the first state.
+func receive(bus chan task.ExecutionTask) task.ExecutionTask {
+	t := <-bus
+	t.ID = ""
+	t.Commit = ""
+	t.Created = time.Time{}
+	t.Plan = nil
+	return t
 }
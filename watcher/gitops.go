@@ -0,0 +1,195 @@
+package watcher
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/Southclaws/gitwatch"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// DefaultGitTimeout is how long a clone, fetch or listing of a remote may take
+// unless configured otherwise.
+const DefaultGitTimeout = 10 * time.Minute
+
+// withTimeout runs a git operation with a deadline, a timeout of zero never
+// expires. An operation that's abandoned after timing out is logged with how
+// long it ran for and returns an error matching context.DeadlineExceeded.
+func withTimeout(ctx context.Context, timeout time.Duration, operation, url string, f func(ctx context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	err := f(ctx)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		elapsed := time.Since(started)
+		zap.L().Error("git operation timed out",
+			zap.String("operation", operation),
+			zap.String("url", url),
+			zap.Duration("elapsed", elapsed),
+			zap.Duration("timeout", timeout))
+		return errors.Wrapf(context.DeadlineExceeded, "git %s of %s abandoned after %s", operation, url, elapsed.Round(time.Millisecond))
+	}
+	return err
+}
+
+// Clone clones the branch of the repository to path, the remote's default
+// branch if it's empty. A clone that fails or times out is removed, so there's
+// never a partial clone left behind.
+func Clone(ctx context.Context, timeout time.Duration, path, url, branch string, auth transport.AuthMethod) error {
+	var ref plumbing.ReferenceName
+	if branch != "" {
+		ref = plumbing.NewBranchReferenceName(branch)
+	}
+	return withTimeout(ctx, timeout, "clone", url, func(ctx context.Context) error {
+		_, err := git.PlainCloneContext(ctx, path, false, &git.CloneOptions{
+			URL:           url,
+			Auth:          auth,
+			ReferenceName: ref,
+		})
+		return errors.Wrap(err, "failed to clone initial copy of repository")
+	})
+}
+
+// CloneBare clones the branch of the repository without a working tree into
+// the .git directory of path, so it's still found where a full clone would be.
+func CloneBare(ctx context.Context, timeout time.Duration, path, url, branch string, auth transport.AuthMethod) error {
+	var ref plumbing.ReferenceName
+	if branch != "" {
+		ref = plumbing.NewBranchReferenceName(branch)
+	}
+	return withTimeout(ctx, timeout, "clone", url, func(ctx context.Context) error {
+		_, err := git.PlainCloneContext(ctx, filepath.Join(path, git.GitDirName), true, &git.CloneOptions{
+			URL:           url,
+			Auth:          auth,
+			ReferenceName: ref,
+		})
+		return errors.Wrap(err, "failed to clone initial copy of repository")
+	})
+}
+
+// FetchBare fetches the branch of a clone without a working tree, or the branch
+// checked out when it was cloned if empty, and moves the branch to the remote's.
+// It returns an event if there were new commits, the same as Pull.
+func FetchBare(ctx context.Context, timeout time.Duration, repo *git.Repository, path, url, branch string, auth transport.AuthMethod) (*gitwatch.Event, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read HEAD")
+	}
+	local := head.Name()
+	if branch != "" {
+		local = plumbing.NewBranchReferenceName(branch)
+	}
+	if !local.IsBranch() {
+		return nil, errors.Errorf("HEAD of %s is not a branch", path)
+	}
+	err = Fetch(ctx, timeout, repo, url, &git.FetchOptions{Auth: auth})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, errors.Wrap(err, "failed to fetch local repo")
+	}
+
+	remote, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, local.Short()), true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read remote branch %s", local.Short())
+	}
+	if remote.Hash() == head.Hash() {
+		return nil, nil
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(local, remote.Hash())); err != nil {
+		return nil, errors.Wrapf(err, "failed to update branch %s", local.Short())
+	}
+	c, err := repo.CommitObject(remote.Hash())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read commit %s", remote.Hash())
+	}
+	return &gitwatch.Event{URL: url, Path: path, Timestamp: c.Author.When}, nil
+}
+
+// headEvent returns an event for the commit checked out at path, for a clone
+// that replaced one of a different commit.
+func headEvent(path, url string) (*gitwatch.Event, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open local repo")
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read HEAD")
+	}
+	c, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read commit %s", head.Hash())
+	}
+	return &gitwatch.Event{URL: url, Path: path, Timestamp: c.Author.When}, nil
+}
+
+// Pull pulls the branch of the checkout and returns an event if there were new
+// commits. References are only updated once all objects have been fetched, so
+// a pull that times out leaves the checkout as it was.
+func Pull(ctx context.Context, timeout time.Duration, repo *git.Repository, url, branch string, auth transport.AuthMethod) (*gitwatch.Event, error) {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get worktree")
+	}
+	var ref plumbing.ReferenceName
+	if branch != "" {
+		ref = plumbing.NewBranchReferenceName(branch)
+	}
+	err = withTimeout(ctx, timeout, "fetch", url, func(ctx context.Context) error {
+		return wt.PullContext(ctx, &git.PullOptions{
+			Auth:          auth,
+			ReferenceName: ref,
+		})
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to pull local repo")
+	}
+	return gitwatch.GetEventFromRepo(repo)
+}
+
+// Fetch fetches from the remote of the repository at url
+func Fetch(ctx context.Context, timeout time.Duration, repo *git.Repository, url string, o *git.FetchOptions) error {
+	return withTimeout(ctx, timeout, "fetch", url, func(ctx context.Context) error {
+		return repo.FetchContext(ctx, o)
+	})
+}
+
+// ListRemote lists the references of a remote, the same as git ls-remote. The
+// listing can't be cancelled, so once it times out it's abandoned and left to
+// finish in the background.
+func ListRemote(ctx context.Context, timeout time.Duration, url string, auth transport.AuthMethod) (refs []*plumbing.Reference, err error) {
+	err = withTimeout(ctx, timeout, "ls-remote", url, func(ctx context.Context) error {
+		type listing struct {
+			refs []*plumbing.Reference
+			err  error
+		}
+		done := make(chan listing, 1)
+		go func() {
+			remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{
+				Name: git.DefaultRemoteName,
+				URLs: []string{url},
+			})
+			refs, err := remote.List(&git.ListOptions{Auth: auth})
+			done <- listing{refs, err}
+		}()
+		select {
+		case l := <-done:
+			refs = l.refs
+			return errors.Wrapf(l.err, "failed to list %s", url)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	return refs, err
+}
@@ -0,0 +1,67 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestGitTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-git-timeout")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a server that accepts connections and never responds
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	url := "http://" + l.Addr().String() + "/repo.git"
+
+	started := time.Now()
+	_, err = ListRemote(context.Background(), 100*time.Millisecond, url, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second))
+
+	source := filepath.Join(dir, "source")
+	repo, err := git.PlainInit(source, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(source, "file"), []byte("one"), 0o600))
+	_, err = wt.Add("file")
+	require.NoError(t, err)
+	_, err = wt.Commit("one", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+
+	clone := filepath.Join(dir, "clone")
+	err = Clone(context.Background(), time.Nanosecond, clone, source, "", nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	_, err = os.Stat(clone)
+	assert.True(t, os.IsNotExist(err), "a partial clone was left behind")
+
+	require.NoError(t, Clone(context.Background(), time.Minute, clone, source, "", nil))
+	cloned, err := git.PlainOpen(clone)
+	require.NoError(t, err)
+	e, err := Pull(context.Background(), time.Minute, cloned, source, "", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, e)
+}
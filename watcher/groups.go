@@ -0,0 +1,87 @@
+package watcher
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// PauseGroup stops changes to the group's targets from being deployed until
+// the group is resumed. Changes detected in the meantime are remembered, so
+// resuming deploys them. Triggered deploys are not affected.
+func (w *GitWatcher) PauseGroup(group string) {
+	w.groupsMu.Lock()
+	w.paused[group] = true
+	w.groupsMu.Unlock()
+	zap.L().Info("paused group", zap.String("group", group))
+}
+
+// ResumeGroup allows changes to the group's targets to be deployed again and
+// queues the changes detected while it was paused.
+func (w *GitWatcher) ResumeGroup(group string) {
+	w.groupsMu.Lock()
+	delete(w.paused, group)
+	w.groupsMu.Unlock()
+	zap.L().Info("resumed group", zap.String("group", group))
+
+	w.resume <- struct{}{}
+}
+
+// PausedGroups returns the names of the paused groups, sorted
+func (w *GitWatcher) PausedGroups() []string {
+	w.groupsMu.RLock()
+	defer w.groupsMu.RUnlock()
+
+	out := make([]string, 0, len(w.paused))
+	for group := range w.paused {
+		out = append(out, group)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// held reports whether the target's group is paused, and if it is remembers
+// that the target has a change to deploy once it's resumed.
+func (w *GitWatcher) held(t task.Target) bool {
+	if t.Group == "" {
+		return false
+	}
+	w.groupsMu.Lock()
+	defer w.groupsMu.Unlock()
+
+	if !w.paused[t.Group] {
+		return false
+	}
+	w.pending[t.Name] = true
+	zap.L().Info("holding change of target in paused group",
+		zap.String("target", t.Name),
+		zap.String("group", t.Group),
+		t.LabelsField())
+	return true
+}
+
+// releasePending deploys the held changes of targets whose group is no longer
+// paused, either since it was resumed or since the target moved to another
+// group. Changes of targets that no longer exist are forgotten.
+func (w *GitWatcher) releasePending() {
+	var release []string
+	w.groupsMu.Lock()
+	for name := range w.pending {
+		t, ok := w.getTargetByName(name)
+		if ok && w.paused[t.Group] {
+			continue
+		}
+		delete(w.pending, name)
+		if ok {
+			release = append(release, name)
+		}
+	}
+	w.groupsMu.Unlock()
+
+	sort.Strings(release)
+	for _, name := range release {
+		w.doTrigger(trigger{name: name, cause: task.TriggerChange, detail: "released from paused group"})
+	}
+}
@@ -0,0 +1,47 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func TestGroupsHoldChanges(t *testing.T) {
+	b := make(chan task.ExecutionTask, 16)
+	gw := NewGitWatcher(".test", b, time.Second, nil)
+	gw.state = config.State{Targets: []task.Target{
+		{Name: "grafana", Group: "monitoring"},
+		{Name: "prometheus", Group: "monitoring"},
+		{Name: "traefik", Group: "ingress"},
+	}}
+
+	gw.PauseGroup("monitoring")
+	assert.Equal(t, []string{"monitoring"}, gw.PausedGroups())
+
+	for _, name := range []string{"grafana", "traefik"} {
+		target, _ := gw.getTargetByName(name)
+		if !gw.held(target) {
+			gw.__waitpoint__send_target_task(target, target.Path(gw.directory), false, task.TriggerChange, "")
+		}
+	}
+	assert.Equal(t, "traefik", (<-b).Target.Name)
+	assert.Empty(t, b)
+
+	// moving a target out of the paused group releases its change
+	gw.state.Targets[0].Group = "apps"
+	gw.releasePending()
+	assert.Equal(t, "grafana", (<-b).Target.Name)
+
+	prometheus, _ := gw.getTargetByName("prometheus")
+	assert.True(t, gw.held(prometheus))
+	gw.ResumeGroup("monitoring")
+	<-gw.resume
+	gw.releasePending()
+	assert.Equal(t, "prometheus", (<-b).Target.Name)
+	assert.Empty(t, gw.PausedGroups())
+	assert.Empty(t, gw.pending)
+}
@@ -0,0 +1,47 @@
+package watcher
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// holdTimeout is how long a reconfiguration waits for the executing tasks of
+// its modified targets before it's applied regardless
+const holdTimeout = 5 * time.Minute
+
+// awaitHold holds the tasks of the targets while the watch loop keeps the
+// watchdog fed and answers state requests and checks, the hold waits for an
+// executing task to finish which may take as long as its commands do. Once the
+// hold timeout passes the reconfiguration goes ahead, the hold is then released
+// as soon as it takes effect.
+func (w *GitWatcher) awaitHold(targets []string) (release func()) {
+	held := make(chan func(), 1)
+	go func() { held <- w.hold(targets) }()
+
+	heartbeat := time.NewTicker(time.Second)
+	defer heartbeat.Stop()
+	timeout := time.NewTimer(w.holdTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case release := <-held:
+			return release
+		case <-heartbeat.C:
+			atomic.StoreInt64(&w.lastActive, time.Now().UnixNano())
+		case <-w.stateReq:
+			w.stateRes <- w.state
+		case r := <-w.check:
+			w.doCheck(r)
+		case <-timeout.C:
+			zap.L().Warn("reconfiguring targets while their tasks are still executing",
+				zap.Strings("targets", targets),
+				zap.Duration("waited", w.holdTimeout))
+			return func() {
+				go func() { (<-held)() }()
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func TestAwaitHold(t *testing.T) {
+	gw := NewGitWatcher(".test", make(chan task.ExecutionTask, 16), time.Second, nil)
+	gw.initialised = true
+	gw.state = config.State{Targets: []task.Target{{Name: "app"}}}
+	gw.holdTimeout = 100 * time.Millisecond
+
+	finished := make(chan struct{})
+	released := make(chan struct{})
+	gw.SetHold(func(targets []string) func() {
+		<-finished
+		return func() { close(released) }
+	})
+
+	done := make(chan func())
+	go func() { done <- gw.awaitHold([]string{"app"}) }()
+
+	// the state is served while the hold waits
+	assert.Equal(t, "app", gw.GetState().Targets[0].Name)
+
+	var release func()
+	select {
+	case release = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hold did not time out")
+	}
+	release()
+
+	select {
+	case <-released:
+		t.Fatal("released before the hold took effect")
+	default:
+	}
+	close(finished)
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hold was not released once it took effect")
+	}
+}
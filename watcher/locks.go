@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// minStaleLockAge is the youngest a lock file may be to be considered left
+// behind by a crash, whatever the git timeout.
+const minStaleLockAge = time.Minute * 10
+
+// staleLockAge is how old a lock or temporary file in a clone must be before
+// it's removed. Nothing but Pico writes to clones and its git operations are
+// abandoned after their timeout, so older files can't belong to a live one.
+func staleLockAge(timeout time.Duration) time.Duration {
+	if age := timeout + time.Minute; age > minStaleLockAge {
+		return age
+	}
+	return minStaleLockAge
+}
+
+// leftover reports whether a file in a clone's git directory is a lock or a
+// partially written object left behind by an interrupted git operation.
+func leftover(name string) bool {
+	return strings.HasSuffix(name, ".lock") ||
+		strings.HasPrefix(name, "tmp_pack_") ||
+		strings.HasPrefix(name, "tmp_idx_") ||
+		strings.HasPrefix(name, "tmp_obj_")
+}
+
+// removeStaleLocks removes lock and temporary files older than age from the git
+// directory of the clone at path and returns how many were removed. Each one
+// is logged with its age. Loose objects aren't walked, temporary files are
+// only written to the top of the objects directory and its pack directory.
+func removeStaleLocks(path string, age time.Duration) (removed int) {
+	gitDir := filepath.Join(path, ".git")
+	objects := filepath.Join(gitDir, "objects")
+	now := time.Now()
+	filepath.Walk(gitDir, func(p string, info os.FileInfo, err error) error { //nolint:errcheck
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if filepath.Dir(p) == objects && info.Name() != "pack" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !leftover(info.Name()) || now.Sub(info.ModTime()) < age {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			zap.L().Warn("failed to remove stale git lock file",
+				zap.String("path", p),
+				zap.Error(err))
+			return nil
+		}
+		zap.L().Warn("removed stale git lock file",
+			zap.String("path", p),
+			zap.Duration("age", now.Sub(info.ModTime()).Round(time.Second)))
+		removed++
+		return nil
+	})
+	return
+}
+
+// lockError reports whether a git operation failed because a file it creates
+// exclusively, such as index.lock, already exists.
+func lockError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) && os.IsExist(pathErr) {
+		return true
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "file exists")
+}
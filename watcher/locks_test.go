@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveStaleLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-locks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-time.Hour)
+	files := map[string]bool{ // whether the file is old
+		".git/index.lock":                 true,
+		".git/shallow.lock":               true,
+		".git/refs/heads/main.lock":       true,
+		".git/objects/pack/tmp_pack_1234": true,
+		".git/objects/tmp_obj_5678":       true,
+		".git/HEAD.lock":                  false, // too recent to be stale
+		".git/objects/ab/cdef.lock":       true,  // loose objects aren't walked
+		".git/index":                      true,
+		"index.lock":                      true, // outside the git directory
+	}
+	for name, stale := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		require.NoError(t, ioutil.WriteFile(p, nil, 0o600))
+		if stale {
+			require.NoError(t, os.Chtimes(p, old, old))
+		}
+	}
+
+	assert.Equal(t, 5, removeStaleLocks(dir, time.Minute*10))
+	for name, removed := range map[string]bool{
+		".git/index.lock":                 true,
+		".git/shallow.lock":               true,
+		".git/refs/heads/main.lock":       true,
+		".git/objects/pack/tmp_pack_1234": true,
+		".git/objects/tmp_obj_5678":       true,
+		".git/HEAD.lock":                  false,
+		".git/objects/ab/cdef.lock":       false,
+		".git/index":                      false,
+		"index.lock":                      false,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.Equal(t, removed, os.IsNotExist(err), name)
+	}
+
+	// a clone that doesn't exist yet has nothing to remove
+	assert.Equal(t, 0, removeStaleLocks(filepath.Join(dir, "missing"), time.Minute))
+}
+
+func TestStaleLockAge(t *testing.T) {
+	assert.Equal(t, minStaleLockAge, staleLockAge(0))
+	assert.Equal(t, minStaleLockAge, staleLockAge(time.Minute))
+	assert.Equal(t, time.Minute*31, staleLockAge(time.Minute*30))
+}
+
+func TestLockError(t *testing.T) {
+	assert.True(t, lockError(errors.New("fatal: Unable to create '/data/app/.git/index.lock': File exists.")))
+	assert.True(t, lockError(errors.Wrap(&os.PathError{Op: "open", Path: "shallow.lock", Err: os.ErrExist}, "failed to fetch")))
+	assert.False(t, lockError(errors.New("authentication required")))
+	assert.False(t, lockError(nil))
+}
@@ -0,0 +1,209 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/disk"
+	"github.com/picostack/pico/task"
+)
+
+// sizeInterval is how often the disk usage of target clones is measured
+const sizeInterval = time.Minute * 5
+
+// maintenance measures target clones and compacts those over a threshold
+type maintenance struct {
+	interval  time.Duration // between compaction passes, disabled when zero
+	threshold int64         // clones bigger than this are compacted
+
+	mu        sync.Mutex
+	sizes     map[string]int64
+	checkouts map[string]int64 // by checkout mode, shared clones counted once
+	measuring bool
+	measured  time.Time
+	compacted time.Time
+
+	compacting map[string]bool // clones being compacted, by path
+}
+
+// compaction is the outcome of compacting a clone, reported to the watch loop
+type compaction struct {
+	target   task.Target
+	path     string
+	before   int64
+	after    int64
+	duration time.Duration
+	skipped  bool // the clone wasn't over the threshold
+	err      error
+}
+
+func newMaintenance() *maintenance {
+	return &maintenance{sizes: make(map[string]int64), compacting: make(map[string]bool)}
+}
+
+// SetMaintenance enables compaction of target clones bigger than threshold
+// bytes, checked every interval. It must be called before Start.
+func (w *GitWatcher) SetMaintenance(interval time.Duration, threshold int64) {
+	w.maintenance.interval = interval
+	w.maintenance.threshold = threshold
+}
+
+// Sizes returns the disk usage of each target's clone in bytes, as of the
+// last measurement.
+func (w *GitWatcher) Sizes() map[string]int64 {
+	m := w.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]int64, len(m.sizes))
+	for k, v := range m.sizes {
+		out[k] = v
+	}
+	return out
+}
+
+// CheckoutSizes returns the disk usage of the clones checked out in each mode in
+// bytes, as of the last measurement, with clones shared by targets counted once.
+func (w *GitWatcher) CheckoutSizes() map[string]int64 {
+	m := w.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]int64, len(m.checkouts))
+	for k, v := range m.checkouts {
+		out[k] = v
+	}
+	return out
+}
+
+// checkMaintenance starts a measurement in the background when one is due and
+// compacts oversized clones when a compaction pass is due.
+func (w *GitWatcher) checkMaintenance(now time.Time) error {
+	m := w.maintenance
+
+	m.mu.Lock()
+	measure := !m.measuring && now.Sub(m.measured) >= sizeInterval
+	if measure {
+		m.measuring = true
+		m.measured = now
+	}
+	compact := m.interval > 0 && now.Sub(m.compacted) >= m.interval
+	if compact {
+		m.compacted = now
+	}
+	m.mu.Unlock()
+
+	if measure {
+		go w.measure(w.state.Targets)
+	}
+	if compact {
+		w.compact()
+	}
+	return nil
+}
+
+func (w *GitWatcher) measure(targets task.Targets) {
+	sizes := make(map[string]int64, len(targets))
+	checkouts := make(map[string]int64)
+	measured := make(map[string]bool)
+	for _, t := range targets {
+		path := t.Path(w.directory)
+		size, err := disk.Size(path)
+		if err != nil {
+			zap.L().Debug("failed to measure target clone", zap.String("target", t.Name), zap.Error(err))
+			continue
+		}
+		sizes[t.Name] = size
+		if !measured[path] {
+			measured[path] = true
+			checkouts[t.GetCheckout()] += size
+		}
+	}
+
+	m := w.maintenance
+	m.mu.Lock()
+	m.sizes = sizes
+	m.checkouts = checkouts
+	m.measuring = false
+	m.mu.Unlock()
+}
+
+// compact runs garbage collection on every clone over the threshold, each in
+// the background so a large clone doesn't hold up the watch loop, which
+// receives the outcome on compactions. A clone still being compacted from the
+// last pass is skipped.
+func (w *GitWatcher) compact() {
+	m := w.maintenance
+	shared := make(map[string]bool)
+	for _, t := range w.state.Targets {
+		p := w.pollers[t.Name]
+		if !t.IsEnabled() || p == nil {
+			continue
+		}
+		// a shared clone is compacted once, as the first of its targets
+		if t.Clone != "" {
+			if shared[t.Clone] {
+				continue
+			}
+			shared[t.Clone] = true
+		}
+		path := t.Path(w.directory)
+		m.mu.Lock()
+		busy := m.compacting[path]
+		m.compacting[path] = true
+		m.mu.Unlock()
+		if busy {
+			continue
+		}
+		go func(t task.Target, p *poller) { w.compactions <- w.gc(t, path, p) }(t, p)
+	}
+}
+
+// gc compacts the clone if it's over the threshold. The target's poller is
+// held for the duration, so fetches and garbage collection never operate on a
+// clone at the same time.
+func (w *GitWatcher) gc(t task.Target, path string, p *poller) compaction {
+	c := compaction{target: t, path: path}
+	size, err := disk.Size(path)
+	if err != nil || size <= w.maintenance.threshold {
+		c.skipped = true
+		return c
+	}
+	c.before = size
+	start := time.Now()
+	p.mu.Lock()
+	c.err = disk.GC(path)
+	p.mu.Unlock()
+	c.duration = time.Since(start)
+	c.after, _ = disk.Size(path) //nolint:errcheck
+	return c
+}
+
+// doCompaction records the outcome of compacting a clone
+func (w *GitWatcher) doCompaction(c compaction) {
+	m := w.maintenance
+	m.mu.Lock()
+	delete(m.compacting, c.path)
+	if !c.skipped && c.err == nil {
+		m.sizes[c.target.Name] = c.after
+	}
+	m.mu.Unlock()
+
+	switch {
+	case c.skipped:
+	case c.err != nil:
+		zap.L().Warn("failed to compact target clone",
+			zap.String("target", c.target.Name),
+			c.target.LabelsField(),
+			zap.Error(c.err))
+	default:
+		zap.L().Info("compacted target clone",
+			zap.String("target", c.target.Name),
+			c.target.LabelsField(),
+			zap.String("before", disk.FormatSize(c.before)),
+			zap.String("after", disk.FormatSize(c.after)),
+			zap.Duration("duration", c.duration))
+	}
+}
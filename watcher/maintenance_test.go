@@ -0,0 +1,62 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-maintenance")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("app"), 0600))
+	_, err = wt.Add("README")
+	require.NoError(t, err)
+	_, err = wt.Commit("initial", &git.CommitOptions{
+		Author: &object.Signature{Name: "pico", Email: "pico@localhost", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	gw := NewGitWatcher(".test", make(chan task.ExecutionTask, 16), time.Second, nil)
+	gw.state = config.State{Targets: []task.Target{{Name: "app", Directory: dir}}}
+	gw.pollers["app"] = &poller{}
+	gw.SetMaintenance(time.Hour, 0)
+
+	gw.compact()
+	// the clone is still being compacted, so it's skipped
+	gw.compact()
+
+	var c compaction
+	select {
+	case c = <-gw.compactions:
+	case <-time.After(10 * time.Second):
+		t.Fatal("clone was not compacted")
+	}
+	assert.NoError(t, c.err)
+	assert.False(t, c.skipped)
+	gw.doCompaction(c)
+	assert.Equal(t, c.after, gw.Sizes()["app"])
+	assert.Empty(t, gw.maintenance.compacting)
+
+	select {
+	case <-gw.compactions:
+		t.Fatal("clone was compacted twice at once")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,308 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+
+	"github.com/picostack/pico/task"
+)
+
+// primaryReprobeInterval is how often a target that failed over to a mirror
+// checks whether a more preferred remote is reachable again.
+const primaryReprobeInterval = time.Minute * 10
+
+// probeFunc lists the references of a remote, it's replaced in tests
+type probeFunc func(url string, auth transport.AuthMethod, timeout time.Duration) ([]*plumbing.Reference, error)
+
+// mirrors tracks which of the repository URLs of each target with mirrors is
+// in use. The primary URL is always preferred.
+type mirrors struct {
+	probe probeFunc
+
+	mu        sync.Mutex
+	active    map[string]int // target name to index into its URLs
+	lastProbe time.Time
+	failed    bool // a git error occurred since the last check
+}
+
+func newMirrors() *mirrors {
+	return &mirrors{
+		probe:  listRemote,
+		active: make(map[string]int),
+	}
+}
+
+// URL returns the repository URL in use for the target
+func (m *mirrors) URL(t task.Target) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return t.URLs()[m.active[t.Name]]
+}
+
+// choose probes the target's URLs in order of preference, starting with the
+// primary, and selects the first that responds. It returns the selected URL
+// and whether it differs from the one in use. If no URL responds, the one in
+// use is kept.
+func (m *mirrors) choose(t task.Target, auth func(url string) transport.AuthMethod, timeout time.Duration) (string, bool) {
+	urls := t.URLs()
+
+	m.mu.Lock()
+	current := m.active[t.Name]
+	m.mu.Unlock()
+	if current >= len(urls) {
+		current = 0
+	}
+
+	for i, url := range urls {
+		_, err := m.probe(url, auth(url), timeout)
+		if err != nil {
+			zap.L().Warn("target repository remote is unreachable",
+				zap.String("target", t.Name),
+				zap.String("url", url),
+				zap.Error(err))
+			continue
+		}
+
+		m.mu.Lock()
+		m.active[t.Name] = i
+		m.mu.Unlock()
+
+		if i != current {
+			zap.L().Info("switching target repository remote",
+				zap.String("target", t.Name),
+				zap.String("from", urls[current]),
+				zap.String("to", url),
+				zap.Bool("primary", i == 0))
+		}
+		return url, i != current
+	}
+
+	zap.L().Error("no target repository remote is reachable",
+		zap.String("target", t.Name),
+		zap.Strings("urls", urls))
+	return urls[current], false
+}
+
+// forget drops targets that no longer have mirrors
+func (m *mirrors) forget(targets task.Targets) {
+	keep := make(map[string]bool)
+	for _, t := range targets {
+		if len(t.Mirrors) > 0 {
+			keep[t.Name] = true
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.active {
+		if !keep[name] {
+			delete(m.active, name)
+		}
+	}
+}
+
+// fail records that a git operation failed, so remotes are probed on the
+// next check.
+func (m *mirrors) fail() {
+	m.mu.Lock()
+	m.failed = true
+	m.mu.Unlock()
+}
+
+// due reports whether remotes should be probed: after a git error or, while
+// any target uses a mirror, periodically to return to the primary.
+func (m *mirrors) due(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failed {
+		m.failed = false
+		m.lastProbe = now
+		return true
+	}
+	for _, i := range m.active {
+		if i != 0 && now.Sub(m.lastProbe) >= primaryReprobeInterval {
+			m.lastProbe = now
+			return true
+		}
+	}
+	return false
+}
+
+// checkMirrors probes the remotes of every target with mirrors and restarts
+// the targets watcher if any target switched to a different remote.
+func (w *GitWatcher) checkMirrors() error {
+	if !w.mirrors.due(time.Now()) {
+		return nil
+	}
+
+	changed := false
+	for _, t := range w.state.Targets {
+		if len(t.Mirrors) == 0 || !t.IsEnabled() {
+			continue
+		}
+		url, switched, err := w.selectRemote(t)
+		if err != nil {
+			zap.L().Error("failed to check target repository remotes",
+				zap.String("target", t.Name),
+				zap.Error(err))
+			continue
+		}
+		if switched {
+			zap.L().Debug("target remote changed", zap.String("target", t.Name), zap.String("url", url))
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return w.watchTargets()
+}
+
+// selectRemote chooses the remote for a target with mirrors and points its
+// existing checkout at it.
+func (w *GitWatcher) selectRemote(t task.Target) (string, bool, error) {
+	var authErr error
+	auth := func(url string) transport.AuthMethod {
+		a, err := w.getAuthForTarget(t, url)
+		if err != nil {
+			authErr = err
+		}
+		return a
+	}
+	url, switched := w.mirrors.choose(t, auth, t.GetGitTimeout(w.gitTimeout))
+	if authErr != nil {
+		return "", false, authErr
+	}
+
+	path := t.Path(w.directory)
+	if err := setOrigin(path, url); err != nil {
+		zap.L().Warn("failed to change target repository remote",
+			zap.String("target", t.Name),
+			zap.String("url", url),
+			zap.Error(err))
+		return url, switched, nil
+	}
+	if switched {
+		w.logSkew(t, path, url, auth(url))
+	}
+	return url, switched, nil
+}
+
+// logSkew compares the branch head of a remote with the commit checked out.
+// A checkout is never moved backwards, so when a mirror is behind, the checked
+// out commit stays deployed until the mirror catches up.
+func (w *GitWatcher) logSkew(t task.Target, path, url string, auth transport.AuthMethod) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return
+	}
+	refs, err := w.mirrors.probe(url, auth, t.GetGitTimeout(w.gitTimeout))
+	if err != nil {
+		return
+	}
+
+	hash, ok := remoteHead(refs, t.Branch)
+	if !ok || hash == head.Hash() {
+		return
+	}
+	remote, err := repo.CommitObject(hash)
+	if err != nil {
+		// unknown locally, so the remote is ahead or diverged
+		return
+	}
+	local, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return
+	}
+	if behind, err := remote.IsAncestor(local); err == nil && behind {
+		zap.L().Warn("remote is behind the deployed commit, keeping the deployed commit",
+			zap.String("target", t.Name),
+			zap.String("url", url),
+			zap.String("deployed", head.Hash().String()),
+			zap.String("remote", hash.String()))
+	}
+}
+
+// remoteHead finds the commit of a branch, or of HEAD if branch is empty, in
+// the references listed from a remote.
+func remoteHead(refs []*plumbing.Reference, branch string) (plumbing.Hash, bool) {
+	name := plumbing.HEAD
+	if branch != "" {
+		name = plumbing.NewBranchReferenceName(branch)
+	}
+	for i := 0; i < 2; i++ {
+		var next plumbing.ReferenceName
+		for _, ref := range refs {
+			if ref.Name() != name {
+				continue
+			}
+			if ref.Type() == plumbing.HashReference {
+				return ref.Hash(), true
+			}
+			next = ref.Target()
+		}
+		if next == "" {
+			break
+		}
+		// HEAD is usually listed as a symbolic reference to the default branch
+		name = next
+	}
+	return plumbing.ZeroHash, false
+}
+
+// setOrigin points the origin remote of an existing checkout at url, a missing
+// checkout is cloned from the right remote anyway.
+func setOrigin(path, url string) error {
+	repo, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to open repository")
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return errors.Wrap(err, "failed to read repository config")
+	}
+	origin, ok := cfg.Remotes[git.DefaultRemoteName]
+	if !ok {
+		return errors.New("repository has no origin remote")
+	}
+	if len(origin.URLs) == 1 && origin.URLs[0] == url {
+		return nil
+	}
+	origin.URLs = []string{url}
+	return errors.Wrap(repo.Storer.SetConfig(cfg), "failed to write repository config")
+}
+
+// originURL returns the URL of the origin remote of an existing checkout, or
+// empty if it can't be read.
+func originURL(path string) string {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return ""
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return ""
+	}
+	origin, ok := cfg.Remotes[git.DefaultRemoteName]
+	if !ok || len(origin.URLs) == 0 {
+		return ""
+	}
+	return origin.URLs[0]
+}
+
+func listRemote(url string, auth transport.AuthMethod, timeout time.Duration) ([]*plumbing.Reference, error) {
+	return ListRemote(context.Background(), timeout, url, auth)
+}
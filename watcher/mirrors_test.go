@@ -0,0 +1,117 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/src-d/go-git.v4"
+	gitconfig "gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+
+	"github.com/picostack/pico/task"
+)
+
+func fakeProbe(down map[string]bool) probeFunc {
+	return func(url string, auth transport.AuthMethod, timeout time.Duration) ([]*plumbing.Reference, error) {
+		if down[url] {
+			return nil, errors.New("unreachable")
+		}
+		return nil, nil
+	}
+}
+
+func TestMirrorsChoose(t *testing.T) {
+	down := map[string]bool{}
+	m := newMirrors()
+	m.probe = fakeProbe(down)
+	noAuth := func(string) transport.AuthMethod { return nil }
+
+	target := task.Target{Name: "app", RepoURL: "https://primary/app", Mirrors: []string{"https://mirror1/app", "https://mirror2/app"}}
+
+	url, switched := m.choose(target, noAuth, time.Minute)
+	assert.Equal(t, "https://primary/app", url)
+	assert.False(t, switched)
+
+	down["https://primary/app"] = true
+	down["https://mirror1/app"] = true
+	url, switched = m.choose(target, noAuth, time.Minute)
+	assert.Equal(t, "https://mirror2/app", url)
+	assert.True(t, switched)
+	assert.Equal(t, "https://mirror2/app", m.URL(target))
+
+	// all down keeps the current remote
+	down["https://mirror2/app"] = true
+	url, switched = m.choose(target, noAuth, time.Minute)
+	assert.Equal(t, "https://mirror2/app", url)
+	assert.False(t, switched)
+
+	// the primary is preferred once it's back
+	down["https://primary/app"] = false
+	url, switched = m.choose(target, noAuth, time.Minute)
+	assert.Equal(t, "https://primary/app", url)
+	assert.True(t, switched)
+
+	m.forget(nil)
+	assert.Empty(t, m.active)
+}
+
+func TestMirrorsDue(t *testing.T) {
+	m := newMirrors()
+	now := time.Now()
+	assert.False(t, m.due(now))
+
+	m.fail()
+	assert.True(t, m.due(now))
+	assert.False(t, m.due(now))
+
+	m.active["app"] = 1
+	assert.False(t, m.due(now.Add(time.Minute)))
+	assert.True(t, m.due(now.Add(primaryReprobeInterval)))
+}
+
+func TestSetOrigin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-mirror")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, setOrigin(dir+"/missing", "https://mirror/app"))
+
+	repo, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{"https://primary/app"}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, setOrigin(dir, "https://mirror/app"))
+
+	repo, err = git.PlainOpen(dir)
+	assert.NoError(t, err)
+	remote, err := repo.Remote("origin")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://mirror/app"}, remote.Config().URLs)
+}
+
+func TestRemoteHead(t *testing.T) {
+	main := plumbing.NewHash("1111111111111111111111111111111111111111")
+	dev := plumbing.NewHash("2222222222222222222222222222222222222222")
+	refs := []*plumbing.Reference{
+		plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main"),
+		plumbing.NewHashReference("refs/heads/main", main),
+		plumbing.NewHashReference("refs/heads/dev", dev),
+	}
+
+	hash, ok := remoteHead(refs, "")
+	assert.True(t, ok)
+	assert.Equal(t, main, hash)
+
+	hash, ok = remoteHead(refs, "dev")
+	assert.True(t, ok)
+	assert.Equal(t, dev, hash)
+
+	_, ok = remoteHead(refs, "missing")
+	assert.False(t, ok)
+}
@@ -0,0 +1,259 @@
+package watcher
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Southclaws/gitwatch"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+
+	"github.com/picostack/pico/task"
+)
+
+// check is the outcome of fetching a target's repository once
+type check struct {
+	target string
+	time   time.Time
+	event  *gitwatch.Event // set if the fetch brought in new commits
+	err    error
+	// the commit checked out before the fetch, empty for a new clone
+	previous string
+	cause    task.Trigger // TriggerWebhook if a webhook asked for the fetch
+	detail   string       // the webhook delivery, if known
+}
+
+// poller periodically fetches a single clone, so the outcome of every fetch can
+// be attributed to its target. Targets sharing a clone share its poller, each
+// fetch is reported to all of them.
+type poller struct {
+	targets []string
+	url     string
+	branch  string
+	path    string
+	auth    transport.AuthMethod
+	// how long a clone or fetch may take before it's abandoned
+	timeout time.Duration
+	// one of the task.Checkout constants and, for sparse checkouts, the paths
+	// kept in the working tree
+	checkout string
+	paths    []string
+	synced   bool // whether the sparse working tree has been written since starting
+	// the remote commit last reported as behind or diverged from the checkout
+	diverged string
+
+	mu     sync.Mutex // held during fetches and maintenance of the clone
+	cancel context.CancelFunc
+	done   chan struct{}
+	now    chan string // fetches without waiting for the interval, with the webhook delivery
+	space  func(targets []string) error
+}
+
+// fetch clones the repository if it doesn't exist yet, otherwise it pulls and
+// returns an event if there were new commits along with the commit that was
+// checked out before. A fetch blocked by lock files left behind by a crash is
+// retried once they're removed. Nothing is fetched while the space check fails.
+func (p *poller) fetch(ctx context.Context) (*gitwatch.Event, string, error) {
+	if p.space != nil {
+		if err := p.space(p.targets); err != nil {
+			return nil, "", err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	event, previous, err := p.pull(ctx)
+	if lockError(err) && removeStaleLocks(p.path, staleLockAge(p.timeout)) > 0 {
+		zap.L().Info("retrying fetch after removing stale lock files",
+			zap.Strings("targets", p.targets))
+		event, previous, err = p.pull(ctx)
+	}
+	return event, previous, err
+}
+
+// removeStaleLocks removes lock files left in the clone by a crashed fetch,
+// before the first fetch of the clone.
+func (p *poller) removeStaleLocks() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	removeStaleLocks(p.path, staleLockAge(p.timeout))
+}
+
+func (p *poller) pull(ctx context.Context) (*gitwatch.Event, string, error) {
+	full := p.checkout == task.CheckoutFull
+	repo, err := git.PlainOpen(p.path)
+	if err == nil && task.IsBare(p.path) == full {
+		// the checkout mode changed, the clone is replaced by one of the new mode
+		zap.L().Info("recloning repository for a different checkout",
+			zap.Strings("targets", p.targets),
+			zap.String("checkout", p.checkout))
+		if err := os.RemoveAll(p.path); err != nil {
+			return nil, "", errors.Wrap(err, "failed to remove clone of the previous checkout")
+		}
+		err = git.ErrRepositoryNotExists
+	}
+	// the commit of a clone replaced because its remote couldn't be changed
+	var replaced string
+	if err == nil && !p.updateOrigin() {
+		replaced = task.HeadCommit(p.path)
+		if err := os.RemoveAll(p.path); err != nil {
+			return nil, "", errors.Wrap(err, "failed to remove clone of the previous remote")
+		}
+		err = git.ErrRepositoryNotExists
+	}
+	if err == git.ErrRepositoryNotExists {
+		if full {
+			err = Clone(ctx, p.timeout, p.path, p.url, p.branch, p.auth)
+		} else {
+			err = CloneBare(ctx, p.timeout, p.path, p.url, p.branch, p.auth)
+		}
+		if err != nil {
+			return nil, replaced, gitError(p.url, err)
+		}
+		if err := p.sync(); err != nil {
+			return nil, replaced, err
+		}
+		if replaced == "" || task.HeadCommit(p.path) == replaced {
+			return nil, replaced, nil
+		}
+		// a clone of the new remote is only deployed if its head differs
+		event, err := headEvent(p.path, p.url)
+		return event, replaced, err
+	} else if err != nil {
+		return nil, "", errors.Wrap(err, "failed to open local repo")
+	}
+
+	previous := task.HeadCommit(p.path)
+	var event *gitwatch.Event
+	if full {
+		event, err = Pull(ctx, p.timeout, repo, p.url, p.branch, p.auth)
+	} else {
+		event, err = FetchBare(ctx, p.timeout, repo, p.path, p.url, p.branch, p.auth)
+	}
+	if errors.Is(err, io.EOF) {
+		// an empty response from the remote, nothing changed
+		return nil, previous, nil
+	} else if errors.Is(err, git.ErrNonFastForwardUpdate) {
+		// the remote is behind or has diverged from the checkout, such as a
+		// mirror that hasn't caught up. The checkout is never moved backwards,
+		// so the deployed commit is kept until the remote moves past it.
+		p.reportDiverged(repo)
+		return nil, previous, nil
+	} else if err != nil {
+		return nil, previous, gitError(p.url, err)
+	}
+	p.diverged = ""
+	if event != nil || !p.synced {
+		if err := p.sync(); err != nil {
+			return nil, previous, err
+		}
+	}
+	return event, previous, nil
+}
+
+// reportDiverged warns that the remote's branch can't be fast-forwarded to,
+// once for each commit the remote is at so every poll doesn't repeat it.
+func (p *poller) reportDiverged(repo *git.Repository) {
+	head, err := repo.Head()
+	if err != nil {
+		return
+	}
+	remote, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, head.Name().Short()), true)
+	if err != nil || remote.Hash().String() == p.diverged {
+		return
+	}
+	p.diverged = remote.Hash().String()
+	zap.L().Warn("remote is behind or has diverged from the deployed commit, keeping the deployed commit",
+		zap.Strings("targets", p.targets),
+		zap.String("url", p.url),
+		zap.String("deployed", head.Hash().String()),
+		zap.String("remote", p.diverged))
+}
+
+// updateOrigin points the origin remote of the clone at the poller's URL if the
+// target's URL changed since it was cloned, such as when a repository moves to
+// another host. The objects already fetched are kept, so the next fetch only
+// brings in what's new. It reports false if the remote couldn't be changed and
+// the clone must be replaced instead.
+func (p *poller) updateOrigin() bool {
+	previous := originURL(p.path)
+	if previous == "" || previous == p.url {
+		return true
+	}
+	if err := setOrigin(p.path, p.url); err != nil {
+		zap.L().Warn("failed to change repository remote, recloning",
+			zap.Strings("targets", p.targets),
+			zap.String("from", previous),
+			zap.String("to", p.url),
+			zap.Error(err))
+		return false
+	}
+	zap.L().Info("changed repository remote",
+		zap.Strings("targets", p.targets),
+		zap.String("from", previous),
+		zap.String("to", p.url))
+	return true
+}
+
+// sync writes the files of a sparse checkout's paths at the fetched commit to
+// its working tree, removing any that are no longer within them, so in-place
+// tasks see the same files as they would in a full checkout.
+func (p *poller) sync() error {
+	if p.checkout != task.CheckoutSparse {
+		return nil
+	}
+	if err := task.SyncTree(p.path, task.HeadCommit(p.path), p.paths); err != nil {
+		return errors.Wrap(err, "failed to write sparse checkout")
+	}
+	p.synced = true
+	return nil
+}
+
+// run fetches on every interval and reports each outcome until stopped
+func (p *poller) run(ctx context.Context, interval time.Duration, checks chan<- check) {
+	defer close(p.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		cause, detail := task.TriggerChange, ""
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case detail = <-p.now:
+			cause = task.TriggerWebhook
+		}
+
+		event, previous, err := p.fetch(ctx)
+		now := time.Now()
+		for _, target := range p.targets {
+			select {
+			case checks <- check{target: target, time: now, event: event, err: err, cause: cause, detail: detail, previous: previous}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// poke makes the poller fetch now, a fetch already requested is not repeated
+func (p *poller) poke(delivery string) {
+	select {
+	case p.now <- delivery:
+	default:
+	}
+}
+
+// stop ends the poller and waits for a fetch in progress to finish
+func (p *poller) stop() {
+	p.cancel()
+	<-p.done
+}
@@ -0,0 +1,25 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestCheckPokesPoller(t *testing.T) {
+	gw := NewGitWatcher(".test", make(chan task.ExecutionTask), time.Second, nil)
+	p := &poller{targets: []string{"app"}, now: make(chan string, 1)}
+	gw.pollers["app"] = p
+
+	gw.doCheck(checkRequest{"app", "first"})
+	gw.doCheck(checkRequest{"app", "second"})
+	gw.doCheck(checkRequest{"unknown", ""})
+
+	// repeated checks before the poller wakes up result in a single fetch,
+	// attributed to the first delivery
+	assert.Len(t, p.now, 1)
+	assert.Equal(t, "first", <-p.now)
+}
@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// SetLastDeploy sets how the time of a target's last successful deploy is
+// found, which min_deploy_interval is measured from. It must be called before
+// Start, targets are never rate limited without it.
+func (w *GitWatcher) SetLastDeploy(f func(target string) time.Time) {
+	w.lastDeploy = f
+}
+
+// limited reports whether a change of the target arrived too soon after its
+// last successful deploy. If it did, the change is held and deployed with the
+// newest commit once the interval has passed.
+func (w *GitWatcher) limited(t task.Target, now time.Time) bool {
+	if t.MinDeployInterval <= 0 || w.lastDeploy == nil {
+		return false
+	}
+	last := w.lastDeploy(t.Name)
+	if last.IsZero() {
+		return false
+	}
+	until := last.Add(time.Duration(t.MinDeployInterval))
+	if !now.Before(until) {
+		return false
+	}
+
+	w.limitMu.Lock()
+	_, ok := w.limitedUntil[t.Name]
+	w.limitedUntil[t.Name] = until
+	w.limitMu.Unlock()
+
+	if !ok {
+		zap.L().Info("rate limiting target change",
+			zap.String("target", t.Name),
+			t.LabelsField(),
+			zap.Time("until", until))
+	}
+	return true
+}
+
+// flushLimited deploys the held changes of targets whose interval has passed
+func (w *GitWatcher) flushLimited(now time.Time) {
+	var due []string
+	w.limitMu.Lock()
+	for name, until := range w.limitedUntil {
+		if !now.Before(until) {
+			due = append(due, name)
+			delete(w.limitedUntil, name)
+		}
+	}
+	w.limitMu.Unlock()
+
+	for _, name := range due {
+		t, ok := w.getTargetByName(name)
+		if !ok || w.held(t) {
+			continue
+		}
+		zap.L().Info("deploying rate limited target", zap.String("target", name), t.LabelsField())
+		w.__waitpoint__send_target_task(t, t.Path(w.directory), false, task.TriggerChange, "held by min_deploy_interval")
+	}
+}
+
+func (w *GitWatcher) clearLimited(name string) {
+	w.limitMu.Lock()
+	delete(w.limitedUntil, name)
+	w.limitMu.Unlock()
+}
+
+// RateLimited returns when the held changes of rate limited targets are due
+func (w *GitWatcher) RateLimited() map[string]time.Time {
+	w.limitMu.Lock()
+	defer w.limitMu.Unlock()
+
+	out := make(map[string]time.Time, len(w.limitedUntil))
+	for name, until := range w.limitedUntil {
+		out[name] = until
+	}
+	return out
+}
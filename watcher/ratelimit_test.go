@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func TestRateLimit(t *testing.T) {
+	b := make(chan task.ExecutionTask, 16)
+	gw := NewGitWatcher(".test", b, time.Second, nil)
+	deployed := time.Now().Add(-time.Minute)
+	gw.SetLastDeploy(func(target string) time.Time {
+		if target == "new" {
+			return time.Time{}
+		}
+		return deployed
+	})
+	gw.state = config.State{Targets: []task.Target{
+		{Name: "db", MinDeployInterval: task.Duration(10 * time.Minute)},
+		{Name: "api", MinDeployInterval: task.Duration(30 * time.Second)},
+		{Name: "new", MinDeployInterval: task.Duration(10 * time.Minute)},
+		{Name: "app"},
+	}}
+
+	now := time.Now()
+	for _, tt := range []struct {
+		name    string
+		limited bool
+	}{
+		{"db", true},
+		{"api", false},
+		{"new", false},
+		{"app", false},
+	} {
+		target, _ := gw.getTargetByName(tt.name)
+		assert.Equal(t, tt.limited, gw.limited(target, now), tt.name)
+	}
+
+	until := deployed.Add(10 * time.Minute)
+	assert.Equal(t, map[string]time.Time{"db": until}, gw.RateLimited())
+
+	gw.flushLimited(until.Add(-time.Second))
+	assert.Empty(t, b)
+
+	gw.flushLimited(until)
+	got := <-b
+	assert.Equal(t, "db", got.Target.Name)
+	assert.Equal(t, "change", string(got.Trigger))
+	assert.Empty(t, gw.RateLimited())
+}
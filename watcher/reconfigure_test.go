@@ -0,0 +1,23 @@
+package watcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestModifiedTargets(t *testing.T) {
+	old := []task.Target{
+		{Name: "app", Branch: "main"},
+		{Name: "db"},
+		{Name: "removed"},
+	}
+	additions, _ := task.DiffTargets(old, []task.Target{
+		{Name: "app", Branch: "dev"},
+		{Name: "db"},
+		{Name: "new"},
+	})
+	assert.Equal(t, []string{"app"}, modifiedTargets(old, additions))
+}
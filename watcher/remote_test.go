@@ -0,0 +1,120 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestRemoteChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-remote")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "old")
+	repo, err := git.PlainInit(old, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(old, "compose.yml"), []byte("v1"), 0o600))
+	_, err = wt.Add("compose.yml")
+	require.NoError(t, err)
+	_, err = wt.Commit("v1", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+
+	// the repository moves to a new host with its history
+	moved := filepath.Join(dir, "moved")
+	_, err = git.PlainClone(moved, false, &git.CloneOptions{URL: old})
+	require.NoError(t, err)
+
+	for _, checkout := range []string{task.CheckoutFull, task.CheckoutArchive} {
+		t.Run(checkout, func(t *testing.T) {
+			p := &poller{targets: []string{"app"}, url: old, path: filepath.Join(dir, checkout), checkout: checkout}
+			_, _, err := p.fetch(context.Background())
+			require.NoError(t, err)
+			head := task.HeadCommit(p.path)
+
+			// the same head on the new remote isn't deployed again
+			p.url = moved
+			event, previous, err := p.fetch(context.Background())
+			require.NoError(t, err)
+			assert.Nil(t, event)
+			assert.Equal(t, head, previous)
+			assert.Equal(t, moved, originURL(p.path))
+		})
+	}
+
+	// new commits are fetched from the new remote
+	mrepo, err := git.PlainOpen(moved)
+	require.NoError(t, err)
+	mwt, err := mrepo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(moved, "compose.yml"), []byte("v2"), 0o600))
+	_, err = mwt.Add("compose.yml")
+	require.NoError(t, err)
+	h, err := mwt.Commit("v2", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+
+	p := &poller{targets: []string{"app"}, url: moved, path: filepath.Join(dir, task.CheckoutFull), checkout: task.CheckoutFull}
+	event, _, err := p.fetch(context.Background())
+	require.NoError(t, err)
+	assert.NotNil(t, event)
+	assert.Equal(t, h.String(), task.HeadCommit(p.path))
+}
+
+func TestRemoteBehind(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	dir, err := ioutil.TempDir("", "pico-remote")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	primary := filepath.Join(dir, "primary")
+	repo, err := git.PlainInit(primary, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	var commits []plumbing.Hash
+	for _, v := range []string{"v1", "v2"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(primary, "compose.yml"), []byte(v), 0o600))
+		_, err = wt.Add("compose.yml")
+		require.NoError(t, err)
+		h, err := wt.Commit(v, &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+		require.NoError(t, err)
+		commits = append(commits, h)
+	}
+
+	// the mirror hasn't caught up with the primary's last commit
+	mirror := filepath.Join(dir, "mirror")
+	mrepo, err := git.PlainClone(mirror, true, &git.CloneOptions{URL: primary})
+	require.NoError(t, err)
+	require.NoError(t, mrepo.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, commits[0])))
+
+	p := &poller{targets: []string{"app"}, url: primary, path: filepath.Join(dir, "app"), checkout: task.CheckoutFull}
+	_, _, err = p.fetch(context.Background())
+	require.NoError(t, err)
+
+	p.url = mirror
+	for i := 0; i < 2; i++ {
+		event, previous, err := p.fetch(context.Background())
+		require.NoError(t, err)
+		assert.Nil(t, event)
+		assert.Equal(t, commits[1].String(), previous)
+	}
+	assert.Equal(t, commits[1].String(), task.HeadCommit(p.path))
+	assert.Equal(t, 1, logs.FilterMessage("remote is behind or has diverged from the deployed commit, keeping the deployed commit").Len())
+}
@@ -0,0 +1,59 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func TestSharedClones(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-shared-clones")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	repo, err := git.PlainInit(source, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(source, "file"), []byte("one"), 0o600))
+	_, err = wt.Add("file")
+	require.NoError(t, err)
+	_, err = wt.Commit("one", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	require.NoError(t, err)
+
+	targets := []task.Target{
+		{Name: "web", RepoURL: source, Up: []string{"true"}},
+		{Name: "worker", RepoURL: source, Up: []string{"true"}},
+		{Name: "other", RepoURL: source, Branch: "other", Up: []string{"true"}},
+	}
+	task.ShareClones(targets)
+
+	gw := NewGitWatcher(filepath.Join(dir, "targets"), make(chan task.ExecutionTask, 4), time.Hour, nil)
+	gw.state = config.State{Targets: targets}
+	require.NoError(t, gw.watchTargets())
+	defer gw.stopTargets()
+
+	// one poller fetches the shared clone for both targets
+	assert.Len(t, gw.clonePollers(), 2)
+	assert.Equal(t, gw.pollers["web"], gw.pollers["worker"])
+	assert.Equal(t, []string{"web", "worker"}, gw.pollers["web"].targets)
+	assert.Equal(t, targets[0].Path(gw.directory), targets[1].Path(gw.directory))
+	_, err = git.PlainOpen(targets[0].Path(gw.directory))
+	assert.NoError(t, err)
+
+	// and each of them has the outcome recorded
+	states := gw.State()
+	assert.Contains(t, states, "web")
+	assert.Contains(t, states, "worker")
+}
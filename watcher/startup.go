@@ -0,0 +1,33 @@
+package watcher
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// executeStartup queues the tasks of the first state as a single plan, in
+// task.StartupOrder, so targets are deployed after a cold start in the same
+// order every time rather than in the order their clones became ready.
+func (w *GitWatcher) executeStartup(targets []task.Target) {
+	var included []task.Target
+	for _, t := range targets {
+		if !t.IsEnabled() || w.held(t) || w.awaitingApproval(t, t.Path(w.directory), task.TriggerStartup) {
+			continue
+		}
+		included = append(included, t)
+	}
+	ordered := task.StartupOrder(included)
+
+	plan := &task.Plan{Targets: make([]string, len(ordered))}
+	for i, t := range ordered {
+		plan.Targets[i] = t.Name
+	}
+	zap.L().Info("planned cold start", zap.Strings("order", plan.Targets))
+
+	for _, t := range ordered {
+		et := w.newTask(t, t.Path(w.directory), false, task.TriggerStartup, "")
+		et.Plan = plan
+		w.bus <- et
+	}
+}
@@ -0,0 +1,41 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestExecuteStartup(t *testing.T) {
+	b := make(chan task.ExecutionTask, 16)
+	gw := NewGitWatcher(".test", b, time.Second, nil)
+	gw.PauseGroup("monitoring")
+	disabled := false
+
+	gw.executeStartup([]task.Target{
+		{Name: "app", DependsOn: []string{"network"}, Priority: 10},
+		{Name: "grafana", Group: "monitoring"},
+		{Name: "network"},
+		{Name: "old", Enabled: &disabled},
+		{Name: "proxy", Priority: 5},
+	})
+	close(b)
+
+	var order []string
+	var plan *task.Plan
+	for et := range b {
+		order = append(order, et.Target.Name)
+		assert.Equal(t, task.TriggerStartup, et.Trigger)
+		assert.NotNil(t, et.Plan)
+		if plan != nil {
+			assert.True(t, plan == et.Plan, "every task is part of the same plan")
+		}
+		plan = et.Plan
+	}
+	assert.Equal(t, []string{"proxy", "network", "app"}, order)
+	assert.Equal(t, order, plan.Targets)
+	assert.Equal(t, map[string]bool{"grafana": true}, gw.pending)
+}
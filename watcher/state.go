@@ -0,0 +1,84 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/picostack/pico/task"
+)
+
+// TargetState describes the outcome of the most recent fetches of a target's
+// repository. LastError is empty once a fetch has succeeded again.
+type TargetState struct {
+	LastCheck           time.Time `json:"last_check"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// fetchStates holds the state of each target, it's written by the watch loop
+// and may be read from anywhere.
+type fetchStates struct {
+	mu     sync.RWMutex
+	states map[string]TargetState
+}
+
+func newFetchStates() *fetchStates {
+	return &fetchStates{states: make(map[string]TargetState)}
+}
+
+// record updates the state of the target checked by c and reports whether the
+// target recovered from previous failures.
+func (f *fetchStates) record(c check) (recovered bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.states[c.target]
+	s.LastCheck = c.time
+	if c.err != nil {
+		s.LastError = c.err.Error()
+		s.ConsecutiveFailures++
+	} else {
+		recovered = s.ConsecutiveFailures > 0
+		s.LastSuccess = c.time
+		s.LastError = ""
+		s.ConsecutiveFailures = 0
+	}
+	f.states[c.target] = s
+	return
+}
+
+// forget removes the state of targets that no longer exist or are disabled.
+func (f *fetchStates) forget(targets task.Targets) {
+	watched := make(map[string]bool)
+	for _, t := range targets {
+		if t.IsEnabled() {
+			watched[t.Name] = true
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name := range f.states {
+		if !watched[name] {
+			delete(f.states, name)
+		}
+	}
+}
+
+func (f *fetchStates) copy() map[string]TargetState {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	out := make(map[string]TargetState, len(f.states))
+	for name, s := range f.states {
+		out[name] = s
+	}
+	return out
+}
+
+// State returns the fetch state of every watched target, keyed by name. It is
+// safe to call concurrently with the watch loop.
+func (w *GitWatcher) State() map[string]TargetState {
+	return w.fetches.copy()
+}
@@ -0,0 +1,45 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/task"
+)
+
+func TestFetchStates(t *testing.T) {
+	f := newFetchStates()
+	t0 := time.Unix(1000, 0)
+
+	assert.False(t, f.record(check{target: "a", time: t0}))
+	assert.False(t, f.record(check{target: "a", time: t0.Add(time.Minute), err: errors.New("failed to pull local repo")}))
+	assert.False(t, f.record(check{target: "a", time: t0.Add(2 * time.Minute), err: errors.New("failed to pull local repo")}))
+	assert.Equal(t, TargetState{
+		LastCheck:           t0.Add(2 * time.Minute),
+		LastSuccess:         t0,
+		LastError:           "failed to pull local repo",
+		ConsecutiveFailures: 2,
+	}, f.copy()["a"])
+
+	assert.True(t, f.record(check{target: "a", time: t0.Add(3 * time.Minute)}))
+	assert.Equal(t, TargetState{
+		LastCheck:   t0.Add(3 * time.Minute),
+		LastSuccess: t0.Add(3 * time.Minute),
+	}, f.copy()["a"])
+
+	f.record(check{target: "b", time: t0})
+	f.record(check{target: "c", time: t0})
+	disabled := false
+	f.forget(task.Targets{{Name: "a"}, {Name: "c", Enabled: &disabled}})
+	assert.Equal(t, []string{"a"}, keys(f.copy()))
+}
+
+func keys(m map[string]TargetState) (out []string) {
+	for k := range m {
+		out = append(out, k)
+	}
+	return
+}
@@ -0,0 +1,35 @@
+package watcher
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/picostack/pico/task"
+)
+
+// outsideSubpath reports whether the commits a check brought in leave the
+// subpath of its target untouched, as well as its env files and template
+// sources which may be outside of it, so they aren't deployed. Changes that
+// can't be compared, such as those of a new clone, are deployed.
+func (w *GitWatcher) outsideSubpath(c check) bool {
+	target, ok := w.getTargetByName(c.target)
+	if !ok || target.Subpath == "" || c.previous == "" {
+		return false
+	}
+	head := task.HeadCommit(c.event.Path)
+	changed, err := task.ChangedWithin(c.event.Path, c.previous, head, target.SubpathPaths()...)
+	if err != nil {
+		zap.L().Warn("failed to compare changes with target subpath, deploying them",
+			zap.String("target", target.Name),
+			zap.String("subpath", target.Subpath),
+			zap.Error(err))
+		return false
+	}
+	if !changed {
+		zap.L().Info("ignoring change outside of target subpath",
+			zap.String("target", target.Name),
+			target.LabelsField(),
+			zap.String("subpath", target.Subpath),
+			zap.String("commit", head))
+	}
+	return !changed
+}
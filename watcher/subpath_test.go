@@ -0,0 +1,61 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Southclaws/gitwatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func TestSubpathChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pico-subpath")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(file string) string {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0o700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(file), 0o600))
+		_, err := wt.Add(file)
+		require.NoError(t, err)
+		hash, err := wt.Commit(file, &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+		require.NoError(t, err)
+		return hash.String()
+	}
+
+	gw := NewGitWatcher(".test", make(chan task.ExecutionTask, 1), time.Second, nil)
+	gw.state = config.State{Targets: []task.Target{
+		{Name: "edge", Directory: dir, Subpath: "deployments/edge", EnvFile: []string{"env/edge.env"}},
+		{Name: "all", Directory: dir},
+	}}
+	event := &gitwatch.Event{Path: dir}
+
+	first := commit("deployments/edge/docker-compose.yml")
+	second := commit("services/api/main.go")
+	assert.True(t, gw.outsideSubpath(check{target: "edge", event: event, previous: first}))
+	assert.False(t, gw.outsideSubpath(check{target: "all", event: event, previous: first}))
+
+	third := commit("deployments/edge/.env")
+	assert.False(t, gw.outsideSubpath(check{target: "edge", event: event, previous: second}))
+
+	// the env file is read from outside of the subpath
+	fourth := commit("env/edge.env")
+	assert.False(t, gw.outsideSubpath(check{target: "edge", event: event, previous: third}))
+	commit("env/other.env")
+	assert.True(t, gw.outsideSubpath(check{target: "edge", event: event, previous: fourth}))
+	// the first clone has nothing to compare with
+	assert.False(t, gw.outsideSubpath(check{target: "edge", event: event}))
+}
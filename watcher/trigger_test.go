@@ -0,0 +1,97 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Southclaws/gitwatch"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/picostack/pico/config"
+	"github.com/picostack/pico/task"
+)
+
+func TestTriggers(t *testing.T) {
+	fetched := func(gw *GitWatcher, cause task.Trigger, detail string) {
+		gw.handleCheck(check{
+			target: "app",
+			time:   time.Now(),
+			event:  &gitwatch.Event{Path: gw.state.Targets[0].Path(gw.directory)},
+			cause:  cause,
+			detail: detail,
+		})
+	}
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		target     task.Target
+		emit       func(gw *GitWatcher)
+		wantCause  task.Trigger
+		wantDetail string
+	}{
+		{"poll", task.Target{}, func(gw *GitWatcher) {
+			fetched(gw, task.TriggerChange, "")
+		}, task.TriggerChange, ""},
+		{"webhook", task.Target{}, func(gw *GitWatcher) {
+			fetched(gw, task.TriggerWebhook, "delivery-1")
+		}, task.TriggerWebhook, "delivery-1"},
+		{"debounced webhook", task.Target{Debounce: task.Duration(time.Minute)}, func(gw *GitWatcher) {
+			fetched(gw, task.TriggerWebhook, "delivery-1")
+			fetched(gw, task.TriggerChange, "")
+			gw.flushDebounced(later)
+		}, task.TriggerWebhook, "delivery-1"},
+		{"manual", task.Target{}, func(gw *GitWatcher) {
+			gw.Trigger("app", true, "alice")
+			gw.doTrigger(<-gw.trigger)
+		}, task.TriggerManual, "alice"},
+		{"debounced manual", task.Target{Debounce: task.Duration(time.Minute)}, func(gw *GitWatcher) {
+			gw.Trigger("app", false, "alice")
+			gw.doTrigger(<-gw.trigger)
+			gw.flushDebounced(later)
+		}, task.TriggerManual, "alice"},
+		{"redeploy", task.Target{}, func(gw *GitWatcher) {
+			gw.Redeploy("app", "secrets changed")
+			gw.doRedeploy(<-gw.redeploy)
+		}, task.TriggerRedeploy, "secrets changed"},
+		{"config", task.Target{}, func(gw *GitWatcher) {
+			gw.executeTargets(gw.state.Targets, false)
+		}, task.TriggerConfig, ""},
+		{"startup", task.Target{}, func(gw *GitWatcher) {
+			gw.executeStartup(gw.state.Targets)
+		}, task.TriggerStartup, ""},
+		{"paused group", task.Target{Group: "apps"}, func(gw *GitWatcher) {
+			gw.PauseGroup("apps")
+			fetched(gw, task.TriggerChange, "")
+			gw.ResumeGroup("apps")
+			<-gw.resume
+			gw.releasePending()
+		}, task.TriggerChange, "released from paused group"},
+		{"rate limited", task.Target{MinDeployInterval: task.Duration(time.Minute)}, func(gw *GitWatcher) {
+			gw.SetLastDeploy(func(string) time.Time { return time.Now() })
+			fetched(gw, task.TriggerChange, "")
+			gw.flushLimited(later)
+		}, task.TriggerChange, "held by min_deploy_interval"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make(chan task.ExecutionTask, 16)
+			gw := NewGitWatcher(".test", b, time.Second, nil)
+			gw.initialised = true
+			tt.target.Name = "app"
+			gw.state = config.State{Targets: []task.Target{tt.target}}
+
+			tt.emit(gw)
+			close(b)
+
+			var got []task.ExecutionTask
+			for et := range b {
+				got = append(got, et)
+			}
+			if assert.Len(t, got, 1) {
+				assert.Equal(t, tt.wantCause, got[0].Trigger)
+				assert.Equal(t, tt.wantDetail, got[0].Detail)
+			}
+		})
+	}
+}